@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
+)
+
+// PurgePage evicts the page cached for uri, a request URI such as
+// "/products?page=2", along with the variants cached per Vary header when
+// the store is a persistence.PrefixStore. Pages cached with a custom KeyFunc
+// must be purged by key.
+func PurgePage(store persistence.CacheStore, uri string) error {
+	key := CreateKey(uri)
+	if err := persistence.DeleteQuiet(store, key, key+varySuffix); err != nil {
+		return err
+	}
+	if prefixStore, ok := store.(persistence.PrefixStore); ok {
+		return prefixStore.DeletePrefix(key + "|")
+	}
+	return nil
+}
+
+// PurgePrefix evicts the items whose key starts with prefix. It returns
+// persistence.ErrNotSupport if the store is not a persistence.PrefixStore.
+func PurgePrefix(store persistence.CacheStore, prefix string) error {
+	prefixStore, ok := store.(persistence.PrefixStore)
+	if !ok {
+		return persistence.ErrNotSupport
+	}
+	return prefixStore.DeletePrefix(prefix)
+}
+
+// PurgePattern evicts the pages cached for the request URIs matching
+// uriPattern, in which * matches any sequence of characters, slashes
+// included: "/api/v1/products/*" purges every page under /api/v1/products/,
+// and "/search?q=*" every search. Other characters are matched literally. The
+// variants cached per Vary header are purged along. It returns
+// persistence.ErrNotSupport if the store is not a persistence.PatternStore.
+//
+// URIs longer than 200 characters once escaped are cached under a hash, and
+// pages cached with a custom KeyFunc or a namespace under other keys: they
+// are not matched, and must be purged by key with InvalidatePattern.
+func PurgePattern(store persistence.CacheStore, uriPattern string) error {
+	patternStore, ok := store.(persistence.PatternStore)
+	if !ok {
+		return persistence.ErrNotSupport
+	}
+	segments := strings.Split(uriPattern, "*")
+	for i, segment := range segments {
+		// Escaped URIs are made of characters matched literally
+		segments[i] = url.QueryEscape(segment)
+	}
+	pattern := persistence.QuoteGlob(PageCachePrefix+":") + strings.Join(segments, "*")
+	if err := patternStore.InvalidatePattern(pattern); err != nil {
+		return err
+	}
+	if strings.HasSuffix(pattern, "*") {
+		return nil
+	}
+	if err := patternStore.InvalidatePattern(pattern + "|*"); err != nil {
+		return err
+	}
+	return patternStore.InvalidatePattern(pattern + varySuffix)
+}
+
+// PurgeTag evicts the pages tagged with tag (see TagPage). It returns
+// persistence.ErrNotSupport if the store is not a persistence.TagStore.
+func PurgeTag(store persistence.CacheStore, tag string) error {
+	tagStore, ok := store.(persistence.TagStore)
+	if !ok {
+		return persistence.ErrNotSupport
+	}
+	return tagStore.InvalidateTag(tag)
+}
+
+// NewAdminHandler returns an http.Handler letting operators evict cached
+// items, with the following endpoints:
+//
+//	POST /purge/page?uri=/products?page=2
+//	POST /purge/prefix?prefix=gincontrib.page.cache:
+//	POST /purge/pattern?pattern=/api/v1/products/*
+//	POST /purge/tag?tag=products
+//	POST /purge/identity?identity=42
+//	POST /flush
+//
+// They answer 204 No Content on success, and 501 Not Implemented when the
+// store does not support the operation. Every request must be accepted by
+// authorize, or it is answered with 403 Forbidden; a nil authorize rejects
+// all requests. To serve the handler under a path of a gin router:
+//
+//	admin := cache.NewAdminHandler(store, authorize)
+//	router.Any("/admin/cache/*path", gin.WrapH(http.StripPrefix("/admin/cache", admin)))
+func NewAdminHandler(store persistence.CacheStore, authorize func(r *http.Request) bool) http.Handler {
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if authorize == nil || !authorize(c.Request) {
+			c.AbortWithStatus(http.StatusForbidden)
+		}
+	})
+
+	purge := func(param string, fn func(store persistence.CacheStore, value string) error) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			value := c.Query(param)
+			if value == "" {
+				c.String(http.StatusBadRequest, "missing %s parameter", param)
+				return
+			}
+			adminResult(c, fn(persistence.BindContext(c.Request.Context(), store), value))
+		}
+	}
+	router.POST("/purge/page", purge("uri", PurgePage))
+	router.POST("/purge/prefix", purge("prefix", PurgePrefix))
+	router.POST("/purge/pattern", purge("pattern", PurgePattern))
+	router.POST("/purge/tag", purge("tag", PurgeTag))
+	router.POST("/purge/identity", purge("identity", PurgeIdentity))
+	router.POST("/flush", func(c *gin.Context) {
+		adminResult(c, persistence.BindContext(c.Request.Context(), store).Flush())
+	})
+	return router
+}
+
+// adminResult answers an admin request according to err
+func adminResult(c *gin.Context, err error) {
+	switch err {
+	case nil:
+		c.Status(http.StatusNoContent)
+	case persistence.ErrNotSupport:
+		c.String(http.StatusNotImplemented, err.Error())
+	default:
+		c.String(http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WithBodyKey caches the responses to requests with a body, such as GraphQL
+// queries or searches sent with POST, per request body: the cache key is the
+// one of the URL with a hash of the method, the Content-Type and the body
+// appended. Requests whose body is larger than maxSize bytes are not cached.
+// The handler reads the body as sent.
+//
+// Only use it on routes without side effects: a cached response is served
+// without running the handler. With GraphQL, route mutations to a handler
+// that is not cached.
+func WithBodyKey(maxSize int64) PageOption {
+	return func(o *pageOptions) {
+		o.bodyKey = true
+		o.bodyMaxSize = maxSize
+	}
+}
+
+// bodyKeySuffix returns the suffix of the key of the page requested in c for
+// its method and body, or false if the body is too large to be cached. The
+// body is restored for the handler. GET and HEAD requests have no suffix.
+func (o pageOptions) bodyKeySuffix(c *gin.Context) (string, bool) {
+	r := c.Request
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return "", true
+	}
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(io.LimitReader(r.Body, o.bodyMaxSize+1))
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		if err != nil || int64(len(body)) > o.bodyMaxSize {
+			return "", false
+		}
+	}
+
+	h := sha1.New()
+	io.WriteString(h, r.Method)
+	io.WriteString(h, "\n")
+	io.WriteString(h, r.Header.Get("Content-Type"))
+	io.WriteString(h, "\n")
+	h.Write(body)
+	return "|body=" + hex.EncodeToString(h.Sum(nil)), true
+}
+
+// readCloser reads from a reader, and closes a body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
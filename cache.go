@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"encoding/gob"
-	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -12,8 +11,8 @@ import (
 	"sync"
 	"time"
 
-	"github.com/gin-contrib/cache/persistence"
 	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
 )
 
 const (
@@ -28,6 +27,16 @@ type responseCache struct {
 	Status int
 	Header http.Header
 	Data   []byte
+	// Stored is the time the response was cached, zero for responses cached
+	// before it was recorded
+	Stored time.Time
+	// Delta is the time the handler took to generate the response, and
+	// Expires the time it expires from the cache, zero if unknown
+	Delta   time.Duration
+	Expires time.Time
+	// upgraded is set on responses decoded from another format than the
+	// current envelope
+	upgraded bool
 }
 
 // RegisterResponseCacheGob registers the responseCache type with the encoding/gob package
@@ -42,8 +51,26 @@ type cachedWriter struct {
 	store   persistence.CacheStore
 	expire  time.Duration
 	key     string
+	// streamed is set once the response is detected as streamed, after
+	// which it is no longer cached
+	streamed bool
+	headers  headerFilter
+	// delta is the time the handler took to generate the response
+	delta time.Duration
+	// stored is the response cached by commit
+	stored responseCache
+	// body records the response until commit, in a buffer of bodyPool
+	body *bytes.Buffer
 }
 
+// bodyPool holds the buffers cachedWriter records responses in, so that
+// caching a response does not allocate a buffer growing with it
+var bodyPool = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+
+// maxPooledBody is the capacity above which a buffer is not put back in
+// bodyPool, so that a few large responses do not pin memory
+const maxPooledBody = 1 << 20
+
 var _ gin.ResponseWriter = &cachedWriter{}
 
 // CreateKey creates a package specific key for a given string
@@ -66,7 +93,7 @@ func urlEscape(prefix string, u string) string {
 }
 
 func newCachedWriter(store persistence.CacheStore, expire time.Duration, writer gin.ResponseWriter, key string) *cachedWriter {
-	return &cachedWriter{writer, 0, false, store, expire, key}
+	return &cachedWriter{ResponseWriter: writer, store: store, expire: expire, key: key}
 }
 
 func (w *cachedWriter) WriteHeader(code int) {
@@ -83,46 +110,83 @@ func (w *cachedWriter) Written() bool {
 	return w.ResponseWriter.Written()
 }
 
+func (w *cachedWriter) Flush() {
+	w.streamed = true
+	w.release()
+	w.ResponseWriter.Flush()
+}
+
+// caches reports whether the response is still being cached
+func (w *cachedWriter) caches() bool {
+	if !w.streamed && isEventStream(w.Header()) {
+		w.streamed = true
+		w.release()
+	}
+	return !w.streamed
+}
+
+// record returns the buffer the body is recorded in, taking it from
+// bodyPool on the first write
+func (w *cachedWriter) record() *bytes.Buffer {
+	if w.body == nil {
+		w.body = bodyPool.Get().(*bytes.Buffer)
+	}
+	return w.body
+}
+
 func (w *cachedWriter) Write(data []byte) (int, error) {
 	ret, err := w.ResponseWriter.Write(data)
-	if err == nil {
-		store := w.store
-		var cache responseCache
-		if err := store.Get(w.key, &cache); err == nil {
-			data = append(cache.Data, data...)
-		}
-
-		//cache responses with a status code < 300
-		if w.Status() < 300 {
-			val := responseCache{
-				w.Status(),
-				w.Header(),
-				data,
-			}
-			err = store.Set(w.key, val, w.expire)
-			if err != nil {
-				// need logger
-			}
-		}
+	if w.caches() {
+		w.record().Write(data[:ret])
 	}
 	return ret, err
 }
 
 func (w *cachedWriter) WriteString(data string) (n int, err error) {
 	ret, err := w.ResponseWriter.WriteString(data)
-	//cache responses with a status code < 300
-	if err == nil && w.Status() < 300 {
-		store := w.store
-		val := responseCache{
-			w.Status(),
-			w.Header(),
-			[]byte(data),
-		}
-		store.Set(w.key, val, w.expire)
+	if w.caches() {
+		w.record().WriteString(data[:ret])
 	}
 	return ret, err
 }
 
+// commit caches the response written through w if its status code is < 300,
+// it was not streamed and it has a body, and reports whether it did, with the
+// error of the store if any. The body is copied once, to a slice the store
+// may keep, and the buffer it was recorded in is put back in bodyPool.
+func (w *cachedWriter) commit() (bool, error) {
+	defer w.release()
+	if w.streamed || w.body == nil || w.Status() >= 300 {
+		return false, nil
+	}
+	now := time.Now()
+	val := responseCache{
+		Status:  w.Status(),
+		Header:  w.headers.filter(w.Header()),
+		Data:    append(make([]byte, 0, w.body.Len()), w.body.Bytes()...),
+		Stored:  now,
+		Delta:   w.delta,
+		Expires: expiresAt(now, w.expire),
+	}
+	if err := w.store.Set(w.key, val, w.expire); err != nil {
+		return false, err
+	}
+	w.stored = val
+	return true, nil
+}
+
+// release puts the buffer the body is recorded in back in bodyPool
+func (w *cachedWriter) release() {
+	if w.body == nil {
+		return
+	}
+	if w.body.Cap() <= maxPooledBody {
+		w.body.Reset()
+		bodyPool.Put(w.body)
+	}
+	w.body = nil
+}
+
 // Cache Middleware
 func Cache(store *persistence.CacheStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -131,11 +195,19 @@ func Cache(store *persistence.CacheStore) gin.HandlerFunc {
 	}
 }
 
-func SiteCache(store persistence.CacheStore, expire time.Duration) gin.HandlerFunc {
+// SiteCache Middleware
+//
+// Of the PageOption, only WithKeyFunc and WithNamespace apply, unless
+// WithHTTPCaching is given.
+func SiteCache(store persistence.CacheStore, expire time.Duration, opts ...PageOption) gin.HandlerFunc {
+	o := newPageOptions(opts)
+	if o.httpCaching {
+		return o.httpCache(store, expire)
+	}
 	return func(c *gin.Context) {
+		store := persistence.BindContext(c.Request.Context(), store)
 		var cache responseCache
-		url := c.Request.URL
-		key := CreateKey(url.RequestURI())
+		key := o.key(c)
 		if err := store.Get(key, &cache); err != nil {
 			c.Next()
 		} else {
@@ -151,39 +223,254 @@ func SiteCache(store persistence.CacheStore, expire time.Duration) gin.HandlerFu
 }
 
 // CachePage Decorator
-func CachePage(store persistence.CacheStore, expire time.Duration, handle gin.HandlerFunc) gin.HandlerFunc {
+//
+// Concurrent requests missing the cache for the same page are coalesced: the
+// handler runs once while the other requests wait for its response to be
+// cached, instead of all regenerating the page when a popular entry expires.
+func CachePage(store persistence.CacheStore, expire time.Duration, handle gin.HandlerFunc, opts ...PageOption) gin.HandlerFunc {
+	return cachePage(store, expire, handle, newPageOptions(opts))
+}
+
+// CachePageWithMetrics works like CachePage, and records page hits, misses
+// and the size of the cached responses in metrics
+func CachePageWithMetrics(store persistence.CacheStore, expire time.Duration, metrics *PageMetrics, handle gin.HandlerFunc, opts ...PageOption) gin.HandlerFunc {
+	o := newPageOptions(opts)
+	o.metrics = metrics
+	return cachePage(store, expire, handle, o)
+}
+
+func cachePage(store persistence.CacheStore, expire time.Duration, handle gin.HandlerFunc, opts pageOptions) gin.HandlerFunc {
+	var group pageGroup
+	metrics := opts.metrics
 	return func(c *gin.Context) {
-		var cache responseCache
-		url := c.Request.URL
-		key := CreateKey(url.RequestURI())
-		if err := store.Get(key, &cache); err != nil {
-			if err != persistence.ErrCacheMiss {
-				log.Println(err.Error())
+		if opts.rejectFiltered(c) {
+			return
+		}
+		store := persistence.BindContext(c.Request.Context(), store)
+		var directives map[string]string
+		if opts.cacheControl {
+			directives = requestDirectives(c)
+			if _, ok := directives["no-store"]; ok {
+				handle(c)
+				return
 			}
-			// replace writer
-			writer := newCachedWriter(store, expire, c.Writer, key)
-			c.Writer = writer
+		}
+		bypass, refresh := opts.directives(c)
+		if bypass {
 			handle(c)
+			return
+		}
 
-			// Drop caches of aborted contexts
-			if c.IsAborted() {
+		var cache responseCache
+		base := opts.key(c)
+		if opts.bodyKey {
+			suffix, ok := opts.bodyKeySuffix(c)
+			if !ok {
+				handle(c)
+				return
+			}
+			base += suffix
+		}
+		if opts.identity != nil {
+			base += opts.identityKeySuffix(c)
+		}
+		if len(opts.negotiate) > 0 {
+			base += opts.negotiationKeySuffix(c)
+		}
+		headers := opts.varyHeaders(store, base)
+		key := varyKey(base, c.Request, headers)
+		generate := func() {
+			metrics.miss(c)
+			opts.debug.miss(c, key)
+			opts.log(persistence.LogMiss, key, 0)
+			opts.markMiss(c.Writer.Header())
+			if opts.gzip {
+				addVary(c.Writer.Header(), "Accept-Encoding")
+			}
+			if len(opts.negotiate) > 0 {
+				addVary(c.Writer.Header(), "Accept")
+			}
+			var (
+				ttl    = opts.jitter(expire)
+				stored bool
+				size   int
+				page   responseCache
+			)
+			started := time.Now()
+			if opts.records() {
+				writer := &recordingWriter{ResponseWriter: c.Writer, limit: opts.maxSize, streamLimit: opts.streamLimit()}
+				c.Writer = writer
+				handle(c)
+				if opts.skips(c) || writer.overflow || !opts.cacheable(writer.Status()) {
+					return
+				}
+				if opts.negative(writer.Status()) {
+					ttl = opts.jitter(opts.negativeTTL)
+				} else if opts.ttlFunc != nil {
+					ttl = opts.ttlFunc(c, writer.Status(), writer.body.Bytes())
+					if ttl <= 0 {
+						return
+					}
+					ttl = opts.jitter(ttl)
+				}
+				now := time.Now()
+				val := responseCache{
+					Status:  writer.Status(),
+					Header:  opts.headers.filter(writer.Header()),
+					Data:    writer.body.Bytes(),
+					Stored:  now,
+					Delta:   now.Sub(started),
+					Expires: expiresAt(now, ttl),
+				}
+				if err := store.Set(key, val, ttl); err != nil {
+					opts.storeError(c, key, err)
+					return
+				}
+				stored, size, page = true, writer.Size(), val
+			} else {
+				// replace writer
+				writer := newCachedWriter(store, ttl, c.Writer, key)
+				writer.headers = opts.headers
+				c.Writer = writer
+				handle(c)
+				writer.delta = time.Since(started)
+
+				// Drop caches of skipped and streamed responses
+				if opts.skips(c) || writer.streamed {
+					writer.release()
+					return
+				}
+				var err error
+				if stored, err = writer.commit(); err != nil {
+					opts.storeError(c, key, err)
+				}
+				size, page = writer.Size(), writer.stored
+			}
+			if stored {
+				opts.remember(key, page)
+			}
+
+			key := opts.revary(store, c, base, key, headers, ttl)
+			if key == "" {
+				return
+			}
+			controlled, ok := opts.responseTTL(c, ttl)
+			if !ok {
 				store.Delete(key)
+				return
 			}
-		} else {
-			c.Writer.WriteHeader(cache.Status)
+			if controlled != ttl {
+				ttl = controlled
+				reexpire(store, key, ttl)
+			}
+			tagPage(store, c, key)
+			if stored {
+				if opts.etag {
+					addValidators(store, key, ttl)
+				}
+				metrics.cached(c, size)
+				opts.log(persistence.LogStore, key, ttl)
+			}
+		}
+
+		generate = opts.withSecondChance(c, key, generate)
+
+		if _, ok := directives["no-cache"]; ok || refresh {
+			// Evict the cached response, even if the new one is not cached
+			store.Delete(key)
+			generate()
+			return
+		}
+		ttl, err := opts.get(store, key, &cache)
+		if err != nil && err != persistence.ErrCacheMiss {
+			opts.storeError(c, key, err)
+			if opts.errorPolicy.closed {
+				c.AbortWithStatus(http.StatusServiceUnavailable)
+				return
+			}
+			if fallback := opts.errorPolicy.fallback; fallback != nil {
+				// generate caches the page in the fallback too
+				store = persistence.BindContext(c.Request.Context(), fallback)
+				if ttl, err = opts.get(store, key, &cache); err != nil && err != persistence.ErrCacheMiss {
+					opts.storeError(c, key, err)
+				}
+			}
+		}
+		if err != nil {
+			var (
+				generated bool
+				lockErr   error
+			)
+			leader, waitErr := group.do(c.Request.Context(), key, func() {
+				generated, lockErr = opts.generateLocked(store, c, key, &cache, generate)
+			})
+			if waitErr == nil {
+				waitErr = lockErr
+			}
+			if waitErr != nil {
+				// The client went away while waiting for the page; there
+				// is no one left to serve it to
+				c.Error(waitErr)
+				c.Abort()
+				return
+			}
+			if generated {
+				return
+			}
+			// Another request generated the page meanwhile; generate it
+			// again if it did not end up in the cache
+			if !leader {
+				if ttl, err = opts.get(store, key, &cache); err != nil {
+					generate()
+					return
+				}
+			}
+		}
+
+		if opts.recomputeEarly(cache) {
+			generate()
+			return
+		}
+		if cache.upgraded && opts.upgrade {
+			opts.rewrite(store, key, cache, expire)
+		}
+		if opts.sliding {
+			ttl = opts.slide(store, key, cache, ttl, expire)
+		}
+		metrics.hit(c)
+		opts.debug.hit(c, key)
+		opts.remember(key, cache)
+		opts.log(persistence.LogHit, key, ttl)
+		if opts.etag && notModified(c, cache) {
 			for k, vals := range cache.Header {
 				for _, v := range vals {
 					c.Writer.Header().Set(k, v)
 				}
 			}
-			c.Writer.Write(cache.Data)
+			opts.markHit(c.Writer.Header(), cache, ttl)
+			c.Writer.WriteHeader(http.StatusNotModified)
+			c.Writer.WriteHeaderNow()
+			return
+		}
+		c.Writer.WriteHeader(cache.Status)
+		for k, vals := range cache.Header {
+			for _, v := range vals {
+				c.Writer.Header().Set(k, v)
+			}
+		}
+		opts.markHit(c.Writer.Header(), cache, ttl)
+		if opts.gzip {
+			c.Writer.Write(opts.gzipBody(store, c, key, cache, expire))
+			return
 		}
+		c.Writer.Write(cache.Data)
 	}
 }
 
 // CachePageWithoutQuery add ability to ignore GET query parameters.
 func CachePageWithoutQuery(store persistence.CacheStore, expire time.Duration, handle gin.HandlerFunc) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		store := persistence.BindContext(c.Request.Context(), store)
 		var cache responseCache
 		key := CreateKey(c.Request.URL.Path)
 		if err := store.Get(key, &cache); err != nil {
@@ -194,6 +481,14 @@ func CachePageWithoutQuery(store persistence.CacheStore, expire time.Duration, h
 			writer := newCachedWriter(store, expire, c.Writer, key)
 			c.Writer = writer
 			handle(c)
+			if skipped(c) {
+				writer.release()
+				return
+			}
+			if _, err := writer.commit(); err != nil {
+				log.Println(err.Error())
+			}
+			tagPage(store, c, key)
 		} else {
 			c.Writer.WriteHeader(cache.Status)
 			for k, vals := range cache.Header {
@@ -219,6 +514,7 @@ func CachePageAtomic(store persistence.CacheStore, expire time.Duration, handle
 
 func CachePageWithoutHeader(store persistence.CacheStore, expire time.Duration, handle gin.HandlerFunc) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		store := persistence.BindContext(c.Request.Context(), store)
 		var cache responseCache
 		url := c.Request.URL
 		key := CreateKey(url.RequestURI())
@@ -231,9 +527,14 @@ func CachePageWithoutHeader(store persistence.CacheStore, expire time.Duration,
 			c.Writer = writer
 			handle(c)
 
-			// Drop caches of aborted contexts
-			if c.IsAborted() {
-				store.Delete(key)
+			// Drop caches of aborted contexts and skipped responses
+			if skipped(c) {
+				writer.release()
+			} else {
+				if _, err := writer.commit(); err != nil {
+					log.Println(err.Error())
+				}
+				tagPage(store, c, key)
 			}
 		} else {
 			c.Writer.WriteHeader(cache.Status)
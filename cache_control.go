@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
+)
+
+// WithCacheControl makes CachePage honor Cache-Control directives, as a
+// shared cache would.
+//
+// Responses whose Cache-Control header has no-store, private or no-cache are
+// not cached, and s-maxage or max-age, if set, replaces the expiration given
+// to CachePage. Requests with no-cache (or "Pragma: no-cache") bypass the
+// cache and regenerate the page, and requests with no-store are neither
+// answered from nor stored in the cache.
+func WithCacheControl() PageOption {
+	return func(o *pageOptions) {
+		o.cacheControl = true
+	}
+}
+
+// parseCacheControl returns the directives of a Cache-Control header, with
+// their names in lower case and their values unquoted
+func parseCacheControl(header string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value := part, ""
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			name, value = part[:i], strings.Trim(strings.TrimSpace(part[i+1:]), `"`)
+		}
+		directives[strings.ToLower(strings.TrimSpace(name))] = value
+	}
+	return directives
+}
+
+// requestDirectives returns the Cache-Control directives of the request in c,
+// treating "Pragma: no-cache" as no-cache
+func requestDirectives(c *gin.Context) map[string]string {
+	directives := parseCacheControl(c.GetHeader("Cache-Control"))
+	if strings.EqualFold(strings.TrimSpace(c.GetHeader("Pragma")), "no-cache") {
+		directives["no-cache"] = ""
+	}
+	return directives
+}
+
+// responseTTL returns how long to cache the response generated in c, and
+// false if it must not be cached
+func (o pageOptions) responseTTL(c *gin.Context, expire time.Duration) (time.Duration, bool) {
+	if !o.cacheControl {
+		return expire, true
+	}
+	directives := parseCacheControl(c.Writer.Header().Get("Cache-Control"))
+	for _, name := range []string{"no-store", "private", "no-cache"} {
+		if _, ok := directives[name]; ok {
+			return 0, false
+		}
+	}
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[name]; ok {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return expire, true
+}
+
+// reexpire sets the expiration of the response cached at key to expire,
+// without writing it again if the store is a persistence.TouchStore
+func reexpire(store persistence.CacheStore, key string, expire time.Duration) {
+	if err := persistence.Touch(store, key, expire); err != persistence.ErrNotSupport {
+		return
+	}
+	var cache responseCache
+	if err := store.Get(key, &cache); err == nil {
+		store.Set(key, cache, expire)
+	}
+}
@@ -1,16 +1,28 @@
 package cache
 
 import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 	"encoding/gob"
 	"bytes"
+	"io/ioutil"
+	"os"
 
-	"github.com/gin-contrib/cache/persistence"
+	"github.com/mlsen/cache/persistence"
+	"github.com/mlsen/cache/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -38,6 +50,29 @@ func TestWrite(t *testing.T) {
 	assert.True(t, c.Writer.Written())
 }
 
+func TestCachedWriterCommit(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	writer := newCachedWriter(store, time.Second*3, c.Writer, "mykey")
+	c.Writer = writer
+
+	c.Writer.Write([]byte("foo"))
+	c.Writer.WriteString("bar")
+	var cache responseCache
+	assert.Equal(t, persistence.ErrCacheMiss, store.Get("mykey", &cache))
+
+	stored, err := writer.commit()
+	assert.True(t, stored)
+	assert.NoError(t, err)
+	assert.Nil(t, writer.body)
+	assert.NoError(t, store.Get("mykey", &cache))
+	assert.Equal(t, 200, cache.Status)
+	assert.Equal(t, "foobar", string(cache.Data))
+	assert.Equal(t, "foobar", w.Body.String())
+}
+
 func TestCachePage(t *testing.T) {
 	store := persistence.NewInMemoryStore(60 * time.Second)
 
@@ -104,6 +139,126 @@ func TestCachePageAtomic(t *testing.T) {
 	}
 }
 
+func TestCachePageCoalesced(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	var calls int32
+	router := gin.New()
+	router.GET("/coalesced", CachePage(store, time.Second*5, func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(time.Millisecond * 100)
+		c.String(200, "pong "+fmt.Sprint(time.Now().UnixNano()))
+	}))
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 10)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = performRequest("GET", "/coalesced", router).Body.String()
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, body := range bodies {
+		assert.Equal(t, bodies[0], body)
+	}
+}
+
+func TestPageGroupContextDone(t *testing.T) {
+	var group pageGroup
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go group.do(context.Background(), "key", func() {
+		close(started)
+		<-release
+	})
+	<-started
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	ran := false
+	leader, err := group.do(ctx, "key", func() { ran = true })
+	assert.False(t, leader)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.False(t, ran)
+}
+
+func TestCachePageTagged(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	router := gin.New()
+	router.GET("/products/:id", CachePage(store, time.Second*3, Tagged(func(c *gin.Context) {
+		TagPage(c, "product:"+c.Param("id"))
+		c.String(200, "pong "+fmt.Sprint(time.Now().UnixNano()))
+	}, "products")))
+
+	w1 := performRequest("GET", "/products/1", router)
+	w2 := performRequest("GET", "/products/2", router)
+	assert.Equal(t, w1.Body.String(), performRequest("GET", "/products/1", router).Body.String())
+
+	assert.NoError(t, store.InvalidateTag("product:1"))
+	assert.NotEqual(t, w1.Body.String(), performRequest("GET", "/products/1", router).Body.String())
+	assert.Equal(t, w2.Body.String(), performRequest("GET", "/products/2", router).Body.String())
+
+	assert.NoError(t, store.InvalidateTag("products"))
+	assert.NotEqual(t, w2.Body.String(), performRequest("GET", "/products/2", router).Body.String())
+}
+
+func TestCachePageStale(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	var calls int32
+	router := gin.New()
+	router.GET("/stale", CachePageStale(store, time.Millisecond*200, time.Second*2, 1, func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		c.String(200, "pong "+fmt.Sprint(time.Now().UnixNano()))
+	}))
+
+	w1 := performRequest("GET", "/stale", router)
+	assert.Equal(t, w1.Body.String(), performRequest("GET", "/stale", router).Body.String())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// Expired: the stale page is served while it is refreshed
+	time.Sleep(time.Millisecond * 300)
+	w2 := performRequest("GET", "/stale", router)
+	assert.Equal(t, 200, w2.Code)
+	assert.Equal(t, w1.Body.String(), w2.Body.String())
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&calls) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	time.Sleep(time.Millisecond * 50)
+	w3 := performRequest("GET", "/stale", router)
+	assert.NotEqual(t, w1.Body.String(), w3.Body.String())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// Past the stale window: the page is generated again
+	time.Sleep(time.Millisecond * 2300)
+	w4 := performRequest("GET", "/stale", router)
+	assert.NotEqual(t, w3.Body.String(), w4.Body.String())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestCachePageStale400(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	router := gin.New()
+	router.GET("/stale_400", CachePageStale(store, time.Second*3, time.Second, 1, func(c *gin.Context) {
+		c.String(400, fmt.Sprint(time.Now().UnixNano()))
+	}))
+
+	w1 := performRequest("GET", "/stale_400", router)
+	w2 := performRequest("GET", "/stale_400", router)
+
+	assert.Equal(t, 400, w1.Code)
+	assert.NotEqual(t, w1.Body.String(), w2.Body.String())
+}
+
 func TestCachePageWithoutHeader(t *testing.T) {
 	store := persistence.NewInMemoryStore(60 * time.Second)
 
@@ -281,6 +436,1601 @@ func TestCachePageWithoutQuery(t *testing.T) {
 	assert.Equal(t, w1.Body.String(), w2.Body.String())
 }
 
+func TestCachePageWithMetrics(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	metrics := NewPageMetrics()
+
+	router := gin.New()
+	router.GET("/metrics/:id", CachePageWithMetrics(store, time.Second*3, metrics, func(c *gin.Context) {
+		c.String(200, "value")
+	}))
+
+	performRequest("GET", "/metrics/1", router)
+	performRequest("GET", "/metrics/1", router)
+	performRequest("GET", "/metrics/2", router)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.hits.WithLabelValues("/metrics/:id")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.misses.WithLabelValues("/metrics/:id")))
+	assert.Equal(t, 3, testutil.CollectAndCount(metrics))
+}
+
+type typedUser struct {
+	Name string
+	Age  int
+}
+
+func TestTyped(t *testing.T) {
+	users := NewTyped[typedUser](persistence.NewInMemoryStore(60 * time.Second))
+
+	_, err := users.Get("alice")
+	assert.Equal(t, persistence.ErrCacheMiss, err)
+
+	assert.NoError(t, users.Set("alice", typedUser{"Alice", 30}, persistence.DEFAULT))
+	user, err := users.Get("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, typedUser{"Alice", 30}, user)
+}
+
+func TestTypedGetOrCompute(t *testing.T) {
+	counts := NewTyped[int](persistence.NewInMemoryStore(60 * time.Second))
+
+	calls := 0
+	compute := func() (int, error) {
+		calls++
+		return 42, nil
+	}
+	for i := 0; i < 2; i++ {
+		n, err := counts.GetOrCompute("answer", compute, persistence.DEFAULT)
+		assert.NoError(t, err)
+		assert.Equal(t, 42, n)
+	}
+	assert.Equal(t, 1, calls)
+
+	failure := fmt.Errorf("compute failed")
+	n, err := counts.GetOrCompute("other", func() (int, error) {
+		return 1, failure
+	}, persistence.DEFAULT)
+	assert.Equal(t, failure, err)
+	assert.Equal(t, 0, n)
+	_, err = counts.Get("other")
+	assert.Equal(t, persistence.ErrCacheMiss, err)
+}
+
+func performRequestWithHeader(target, header, value string, router *gin.Engine) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", target, nil)
+	r.Header.Set(header, value)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestCachePageVary(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	router := gin.New()
+	router.GET("/vary", CachePage(store, time.Second*3, func(c *gin.Context) {
+		c.String(200, "hello "+c.GetHeader("Accept-Language")+" "+fmt.Sprint(time.Now().UnixNano()))
+	}, WithVary("accept-language")))
+
+	en1 := performRequestWithHeader("/vary", "Accept-Language", "en", router)
+	fr := performRequestWithHeader("/vary", "Accept-Language", "fr", router)
+	en2 := performRequestWithHeader("/vary", "Accept-Language", "en", router)
+
+	assert.Contains(t, en1.Body.String(), "hello en")
+	assert.Contains(t, fr.Body.String(), "hello fr")
+	assert.Equal(t, en1.Body.String(), en2.Body.String())
+}
+
+func TestCachePageResponseVary(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	router := gin.New()
+	router.GET("/vary", CachePage(store, time.Second*3, func(c *gin.Context) {
+		c.Header("Vary", "Accept-Encoding")
+		c.String(200, c.GetHeader("Accept-Encoding")+" "+fmt.Sprint(time.Now().UnixNano()))
+	}, WithResponseVary()))
+	router.GET("/vary-all", CachePage(store, time.Second*3, func(c *gin.Context) {
+		c.Header("Vary", "*")
+		c.String(200, fmt.Sprint(time.Now().UnixNano()))
+	}, WithResponseVary()))
+
+	gzip1 := performRequestWithHeader("/vary", "Accept-Encoding", "gzip", router)
+	br := performRequestWithHeader("/vary", "Accept-Encoding", "br", router)
+	gzip2 := performRequestWithHeader("/vary", "Accept-Encoding", "gzip", router)
+
+	assert.Contains(t, gzip1.Body.String(), "gzip ")
+	assert.Contains(t, br.Body.String(), "br ")
+	assert.Equal(t, gzip1.Body.String(), gzip2.Body.String())
+
+	w1 := performRequest("GET", "/vary-all", router)
+	time.Sleep(time.Millisecond)
+	w2 := performRequest("GET", "/vary-all", router)
+	assert.NotEqual(t, w1.Body.String(), w2.Body.String())
+}
+
+func TestCachePageNegotiation(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	router := gin.New()
+	router.GET("/resource", CachePage(store, time.Second*3, func(c *gin.Context) {
+		c.Negotiate(200, gin.Negotiate{
+			Offered: []string{gin.MIMEJSON, gin.MIMEXML},
+			Data:    gin.H{"at": time.Now().UnixNano()},
+		})
+	}, WithNegotiation(gin.MIMEJSON, gin.MIMEXML)))
+
+	json1 := performRequestWithHeader("/resource", "Accept", "application/json", router)
+	xml := performRequestWithHeader("/resource", "Accept", "application/xml", router)
+	json2 := performRequestWithHeader("/resource", "Accept", "text/html, application/json;q=0.9", router)
+	none := performRequest("GET", "/resource", router)
+
+	assert.Equal(t, gin.MIMEJSON+"; charset=utf-8", json1.Header().Get("Content-Type"))
+	assert.Equal(t, gin.MIMEXML+"; charset=utf-8", xml.Header().Get("Content-Type"))
+	assert.Equal(t, "Accept", json1.Header().Get("Vary"))
+	assert.Equal(t, "Accept", xml.Header().Get("Vary"))
+	assert.Contains(t, xml.Body.String(), "<map>")
+	assert.Equal(t, json1.Body.String(), json2.Body.String())
+	assert.Equal(t, json1.Body.String(), none.Body.String())
+	assert.NotEqual(t, json1.Body.String(), xml.Body.String())
+}
+
+func TestVaryKey(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Language", "en")
+	assert.Equal(t, "base", varyKey("base", r, nil))
+	assert.Equal(t, "base|Accept-Language=en|Authorization=", varyKey("base", r, normalizeHeaders([]string{"authorization", "Accept-Language", "accept-language"})))
+
+	r.Header.Set("Authorization", string(bytes.Repeat([]byte("x"), 300)))
+	assert.True(t, len(varyKey("base", r, []string{"Authorization"})) <= maxKeyLength)
+}
+
+func TestCachePageKeyFunc(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	router := gin.New()
+	router.GET("/user/:id", CachePage(store, time.Second*3, func(c *gin.Context) {
+		c.String(200, c.Param("id")+" "+fmt.Sprint(time.Now().UnixNano()))
+	}, WithKeyFunc(func(c *gin.Context) string {
+		return "user:" + c.Param("id")
+	})))
+
+	w1 := performRequest("GET", "/user/1?ignored=a", router)
+	w2 := performRequest("GET", "/user/1?ignored=b", router)
+	assert.Equal(t, w1.Body.String(), w2.Body.String())
+
+	var cache responseCache
+	assert.NoError(t, store.Get("user:1", &cache))
+}
+
+func TestSortedQueryKey(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	router := gin.New()
+	router.GET("/search", CachePage(store, time.Second*3, func(c *gin.Context) {
+		c.String(200, fmt.Sprint(time.Now().UnixNano()))
+	}, WithKeyFunc(SortedQueryKey)))
+
+	w1 := performRequest("GET", "/search?b=2&a=1", router)
+	w2 := performRequest("GET", "/search?a=1&b=2", router)
+	w3 := performRequest("GET", "/search?a=2&b=2", router)
+	assert.Equal(t, w1.Body.String(), w2.Body.String())
+	assert.NotEqual(t, w1.Body.String(), w3.Body.String())
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/search", nil)
+	assert.Equal(t, CreateKey("/search"), SortedQueryKey(c))
+}
+
+func TestCachePageETag(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	router := gin.New()
+	router.GET("/etag", CachePage(store, time.Second*3, func(c *gin.Context) {
+		c.String(200, "content")
+	}, WithETag()))
+
+	performRequest("GET", "/etag", router)
+	w := performRequest("GET", "/etag", router)
+	etag := w.Header().Get("ETag")
+	lastModified := w.Header().Get("Last-Modified")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "content", w.Body.String())
+	assert.NotEmpty(t, etag)
+	assert.NotEmpty(t, lastModified)
+
+	w = performRequestWithHeader("/etag", "If-None-Match", etag, router)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+
+	w = performRequestWithHeader("/etag", "If-None-Match", `"other"`, router)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "content", w.Body.String())
+
+	w = performRequestWithHeader("/etag", "If-Modified-Since", lastModified, router)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	w = performRequestWithHeader("/etag", "If-Modified-Since", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), router)
+	assert.Equal(t, 200, w.Code)
+}
+
+func TestCachePageETagFromHandler(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	router := gin.New()
+	router.GET("/etag", CachePage(store, time.Second*3, func(c *gin.Context) {
+		c.Header("ETag", `W/"v1"`)
+		c.String(200, "content")
+	}, WithETag()))
+
+	performRequest("GET", "/etag", router)
+	w := performRequestWithHeader("/etag", "If-None-Match", `"v1"`, router)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, `W/"v1"`, w.Header().Get("ETag"))
+}
+
+func TestCachePageCacheControl(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	router := gin.New()
+	cached := func(cacheControl string) gin.HandlerFunc {
+		return CachePage(store, time.Minute, func(c *gin.Context) {
+			if cacheControl != "" {
+				c.Header("Cache-Control", cacheControl)
+			}
+			c.String(200, fmt.Sprint(time.Now().UnixNano()))
+		}, WithCacheControl())
+	}
+	router.GET("/default", cached(""))
+	router.GET("/no-store", cached("no-store"))
+	router.GET("/private", cached("private, max-age=60"))
+	router.GET("/short", cached("public, max-age=1"))
+
+	w1 := performRequest("GET", "/default", router)
+	w2 := performRequest("GET", "/default", router)
+	assert.Equal(t, w1.Body.String(), w2.Body.String())
+
+	// The client asks for a fresh page, which replaces the cached one
+	w3 := performRequestWithHeader("/default", "Cache-Control", "no-cache", router)
+	assert.NotEqual(t, w1.Body.String(), w3.Body.String())
+	w4 := performRequest("GET", "/default", router)
+	assert.Equal(t, w3.Body.String(), w4.Body.String())
+
+	w5 := performRequestWithHeader("/default", "Pragma", "no-cache", router)
+	assert.NotEqual(t, w4.Body.String(), w5.Body.String())
+
+	for _, path := range []string{"/no-store", "/private"} {
+		w1 := performRequest("GET", path, router)
+		time.Sleep(time.Millisecond)
+		w2 := performRequest("GET", path, router)
+		assert.NotEqual(t, w1.Body.String(), w2.Body.String(), path)
+	}
+
+	w1 = performRequest("GET", "/short", router)
+	w2 = performRequest("GET", "/short", router)
+	assert.Equal(t, w1.Body.String(), w2.Body.String())
+	time.Sleep(2 * time.Second)
+	w3 = performRequest("GET", "/short", router)
+	assert.NotEqual(t, w1.Body.String(), w3.Body.String())
+}
+
+func TestCachePageRequestNoStore(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	router := gin.New()
+	router.GET("/page", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(200, "value")
+	}, WithCacheControl()))
+
+	performRequestWithHeader("/page", "Cache-Control", "no-store", router)
+	var cache responseCache
+	assert.Equal(t, persistence.ErrCacheMiss, store.Get(CreateKey("/page"), &cache))
+}
+
+func TestParseCacheControl(t *testing.T) {
+	assert.Equal(t, map[string]string{"public": "", "max-age": "60", "no-cache": "Set-Cookie"},
+		parseCacheControl(`Public, max-age=60, no-cache="Set-Cookie"`))
+}
+
+func TestCachePageTTLFunc(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	ttl := func(c *gin.Context, status int, body []byte) time.Duration {
+		switch {
+		case status == 404:
+			return time.Second
+		case status >= 500:
+			return 0
+		}
+		return time.Minute
+	}
+	router := gin.New()
+	router.GET("/status/:code", CachePage(store, time.Minute, func(c *gin.Context) {
+		code, _ := strconv.Atoi(c.Param("code"))
+		c.String(code, fmt.Sprint(time.Now().UnixNano()))
+	}, WithTTLFunc(ttl)))
+
+	w1 := performRequest("GET", "/status/404", router)
+	w2 := performRequest("GET", "/status/404", router)
+	assert.Equal(t, 404, w2.Code)
+	assert.Equal(t, w1.Body.String(), w2.Body.String())
+	time.Sleep(2 * time.Second)
+	w3 := performRequest("GET", "/status/404", router)
+	assert.NotEqual(t, w1.Body.String(), w3.Body.String())
+
+	w1 = performRequest("GET", "/status/500", router)
+	time.Sleep(time.Millisecond)
+	w2 = performRequest("GET", "/status/500", router)
+	assert.NotEqual(t, w1.Body.String(), w2.Body.String())
+
+	w1 = performRequest("GET", "/status/200", router)
+	w2 = performRequest("GET", "/status/200", router)
+	assert.Equal(t, w1.Body.String(), w2.Body.String())
+}
+
+func TestCachePageStatusCodes(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	router := gin.New()
+	router.GET("/status/:code", CachePage(store, time.Minute, func(c *gin.Context) {
+		code, _ := strconv.Atoi(c.Param("code"))
+		c.String(code, fmt.Sprint(time.Now().UnixNano()))
+	}, WithStatusCodes()))
+
+	for path, cached := range map[string]bool{"/status/200": true, "/status/201": false, "/status/404": false} {
+		w1 := performRequest("GET", path, router)
+		time.Sleep(time.Millisecond)
+		w2 := performRequest("GET", path, router)
+		assert.Equal(t, cached, w1.Body.String() == w2.Body.String(), path)
+	}
+}
+
+func TestCachePageMaxSize(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	router := gin.New()
+	router.GET("/size/:n", CachePage(store, time.Minute, func(c *gin.Context) {
+		n, _ := strconv.Atoi(c.Param("n"))
+		c.Writer.WriteString(fmt.Sprint(time.Now().UnixNano()))
+		for i := 0; i < n; i++ {
+			c.Writer.WriteString(strings.Repeat("x", 10))
+		}
+	}, WithMaxSize(100)))
+
+	w1 := performRequest("GET", "/size/1", router)
+	w2 := performRequest("GET", "/size/1", router)
+	assert.Equal(t, w1.Body.String(), w2.Body.String())
+
+	w1 = performRequest("GET", "/size/20", router)
+	time.Sleep(time.Millisecond)
+	w2 = performRequest("GET", "/size/20", router)
+	assert.Len(t, w1.Body.String(), 19+200)
+	assert.NotEqual(t, w1.Body.String(), w2.Body.String())
+}
+
+func TestAdminHandler(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	admin := NewAdminHandler(store, func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer secret"
+	})
+	router := gin.New()
+	router.Any("/admin/cache/*path", gin.WrapH(http.StripPrefix("/admin/cache", admin)))
+	router.GET("/page", CachePage(store, time.Minute, Tagged(func(c *gin.Context) {
+		c.String(200, fmt.Sprint(time.Now().UnixNano()))
+	}, "pages")))
+
+	adminRequest := func(target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/admin/cache"+target, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+	assertPurged := func(target string) {
+		w1 := performRequest("GET", "/page", router)
+		w2 := performRequest("GET", "/page", router)
+		assert.Equal(t, w1.Body.String(), w2.Body.String())
+		assert.Equal(t, http.StatusNoContent, adminRequest(target).Code, target)
+		w3 := performRequest("GET", "/page", router)
+		assert.NotEqual(t, w1.Body.String(), w3.Body.String(), target)
+	}
+
+	assert.Equal(t, http.StatusForbidden, performRequest("POST", "/admin/cache/flush", router).Code)
+	assertPurged("/purge/page?uri=/page")
+	assertPurged("/purge/pattern?pattern=/pa*")
+	assertPurged("/purge/tag?tag=pages")
+	assertPurged("/flush")
+
+	assert.Equal(t, http.StatusBadRequest, adminRequest("/purge/page").Code)
+	assert.Equal(t, http.StatusNotImplemented, adminRequest("/purge/prefix?prefix=x").Code)
+}
+
+func TestPurgePattern(t *testing.T) {
+	store := persistence.NewShardedInMemoryStore(60*time.Second, 4)
+	router := gin.New()
+	router.GET("/api/:version/products/*id", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(200, fmt.Sprint(time.Now().UnixNano()))
+	}))
+	uris := []string{"/api/v1/products/1", "/api/v1/products/2?fields=name", "/api/v2/products/1"}
+	bodies := make([]string, len(uris))
+	for i, uri := range uris {
+		bodies[i] = performRequest("GET", uri, router).Body.String()
+	}
+
+	assert.NoError(t, PurgePattern(store, "/api/v1/products/*"))
+	assert.NotEqual(t, bodies[0], performRequest("GET", uris[0], router).Body.String())
+	assert.NotEqual(t, bodies[1], performRequest("GET", uris[1], router).Body.String())
+	assert.Equal(t, bodies[2], performRequest("GET", uris[2], router).Body.String())
+
+	assert.Equal(t, persistence.ErrNotSupport, PurgePattern(persistence.NewSlabStore(time.Minute, 1<<20, 1), "/*"))
+}
+
+func TestCachePageNamespace(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	router := gin.New()
+	router.GET("/page", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(200, "value")
+	}, WithNamespace("app1:")))
+
+	performRequest("GET", "/page", router)
+	var cache responseCache
+	assert.NoError(t, store.Get("app1:"+CreateKey("/page"), &cache))
+	assert.Equal(t, persistence.ErrCacheMiss, store.Get(CreateKey("/page"), &cache))
+}
+
+func TestCachePageTTLJitter(t *testing.T) {
+	for _, opts := range [][]PageOption{
+		{WithTTLJitter(0.1)},
+		{WithTTLJitter(0.1), WithStatusCodes()},
+	} {
+		store := &expiryStore{InMemoryStore: persistence.NewInMemoryStore(60 * time.Second)}
+		router := gin.New()
+		router.GET("/page/:id", CachePage(store, time.Minute, func(c *gin.Context) {
+			c.String(200, "page")
+		}, opts...))
+
+		for i := 0; i < 20; i++ {
+			performRequest("GET", fmt.Sprintf("/page/%d", i), router)
+		}
+		distinct := make(map[time.Duration]bool)
+		for _, expires := range store.expirations {
+			assert.True(t, expires >= 54*time.Second && expires <= 66*time.Second, expires)
+			distinct[expires] = true
+		}
+		assert.Len(t, store.expirations, 20)
+		assert.True(t, len(distinct) > 1)
+	}
+}
+
+func TestCachePageNegativeTTL(t *testing.T) {
+	store := &expiryStore{InMemoryStore: persistence.NewInMemoryStore(60 * time.Second)}
+	router := gin.New()
+	router.GET("/item/:id", CachePage(store, time.Minute, func(c *gin.Context) {
+		if c.Param("id") == "missing" {
+			c.String(404, "not found %d", time.Now().UnixNano())
+			return
+		}
+		c.String(200, "item %d", time.Now().UnixNano())
+	}, WithNegativeTTL(5*time.Second)))
+
+	w1 := performRequest("GET", "/item/missing", router)
+	w2 := performRequest("GET", "/item/missing", router)
+	assert.Equal(t, 404, w2.Code)
+	assert.Equal(t, w1.Body.String(), w2.Body.String())
+
+	performRequest("GET", "/item/1", router)
+	assert.Equal(t, []time.Duration{5 * time.Second, time.Minute}, store.expirations)
+
+	// Other errors are still not cached
+	router.GET("/error", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(500, "error %d", time.Now().UnixNano())
+	}, WithNegativeTTL(5*time.Second)))
+	w1 = performRequest("GET", "/error", router)
+	w2 = performRequest("GET", "/error", router)
+	assert.NotEqual(t, w1.Body.String(), w2.Body.String())
+}
+
+func TestCachePageStreaming(t *testing.T) {
+	streamed := func(c *gin.Context) {
+		for i := 0; i < 3; i++ {
+			c.String(200, "chunk %d %d;", i, time.Now().UnixNano())
+			c.Writer.Flush()
+		}
+	}
+	for _, tc := range []struct {
+		name   string
+		opts   []PageOption
+		cached bool
+	}{
+		{"bypass by default", nil, false},
+		{"bypass while recording", []PageOption{WithStatusCodes()}, false},
+		{"cached", []PageOption{WithStreaming(StreamCache, 0)}, true},
+		{"over the cap", []PageOption{WithStreaming(StreamCache, 32)}, false},
+	} {
+		store := persistence.NewInMemoryStore(60 * time.Second)
+		router := gin.New()
+		router.GET("/stream", CachePage(store, time.Minute, streamed, tc.opts...))
+
+		w1 := performRequest("GET", "/stream", router)
+		w2 := performRequest("GET", "/stream", router)
+		assert.Equal(t, 3, strings.Count(w1.Body.String(), "chunk"), tc.name)
+		assert.True(t, w1.Flushed, tc.name)
+		assert.Equal(t, tc.cached, w1.Body.String() == w2.Body.String(), tc.name)
+	}
+}
+
+func TestCachePageEventStream(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	router := gin.New()
+	router.GET("/events", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.SSEvent("message", time.Now().UnixNano())
+	}))
+
+	w1 := performRequest("GET", "/events", router)
+	w2 := performRequest("GET", "/events", router)
+	assert.Equal(t, "text/event-stream", w1.Header().Get("Content-Type"))
+	assert.NotEqual(t, w1.Body.String(), w2.Body.String())
+}
+
+func TestCachePageGzip(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	body := strings.Repeat("gzip me ", 100)
+	router := gin.New()
+	router.GET("/page", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(200, body)
+	}, WithGzip(gzip.BestSpeed)))
+	router.GET("/small", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(200, "small")
+	}, WithGzip(gzip.BestSpeed)))
+
+	w := performRequestWithHeader("/page", "Accept-Encoding", "gzip", router)
+	assert.Equal(t, body, w.Body.String())
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+
+	for _, accept := range []string{"gzip", "br, gzip;q=0.5", "*"} {
+		w = performRequestWithHeader("/page", "Accept-Encoding", accept, router)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"), accept)
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"), accept)
+		assert.Equal(t, body, gunzip(t, w.Body.Bytes()), accept)
+	}
+	for _, accept := range []string{"", "br", "gzip;q=0", "*, gzip;q=0"} {
+		w = performRequestWithHeader("/page", "Accept-Encoding", accept, router)
+		assert.Empty(t, w.Header().Get("Content-Encoding"), accept)
+		assert.Equal(t, body, w.Body.String(), accept)
+	}
+
+	performRequest("GET", "/small", router)
+	w = performRequestWithHeader("/small", "Accept-Encoding", "gzip", router)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "small", w.Body.String())
+
+	// A body compressed from a replaced page is not served
+	body = strings.Repeat("new page ", 100)
+	store.Delete(CreateKey("/page"))
+	performRequest("GET", "/page", router)
+	w = performRequestWithHeader("/page", "Accept-Encoding", "gzip", router)
+	assert.Equal(t, body, gunzip(t, w.Body.Bytes()))
+}
+
+func TestCachePageDistributedLock(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	var calls int32
+	handler := func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(100 * time.Millisecond)
+		c.String(200, "page %d", time.Now().UnixNano())
+	}
+	// Each route coalesces its own requests, like a separate replica
+	router := gin.New()
+	for i := 0; i < 4; i++ {
+		router.GET(fmt.Sprintf("/replica%d/page", i), CachePage(store, time.Minute, handler,
+			WithKeyFunc(func(c *gin.Context) string { return CreateKey("/page") }),
+			WithDistributedLock(time.Second, time.Second)))
+	}
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 4)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = performRequest("GET", fmt.Sprintf("/replica%d/page", i), router).Body.String()
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, body := range bodies {
+		assert.Equal(t, bodies[0], body)
+	}
+	var token string
+	assert.Equal(t, persistence.ErrCacheMiss, store.Get(CreateKey("/page")+":lock", &token))
+}
+
+func TestCachePageDistributedLockContextDone(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	// Another process is generating the page
+	_, acquired, err := persistence.TryLock(store, CreateKey("/page")+":lock", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	var calls int32
+	router := gin.New()
+	router.GET("/page", CachePage(store, time.Minute, func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		c.String(200, "page")
+	}, WithDistributedLock(time.Minute, time.Minute)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest("GET", "/page", nil)
+	router.ServeHTTP(httptest.NewRecorder(), r.WithContext(ctx))
+
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+}
+
+func TestCachePageDiagnosticHeaders(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	router := gin.New()
+	router.GET("/page", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(200, "page")
+	}, WithDiagnosticHeaders(DefaultDiagnosticHeaders)))
+	router.GET("/custom", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(200, "page")
+	}, WithDiagnosticHeaders(DiagnosticHeaders{Cache: "X-Page-Cache"})))
+
+	w := performRequest("GET", "/page", router)
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Empty(t, w.Header().Get("Age"))
+
+	w = performRequest("GET", "/page", router)
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Equal(t, "0", w.Header().Get("Age"))
+	expires, err := http.ParseTime(w.Header().Get("Expires"))
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), expires, 2*time.Second)
+
+	performRequest("GET", "/custom", router)
+	w = performRequest("GET", "/custom", router)
+	assert.Equal(t, "HIT", w.Header().Get("X-Page-Cache"))
+	assert.Empty(t, w.Header().Get("X-Cache"))
+	assert.Empty(t, w.Header().Get("Age"))
+	assert.Empty(t, w.Header().Get("Expires"))
+}
+
+func TestCachePageHeaderFilter(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	handler := func(c *gin.Context) {
+		c.SetCookie("session", "secret", 3600, "/", "", false, true)
+		c.Header("X-Version", "1")
+		c.Header("X-Internal", "debug")
+		c.String(200, "page")
+	}
+	router := gin.New()
+	router.GET("/default", CachePage(store, time.Minute, handler))
+	router.GET("/allow", CachePage(store, time.Minute, handler, WithHeaderAllowList("x-version", "Content-Type", "Set-Cookie")))
+	router.GET("/deny", CachePage(store, time.Minute, handler, WithHeaderDenyList("X-Internal")))
+	router.GET("/all", CachePage(store, time.Minute, handler, WithHeaderDenyList()))
+
+	w1 := performRequest("GET", "/default", router)
+	assert.NotEmpty(t, w1.Header().Get("Set-Cookie"))
+	w2 := performRequest("GET", "/default", router)
+	assert.Empty(t, w2.Header().Get("Set-Cookie"))
+	assert.Equal(t, "1", w2.Header().Get("X-Version"))
+	assert.Equal(t, "debug", w2.Header().Get("X-Internal"))
+
+	performRequest("GET", "/allow", router)
+	w2 = performRequest("GET", "/allow", router)
+	assert.Equal(t, "1", w2.Header().Get("X-Version"))
+	assert.Equal(t, "text/plain; charset=utf-8", w2.Header().Get("Content-Type"))
+	assert.Empty(t, w2.Header().Get("X-Internal"))
+	assert.Empty(t, w2.Header().Get("Set-Cookie"))
+
+	performRequest("GET", "/deny", router)
+	w2 = performRequest("GET", "/deny", router)
+	assert.Empty(t, w2.Header().Get("X-Internal"))
+	assert.NotEmpty(t, w2.Header().Get("Set-Cookie"))
+
+	performRequest("GET", "/all", router)
+	w2 = performRequest("GET", "/all", router)
+	assert.Equal(t, "debug", w2.Header().Get("X-Internal"))
+	assert.NotEmpty(t, w2.Header().Get("Set-Cookie"))
+}
+
+func TestWith(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	calls := 0
+	handler := func(c *gin.Context) {
+		calls++
+		c.String(200, fmt.Sprint(c.GetHeader("Accept-Language"), calls))
+	}
+	products := NewConfig(store, time.Minute).Tags("products").Vary("Accept-Language")
+
+	router := gin.New()
+	router.GET("/products", With(products), handler)
+	group := router.Group("/orders", With(NewConfig(store, time.Minute).StatusCodes(200)))
+	group.GET("/missing", func(c *gin.Context) {
+		calls++
+		c.String(404, fmt.Sprint(calls))
+	})
+
+	w1 := performRequest("GET", "/products", router)
+	w2 := performRequest("GET", "/products", router)
+	assert.Equal(t, "1", w1.Body.String())
+	assert.Equal(t, w1.Body.String(), w2.Body.String())
+	assert.Equal(t, 1, calls)
+
+	req, _ := http.NewRequest("GET", "/products", nil)
+	req.Header.Set("Accept-Language", "fr")
+	w3 := httptest.NewRecorder()
+	router.ServeHTTP(w3, req)
+	assert.Equal(t, "fr2", w3.Body.String())
+
+	assert.NoError(t, store.InvalidateTag("products"))
+	assert.Equal(t, "3", performRequest("GET", "/products", router).Body.String())
+
+	assert.Equal(t, "4", performRequest("GET", "/orders/missing", router).Body.String())
+	assert.Equal(t, "5", performRequest("GET", "/orders/missing", router).Body.String())
+}
+
+func TestConfigCopies(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	base := NewConfig(store, time.Minute).Tags("a")
+	derived := base.Tags("b").TTL(time.Second)
+	base.Tags("c")
+
+	assert.Equal(t, []string{"a"}, base.tags)
+	assert.Equal(t, []string{"a", "b"}, derived.tags)
+	assert.Equal(t, time.Minute, base.expire)
+	assert.Equal(t, time.Second, derived.expire)
+}
+
+func TestWarmer(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	var calls int32
+	router := gin.New()
+	router.GET("/page/:id", CachePage(store, time.Minute, func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		c.String(200, c.Param("id"))
+	}))
+	router.GET("/broken", func(c *gin.Context) {
+		c.String(500, "broken")
+	})
+
+	var progress []WarmProgress
+	err := NewWarmer(router, WithWarmConcurrency(2), WithWarmProgress(func(p WarmProgress) {
+		progress = append(progress, p)
+	})).
+		AddURL("/page/1", "/page/2", "/broken").
+		AddLoader(store, "config", time.Minute, func(ctx context.Context) (interface{}, error) {
+			return "loaded", nil
+		}).
+		Run(context.Background())
+
+	warmErr, ok := err.(WarmError)
+	assert.True(t, ok)
+	assert.Len(t, warmErr, 1)
+	assert.Contains(t, warmErr, "/broken")
+	assert.Len(t, progress, 4)
+	assert.Equal(t, 4, progress[3].Done)
+	assert.Equal(t, 4, progress[3].Total)
+
+	var value string
+	assert.NoError(t, store.Get("config", &value))
+	assert.Equal(t, "loaded", value)
+	assert.Equal(t, "1", performRequest("GET", "/page/1", router).Body.String())
+	assert.Equal(t, "2", performRequest("GET", "/page/2", router).Body.String())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestWarmerCanceled(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	var loads int32
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		cancel()
+		return "value", nil
+	}
+	warmer := NewWarmer(nil, WithWarmConcurrency(1))
+	for i := 0; i < 5; i++ {
+		warmer.AddLoader(store, fmt.Sprint("key", i), time.Minute, loader)
+	}
+
+	assert.Equal(t, context.Canceled, warmer.Run(ctx))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+}
+
+func TestMountPeers(t *testing.T) {
+	routers := []*gin.Engine{gin.New(), gin.New()}
+	servers := make([]*httptest.Server, len(routers))
+	urls := make([]string, len(routers))
+	for i, router := range routers {
+		servers[i] = httptest.NewServer(router)
+		defer servers[i].Close()
+		urls[i] = servers[i].URL
+	}
+	calls := 0
+	for i, router := range routers {
+		store := persistence.NewPeerStore(urls[i], persistence.NewInMemoryStore(time.Hour))
+		store.SetPeers(urls...)
+		MountPeers(router, store)
+		for page := 0; page < 10; page++ {
+			router.GET(fmt.Sprintf("/page/%d", page), CachePage(store, time.Minute, func(c *gin.Context) {
+				calls++
+				c.String(200, "page")
+			}))
+		}
+	}
+
+	// Pages cached through either instance are served by both
+	for page := 0; page < 10; page++ {
+		performRequest("GET", fmt.Sprintf("/page/%d", page), routers[page%2])
+		w := performRequest("GET", fmt.Sprintf("/page/%d", page), routers[(page+1)%2])
+		assert.Equal(t, "page", w.Body.String())
+	}
+	assert.Equal(t, 10, calls)
+}
+
+func TestCachePageBodyKey(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	var calls int32
+	router := gin.New()
+	router.POST("/graphql", CachePage(store, time.Minute, func(c *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		body, _ := ioutil.ReadAll(c.Request.Body)
+		c.String(200, "%s %d", body, time.Now().UnixNano())
+	}, WithBodyKey(64)))
+	post := func(body string) string {
+		r := httptest.NewRequest("POST", "/graphql", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+
+	products := post(`{"query":"{products{id}}"}`)
+	assert.True(t, strings.HasPrefix(products, `{"query":"{products{id}}"}`))
+	assert.Equal(t, products, post(`{"query":"{products{id}}"}`))
+	users := post(`{"query":"{users{id}}"}`)
+	assert.NotEqual(t, products, users)
+	assert.Equal(t, users, post(`{"query":"{users{id}}"}`))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// Bodies over the limit reach the handler whole, and are not cached
+	large := `{"query":"` + strings.Repeat("x", 64) + `"}`
+	first := post(large)
+	assert.True(t, strings.HasPrefix(first, large))
+	assert.NotEqual(t, first, post(large))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
+
+func TestCachePageIdentity(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	router := gin.New()
+	router.GET("/account", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(200, "%s %d", c.GetHeader("X-User"), time.Now().UnixNano())
+	}, WithIdentity(func(c *gin.Context) string {
+		return c.GetHeader("X-User")
+	})))
+	get := func(user string) string {
+		return performRequestWithHeader("/account", "X-User", user, router).Body.String()
+	}
+
+	alice, bob, anonymous := get("alice"), get("bob"), get("")
+	assert.True(t, strings.HasPrefix(alice, "alice "))
+	assert.True(t, strings.HasPrefix(bob, "bob "))
+	assert.Equal(t, alice, get("alice"))
+	assert.Equal(t, bob, get("bob"))
+	assert.Equal(t, anonymous, get(""))
+
+	assert.NoError(t, PurgeIdentity(store, "alice"))
+	assert.NotEqual(t, alice, get("alice"))
+	assert.Equal(t, bob, get("bob"))
+	assert.Equal(t, anonymous, get(""))
+}
+
+func TestResponseEnvelope(t *testing.T) {
+	stored := time.Unix(0, time.Now().UnixNano())
+	small := responseCache{Status: 201, Header: http.Header{"Content-Type": {"text/plain"}, "X-Multi": {"a", "b"}}, Data: []byte("test"), Stored: stored, Delta: time.Millisecond, Expires: stored.Add(time.Minute)}
+	large := responseCache{Status: 200, Data: bytes.Repeat([]byte("<li>item</li>"), 1000)}
+	for _, r := range []responseCache{small, large} {
+		b, err := utils.Serialize(r)
+		assert.NoError(t, err)
+		var decoded responseCache
+		assert.NoError(t, utils.Deserialize(b, &decoded))
+		assert.Equal(t, r, decoded)
+	}
+
+	envelope, _ := large.MarshalBinary()
+	assert.Less(t, len(envelope), len(large.Data)/10, "large bodies are compressed")
+
+	envelope, _ = small.MarshalBinary()
+	var decoded responseCache
+	for i := 0; i < len(envelope)-len(small.Data); i++ {
+		assert.Error(t, decoded.UnmarshalBinary(envelope[:i]), "truncated at %d", i)
+	}
+	newer := append([]byte(nil), envelope...)
+	newer[1]++
+	assert.Equal(t, errEnvelopeVersion, decoded.UnmarshalBinary(newer))
+
+	// magic, version 1, no compression, stored 0, status 200, no headers
+	v1 := append([]byte{envelopeMagic, 1, 0, 0, 0xc8, 0x01, 0}, "v1"...)
+	assert.NoError(t, decoded.UnmarshalBinary(v1))
+	assert.Equal(t, responseCache{Status: 200, Data: []byte("v1"), upgraded: true}, decoded)
+}
+
+func TestRegisterResponseDecoder(t *testing.T) {
+	envelope, _ := responseCache{Status: 200, Data: []byte("v9")}.MarshalBinary()
+	envelope[1] = 9
+	var decoded responseCache
+	assert.Equal(t, errEnvelopeVersion, decoded.UnmarshalBinary(envelope))
+
+	RegisterResponseDecoder(9, func(data []byte) (CachedResponse, error) {
+		return CachedResponse{Status: 200, Body: data[len(data)-2:]}, nil
+	})
+	assert.NoError(t, decoded.UnmarshalBinary(envelope))
+	assert.Equal(t, "v9", string(decoded.Data))
+	assert.True(t, decoded.upgraded)
+}
+
+func TestCachePageLegacyResponse(t *testing.T) {
+	// SlabStore serializes values like remote stores do
+	store := persistence.NewSlabStore(time.Minute, 1<<20, 1)
+	router := gin.New()
+	router.GET("/legacy", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(200, "regenerated")
+	}, WithRewriteUpgraded()))
+
+	key := CreateKey("/legacy")
+	assert.NoError(t, store.Set(key, legacyResponseCache{Status: 200, Data: []byte("legacy")}, time.Minute))
+	var cache responseCache
+	assert.Error(t, store.Get(key, &cache))
+
+	assert.Equal(t, "legacy", performRequest("GET", "/legacy", router).Body.String())
+	assert.NoError(t, store.Get(key, &cache), "rewritten in the current envelope")
+	assert.Equal(t, "legacy", string(cache.Data))
+	assert.Equal(t, "legacy", performRequest("GET", "/legacy", router).Body.String())
+}
+
+func TestCachePageSkip(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	var degraded, failed, panics int32 = 1, 1, 1
+
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.GET("/skip", CachePage(store, time.Minute, func(c *gin.Context) {
+		if atomic.CompareAndSwapInt32(&degraded, 1, 0) {
+			Skip(c)
+		}
+		c.String(200, "pong "+fmt.Sprint(time.Now().UnixNano()))
+	}))
+	router.GET("/error", CachePage(store, time.Minute, func(c *gin.Context) {
+		if atomic.CompareAndSwapInt32(&failed, 1, 0) {
+			c.Error(fmt.Errorf("backend failed"))
+		}
+		c.String(200, "pong "+fmt.Sprint(time.Now().UnixNano()))
+	}, WithSkipOnError()))
+	router.GET("/panic", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(200, "partial")
+		if atomic.CompareAndSwapInt32(&panics, 1, 0) {
+			panic("handler failed")
+		}
+	}))
+	router.GET("/without_query", CachePageWithoutQuery(store, time.Minute, func(c *gin.Context) {
+		Skip(c)
+		c.String(200, "pong "+fmt.Sprint(time.Now().UnixNano()))
+	}))
+
+	for _, path := range []string{"/skip", "/error"} {
+		w1 := performRequest("GET", path, router)
+		w2 := performRequest("GET", path, router)
+		w3 := performRequest("GET", path, router)
+		assert.NotEqual(t, w1.Body.String(), w2.Body.String(), path)
+		assert.Equal(t, w2.Body.String(), w3.Body.String(), path)
+	}
+
+	performRequest("GET", "/panic", router)
+	var cache responseCache
+	assert.Equal(t, persistence.ErrCacheMiss, store.Get(CreateKey("/panic"), &cache))
+	performRequest("GET", "/panic", router)
+	assert.NoError(t, store.Get(CreateKey("/panic"), &cache))
+
+	w1 := performRequest("GET", "/without_query", router)
+	w2 := performRequest("GET", "/without_query", router)
+	assert.NotEqual(t, w1.Body.String(), w2.Body.String())
+}
+
+func TestCachePageDirectives(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	router := gin.New()
+	router.GET("/directives", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(200, c.Request.URL.RawQuery+" "+fmt.Sprint(time.Now().UnixNano()))
+	}, WithBypass(HeaderDirective("X-Cache-Bypass", "secret")), WithRefresh(QueryDirective("refresh", "secret"))))
+
+	w1 := performRequest("GET", "/directives?a=1", router)
+	w2 := performRequestWithHeader("/directives?a=1", "X-Cache-Bypass", "secret", router)
+	assert.NotEqual(t, w1.Body.String(), w2.Body.String())
+	w3 := performRequestWithHeader("/directives?a=1", "X-Cache-Bypass", "guess", router)
+	assert.Equal(t, w1.Body.String(), w3.Body.String())
+	assert.Equal(t, w1.Body.String(), performRequest("GET", "/directives?a=1", router).Body.String())
+
+	w4 := performRequest("GET", "/directives?a=1&refresh=secret", router)
+	assert.NotEqual(t, w1.Body.String(), w4.Body.String())
+	assert.True(t, strings.HasPrefix(w4.Body.String(), "a=1 "))
+	assert.Equal(t, w4.Body.String(), performRequest("GET", "/directives?a=1", router).Body.String())
+	assert.Equal(t, w4.Body.String(), performRequest("GET", "/directives?a=1&refresh=guess", router).Body.String())
+}
+
+func TestCachePageLogger(t *testing.T) {
+	var events []string
+	logger := persistence.LoggerFunc(func(e persistence.LogEvent) {
+		event := e.Kind + " " + e.Operation + " " + e.Key
+		if e.Err != nil {
+			event += ": " + e.Err.Error()
+		}
+		events = append(events, event)
+	})
+	handler := func(c *gin.Context) {
+		c.String(200, "pong")
+	}
+
+	router := gin.New()
+	router.GET("/logged", CachePage(persistence.NewInMemoryStore(time.Minute), time.Minute, handler, WithLogger(logger)))
+	router.GET("/failing", CachePage(&failingSetStore{persistence.NewInMemoryStore(time.Minute)}, time.Minute, handler, WithLogger(logger)))
+
+	performRequest("GET", "/logged", router)
+	performRequest("GET", "/logged", router)
+	performRequest("GET", "/failing", router)
+	assert.Equal(t, []string{
+		"miss page " + CreateKey("/logged"),
+		"store page " + CreateKey("/logged"),
+		"hit page " + CreateKey("/logged"),
+		"miss page " + CreateKey("/failing"),
+		"error page " + CreateKey("/failing") + ": cache: write of " + CreateKey("/failing") + " refused",
+	}, events)
+}
+
+func TestCachePageHashedKeys(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	router := gin.New()
+	router.GET("/hashed", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(200, "pong "+fmt.Sprint(time.Now().UnixNano()))
+	}, WithHashedKeys(120)))
+
+	long := "/hashed?q=" + strings.Repeat("x", 150)
+	w1 := performRequest("GET", long, router)
+	w2 := performRequest("GET", long, router)
+	assert.Equal(t, w1.Body.String(), w2.Body.String())
+	performRequest("GET", "/hashed?q=short", router)
+
+	key := CreateHashedKey(long, 120)
+	assert.Equal(t, PageCachePrefix+":sha256:", key[:len(PageCachePrefix)+8])
+	assert.Len(t, key, len(PageCachePrefix)+8+64)
+	var cache responseCache
+	assert.NoError(t, store.Get(key, &cache))
+	assert.Equal(t, w1.Body.String(), string(cache.Data))
+	assert.Equal(t, CreateKey("/hashed?q=short"), CreateHashedKey("/hashed?q=short", 120))
+	assert.NoError(t, store.Get(CreateKey("/hashed?q=short"), &cache))
+}
+
+func TestCachePageSlidingExpiration(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+
+	router := gin.New()
+	router.GET("/sliding", CachePage(store, 400*time.Millisecond, func(c *gin.Context) {
+		c.String(200, "pong "+fmt.Sprint(time.Now().UnixNano()))
+	}, WithSlidingExpiration()))
+
+	w1 := performRequest("GET", "/sliding", router)
+	for i := 0; i < 3; i++ {
+		time.Sleep(250 * time.Millisecond)
+		assert.Equal(t, w1.Body.String(), performRequest("GET", "/sliding", router).Body.String())
+	}
+	time.Sleep(600 * time.Millisecond)
+	assert.NotEqual(t, w1.Body.String(), performRequest("GET", "/sliding", router).Body.String())
+}
+
+func TestHealthcheck(t *testing.T) {
+	healthy := persistence.NewInMemoryStore(time.Minute)
+	router := gin.New()
+	router.GET("/healthy", Healthcheck(map[string]persistence.CacheStore{"memory": healthy}, time.Second))
+	router.GET("/unhealthy", Healthcheck(map[string]persistence.CacheStore{
+		"memory": healthy,
+		"down":   &unavailableStore{healthy},
+	}, time.Second))
+
+	w := performRequest("GET", "/healthy", router)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var health Health
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &health))
+	assert.Equal(t, "ok", health.Status)
+	assert.Equal(t, "ok", health.Stores["memory"].Status)
+
+	w = performRequest("GET", "/unhealthy", router)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	health = Health{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &health))
+	assert.Equal(t, "unavailable", health.Status)
+	assert.Equal(t, "ok", health.Stores["memory"].Status)
+	assert.Equal(t, StoreHealth{Status: "unavailable", Latency: health.Stores["down"].Latency, Error: "cache: backend unreachable"}, health.Stores["down"])
+}
+
+func TestDebugHandler(t *testing.T) {
+	store := persistence.NewInMemoryStore(time.Minute)
+	stats := NewPageStats(1)
+	router := gin.New()
+	router.GET("/page/:id", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(http.StatusOK, "page "+c.Param("id"))
+	}, WithDebugStats(stats)))
+	router.GET("/failing", CachePage(&failingSetStore{store}, time.Minute, func(c *gin.Context) {
+		c.String(http.StatusOK, "failing")
+	}, WithDebugStats(stats), WithLogger(persistence.LoggerFunc(func(persistence.LogEvent) {}))))
+	router.GET("/debug", DebugHandler(stats, map[string]persistence.CacheStore{
+		"memory":   store,
+		"untraced": persistence.NewNamespacedStore(store, "app:"),
+	}))
+
+	for _, target := range []string{"/page/1", "/page/1", "/page/1", "/page/2", "/failing"} {
+		performRequest("GET", target, router)
+	}
+
+	w := performRequest("GET", "/debug", router)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var debug Debug
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &debug))
+	assert.Equal(t, RouteStats{Hits: 2, Misses: 2, HitRatio: 0.5}, debug.Routes["/page/:id"])
+	assert.Equal(t, RouteStats{Misses: 1, Errors: 1}, debug.Routes["/failing"])
+	assert.Equal(t, []HotKey{{Key: CreateKey("/page/1"), Requests: 3}}, debug.HotKeys)
+	assert.Contains(t, debug.Stores, "memory")
+	assert.Equal(t, 2, debug.Stores["memory"].Entries)
+	assert.NotContains(t, debug.Stores, "untraced")
+}
+
+func TestCachePageEarlyRecompute(t *testing.T) {
+	stores := map[string]persistence.CacheStore{
+		"in memory": persistence.NewInMemoryStore(time.Minute),
+		// SlabStore serializes values like remote stores do
+		"serializing": persistence.NewSlabStore(time.Minute, 1<<20, 1),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			var calls int32
+			handler := func(c *gin.Context) {
+				time.Sleep(time.Millisecond)
+				c.String(http.StatusOK, fmt.Sprint(atomic.AddInt32(&calls, 1)))
+			}
+			router := gin.New()
+			router.GET("/early", CachePage(store, time.Minute, handler, WithEarlyRecompute(1e9)))
+			router.GET("/late", CachePage(store, time.Minute, handler, WithEarlyRecompute(1e-9)))
+
+			first := performRequest("GET", "/early", router)
+			second := performRequest("GET", "/early", router)
+			assert.NotEqual(t, first.Body.String(), second.Body.String())
+			third := performRequest("GET", "/early", router)
+			assert.NotEqual(t, second.Body.String(), third.Body.String())
+
+			first = performRequest("GET", "/late", router)
+			second = performRequest("GET", "/late", router)
+			assert.Equal(t, first.Body.String(), second.Body.String())
+		})
+	}
+}
+
+func TestMemoize(t *testing.T) {
+	store := persistence.NewInMemoryStore(time.Minute)
+	var calls int32
+	release := make(chan struct{})
+	square := Memoize(store, time.Minute, func(n int) string {
+		return "square:" + strconv.Itoa(n)
+	}, func(ctx context.Context, n int) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		if n < 0 {
+			return 0, errors.New("negative")
+		}
+		return n * n, nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := square(context.Background(), 3)
+			assert.NoError(t, err)
+			assert.Equal(t, 9, n)
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	n, err := square(context.Background(), 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, n)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	var cached int
+	assert.NoError(t, store.Get("square:3", &cached))
+	assert.Equal(t, 9, cached)
+
+	_, err = square(context.Background(), -1)
+	assert.EqualError(t, err, "negative")
+	_, err = square(context.Background(), -1)
+	assert.EqualError(t, err, "negative")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestCachePageSecondChance(t *testing.T) {
+	store := persistence.NewInMemoryStore(time.Minute)
+	var calls int32
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.GET("/flaky", CachePage(store, time.Minute, func(c *gin.Context) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			c.String(http.StatusOK, "fresh")
+		case 2:
+			c.String(http.StatusBadGateway, "upstream down")
+		case 3:
+			panic("handler failed")
+		default:
+			c.String(http.StatusOK, "recovered")
+		}
+	}, WithSecondChance(10, true)))
+
+	w := performRequest("GET", "/flaky", router)
+	assert.Equal(t, "fresh", w.Body.String())
+
+	for i := 0; i < 2; i++ {
+		store.Delete(CreateKey("/flaky"))
+		w = performRequest("GET", "/flaky", router)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "fresh", w.Body.String())
+		assert.Equal(t, `110 - "Response is Stale"`, w.Header().Get("Warning"))
+	}
+
+	store.Delete(CreateKey("/flaky"))
+	w = performRequest("GET", "/flaky", router)
+	assert.Equal(t, "recovered", w.Body.String())
+	assert.Empty(t, w.Header().Get("Warning"))
+}
+
+func TestCachePageErrorPolicy(t *testing.T) {
+	down := &unavailableStore{persistence.NewInMemoryStore(time.Minute)}
+	fallback := persistence.NewInMemoryStore(time.Minute)
+	var calls int32
+	var errs []string
+	handler := func(c *gin.Context) {
+		c.String(http.StatusOK, fmt.Sprint(atomic.AddInt32(&calls, 1)))
+	}
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Next()
+		errs = append(errs, c.Errors.ByType(gin.ErrorTypePrivate).Errors()...)
+	})
+	router.GET("/open", CachePage(down, time.Minute, handler, WithSkipOnError()))
+	router.GET("/closed", CachePage(down, time.Minute, handler, WithErrorPolicy(FailClosed)))
+	router.GET("/fallback", CachePage(down, time.Minute, handler, WithErrorPolicy(FallbackTo(fallback))))
+
+	w := performRequest("GET", "/open", router)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Body.String())
+	assert.Equal(t, []string{"cache: backend unreachable"}, errs)
+	var cache responseCache
+	assert.NoError(t, down.InMemoryStore.Get(CreateKey("/open"), &cache), "store errors are not handler errors")
+
+	w = performRequest("GET", "/closed", router)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	w = performRequest("GET", "/fallback", router)
+	assert.Equal(t, "2", w.Body.String())
+	w = performRequest("GET", "/fallback", router)
+	assert.Equal(t, "2", w.Body.String())
+	assert.NoError(t, fallback.Get(CreateKey("/fallback"), &cache))
+	assert.Len(t, errs, 4)
+}
+
+func TestFromConfig(t *testing.T) {
+	var cfg StoreConfig
+	err := json.Unmarshal([]byte(`{
+		"type": "tiered",
+		"namespace": "products",
+		"local": {"type": "inmemory", "max_entries": 100},
+		"remote": {"type": "redis", "addrs": ["localhost:6379"], "pool_size": 5, "codec": "json"},
+		"local_expiration": "1m"
+	}`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, Duration(time.Minute), cfg.LocalExpiration)
+
+	store, err := FromConfig(cfg)
+	if err != nil {
+		t.Skipf("Redis is unavailable: %v", err)
+	}
+	assert.IsType(t, &persistence.NamespacedStore{}, store)
+	assert.NoError(t, store.Set("config", "value", time.Minute))
+	var value string
+	assert.NoError(t, store.Get("config", &value))
+	assert.Equal(t, "value", value)
+	store.Delete("config")
+
+	_, err = FromConfig(StoreConfig{Type: "etcd"})
+	assert.Error(t, err)
+	_, err = FromConfig(StoreConfig{Type: "inmemory", Codec: "xml"})
+	assert.Error(t, err)
+	_, err = FromConfig(StoreConfig{Type: "tiered", Local: &StoreConfig{Type: "inmemory"}})
+	assert.Error(t, err)
+}
+
+func TestConfiguredCodec(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec utils.Codec
+	}{
+		{"", utils.GobCodec},
+		{"gob", utils.GobCodec},
+		{"json", utils.JSONCodec},
+		{"msgpack", utils.MsgpackCodec},
+		{"raw", utils.RawCodec},
+	}
+	for _, test := range tests {
+		codec, err := configuredCodec(test.name)
+		assert.NoError(t, err)
+		assert.Equal(t, test.codec, codec, test.name)
+	}
+	_, err := configuredCodec("xml")
+	assert.EqualError(t, err, `cache: unknown codec "xml".`)
+}
+
+func TestStoreConfigFromEnv(t *testing.T) {
+	for name, value := range map[string]string{
+		"TESTCACHE_TYPE":                   "tiered",
+		"TESTCACHE_LOCAL_TYPE":             "inmemory",
+		"TESTCACHE_LOCAL_MAX_TOTAL_BYTES":  "1048576",
+		"TESTCACHE_REMOTE_TYPE":            "memcached",
+		"TESTCACHE_REMOTE_ADDRS":           "cache-1:11211, cache-2:11211",
+		"TESTCACHE_REMOTE_TLS_ENABLED":     "true",
+		"TESTCACHE_REMOTE_TLS_SERVER_NAME": "cache.internal",
+		"TESTCACHE_LOCAL_EXPIRATION":       "30s",
+	} {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	cfg, err := StoreConfigFromEnv("TESTCACHE")
+	assert.NoError(t, err)
+	assert.Equal(t, "tiered", cfg.Type)
+	assert.Equal(t, Duration(30*time.Second), cfg.LocalExpiration)
+	if assert.NotNil(t, cfg.Local) && assert.NotNil(t, cfg.Remote) {
+		assert.Equal(t, int64(1048576), cfg.Local.MaxTotalBytes)
+		assert.Nil(t, cfg.Local.Local)
+		assert.Equal(t, []string{"cache-1:11211", "cache-2:11211"}, cfg.Remote.Addrs)
+		if assert.NotNil(t, cfg.Remote.TLS) {
+			assert.True(t, cfg.Remote.TLS.Enabled)
+			assert.Equal(t, "cache.internal", cfg.Remote.TLS.ServerName)
+		}
+	}
+	assert.Nil(t, cfg.TLS)
+
+	os.Setenv("TESTCACHE_LOCAL_MAX_ENTRIES", "many")
+	defer os.Unsetenv("TESTCACHE_LOCAL_MAX_ENTRIES")
+	_, err = StoreConfigFromEnv("TESTCACHE")
+	assert.Error(t, err)
+}
+
+func TestSiteCacheHTTPCaching(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	counter := 0
+	router := gin.New()
+	router.Use(SiteCache(store, time.Minute, WithHTTPCaching(time.Minute)))
+	handler := func(cacheControl string) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			counter++
+			if cacheControl != "" {
+				c.Header("Cache-Control", cacheControl)
+			}
+			c.String(200, fmt.Sprint(counter))
+		}
+	}
+	router.GET("/fresh", handler("max-age=60"))
+	router.POST("/fresh", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	router.GET("/no-store", handler("no-store"))
+	router.GET("/private", handler("private, max-age=60"))
+	router.GET("/short", handler("max-age=1"))
+	router.GET("/revalidate", handler("max-age=1, must-revalidate"))
+
+	w1 := performRequest("GET", "/fresh", router)
+	w2 := performRequest("GET", "/fresh", router)
+	assert.Equal(t, w1.Body.String(), w2.Body.String())
+	assert.Equal(t, "0", w2.Header().Get("Age"))
+	assert.NotEmpty(t, w2.Header().Get("Date"))
+	head := performRequest("HEAD", "/fresh", router)
+	assert.Equal(t, 200, head.Code)
+	assert.Empty(t, head.Body.String())
+
+	// Requests can refuse cached responses
+	w3 := performRequestWithHeader("/fresh", "Cache-Control", "no-cache", router)
+	assert.NotEqual(t, w1.Body.String(), w3.Body.String())
+	w4 := performRequest("GET", "/fresh", router)
+	assert.Equal(t, w3.Body.String(), w4.Body.String())
+
+	// Unsafe methods evict the page
+	performRequest("POST", "/fresh", router)
+	w5 := performRequest("GET", "/fresh", router)
+	assert.NotEqual(t, w4.Body.String(), w5.Body.String())
+
+	for _, path := range []string{"/no-store", "/private"} {
+		w1 := performRequest("GET", path, router)
+		w2 := performRequest("GET", path, router)
+		assert.NotEqual(t, w1.Body.String(), w2.Body.String(), path)
+	}
+
+	miss := performRequestWithHeader("/unknown", "Cache-Control", "only-if-cached", router)
+	assert.Equal(t, http.StatusGatewayTimeout, miss.Code)
+
+	// Stale responses are only served to requests allowing them
+	short := performRequest("GET", "/short", router)
+	revalidate := performRequest("GET", "/revalidate", router)
+	time.Sleep(1100 * time.Millisecond)
+	stale := performRequestWithHeader("/short", "Cache-Control", "max-stale=30", router)
+	assert.Equal(t, short.Body.String(), stale.Body.String())
+	assert.Equal(t, `110 - "Response is Stale"`, stale.Header().Get("Warning"))
+	assert.Equal(t, "1", stale.Header().Get("Age"))
+	tooOld := performRequestWithHeader("/short", "Cache-Control", "max-age=0", router)
+	assert.NotEqual(t, short.Body.String(), tooOld.Body.String())
+	fresh := performRequestWithHeader("/revalidate", "Cache-Control", "max-stale", router)
+	assert.NotEqual(t, revalidate.Body.String(), fresh.Body.String())
+}
+
+func TestFreshnessLifetime(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	response := func(headers ...string) responseCache {
+		header := http.Header{"Date": {now.Format(http.TimeFormat)}}
+		for i := 0; i < len(headers); i += 2 {
+			header.Set(headers[i], headers[i+1])
+		}
+		return responseCache{Status: 200, Header: header, Stored: now}
+	}
+	lifetime := func(cache responseCache) (time.Duration, bool) {
+		return freshnessLifetime(cache, parseCacheControl(cache.Header.Get("Cache-Control")), time.Hour)
+	}
+
+	ttl, heuristic := lifetime(response("Cache-Control", "max-age=60, s-maxage=30"))
+	assert.Equal(t, 30*time.Second, ttl)
+	assert.False(t, heuristic)
+	ttl, _ = lifetime(response("Expires", now.Add(time.Minute).Format(http.TimeFormat)))
+	assert.Equal(t, time.Minute, ttl)
+	ttl, _ = lifetime(response("Expires", "0"))
+	assert.Equal(t, time.Duration(0), ttl)
+
+	ttl, heuristic = lifetime(response("Last-Modified", now.Add(-100*time.Minute).Format(http.TimeFormat)))
+	assert.Equal(t, 10*time.Minute, ttl)
+	assert.True(t, heuristic)
+	ttl, _ = lifetime(response("Last-Modified", now.Add(-100*time.Hour).Format(http.TimeFormat)))
+	assert.Equal(t, time.Hour, ttl)
+	ttl, heuristic = lifetime(response())
+	assert.Equal(t, time.Hour, ttl)
+	assert.True(t, heuristic)
+
+	age := initialAge(http.Header{"Age": {"30"}}, now, now.Add(time.Second))
+	assert.Equal(t, 31*time.Second, age)
+}
+
+func TestCachePageKeyFilter(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	products := persistence.NewCountingBloomFilter(100, 0.01)
+	products.Add("1")
+	calls := 0
+
+	router := gin.New()
+	router.GET("/products/:id", CachePage(store, time.Minute, func(c *gin.Context) {
+		calls++
+		c.String(200, "product "+c.Param("id"))
+	}, WithKeyFilter(products, func(c *gin.Context) string {
+		return c.Param("id")
+	})))
+
+	w := performRequest("GET", "/products/1", router)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "product 1", w.Body.String())
+
+	for i := 0; i < 10; i++ {
+		w = performRequest("GET", "/products/2", router)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	}
+	assert.Equal(t, 1, calls)
+
+	products.Add("2")
+	w = performRequest("GET", "/products/2", router)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, 2, calls)
+}
+
+func TestCachePageStaleRefreshGroup(t *testing.T) {
+	store := persistence.NewInMemoryStore(60 * time.Second)
+	refreshes := NewRefreshGroup()
+
+	var calls int32
+	release := make(chan struct{})
+	router := gin.New()
+	router.GET("/stale", CachePageStale(store, time.Millisecond*100, time.Second*2, 1, func(c *gin.Context) {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			<-release
+		}
+		c.String(200, "pong "+fmt.Sprint(time.Now().UnixNano()))
+	}, WithRefreshGroup(refreshes)))
+
+	w1 := performRequest("GET", "/stale", router)
+	time.Sleep(time.Millisecond * 150)
+	// Starts a refresh, blocked until released
+	assert.Equal(t, w1.Body.String(), performRequest("GET", "/stale", router).Body.String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, refreshes.Shutdown(ctx))
+	close(release)
+	assert.NoError(t, refreshes.Shutdown(context.Background()))
+	refreshed := atomic.LoadInt32(&calls)
+	assert.Equal(t, int32(2), refreshed)
+
+	// Refreshes are no longer started once shut down
+	time.Sleep(time.Millisecond * 150)
+	assert.Equal(t, 200, performRequest("GET", "/stale", router).Code)
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, refreshed, atomic.LoadInt32(&calls))
+}
+
+// benchmarkCachePageMiss measures the requests missing the cache, whose
+// response is written in chunks through the cached writer
+func benchmarkCachePageMiss(b *testing.B, store persistence.CacheStore) {
+	chunk := bytes.Repeat([]byte("a"), 1024)
+	router := gin.New()
+	router.GET("/bench", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.Status(200)
+		for i := 0; i < 4; i++ {
+			c.Writer.Write(chunk)
+		}
+	}))
+	r := httptest.NewRequest("GET", "/bench", nil)
+	key := CreateKey("/bench")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store.Delete(key)
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}
+}
+
+func BenchmarkCachePageMiss(b *testing.B) {
+	benchmarkCachePageMiss(b, persistence.NewInMemoryStore(time.Minute))
+}
+
+func BenchmarkCachePageMissSerialized(b *testing.B) {
+	benchmarkCachePageMiss(b, persistence.NewSlabStore(time.Minute, 1<<24, 1))
+}
+
+func BenchmarkCachePageHit(b *testing.B) {
+	store := persistence.NewInMemoryStore(time.Minute)
+	router := gin.New()
+	router.GET("/bench", CachePage(store, time.Minute, func(c *gin.Context) {
+		c.String(200, strings.Repeat("a", 4096))
+	}))
+	r := httptest.NewRequest("GET", "/bench", nil)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		router.ServeHTTP(httptest.NewRecorder(), r)
+	}
+}
+
 func TestRegisterResponseCacheGob(t *testing.T) {
 	RegisterResponseCacheGob()
 	r := responseCache{Status:200, Data: []byte("test"),}
@@ -322,3 +2072,42 @@ func (c *memoryDelayStore) Add(key string, value interface{}, expires time.Durat
 	time.Sleep(time.Millisecond * 3)
 	return c.InMemoryStore.Add(key, value, expires)
 }
+
+// expiryStore records the expirations of the items set
+type expiryStore struct {
+	*persistence.InMemoryStore
+	expirations []time.Duration
+}
+
+func (c *expiryStore) Set(key string, value interface{}, expires time.Duration) error {
+	c.expirations = append(c.expirations, expires)
+	return c.InMemoryStore.Set(key, value, expires)
+}
+
+// unavailableStore fails the reads of items
+type unavailableStore struct {
+	*persistence.InMemoryStore
+}
+
+func (c *unavailableStore) Get(key string, value interface{}) error {
+	return errors.New("cache: backend unreachable")
+}
+
+// failingSetStore fails the writes of items
+type failingSetStore struct {
+	*persistence.InMemoryStore
+}
+
+func (c *failingSetStore) Set(key string, value interface{}, expires time.Duration) error {
+	return fmt.Errorf("cache: write of %s refused", key)
+}
+
+func gunzip(t *testing.T, data []byte) string {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	b, err := ioutil.ReadAll(zr)
+	assert.NoError(t, err)
+	return string(b)
+}
@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
+)
+
+// lockSuffix is appended to the key of a page to name the lock held while
+// regenerating it
+const lockSuffix = ":lock"
+
+// lockPollInterval is the interval at which a request waiting for a page
+// generated by another process checks the cache
+const lockPollInterval = 25 * time.Millisecond
+
+// pageGroup coalesces concurrent cache misses on the same key, so that a page
+// is generated by a single request while the others wait for it
+type pageGroup struct {
+	mu    sync.Mutex
+	calls map[string]chan struct{}
+}
+
+// do runs fn and returns true if no call for key is in flight. Otherwise it
+// waits for the in-flight call to finish and returns false without running
+// fn, or returns ctx.Err() if ctx is done first.
+func (g *pageGroup) do(ctx context.Context, key string, fn func()) (bool, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]chan struct{})
+	}
+	if done, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-done:
+			return false, nil
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+	done := make(chan struct{})
+	g.calls[key] = done
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(done)
+	}()
+	fn()
+	return true, nil
+}
+
+// WithDistributedLock extends the coalescing of cache misses to every process
+// sharing the store, for deployments with many replicas. The request
+// regenerating a page holds a lock in the store for up to ttl, acquired with
+// persistence.TryLock: SET NX PX with Redis. Requests of other processes
+// missing the page meanwhile wait up to wait for it to be cached, then
+// regenerate it themselves. Set ttl above the time the handler takes.
+func WithDistributedLock(ttl, wait time.Duration) PageOption {
+	return func(o *pageOptions) {
+		o.lockTTL = ttl
+		o.lockWait = wait
+	}
+}
+
+// generateLocked runs generate while holding the distributed lock of the page
+// at key, if enabled. If another process holds the lock, it waits for the
+// page to be cached, and returns false after reading it into cache. It
+// returns the error of the context of the request if it is done before the
+// page is cached or generated, without generating it.
+func (o pageOptions) generateLocked(store persistence.CacheStore, c *gin.Context, key string, cache *responseCache, generate func()) (bool, error) {
+	ctx := c.Request.Context()
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if o.lockTTL <= 0 {
+		generate()
+		return true, nil
+	}
+	lockKey := key + lockSuffix
+	deadline := time.Now().Add(o.lockWait)
+	for {
+		token, acquired, err := persistence.TryLock(store, lockKey, o.lockTTL)
+		if err != nil {
+			o.logError(lockKey, err)
+			generate()
+			return true, nil
+		}
+		if acquired {
+			defer func() {
+				if err := persistence.Unlock(store, lockKey, token); err != nil {
+					o.logError(lockKey, err)
+				}
+			}()
+			// The page may have been cached by the previous holder
+			if err := store.Get(key, cache); err == nil {
+				return false, nil
+			}
+			generate()
+			return true, nil
+		}
+
+		if err := store.Get(key, cache); err == nil {
+			return false, nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			generate()
+			return true, nil
+		}
+		if remaining > lockPollInterval {
+			remaining = lockPollInterval
+		}
+		select {
+		case <-time.After(remaining):
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
+)
+
+// WithETag stores an ETag and a Last-Modified header along with cached
+// responses, unless the handler set them, and answers conditional requests
+// (If-None-Match, If-Modified-Since) for cached pages with 304 Not Modified,
+// without the body. The response generating a page is sent without them.
+func WithETag() PageOption {
+	return func(o *pageOptions) {
+		o.etag = true
+	}
+}
+
+// addValidators adds an ETag and a Last-Modified header to the response
+// cached at key
+func addValidators(store persistence.CacheStore, key string, expire time.Duration) {
+	var cache responseCache
+	if err := store.Get(key, &cache); err != nil {
+		return
+	}
+	header := cache.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if header.Get("ETag") == "" {
+		sum := sha1.Sum(cache.Data)
+		header.Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	}
+	if header.Get("Last-Modified") == "" {
+		header.Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	}
+	cache.Header = header
+	store.Set(key, cache, expire)
+}
+
+// notModified reports whether the conditional request in c is satisfied by
+// the cached response, following RFC 7232: If-None-Match takes precedence
+// over If-Modified-Since
+func notModified(c *gin.Context, cache responseCache) bool {
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		etag := cache.Header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(c.GetHeader("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(cache.Header.Get("Last-Modified"))
+	return err == nil && !modified.After(since)
+}
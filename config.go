@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
+)
+
+// withHandledKey is the gin context key set once the handlers following a
+// With middleware run, to tell a generated page from a cached one
+const withHandledKey = "gincontrib.cache.handled"
+
+// Config declares how the pages of a route or route group are cached, to be
+// attached with With. A Config is built from NewConfig by chaining its
+// methods, each returning an updated copy, so that a Config shared by a group
+// can be refined per route without changing it:
+//
+//	products := cache.NewConfig(store, time.Hour).Tags("products").Vary("Accept-Language")
+//	router.GET("/products", cache.With(products), listProducts)
+//	router.GET("/products/:id", cache.With(products.TTL(10*time.Minute)), getProduct)
+type Config struct {
+	store       persistence.CacheStore
+	expire      time.Duration
+	keyFunc     KeyFunc
+	vary        []string
+	statusCodes []int
+	tags        []string
+	options     []PageOption
+}
+
+// NewConfig returns a Config caching pages in store for expire, the way
+// CachePage does without options
+func NewConfig(store persistence.CacheStore, expire time.Duration) Config {
+	return Config{store: store, expire: expire}
+}
+
+// TTL sets how long pages are cached
+func (cfg Config) TTL(expire time.Duration) Config {
+	cfg.expire = expire
+	return cfg
+}
+
+// KeyFunc sets the function computing the cache key of pages (see
+// WithKeyFunc)
+func (cfg Config) KeyFunc(keyFunc KeyFunc) Config {
+	cfg.keyFunc = keyFunc
+	return cfg
+}
+
+// Vary adds request headers pages are cached per value of (see WithVary)
+func (cfg Config) Vary(headers ...string) Config {
+	cfg.vary = append(cfg.vary[:len(cfg.vary):len(cfg.vary)], headers...)
+	return cfg
+}
+
+// StatusCodes sets the status codes of the responses cached (see
+// WithStatusCodes)
+func (cfg Config) StatusCodes(codes ...int) Config {
+	cfg.statusCodes = append([]int{}, codes...)
+	return cfg
+}
+
+// Tags adds tags to the pages cached (see TagPage)
+func (cfg Config) Tags(tags ...string) Config {
+	cfg.tags = append(cfg.tags[:len(cfg.tags):len(cfg.tags)], tags...)
+	return cfg
+}
+
+// Options adds PageOption, applied after the settings of the other methods
+func (cfg Config) Options(opts ...PageOption) Config {
+	cfg.options = append(cfg.options[:len(cfg.options):len(cfg.options)], opts...)
+	return cfg
+}
+
+// pageOptions returns the options CachePage is given for cfg
+func (cfg Config) pageOptions() pageOptions {
+	var opts []PageOption
+	if cfg.keyFunc != nil {
+		opts = append(opts, WithKeyFunc(cfg.keyFunc))
+	}
+	if len(cfg.vary) > 0 {
+		opts = append(opts, WithVary(cfg.vary...))
+	}
+	if cfg.statusCodes != nil {
+		opts = append(opts, WithStatusCodes(cfg.statusCodes...))
+	}
+	return newPageOptions(append(opts, cfg.options...))
+}
+
+// With Middleware
+//
+// With caches the pages generated by the handlers following it as
+// configured by cfg, like CachePage wrapping them would. On a hit, the
+// cached page is served and the following handlers are skipped. It can be
+// attached to a single route or to a route group with Use.
+func With(cfg Config) gin.HandlerFunc {
+	tags := cfg.tags
+	page := cachePage(cfg.store, cfg.expire, func(c *gin.Context) {
+		c.Set(withHandledKey, true)
+		TagPage(c, tags...)
+		c.Next()
+	}, cfg.pageOptions())
+	return func(c *gin.Context) {
+		c.Set(withHandledKey, false)
+		page(c)
+		if !c.GetBool(withHandledKey) {
+			c.Abort()
+		}
+	}
+}
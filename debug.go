@@ -0,0 +1,192 @@
+package cache
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
+)
+
+// PageStats records the hits, misses and errors of the pages cached by
+// CachePage per route, and the most requested keys, for DebugHandler. Unlike
+// PageMetrics, it needs no metrics stack to be inspected.
+type PageStats struct {
+	mu     sync.Mutex
+	routes map[string]*RouteStats
+	keys   map[string]uint64
+	topN   int
+}
+
+// RouteStats is the activity of the pages of a route
+type RouteStats struct {
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	Errors   uint64  `json:"errors"`
+	HitRatio float64 `json:"hit_ratio"`
+}
+
+// HotKey is a key among the most requested ones, with an estimate of its
+// number of requests
+type HotKey struct {
+	Key      string `json:"key"`
+	Requests uint64 `json:"requests"`
+}
+
+// NewPageStats returns a PageStats reporting the topN most requested keys.
+// Keys are counted approximately, keeping 10 times topN candidates, so that
+// the memory used does not grow with the number of pages.
+func NewPageStats(topN int) *PageStats {
+	return &PageStats{routes: make(map[string]*RouteStats), keys: make(map[string]uint64), topN: topN}
+}
+
+// WithDebugStats records the hits, misses and errors of the pages in stats
+func WithDebugStats(stats *PageStats) PageOption {
+	return func(o *pageOptions) {
+		o.debug = stats
+	}
+}
+
+// Routes returns the activity of every route, keyed by route pattern
+func (s *PageStats) Routes() map[string]RouteStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	routes := make(map[string]RouteStats, len(s.routes))
+	for route, stats := range s.routes {
+		r := *stats
+		if r.Hits+r.Misses > 0 {
+			r.HitRatio = float64(r.Hits) / float64(r.Hits+r.Misses)
+		}
+		routes[route] = r
+	}
+	return routes
+}
+
+// HotKeys returns the most requested keys, most requested first
+func (s *PageStats) HotKeys() []HotKey {
+	s.mu.Lock()
+	keys := make([]HotKey, 0, len(s.keys))
+	for key, n := range s.keys {
+		keys = append(keys, HotKey{Key: key, Requests: n})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Requests != keys[j].Requests {
+			return keys[i].Requests > keys[j].Requests
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if len(keys) > s.topN {
+		keys = keys[:s.topN]
+	}
+	return keys
+}
+
+// The recording methods do nothing on a nil PageStats, which is what
+// CachePage uses
+
+func (s *PageStats) hit(c *gin.Context, key string) {
+	if s != nil {
+		s.record(c, key, func(r *RouteStats) { r.Hits++ })
+	}
+}
+
+func (s *PageStats) miss(c *gin.Context, key string) {
+	if s != nil {
+		s.record(c, key, func(r *RouteStats) { r.Misses++ })
+	}
+}
+
+func (s *PageStats) failed(c *gin.Context) {
+	if s != nil {
+		s.record(c, "", func(r *RouteStats) { r.Errors++ })
+	}
+}
+
+// record updates the stats of the route of c, and counts a request of key
+// unless it is empty
+func (s *PageStats) record(c *gin.Context, key string, update func(*RouteStats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	route := s.routes[c.FullPath()]
+	if route == nil {
+		route = &RouteStats{}
+		s.routes[c.FullPath()] = route
+	}
+	update(route)
+	if key != "" {
+		s.countKey(key)
+	}
+}
+
+// countKey counts a request of key with the Space-Saving algorithm: when the
+// candidates are full, the least requested one is replaced by key, which
+// inherits its count. Counts overestimate by at most that inherited count.
+func (s *PageStats) countKey(key string) {
+	if _, ok := s.keys[key]; ok || len(s.keys) < 10*s.topN {
+		s.keys[key]++
+		return
+	}
+	var minKey string
+	var min uint64
+	for k, n := range s.keys {
+		if minKey == "" || n < min {
+			minKey, min = k, n
+		}
+	}
+	delete(s.keys, minKey)
+	s.keys[key] = min + 1
+}
+
+// StoreDebug is the activity of a store reported by DebugHandler, see
+// persistence.Stats
+type StoreDebug struct {
+	Hits      uint64  `json:"hits"`
+	Misses    uint64  `json:"misses"`
+	Sets      uint64  `json:"sets"`
+	Evictions uint64  `json:"evictions"`
+	Entries   int     `json:"entries"`
+	Bytes     int64   `json:"bytes"`
+	HitRatio  float64 `json:"hit_ratio"`
+}
+
+// Debug is the response of DebugHandler
+type Debug struct {
+	Routes  map[string]RouteStats `json:"routes"`
+	HotKeys []HotKey              `json:"hot_keys"`
+	Stores  map[string]StoreDebug `json:"stores"`
+}
+
+// DebugHandler returns a handler answering the Debug state of the pages
+// recorded in stats and of stores, keyed by name, as JSON, to triage caching
+// in production without a metrics stack. Stores which are not a
+// persistence.StatsStore, like a persistence.CountingStore wrapping a remote
+// one, are left out. As keys and errors are exposed, the handler should not
+// be publicly reachable. stats may be nil to only report stores.
+func DebugHandler(stats *PageStats, stores map[string]persistence.CacheStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		debug := Debug{Stores: make(map[string]StoreDebug, len(stores))}
+		if stats != nil {
+			debug.Routes, debug.HotKeys = stats.Routes(), stats.HotKeys()
+		}
+		for name, store := range stores {
+			statsStore, ok := store.(persistence.StatsStore)
+			if !ok {
+				continue
+			}
+			s := statsStore.Stats()
+			debug.Stores[name] = StoreDebug{
+				Hits:      s.Hits,
+				Misses:    s.Misses,
+				Sets:      s.Sets,
+				Evictions: s.Evictions,
+				Entries:   s.Entries,
+				Bytes:     s.Bytes,
+				HitRatio:  s.HitRatio(),
+			}
+		}
+		c.JSON(http.StatusOK, debug)
+	}
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mlsen/cache/persistence"
+)
+
+// DiagnosticHeaders names the headers CachePage adds to responses when set
+// with WithDiagnosticHeaders. A header with an empty name is omitted.
+type DiagnosticHeaders struct {
+	// Cache is set to HIT for responses served from the cache, and to MISS
+	// for generated ones
+	Cache string
+	// Age is set to the number of seconds since the page was cached
+	Age string
+	// Expires is set to the time the page expires from the cache. It is only
+	// known for stores implementing persistence.TTLStore.
+	Expires string
+}
+
+// DefaultDiagnosticHeaders are the usual names of the diagnostic headers
+var DefaultDiagnosticHeaders = DiagnosticHeaders{Cache: "X-Cache", Age: "Age", Expires: "Expires"}
+
+// WithDiagnosticHeaders adds the given headers to responses, telling clients
+// and proxies whether and for how long a page was cached. They replace the
+// headers of the same name set by the handler.
+func WithDiagnosticHeaders(headers DiagnosticHeaders) PageOption {
+	return func(o *pageOptions) {
+		o.diagnostics = headers
+	}
+}
+
+// get reads the page cached at key into cache, and returns its time to live
+// if the Expires header needs it and the store can tell it, 0 otherwise.
+// Pages cached before responses were stored in an envelope are read too.
+func (o pageOptions) get(store persistence.CacheStore, key string, cache *responseCache) (ttl time.Duration, err error) {
+	if ttlStore, ok := store.(persistence.TTLStore); ok && o.diagnostics.Expires != "" {
+		ttl, err = ttlStore.GetWithTTL(key, cache)
+	} else {
+		err = store.Get(key, cache)
+	}
+	if err != nil && err != persistence.ErrCacheMiss {
+		return 0, getLegacy(store, key, cache, err)
+	}
+	return ttl, err
+}
+
+// markMiss adds the diagnostic headers of a generated response to header
+func (o pageOptions) markMiss(header http.Header) {
+	if o.diagnostics.Cache != "" {
+		header.Set(o.diagnostics.Cache, "MISS")
+	}
+}
+
+// markHit adds the diagnostic headers of cache, served with ttl left before
+// it expires (0 if unknown), to header
+func (o pageOptions) markHit(header http.Header, cache responseCache, ttl time.Duration) {
+	d := o.diagnostics
+	if d.Cache != "" {
+		header.Set(d.Cache, "HIT")
+	}
+	if d.Age != "" && !cache.Stored.IsZero() {
+		age := time.Since(cache.Stored)
+		if age < 0 {
+			age = 0
+		}
+		header.Set(d.Age, strconv.FormatInt(int64(age/time.Second), 10))
+	}
+	if d.Expires != "" && ttl > 0 {
+		header.Set(d.Expires, time.Now().Add(ttl).UTC().Format(http.TimeFormat))
+	}
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DirectiveFunc reports whether the request in c carries a directive, such
+// as bypassing the cache
+type DirectiveFunc func(c *gin.Context) bool
+
+// HeaderDirective returns a DirectiveFunc matching the requests whose header
+// name is secret, or has any value if secret is empty
+func HeaderDirective(name, secret string) DirectiveFunc {
+	return func(c *gin.Context) bool {
+		values, ok := c.Request.Header[http.CanonicalHeaderKey(name)]
+		return ok && matchSecret(values[0], secret)
+	}
+}
+
+// QueryDirective returns a DirectiveFunc matching the requests whose query
+// parameter name is secret, or has any value if secret is empty. The
+// parameter is removed from the request, whether it matches or not, so that
+// the page is keyed and generated without it.
+func QueryDirective(name, secret string) DirectiveFunc {
+	return func(c *gin.Context) bool {
+		u := c.Request.URL
+		if u.RawQuery == "" {
+			return false
+		}
+		query, err := url.ParseQuery(u.RawQuery)
+		if err != nil {
+			return false
+		}
+		values, ok := query[name]
+		if !ok {
+			return false
+		}
+		query.Del(name)
+		u.RawQuery = query.Encode()
+		return matchSecret(values[0], secret)
+	}
+}
+
+func matchSecret(value, secret string) bool {
+	return secret == "" || subtle.ConstantTimeCompare([]byte(value), []byte(secret)) == 1
+}
+
+// WithBypass answers the requests matching bypass with the handler, without
+// reading or writing the cache, e.g. to check from production what a page
+// looks like uncached. Use a secret, so that clients cannot overload the
+// handler:
+//
+//	CachePage(store, time.Hour, handler, WithBypass(HeaderDirective("X-Cache-Bypass", secret)))
+func WithBypass(bypass DirectiveFunc) PageOption {
+	return func(o *pageOptions) {
+		o.bypass = bypass
+	}
+}
+
+// WithRefresh regenerates the page requested by the requests matching
+// refresh, overwriting the cached one, e.g. to replace stale content without
+// flushing the cache. Like with WithBypass, use a secret.
+func WithRefresh(refresh DirectiveFunc) PageOption {
+	return func(o *pageOptions) {
+		o.refresh = refresh
+	}
+}
+
+// directives applies the directives of the request in c, returning whether
+// it bypasses the cache and whether it refreshes the page
+func (o pageOptions) directives(c *gin.Context) (bypass, refresh bool) {
+	if o.bypass != nil && o.bypass(c) {
+		return true, false
+	}
+	return false, o.refresh != nil && o.refresh(c)
+}
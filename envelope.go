@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/mlsen/cache/utils"
+)
+
+// envelopeMagic starts the envelope of cached responses, followed by its
+// version. Like the headers of the codecs of utils, it is never produced by
+// gob, JSON or MessagePack.
+const envelopeMagic = 0xc4
+
+// envelopeVersion is the version of the envelope written by this version
+const envelopeVersion = 2
+
+// envelopeVersion1 is the version of the envelope written before the delta
+// and the expiration of responses were, which is still decoded
+const envelopeVersion1 = 1
+
+// envelopeCompressMinLength is the size from which bodies are compressed in
+// the envelope, unless the handler already encoded them
+const envelopeCompressMinLength = 1024
+
+var (
+	errEnvelopeMagic   = errors.New("cache: cached response is not in an envelope.")
+	errEnvelopeVersion = errors.New("cache: no decoder for the version of the cached response envelope.")
+	errEnvelopeFormat  = errors.New("cache: cached response envelope is malformed.")
+)
+
+// MarshalBinary encodes the response in a versioned envelope, which stores
+// that serialize values, such as RedisStore with the gob codec, write in
+// place of the fields of the struct. Version 2 holds, after the magic byte
+// and the version:
+//
+//	compression  byte, 0 or utils.Snappy
+//	stored       varint, Unix nanoseconds, 0 if unknown
+//	delta        varint, nanoseconds
+//	expires      varint, Unix nanoseconds, 0 if unknown
+//	status       uvarint
+//	headers      uvarint count, then for each the name and uvarint count of
+//	             values, then the values, strings being prefixed by their
+//	             uvarint length
+//	body         the rest, compressed with compression
+//
+// Version 1 has neither delta nor expires, which are zero once decoded.
+// Decoding an envelope of another version fails instead of misreading it,
+// unless a decoder is registered for it with RegisterResponseDecoder.
+func (r responseCache) MarshalBinary() ([]byte, error) {
+	compression := byte(0)
+	body := r.Data
+	if len(body) >= envelopeCompressMinLength && r.Header.Get("Content-Encoding") == "" {
+		compression, body = byte(utils.Snappy), snappy.Encode(nil, body)
+	}
+	var stored, expires int64
+	if !r.Stored.IsZero() {
+		stored = r.Stored.UnixNano()
+	}
+	if !r.Expires.IsZero() {
+		expires = r.Expires.UnixNano()
+	}
+
+	names := make([]string, 0, len(r.Header))
+	for name := range r.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b := make([]byte, 0, 64+len(body))
+	b = append(b, envelopeMagic, envelopeVersion, compression)
+	b = appendVarint(b, stored)
+	b = appendVarint(b, int64(r.Delta))
+	b = appendVarint(b, expires)
+	b = appendUvarint(b, uint64(r.Status))
+	b = appendUvarint(b, uint64(len(names)))
+	for _, name := range names {
+		b = appendEnvelopeString(b, name)
+		b = appendUvarint(b, uint64(len(r.Header[name])))
+		for _, value := range r.Header[name] {
+			b = appendEnvelopeString(b, value)
+		}
+	}
+	return append(b, body...), nil
+}
+
+// UnmarshalBinary decodes an envelope written by MarshalBinary
+func (r *responseCache) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 || data[0] != envelopeMagic {
+		return errEnvelopeMagic
+	}
+	version := data[1]
+	if version != envelopeVersion && version != envelopeVersion1 {
+		decoder := lookupResponseDecoder(data[1])
+		if decoder == nil {
+			return errEnvelopeVersion
+		}
+		decoded, err := decoder(data)
+		if err != nil {
+			return err
+		}
+		*r = responseCache{Status: decoded.Status, Header: decoded.Header, Data: decoded.Body, Stored: decoded.Stored, upgraded: true}
+		return nil
+	}
+	d := envelopeDecoder{data: data[2:]}
+	compression := d.byte()
+	stored := d.varint()
+	var delta, expires int64
+	if version != envelopeVersion1 {
+		delta, expires = d.varint(), d.varint()
+	}
+	status := d.uvarint()
+	var header http.Header
+	if n := d.uvarint(); n > 0 && d.err == nil {
+		header = make(http.Header)
+		for i := uint64(0); i < n && d.err == nil; i++ {
+			name := d.string()
+			values := make([]string, 0)
+			for j, m := uint64(0), d.uvarint(); j < m && d.err == nil; j++ {
+				values = append(values, d.string())
+			}
+			header[name] = values
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	body := d.data
+	switch utils.Compression(compression) {
+	case 0:
+		body = append([]byte(nil), body...)
+	case utils.Snappy:
+		var err error
+		if body, err = snappy.Decode(nil, body); err != nil {
+			return err
+		}
+	default:
+		return utils.ErrUnknownCodec
+	}
+	*r = responseCache{Status: int(status), Header: header, Data: body, Delta: time.Duration(delta), upgraded: version != envelopeVersion}
+	if stored != 0 {
+		r.Stored = time.Unix(0, stored)
+	}
+	if expires != 0 {
+		r.Expires = time.Unix(0, expires)
+	}
+	return nil
+}
+
+// appendVarint and appendUvarint stand for binary.AppendVarint and
+// binary.AppendUvarint, which need Go 1.19
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendEnvelopeString(b []byte, s string) []byte {
+	b = appendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// envelopeDecoder reads the fields of an envelope from data, recording the
+// first error in err, after which it returns zero values
+type envelopeDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *envelopeDecoder) byte() byte {
+	if d.err != nil || len(d.data) == 0 {
+		d.err = errEnvelopeFormat
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *envelopeDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.data)
+	if n <= 0 {
+		d.err = errEnvelopeFormat
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *envelopeDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errEnvelopeFormat
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *envelopeDecoder) string() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if n > uint64(len(d.data)) {
+		d.err = errEnvelopeFormat
+		return ""
+	}
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
+)
+
+// ErrorPolicy decides how CachePage serves requests when the store fails to
+// read their page, see WithErrorPolicy
+type ErrorPolicy struct {
+	closed   bool
+	fallback persistence.CacheStore
+}
+
+var (
+	// FailOpen serves requests as cache misses: the handler runs and its
+	// response is cached if the store recovered. It is the default.
+	FailOpen = ErrorPolicy{}
+	// FailClosed answers requests with 503 Service Unavailable, so that a
+	// failing cache does not send the whole load to the handler.
+	FailClosed = ErrorPolicy{closed: true}
+)
+
+// FallbackTo serves requests with fallback, typically a local InMemoryStore,
+// in place of the failing store: the page is read from it, and cached in it
+// if it misses there too
+func FallbackTo(fallback persistence.CacheStore) ErrorPolicy {
+	return ErrorPolicy{fallback: fallback}
+}
+
+// WithErrorPolicy sets how requests are served when the store fails to read
+// their page. Whatever the policy, the errors of the store are logged, see
+// WithLogger, and attached to the gin context as private errors, so that
+// they reach the loggers and recovery middlewares reading c.Errors.
+func WithErrorPolicy(policy ErrorPolicy) PageOption {
+	return func(o *pageOptions) {
+		o.errorPolicy = policy
+	}
+}
+
+// storeErrorMeta is the metadata of the errors of the store attached to gin
+// contexts, which tells them apart from the errors of the handler
+type storeErrorMeta struct {
+	Key string
+}
+
+// storeError reports err, returned by the store for the page at key of the
+// request c
+func (o pageOptions) storeError(c *gin.Context, key string, err error) {
+	c.Error(err).SetType(gin.ErrorTypePrivate).SetMeta(storeErrorMeta{Key: key})
+	o.debug.failed(c)
+	o.logError(key, err)
+}
+
+// handlerErrors returns the number of errors the handler attached to c,
+// leaving out those of the store
+func handlerErrors(c *gin.Context) int {
+	n := 0
+	for _, err := range c.Errors {
+		if _, ok := err.Meta.(storeErrorMeta); !ok {
+			n++
+		}
+	}
+	return n
+}
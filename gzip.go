@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"hash/crc32"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
+)
+
+// gzipSuffix is appended to the key of a page to store its gzip-compressed
+// body
+const gzipSuffix = ":gzip"
+
+// gzipMinLength is the size under which bodies are not worth compressing
+const gzipMinLength = 256
+
+// gzipResponseCache is the gzip-compressed body of a cached response. Sum is
+// the checksum of the uncompressed body, so that a body compressed from a
+// response since replaced is not served.
+type gzipResponseCache struct {
+	Sum  uint32
+	Data []byte
+}
+
+// WithGzip serves cached pages gzip-compressed, at the given compress/gzip
+// level, to clients accepting it. The body is compressed on the first
+// request asking for it, and the result is cached along with the page.
+// Responses are marked as varying on Accept-Encoding. Pages the handler
+// already encoded, and bodies smaller than 256 bytes, are served as is.
+//
+// Use it in place of a gzip middleware on cached routes: such middlewares
+// would compress the compressed body again.
+func WithGzip(level int) PageOption {
+	return func(o *pageOptions) {
+		if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+			level = gzip.DefaultCompression
+		}
+		o.gzip = true
+		o.gzipLevel = level
+	}
+}
+
+// gzipBody returns the body of cache to send to the client of c, setting the
+// headers of a compressed response if it is compressed. The compressed body
+// is read from or cached in store at key+gzipSuffix, for expire.
+func (o pageOptions) gzipBody(store persistence.CacheStore, c *gin.Context, key string, cache responseCache, expire time.Duration) []byte {
+	header := c.Writer.Header()
+	if len(cache.Data) < gzipMinLength || header.Get("Content-Encoding") != "" || !acceptsGzip(c.Request) {
+		return cache.Data
+	}
+
+	sum := crc32.ChecksumIEEE(cache.Data)
+	var compressed gzipResponseCache
+	if err := store.Get(key+gzipSuffix, &compressed); err != nil || compressed.Sum != sum {
+		var buf bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&buf, o.gzipLevel)
+		zw.Write(cache.Data)
+		if err := zw.Close(); err != nil {
+			log.Println(err.Error())
+			return cache.Data
+		}
+		compressed = gzipResponseCache{Sum: sum, Data: buf.Bytes()}
+		if err := store.Set(key+gzipSuffix, compressed, expire); err != nil {
+			log.Println(err.Error())
+		}
+	}
+
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	addVary(header, "Accept-Encoding")
+	return compressed.Data
+}
+
+// addVary adds name to the Vary header, unless it is already listed
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, h := range strings.Split(value, ",") {
+			if h = strings.TrimSpace(h); h == "*" || strings.EqualFold(h, name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of r allows a gzip
+// response
+func acceptsGzip(r *http.Request) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			coding, q := parseCoding(part)
+			switch coding {
+			case "gzip", "x-gzip":
+				gzipQ = q
+			case "*":
+				anyQ = q
+			}
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+// parseCoding parses an element of Accept-Encoding, such as "gzip;q=0.8",
+// into its lowercased content coding and quality value
+func parseCoding(part string) (coding string, q float64) {
+	q = 1
+	params := strings.Split(part, ";")
+	coding = strings.ToLower(strings.TrimSpace(params[0]))
+	for _, param := range params[1:] {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(param, "q=") {
+			if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+				q = v
+			}
+		}
+	}
+	return coding, q
+}
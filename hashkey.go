@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+)
+
+// hashedKeyInfix follows PageCachePrefix in the keys hashed by
+// CreateHashedKey
+const hashedKeyInfix = ":sha256:"
+
+// WithHashedKeys keys the pages whose key would be longer than maxLength
+// bytes with a SHA-256 hash of the request URI, as CreateHashedKey does,
+// instead of the URL-escaped request URI. Memcached rejects keys longer than
+// 250 bytes or holding control characters, which CreateKey produces for long
+// URIs, so pages requested with long query strings are otherwise not cached,
+// and long keys waste memory in Redis. With WithKeyFunc, the keys it returns
+// are hashed. The keys of the variants of a page, such as those cached per
+// Vary header, append to its key: leave room for them.
+//
+// Pages whose key is hashed are purged by key:
+//
+//	store.Delete(CreateHashedKey(uri, maxLength))
+func WithHashedKeys(maxLength int) PageOption {
+	return func(o *pageOptions) {
+		o.hashedKeys = maxLength
+	}
+}
+
+// CreateHashedKey creates a package specific key for a given string, like
+// CreateKey, unless it would be longer than maxLength bytes, in which case
+// it is PageCachePrefix followed by ":sha256:" and the hex-encoded SHA-256
+// hash of u
+func CreateHashedKey(u string, maxLength int) string {
+	if key := PageCachePrefix + ":" + url.QueryEscape(u); len(key) <= maxLength {
+		return key
+	}
+	return hashKey(u)
+}
+
+// hashKey returns the key hashing s
+func hashKey(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return PageCachePrefix + hashedKeyInfix + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,66 @@
+package cache
+
+import "net/http"
+
+// DefaultDeniedHeaders are the response headers not cached unless
+// WithHeaderDenyList says otherwise. They carry state of the client a
+// response was generated for, which must not be replayed to the other
+// clients hitting the cache: a cached Set-Cookie would hand the session of
+// one user to everyone.
+var DefaultDeniedHeaders = []string{"Set-Cookie", "Set-Cookie2", "Authentication-Info", "Proxy-Authentication-Info"}
+
+// headerFilter selects the response headers stored with cached pages. Its
+// zero value denies DefaultDeniedHeaders.
+type headerFilter struct {
+	// allow, if not nil, holds the only headers stored
+	allow map[string]bool
+	// deny holds the headers not stored, in place of DefaultDeniedHeaders
+	// if custom is set
+	deny   map[string]bool
+	custom bool
+}
+
+// WithHeaderAllowList only caches the response headers named, e.g.
+// Content-Type. Headers denied by default or with WithHeaderDenyList are not
+// cached even if listed.
+func WithHeaderAllowList(names ...string) PageOption {
+	return func(o *pageOptions) {
+		o.headers.allow = headerSet(names)
+	}
+}
+
+// WithHeaderDenyList caches every response header but those named, in place
+// of DefaultDeniedHeaders. To deny more headers, list them along with
+// DefaultDeniedHeaders. Without names, every header is cached, including
+// Set-Cookie.
+func WithHeaderDenyList(names ...string) PageOption {
+	return func(o *pageOptions) {
+		o.headers.deny = headerSet(names)
+		o.headers.custom = true
+	}
+}
+
+func headerSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[http.CanonicalHeaderKey(name)] = true
+	}
+	return set
+}
+
+// filter returns the headers of header to store with a cached response
+func (f headerFilter) filter(header http.Header) http.Header {
+	deny := f.deny
+	if !f.custom {
+		deny = headerSet(DefaultDeniedHeaders)
+	}
+	stored := make(http.Header, len(header))
+	for name, values := range header {
+		canonical := http.CanonicalHeaderKey(name)
+		if deny[canonical] || (f.allow != nil && !f.allow[canonical]) {
+			continue
+		}
+		stored[name] = append([]string(nil), values...)
+	}
+	return stored
+}
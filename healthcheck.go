@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
+)
+
+// StoreHealth is the state of a store reported by Healthcheck
+type StoreHealth struct {
+	// Status is "ok" if the store reached its backend, "unavailable"
+	// otherwise
+	Status string `json:"status"`
+	// Latency is the time the check took, in milliseconds
+	Latency float64 `json:"latency_ms"`
+	// Error is the error of the check, if any
+	Error string `json:"error,omitempty"`
+}
+
+// Health is the response of Healthcheck
+type Health struct {
+	// Status is "ok" if every store is, "unavailable" otherwise
+	Status string                 `json:"status"`
+	Stores map[string]StoreHealth `json:"stores"`
+}
+
+// Healthcheck returns a handler checking that every store reaches its
+// backend with persistence.Ping, concurrently. It answers the Health of the
+// stores, keyed by name, as JSON, with 200 OK if all of them are available
+// and 503 Service Unavailable otherwise, so that it can serve a Kubernetes
+// readiness probe:
+//
+//	router.GET("/healthz", cache.Healthcheck(map[string]persistence.CacheStore{"redis": store}, time.Second))
+//
+// A check fails if it takes longer than timeout, unless it is 0, or once the
+// request is canceled.
+func Healthcheck(stores map[string]persistence.CacheStore, timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		health := Health{Status: "ok", Stores: make(map[string]StoreHealth, len(stores))}
+		var mu sync.Mutex
+		var checks sync.WaitGroup
+		for name, store := range stores {
+			checks.Add(1)
+			go func(name string, store persistence.CacheStore) {
+				defer checks.Done()
+				started := time.Now()
+				err := persistence.Ping(ctx, store)
+				state := StoreHealth{Status: "ok", Latency: float64(time.Since(started)) / float64(time.Millisecond)}
+				if err != nil {
+					state.Status = "unavailable"
+					state.Error = err.Error()
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				health.Stores[name] = state
+				if err != nil {
+					health.Status = "unavailable"
+				}
+			}(name, store)
+		}
+		checks.Wait()
+
+		status := http.StatusOK
+		if health.Status != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, health)
+	}
+}
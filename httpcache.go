@@ -0,0 +1,314 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
+)
+
+// heuristicWarning and heuristicWarningAge are the Warning header RFC 7234
+// adds to responses older than a day whose freshness lifetime was computed
+// heuristically
+const (
+	heuristicWarning    = `113 - "Heuristic Expiration"`
+	heuristicWarningAge = 24 * time.Hour
+)
+
+// heuristicFraction is the fraction of the time since the Last-Modified date
+// of a response used as its heuristic freshness lifetime, as RFC 9111
+// suggests
+const heuristicFraction = 10
+
+// heuristicallyCacheable lists the status codes cacheable by default, which
+// may be cached without an explicit expiration (RFC 9110, section 15.1)
+var heuristicallyCacheable = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// WithHTTPCaching makes SiteCache follow the HTTP caching semantics of RFC
+// 9111, as a shared cache in front of the application would, instead of
+// caching every page for the expiration it is given:
+//
+//   - only responses to GET are stored, and HEAD requests are answered from
+//     them; non-error responses to other methods evict the page
+//   - responses with no-store, private or no-cache, to requests with an
+//     Authorization header unless the response allows it, or varying on
+//     every header ("Vary: *") are not stored
+//   - the freshness lifetime of responses is given by s-maxage, max-age or
+//     Expires. Without any, the lifetime of heuristically cacheable responses
+//     is a tenth of the time since their Last-Modified date, at most the
+//     expiration given to SiteCache, or that expiration if they have no
+//     Last-Modified date
+//   - requests honor no-store, no-cache, max-age, min-fresh, max-stale and
+//     only-if-cached
+//   - responses are served with an Age header, and the Warning headers of
+//     RFC 7234 for stale and old heuristically fresh responses
+//
+// Stale responses are kept maxStale past their freshness lifetime, to answer
+// requests allowing them with max-stale, unless they have must-revalidate,
+// proxy-revalidate or s-maxage. Responses are cached per value of the
+// request headers listed in their Vary header, as with WithResponseVary.
+func WithHTTPCaching(maxStale time.Duration) PageOption {
+	return func(o *pageOptions) {
+		o.httpCaching = true
+		o.httpMaxStale = maxStale
+		o.responseVary = true
+	}
+}
+
+// httpCache is the handler of SiteCache with WithHTTPCaching
+func (o pageOptions) httpCache(store persistence.CacheStore, expire time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		store := persistence.BindContext(c.Request.Context(), store)
+		method := c.Request.Method
+		if method != http.MethodGet && method != http.MethodHead {
+			c.Next()
+			if c.Writer.Status() < http.StatusBadRequest {
+				base := o.key(c)
+				store.Delete(varyKey(base, c.Request, o.varyHeaders(store, base)))
+			}
+			return
+		}
+		request := requestDirectives(c)
+		if _, ok := request["no-store"]; ok {
+			c.Next()
+			return
+		}
+
+		base := o.key(c)
+		headers := o.varyHeaders(store, base)
+		key := varyKey(base, c.Request, headers)
+		if _, ok := request["no-cache"]; !ok {
+			var cache responseCache
+			if err := store.Get(key, &cache); err == nil && o.serveHTTP(c, cache, request, expire) {
+				c.Abort()
+				return
+			}
+		}
+		if _, ok := request["only-if-cached"]; ok {
+			c.AbortWithStatus(http.StatusGatewayTimeout)
+			return
+		}
+		if method == http.MethodHead {
+			c.Next()
+			return
+		}
+		o.storeHTTP(store, c, base, key, headers, expire)
+	}
+}
+
+// serveHTTP answers the request in c with cache, and reports whether it
+// did, which it does not if cache is too old for the request
+func (o pageOptions) serveHTTP(c *gin.Context, cache responseCache, request map[string]string, expire time.Duration) bool {
+	if cache.Stored.IsZero() {
+		return false
+	}
+	response := parseCacheControl(cache.Header.Get("Cache-Control"))
+	lifetime, heuristic := freshnessLifetime(cache, response, expire)
+	age := time.Since(cache.Stored)
+	if age < 0 {
+		age = 0
+	}
+	if value, ok := request["max-age"]; ok {
+		if maxAge, ok := directiveSeconds(value); !ok || age > maxAge {
+			return false
+		}
+	}
+	if value, ok := request["min-fresh"]; ok {
+		if minFresh, ok := directiveSeconds(value); !ok || lifetime-age < minFresh {
+			return false
+		}
+	}
+	stale := age >= lifetime
+	if stale {
+		if !mayServeStale(response) {
+			return false
+		}
+		value, ok := request["max-stale"]
+		if !ok {
+			return false
+		}
+		if value != "" {
+			if maxStale, ok := directiveSeconds(value); !ok || age-lifetime > maxStale {
+				return false
+			}
+		}
+	}
+
+	header := c.Writer.Header()
+	for k, vals := range cache.Header {
+		header[k] = append([]string(nil), vals...)
+	}
+	header.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	if stale {
+		header.Add("Warning", staleWarning)
+	}
+	if heuristic && age > heuristicWarningAge {
+		header.Add("Warning", heuristicWarning)
+	}
+	c.Writer.WriteHeader(cache.Status)
+	if c.Request.Method == http.MethodHead {
+		c.Writer.WriteHeaderNow()
+		return true
+	}
+	c.Writer.Write(cache.Data)
+	return true
+}
+
+// storeHTTP runs the handlers following the middleware, and stores their
+// response if it may be
+func (o pageOptions) storeHTTP(store persistence.CacheStore, c *gin.Context, base, key string, headers []string, expire time.Duration) {
+	requested := time.Now()
+	writer := &recordingWriter{ResponseWriter: c.Writer, limit: o.maxSize, streamLimit: o.streamLimit()}
+	c.Writer = writer
+	c.Next()
+	responded := time.Now()
+	if writer.overflow || !o.storable(c, writer.Status()) {
+		return
+	}
+	key = o.revary(store, c, base, key, headers, expire)
+	if key == "" {
+		return
+	}
+
+	header := o.headers.filter(writer.Header())
+	cache := responseCache{
+		Status: writer.Status(),
+		Header: header,
+		Data:   writer.body.Bytes(),
+		// Stored is backdated by the age of the response, so that its
+		// current age is the time since Stored
+		Stored: responded.Add(-initialAge(header, requested, responded)),
+	}
+	if header.Get("Date") == "" {
+		header.Set("Date", responded.UTC().Format(http.TimeFormat))
+	}
+	response := parseCacheControl(header.Get("Cache-Control"))
+	lifetime, _ := freshnessLifetime(cache, response, expire)
+	ttl := lifetime - responded.Sub(cache.Stored)
+	if mayServeStale(response) {
+		ttl += o.httpMaxStale
+	}
+	if ttl <= 0 {
+		return
+	}
+	if ttl < time.Second {
+		// Stores do not keep items for less than a second; the response is
+		// not served once stale anyway
+		ttl = time.Second
+	}
+	cache.Expires = expiresAt(responded, ttl)
+	if err := store.Set(key, cache, ttl); err != nil {
+		o.storeError(c, key, err)
+	}
+}
+
+// storable reports whether the response generated in c may be stored by a
+// shared cache (RFC 9111, section 3)
+func (o pageOptions) storable(c *gin.Context, status int) bool {
+	if o.skips(c) || status < http.StatusOK {
+		return false
+	}
+	header := c.Writer.Header()
+	response := parseCacheControl(header.Get("Cache-Control"))
+	for _, name := range []string{"no-store", "private", "no-cache"} {
+		if _, ok := response[name]; ok {
+			return false
+		}
+	}
+	_, public := response["public"]
+	_, sMaxAge := response["s-maxage"]
+	if c.GetHeader("Authorization") != "" {
+		_, mustRevalidate := response["must-revalidate"]
+		if !public && !sMaxAge && !mustRevalidate {
+			return false
+		}
+	}
+	_, maxAge := response["max-age"]
+	explicit := sMaxAge || maxAge || header.Get("Expires") != ""
+	return explicit || public || heuristicallyCacheable[status]
+}
+
+// freshnessLifetime returns the freshness lifetime of cache (RFC 9111,
+// section 4.2.1), and whether it was computed heuristically, in which case
+// it is at most expire
+func freshnessLifetime(cache responseCache, response map[string]string, expire time.Duration) (time.Duration, bool) {
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if value, ok := response[name]; ok {
+			lifetime, _ := directiveSeconds(value)
+			return lifetime, false
+		}
+	}
+	date, err := http.ParseTime(cache.Header.Get("Date"))
+	if err != nil {
+		date = cache.Stored
+	}
+	if value := cache.Header.Get("Expires"); value != "" {
+		// Invalid dates, such as "0", mean the response is already expired
+		expires, err := http.ParseTime(value)
+		if err != nil || expires.Before(date) {
+			return 0, false
+		}
+		return expires.Sub(date), false
+	}
+	if lastModified, err := http.ParseTime(cache.Header.Get("Last-Modified")); err == nil {
+		if lifetime := date.Sub(lastModified) / heuristicFraction; lifetime < expire {
+			if lifetime < 0 {
+				lifetime = 0
+			}
+			return lifetime, true
+		}
+	}
+	return expire, true
+}
+
+// initialAge returns the age of a response with the given header, requested
+// and received at the given times (RFC 9111, section 4.2.3)
+func initialAge(header http.Header, requested, responded time.Time) time.Duration {
+	var apparent time.Duration
+	if date, err := http.ParseTime(header.Get("Date")); err == nil && responded.After(date) {
+		apparent = responded.Sub(date)
+	}
+	corrected := responded.Sub(requested)
+	if age, ok := directiveSeconds(header.Get("Age")); ok {
+		corrected += age
+	}
+	if apparent > corrected {
+		return apparent
+	}
+	return corrected
+}
+
+// mayServeStale reports whether a response with the given Cache-Control
+// directives may be served stale by a shared cache
+func mayServeStale(response map[string]string) bool {
+	for _, name := range []string{"must-revalidate", "proxy-revalidate", "s-maxage"} {
+		if _, ok := response[name]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// directiveSeconds parses the delta-seconds value of a directive or the Age
+// header
+func directiveSeconds(value string) (time.Duration, bool) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
+)
+
+// identityTagPrefix prefixes the tag of the pages cached for an identity
+const identityTagPrefix = "gincontrib.cache.identity:"
+
+// IdentityFunc returns the identity of the user the page requested in c is
+// generated for, such as a user ID or a session ID, or "" for anonymous
+// requests
+type IdentityFunc func(c *gin.Context) string
+
+// WithIdentity caches pages per identity, as returned by identity, so that
+// routes rendering user-specific content can be cached without serving the
+// page of a user to another. Pages requested anonymously are shared by all
+// anonymous requests. Identities are hashed in keys and tags, so that session
+// IDs used as identities do not appear in the store.
+//
+// The pages cached for an identity are tagged, so that PurgeIdentity evicts
+// them when the store is a persistence.TagStore, e.g. when the user logs out
+// or their permissions change.
+func WithIdentity(identity IdentityFunc) PageOption {
+	return func(o *pageOptions) {
+		o.identity = identity
+	}
+}
+
+// identityKeySuffix returns the suffix of the key of the page requested in c
+// for its identity, and tags the page for PurgeIdentity
+func (o pageOptions) identityKeySuffix(c *gin.Context) string {
+	id := o.identity(c)
+	if id == "" {
+		return ""
+	}
+	hashed := hashIdentity(id)
+	TagPage(c, identityTagPrefix+hashed)
+	return "|identity=" + hashed
+}
+
+// PurgeIdentity evicts the pages cached for identity with WithIdentity. It
+// returns persistence.ErrNotSupport if the store is not a
+// persistence.TagStore.
+func PurgeIdentity(store persistence.CacheStore, identity string) error {
+	return PurgeTag(store, identityTagPrefix+hashIdentity(identity))
+}
+
+func hashIdentity(identity string) string {
+	sum := sha1.Sum([]byte(identity))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
+)
+
+// WithKeyFilter answers the requests for resources that do not exist with a
+// 404 Not Found, without reading the cache or calling the handler. Requests
+// are looked up in filter by the key returned by key, e.g. the ID of the
+// product requested, which the application adds to and removes from filter
+// as its dataset changes:
+//
+//	products := persistence.NewCountingBloomFilter(1000000, 0.01)
+//	for _, id := range productIDs {
+//		products.Add(id)
+//	}
+//	router.GET("/products/:id", CachePage(store, time.Hour, handler, WithKeyFilter(products, func(c *gin.Context) string {
+//		return c.Param("id")
+//	})))
+//
+// As filters report false positives, some requests for missing resources
+// still reach the handler. If the filter fails, requests are served as
+// without it.
+func WithKeyFilter(filter persistence.KeyFilter, key KeyFunc) PageOption {
+	return func(o *pageOptions) {
+		o.keyFilter = filter
+		o.keyFilterFunc = key
+	}
+}
+
+// filtered reports whether the resource requested in c definitely does not
+// exist
+func (o pageOptions) filtered(c *gin.Context) bool {
+	if o.keyFilter == nil {
+		return false
+	}
+	contains, err := o.keyFilter.MayContain(o.keyFilterFunc(c))
+	return err == nil && !contains
+}
+
+// rejectFiltered answers the request in c with a 404 if the resource
+// requested definitely does not exist, and reports whether it did
+func (o pageOptions) rejectFiltered(c *gin.Context) bool {
+	if !o.filtered(c) {
+		return false
+	}
+	c.AbortWithStatus(http.StatusNotFound)
+	return true
+}
@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"log"
+	"time"
+
+	"github.com/mlsen/cache/persistence"
+)
+
+// WithLogger reports the hits, misses and writes of the pages cached by
+// CachePage to logger, with the errors of the store reading or writing them,
+// which are otherwise logged with the log package. Events have the
+// Operation "page". To log every operation of the store, wrap it in a
+// persistence.LoggedStore.
+func WithLogger(logger persistence.Logger) PageOption {
+	return func(o *pageOptions) {
+		o.logger = logger
+	}
+}
+
+// log reports an event of kind for the page at key to the logger, if any
+func (o pageOptions) log(kind, key string, expires time.Duration) {
+	if o.logger != nil {
+		o.logger.Log(persistence.LogEvent{Kind: kind, Operation: "page", Key: key, Expires: expires})
+	}
+}
+
+// logError reports err, returned by the store for the page at key, to the
+// logger, or logs it with the log package
+func (o pageOptions) logError(key string, err error) {
+	if o.logger == nil {
+		log.Println(err.Error())
+		return
+	}
+	o.logger.Log(persistence.LogEvent{Kind: persistence.LogError, Operation: "page", Key: key, Err: err})
+}
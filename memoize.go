@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mlsen/cache/persistence"
+)
+
+// Memoize wraps fn with caching in store: a call returns the result cached
+// at the key keyFn computes from its arguments, or calls fn and caches its
+// result for ttl. Concurrent calls for the same key missing the cache share
+// a single call of fn, run with the context of the first of them, instead of
+// all calling it; the others stop waiting once their context is done.
+//
+// Results are serialized by the store, with its codec. Errors returned by fn
+// are returned as is and not cached; failing to read or write the store is
+// only logged. keyFn should prefix its keys so that they do not collide with
+// those of other functions or pages:
+//
+//	getUser := cache.Memoize(store, time.Minute, func(id int) string {
+//		return "user:" + strconv.Itoa(id)
+//	}, users.Get)
+//	user, err := getUser(ctx, 42)
+func Memoize[A, T any](store persistence.CacheStore, ttl time.Duration, keyFn func(A) string, fn func(context.Context, A) (T, error)) func(context.Context, A) (T, error) {
+	var group memoGroup[T]
+	return func(ctx context.Context, args A) (T, error) {
+		store := persistence.BindContext(ctx, store)
+		key := keyFn(args)
+		var value T
+		err := store.Get(key, &value)
+		if err == nil {
+			return value, nil
+		}
+		if err != persistence.ErrCacheMiss {
+			log.Println(err.Error())
+		}
+
+		return group.do(ctx, key, func() (T, error) {
+			value, err := fn(ctx, args)
+			if err != nil {
+				return value, err
+			}
+			if err := store.Set(key, value, ttl); err != nil {
+				log.Println(err.Error())
+			}
+			return value, nil
+		})
+	}
+}
+
+// memoGroup shares the calls of a memoized function in flight by key
+type memoGroup[T any] struct {
+	mu    sync.Mutex
+	calls map[string]*memoCall[T]
+}
+
+// memoCall is a call in flight, whose result is set before done is closed
+type memoCall[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+// do returns the result of fn, or of the call in flight for key if there is
+// one, unless ctx is done before it returns
+func (g *memoGroup[T]) do(ctx context.Context, key string, fn func() (T, error)) (T, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*memoCall[T])
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.value, call.err
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+	}
+	call := &memoCall[T]{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.value, call.err = fn()
+	return call.value, call.err
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PageMetrics is a prometheus.Collector holding the metrics recorded by
+// CachePageWithMetrics: page hits and misses, and the size of the responses
+// it caches, labelled with the route pattern
+type PageMetrics struct {
+	hits   *prometheus.CounterVec
+	misses *prometheus.CounterVec
+	size   *prometheus.HistogramVec
+}
+
+// NewPageMetrics returns a PageMetrics, to be registered with a
+// prometheus.Registerer
+func NewPageMetrics() *PageMetrics {
+	return &PageMetrics{
+		hits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gincontrib",
+			Subsystem: "cache",
+			Name:      "page_hits_total",
+			Help:      "Number of requests answered from the page cache.",
+		}, []string{"route"}),
+		misses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gincontrib",
+			Subsystem: "cache",
+			Name:      "page_misses_total",
+			Help:      "Number of requests that missed the page cache.",
+		}, []string{"route"}),
+		size: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "gincontrib",
+			Subsystem: "cache",
+			Name:      "page_response_size_bytes",
+			Help:      "Size of the response bodies stored in the page cache.",
+			Buckets:   prometheus.ExponentialBuckets(256, 4, 8),
+		}, []string{"route"}),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (m *PageMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.hits.Describe(ch)
+	m.misses.Describe(ch)
+	m.size.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (m *PageMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.hits.Collect(ch)
+	m.misses.Collect(ch)
+	m.size.Collect(ch)
+}
+
+// The recording methods do nothing on a nil PageMetrics, which is what
+// CachePage uses
+
+func (m *PageMetrics) hit(c *gin.Context) {
+	if m != nil {
+		m.hits.WithLabelValues(c.FullPath()).Inc()
+	}
+}
+
+func (m *PageMetrics) miss(c *gin.Context) {
+	if m != nil {
+		m.misses.WithLabelValues(c.FullPath()).Inc()
+	}
+}
+
+func (m *PageMetrics) cached(c *gin.Context, size int) {
+	if m != nil {
+		m.size.WithLabelValues(c.FullPath()).Observe(float64(size))
+	}
+}
@@ -0,0 +1,27 @@
+package cache
+
+import (
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WithNegotiation caches a response per content type negotiated among offers,
+// such as binding.MIMEJSON and binding.MIMEXML, from the Accept header of the
+// request, for handlers choosing their representation with
+// c.NegotiateFormat. Unlike WithVary("Accept"), requests negotiating the same
+// type share a page whatever the exact Accept header they send. Requests
+// without an Accept header negotiate the first offer, and those accepting
+// none of them share a page of their own. Responses are marked as varying on
+// Accept.
+func WithNegotiation(offers ...string) PageOption {
+	return func(o *pageOptions) {
+		o.negotiate = append(o.negotiate, offers...)
+	}
+}
+
+// negotiationKeySuffix returns the suffix of the key of the page requested in
+// c for the content type it negotiates
+func (o pageOptions) negotiationKeySuffix(c *gin.Context) string {
+	return "|accept=" + url.QueryEscape(c.NegotiateFormat(o.negotiate...))
+}
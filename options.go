@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
+)
+
+// PageOption configures CachePage
+type PageOption func(*pageOptions)
+
+type pageOptions struct {
+	keyFunc       KeyFunc
+	hashedKeys    int
+	namespace     string
+	bodyKey       bool
+	bodyMaxSize   int64
+	identity      IdentityFunc
+	upgrade       bool
+	skipOnError   bool
+	errorPolicy   ErrorPolicy
+	bypass        DirectiveFunc
+	refresh       DirectiveFunc
+	refreshGroup  *RefreshGroup
+	keyFilter     persistence.KeyFilter
+	keyFilterFunc KeyFunc
+	vary          []string
+	responseVary  bool
+	negotiate     []string
+	etag          bool
+	cacheControl  bool
+	httpCaching   bool
+	httpMaxStale  time.Duration
+	ttlFunc       TTLFunc
+	ttlJitter     float64
+	sliding       bool
+	earlyBeta     float64
+	negativeTTL   time.Duration
+	secondChance  persistence.CacheStore
+	staleWarning  bool
+	streaming     StreamingPolicy
+	streamMaxSize int
+	gzip          bool
+	gzipLevel     int
+	lockTTL       time.Duration
+	lockWait      time.Duration
+	diagnostics   DiagnosticHeaders
+	headers       headerFilter
+	statusCodes   []int
+	maxSize       int
+	metrics       *PageMetrics
+	debug         *PageStats
+	logger        persistence.Logger
+}
+
+func newPageOptions(opts []PageOption) pageOptions {
+	var o pageOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// KeyFunc returns the cache key of the page requested in c
+type KeyFunc func(c *gin.Context) string
+
+// WithKeyFunc sets the function computing the cache key of pages. By default
+// the key is CreateKey of the request URI.
+func WithKeyFunc(keyFunc KeyFunc) PageOption {
+	return func(o *pageOptions) {
+		o.keyFunc = keyFunc
+	}
+}
+
+// SortedQueryKey is a KeyFunc keying pages on their path and query
+// parameters sorted by name, so that requests listing the same parameters in
+// another order share a page
+func SortedQueryKey(c *gin.Context) string {
+	u := c.Request.URL
+	if u.RawQuery == "" {
+		return CreateKey(u.EscapedPath())
+	}
+	return CreateKey(u.EscapedPath() + "?" + u.Query().Encode())
+}
+
+// WithStatusCodes only caches responses with the given status codes, 200
+// if none is given. Without this option, responses with a status code < 300
+// are cached, or any response if WithTTLFunc is set.
+func WithStatusCodes(codes ...int) PageOption {
+	return func(o *pageOptions) {
+		if len(codes) == 0 {
+			codes = []int{http.StatusOK}
+		}
+		o.statusCodes = codes
+	}
+}
+
+// WithMaxSize skips caching responses whose body is larger than maxSize
+// bytes. They are not buffered past that size.
+func WithMaxSize(maxSize int) PageOption {
+	return func(o *pageOptions) {
+		o.maxSize = maxSize
+	}
+}
+
+// records reports whether CachePage must record responses and decide whether
+// to cache them once generated, rather than caching them as they are written
+func (o pageOptions) records() bool {
+	return o.ttlFunc != nil || o.statusCodes != nil || o.maxSize > 0 || o.negativeTTL > 0 ||
+		o.streaming == StreamCache
+}
+
+// cacheable reports whether a response with status may be cached
+func (o pageOptions) cacheable(status int) bool {
+	if o.negative(status) {
+		return true
+	}
+	if o.statusCodes == nil {
+		return o.ttlFunc != nil || status < 300
+	}
+	for _, code := range o.statusCodes {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+// WithNamespace prefixes the cache keys of pages with namespace, so that
+// several applications can cache pages in the same store. Wrapping the store
+// in a persistence.NamespacedStore also namespaces tags, and lets Flush only
+// delete the keys of the namespace.
+func WithNamespace(namespace string) PageOption {
+	return func(o *pageOptions) {
+		o.namespace = namespace
+	}
+}
+
+// key returns the cache key of the page requested in c
+func (o pageOptions) key(c *gin.Context) string {
+	switch {
+	case o.keyFunc == nil && o.hashedKeys > 0:
+		return o.namespace + CreateHashedKey(c.Request.URL.RequestURI(), o.hashedKeys)
+	case o.keyFunc == nil:
+		return o.namespace + CreateKey(c.Request.URL.RequestURI())
+	}
+	key := o.keyFunc(c)
+	if o.hashedKeys > 0 && len(key) > o.hashedKeys {
+		key = hashKey(key)
+	}
+	return o.namespace + key
+}
+
+// TTLFunc returns how long to cache the response generated in c, given its
+// status code and body. A duration <= 0 means the response is not cached.
+type TTLFunc func(c *gin.Context, status int, body []byte) time.Duration
+
+// WithTTLFunc sets the function deciding how long each response is cached,
+// in place of the expiration given to CachePage. Unless WithStatusCodes is
+// set, responses are cached whatever their status code, as long as ttlFunc
+// returns a positive duration.
+func WithTTLFunc(ttlFunc TTLFunc) PageOption {
+	return func(o *pageOptions) {
+		o.ttlFunc = ttlFunc
+	}
+}
+
+// WithTTLJitter randomly lengthens or shortens the expiration of each cached
+// page by up to fraction of it, e.g. 0.1 for ±10%, so that pages cached at
+// the same time, such as right after a deploy, do not all expire in the same
+// second and get regenerated at once. Pages cached forever are not affected.
+func WithTTLJitter(fraction float64) PageOption {
+	return func(o *pageOptions) {
+		o.ttlJitter = fraction
+	}
+}
+
+// jitter applies the jitter set with WithTTLJitter to ttl
+func (o pageOptions) jitter(ttl time.Duration) time.Duration {
+	if o.ttlJitter <= 0 || ttl <= 0 {
+		return ttl
+	}
+	jittered := ttl + time.Duration((2*rand.Float64()-1)*o.ttlJitter*float64(ttl))
+	if jittered <= 0 {
+		return ttl
+	}
+	return jittered
+}
+
+// WithNegativeTTL caches 404 responses for ttl, in place of the expiration
+// given to CachePage or returned by the TTLFunc, whatever WithStatusCodes
+// allows. A short ttl shields the backend from repeated requests for missing
+// resources while letting them show up soon once created.
+func WithNegativeTTL(ttl time.Duration) PageOption {
+	return func(o *pageOptions) {
+		o.negativeTTL = ttl
+	}
+}
+
+// negative reports whether a response with status is cached for negativeTTL
+func (o pageOptions) negative(status int) bool {
+	return o.negativeTTL > 0 && status == http.StatusNotFound
+}
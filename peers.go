@@ -0,0 +1,20 @@
+package cache
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
+)
+
+// MountPeers serves the requests the other peers of store send it, under the
+// base path of store, so that a fleet of gin instances shares a
+// persistence.PeerStore:
+//
+//	store := persistence.NewPeerStore("http://10.0.0.1:8080", persistence.NewInMemoryStore(time.Hour))
+//	store.SetPeers("http://10.0.0.1:8080", "http://10.0.0.2:8080")
+//	cache.MountPeers(router, store)
+//	router.GET("/products", cache.CachePage(store, time.Minute, listProducts))
+//
+// Peers trust each other: the base path must not be reachable by clients.
+func MountPeers(router gin.IRoutes, store *persistence.PeerStore) {
+	router.Any(store.BasePath()+"*key", gin.WrapH(store))
+}
@@ -0,0 +1,282 @@
+package persistence
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy decides what an AsyncStore does with a write when the queue
+// it goes to is full
+type OverflowPolicy int
+
+const (
+	// OverflowDrop discards the Set, and counts it in Dropped. Cached pages
+	// are simply regenerated later, which makes it the usual choice. Deletes
+	// are never dropped, as a lost invalidation would keep a stale item: they
+	// wait for room in the queue instead, like with OverflowBlock.
+	OverflowDrop OverflowPolicy = iota
+	// OverflowBlock waits for room in the queue
+	OverflowBlock
+	// OverflowSync applies the write to the wrapped store synchronously
+	OverflowSync
+)
+
+// AsyncStore is a CacheStore applying Set and Delete to the store it wraps in
+// the background, so that callers do not wait for the backend. Writes are
+// queued per key and applied in order by worker goroutines, so a Get issued
+// right after a Set may not see it yet. Errors of background writes are
+// logged.
+//
+// The other operations are synchronous. Add, Replace, Increment and
+// Decrement are queued behind the pending writes to the same key, and Flush
+// waits for every pending write, so that they observe the writes made
+// before them.
+type AsyncStore struct {
+	// dropped is accessed atomically and kept first for 64-bit alignment
+	dropped uint64
+
+	store  CacheStore
+	policy OverflowPolicy
+
+	mu      sync.RWMutex
+	closed  bool
+	queues  []chan func(CacheStore)
+	workers *sync.WaitGroup
+	// done is closed once the workers return after Close
+	done chan struct{}
+}
+
+// NewAsyncStore returns an AsyncStore writing to store with workers
+// goroutines, each with a queue of queueSize writes. A value passed to Set
+// must not be modified afterwards, as it is written later. Call Close on
+// shutdown to apply the pending writes.
+func NewAsyncStore(store CacheStore, workers, queueSize int, policy OverflowPolicy) *AsyncStore {
+	if workers < 1 {
+		workers = 1
+	}
+	c := &AsyncStore{
+		store:   store,
+		policy:  policy,
+		queues:  make([]chan func(CacheStore), workers),
+		workers: &sync.WaitGroup{},
+		done:    make(chan struct{}),
+	}
+	for i := range c.queues {
+		c.queues[i] = make(chan func(CacheStore), queueSize)
+		c.workers.Add(1)
+		go c.work(c.queues[i])
+	}
+	return c
+}
+
+func (c *AsyncStore) work(queue chan func(CacheStore)) {
+	defer c.workers.Done()
+	for op := range queue {
+		op(c.store)
+	}
+}
+
+// Dropped returns the number of writes discarded by OverflowDrop
+func (c *AsyncStore) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+// Drain waits until the writes queued before the call are applied
+func (c *AsyncStore) Drain() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(c.queues))
+	for _, queue := range c.queues {
+		queue <- func(CacheStore) { wg.Done() }
+	}
+	wg.Wait()
+}
+
+// Close (see CacheStore interface)
+//
+// It applies the pending writes, stops the workers and closes the store it
+// wraps. If ctx is done first, the workers keep applying the pending writes,
+// and the wrapped store is left open.
+func (c *AsyncStore) Close(ctx context.Context) error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		for _, queue := range c.queues {
+			close(queue)
+		}
+		go func() {
+			c.workers.Wait()
+			close(c.done)
+		}()
+	}
+	c.mu.Unlock()
+	if err := awaitDone(ctx, c.done); err != nil {
+		return err
+	}
+	return c.store.Close(ctx)
+}
+
+// WithContext (see ContextBinder interface)
+//
+// Only reads are bound to ctx: queued writes outlive the request and are
+// applied without a context.
+func (c *AsyncStore) WithContext(ctx context.Context) CacheStore {
+	return &asyncBoundStore{AsyncStore: c, reads: BindContext(ctx, c.store)}
+}
+
+// enqueue queues the background write op to the queue of key, or applies it
+// according to the overflow policy. Only droppable writes are dropped.
+func (c *AsyncStore) enqueue(key string, droppable bool, op func(CacheStore) error) {
+	write := func(store CacheStore) {
+		if err := op(store); err != nil && err != ErrCacheMiss {
+			log.Printf("cache: async write of %s failed: %s", key, err)
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		write(c.store)
+		return
+	}
+	queue := c.queue(key)
+	select {
+	case queue <- write:
+		return
+	default:
+	}
+	switch {
+	case c.policy == OverflowSync:
+		write(c.store)
+	case c.policy == OverflowDrop && droppable:
+		atomic.AddUint64(&c.dropped, 1)
+	default:
+		// Waiting keeps the write ordered after those queued to key
+		queue <- write
+	}
+}
+
+// run applies op after the pending writes to key, and returns its error
+func (c *AsyncStore) run(key string, op func(CacheStore) error) error {
+	c.mu.RLock()
+	if c.closed {
+		c.mu.RUnlock()
+		return op(c.store)
+	}
+	done := make(chan error, 1)
+	c.queue(key) <- func(store CacheStore) { done <- op(store) }
+	c.mu.RUnlock()
+	return <-done
+}
+
+func (c *AsyncStore) queue(key string) chan func(CacheStore) {
+	return c.queues[FNVPartition(key)%uint32(len(c.queues))]
+}
+
+// Get (see CacheStore interface)
+func (c *AsyncStore) Get(key string, value interface{}) error {
+	return c.store.Get(key, value)
+}
+
+// Set (see CacheStore interface)
+//
+// The item is written in the background, and Set always returns nil.
+func (c *AsyncStore) Set(key string, value interface{}, expires time.Duration) error {
+	c.enqueue(key, true, func(store CacheStore) error {
+		return store.Set(key, value, expires)
+	})
+	return nil
+}
+
+// Add (see CacheStore interface)
+func (c *AsyncStore) Add(key string, value interface{}, expires time.Duration) error {
+	return c.run(key, func(store CacheStore) error {
+		return store.Add(key, value, expires)
+	})
+}
+
+// Replace (see CacheStore interface)
+func (c *AsyncStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return c.run(key, func(store CacheStore) error {
+		return store.Replace(key, value, expires)
+	})
+}
+
+// Delete (see CacheStore interface)
+//
+// The item is deleted in the background, and Delete always returns nil. It
+// is never dropped: when the queue is full, Delete waits for room, unless the
+// policy is OverflowSync.
+func (c *AsyncStore) Delete(key string) error {
+	c.enqueue(key, false, func(store CacheStore) error {
+		return store.Delete(key)
+	})
+	return nil
+}
+
+// Increment (see CacheStore interface)
+func (c *AsyncStore) Increment(key string, delta uint64) (n uint64, err error) {
+	err = c.run(key, func(store CacheStore) error {
+		n, err = store.Increment(key, delta)
+		return err
+	})
+	return n, err
+}
+
+// Decrement (see CacheStore interface)
+func (c *AsyncStore) Decrement(key string, delta uint64) (n uint64, err error) {
+	err = c.run(key, func(store CacheStore) error {
+		n, err = store.Decrement(key, delta)
+		return err
+	})
+	return n, err
+}
+
+// Flush (see CacheStore interface)
+//
+// Pending writes are applied before flushing.
+func (c *AsyncStore) Flush() error {
+	c.Drain()
+	return c.store.Flush()
+}
+
+// GetMulti (see CacheStore interface)
+func (c *AsyncStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	return c.store.GetMulti(keys, values)
+}
+
+// SetMulti (see CacheStore interface)
+//
+// Each item is written in the background like with Set.
+func (c *AsyncStore) SetMulti(items map[string]Item) error {
+	for key, item := range items {
+		key, item := key, item
+		c.enqueue(key, true, func(store CacheStore) error {
+			return store.Set(key, item.Value, item.Expire)
+		})
+	}
+	return nil
+}
+
+// asyncBoundStore is an AsyncStore whose reads are bound to a context
+type asyncBoundStore struct {
+	*AsyncStore
+	reads CacheStore
+}
+
+// Get (see CacheStore interface)
+func (c *asyncBoundStore) Get(key string, value interface{}) error {
+	return c.reads.Get(key, value)
+}
+
+// GetMulti (see CacheStore interface)
+func (c *asyncBoundStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	return c.reads.GetMulti(keys, values)
+}
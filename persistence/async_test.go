@@ -0,0 +1,142 @@
+package persistence
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// gatedStore blocks its writes until the gate is opened
+type gatedStore struct {
+	CacheStore
+	gate chan struct{}
+}
+
+func (s gatedStore) Set(key string, value interface{}, expires time.Duration) error {
+	<-s.gate
+	return s.CacheStore.Set(key, value, expires)
+}
+
+func TestAsyncStore_Writes(t *testing.T) {
+	backend := NewInMemoryStore(time.Hour)
+	store := NewAsyncStore(backend, 4, 100, OverflowBlock)
+	defer store.Close(context.Background())
+
+	for i := 0; i < 100; i++ {
+		store.Set("key", i, DEFAULT)
+		store.Set("key"+strconv.Itoa(i), i, DEFAULT)
+	}
+	store.Drain()
+	var n int
+	if err := backend.Get("key", &n); err != nil || n != 99 {
+		t.Errorf("Expected the writes to a key to be applied in order, got %d, %v", n, err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := backend.Get("key"+strconv.Itoa(i), &n); err != nil || n != i {
+			t.Errorf("Expected %d, got %d, %v", i, n, err)
+		}
+	}
+
+	// Synchronous operations see the pending writes to their key
+	store.Set("new", 1, DEFAULT)
+	if err := store.Add("new", 2, DEFAULT); err != ErrNotStored {
+		t.Errorf("Expected ErrNotStored, got: %v", err)
+	}
+	if n, err := store.Increment("new", 1); err != nil || n != 2 {
+		t.Errorf("Expected 2, got %d, %v", n, err)
+	}
+	store.Delete("new")
+	if err := store.Replace("new", 3, DEFAULT); err != ErrNotStored {
+		t.Errorf("Expected ErrNotStored, got: %v", err)
+	}
+
+	store.SetMulti(map[string]Item{"a": {"a", DEFAULT}, "b": {"b", DEFAULT}})
+	store.Flush()
+	var s string
+	if err := backend.Get("a", &s); err != ErrCacheMiss {
+		t.Errorf("Expected pending writes to be flushed, got: %v", err)
+	}
+}
+
+func TestAsyncStore_Overflow(t *testing.T) {
+	for _, policy := range []OverflowPolicy{OverflowDrop, OverflowSync} {
+		gate := make(chan struct{})
+		backend := NewInMemoryStore(time.Hour)
+		store := NewAsyncStore(gatedStore{backend, gate}, 1, 1, policy)
+
+		// The first write blocks the worker, the second fills the queue
+		store.Set("a", "a", DEFAULT)
+		for len(store.queues[0]) != 0 {
+			time.Sleep(time.Millisecond)
+		}
+		store.Set("b", "b", DEFAULT)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			close(gate)
+		}()
+		store.Set("c", "c", DEFAULT)
+
+		var s string
+		switch policy {
+		case OverflowDrop:
+			if n := store.Dropped(); n != 1 {
+				t.Errorf("Expected 1 dropped write, got %d", n)
+			}
+			store.Close(context.Background())
+			if err := backend.Get("c", &s); err != ErrCacheMiss {
+				t.Errorf("Expected the dropped write to be missing, got: %v", err)
+			}
+		case OverflowSync:
+			if err := backend.Get("c", &s); err != nil {
+				t.Errorf("Expected the overflowing write to be applied, got: %v", err)
+			}
+			store.Close(context.Background())
+		}
+		if err := backend.Get("b", &s); err != nil {
+			t.Errorf("Expected the queued write to be applied on Close, got: %v", err)
+		}
+	}
+}
+
+func TestAsyncStore_OverflowKeepsDeletes(t *testing.T) {
+	gate := make(chan struct{})
+	backend := NewInMemoryStore(time.Hour)
+	backend.Set("b", "stale", DEFAULT)
+	store := NewAsyncStore(gatedStore{backend, gate}, 1, 1, OverflowDrop)
+
+	store.Set("a", "a", DEFAULT)
+	for len(store.queues[0]) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	store.Set("c", "c", DEFAULT)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(gate)
+	}()
+	store.Delete("b")
+	store.Close(context.Background())
+
+	if n := store.Dropped(); n != 0 {
+		t.Errorf("Expected no dropped write, got %d", n)
+	}
+	var s string
+	if err := backend.Get("b", &s); err != ErrCacheMiss {
+		t.Errorf("Expected the overflowing delete to be applied, got %q, %v", s, err)
+	}
+}
+
+func TestAsyncStore_Closed(t *testing.T) {
+	backend := NewInMemoryStore(time.Hour)
+	store := NewAsyncStore(backend, 2, 10, OverflowDrop)
+	store.Close(context.Background())
+
+	store.Set("a", "a", DEFAULT)
+	var s string
+	if err := backend.Get("a", &s); err != nil || s != "a" {
+		t.Errorf("Expected writes after Close to be applied synchronously, got %s, %v", s, err)
+	}
+	if err := store.Add("a", "b", DEFAULT); err != ErrNotStored {
+		t.Errorf("Expected ErrNotStored, got: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package persistence
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff computes how long to wait before retrying a failed operation.
+// attempt is 0 for the first retry and grows by one for each following one.
+type Backoff interface {
+	NextDelay(attempt int) time.Duration
+}
+
+// maxBackoff is the longest delay of the backoffs without a Max, which
+// stop growing there instead of overflowing
+const maxBackoff = time.Duration(math.MaxInt64)
+
+// backoffLimit returns the longest delay of a backoff with max
+func backoffLimit(max time.Duration) time.Duration {
+	if max <= 0 {
+		return maxBackoff
+	}
+	return max
+}
+
+// ConstantBackoff waits the same delay before every retry
+type ConstantBackoff time.Duration
+
+// NextDelay (see Backoff interface)
+func (b ConstantBackoff) NextDelay(attempt int) time.Duration {
+	return time.Duration(b)
+}
+
+// LinearBackoff waits Step longer before each retry, starting with Step, and
+// never longer than Max (unless Max is 0)
+type LinearBackoff struct {
+	Step time.Duration
+	Max  time.Duration
+}
+
+// NextDelay (see Backoff interface)
+func (b LinearBackoff) NextDelay(attempt int) time.Duration {
+	limit := backoffLimit(b.Max)
+	if b.Step > 0 && time.Duration(attempt+1) > limit/b.Step {
+		return limit
+	}
+	return b.Step * time.Duration(attempt+1)
+}
+
+// ExponentialBackoff doubles the delay before each retry, starting with Base,
+// and never waits longer than Max (unless Max is 0). Jitter, between 0 and 1,
+// randomizes each delay to between (1 - Jitter) and 1 times its value, so
+// clients failing at the same time don't retry in lockstep.
+type ExponentialBackoff struct {
+	Base   time.Duration
+	Max    time.Duration
+	Jitter float64
+}
+
+// NextDelay (see Backoff interface)
+func (b ExponentialBackoff) NextDelay(attempt int) time.Duration {
+	limit := backoffLimit(b.Max)
+	delay := b.Base
+	for i := 0; i < attempt && delay > 0 && delay < limit; i++ {
+		if delay > limit/2 {
+			delay = limit
+			break
+		}
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	if b.Jitter > 0 {
+		delay -= time.Duration(b.Jitter * rand.Float64() * float64(delay))
+	}
+	return delay
+}
+
+// defaultBackoff is used by the retrying features of the stores unless
+// another Backoff is configured
+var defaultBackoff Backoff = ExponentialBackoff{
+	Base:   50 * time.Millisecond,
+	Max:    5 * time.Second,
+	Jitter: 0.5,
+}
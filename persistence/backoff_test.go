@@ -0,0 +1,60 @@
+package persistence
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func expectDelays(t *testing.T, b Backoff, expected ...time.Duration) {
+	for attempt, e := range expected {
+		if d := b.NextDelay(attempt); d != e {
+			t.Errorf("Expected a delay of %s for attempt %d, got %s", e, attempt, d)
+		}
+	}
+}
+
+func TestConstantBackoff(t *testing.T) {
+	expectDelays(t, ConstantBackoff(time.Second),
+		time.Second, time.Second, time.Second)
+}
+
+func TestLinearBackoff(t *testing.T) {
+	expectDelays(t, LinearBackoff{Step: time.Second, Max: 3 * time.Second},
+		time.Second, 2*time.Second, 3*time.Second, 3*time.Second)
+	expectDelays(t, LinearBackoff{Step: time.Second},
+		time.Second, 2*time.Second, 3*time.Second, 4*time.Second)
+	if d := (LinearBackoff{Step: time.Hour}).NextDelay(math.MaxInt32 * 1000); d != maxBackoff {
+		t.Errorf("Expected the delay to stop growing instead of overflowing, got %s", d)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	expectDelays(t, ExponentialBackoff{Base: 100 * time.Millisecond, Max: time.Second},
+		100*time.Millisecond, 200*time.Millisecond, 400*time.Millisecond, 800*time.Millisecond, time.Second, time.Second)
+	if d := (ExponentialBackoff{Base: time.Second, Max: time.Minute}).NextDelay(1000); d != time.Minute {
+		t.Errorf("Expected a delay of 1m for a large attempt, got %s", d)
+	}
+	for _, attempt := range []int{62, 63, 64, 1000} {
+		if d := (ExponentialBackoff{Base: time.Second}).NextDelay(attempt); d != maxBackoff {
+			t.Errorf("Expected the delay of attempt %d to stop growing instead of overflowing, got %s", attempt, d)
+		}
+	}
+}
+
+func TestExponentialBackoffJitter(t *testing.T) {
+	b := ExponentialBackoff{Base: 100 * time.Millisecond, Max: time.Second, Jitter: 0.5}
+	for attempt, max := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		distinct := make(map[time.Duration]bool)
+		for i := 0; i < 100; i++ {
+			d := b.NextDelay(attempt)
+			if d < max/2 || d > max {
+				t.Errorf("Expected a delay between %s and %s for attempt %d, got %s", max/2, max, attempt, d)
+			}
+			distinct[d] = true
+		}
+		if len(distinct) < 2 {
+			t.Errorf("Expected jittered delays for attempt %d", attempt)
+		}
+	}
+}
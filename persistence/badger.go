@@ -0,0 +1,274 @@
+package persistence
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/mlsen/cache/utils"
+)
+
+// BadgerStore represents the cache with on-disk persistence in a BadgerDB
+// database, which keeps the cache warm across restarts of a single node
+// service. Expirations are handled by badger itself, with a one second
+// resolution.
+type BadgerStore struct {
+	db                *badger.DB
+	defaultExpiration time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewBadgerStore opens a badger database with opts and returns a BadgerStore
+// using it. If gcInterval is positive, the value log of the database is
+// garbage collected at that interval, reclaiming the space of expired and
+// overwritten values.
+func NewBadgerStore(opts badger.Options, defaultExpiration, gcInterval time.Duration) (*BadgerStore, error) {
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	store := &BadgerStore{db: db, defaultExpiration: defaultExpiration, stop: make(chan struct{}), done: make(chan struct{})}
+	if gcInterval > 0 {
+		go store.collectGarbage(gcInterval)
+	} else {
+		close(store.done)
+	}
+	return store, nil
+}
+
+// Close (see CacheStore interface)
+//
+// It stops the garbage collection and closes the database, unless ctx is done
+// before the garbage collection returns.
+func (c *BadgerStore) Close(ctx context.Context) error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	if err := awaitDone(ctx, c.done); err != nil {
+		return err
+	}
+	return c.db.Close()
+}
+
+func (c *BadgerStore) collectGarbage(interval time.Duration) {
+	defer close(c.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// Each run rewrites at most one file; keep going while there
+			// is something to reclaim
+			for c.db.RunValueLogGC(0.5) == nil {
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Get (see CacheStore interface)
+func (c *BadgerStore) Get(key string, value interface{}) error {
+	var b []byte
+	err := c.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		b, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		return convertBadgerError(err)
+	}
+	return utils.Deserialize(b, value)
+}
+
+// Set (see CacheStore interface)
+func (c *BadgerStore) Set(key string, value interface{}, expires time.Duration) error {
+	return c.update(func(txn *badger.Txn) error {
+		return c.set(txn, key, value, expires)
+	})
+}
+
+// Add (see CacheStore interface)
+func (c *BadgerStore) Add(key string, value interface{}, expires time.Duration) error {
+	return c.update(func(txn *badger.Txn) error {
+		if _, err := txn.Get([]byte(key)); err != badger.ErrKeyNotFound {
+			if err == nil {
+				return ErrNotStored
+			}
+			return err
+		}
+		return c.set(txn, key, value, expires)
+	})
+}
+
+// Replace (see CacheStore interface)
+func (c *BadgerStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return c.update(func(txn *badger.Txn) error {
+		if _, err := txn.Get([]byte(key)); err != nil {
+			if err == badger.ErrKeyNotFound {
+				return ErrNotStored
+			}
+			return err
+		}
+		return c.set(txn, key, value, expires)
+	})
+}
+
+// Delete (see CacheStore interface)
+func (c *BadgerStore) Delete(key string) error {
+	return c.update(func(txn *badger.Txn) error {
+		if _, err := txn.Get([]byte(key)); err != nil {
+			return err
+		}
+		return txn.Delete([]byte(key))
+	})
+}
+
+// Increment (see CacheStore interface)
+//
+// Like InMemoryStore, the counter wraps around on overflow.
+func (c *BadgerStore) Increment(key string, n uint64) (uint64, error) {
+	return c.incrDecr(key, func(value uint64) uint64 {
+		return value + n
+	})
+}
+
+// Decrement (see CacheStore interface)
+//
+// Like InMemoryStore, the counter stops at 0.
+func (c *BadgerStore) Decrement(key string, n uint64) (uint64, error) {
+	return c.incrDecr(key, func(value uint64) uint64 {
+		if n > value {
+			return 0
+		}
+		return value - n
+	})
+}
+
+// incrDecr updates the counter at key with fn, keeping its expiration
+func (c *BadgerStore) incrDecr(key string, fn func(uint64) uint64) (uint64, error) {
+	var value uint64
+	err := c.update(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		b, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err := utils.Deserialize(b, &value); err != nil {
+			return err
+		}
+		value = fn(value)
+		b, err = utils.Serialize(value)
+		if err != nil {
+			return err
+		}
+		entry := badger.NewEntry([]byte(key), b)
+		entry.ExpiresAt = item.ExpiresAt()
+		return txn.SetEntry(entry)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// Flush (see CacheStore interface)
+func (c *BadgerStore) Flush() error {
+	return c.db.DropAll()
+}
+
+// GetMulti (see CacheStore interface)
+//
+// All keys are read in a single transaction.
+func (c *BadgerStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	if len(keys) != len(values) {
+		return nil, errGetMultiLength
+	}
+	found := make([]bool, len(keys))
+	err := c.db.View(func(txn *badger.Txn) error {
+		for i, key := range keys {
+			item, err := txn.Get([]byte(key))
+			if err == badger.ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			b, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			err = utils.Deserialize(b, values[i])
+			if err == ErrNegativeHit {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			found[i] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// SetMulti (see CacheStore interface)
+//
+// All items are written in a single transaction.
+func (c *BadgerStore) SetMulti(items map[string]Item) error {
+	return c.update(func(txn *badger.Txn) error {
+		for key, item := range items {
+			if err := c.set(txn, key, item.Value, item.Expire); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// update runs fn in a read-write transaction, retrying it as long as it
+// conflicts with concurrent transactions
+func (c *BadgerStore) update(fn func(txn *badger.Txn) error) error {
+	for {
+		err := c.db.Update(fn)
+		if err != badger.ErrConflict {
+			return convertBadgerError(err)
+		}
+	}
+}
+
+func (c *BadgerStore) set(txn *badger.Txn, key string, value interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	entry := badger.NewEntry([]byte(key), b)
+	switch expires {
+	case DEFAULT:
+		expires = c.defaultExpiration
+	case FOREVER:
+		expires = 0
+	}
+	if expires > 0 {
+		entry = entry.WithTTL(expires)
+	}
+	return txn.SetEntry(entry)
+}
+
+func convertBadgerError(err error) error {
+	if err == badger.ErrKeyNotFound {
+		return ErrCacheMiss
+	}
+	return err
+}
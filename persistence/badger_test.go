@@ -0,0 +1,79 @@
+package persistence
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+var newBadgerStore = func(t *testing.T, defaultExpiration time.Duration) CacheStore {
+	opts := badger.DefaultOptions("").WithInMemory(true).WithLogger(nil)
+	store, err := NewBadgerStore(opts, defaultExpiration, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating store: %s", err)
+	}
+	return store
+}
+
+// Test typical cache interactions
+func TestBadgerCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newBadgerStore)
+}
+
+func TestBadgerCache_IncrDecr(t *testing.T) {
+	incrDecr(t, newBadgerStore)
+}
+
+func TestBadgerCache_CounterPresence(t *testing.T) {
+	counterPresence(t, newBadgerStore)
+}
+
+func TestBadgerCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newBadgerStore)
+}
+
+func TestBadgerCache_Expiration(t *testing.T) {
+	expiration(t, newBadgerStore)
+}
+
+func TestBadgerCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newBadgerStore)
+}
+
+func TestBadgerCache_Replace(t *testing.T) {
+	testReplace(t, newBadgerStore)
+}
+
+func TestBadgerCache_Add(t *testing.T) {
+	testAdd(t, newBadgerStore)
+}
+
+func TestBadgerCache_NegativeCaching(t *testing.T) {
+	negativeCaching(t, newBadgerStore)
+}
+
+func TestBadgerCache_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	opts := badger.DefaultOptions(dir).WithLogger(nil)
+
+	store, err := NewBadgerStore(opts, time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Error creating store: %s", err)
+	}
+	store.Set("key", "value", DEFAULT)
+	if err := store.Close(context.Background()); err != nil {
+		t.Fatalf("Error closing store: %s", err)
+	}
+
+	store, err = NewBadgerStore(opts, time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Error reopening store: %s", err)
+	}
+	defer store.Close(context.Background())
+	var value string
+	if err := store.Get("key", &value); err != nil || value != "value" {
+		t.Errorf("Expected the value to survive a restart, got %q (%v)", value, err)
+	}
+}
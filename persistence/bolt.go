@@ -0,0 +1,289 @@
+package persistence
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/mlsen/cache/utils"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltHeaderSize is the size of the expiration stored before every value: a
+// big endian Unix time in nanoseconds, 0 for items that never expire
+const boltHeaderSize = 8
+
+// BoltStore represents the cache with on-disk persistence in a bbolt
+// database, for embedded deployments without a cache server. Items are kept
+// in a bucket of their own, so the database can be shared with other data.
+// Expired items are treated as missing when read, and deleted by a background
+// sweeper.
+type BoltStore struct {
+	db                *bolt.DB
+	bucket            []byte
+	defaultExpiration time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewBoltStore returns a BoltStore keeping its items in bucket of db, which
+// is created if needed. If sweepInterval is positive, expired items are
+// deleted at that interval.
+func NewBoltStore(db *bolt.DB, bucket string, defaultExpiration, sweepInterval time.Duration) (*BoltStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	store := &BoltStore{db: db, bucket: []byte(bucket), defaultExpiration: defaultExpiration, stop: make(chan struct{}), done: make(chan struct{})}
+	if sweepInterval > 0 {
+		go store.sweep(sweepInterval)
+	} else {
+		close(store.done)
+	}
+	return store, nil
+}
+
+// Close (see CacheStore interface)
+//
+// It stops the sweeper. The database is left open, since it may be used for
+// other data.
+func (c *BoltStore) Close(ctx context.Context) error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	return awaitDone(ctx, c.done)
+}
+
+func (c *BoltStore) sweep(interval time.Duration) {
+	defer close(c.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.DeleteExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// DeleteExpired deletes the expired items from the database
+func (c *BoltStore) DeleteExpired() error {
+	now := time.Now()
+	return c.db.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(c.bucket).Cursor()
+		for k, v := cursor.First(); k != nil; {
+			if boltExpired(v, now) {
+				// Delete moves the cursor to the next item
+				if err := cursor.Delete(); err != nil {
+					return err
+				}
+				k, v = cursor.Seek(k)
+				continue
+			}
+			k, v = cursor.Next()
+		}
+		return nil
+	})
+}
+
+// Get (see CacheStore interface)
+func (c *BoltStore) Get(key string, value interface{}) error {
+	var b []byte
+	err := c.db.View(func(tx *bolt.Tx) error {
+		v := c.get(tx, key)
+		if v == nil {
+			return ErrCacheMiss
+		}
+		// The value is only valid during the transaction
+		b = append([]byte(nil), v[boltHeaderSize:]...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return utils.Deserialize(b, value)
+}
+
+// Set (see CacheStore interface)
+func (c *BoltStore) Set(key string, value interface{}, expires time.Duration) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return c.set(tx, key, value, c.expiresAt(expires))
+	})
+}
+
+// Add (see CacheStore interface)
+func (c *BoltStore) Add(key string, value interface{}, expires time.Duration) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if c.get(tx, key) != nil {
+			return ErrNotStored
+		}
+		return c.set(tx, key, value, c.expiresAt(expires))
+	})
+}
+
+// Replace (see CacheStore interface)
+func (c *BoltStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if c.get(tx, key) == nil {
+			return ErrNotStored
+		}
+		return c.set(tx, key, value, c.expiresAt(expires))
+	})
+}
+
+// Delete (see CacheStore interface)
+func (c *BoltStore) Delete(key string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if c.get(tx, key) == nil {
+			return ErrCacheMiss
+		}
+		return tx.Bucket(c.bucket).Delete([]byte(key))
+	})
+}
+
+// Increment (see CacheStore interface)
+//
+// Like InMemoryStore, the counter wraps around on overflow.
+func (c *BoltStore) Increment(key string, n uint64) (uint64, error) {
+	return c.incrDecr(key, func(value uint64) uint64 {
+		return value + n
+	})
+}
+
+// Decrement (see CacheStore interface)
+//
+// Like InMemoryStore, the counter stops at 0.
+func (c *BoltStore) Decrement(key string, n uint64) (uint64, error) {
+	return c.incrDecr(key, func(value uint64) uint64 {
+		if n > value {
+			return 0
+		}
+		return value - n
+	})
+}
+
+// incrDecr updates the counter at key with fn, keeping its expiration
+func (c *BoltStore) incrDecr(key string, fn func(uint64) uint64) (uint64, error) {
+	var value uint64
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		v := c.get(tx, key)
+		if v == nil {
+			return ErrCacheMiss
+		}
+		if err := utils.Deserialize(v[boltHeaderSize:], &value); err != nil {
+			return err
+		}
+		value = fn(value)
+		return c.set(tx, key, value, int64(binary.BigEndian.Uint64(v)))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return value, nil
+}
+
+// Flush (see CacheStore interface)
+func (c *BoltStore) Flush() error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(c.bucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(c.bucket)
+		return err
+	})
+}
+
+// GetMulti (see CacheStore interface)
+//
+// All keys are read in a single transaction.
+func (c *BoltStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	if len(keys) != len(values) {
+		return nil, errGetMultiLength
+	}
+	found := make([]bool, len(keys))
+	err := c.db.View(func(tx *bolt.Tx) error {
+		for i, key := range keys {
+			v := c.get(tx, key)
+			if v == nil {
+				continue
+			}
+			err := utils.Deserialize(append([]byte(nil), v[boltHeaderSize:]...), values[i])
+			if err == ErrNegativeHit {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			found[i] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// SetMulti (see CacheStore interface)
+//
+// All items are written in a single transaction.
+func (c *BoltStore) SetMulti(items map[string]Item) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		for key, item := range items {
+			if err := c.set(tx, key, item.Value, c.expiresAt(item.Expire)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// get returns the stored bytes of key, header included, or nil if the key is
+// missing or expired
+func (c *BoltStore) get(tx *bolt.Tx, key string) []byte {
+	v := tx.Bucket(c.bucket).Get([]byte(key))
+	if v == nil || boltExpired(v, time.Now()) {
+		return nil
+	}
+	return v
+}
+
+func (c *BoltStore) set(tx *bolt.Tx, key string, value interface{}, expiresAt int64) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	v := make([]byte, boltHeaderSize+len(b))
+	binary.BigEndian.PutUint64(v, uint64(expiresAt))
+	copy(v[boltHeaderSize:], b)
+	return tx.Bucket(c.bucket).Put([]byte(key), v)
+}
+
+// expiresAt returns the expiration time, in Unix nanoseconds, of an item set
+// now for expires
+func (c *BoltStore) expiresAt(expires time.Duration) int64 {
+	switch expires {
+	case DEFAULT:
+		expires = c.defaultExpiration
+	case FOREVER:
+		return 0
+	}
+	if expires <= 0 {
+		return 0
+	}
+	return time.Now().Add(expires).UnixNano()
+}
+
+func boltExpired(v []byte, now time.Time) bool {
+	if len(v) < boltHeaderSize {
+		return true
+	}
+	expiresAt := int64(binary.BigEndian.Uint64(v))
+	return expiresAt != 0 && expiresAt <= now.UnixNano()
+}
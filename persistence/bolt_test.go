@@ -0,0 +1,88 @@
+package persistence
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func openBoltDB(t *testing.T) *bolt.DB {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "cache.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+var newBoltStore = func(t *testing.T, defaultExpiration time.Duration) CacheStore {
+	store, err := NewBoltStore(openBoltDB(t), "cache", defaultExpiration, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating store: %s", err)
+	}
+	t.Cleanup(func() { store.Close(context.Background()) })
+	return store
+}
+
+// Test typical cache interactions
+func TestBoltCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newBoltStore)
+}
+
+func TestBoltCache_IncrDecr(t *testing.T) {
+	incrDecr(t, newBoltStore)
+}
+
+func TestBoltCache_CounterPresence(t *testing.T) {
+	counterPresence(t, newBoltStore)
+}
+
+func TestBoltCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newBoltStore)
+}
+
+func TestBoltCache_Expiration(t *testing.T) {
+	expiration(t, newBoltStore)
+}
+
+func TestBoltCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newBoltStore)
+}
+
+func TestBoltCache_Replace(t *testing.T) {
+	testReplace(t, newBoltStore)
+}
+
+func TestBoltCache_Add(t *testing.T) {
+	testAdd(t, newBoltStore)
+}
+
+func TestBoltCache_NegativeCaching(t *testing.T) {
+	negativeCaching(t, newBoltStore)
+}
+
+func TestBoltCache_DeleteExpired(t *testing.T) {
+	db := openBoltDB(t)
+	store, err := NewBoltStore(db, "cache", time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Error creating store: %s", err)
+	}
+	store.Set("a", 1, 10*time.Millisecond)
+	store.Set("b", 1, 10*time.Millisecond)
+	store.Set("c", 1, DEFAULT)
+	store.Set("d", 1, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	if err := store.DeleteExpired(); err != nil {
+		t.Fatalf("Error deleting expired items: %s", err)
+	}
+	db.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("cache")).Stats().KeyN; n != 1 {
+			t.Errorf("Expected 1 item left, got %d", n)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,271 @@
+package persistence
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// CircuitBreakerStore is a CacheStore protecting the application from an
+// unavailable backend. Once the store it wraps fails threshold times in a
+// row, the circuit opens: operations go to a fallback store without trying
+// the backend, which avoids waiting for timeouts on every request. After
+// cooldown, a single operation probes the backend, closing the circuit if it
+// succeeds. Operations failing while the circuit is closed are retried on
+// the fallback, so callers never see backend errors.
+//
+// Misses, ErrNotStored and the other errors reporting the state of an item
+// are not failures.
+type CircuitBreakerStore struct {
+	store    CacheStore
+	fallback CacheStore
+	breaker  *circuitBreaker
+}
+
+// NewCircuitBreakerStore returns a CircuitBreakerStore wrapping store. If
+// fallback is nil, the store behaves as an empty cache while the circuit is
+// open: reads miss and writes are discarded. Otherwise fallback, typically
+// an InMemoryStore, serves the operations and is flushed when the circuit
+// closes, so that it does not hold values older than the backend's during
+// the next outage. Deletions served by fallback do not reach the backend.
+func NewCircuitBreakerStore(store, fallback CacheStore, threshold int, cooldown time.Duration) *CircuitBreakerStore {
+	if fallback == nil {
+		fallback = missStore{}
+	}
+	return &CircuitBreakerStore{
+		store:    store,
+		fallback: fallback,
+		breaker:  &circuitBreaker{threshold: threshold, cooldown: cooldown},
+	}
+}
+
+// Open reports whether the circuit is open, i.e. whether operations are
+// served by the fallback
+func (c *CircuitBreakerStore) Open() bool {
+	c.breaker.mu.Lock()
+	defer c.breaker.mu.Unlock()
+	return c.breaker.open
+}
+
+// WithContext (see ContextBinder interface)
+func (c *CircuitBreakerStore) WithContext(ctx context.Context) CacheStore {
+	return &CircuitBreakerStore{
+		store:    BindContext(ctx, c.store),
+		fallback: BindContext(ctx, c.fallback),
+		breaker:  c.breaker,
+	}
+}
+
+// do runs op on the backend if the circuit allows it, and on the fallback if
+// it does not or if the backend fails
+func (c *CircuitBreakerStore) do(op func(store CacheStore) error) error {
+	if c.breaker.allow() {
+		err := op(c.store)
+		if err == context.Canceled {
+			// The caller gave up: the backend may be healthy
+			c.breaker.cancel()
+			return err
+		}
+		failed := isBackendError(err)
+		if c.breaker.record(failed) {
+			c.fallback.Flush()
+		}
+		if !failed {
+			return err
+		}
+	}
+	return op(c.fallback)
+}
+
+// Get (see CacheStore interface)
+func (c *CircuitBreakerStore) Get(key string, value interface{}) error {
+	return c.do(func(store CacheStore) error {
+		return store.Get(key, value)
+	})
+}
+
+// Set (see CacheStore interface)
+func (c *CircuitBreakerStore) Set(key string, value interface{}, expires time.Duration) error {
+	return c.do(func(store CacheStore) error {
+		return store.Set(key, value, expires)
+	})
+}
+
+// Add (see CacheStore interface)
+func (c *CircuitBreakerStore) Add(key string, value interface{}, expires time.Duration) error {
+	return c.do(func(store CacheStore) error {
+		return store.Add(key, value, expires)
+	})
+}
+
+// Replace (see CacheStore interface)
+func (c *CircuitBreakerStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return c.do(func(store CacheStore) error {
+		return store.Replace(key, value, expires)
+	})
+}
+
+// Delete (see CacheStore interface)
+func (c *CircuitBreakerStore) Delete(key string) error {
+	return c.do(func(store CacheStore) error {
+		return store.Delete(key)
+	})
+}
+
+// Increment (see CacheStore interface)
+func (c *CircuitBreakerStore) Increment(key string, delta uint64) (n uint64, err error) {
+	err = c.do(func(store CacheStore) error {
+		n, err = store.Increment(key, delta)
+		return err
+	})
+	return n, err
+}
+
+// Decrement (see CacheStore interface)
+func (c *CircuitBreakerStore) Decrement(key string, delta uint64) (n uint64, err error) {
+	err = c.do(func(store CacheStore) error {
+		n, err = store.Decrement(key, delta)
+		return err
+	})
+	return n, err
+}
+
+// Flush (see CacheStore interface)
+func (c *CircuitBreakerStore) Flush() error {
+	return c.do(func(store CacheStore) error {
+		return store.Flush()
+	})
+}
+
+// Close (see CacheStore interface)
+//
+// It closes the store and the fallback.
+func (c *CircuitBreakerStore) Close(ctx context.Context) error {
+	return closeAll(ctx, c.store, c.fallback)
+}
+
+// GetMulti (see CacheStore interface)
+func (c *CircuitBreakerStore) GetMulti(keys []string, values []interface{}) (found []bool, err error) {
+	err = c.do(func(store CacheStore) error {
+		found, err = store.GetMulti(keys, values)
+		return err
+	})
+	return found, err
+}
+
+// SetMulti (see CacheStore interface)
+func (c *CircuitBreakerStore) SetMulti(items map[string]Item) error {
+	return c.do(func(store CacheStore) error {
+		return store.SetMulti(items)
+	})
+}
+
+// circuitBreaker counts the consecutive failures of a backend
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+	probing  bool
+}
+
+// allow reports whether an operation may use the backend. Once the cooldown
+// has elapsed, a single operation is allowed to probe it.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return true
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record records the outcome of an operation allowed by allow, and reports
+// whether it closed the circuit
+func (b *circuitBreaker) record(failed bool) (closed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.failures = 0
+		if b.open {
+			b.open, b.probing = false, false
+			log.Println("cache: backend recovered, closing the circuit")
+			return true
+		}
+		return false
+	}
+	b.failures++
+	if b.probing || (!b.open && b.failures >= b.threshold) {
+		if !b.open {
+			log.Printf("cache: backend failed %d times in a row, opening the circuit", b.failures)
+		}
+		b.open, b.probing = true, false
+		b.openedAt = time.Now()
+	}
+	return false
+}
+
+// cancel records that an operation allowed by allow did not complete
+func (b *circuitBreaker) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// missStore is an always empty CacheStore, discarding writes
+type missStore struct{}
+
+func (missStore) Get(key string, value interface{}) error {
+	return ErrCacheMiss
+}
+
+func (missStore) Set(key string, value interface{}, expires time.Duration) error {
+	return nil
+}
+
+func (missStore) Add(key string, value interface{}, expires time.Duration) error {
+	return ErrNotStored
+}
+
+func (missStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return ErrNotStored
+}
+
+func (missStore) Delete(key string) error {
+	return ErrCacheMiss
+}
+
+func (missStore) Increment(key string, delta uint64) (uint64, error) {
+	return 0, ErrCacheMiss
+}
+
+func (missStore) Decrement(key string, delta uint64) (uint64, error) {
+	return 0, ErrCacheMiss
+}
+
+func (missStore) Flush() error {
+	return nil
+}
+
+func (missStore) Close(ctx context.Context) error {
+	return nil
+}
+
+func (missStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	if len(keys) != len(values) {
+		return nil, errGetMultiLength
+	}
+	return make([]bool, len(keys)), nil
+}
+
+func (missStore) SetMulti(items map[string]Item) error {
+	return nil
+}
@@ -0,0 +1,150 @@
+package persistence
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errBackendDown = errors.New("connection refused")
+
+// flakyStore fails every operation while down is set
+type flakyStore struct {
+	CacheStore
+	down  int32
+	calls int32
+}
+
+func (s *flakyStore) Get(key string, value interface{}) error {
+	atomic.AddInt32(&s.calls, 1)
+	if atomic.LoadInt32(&s.down) != 0 {
+		return errBackendDown
+	}
+	return s.CacheStore.Get(key, value)
+}
+
+func (s *flakyStore) Set(key string, value interface{}, expires time.Duration) error {
+	atomic.AddInt32(&s.calls, 1)
+	if atomic.LoadInt32(&s.down) != 0 {
+		return errBackendDown
+	}
+	return s.CacheStore.Set(key, value, expires)
+}
+
+var newCircuitBreakerStore = func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
+	return NewCircuitBreakerStore(NewInMemoryStore(defaultExpiration), nil, 3, time.Second)
+}
+
+// Test typical cache interactions
+func TestCircuitBreakerCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newCircuitBreakerStore)
+}
+
+func TestCircuitBreakerCache_IncrDecr(t *testing.T) {
+	incrDecr(t, newCircuitBreakerStore)
+}
+
+func TestCircuitBreakerCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newCircuitBreakerStore)
+}
+
+func TestCircuitBreakerCache_Replace(t *testing.T) {
+	testReplace(t, newCircuitBreakerStore)
+}
+
+func TestCircuitBreakerCache_Add(t *testing.T) {
+	testAdd(t, newCircuitBreakerStore)
+}
+
+func TestCircuitBreakerStore_Trip(t *testing.T) {
+	backend := &flakyStore{CacheStore: NewInMemoryStore(time.Hour)}
+	fallback := NewInMemoryStore(time.Hour)
+	store := NewCircuitBreakerStore(backend, fallback, 3, 50*time.Millisecond)
+
+	backend.Set("a", "backend", DEFAULT)
+	atomic.StoreInt32(&backend.down, 1)
+
+	// Failures are served by the fallback until the circuit opens
+	var s string
+	for i := 0; i < 3; i++ {
+		if err := store.Get("a", &s); err != ErrCacheMiss {
+			t.Errorf("Expected a miss from the fallback, got: %v", err)
+		}
+	}
+	if !store.Open() {
+		t.Fatalf("Expected the circuit to be open after 3 failures")
+	}
+	calls := atomic.LoadInt32(&backend.calls)
+	if err := store.Set("a", "fallback", DEFAULT); err != nil {
+		t.Errorf("Expected the fallback to store the value, got: %v", err)
+	}
+	if err := store.Get("a", &s); err != nil || s != "fallback" {
+		t.Errorf("Expected fallback, got %s, %v", s, err)
+	}
+	if n := atomic.LoadInt32(&backend.calls); n != calls {
+		t.Errorf("Expected the backend to be left alone while open, got %d calls", n-calls)
+	}
+
+	// A failed probe keeps the circuit open
+	time.Sleep(60 * time.Millisecond)
+	store.Get("a", &s)
+	if !store.Open() {
+		t.Errorf("Expected a failed probe to keep the circuit open")
+	}
+
+	// A successful probe closes it, and flushes the fallback
+	atomic.StoreInt32(&backend.down, 0)
+	time.Sleep(60 * time.Millisecond)
+	if err := store.Get("a", &s); err != nil || s != "backend" {
+		t.Errorf("Expected the backend value, got %s, %v", s, err)
+	}
+	if store.Open() {
+		t.Errorf("Expected the circuit to be closed")
+	}
+	if err := fallback.Get("a", &s); err != ErrCacheMiss {
+		t.Errorf("Expected the fallback to be flushed, got: %v", err)
+	}
+}
+
+func TestCircuitBreakerStore_NegativeHits(t *testing.T) {
+	backend := NewInMemoryStore(time.Hour)
+	store := NewCircuitBreakerStore(backend, nil, 3, time.Minute)
+	backend.Set("present", "value", DEFAULT)
+
+	// Negative hits and other outcomes are not failures of the backend
+	var s string
+	for i := 0; i < 5; i++ {
+		if err := SetNegative(store, "absent", time.Minute); err != nil {
+			t.Fatalf("Error caching a negative result: %s", err)
+		}
+		if err := store.Get("absent", &s); err != ErrNegativeHit {
+			t.Errorf("Expected ErrNegativeHit, got: %v", err)
+		}
+		if err := store.Add("present", "other", DEFAULT); err != ErrNotStored {
+			t.Errorf("Expected ErrNotStored, got: %v", err)
+		}
+	}
+	if store.Open() {
+		t.Fatalf("Expected the circuit to stay closed")
+	}
+	if err := store.Get("present", &s); err != nil || s != "value" {
+		t.Errorf("Expected the value of an existing key, got %q, %v", s, err)
+	}
+}
+
+func TestCircuitBreakerStore_WithoutFallback(t *testing.T) {
+	backend := &flakyStore{CacheStore: NewInMemoryStore(time.Hour), down: 1}
+	store := NewCircuitBreakerStore(backend, nil, 1, time.Hour)
+
+	var s string
+	if err := store.Get("a", &s); err != ErrCacheMiss {
+		t.Errorf("Expected a miss, got: %v", err)
+	}
+	if err := store.Set("a", "value", DEFAULT); err != nil {
+		t.Errorf("Expected the write to be discarded, got: %v", err)
+	}
+	if err := store.Add("a", "value", DEFAULT); err != ErrNotStored {
+		t.Errorf("Expected ErrNotStored, got: %v", err)
+	}
+}
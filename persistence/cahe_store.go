@@ -1,8 +1,11 @@
 package persistence
 
 import (
+	"context"
 	"errors"
 	"time"
+
+	"github.com/mlsen/cache/utils"
 )
 
 const (
@@ -15,8 +18,48 @@ var (
 	ErrCacheMiss    = errors.New("cache: key not found.")
 	ErrNotStored    = errors.New("cache: not stored.")
 	ErrNotSupport   = errors.New("cache: not support.")
+
+	ErrNegativeCounter = errors.New("cache: counter value is negative.")
+	ErrCounterOverflow = errors.New("cache: counter value overflows int64.")
+
+	ErrSchemaVersionMismatch = errors.New("cache: value was written with another schema version.")
+	ErrNotCluster            = errors.New("cache: not connected to a cluster.")
+	ErrReadOnly              = errors.New("cache: store is read-only.")
+	ErrCASConflict           = errors.New("cache: item was modified since it was read.")
+	ErrSnapshotVersion       = errors.New("cache: unsupported snapshot version.")
+	ErrCacheUnavailable      = errors.New("cache: too many operations in flight.")
+	ErrNotRaw                = errors.New("cache: item is not a byte slice.")
+	ErrValueTooLarge         = errors.New("cache: item is too large.")
+	ErrIdempotencyConflict   = errors.New("cache: idempotency key was used by another request.")
+	ErrNegativeHit           = utils.ErrNegativeHit
 )
 
+// outcomeErrors are the errors reporting the outcome of an operation, such as
+// a miss or a conflict, rather than a failure of the backend. Stores tracking
+// the health of their backends, such as CircuitBreakerStore, do not count
+// them as failures.
+var outcomeErrors = map[error]bool{
+	ErrCacheMiss:             true,
+	ErrNotStored:             true,
+	ErrNotSupport:            true,
+	ErrNegativeCounter:       true,
+	ErrCounterOverflow:       true,
+	ErrSchemaVersionMismatch: true,
+	ErrReadOnly:              true,
+	ErrCASConflict:           true,
+	ErrNotRaw:                true,
+	ErrValueTooLarge:         true,
+	ErrIdempotencyConflict:   true,
+	ErrNegativeHit:           true,
+	errGetMultiLength:        true,
+}
+
+// isBackendError reports whether err is a failure of the backend, rather
+// than an outcome of the operation
+func isBackendError(err error) bool {
+	return err != nil && !outcomeErrors[err]
+}
+
 // CacheStore is the interface of a cache backend
 type CacheStore interface {
 	// Get retrieves an item from the cache. Returns the item or nil, and a bool indicating
@@ -37,12 +80,75 @@ type CacheStore interface {
 	// Delete removes an item from the cache. Does nothing if the key is not in the cache.
 	Delete(key string) error
 
-	// Increment increments a real number, and returns error if the value is not real
+	// Increment increments a real number, and returns error if the value is not real.
+	// Counters are never created implicitly: ErrCacheMiss is returned if the key
+	// does not exist, which tells a counter that was never set apart from one that is 0.
 	Increment(key string, data uint64) (uint64, error)
 
-	// Decrement decrements a real number, and returns error if the value is not real
+	// Decrement decrements a real number, and returns error if the value is not real.
+	// Like Increment, it returns ErrCacheMiss if the key does not exist.
 	Decrement(key string, data uint64) (uint64, error)
 
 	// Flush seletes all items from the cache.
 	Flush() error
+
+	// GetMulti retrieves the items stored at keys into the pointers at the same
+	// index of values, and reports for each key whether it was found. Stores
+	// fetch all keys in as few round trips as they can.
+	GetMulti(keys []string, values []interface{}) (found []bool, err error)
+
+	// SetMulti sets several items to the cache, replacing any existing item.
+	SetMulti(items map[string]Item) error
+
+	// Close applies the writes the store buffers, stops its background
+	// goroutines, releases its connections and closes the stores it wraps,
+	// so that services terminate cleanly and tests do not leak goroutines.
+	// It returns ctx.Err() if ctx is done first. The store must not be used
+	// afterwards, unless documented otherwise.
+	Close(ctx context.Context) error
+}
+
+// Item is a value and its expiration, as stored by SetMulti
+type Item struct {
+	Value  interface{}
+	Expire time.Duration
+}
+
+var errGetMultiLength = errors.New("cache: GetMulti needs as many values as keys.")
+
+// getMulti implements GetMulti with one Get per key
+func getMulti(store CacheStore, keys []string, values []interface{}) ([]bool, error) {
+	if len(keys) != len(values) {
+		return nil, errGetMultiLength
+	}
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		err := store.Get(key, values[i])
+		if err == ErrCacheMiss || err == ErrNegativeHit {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found[i] = true
+	}
+	return found, nil
+}
+
+// SetNegative caches in store that key has no value, e.g. because the
+// resource it stands for does not exist, for expires. Get then returns
+// ErrNegativeHit for key, telling a known absence apart from a miss, and
+// GetMulti reports key as not found.
+func SetNegative(store CacheStore, key string, expires time.Duration) error {
+	return store.Set(key, utils.NegativeValue(), expires)
+}
+
+// setMulti implements SetMulti with one Set per item
+func setMulti(store CacheStore, items map[string]Item) error {
+	for key, item := range items {
+		if err := store.Set(key, item.Value, item.Expire); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -1,7 +1,12 @@
 package persistence
 
 import (
+	"context"
 	"math"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -71,6 +76,36 @@ func incrDecr(t *testing.T, newCache cacheFactory) {
 	}
 }
 
+// Test that a counter that was never set is told apart from one that is 0
+func counterPresence(t *testing.T, newCache cacheFactory) {
+	var err error
+	cache := newCache(t, time.Hour)
+
+	if _, err = cache.Increment("untouched", 0); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss incrementing a counter that was never set: %s", err)
+	}
+	if _, err = cache.Decrement("untouched", 0); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss decrementing a counter that was never set: %s", err)
+	}
+
+	if err = cache.Set("zero", 0, DEFAULT); err != nil {
+		t.Errorf("Error setting int: %s", err)
+	}
+	newValue, err := cache.Increment("zero", 0)
+	if err != nil {
+		t.Errorf("Error incrementing a counter set to 0: %s", err)
+	}
+	if newValue != 0 {
+		t.Errorf("Expected 0, was %d", newValue)
+	}
+	if newValue, err = cache.Decrement("zero", 1); err != nil {
+		t.Errorf("Error decrementing a counter set to 0: %s", err)
+	}
+	if newValue != 0 {
+		t.Errorf("Expected 0, was %d", newValue)
+	}
+}
+
 func expiration(t *testing.T, newCache cacheFactory) {
 	// memcached does not support expiration times less than 1 second.
 	var err error
@@ -126,6 +161,10 @@ func emptyCache(t *testing.T, newCache cacheFactory) {
 		t.Errorf("Expected ErrCacheMiss for non-existent key: %s", err)
 	}
 
+	if err = DeleteQuiet(cache, "notexist", "notexist2"); err != nil {
+		t.Errorf("Expected no error quietly deleting non-existent keys: %s", err)
+	}
+
 	_, err = cache.Increment("notexist", 1)
 	if err != ErrCacheMiss {
 		t.Errorf("Expected cache miss incrementing non-existent key: %s", err)
@@ -200,3 +239,464 @@ func testAdd(t *testing.T, newCache cacheFactory) {
 		t.Errorf("Expected 3, got: %d", i)
 	}
 }
+
+// Test GetMulti and SetMulti, including empty values
+func getSetMulti(t *testing.T, newCache cacheFactory) {
+	cache := newCache(t, time.Hour)
+
+	err := cache.SetMulti(map[string]Item{
+		"multi:string": {"foo", DEFAULT},
+		"multi:int":    {42, DEFAULT},
+		"multi:empty":  {[]byte{}, DEFAULT},
+	})
+	if err != nil {
+		t.Fatalf("Error setting values: %s", err)
+	}
+
+	var (
+		s     string
+		i     int
+		empty []byte
+		miss  string
+	)
+	keys := []string{"multi:string", "multi:missing", "multi:int", "multi:empty"}
+	found, err := cache.GetMulti(keys, []interface{}{&s, &miss, &i, &empty})
+	if err != nil {
+		t.Fatalf("Error getting values: %s", err)
+	}
+	expected := []bool{true, false, true, true}
+	for n := range keys {
+		if found[n] != expected[n] {
+			t.Errorf("Expected found to be %v for %s, got %v", expected[n], keys[n], found[n])
+		}
+	}
+	if s != "foo" || i != 42 || len(empty) != 0 {
+		t.Errorf("Expected foo, 42 and an empty value, got %q, %d, %q", s, i, empty)
+	}
+
+	if _, err = cache.GetMulti(keys, nil); err == nil {
+		t.Errorf("Expected an error for mismatched keys and values")
+	}
+}
+
+// Test tag based invalidation
+func tagInvalidation(t *testing.T, store TagStore) {
+	if err := SetWithTags(store, "user:42:profile", "profile", DEFAULT, "user:42"); err != nil {
+		t.Fatalf("Error setting a tagged value: %s", err)
+	}
+	if err := SetWithTags(store, "user:42:orders", "orders", DEFAULT, "user:42", "orders"); err != nil {
+		t.Fatalf("Error setting a tagged value: %s", err)
+	}
+	if err := SetWithTags(store, "user:7:orders", "orders", DEFAULT, "orders"); err != nil {
+		t.Fatalf("Error setting a tagged value: %s", err)
+	}
+
+	if err := store.InvalidateTag("user:42"); err != nil {
+		t.Fatalf("Error invalidating a tag: %s", err)
+	}
+	var s string
+	for _, key := range []string{"user:42:profile", "user:42:orders"} {
+		if err := store.Get(key, &s); err != ErrCacheMiss {
+			t.Errorf("Expected %s to be invalidated, got: %v", key, err)
+		}
+	}
+	if err := store.Get("user:7:orders", &s); err != nil || s != "orders" {
+		t.Errorf("Expected untagged key to be kept, got %s, %v", s, err)
+	}
+
+	if err := store.InvalidateTag("orders"); err != nil {
+		t.Fatalf("Error invalidating a tag: %s", err)
+	}
+	if err := store.Get("user:7:orders", &s); err != ErrCacheMiss {
+		t.Errorf("Expected user:7:orders to be invalidated, got: %v", err)
+	}
+	if err := store.InvalidateTag("unknown"); err != nil {
+		t.Errorf("Unexpected error invalidating an unknown tag: %s", err)
+	}
+}
+
+// Test values set with a soft and a hard TTL
+func softExpiration(t *testing.T, newStore cacheFactory) {
+	store := newStore(t, time.Hour)
+
+	if err := SetSoft(store, "soft", "value", 100*time.Millisecond, 2*time.Second); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	var s string
+	stale, err := GetSoft(store, "soft", &s)
+	if err != nil || stale || s != "value" {
+		t.Errorf("Expected a fresh value, got: %q, stale %v, %v", s, stale, err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	s = ""
+	stale, err = GetSoft(store, "soft", &s)
+	if err != nil || !stale || s != "value" {
+		t.Errorf("Expected a stale value, got: %q, stale %v, %v", s, stale, err)
+	}
+
+	time.Sleep(2 * time.Second)
+	if _, err := GetSoft(store, "soft", &s); err != ErrCacheMiss {
+		t.Errorf("Expected a miss past the hard TTL, got: %v", err)
+	}
+}
+
+// Test extending the expiration of items without writing them
+func touchExpiration(t *testing.T, store TouchStore) {
+	for _, key := range []string{"touched", "persisted"} {
+		if err := store.Set(key, "value", time.Second); err != nil {
+			t.Fatalf("Error setting a value: %s", err)
+		}
+	}
+	if err := store.Touch("touched", time.Hour); err != nil {
+		t.Errorf("Error touching an item: %s", err)
+	}
+	if err := store.Touch("persisted", FOREVER); err != nil {
+		t.Errorf("Error touching an item: %s", err)
+	}
+	if err := store.Touch("notexist", time.Hour); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss touching a non-existent key, got: %v", err)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	for _, key := range []string{"touched", "persisted"} {
+		var s string
+		if err := store.Get(key, &s); err != nil || s != "value" {
+			t.Errorf("Expected %s to outlive its original expiration, got: %q, %v", key, s, err)
+		}
+	}
+}
+
+// Test swapping and consuming items with GetSet and GetDel
+func swapValues(t *testing.T, store CacheStore) {
+	var old string
+	if err := GetSet(store, "token", "first", time.Hour, &old); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss swapping a non-existent key, got: %v", err)
+	}
+	if err := GetSet(store, "token", "second", time.Hour, &old); err != nil || old != "first" {
+		t.Errorf("Expected to read the swapped value, got: %q, %v", old, err)
+	}
+
+	var consumers sync.WaitGroup
+	var consumed int32
+	for i := 0; i < 10; i++ {
+		consumers.Add(1)
+		go func() {
+			defer consumers.Done()
+			var s string
+			err := GetDel(store, "token", &s)
+			switch {
+			case err == nil && s == "second":
+				atomic.AddInt32(&consumed, 1)
+			case err != ErrCacheMiss && err != ErrNegativeHit:
+				t.Errorf("Expected to consume the value or miss, got: %q, %v", s, err)
+			}
+		}()
+	}
+	consumers.Wait()
+	if consumed != 1 {
+		t.Errorf("Expected the value to be consumed once, got %d times", consumed)
+	}
+	var s string
+	if err := store.Get("token", &s); err != ErrCacheMiss && err != ErrNegativeHit {
+		t.Errorf("Expected a consumed value to be deleted, got: %q, %v", s, err)
+	}
+	if err := GetSet(store, "token", "third", time.Hour, &old); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss swapping a consumed key, got: %v", err)
+	}
+}
+
+// Test adding bytes to both ends of items with Append and Prepend
+func rawAppend(t *testing.T, store RawStore) {
+	if err := store.Set("raw", []byte("middle"), time.Hour); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	if err := store.Append("raw", []byte("-end")); err != nil {
+		t.Errorf("Error appending to an item: %s", err)
+	}
+	if err := store.Prepend("raw", []byte("start-")); err != nil {
+		t.Errorf("Error prepending to an item: %s", err)
+	}
+	var b []byte
+	if err := store.Get("raw", &b); err != nil || string(b) != "start-middle-end" {
+		t.Errorf("Expected the bytes to be added to the item, got: %q, %v", b, err)
+	}
+	if ttlStore, ok := store.(TTLStore); ok {
+		if ttl, err := ttlStore.GetWithTTL("raw", &b); err != nil || ttl <= 0 || ttl > time.Hour {
+			t.Errorf("Expected the item to keep its expiration, got: %s, %v", ttl, err)
+		}
+	}
+
+	if err := store.Append("notexist", []byte("data")); err != ErrNotStored {
+		t.Errorf("Expected ErrNotStored appending to a non-existent key, got: %v", err)
+	}
+	if err := store.Prepend("notexist", []byte("data")); err != ErrNotStored {
+		t.Errorf("Expected ErrNotStored prepending to a non-existent key, got: %v", err)
+	}
+	if err := store.Get("notexist", &b); err != ErrCacheMiss {
+		t.Errorf("Expected a non-existent key to stay missing, got: %q, %v", b, err)
+	}
+}
+
+// Test listing keys by prefix
+func keyIteration(t *testing.T, store IterableStore) {
+	if err := store.Set("list:a", "value", time.Hour); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	if err := store.Set("list:b", "value", FOREVER); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	if err := store.Set("other", "value", DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	keys, err := store.Keys("list:")
+	sort.Strings(keys)
+	if err != nil || !reflect.DeepEqual(keys, []string{"list:a", "list:b"}) {
+		t.Errorf("Expected the keys starting with list:, got: %v, %v", keys, err)
+	}
+
+	metas := make(map[string]Meta)
+	err = store.Iterate("list:", func(key string, meta Meta) bool {
+		metas[key] = meta
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Error iterating: %s", err)
+	}
+	if ttl := metas["list:a"].TTL; ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Errorf("Expected list:a to expire in an hour, got: %s", ttl)
+	}
+	if ttl := metas["list:b"].TTL; ttl != FOREVER {
+		t.Errorf("Expected list:b not to expire, got: %s", ttl)
+	}
+
+	n := 0
+	err = store.Iterate("", func(string, Meta) bool {
+		n++
+		return false
+	})
+	if err != nil || n != 1 {
+		t.Errorf("Expected the iteration to stop after a key, got %d keys, %v", n, err)
+	}
+}
+
+// Test deleting keys matching a glob pattern
+func patternInvalidation(t *testing.T, store PatternStore) {
+	keys := []string{"page:/api/v1/products/1", "page:/api/v1/products/1/reviews", "page:/api/v1/products", "page:/api/v2/products/1", "page:/api/v1/users/1"}
+	for _, key := range keys {
+		if err := store.Set(key, "value", DEFAULT); err != nil {
+			t.Fatalf("Error setting %s: %s", key, err)
+		}
+	}
+
+	if err := store.InvalidatePattern("page:/api/v[1]/products/*"); err != nil {
+		t.Fatalf("Error invalidating a pattern: %s", err)
+	}
+	var s string
+	for _, key := range keys[:2] {
+		if err := store.Get(key, &s); err != ErrCacheMiss {
+			t.Errorf("Expected %s to be deleted, got: %v", key, err)
+		}
+	}
+	for _, key := range keys[2:] {
+		if err := store.Get(key, &s); err != nil {
+			t.Errorf("Expected %s to be kept, got: %v", key, err)
+		}
+	}
+
+	if err := store.InvalidatePattern("page:/api/v?/*"); err != nil {
+		t.Fatalf("Error invalidating a pattern: %s", err)
+	}
+	for _, key := range keys[2:] {
+		if err := store.Get(key, &s); err != ErrCacheMiss {
+			t.Errorf("Expected %s to be deleted, got: %v", key, err)
+		}
+	}
+}
+
+// Test deleting keys by prefix
+func prefixDeletion(t *testing.T, store PrefixStore) {
+	for _, key := range []string{"page:/a", "page:/a/b", "page:/a*", "page:/b", "other"} {
+		if err := store.Set(key, "value", DEFAULT); err != nil {
+			t.Fatalf("Error setting %s: %s", key, err)
+		}
+	}
+
+	// Glob characters in the prefix are matched literally
+	if err := store.DeletePrefix("page:/a*"); err != nil {
+		t.Fatalf("Error deleting a prefix: %s", err)
+	}
+	var s string
+	if err := store.Get("page:/a/b", &s); err != nil {
+		t.Errorf("Expected page:/a/b to be kept, got: %v", err)
+	}
+
+	if err := store.DeletePrefix("page:/a"); err != nil {
+		t.Fatalf("Error deleting a prefix: %s", err)
+	}
+	for _, key := range []string{"page:/a", "page:/a/b", "page:/a*"} {
+		if err := store.Get(key, &s); err != ErrCacheMiss {
+			t.Errorf("Expected %s to be deleted, got: %v", key, err)
+		}
+	}
+	for _, key := range []string{"page:/b", "other"} {
+		if err := store.Get(key, &s); err != nil {
+			t.Errorf("Expected %s to be kept, got: %v", key, err)
+		}
+	}
+}
+
+func negativeCaching(t *testing.T, newCache cacheFactory) {
+	cache := newCache(t, time.Hour)
+	if err := SetNegative(cache, "missing", DEFAULT); err != nil {
+		t.Fatalf("Error caching a negative result: %s", err)
+	}
+	if err := cache.Set("present", "value", DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	var s string
+	if err := cache.Get("missing", &s); err != ErrNegativeHit {
+		t.Errorf("Expected ErrNegativeHit, got: %v", err)
+	}
+
+	var present, missing string
+	found, err := cache.GetMulti([]string{"present", "missing"}, []interface{}{&present, &missing})
+	if err != nil {
+		t.Fatalf("Error getting multiple values: %s", err)
+	}
+	if !found[0] || present != "value" || found[1] {
+		t.Errorf("Expected only present to be found, got: %v (%q)", found, present)
+	}
+}
+
+func distributedLock(t *testing.T, store CacheStore) {
+	token, acquired, err := TryLock(store, "lock", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("Expected to acquire the lock, got %v (%v)", acquired, err)
+	}
+	if _, acquired, err := TryLock(store, "lock", time.Minute); err != nil || acquired {
+		t.Errorf("Expected the lock to be held, got %v (%v)", acquired, err)
+	}
+
+	// Releasing with another token keeps the lock
+	if err := Unlock(store, "lock", "other"); err != nil {
+		t.Errorf("Error releasing the lock: %s", err)
+	}
+	if _, acquired, _ := TryLock(store, "lock", time.Minute); acquired {
+		t.Error("Expected the lock to be kept")
+	}
+
+	if err := Unlock(store, "lock", token); err != nil {
+		t.Errorf("Error releasing the lock: %s", err)
+	}
+	token, acquired, err = TryLock(store, "lock", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("Expected to acquire the released lock, got %v (%v)", acquired, err)
+	}
+
+	// Lock waits for the lock to be released, until its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := Lock(ctx, store, "lock", time.Minute); err != context.DeadlineExceeded {
+		t.Errorf("Expected the wait to time out, got: %v", err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		Unlock(store, "lock", token)
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := Lock(ctx, store, "lock", time.Minute); err != nil {
+		t.Errorf("Expected to acquire the lock once released, got: %v", err)
+	}
+}
+
+func ttlInspection(t *testing.T, newCache cacheFactory) {
+	cache := newCache(t, time.Hour)
+	store, ok := cache.(TTLStore)
+	if !ok {
+		t.Fatalf("Expected a TTLStore, got %T", cache)
+	}
+	if err := store.Set("expiring", "value", DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	if err := store.Set("forever", "value", FOREVER); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	var s string
+	ttl, err := store.GetWithTTL("expiring", &s)
+	if err != nil || s != "value" {
+		t.Errorf("Expected to get the value, got %q (%v)", s, err)
+	}
+	if ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Errorf("Expected a TTL close to an hour, got %s", ttl)
+	}
+	if ttl, err := store.GetWithTTL("forever", &s); err != nil || ttl != FOREVER {
+		t.Errorf("Expected FOREVER, got %s (%v)", ttl, err)
+	}
+	if _, err := store.GetWithTTL("missing", &s); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss, got: %v", err)
+	}
+
+	if exists, err := store.Exists("expiring"); err != nil || !exists {
+		t.Errorf("Expected the key to exist, got %v (%v)", exists, err)
+	}
+	if exists, err := store.Exists("missing"); err != nil || exists {
+		t.Errorf("Expected the key not to exist, got %v (%v)", exists, err)
+	}
+}
+
+func compareAndSwap(t *testing.T, newCache cacheFactory) {
+	cache := newCache(t, time.Hour)
+	store, ok := cache.(CASStore)
+	if !ok {
+		t.Fatalf("Expected a CASStore, got %T", cache)
+	}
+
+	// Version 0 stands for a missing item
+	if err := store.CompareAndSwap("key", "first", 0, DEFAULT); err != nil {
+		t.Fatalf("Error creating the item: %s", err)
+	}
+	if err := store.CompareAndSwap("key", "again", 0, DEFAULT); err != ErrCASConflict {
+		t.Errorf("Expected ErrCASConflict, got: %v", err)
+	}
+
+	var s string
+	version, err := store.GetWithVersion("key", &s)
+	if err != nil || s != "first" {
+		t.Fatalf("Expected to get the value, got %q (%v)", s, err)
+	}
+	if err = store.CompareAndSwap("key", "second", version, DEFAULT); err != nil {
+		t.Fatalf("Error swapping the value: %s", err)
+	}
+	// The item changed since version was read
+	if err = store.CompareAndSwap("key", "lost", version, DEFAULT); err != ErrCASConflict {
+		t.Errorf("Expected ErrCASConflict, got: %v", err)
+	}
+	if err = store.Get("key", &s); err != nil || s != "second" {
+		t.Errorf("Expected the swapped value, got %q (%v)", s, err)
+	}
+
+	// Writes other than CompareAndSwap change the version too
+	version, _ = store.GetWithVersion("key", &s)
+	if err = store.Set("key", "third", DEFAULT); err != nil {
+		t.Fatalf("Error setting the value: %s", err)
+	}
+	if err = store.CompareAndSwap("key", "lost", version, DEFAULT); err != ErrCASConflict {
+		t.Errorf("Expected ErrCASConflict, got: %v", err)
+	}
+
+	version, _ = store.GetWithVersion("key", &s)
+	if err = store.Delete("key"); err != nil {
+		t.Fatalf("Error deleting the value: %s", err)
+	}
+	if err = store.CompareAndSwap("key", "lost", version, DEFAULT); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss, got: %v", err)
+	}
+	if _, err = store.GetWithVersion("key", &s); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss, got: %v", err)
+	}
+}
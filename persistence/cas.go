@@ -0,0 +1,74 @@
+package persistence
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// compareAndSwapSource sets KEYS[1] to ARGV[2], expiring in ARGV[3]
+// milliseconds if positive, if the SHA1 of its value starts with the
+// hexadecimal version ARGV[1], or if it does not exist and ARGV[1] is "0".
+// It returns 1 if the key was set, 0 on a conflict and -1 if the key does
+// not exist.
+const compareAndSwapSource = `
+local current = redis.call("GET", KEYS[1])
+if current then
+	if ARGV[1] == "0" or string.sub(redis.sha1hex(current), 1, 16) ~= ARGV[1] then
+		return 0
+	end
+elseif ARGV[1] ~= "0" then
+	return -1
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+else
+	redis.call("SET", KEYS[1], ARGV[2])
+end
+return 1
+`
+
+// CASStore is implemented by stores supporting optimistic concurrency: an
+// item read along with its version is only overwritten if nobody wrote it
+// since, so that concurrent read-modify-write cycles do not lose updates.
+type CASStore interface {
+	CacheStore
+
+	// GetWithVersion works like Get, and additionally returns the version of
+	// the item, to pass to CompareAndSwap. The version of negative entries
+	// is returned along with ErrNegativeHit, so that they can be swapped.
+	GetWithVersion(key string, value interface{}) (version uint64, err error)
+
+	// CompareAndSwap sets key to value if it still holds the item at
+	// version, or if it holds no item and version is 0. It returns
+	// ErrCASConflict if the item was written since it was read, and
+	// ErrCacheMiss if it was deleted or expired.
+	CompareAndSwap(key string, value interface{}, version uint64, expires time.Duration) error
+}
+
+// casVersion returns the version of an item stored as b, for stores without
+// native versions. It matches the one compareAndSwapSource computes.
+func casVersion(b []byte) uint64 {
+	sum := sha1.Sum(b)
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// casVersionArg formats version as an argument of compareAndSwapSource
+func casVersionArg(version uint64) string {
+	if version == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%016x", version)
+}
+
+// casResult converts the result of compareAndSwapSource to an error
+func casResult(result int64) error {
+	switch result {
+	case 0:
+		return ErrCASConflict
+	case -1:
+		return ErrCacheMiss
+	}
+	return nil
+}
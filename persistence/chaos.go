@@ -0,0 +1,225 @@
+package persistence
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ChaosStore is a CacheStore injecting latency, errors and misses into the
+// operations of the store it wraps, so that services can test how they
+// degrade when their cache is slow or failing, e.g. that their timeouts and
+// fallbacks work, without breaking a real backend.
+type ChaosStore struct {
+	store CacheStore
+	ctx   context.Context
+
+	minLatency time.Duration
+	maxLatency time.Duration
+	errorRate  float64
+	err        error
+	missRate   float64
+
+	mu   *sync.Mutex
+	rand *rand.Rand
+}
+
+// ChaosOption configures the faults a ChaosStore injects
+type ChaosOption func(*ChaosStore)
+
+// WithLatency delays every operation by a duration picked uniformly between
+// min and max. Operations of a store bound to a context with WithContext
+// stop waiting, and fail with the error of the context, once it is done.
+func WithLatency(min, max time.Duration) ChaosOption {
+	return func(c *ChaosStore) {
+		if max < min {
+			max = min
+		}
+		c.minLatency, c.maxLatency = min, max
+	}
+}
+
+// WithErrorRate fails the given fraction of the operations with err,
+// without calling the wrapped store. A nil err fails them with
+// ErrCacheUnavailable.
+func WithErrorRate(rate float64, err error) ChaosOption {
+	return func(c *ChaosStore) {
+		if err == nil {
+			err = ErrCacheUnavailable
+		}
+		c.errorRate, c.err = rate, err
+	}
+}
+
+// WithMissRate makes the given fraction of the keys read with Get and
+// GetMulti miss, as if they had been evicted
+func WithMissRate(rate float64) ChaosOption {
+	return func(c *ChaosStore) {
+		c.missRate = rate
+	}
+}
+
+// WithChaosSeed seeds the random faults, so that a test injects the same
+// faults on every run
+func WithChaosSeed(seed int64) ChaosOption {
+	return func(c *ChaosStore) {
+		c.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// NewChaosStore returns a ChaosStore injecting into the operations of store
+// the faults configured by options. Without options, it injects none.
+func NewChaosStore(store CacheStore, options ...ChaosOption) *ChaosStore {
+	c := &ChaosStore{
+		store: store,
+		ctx:   context.Background(),
+		mu:    &sync.Mutex{},
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// WithContext (see ContextBinder interface)
+//
+// The bound store shares the random faults of c.
+func (c *ChaosStore) WithContext(ctx context.Context) CacheStore {
+	bound := *c
+	bound.store = BindContext(ctx, c.store)
+	bound.ctx = ctx
+	return &bound
+}
+
+// chance reports whether an event of probability rate happens
+func (c *ChaosStore) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rand.Float64() < rate
+}
+
+// inject waits for the latency of an operation, and returns the error it
+// must fail with, if any
+func (c *ChaosStore) inject() error {
+	if c.maxLatency > 0 {
+		latency := c.minLatency
+		if c.maxLatency > c.minLatency {
+			c.mu.Lock()
+			latency += time.Duration(c.rand.Int63n(int64(c.maxLatency - c.minLatency)))
+			c.mu.Unlock()
+		}
+		timer := time.NewTimer(latency)
+		select {
+		case <-timer.C:
+		case <-c.ctx.Done():
+			timer.Stop()
+			return c.ctx.Err()
+		}
+	}
+	if c.chance(c.errorRate) {
+		return c.err
+	}
+	return nil
+}
+
+// Get (see CacheStore interface)
+func (c *ChaosStore) Get(key string, value interface{}) error {
+	if err := c.inject(); err != nil {
+		return err
+	}
+	if c.chance(c.missRate) {
+		return ErrCacheMiss
+	}
+	return c.store.Get(key, value)
+}
+
+// Set (see CacheStore interface)
+func (c *ChaosStore) Set(key string, value interface{}, expires time.Duration) error {
+	if err := c.inject(); err != nil {
+		return err
+	}
+	return c.store.Set(key, value, expires)
+}
+
+// Add (see CacheStore interface)
+func (c *ChaosStore) Add(key string, value interface{}, expires time.Duration) error {
+	if err := c.inject(); err != nil {
+		return err
+	}
+	return c.store.Add(key, value, expires)
+}
+
+// Replace (see CacheStore interface)
+func (c *ChaosStore) Replace(key string, value interface{}, expires time.Duration) error {
+	if err := c.inject(); err != nil {
+		return err
+	}
+	return c.store.Replace(key, value, expires)
+}
+
+// Delete (see CacheStore interface)
+func (c *ChaosStore) Delete(key string) error {
+	if err := c.inject(); err != nil {
+		return err
+	}
+	return c.store.Delete(key)
+}
+
+// Increment (see CacheStore interface)
+func (c *ChaosStore) Increment(key string, delta uint64) (uint64, error) {
+	if err := c.inject(); err != nil {
+		return 0, err
+	}
+	return c.store.Increment(key, delta)
+}
+
+// Decrement (see CacheStore interface)
+func (c *ChaosStore) Decrement(key string, delta uint64) (uint64, error) {
+	if err := c.inject(); err != nil {
+		return 0, err
+	}
+	return c.store.Decrement(key, delta)
+}
+
+// Flush (see CacheStore interface)
+func (c *ChaosStore) Flush() error {
+	if err := c.inject(); err != nil {
+		return err
+	}
+	return c.store.Flush()
+}
+
+// Close (see CacheStore interface)
+func (c *ChaosStore) Close(ctx context.Context) error {
+	return c.store.Close(ctx)
+}
+
+// GetMulti (see CacheStore interface)
+func (c *ChaosStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	if err := c.inject(); err != nil {
+		return nil, err
+	}
+	found, err := c.store.GetMulti(keys, values)
+	if err != nil {
+		return nil, err
+	}
+	for i := range found {
+		if found[i] && c.chance(c.missRate) {
+			found[i] = false
+		}
+	}
+	return found, nil
+}
+
+// SetMulti (see CacheStore interface)
+func (c *ChaosStore) SetMulti(items map[string]Item) error {
+	if err := c.inject(); err != nil {
+		return err
+	}
+	return c.store.SetMulti(items)
+}
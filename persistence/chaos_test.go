@@ -0,0 +1,65 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChaosStore_NoFaults(t *testing.T) {
+	store := NewChaosStore(NewInMemoryStore(time.Hour))
+	store.Set("key", "value", DEFAULT)
+	var value string
+	for i := 0; i < 100; i++ {
+		if err := store.Get("key", &value); err != nil {
+			t.Fatalf("Expected no fault, got: %v", err)
+		}
+	}
+}
+
+func TestChaosStore_Rates(t *testing.T) {
+	errInjected := errors.New("injected")
+	store := NewChaosStore(NewInMemoryStore(time.Hour), WithChaosSeed(1),
+		WithErrorRate(0.2, errInjected), WithMissRate(0.3))
+	store.store.Set("key", "value", DEFAULT)
+
+	failed, missed := 0, 0
+	var value string
+	for i := 0; i < 1000; i++ {
+		switch err := store.Get("key", &value); err {
+		case errInjected:
+			failed++
+		case ErrCacheMiss:
+			missed++
+		case nil:
+		default:
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	// 20% of the reads fail, and 30% of the others miss
+	if failed < 150 || failed > 250 {
+		t.Errorf("Expected about 200 failures, got %d", failed)
+	}
+	if missed < 190 || missed > 290 {
+		t.Errorf("Expected about 240 misses, got %d", missed)
+	}
+}
+
+func TestChaosStore_Latency(t *testing.T) {
+	store := NewChaosStore(NewInMemoryStore(time.Hour), WithLatency(20*time.Millisecond, 30*time.Millisecond))
+	start := time.Now()
+	if err := store.Set("key", "value", DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected the write to be delayed, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	var value string
+	if err := BindContext(ctx, store).Get("key", &value); err != context.DeadlineExceeded {
+		t.Errorf("Expected the read to time out, got: %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package persistence
+
+import "context"
+
+// closeAll closes the stores that are not nil, in order, and returns the
+// first error
+func closeAll(ctx context.Context, stores ...CacheStore) error {
+	var first error
+	for _, store := range stores {
+		if store == nil {
+			continue
+		}
+		if err := store.Close(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// awaitDone waits until done is closed, or returns ctx.Err() if ctx is done
+// first
+func awaitDone(ctx context.Context, done <-chan struct{}) error {
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
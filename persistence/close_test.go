@@ -0,0 +1,115 @@
+package persistence
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// closeRecorder records whether the store it wraps was closed
+type closeRecorder struct {
+	CacheStore
+	closed bool
+}
+
+func (s *closeRecorder) Close(ctx context.Context) error {
+	s.closed = true
+	return s.CacheStore.Close(ctx)
+}
+
+func TestClose(t *testing.T) {
+	ctx := context.Background()
+
+	// Stores wrapping another one close it
+	inner := &closeRecorder{CacheStore: NewInMemoryStore(time.Hour)}
+	if err := NewNamespacedStore(inner, "app:").Close(ctx); err != nil {
+		t.Errorf("Unexpected error closing a NamespacedStore: %s", err)
+	}
+	if !inner.closed {
+		t.Errorf("Expected the wrapped store to be closed")
+	}
+
+	// Pending writes are applied before the wrapped store is closed
+	gate := make(chan struct{})
+	backend := &closeRecorder{CacheStore: gatedStore{NewInMemoryStore(time.Hour), gate}}
+	async := NewAsyncStore(backend, 1, 10, OverflowBlock)
+	async.Set("queued", "value", DEFAULT)
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := async.Close(timeout); err != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline to be exceeded, got: %v", err)
+	}
+	if backend.closed {
+		t.Errorf("Expected the wrapped store to be left open while writes are pending")
+	}
+	close(gate)
+	if err := async.Close(ctx); err != nil {
+		t.Errorf("Error closing an AsyncStore: %s", err)
+	}
+	var s string
+	if err := backend.Get("queued", &s); err != nil || s != "value" {
+		t.Errorf("Expected the queued write to be applied, got %q, %v", s, err)
+	}
+	if !backend.closed {
+		t.Errorf("Expected the wrapped store to be closed")
+	}
+
+	sharded := NewShardedInMemoryStore(time.Hour, 4)
+	for i := 0; i < 2; i++ {
+		if err := sharded.Close(ctx); err != nil {
+			t.Errorf("Error closing a ShardedInMemoryStore: %s", err)
+		}
+	}
+}
+
+func TestInMemoryCache_Close(t *testing.T) {
+	expired := make(chan string, 1)
+	store := NewInMemoryStore(time.Hour, WithExpirationCallback(func(key string) {
+		expired <- key
+	}))
+	store.Set("a", "value", 100*time.Millisecond)
+	if err := store.Close(context.Background()); err != nil {
+		t.Fatalf("Error closing the store: %s", err)
+	}
+	store.Set("b", "value", 100*time.Millisecond)
+
+	select {
+	case key := <-expired:
+		t.Errorf("Expected items not to be expired once closed, %s was", key)
+	case <-time.After(300 * time.Millisecond):
+	}
+	var s string
+	if err := store.Get("a", &s); err != ErrCacheMiss {
+		t.Errorf("Expected an expired item to be missed, got: %v", err)
+	}
+}
+
+func TestInMemoryCache_CloseWaitsForExpirations(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	store := NewInMemoryStore(time.Hour, WithExpirationCallback(func(key string) {
+		close(started)
+		<-release
+	}))
+	store.Set("a", "value", 50*time.Millisecond)
+	<-started
+
+	timeout, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := store.Close(timeout); err != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline to be exceeded, got: %v", err)
+	}
+	close(release)
+	if err := store.Close(context.Background()); err != nil {
+		t.Errorf("Error closing the store: %s", err)
+	}
+}
+
+func TestRedisCache_Close(t *testing.T) {
+	store := newRedisStore(t, time.Hour).(*RedisStore)
+	if err := store.Close(context.Background()); err != nil {
+		t.Fatalf("Error closing the store: %s", err)
+	}
+	if err := store.Set("a", "value", DEFAULT); err == nil {
+		t.Errorf("Expected a closed store to fail")
+	}
+}
@@ -0,0 +1,160 @@
+package persistence
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// CacheStoreCtx is the context aware counterpart of CacheStore: every
+// operation takes a context.Context, so that request deadlines and
+// cancellation reach the backend. Use NewCacheStoreCtx to obtain one from
+// any CacheStore.
+type CacheStoreCtx interface {
+	Get(ctx context.Context, key string, value interface{}) error
+	Set(ctx context.Context, key string, value interface{}, expire time.Duration) error
+	Add(ctx context.Context, key string, value interface{}, expire time.Duration) error
+	Replace(ctx context.Context, key string, data interface{}, expire time.Duration) error
+	Delete(ctx context.Context, key string) error
+	Increment(ctx context.Context, key string, data uint64) (uint64, error)
+	Decrement(ctx context.Context, key string, data uint64) (uint64, error)
+	Flush(ctx context.Context) error
+	GetMulti(ctx context.Context, keys []string, values []interface{}) ([]bool, error)
+	SetMulti(ctx context.Context, items map[string]Item) error
+	Close(ctx context.Context) error
+}
+
+// ContextBinder is implemented by stores whose operations can be bound to a
+// context. WithContext returns a copy of the store issuing every operation
+// with ctx.
+type ContextBinder interface {
+	WithContext(ctx context.Context) CacheStore
+}
+
+// BindContext returns store bound to ctx if it implements ContextBinder, and
+// store itself otherwise. The gin middleware uses it to pass the request
+// context to the store.
+func BindContext(ctx context.Context, store CacheStore) CacheStore {
+	if binder, ok := store.(ContextBinder); ok {
+		return binder.WithContext(ctx)
+	}
+	return store
+}
+
+// NewCacheStoreCtx adapts store to CacheStoreCtx. Stores implementing
+// ContextBinder, like RedisStore, abort operations when the context is done.
+// Other stores only check the context before starting an operation.
+func NewCacheStoreCtx(store CacheStore) CacheStoreCtx {
+	return ctxStore{store}
+}
+
+type ctxStore struct {
+	store CacheStore
+}
+
+func (s ctxStore) bind(ctx context.Context) (CacheStore, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return BindContext(ctx, s.store), nil
+}
+
+func (s ctxStore) Get(ctx context.Context, key string, value interface{}) error {
+	store, err := s.bind(ctx)
+	if err != nil {
+		return err
+	}
+	return store.Get(key, value)
+}
+
+func (s ctxStore) Set(ctx context.Context, key string, value interface{}, expire time.Duration) error {
+	store, err := s.bind(ctx)
+	if err != nil {
+		return err
+	}
+	return store.Set(key, value, expire)
+}
+
+func (s ctxStore) Add(ctx context.Context, key string, value interface{}, expire time.Duration) error {
+	store, err := s.bind(ctx)
+	if err != nil {
+		return err
+	}
+	return store.Add(key, value, expire)
+}
+
+func (s ctxStore) Replace(ctx context.Context, key string, data interface{}, expire time.Duration) error {
+	store, err := s.bind(ctx)
+	if err != nil {
+		return err
+	}
+	return store.Replace(key, data, expire)
+}
+
+func (s ctxStore) Delete(ctx context.Context, key string) error {
+	store, err := s.bind(ctx)
+	if err != nil {
+		return err
+	}
+	return store.Delete(key)
+}
+
+func (s ctxStore) Increment(ctx context.Context, key string, data uint64) (uint64, error) {
+	store, err := s.bind(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return store.Increment(key, data)
+}
+
+func (s ctxStore) Decrement(ctx context.Context, key string, data uint64) (uint64, error) {
+	store, err := s.bind(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return store.Decrement(key, data)
+}
+
+func (s ctxStore) Flush(ctx context.Context) error {
+	store, err := s.bind(ctx)
+	if err != nil {
+		return err
+	}
+	return store.Flush()
+}
+
+func (s ctxStore) GetMulti(ctx context.Context, keys []string, values []interface{}) ([]bool, error) {
+	store, err := s.bind(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return store.GetMulti(keys, values)
+}
+
+func (s ctxStore) SetMulti(ctx context.Context, items map[string]Item) error {
+	store, err := s.bind(ctx)
+	if err != nil {
+		return err
+	}
+	return store.SetMulti(items)
+}
+
+func (s ctxStore) Close(ctx context.Context) error {
+	return s.store.Close(ctx)
+}
+
+// WithContext returns a copy of the store whose commands are issued with ctx,
+// so they fail once ctx is done instead of waiting on a stalled server
+func (c *RedisStore) WithContext(ctx context.Context) CacheStore {
+	bound := *c
+	switch client := c.client.(type) {
+	case *redis.Client:
+		bound.client = client.WithContext(ctx)
+	case *redis.ClusterClient:
+		bound.client = client.WithContext(ctx)
+	case *redis.Ring:
+		bound.client = client.WithContext(ctx)
+	}
+	return &bound
+}
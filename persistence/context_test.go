@@ -0,0 +1,71 @@
+package persistence
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+func TestCacheStoreCtx(t *testing.T) {
+	store := NewCacheStoreCtx(NewInMemoryStore(time.Hour))
+
+	ctx := context.Background()
+	if err := store.Set(ctx, "key", "value", DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	var s string
+	if err := store.Get(ctx, "key", &s); err != nil || s != "value" {
+		t.Errorf("Expected value, got %s, %v", s, err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := store.Get(canceled, "key", &s); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got: %v", err)
+	}
+	if _, err := store.Increment(canceled, "key", 1); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got: %v", err)
+	}
+}
+
+func TestRedisCache_WithContext(t *testing.T) {
+	// A server accepting connections but never answering
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+		}
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	client := redis.NewClient(&redis.Options{
+		Addr:        ln.Addr().String(),
+		ReadTimeout: time.Minute,
+	})
+	store := NewCacheStoreCtx(NewRedisCacheFromClient(client, time.Hour))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	var s string
+	if err := store.Get(ctx, "key", &s); err == nil {
+		t.Errorf("Expected an error from a stalled server")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected Get to give up at the deadline, took %s", elapsed)
+	}
+}
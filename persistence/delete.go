@@ -0,0 +1,16 @@
+package persistence
+
+// DeleteQuiet deletes keys from store, ignoring those that are not in the
+// cache, for invalidations that do not care whether the items were cached:
+// Delete returns ErrCacheMiss for them. Every key is deleted, even if
+// deleting one fails, and the first error other than ErrCacheMiss is
+// returned.
+func DeleteQuiet(store CacheStore, keys ...string) error {
+	var first error
+	for _, key := range keys {
+		if err := store.Delete(key); err != nil && err != ErrCacheMiss && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,404 @@
+package persistence
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/mlsen/cache/utils"
+)
+
+// DynamoDB limits the number of keys read by BatchGetItem, and of items
+// written by BatchWriteItem
+const (
+	dynamoBatchGetSize   = 100
+	dynamoBatchWriteSize = 25
+)
+
+// Condition expressions of the conditional writes. #t and :now let them treat
+// items whose expiration has passed, but that DynamoDB did not delete yet, as
+// missing.
+const (
+	dynamoCondMissing = "attribute_not_exists(#k) OR #t <= :now"
+	dynamoCondPresent = "attribute_exists(#k) AND (attribute_not_exists(#t) OR #t > :now)"
+	dynamoCondValue   = dynamoCondPresent + " AND #v = :old"
+)
+
+// DynamoStore represents the cache with DynamoDB persistence, for serverless
+// deployments without a cache server. Items hold the key, the serialized
+// value, and their expiration as a Unix time in seconds, which DynamoDB
+// deletes by itself once TTL is enabled on that attribute. Since DynamoDB
+// deletes expired items lazily, reads and conditional writes also check the
+// expiration.
+type DynamoStore struct {
+	client            dynamodbiface.DynamoDBAPI
+	ctx               context.Context
+	table             string
+	defaultExpiration time.Duration
+
+	keyAttribute     string
+	valueAttribute   string
+	expiresAttribute string
+}
+
+// DynamoOption configures a DynamoStore
+type DynamoOption func(*DynamoStore)
+
+// WithAttributeNames sets the names of the attributes holding the key (the
+// partition key of the table, a string), the value and the expiration of
+// items. They default to "key", "value" and "expires_at".
+func WithAttributeNames(key, value, expires string) DynamoOption {
+	return func(c *DynamoStore) {
+		c.keyAttribute = key
+		c.valueAttribute = value
+		c.expiresAttribute = expires
+	}
+}
+
+// NewDynamoStore returns a DynamoStore keeping its items in table
+func NewDynamoStore(client dynamodbiface.DynamoDBAPI, table string, defaultExpiration time.Duration, opts ...DynamoOption) *DynamoStore {
+	store := &DynamoStore{
+		client:            client,
+		ctx:               context.Background(),
+		table:             table,
+		defaultExpiration: defaultExpiration,
+		keyAttribute:      "key",
+		valueAttribute:    "value",
+		expiresAttribute:  "expires_at",
+	}
+	for _, opt := range opts {
+		opt(store)
+	}
+	return store
+}
+
+// WithContext (see ContextBinder interface)
+func (c *DynamoStore) WithContext(ctx context.Context) CacheStore {
+	bound := *c
+	bound.ctx = ctx
+	return &bound
+}
+
+// Get (see CacheStore interface)
+func (c *DynamoStore) Get(key string, value interface{}) error {
+	item, err := c.get(key)
+	if err != nil {
+		return err
+	}
+	return utils.Deserialize(item[c.valueAttribute].B, value)
+}
+
+// Set (see CacheStore interface)
+func (c *DynamoStore) Set(key string, value interface{}, expires time.Duration) error {
+	item, err := c.item(key, value, c.expiresAt(expires))
+	if err != nil {
+		return err
+	}
+	_, err = c.client.PutItemWithContext(c.ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(c.table),
+		Item:      item,
+	})
+	return err
+}
+
+// Add (see CacheStore interface)
+func (c *DynamoStore) Add(key string, value interface{}, expires time.Duration) error {
+	return c.putIf(key, value, c.expiresAt(expires), dynamoCondMissing, nil)
+}
+
+// Replace (see CacheStore interface)
+func (c *DynamoStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return c.putIf(key, value, c.expiresAt(expires), dynamoCondPresent, nil)
+}
+
+// Delete (see CacheStore interface)
+func (c *DynamoStore) Delete(key string) error {
+	_, err := c.client.DeleteItemWithContext(c.ctx, &dynamodb.DeleteItemInput{
+		TableName:                 aws.String(c.table),
+		Key:                       c.key(key),
+		ConditionExpression:       aws.String(dynamoCondPresent),
+		ExpressionAttributeNames:  c.conditionNames(false),
+		ExpressionAttributeValues: c.conditionValues(nil),
+	})
+	if isConditionFailed(err) {
+		return ErrCacheMiss
+	}
+	return err
+}
+
+// Increment (see CacheStore interface)
+//
+// Like InMemoryStore, the counter wraps around on overflow.
+func (c *DynamoStore) Increment(key string, n uint64) (uint64, error) {
+	return c.incrDecr(key, func(value uint64) uint64 {
+		return value + n
+	})
+}
+
+// Decrement (see CacheStore interface)
+//
+// Like InMemoryStore, the counter stops at 0.
+func (c *DynamoStore) Decrement(key string, n uint64) (uint64, error) {
+	return c.incrDecr(key, func(value uint64) uint64 {
+		if n > value {
+			return 0
+		}
+		return value - n
+	})
+}
+
+// incrDecr updates the counter at key with fn, keeping its expiration. The
+// update is conditioned on the counter being unchanged since it was read, and
+// retried otherwise.
+func (c *DynamoStore) incrDecr(key string, fn func(uint64) uint64) (uint64, error) {
+	for {
+		item, err := c.get(key)
+		if err != nil {
+			return 0, err
+		}
+		old := item[c.valueAttribute].B
+		var value uint64
+		if err := utils.Deserialize(old, &value); err != nil {
+			return 0, err
+		}
+		value = fn(value)
+
+		var expiresAt int64
+		if attr, ok := item[c.expiresAttribute]; ok && attr.N != nil {
+			expiresAt, _ = strconv.ParseInt(*attr.N, 10, 64)
+		}
+		err = c.putIf(key, value, expiresAt, dynamoCondValue, old)
+		if err == ErrNotStored {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		return value, nil
+	}
+}
+
+// Flush (see CacheStore interface)
+//
+// Flush scans the whole table and deletes its items in batches, which is slow
+// and costly on large tables.
+func (c *DynamoStore) Flush() error {
+	var keys []map[string]*dynamodb.AttributeValue
+	err := c.client.ScanPagesWithContext(c.ctx, &dynamodb.ScanInput{
+		TableName:                aws.String(c.table),
+		ProjectionExpression:     aws.String("#k"),
+		ExpressionAttributeNames: map[string]*string{"#k": aws.String(c.keyAttribute)},
+	}, func(page *dynamodb.ScanOutput, _ bool) bool {
+		keys = append(keys, page.Items...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	requests := make([]*dynamodb.WriteRequest, len(keys))
+	for i, key := range keys {
+		requests[i] = &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{Key: key}}
+	}
+	return c.batchWrite(requests)
+}
+
+// Close (see CacheStore interface)
+//
+// It does nothing, as the client may be shared.
+func (c *DynamoStore) Close(ctx context.Context) error {
+	return nil
+}
+
+// GetMulti (see CacheStore interface)
+//
+// Keys are read with BatchGetItem, by batches of 100.
+func (c *DynamoStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	if len(keys) != len(values) {
+		return nil, errGetMultiLength
+	}
+	index := make(map[string]int, len(keys))
+	for i, key := range keys {
+		index[key] = i
+	}
+
+	found := make([]bool, len(keys))
+	now := time.Now().Unix()
+	for start := 0; start < len(keys); start += dynamoBatchGetSize {
+		end := start + dynamoBatchGetSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		request := &dynamodb.KeysAndAttributes{ConsistentRead: aws.Bool(true)}
+		for _, key := range keys[start:end] {
+			request.Keys = append(request.Keys, c.key(key))
+		}
+		pending := map[string]*dynamodb.KeysAndAttributes{c.table: request}
+		for len(pending) > 0 {
+			out, err := c.client.BatchGetItemWithContext(c.ctx, &dynamodb.BatchGetItemInput{RequestItems: pending})
+			if err != nil {
+				return nil, err
+			}
+			for _, item := range out.Responses[c.table] {
+				if c.expired(item, now) {
+					continue
+				}
+				i := index[aws.StringValue(item[c.keyAttribute].S)]
+				err := utils.Deserialize(item[c.valueAttribute].B, values[i])
+				if err == ErrNegativeHit {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				found[i] = true
+			}
+			pending = out.UnprocessedKeys
+		}
+	}
+	return found, nil
+}
+
+// SetMulti (see CacheStore interface)
+//
+// Items are written with BatchWriteItem, by batches of 25.
+func (c *DynamoStore) SetMulti(items map[string]Item) error {
+	requests := make([]*dynamodb.WriteRequest, 0, len(items))
+	for key, item := range items {
+		attrs, err := c.item(key, item.Value, c.expiresAt(item.Expire))
+		if err != nil {
+			return err
+		}
+		requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: attrs}})
+	}
+	return c.batchWrite(requests)
+}
+
+// batchWrite sends requests with BatchWriteItem, resending the unprocessed
+// ones
+func (c *DynamoStore) batchWrite(requests []*dynamodb.WriteRequest) error {
+	for start := 0; start < len(requests); start += dynamoBatchWriteSize {
+		end := start + dynamoBatchWriteSize
+		if end > len(requests) {
+			end = len(requests)
+		}
+		pending := map[string][]*dynamodb.WriteRequest{c.table: requests[start:end]}
+		for len(pending) > 0 {
+			out, err := c.client.BatchWriteItemWithContext(c.ctx, &dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return err
+			}
+			pending = out.UnprocessedItems
+		}
+	}
+	return nil
+}
+
+// get returns the item at key, or ErrCacheMiss if it is missing or expired
+func (c *DynamoStore) get(key string) (map[string]*dynamodb.AttributeValue, error) {
+	out, err := c.client.GetItemWithContext(c.ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(c.table),
+		Key:            c.key(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil || c.expired(out.Item, time.Now().Unix()) {
+		return nil, ErrCacheMiss
+	}
+	return out.Item, nil
+}
+
+// putIf writes an item if condition holds, and returns ErrNotStored otherwise.
+// old is the serialized value dynamoCondValue compares the item with.
+func (c *DynamoStore) putIf(key string, value interface{}, expiresAt int64, condition string, old []byte) error {
+	item, err := c.item(key, value, expiresAt)
+	if err != nil {
+		return err
+	}
+	_, err = c.client.PutItemWithContext(c.ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(c.table),
+		Item:                      item,
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  c.conditionNames(old != nil),
+		ExpressionAttributeValues: c.conditionValues(old),
+	})
+	if isConditionFailed(err) {
+		return ErrNotStored
+	}
+	return err
+}
+
+func (c *DynamoStore) conditionNames(withValue bool) map[string]*string {
+	names := map[string]*string{
+		"#k": aws.String(c.keyAttribute),
+		"#t": aws.String(c.expiresAttribute),
+	}
+	if withValue {
+		names["#v"] = aws.String(c.valueAttribute)
+	}
+	return names
+}
+
+func (c *DynamoStore) conditionValues(old []byte) map[string]*dynamodb.AttributeValue {
+	values := map[string]*dynamodb.AttributeValue{
+		":now": {N: aws.String(strconv.FormatInt(time.Now().Unix(), 10))},
+	}
+	if old != nil {
+		values[":old"] = &dynamodb.AttributeValue{B: old}
+	}
+	return values
+}
+
+func (c *DynamoStore) key(key string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{c.keyAttribute: {S: aws.String(key)}}
+}
+
+// item returns the attributes of an item; it has no expiration if expiresAt
+// is 0
+func (c *DynamoStore) item(key string, value interface{}, expiresAt int64) (map[string]*dynamodb.AttributeValue, error) {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return nil, err
+	}
+	item := c.key(key)
+	item[c.valueAttribute] = &dynamodb.AttributeValue{B: b}
+	if expiresAt != 0 {
+		item[c.expiresAttribute] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(expiresAt, 10))}
+	}
+	return item, nil
+}
+
+// expiresAt returns the expiration time, in Unix seconds rounded up, of an
+// item set now for expires, or 0 if it never expires
+func (c *DynamoStore) expiresAt(expires time.Duration) int64 {
+	switch expires {
+	case DEFAULT:
+		expires = c.defaultExpiration
+	case FOREVER:
+		return 0
+	}
+	if expires <= 0 {
+		return 0
+	}
+	return (time.Now().Add(expires).UnixNano() + int64(time.Second) - 1) / int64(time.Second)
+}
+
+func (c *DynamoStore) expired(item map[string]*dynamodb.AttributeValue, now int64) bool {
+	attr, ok := item[c.expiresAttribute]
+	if !ok || attr.N == nil {
+		return false
+	}
+	expiresAt, err := strconv.ParseInt(*attr.N, 10, 64)
+	return err == nil && expiresAt <= now
+}
+
+func isConditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
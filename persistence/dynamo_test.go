@@ -0,0 +1,208 @@
+package persistence
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// fakeDynamo is an in-memory DynamoDB table, evaluating the condition
+// expressions used by DynamoStore. Expired items are kept, as DynamoDB does
+// until its TTL process deletes them.
+type fakeDynamo struct {
+	dynamodbiface.DynamoDBAPI
+
+	mu    sync.Mutex
+	items map[string]map[string]*dynamodb.AttributeValue
+}
+
+func newFakeDynamo() *fakeDynamo {
+	return &fakeDynamo{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+}
+
+func (f *fakeDynamo) check(key string, condition *string, names map[string]*string, values map[string]*dynamodb.AttributeValue) error {
+	if condition == nil {
+		return nil
+	}
+	item, exists := f.items[key]
+	live := exists
+	if exists {
+		if attr, ok := item[*names["#t"]]; ok {
+			expiresAt, _ := strconv.ParseInt(*attr.N, 10, 64)
+			now, _ := strconv.ParseInt(*values[":now"].N, 10, 64)
+			live = expiresAt > now
+		}
+	}
+	var ok bool
+	switch *condition {
+	case dynamoCondMissing:
+		ok = !live
+	case dynamoCondPresent:
+		ok = live
+	case dynamoCondValue:
+		ok = live && bytes.Equal(item[*names["#v"]].B, values[":old"].B)
+	default:
+		panic("unexpected condition " + *condition)
+	}
+	if !ok {
+		return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "condition failed", nil)
+	}
+	return nil
+}
+
+func (f *fakeDynamo) GetItemWithContext(_ aws.Context, in *dynamodb.GetItemInput, _ ...request.Option) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &dynamodb.GetItemOutput{Item: f.items[*in.Key["key"].S]}, nil
+}
+
+func (f *fakeDynamo) PutItemWithContext(_ aws.Context, in *dynamodb.PutItemInput, _ ...request.Option) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := *in.Item["key"].S
+	if err := f.check(key, in.ConditionExpression, in.ExpressionAttributeNames, in.ExpressionAttributeValues); err != nil {
+		return nil, err
+	}
+	f.items[key] = in.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamo) DeleteItemWithContext(_ aws.Context, in *dynamodb.DeleteItemInput, _ ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := *in.Key["key"].S
+	if err := f.check(key, in.ConditionExpression, in.ExpressionAttributeNames, in.ExpressionAttributeValues); err != nil {
+		return nil, err
+	}
+	delete(f.items, key)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func (f *fakeDynamo) BatchGetItemWithContext(_ aws.Context, in *dynamodb.BatchGetItemInput, _ ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &dynamodb.BatchGetItemOutput{Responses: make(map[string][]map[string]*dynamodb.AttributeValue)}
+	for table, request := range in.RequestItems {
+		for _, key := range request.Keys {
+			if item, ok := f.items[*key["key"].S]; ok {
+				out.Responses[table] = append(out.Responses[table], item)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeDynamo) BatchWriteItemWithContext(_ aws.Context, in *dynamodb.BatchWriteItemInput, _ ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, requests := range in.RequestItems {
+		if len(requests) > dynamoBatchWriteSize {
+			return nil, awserr.New("ValidationException", "too many items", nil)
+		}
+		for _, r := range requests {
+			if r.PutRequest != nil {
+				f.items[*r.PutRequest.Item["key"].S] = r.PutRequest.Item
+			} else {
+				delete(f.items, *r.DeleteRequest.Key["key"].S)
+			}
+		}
+	}
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
+func (f *fakeDynamo) ScanPagesWithContext(_ aws.Context, _ *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool, _ ...request.Option) error {
+	f.mu.Lock()
+	page := &dynamodb.ScanOutput{}
+	for key := range f.items {
+		page.Items = append(page.Items, map[string]*dynamodb.AttributeValue{"key": {S: aws.String(key)}})
+	}
+	f.mu.Unlock()
+	fn(page, true)
+	return nil
+}
+
+var newDynamoStore = func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
+	return NewDynamoStore(newFakeDynamo(), "cache", defaultExpiration)
+}
+
+// Test typical cache interactions
+func TestDynamoCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newDynamoStore)
+}
+
+func TestDynamoCache_IncrDecr(t *testing.T) {
+	incrDecr(t, newDynamoStore)
+}
+
+func TestDynamoCache_CounterPresence(t *testing.T) {
+	counterPresence(t, newDynamoStore)
+}
+
+func TestDynamoCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newDynamoStore)
+}
+
+func TestDynamoCache_Expiration(t *testing.T) {
+	expiration(t, newDynamoStore)
+}
+
+func TestDynamoCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newDynamoStore)
+}
+
+func TestDynamoCache_Replace(t *testing.T) {
+	testReplace(t, newDynamoStore)
+}
+
+func TestDynamoCache_Add(t *testing.T) {
+	testAdd(t, newDynamoStore)
+}
+
+func TestDynamoCache_AttributeNames(t *testing.T) {
+	client := newFakeDynamo()
+	store := NewDynamoStore(client, "cache", time.Hour, WithAttributeNames("key", "data", "ttl"))
+	store.Set("a", "value", DEFAULT)
+
+	item := client.items["a"]
+	if _, ok := item["data"]; !ok {
+		t.Errorf("Expected the value in the data attribute")
+	}
+	if _, ok := item["ttl"]; !ok {
+		t.Errorf("Expected the expiration in the ttl attribute")
+	}
+	// The expired item is still in the table, but Add can overwrite it
+	item["ttl"].N = aws.String(strconv.FormatInt(time.Now().Unix()-1, 10))
+	if err := store.Add("a", "new", DEFAULT); err != nil {
+		t.Errorf("Expected Add to overwrite an expired item, got %s", err)
+	}
+}
+
+func TestDynamoCache_ConcurrentCounters(t *testing.T) {
+	store := NewDynamoStore(newFakeDynamo(), "cache", time.Hour)
+	store.Set("counter", 0, DEFAULT)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.Increment("counter", 1); err != nil {
+				t.Errorf("Error incrementing: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var n uint64
+	if err := store.Get("counter", &n); err != nil || n != 20 {
+		t.Errorf("Expected 20, got %d (%v)", n, err)
+	}
+}
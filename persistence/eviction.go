@@ -0,0 +1,855 @@
+package persistence
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/robfig/go-cache"
+)
+
+// EvictionPolicy selects the items an InMemoryStore evicts once it holds its
+// maximum number of entries
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the least recently used item
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU evicts the least frequently used item, the least recently
+	// used one among those used as rarely. Items are only counted while
+	// cached, so a new item is the first candidate for the next eviction.
+	EvictLFU
+	// EvictTinyLFU evicts the least recently used item, but only to make room
+	// for an item requested more often than it. Request frequencies are
+	// estimated by a compact sketch that remembers keys after they are
+	// evicted, and ages as the store is used, which protects popular items
+	// from bursts of keys requested once.
+	EvictTinyLFU
+)
+
+// InMemoryOption configures optional behaviour of an InMemoryStore
+type InMemoryOption func(*InMemoryStore)
+
+// WithMaxEntries bounds the number of items an InMemoryStore holds. Once it
+// is reached, writing a new key evicts an item selected by policy. With
+// EvictTinyLFU, Set may discard the new item instead, while Add, Replace and
+// the counters always store their item. A maxEntries <= 0 means no limit.
+//
+// Expired items are deleted as they expire, and no longer count towards
+// maxEntries.
+func WithMaxEntries(maxEntries int, policy EvictionPolicy) InMemoryOption {
+	return func(c *InMemoryStore) {
+		c.limit.maxEntries, c.limit.policy = maxEntries, policy
+	}
+}
+
+// WithMaxValueBytes bounds the size of the items an InMemoryStore holds:
+// writing a larger item fails with ErrValueTooLarge, and so does appending
+// to an item beyond it. The size of an item is estimated from the memory
+// used by its key and value. A maxBytes <= 0 means no limit.
+func WithMaxValueBytes(maxBytes int64) InMemoryOption {
+	return func(c *InMemoryStore) {
+		c.limit.maxValueBytes = maxBytes
+	}
+}
+
+// WithMaxTotalBytes bounds the total size of the items an InMemoryStore
+// holds, estimated as with WithMaxValueBytes. Once it is exceeded, items are
+// evicted as selected by the policy given to WithMaxEntries, EvictLRU by
+// default. An item larger than maxBytes fails with ErrValueTooLarge. A
+// maxBytes <= 0 means no limit.
+func WithMaxTotalBytes(maxBytes int64) InMemoryOption {
+	return func(c *InMemoryStore) {
+		c.limit.maxBytes = maxBytes
+	}
+}
+
+// WithEvictionCallback calls onEvict with each item evicted by the limits set
+// with WithMaxEntries and WithMaxTotalBytes, after the operation evicting it returns. Items that
+// expire or are deleted are not reported.
+func WithEvictionCallback(onEvict func(key string, value interface{})) InMemoryOption {
+	return func(c *InMemoryStore) {
+		c.onEvict = onEvict
+	}
+}
+
+// WithExpirationCallback calls onExpire with the key of each item deleted
+// because it expired, as soon as it does. The value has lapsed by then, so
+// only the key is reported. onExpire runs on a timer goroutine and must not
+// block for long, since items expiring meanwhile wait for it. Items that are
+// evicted, deleted or overwritten before they expire are not reported.
+func WithExpirationCallback(onExpire func(key string)) InMemoryOption {
+	return func(c *InMemoryStore) {
+		c.onExpire = onExpire
+	}
+}
+
+// WithItemInfo keeps metadata about each item of an InMemoryStore: the time
+// it was written, the number of times it was read since, and an estimate of
+// its size. GetWithInfo reports them, and Stats and Iterate the sizes.
+// Without it, or a limit on sizes, writes skip estimating the size of items,
+// GetWithInfo only reports their TTL and tags, and Stats reports no Bytes.
+func WithItemInfo() InMemoryOption {
+	return func(c *InMemoryStore) {
+		c.limit.tracked = true
+	}
+}
+
+// evictionTracker orders the keys of an InMemoryStore for eviction
+type evictionTracker interface {
+	// touch records an access to key, which may not be tracked
+	touch(key string)
+	// admit reports whether key may be inserted in place of victim
+	admit(key, victim string) bool
+	// insert starts tracking key, or touches it if it is tracked
+	insert(key string)
+	remove(key string)
+	has(key string) bool
+	// victim returns the key to evict next
+	victim() (string, bool)
+	len() int
+	keys() []string
+}
+
+// sketchItemBytes is the average item size a TinyLFU sketch is sized for
+// when only the total size of the items is bounded
+const sketchItemBytes = 1024
+
+func (l *capacity) newTracker() evictionTracker {
+	if l.maxEntries <= 0 && l.maxBytes <= 0 {
+		return keySet{}
+	}
+	switch l.policy {
+	case EvictLFU:
+		return newLFUTracker()
+	case EvictTinyLFU:
+		width := l.maxEntries
+		if width <= 0 {
+			width = int(l.maxBytes / sketchItemBytes)
+		}
+		return &tinyLFUTracker{lruTracker: newLRUTracker(), sketch: newFrequencySketch(width)}
+	default:
+		return newLRUTracker()
+	}
+}
+
+// minCompaction is the number of stale entries the expiry heap of a
+// capacity holds before it is compacted
+const minCompaction = 1024
+
+// capacity tracks the keys of an InMemoryStore and their size, and enforces
+// its maximum number of entries if maxEntries > 0, and its maximum total size
+// if maxBytes > 0. Stores without either track their keys in several
+// capacities, each with its own lock, so that writes to different keys do
+// not contend.
+type capacity struct {
+	mu            sync.Mutex
+	maxEntries    int
+	maxBytes      int64
+	maxValueBytes int64
+	policy        EvictionPolicy
+	tracker       evictionTracker
+	// tracked is set if sizes, written and hits are kept, for WithItemInfo
+	// or the limits on sizes
+	tracked bool
+	// sizes holds the estimated size of the tracked items, and bytes their
+	// sum
+	sizes map[string]int64
+	bytes int64
+	// written holds the time the tracked items were last set, and hits
+	// a *uint64 counting their reads since, updated without the lock
+	written map[string]time.Time
+	hits    sync.Map
+	// expiry holds the expiration time of the tracked items that expire
+	expiry map[string]time.Time
+	// expiries orders the expiration times of expiry. It also holds stale
+	// entries for items written again or deleted since, which are skipped.
+	expiries expiryHeap
+	// expirer deletes the items at expiresNext, the earliest expiration
+	// time in expiries, zero if it is stopped
+	expirer     *time.Timer
+	expiresNext time.Time
+	onExpire    func(key string)
+	// expiring is held by expire while it runs, including onExpire, so that
+	// stop can wait for it
+	expiring chan struct{}
+	// untag is called with the lock held when a key is written or stops
+	// being tracked, to forget its tags
+	untag func(key string)
+	// stopped is set once expirer is stopped for good by stop, after which
+	// expired items are only dropped when read
+	stopped bool
+	store   *cache.Cache
+	// versions holds the version of the tracked items, taken from version
+	// when they were last written, for CompareAndSwap
+	versions map[string]uint64
+	version  uint64
+}
+
+type evictedItem struct {
+	key   string
+	value interface{}
+}
+
+// newCapacity returns a capacity tracking the keys of store. Its limits are
+// set by the options of the InMemoryStore before calling start.
+func newCapacity(store *cache.Cache) *capacity {
+	return &capacity{
+		store:    store,
+		sizes:    make(map[string]int64),
+		written:  make(map[string]time.Time),
+		expiry:   make(map[string]time.Time),
+		versions: make(map[string]uint64),
+		expiring: make(chan struct{}, 1),
+	}
+}
+
+// limited reports whether the number or the total size of the items is
+// bounded, which needs a single capacity to order every key
+func (l *capacity) limited() bool {
+	return l.maxEntries > 0 || l.maxBytes > 0
+}
+
+// stripe returns a new capacity with the settings of l, which must not be
+// limited, to track other keys of its store
+func (l *capacity) stripe() *capacity {
+	s := newCapacity(l.store)
+	s.maxValueBytes, s.tracked = l.maxValueBytes, l.tracked
+	return s
+}
+
+// start creates the tracker once the limits are set
+func (l *capacity) start(onExpire, untag func(key string)) {
+	l.tracker = l.newTracker()
+	l.onExpire, l.untag = onExpire, untag
+	l.tracked = l.tracked || l.maxBytes > 0 || l.maxValueBytes > 0
+}
+
+// tooLarge reports whether an item of size bytes exceeds the limits
+func (l *capacity) tooLarge(size int64) bool {
+	return l.maxValueBytes > 0 && size > l.maxValueBytes || l.maxBytes > 0 && size > l.maxBytes
+}
+
+// write calls set to write key to the store, with an estimated size (< 0 to
+// keep its current one), expiring at expiresAt (zero if never), unless the
+// tracker does not admit it, and evicts items to keep the store within its
+// limits. admission is false for writes that must not be discarded. set
+// reports whether it stored the item.
+func (l *capacity) write(key string, size int64, expiresAt time.Time, admission bool, set func() bool) (evicted []evictedItem) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tracker.touch(key)
+	full := l.maxEntries > 0 && !l.tracker.has(key) && l.tracker.len() >= l.maxEntries
+	if admission && full {
+		if victim, ok := l.tracker.victim(); ok && !l.tracker.admit(key, victim) {
+			return nil
+		}
+	}
+	if !set() {
+		return nil
+	}
+	for full && l.tracker.len() >= l.maxEntries {
+		victim, ok := l.tracker.victim()
+		if !ok {
+			break
+		}
+		evicted = append(evicted, l.evict(victim))
+	}
+	l.tracker.insert(key)
+	l.bump(key)
+	if size >= 0 {
+		l.untag(key)
+	}
+	if size >= 0 && l.tracked {
+		l.resize(key, size)
+		l.written[key] = time.Now()
+		l.hits.Store(key, new(uint64))
+	}
+	if expiresAt.IsZero() {
+		delete(l.expiry, key)
+	} else {
+		l.expiry[key] = expiresAt
+		l.schedule(key, expiresAt)
+	}
+	return append(evicted, l.shrink(key)...)
+}
+
+// evict deletes the item at victim, and returns it
+func (l *capacity) evict(victim string) evictedItem {
+	l.forget(victim)
+	value, _ := l.store.Get(victim)
+	l.store.Delete(victim)
+	return evictedItem{victim, value}
+}
+
+// resize sets the size of the item at key
+func (l *capacity) resize(key string, size int64) {
+	l.bytes += size - l.sizes[key]
+	l.sizes[key] = size
+}
+
+// shrink evicts items other than key while the store exceeds maxBytes
+func (l *capacity) shrink(key string) (evicted []evictedItem) {
+	for l.maxBytes > 0 && l.bytes > l.maxBytes {
+		victim, ok := l.tracker.victim()
+		if !ok || victim == key {
+			break
+		}
+		evicted = append(evicted, l.evict(victim))
+	}
+	return evicted
+}
+
+// schedule arranges for the item at key to be deleted at expiresAt
+func (l *capacity) schedule(key string, expiresAt time.Time) {
+	if l.stopped {
+		return
+	}
+	heap.Push(&l.expiries, expiryEntry{key: key, expiresAt: expiresAt})
+	if len(l.expiries) > 2*len(l.expiry)+minCompaction {
+		l.compact()
+	}
+	switch {
+	case l.expirer == nil:
+		l.expirer = time.AfterFunc(time.Until(expiresAt), l.expire)
+	case l.expiresNext.IsZero() || expiresAt.Before(l.expiresNext):
+		l.expirer.Reset(time.Until(expiresAt))
+	default:
+		return
+	}
+	l.expiresNext = expiresAt
+}
+
+// stop stops expirer for good, and waits for a run of expire in progress to
+// return, or returns ctx.Err() if ctx is done first
+func (l *capacity) stop(ctx context.Context) error {
+	l.mu.Lock()
+	l.stopped = true
+	if l.expirer != nil {
+		l.expirer.Stop()
+	}
+	l.expiresNext = time.Time{}
+	l.expiries = nil
+	l.mu.Unlock()
+
+	select {
+	case l.expiring <- struct{}{}:
+		<-l.expiring
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// compact drops the stale entries of expiries
+func (l *capacity) compact() {
+	entries := l.expiries[:0]
+	for _, e := range l.expiries {
+		if l.expiry[e.key].Equal(e.expiresAt) {
+			entries = append(entries, e)
+		}
+	}
+	for i := len(entries); i < len(l.expiries); i++ {
+		l.expiries[i] = expiryEntry{}
+	}
+	l.expiries = entries
+	heap.Init(&l.expiries)
+}
+
+// expire deletes the items that expired, calls onExpire with their keys, and
+// schedules the next run of expirer
+func (l *capacity) expire() {
+	l.expiring <- struct{}{}
+	defer func() { <-l.expiring }()
+	l.mu.Lock()
+	if l.stopped {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	var expired []string
+	for len(l.expiries) > 0 && !l.expiries[0].expiresAt.After(now) {
+		e := heap.Pop(&l.expiries).(expiryEntry)
+		if !l.expiry[e.key].Equal(e.expiresAt) {
+			continue
+		}
+		l.store.Delete(e.key)
+		l.forget(e.key)
+		expired = append(expired, e.key)
+	}
+	l.expiresNext = time.Time{}
+	if len(l.expiries) > 0 {
+		l.expiresNext = l.expiries[0].expiresAt
+		l.expirer.Reset(time.Until(l.expiresNext))
+	}
+	onExpire := l.onExpire
+	l.mu.Unlock()
+	if onExpire != nil {
+		for _, key := range expired {
+			onExpire(key)
+		}
+	}
+}
+
+// keys returns the keys tracked
+func (l *capacity) keys() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.tracker.keys()
+}
+
+func (l *capacity) touch(key string) {
+	if l.maxEntries <= 0 && l.maxBytes <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tracker.touch(key)
+}
+
+// modify calls fn to modify the item at key in place, and gives it a new
+// version and size if fn reports it did, evicting others if it grew beyond
+// maxBytes
+func (l *capacity) modify(key string, fn func() bool) []evictedItem {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !fn() {
+		return nil
+	}
+	l.tracker.touch(key)
+	l.bump(key)
+	if !l.tracked {
+		return nil
+	}
+	if value, found := l.store.Get(key); found {
+		l.resize(key, entrySize(key, value))
+	}
+	return l.shrink(key)
+}
+
+// size returns the number of items tracked and their total size, zero if
+// sizes are not tracked
+func (l *capacity) size() (int, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.tracker.len(), l.bytes
+}
+
+// sizeOf returns the size of the item at key, zero if it is not tracked
+func (l *capacity) sizeOf(key string) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sizes[key]
+}
+
+// hit counts a read of the item at key
+func (l *capacity) hit(key string) {
+	if !l.tracked {
+		return
+	}
+	if hits, ok := l.hits.Load(key); ok {
+		atomic.AddUint64(hits.(*uint64), 1)
+	}
+}
+
+// info returns the metadata the capacity holds about the item at key
+func (l *capacity) info(key string) Info {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	info := Info{
+		StoredAt: l.written[key],
+		TTL:      remainingTTL(l.expiry[key]),
+		Size:     l.sizes[key],
+	}
+	if !l.tracked {
+		info.Hits = -1
+	}
+	if hits, ok := l.hits.Load(key); ok {
+		info.Hits = int64(atomic.LoadUint64(hits.(*uint64)))
+	}
+	return info
+}
+
+// entrySize estimates the memory used by an item
+func entrySize(key string, value interface{}) int64 {
+	return int64(len(key)) + approximateSize(value)
+}
+
+// lookup calls get to read the item at key, and returns its version
+func (l *capacity) lookup(key string, get func()) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	get()
+	l.tracker.touch(key)
+	return l.versions[key]
+}
+
+func (l *capacity) bump(key string) {
+	l.version++
+	l.versions[key] = l.version
+}
+
+// remove stops tracking key after calling del to delete it
+func (l *capacity) remove(key string, del func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	del()
+	l.forget(key)
+}
+
+// forget stops tracking key
+func (l *capacity) forget(key string) {
+	l.untag(key)
+	l.tracker.remove(key)
+	l.bytes -= l.sizes[key]
+	delete(l.sizes, key)
+	delete(l.written, key)
+	l.hits.Delete(key)
+	delete(l.expiry, key)
+	delete(l.versions, key)
+}
+
+// expiresAt returns the expiration time of the item at key, zero if it does
+// not expire
+func (l *capacity) expiresAt(key string) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.expiry[key]
+}
+
+// resetAll stops tracking every key of limits after calling flush to delete
+// them
+func resetAll(limits []*capacity, flush func()) {
+	for _, l := range limits {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+	}
+	flush()
+	for _, l := range limits {
+		l.reset()
+	}
+}
+
+// reset stops tracking every key, with the lock held
+func (l *capacity) reset() {
+	l.tracker = l.newTracker()
+	l.sizes = make(map[string]int64)
+	l.bytes = 0
+	l.written = make(map[string]time.Time)
+	l.hits.Range(func(key, _ interface{}) bool {
+		l.hits.Delete(key)
+		return true
+	})
+	l.expiry = make(map[string]time.Time)
+	l.versions = make(map[string]uint64)
+	l.expiries = nil
+	if l.expirer != nil {
+		l.expirer.Stop()
+	}
+	l.expiresNext = time.Time{}
+}
+
+type expiryEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+// expiryHeap is a min-heap of expiration times
+type expiryHeap []expiryEntry
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+
+func (h expiryHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap) Push(x interface{}) {
+	*h = append(*h, x.(expiryEntry))
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = expiryEntry{}
+	*h = old[:len(old)-1]
+	return e
+}
+
+// keySet tracks keys without ordering them, for stores without a limit
+type keySet map[string]struct{}
+
+func (t keySet) touch(key string) {}
+
+func (t keySet) admit(key, victim string) bool {
+	return true
+}
+
+func (t keySet) insert(key string) {
+	t[key] = struct{}{}
+}
+
+func (t keySet) remove(key string) {
+	delete(t, key)
+}
+
+func (t keySet) has(key string) bool {
+	_, ok := t[key]
+	return ok
+}
+
+func (t keySet) victim() (string, bool) {
+	return "", false
+}
+
+func (t keySet) len() int {
+	return len(t)
+}
+
+func (t keySet) keys() []string {
+	keys := make([]string, 0, len(t))
+	for key := range t {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// lruTracker orders keys from the most to the least recently used
+type lruTracker struct {
+	order    *list.List
+	elements map[string]*list.Element
+}
+
+func newLRUTracker() *lruTracker {
+	return &lruTracker{order: list.New(), elements: make(map[string]*list.Element)}
+}
+
+func (t *lruTracker) touch(key string) {
+	if e, ok := t.elements[key]; ok {
+		t.order.MoveToFront(e)
+	}
+}
+
+func (t *lruTracker) admit(key, victim string) bool {
+	return true
+}
+
+func (t *lruTracker) insert(key string) {
+	if e, ok := t.elements[key]; ok {
+		t.order.MoveToFront(e)
+		return
+	}
+	t.elements[key] = t.order.PushFront(key)
+}
+
+func (t *lruTracker) remove(key string) {
+	if e, ok := t.elements[key]; ok {
+		t.order.Remove(e)
+		delete(t.elements, key)
+	}
+}
+
+func (t *lruTracker) has(key string) bool {
+	_, ok := t.elements[key]
+	return ok
+}
+
+func (t *lruTracker) victim() (string, bool) {
+	e := t.order.Back()
+	if e == nil {
+		return "", false
+	}
+	return e.Value.(string), true
+}
+
+func (t *lruTracker) len() int {
+	return len(t.elements)
+}
+
+func (t *lruTracker) keys() []string {
+	keys := make([]string, 0, len(t.elements))
+	for key := range t.elements {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// lfuTracker keeps keys in a heap ordered by use count, then by last use
+type lfuTracker struct {
+	entries lfuHeap
+	byKey   map[string]*lfuEntry
+	clock   uint64
+}
+
+type lfuEntry struct {
+	key      string
+	uses     uint64
+	lastUsed uint64
+	index    int
+}
+
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].uses != h[j].uses {
+		return h[i].uses < h[j].uses
+	}
+	return h[i].lastUsed < h[j].lastUsed
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*lfuEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+func newLFUTracker() *lfuTracker {
+	return &lfuTracker{byKey: make(map[string]*lfuEntry)}
+}
+
+func (t *lfuTracker) touch(key string) {
+	if e, ok := t.byKey[key]; ok {
+		t.clock++
+		e.uses++
+		e.lastUsed = t.clock
+		heap.Fix(&t.entries, e.index)
+	}
+}
+
+func (t *lfuTracker) admit(key, victim string) bool {
+	return true
+}
+
+func (t *lfuTracker) insert(key string) {
+	if _, ok := t.byKey[key]; ok {
+		return
+	}
+	t.clock++
+	e := &lfuEntry{key: key, uses: 1, lastUsed: t.clock}
+	t.byKey[key] = e
+	heap.Push(&t.entries, e)
+}
+
+func (t *lfuTracker) remove(key string) {
+	if e, ok := t.byKey[key]; ok {
+		heap.Remove(&t.entries, e.index)
+		delete(t.byKey, key)
+	}
+}
+
+func (t *lfuTracker) has(key string) bool {
+	_, ok := t.byKey[key]
+	return ok
+}
+
+func (t *lfuTracker) victim() (string, bool) {
+	if len(t.entries) == 0 {
+		return "", false
+	}
+	return t.entries[0].key, true
+}
+
+func (t *lfuTracker) len() int {
+	return len(t.byKey)
+}
+
+func (t *lfuTracker) keys() []string {
+	keys := make([]string, 0, len(t.byKey))
+	for key := range t.byKey {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// tinyLFUTracker is an lruTracker admitting keys according to their
+// estimated frequency
+type tinyLFUTracker struct {
+	*lruTracker
+	sketch *frequencySketch
+}
+
+func (t *tinyLFUTracker) touch(key string) {
+	t.sketch.increment(key)
+	t.lruTracker.touch(key)
+}
+
+func (t *tinyLFUTracker) admit(key, victim string) bool {
+	return t.sketch.estimate(key) > t.sketch.estimate(victim)
+}
+
+// frequencySketch is a count-min sketch of 4-bit counters estimating how
+// often keys were seen. Counters are halved once it has counted 10 times as
+// many increments as it has counters per row, so that old popularity fades.
+type frequencySketch struct {
+	rows      [4][]uint8
+	mask      uint32
+	additions int
+	resetAt   int
+}
+
+func newFrequencySketch(maxEntries int) *frequencySketch {
+	width := 16
+	for width < maxEntries {
+		width *= 2
+	}
+	s := &frequencySketch{mask: uint32(width - 1), resetAt: 10 * width}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+// indexes returns the counter of key in each row, derived from two hashes
+func (s *frequencySketch) indexes(key string) [4]uint32 {
+	h1 := FNVPartition(key)
+	h2 := h1>>17 | h1<<15
+	var idx [4]uint32
+	for i := range idx {
+		idx[i] = (h1 + uint32(i)*h2) & s.mask
+	}
+	return idx
+}
+
+func (s *frequencySketch) increment(key string) {
+	for i, j := range s.indexes(key) {
+		if s.rows[i][j] < 15 {
+			s.rows[i][j]++
+		}
+	}
+	if s.additions++; s.additions >= s.resetAt {
+		for _, row := range s.rows {
+			for j := range row {
+				row[j] /= 2
+			}
+		}
+		s.additions /= 2
+	}
+}
+
+func (s *frequencySketch) estimate(key string) uint8 {
+	min := uint8(15)
+	for i, j := range s.indexes(key) {
+		if s.rows[i][j] < min {
+			min = s.rows[i][j]
+		}
+	}
+	return min
+}
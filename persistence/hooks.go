@@ -0,0 +1,217 @@
+package persistence
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// HookEvent describes the operation a hook registered on a HookedStore is
+// called for
+type HookEvent struct {
+	// Operation is the name of the method of the store, such as "get",
+	// "set_multi" or "increment", or "evict" for evictions
+	Operation string
+	// Key is the key of the item, empty for Flush
+	Key string
+	// Value is the value written, the new value of a counter, the pointer the
+	// value was read into on a hit, or the evicted value if known. It is nil
+	// otherwise.
+	Value interface{}
+	// Expires is the expiration the item was written with
+	Expires time.Duration
+	// Time is when the operation returned
+	Time time.Time
+}
+
+// Hook is a function called by a HookedStore
+type Hook func(HookEvent)
+
+// HookedStore is a CacheStore calling hooks on the operations of the store it
+// wraps, for audit logging, custom metrics or invalidating derived keys.
+// Hooks are called synchronously once an operation succeeds, in the order
+// they were registered; they must not call the store with the same key
+// recursively forever.
+type HookedStore struct {
+	store CacheStore
+	hooks *hooks
+}
+
+type hooks struct {
+	mu                                      sync.RWMutex
+	onHit, onMiss, onSet, onDelete, onEvict []Hook
+}
+
+// NewHookedStore returns a HookedStore wrapping store, without hooks
+func NewHookedStore(store CacheStore) *HookedStore {
+	return &HookedStore{store: store, hooks: &hooks{}}
+}
+
+// WithContext (see ContextBinder interface)
+//
+// The bound store shares the hooks of s.
+func (s *HookedStore) WithContext(ctx context.Context) CacheStore {
+	return &HookedStore{store: BindContext(ctx, s.store), hooks: s.hooks}
+}
+
+// OnHit registers fn to be called for each key found by Get or GetMulti
+func (s *HookedStore) OnHit(fn Hook) {
+	s.hooks.add(&s.hooks.onHit, fn)
+}
+
+// OnMiss registers fn to be called for each key not found by Get or GetMulti
+func (s *HookedStore) OnMiss(fn Hook) {
+	s.hooks.add(&s.hooks.onMiss, fn)
+}
+
+// OnSet registers fn to be called for each item written by Set, Add,
+// Replace, SetMulti, Increment or Decrement
+func (s *HookedStore) OnSet(fn Hook) {
+	s.hooks.add(&s.hooks.onSet, fn)
+}
+
+// OnDelete registers fn to be called for each item deleted by Delete, and
+// once with an empty key for Flush
+func (s *HookedStore) OnDelete(fn Hook) {
+	s.hooks.add(&s.hooks.onDelete, fn)
+}
+
+// OnEvict registers fn to be called for each item reported by Evicted
+func (s *HookedStore) OnEvict(fn Hook) {
+	s.hooks.add(&s.hooks.onEvict, fn)
+}
+
+// Evicted calls the OnEvict hooks for the item at key. The store cannot
+// detect evictions itself: Evicted is meant to be given to the wrapped store,
+// as the callback of WithEvictionCallback, or called from the function passed
+// to RedisStore's MonitorEvictions with a nil value:
+//
+//	var hooked *HookedStore
+//	store := NewInMemoryStore(time.Hour, WithMaxEntries(1000, EvictLRU),
+//		WithEvictionCallback(func(key string, value interface{}) {
+//			hooked.Evicted(key, value)
+//		}))
+//	hooked = NewHookedStore(store)
+func (s *HookedStore) Evicted(key string, value interface{}) {
+	s.hooks.call(&s.hooks.onEvict, HookEvent{Operation: "evict", Key: key, Value: value})
+}
+
+// Get (see CacheStore interface)
+func (s *HookedStore) Get(key string, value interface{}) error {
+	err := s.store.Get(key, value)
+	switch err {
+	case nil:
+		s.hooks.call(&s.hooks.onHit, HookEvent{Operation: "get", Key: key, Value: value})
+	case ErrCacheMiss:
+		s.hooks.call(&s.hooks.onMiss, HookEvent{Operation: "get", Key: key})
+	}
+	return err
+}
+
+// Set (see CacheStore interface)
+func (s *HookedStore) Set(key string, value interface{}, expires time.Duration) error {
+	return s.write("set", key, value, expires, s.store.Set)
+}
+
+// Add (see CacheStore interface)
+func (s *HookedStore) Add(key string, value interface{}, expires time.Duration) error {
+	return s.write("add", key, value, expires, s.store.Add)
+}
+
+// Replace (see CacheStore interface)
+func (s *HookedStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return s.write("replace", key, value, expires, s.store.Replace)
+}
+
+// Delete (see CacheStore interface)
+func (s *HookedStore) Delete(key string) error {
+	err := s.store.Delete(key)
+	if err == nil {
+		s.hooks.call(&s.hooks.onDelete, HookEvent{Operation: "delete", Key: key})
+	}
+	return err
+}
+
+// Increment (see CacheStore interface)
+func (s *HookedStore) Increment(key string, delta uint64) (uint64, error) {
+	return s.count("increment", key, delta, s.store.Increment)
+}
+
+// Decrement (see CacheStore interface)
+func (s *HookedStore) Decrement(key string, delta uint64) (uint64, error) {
+	return s.count("decrement", key, delta, s.store.Decrement)
+}
+
+// Flush (see CacheStore interface)
+func (s *HookedStore) Flush() error {
+	err := s.store.Flush()
+	if err == nil {
+		s.hooks.call(&s.hooks.onDelete, HookEvent{Operation: "flush"})
+	}
+	return err
+}
+
+// Close (see CacheStore interface)
+func (s *HookedStore) Close(ctx context.Context) error {
+	return s.store.Close(ctx)
+}
+
+// GetMulti (see CacheStore interface)
+func (s *HookedStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	found, err := s.store.GetMulti(keys, values)
+	for i, ok := range found {
+		if ok {
+			s.hooks.call(&s.hooks.onHit, HookEvent{Operation: "get_multi", Key: keys[i], Value: values[i]})
+		} else {
+			s.hooks.call(&s.hooks.onMiss, HookEvent{Operation: "get_multi", Key: keys[i]})
+		}
+	}
+	return found, err
+}
+
+// SetMulti (see CacheStore interface)
+func (s *HookedStore) SetMulti(items map[string]Item) error {
+	err := s.store.SetMulti(items)
+	if err == nil {
+		for key, item := range items {
+			s.hooks.call(&s.hooks.onSet, HookEvent{Operation: "set_multi", Key: key, Value: item.Value, Expires: item.Expire})
+		}
+	}
+	return err
+}
+
+func (s *HookedStore) write(operation, key string, value interface{}, expires time.Duration, write func(string, interface{}, time.Duration) error) error {
+	err := write(key, value, expires)
+	if err == nil {
+		s.hooks.call(&s.hooks.onSet, HookEvent{Operation: operation, Key: key, Value: value, Expires: expires})
+	}
+	return err
+}
+
+func (s *HookedStore) count(operation, key string, delta uint64, count func(string, uint64) (uint64, error)) (uint64, error) {
+	n, err := count(key, delta)
+	if err == nil {
+		s.hooks.call(&s.hooks.onSet, HookEvent{Operation: operation, Key: key, Value: n})
+	}
+	return n, err
+}
+
+func (h *hooks) add(list *[]Hook, fn Hook) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	*list = append(*list, fn)
+}
+
+// call calls the hooks of list with event, stamped with the current time
+func (h *hooks) call(list *[]Hook, event HookEvent) {
+	h.mu.RLock()
+	registered := *list
+	h.mu.RUnlock()
+	if len(registered) == 0 {
+		return
+	}
+	event.Time = time.Now()
+	for _, fn := range registered {
+		fn(event)
+	}
+}
@@ -0,0 +1,84 @@
+package persistence
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHookedStore(t *testing.T) {
+	var events []string
+	record := func(kind string) Hook {
+		return func(e HookEvent) {
+			if e.Time.IsZero() {
+				t.Errorf("Expected the event to be timestamped")
+			}
+			events = append(events, kind+" "+e.Operation+" "+e.Key)
+		}
+	}
+	var hooked *HookedStore
+	store := NewInMemoryStore(time.Hour, WithMaxEntries(2, EvictLRU),
+		WithEvictionCallback(func(key string, value interface{}) {
+			hooked.Evicted(key, value)
+		}))
+	hooked = NewHookedStore(store)
+	hooked.OnHit(record("hit"))
+	hooked.OnMiss(record("miss"))
+	hooked.OnSet(record("set"))
+	hooked.OnDelete(record("delete"))
+	hooked.OnEvict(record("evict"))
+
+	var value int
+	hooked.Set("a", 1, DEFAULT)
+	hooked.Get("a", &value)
+	hooked.Get("b", &value)
+	if err := hooked.Add("a", 2, DEFAULT); err != ErrNotStored {
+		t.Errorf("Expected ErrNotStored, got %v", err)
+	}
+	hooked.Increment("a", 1)
+	hooked.SetMulti(map[string]Item{"b": {Value: 1}})
+	hooked.GetMulti([]string{"a", "c"}, []interface{}{new(int), new(int)})
+	hooked.Set("c", 3, DEFAULT)
+	hooked.Delete("c")
+	hooked.Delete("c")
+	hooked.Flush()
+
+	expected := []string{
+		"set set a",
+		"hit get a",
+		"miss get b",
+		"set increment a",
+		"set set_multi b",
+		"hit get_multi a",
+		"miss get_multi c",
+		"evict evict b",
+		"set set c",
+		"delete delete c",
+		"delete flush ",
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events %q, got %q", expected, events)
+	}
+}
+
+func TestHookedStore_Values(t *testing.T) {
+	hooked := NewHookedStore(NewInMemoryStore(time.Hour))
+	var set, hit HookEvent
+	hooked.OnSet(func(e HookEvent) { set = e })
+	hooked.OnHit(func(e HookEvent) { hit = e })
+
+	hooked.Set("a", "value", time.Minute)
+	if set.Value != "value" || set.Expires != time.Minute {
+		t.Errorf("Expected the written value and expiration, got %v", set)
+	}
+	var value string
+	hooked.Get("a", &value)
+	if hit.Value != &value {
+		t.Errorf("Expected the pointer read into, got %v", hit.Value)
+	}
+	hooked.Set("n", 1, DEFAULT)
+	hooked.Increment("n", 2)
+	if set.Value != uint64(3) {
+		t.Errorf("Expected the new counter value, got %v", set.Value)
+	}
+}
@@ -0,0 +1,240 @@
+package persistence
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// HotKeyStore is a CacheStore spreading the load of designated hot keys: their
+// values are written under several sub-keys, key#0 to key#N-1, and reads pick
+// one at random. In a Redis cluster, the sub-keys hash to different slots, so
+// that a single node does not serve all the reads of a popular key.
+//
+// Writes and deletions of hot keys fan out to every sub-key. Other keys are
+// stored as is.
+type HotKeyStore struct {
+	store    CacheStore
+	replicas map[string]int
+	jitter   float64
+}
+
+// HotKeyOption configures a HotKeyStore
+type HotKeyOption func(*HotKeyStore)
+
+// HotKey writes the value of key under replicas sub-keys
+func HotKey(key string, replicas int) HotKeyOption {
+	return func(s *HotKeyStore) {
+		if replicas > 1 {
+			s.replicas[key] = replicas
+		} else {
+			delete(s.replicas, key)
+		}
+	}
+}
+
+// WithReplicaJitter shortens the expiration of each sub-key by a random
+// fraction of at most jitter, e.g. 0.1, so that the sub-keys of a hot key do
+// not all expire at once, and its readers do not all miss together
+func WithReplicaJitter(jitter float64) HotKeyOption {
+	return func(s *HotKeyStore) {
+		s.jitter = jitter
+	}
+}
+
+// NewHotKeyStore returns a HotKeyStore spreading the hot keys configured by
+// options over store
+func NewHotKeyStore(store CacheStore, options ...HotKeyOption) *HotKeyStore {
+	s := &HotKeyStore{store: store, replicas: make(map[string]int)}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// WithContext (see ContextBinder interface)
+func (s *HotKeyStore) WithContext(ctx context.Context) CacheStore {
+	return &HotKeyStore{store: BindContext(ctx, s.store), replicas: s.replicas, jitter: s.jitter}
+}
+
+// subKey returns the sub-key n of key
+func subKey(key string, n int) string {
+	return key + "#" + strconv.Itoa(n)
+}
+
+// readKey returns the key to read the value of key from
+func (s *HotKeyStore) readKey(key string) string {
+	if replicas := s.replicas[key]; replicas > 1 {
+		return subKey(key, rand.Intn(replicas))
+	}
+	return key
+}
+
+// expiration returns the expiration of a sub-key of an item expiring in
+// expires
+func (s *HotKeyStore) expiration(expires time.Duration) time.Duration {
+	if s.jitter <= 0 || expires <= 0 {
+		return expires
+	}
+	jittered := expires - time.Duration(rand.Float64()*s.jitter*float64(expires))
+	if jittered < time.Second {
+		return expires
+	}
+	return jittered
+}
+
+// Get (see CacheStore interface)
+func (s *HotKeyStore) Get(key string, value interface{}) error {
+	return s.store.Get(s.readKey(key), value)
+}
+
+// Set (see CacheStore interface)
+func (s *HotKeyStore) Set(key string, value interface{}, expires time.Duration) error {
+	replicas := s.replicas[key]
+	if replicas <= 1 {
+		return s.store.Set(key, value, expires)
+	}
+	for n := 0; n < replicas; n++ {
+		if err := s.store.Set(subKey(key, n), value, s.expiration(expires)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add (see CacheStore interface)
+//
+// The first sub-key of a hot key decides whether it is added, after which the
+// others are set.
+func (s *HotKeyStore) Add(key string, value interface{}, expires time.Duration) error {
+	replicas := s.replicas[key]
+	if replicas <= 1 {
+		return s.store.Add(key, value, expires)
+	}
+	if err := s.store.Add(subKey(key, 0), value, s.expiration(expires)); err != nil {
+		return err
+	}
+	for n := 1; n < replicas; n++ {
+		if err := s.store.Set(subKey(key, n), value, s.expiration(expires)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replace (see CacheStore interface)
+//
+// Like Add, the first sub-key of a hot key decides whether it is replaced.
+func (s *HotKeyStore) Replace(key string, value interface{}, expires time.Duration) error {
+	replicas := s.replicas[key]
+	if replicas <= 1 {
+		return s.store.Replace(key, value, expires)
+	}
+	if err := s.store.Replace(subKey(key, 0), value, s.expiration(expires)); err != nil {
+		return err
+	}
+	for n := 1; n < replicas; n++ {
+		if err := s.store.Set(subKey(key, n), value, s.expiration(expires)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete (see CacheStore interface)
+//
+// It returns ErrCacheMiss if none of the sub-keys of a hot key existed.
+func (s *HotKeyStore) Delete(key string) error {
+	replicas := s.replicas[key]
+	if replicas <= 1 {
+		return s.store.Delete(key)
+	}
+	missed := 0
+	for n := 0; n < replicas; n++ {
+		switch err := s.store.Delete(subKey(key, n)); err {
+		case nil:
+		case ErrCacheMiss:
+			missed++
+		default:
+			return err
+		}
+	}
+	if missed == replicas {
+		return ErrCacheMiss
+	}
+	return nil
+}
+
+// Increment (see CacheStore interface)
+//
+// The counters of hot keys are incremented in every sub-key, and the value of
+// the first one is returned.
+func (s *HotKeyStore) Increment(key string, delta uint64) (uint64, error) {
+	return s.fanOut(key, func(key string) (uint64, error) {
+		return s.store.Increment(key, delta)
+	})
+}
+
+// Decrement (see CacheStore interface)
+//
+// Like Increment, it updates every sub-key of hot keys.
+func (s *HotKeyStore) Decrement(key string, delta uint64) (uint64, error) {
+	return s.fanOut(key, func(key string) (uint64, error) {
+		return s.store.Decrement(key, delta)
+	})
+}
+
+// fanOut applies update to the sub-keys of key, or to key if it is not hot,
+// and returns the value of the first one
+func (s *HotKeyStore) fanOut(key string, update func(key string) (uint64, error)) (uint64, error) {
+	replicas := s.replicas[key]
+	if replicas <= 1 {
+		return update(key)
+	}
+	first, err := update(subKey(key, 0))
+	if err != nil {
+		return 0, err
+	}
+	for n := 1; n < replicas; n++ {
+		if _, err := update(subKey(key, n)); err != nil && err != ErrCacheMiss {
+			return 0, err
+		}
+	}
+	return first, nil
+}
+
+// Flush (see CacheStore interface)
+func (s *HotKeyStore) Flush() error {
+	return s.store.Flush()
+}
+
+// Close (see CacheStore interface)
+func (s *HotKeyStore) Close(ctx context.Context) error {
+	return s.store.Close(ctx)
+}
+
+// GetMulti (see CacheStore interface)
+func (s *HotKeyStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	readKeys := make([]string, len(keys))
+	for i, key := range keys {
+		readKeys[i] = s.readKey(key)
+	}
+	return s.store.GetMulti(readKeys, values)
+}
+
+// SetMulti (see CacheStore interface)
+func (s *HotKeyStore) SetMulti(items map[string]Item) error {
+	expanded := make(map[string]Item, len(items))
+	for key, item := range items {
+		replicas := s.replicas[key]
+		if replicas <= 1 {
+			expanded[key] = item
+			continue
+		}
+		for n := 0; n < replicas; n++ {
+			expanded[subKey(key, n)] = Item{Value: item.Value, Expire: s.expiration(item.Expire)}
+		}
+	}
+	return s.store.SetMulti(expanded)
+}
@@ -0,0 +1,72 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+var newHotKeyStore = func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
+	return NewHotKeyStore(NewInMemoryStore(defaultExpiration),
+		HotKey("value", 3), HotKey("int", 3), HotKey("multi:string", 2), WithReplicaJitter(0.1))
+}
+
+func TestHotKeyCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newHotKeyStore)
+}
+
+func TestHotKeyCache_IncrDecr(t *testing.T) {
+	incrDecr(t, newHotKeyStore)
+}
+
+func TestHotKeyCache_Add(t *testing.T) {
+	testAdd(t, newHotKeyStore)
+}
+
+func TestHotKeyCache_Replace(t *testing.T) {
+	testReplace(t, newHotKeyStore)
+}
+
+func TestHotKeyCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newHotKeyStore)
+}
+
+func TestHotKeyCache_FanOut(t *testing.T) {
+	backend := NewInMemoryStore(time.Hour)
+	store := NewHotKeyStore(backend, HotKey("home", 4))
+
+	if err := store.Set("home", "page", time.Minute); err != nil {
+		t.Fatalf("Error setting a hot key: %s", err)
+	}
+	var s string
+	for n := 0; n < 4; n++ {
+		if err := backend.Get(subKey("home", n), &s); err != nil || s != "page" {
+			t.Errorf("Expected sub-key %d to hold the value, got %q, %v", n, s, err)
+		}
+	}
+	if err := backend.Get("home", &s); err != ErrCacheMiss {
+		t.Errorf("Expected the hot key itself not to be written, got: %v", err)
+	}
+
+	// Reads are spread over the sub-keys
+	backend.Set(subKey("home", 1), "other", time.Minute)
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		store.Get("home", &s)
+		seen[s] = true
+	}
+	if !seen["page"] || !seen["other"] {
+		t.Errorf("Expected reads to be spread over the sub-keys, got %v", seen)
+	}
+
+	if err := store.Delete("home"); err != nil {
+		t.Errorf("Error deleting a hot key: %s", err)
+	}
+	for n := 0; n < 4; n++ {
+		if err := backend.Get(subKey("home", n), &s); err != ErrCacheMiss {
+			t.Errorf("Expected sub-key %d to be deleted, got: %v", n, err)
+		}
+	}
+	if err := store.Delete("home"); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss deleting a deleted hot key, got: %v", err)
+	}
+}
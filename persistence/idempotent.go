@@ -0,0 +1,61 @@
+package persistence
+
+import (
+	"time"
+
+	"github.com/mlsen/cache/utils"
+)
+
+// idempotentAttempts bounds the attempts of SetIdempotent to store or read a
+// result, as the result it fails to add may expire before it is read
+const idempotentAttempts = 3
+
+// idempotentEntry is the envelope SetIdempotent stores: the ID of the request
+// the result was stored for, and the serialized result
+type idempotentEntry struct {
+	RequestID string
+	Value     []byte
+}
+
+// SetIdempotent stores value, the result of the request identified by
+// requestID, at key, an idempotency key such as the one sent by a client
+// retrying a POST, for ttl, unless a result is stored there already. It is
+// added with Add semantics, so that only the first result is kept.
+//
+// If a result of requestID is stored already, it is read into result and
+// SetIdempotent reports a duplicate: the caller should respond with it
+// instead of value. If the result of another request is stored at key, it
+// returns ErrIdempotencyConflict, as the key was reused for a different
+// request. result may be nil when value is always stored first.
+//
+// The result is stored in an envelope, and is only as serializable as
+// utils.Serialize allows.
+func SetIdempotent(store CacheStore, key, requestID string, value interface{}, ttl time.Duration, result interface{}) (duplicate bool, err error) {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return false, err
+	}
+	for i := 0; i < idempotentAttempts; i++ {
+		err = store.Add(key, idempotentEntry{RequestID: requestID, Value: b}, ttl)
+		if err != ErrNotStored {
+			return false, err
+		}
+		var entry idempotentEntry
+		switch err = store.Get(key, &entry); err {
+		case nil:
+		case ErrCacheMiss:
+			// The result expired since Add failed
+			continue
+		default:
+			return false, err
+		}
+		if entry.RequestID != requestID {
+			return false, ErrIdempotencyConflict
+		}
+		if result == nil {
+			return true, nil
+		}
+		return true, utils.Deserialize(entry.Value, result)
+	}
+	return false, err
+}
@@ -0,0 +1,74 @@
+package persistence
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetIdempotent(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	var result string
+	duplicate, err := SetIdempotent(store, "payment-1", "request-a", "charged 10", time.Minute, &result)
+	if err != nil || duplicate {
+		t.Fatalf("Expected the first result to be stored, got duplicate=%v: %v", duplicate, err)
+	}
+
+	duplicate, err = SetIdempotent(store, "payment-1", "request-a", "charged 10 again", time.Minute, &result)
+	if err != nil || !duplicate {
+		t.Fatalf("Expected a duplicate, got duplicate=%v: %v", duplicate, err)
+	}
+	if result != "charged 10" {
+		t.Errorf("Expected the first result, got %q", result)
+	}
+
+	if _, err = SetIdempotent(store, "payment-1", "request-b", "charged 20", time.Minute, &result); err != ErrIdempotencyConflict {
+		t.Errorf("Expected ErrIdempotencyConflict for another request, got: %v", err)
+	}
+
+	if _, err = SetIdempotent(store, "payment-2", "request-a", "charged 30", time.Millisecond, nil); err != nil {
+		t.Fatalf("Error storing a result: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	duplicate, err = SetIdempotent(store, "payment-2", "request-a", "charged 30 again", time.Minute, &result)
+	if err != nil || duplicate {
+		t.Errorf("Expected a result to be stored again once expired, got duplicate=%v: %v", duplicate, err)
+	}
+}
+
+func TestSetIdempotentConcurrent(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	results := make([]int, 10)
+	stored := make([]bool, 10)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			duplicate, err := SetIdempotent(store, "order", "request", i, time.Minute, &results[i])
+			if err != nil {
+				t.Errorf("Error storing a result: %v", err)
+			}
+			if !duplicate {
+				results[i] = i
+			}
+			stored[i] = !duplicate
+		}(i)
+	}
+	wg.Wait()
+
+	first := -1
+	for i, ok := range stored {
+		if ok {
+			if first >= 0 {
+				t.Fatalf("Expected a single result to be stored, got %d and %d", first, i)
+			}
+			first = i
+		}
+	}
+	for i, result := range results {
+		if result != first {
+			t.Errorf("Expected every request to get result %d, got %d for %d", first, result, i)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package persistence
+
+import "time"
+
+// Info describes an item of a store, as returned by GetWithInfo
+type Info struct {
+	// StoredAt is the time the item was written, zero if the store does
+	// not know it
+	StoredAt time.Time
+	// TTL is the time the item has left to live, FOREVER if it does not
+	// expire, 0 if the store does not know it
+	TTL time.Duration
+	// Size is an estimate of the size of the item in bytes, 0 if the store
+	// does not know it
+	Size int64
+	// Hits counts the reads of the item since it was written, -1 if the
+	// store does not count them
+	Hits int64
+	// Tags lists the tags of the item (see TagStore), nil if it has none or
+	// the store does not know them
+	Tags []string
+}
+
+// InfoStore is implemented by stores able to describe their items, e.g. for
+// debugging endpoints or refresh policies favoring popular items
+type InfoStore interface {
+	CacheStore
+
+	// GetWithInfo works like Get, and additionally returns the metadata
+	// of the item.
+	GetWithInfo(key string, value interface{}) (Info, error)
+}
+
+// GetWithInfo reads the item of store at key into value, and returns its
+// metadata. For stores that are not InfoStores, only the TTL of TTLStores
+// is known.
+func GetWithInfo(store CacheStore, key string, value interface{}) (Info, error) {
+	if infoStore, ok := store.(InfoStore); ok {
+		return infoStore.GetWithInfo(key, value)
+	}
+	info := Info{Hits: -1}
+	if ttlStore, ok := store.(TTLStore); ok {
+		ttl, err := ttlStore.GetWithTTL(key, value)
+		if err != nil {
+			return Info{}, err
+		}
+		info.TTL = ttl
+		return info, nil
+	}
+	if err := store.Get(key, value); err != nil {
+		return Info{}, err
+	}
+	return info, nil
+}
@@ -0,0 +1,91 @@
+package persistence
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInMemoryCache_GetWithInfo(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithItemInfo())
+	before := time.Now()
+	store.Set("page", "content", time.Minute)
+	store.Tag("page", "products", "home")
+	store.Tag("other", "users")
+
+	var value string
+	store.Get("page", &value)
+	info, err := store.GetWithInfo("page", &value)
+	if err != nil || value != "content" {
+		t.Fatalf("Expected the value, got %q: %v", value, err)
+	}
+	if info.StoredAt.Before(before) || info.StoredAt.After(time.Now()) {
+		t.Errorf("Expected the time the item was stored, got %v", info.StoredAt)
+	}
+	if info.TTL <= 0 || info.TTL > time.Minute {
+		t.Errorf("Expected the TTL of the item, got %v", info.TTL)
+	}
+	if info.Size < int64(len("page")+len("content")) {
+		t.Errorf("Expected the size of the item, got %d", info.Size)
+	}
+	if info.Hits != 2 {
+		t.Errorf("Expected 2 hits, got %d", info.Hits)
+	}
+	if !reflect.DeepEqual(info.Tags, []string{"home", "products"}) {
+		t.Errorf("Expected the tags of the item, got %v", info.Tags)
+	}
+
+	store.Set("page", "new content", FOREVER)
+	if info, _ = store.GetWithInfo("page", &value); info.Hits != 1 || info.TTL != FOREVER {
+		t.Errorf("Expected the hits to restart once written, got %+v", info)
+	}
+	if _, err := store.GetWithInfo("missing", &value); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss, got: %v", err)
+	}
+
+	untracked := NewInMemoryStore(time.Hour)
+	untracked.Set("page", "content", time.Minute)
+	untracked.Tag("page", "products")
+	info, err = untracked.GetWithInfo("page", &value)
+	if err != nil || !info.StoredAt.IsZero() || info.Size != 0 || info.Hits != -1 {
+		t.Errorf("Expected no metadata without WithItemInfo, got %+v, %v", info, err)
+	}
+	if info.TTL <= 0 || !reflect.DeepEqual(info.Tags, []string{"products"}) {
+		t.Errorf("Expected the TTL and tags without WithItemInfo, got %+v", info)
+	}
+}
+
+func TestRedisCache_GetWithInfo(t *testing.T) {
+	store, err := NewRedisCache(&ClientOptions{Addrs: []string{redisTestServer}}, time.Hour, WithAgeTracking())
+	if err != nil {
+		t.Fatalf("Error connecting to redis: %s", err)
+	}
+	defer store.Delete("info")
+	before := time.Now()
+	store.Set("info", "content", time.Minute)
+
+	var value string
+	info, err := GetWithInfo(store, "info", &value)
+	if err != nil || value != "content" {
+		t.Fatalf("Expected the value, got %q: %v", value, err)
+	}
+	if info.StoredAt.Before(before.Add(-time.Second)) || info.TTL <= 0 || info.TTL > time.Minute {
+		t.Errorf("Expected the write time and TTL of the item, got %+v", info)
+	}
+	if info.Size <= int64(len("content")) || info.Hits != -1 {
+		t.Errorf("Expected the serialized size and untracked hits, got %+v", info)
+	}
+}
+
+func TestGetWithInfo_Fallback(t *testing.T) {
+	store := struct{ CacheStore }{NewInMemoryStore(time.Hour)}
+	store.Set("key", "value", time.Minute)
+	var value string
+	info, err := GetWithInfo(store, "key", &value)
+	if err != nil || value != "value" {
+		t.Fatalf("Expected the value, got %q: %v", value, err)
+	}
+	if info.Hits != -1 || !info.StoredAt.IsZero() || info.TTL != 0 {
+		t.Errorf("Expected unknown metadata, got %+v", info)
+	}
+}
@@ -1,28 +1,195 @@
 package persistence
 
 import (
+	"context"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/mlsen/cache/utils"
 	"github.com/robfig/go-cache"
 )
 
 //InMemoryStore represents the cache with memory persistence
 type InMemoryStore struct {
 	cache.Cache
+
+	tagsMu sync.Mutex
+	tags   map[string]map[string]struct{}
+	// keyTags holds the tags of each tagged key, and tagged is set once a
+	// key was tagged, accessed atomically
+	keyTags map[string][]string
+	tagged  int32
+
+	locks lockTable
+
+	defaultExpiration time.Duration
+
+	// limit is set by the options, and tracks every key if the store is
+	// limited. Otherwise keys are tracked by the stripes of limits.
+	limit    *capacity
+	limits   []*capacity
+	onEvict  func(key string, value interface{})
+	onExpire func(key string)
+	stats    *statsCounters
 }
 
 // NewInMemoryStore returns a InMemoryStore
-func NewInMemoryStore(defaultExpiration time.Duration) *InMemoryStore {
-	return &InMemoryStore{*cache.New(defaultExpiration, time.Minute)}
+func NewInMemoryStore(defaultExpiration time.Duration, options ...InMemoryOption) *InMemoryStore {
+	c := &InMemoryStore{
+		// Expired items are deleted by the expirer of limit instead of a
+		// janitor sweeping the whole map
+		Cache:             *cache.New(defaultExpiration, 0),
+		defaultExpiration: defaultExpiration,
+		stats:             &statsCounters{},
+	}
+	c.limit = newCapacity(&c.Cache)
+	for _, option := range options {
+		option(c)
+	}
+	c.limits = []*capacity{c.limit}
+	if !c.limit.limited() {
+		for i := 1; i < inMemoryStripes; i++ {
+			c.limits = append(c.limits, c.limit.stripe())
+		}
+	}
+	for _, l := range c.limits {
+		l.start(c.onExpire, c.untag)
+	}
+	return c
+}
+
+// inMemoryStripes is the number of capacities tracking the keys of an
+// InMemoryStore without limits
+const inMemoryStripes = 32
+
+// limitOf returns the capacity tracking key
+func (c *InMemoryStore) limitOf(key string) *capacity {
+	if len(c.limits) == 1 {
+		return c.limits[0]
+	}
+	return c.limits[FNVPartition(key)%uint32(len(c.limits))]
+}
+
+// keys returns the keys tracked
+func (c *InMemoryStore) keys() []string {
+	var keys []string
+	for _, l := range c.limits {
+		keys = append(keys, l.keys()...)
+	}
+	return keys
 }
 
 // Get (see CacheStore interface)
 func (c *InMemoryStore) Get(key string, value interface{}) error {
 	val, found := c.Cache.Get(key)
+	l := c.limitOf(key)
+	l.touch(key)
+	if found {
+		l.hit(key)
+	}
+	return c.load(val, found, value)
+}
+
+// GetWithInfo (see InfoStore interface)
+//
+// Hits counts the reads by Get, this one included, since the item was last
+// set. Touching or modifying the item in place keeps its StoredAt and Hits.
+// StoredAt, Size and Hits are only known with WithItemInfo.
+func (c *InMemoryStore) GetWithInfo(key string, value interface{}) (Info, error) {
+	if err := c.Get(key, value); err != nil {
+		return Info{}, err
+	}
+	info := c.limitOf(key).info(key)
+	c.tagsMu.Lock()
+	info.Tags = append(info.Tags, c.keyTags[key]...)
+	c.tagsMu.Unlock()
+	sort.Strings(info.Tags)
+	return info, nil
+}
+
+// GetWithVersion (see CASStore interface)
+//
+// The version is a counter incremented on every write to the store.
+func (c *InMemoryStore) GetWithVersion(key string, value interface{}) (uint64, error) {
+	var val interface{}
+	var found bool
+	version := c.limitOf(key).lookup(key, func() {
+		val, found = c.Cache.Get(key)
+	})
+	if err := c.load(val, found, value); err != nil {
+		if err == ErrNegativeHit {
+			return version, err
+		}
+		return 0, err
+	}
+	return version, nil
+}
+
+// CompareAndSwap (see CASStore interface)
+func (c *InMemoryStore) CompareAndSwap(key string, value interface{}, version uint64, expires time.Duration) error {
+	var err error
+	if werr := c.write(key, value, expires, false, func() bool {
+		// write holds the lock of the capacity, which guards its versions
+		_, found := c.Cache.Get(key)
+		switch {
+		case !found && version != 0:
+			err = ErrCacheMiss
+			return false
+		case found && (version == 0 || c.limitOf(key).versions[key] != version):
+			err = ErrCASConflict
+			return false
+		}
+		c.Cache.Set(key, value, expires)
+		return true
+	}); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// GetSet (see SwapStore interface)
+func (c *InMemoryStore) GetSet(key string, value interface{}, expires time.Duration, old interface{}) error {
+	var val interface{}
+	var found bool
+	if err := c.write(key, value, expires, false, func() bool {
+		// write holds the lock of the capacity: the item cannot be written
+		// in between
+		val, found = c.Cache.Get(key)
+		c.Cache.Set(key, value, expires)
+		return true
+	}); err != nil {
+		return err
+	}
+	return c.load(val, found, old)
+}
+
+// GetDel (see SwapStore interface)
+func (c *InMemoryStore) GetDel(key string, value interface{}) error {
+	var val interface{}
+	var found bool
+	c.limitOf(key).remove(key, func() {
+		if val, found = c.Cache.Get(key); found {
+			c.Cache.Delete(key)
+		}
+	})
+	return c.load(val, found, value)
+}
+
+// load sets value to the item val read from the store, if found
+func (c *InMemoryStore) load(val interface{}, found bool, value interface{}) error {
 	if !found {
+		c.stats.countLookup(ErrCacheMiss)
 		return ErrCacheMiss
 	}
+	if utils.IsNegative(val) {
+		c.stats.countLookup(ErrNegativeHit)
+		return ErrNegativeHit
+	}
+	c.stats.countLookup(nil)
 
 	v := reflect.ValueOf(value)
 	if v.Type().Kind() == reflect.Ptr && v.Elem().CanSet() {
@@ -35,13 +202,21 @@ func (c *InMemoryStore) Get(key string, value interface{}) error {
 // Set (see CacheStore interface)
 func (c *InMemoryStore) Set(key string, value interface{}, expires time.Duration) error {
 	// NOTE: go-cache understands the values of DEFAULT and FOREVER
-	c.Cache.Set(key, value, expires)
-	return nil
+	return c.write(key, value, expires, true, func() bool {
+		c.Cache.Set(key, value, expires)
+		return true
+	})
 }
 
 // Add (see CacheStore interface)
 func (c *InMemoryStore) Add(key string, value interface{}, expires time.Duration) error {
-	err := c.Cache.Add(key, value, expires)
+	var err error
+	if werr := c.write(key, value, expires, false, func() bool {
+		err = c.Cache.Add(key, value, expires)
+		return err == nil
+	}); werr != nil {
+		return werr
+	}
 	if err == cache.ErrKeyExists {
 		return ErrNotStored
 	}
@@ -50,7 +225,14 @@ func (c *InMemoryStore) Add(key string, value interface{}, expires time.Duration
 
 // Replace (see CacheStore interface)
 func (c *InMemoryStore) Replace(key string, value interface{}, expires time.Duration) error {
-	if err := c.Cache.Replace(key, value, expires); err != nil {
+	var err error
+	if werr := c.write(key, value, expires, false, func() bool {
+		err = c.Cache.Replace(key, value, expires)
+		return err == nil
+	}); werr != nil {
+		return werr
+	}
+	if err != nil {
 		return ErrNotStored
 	}
 	return nil
@@ -58,7 +240,7 @@ func (c *InMemoryStore) Replace(key string, value interface{}, expires time.Dura
 
 // Delete (see CacheStore interface)
 func (c *InMemoryStore) Delete(key string) error {
-	if found := c.Cache.Delete(key); !found {
+	if found := c.delete(key); !found {
 		return ErrCacheMiss
 	}
 	return nil
@@ -66,7 +248,12 @@ func (c *InMemoryStore) Delete(key string) error {
 
 // Increment (see CacheStore interface)
 func (c *InMemoryStore) Increment(key string, n uint64) (uint64, error) {
-	newValue, err := c.Cache.Increment(key, n)
+	var newValue uint64
+	var err error
+	c.evicted(c.limitOf(key).modify(key, func() bool {
+		newValue, err = c.Cache.Increment(key, n)
+		return err == nil
+	}))
 	if err == cache.ErrCacheMiss {
 		return 0, ErrCacheMiss
 	}
@@ -75,7 +262,12 @@ func (c *InMemoryStore) Increment(key string, n uint64) (uint64, error) {
 
 // Decrement (see CacheStore interface)
 func (c *InMemoryStore) Decrement(key string, n uint64) (uint64, error) {
-	newValue, err := c.Cache.Decrement(key, n)
+	var newValue uint64
+	var err error
+	c.evicted(c.limitOf(key).modify(key, func() bool {
+		newValue, err = c.Cache.Decrement(key, n)
+		return err == nil
+	}))
 	if err == cache.ErrCacheMiss {
 		return 0, ErrCacheMiss
 	}
@@ -84,6 +276,294 @@ func (c *InMemoryStore) Decrement(key string, n uint64) (uint64, error) {
 
 // Flush (see CacheStore interface)
 func (c *InMemoryStore) Flush() error {
-	c.Cache.Flush()
+	resetAll(c.limits, c.Cache.Flush)
+	c.tagsMu.Lock()
+	c.tags, c.keyTags = nil, nil
+	c.tagsMu.Unlock()
 	return nil
 }
+
+// Close (see CacheStore interface)
+//
+// It stops deleting the items as they expire, and waits for the expiration
+// callback in progress to return. The store can still be used, its expired
+// items being dropped when read.
+func (c *InMemoryStore) Close(ctx context.Context) error {
+	for _, l := range c.limits {
+		if err := l.stop(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMulti (see CacheStore interface)
+func (c *InMemoryStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	return getMulti(c, keys, values)
+}
+
+// SetMulti (see CacheStore interface)
+func (c *InMemoryStore) SetMulti(items map[string]Item) error {
+	return setMulti(c, items)
+}
+
+// GetWithTTL (see TTLStore interface)
+func (c *InMemoryStore) GetWithTTL(key string, value interface{}) (time.Duration, error) {
+	if err := c.Get(key, value); err != nil {
+		return 0, err
+	}
+	return remainingTTL(c.limitOf(key).expiresAt(key)), nil
+}
+
+// Exists (see TTLStore interface)
+func (c *InMemoryStore) Exists(key string) (bool, error) {
+	_, found := c.Cache.Get(key)
+	return found, nil
+}
+
+// Touch (see TouchStore interface)
+func (c *InMemoryStore) Touch(key string, expires time.Duration) error {
+	err := ErrCacheMiss
+	c.writeSized(key, -1, expires, false, func() bool {
+		// write holds the lock of the capacity: the item cannot be written
+		// in between
+		val, found := c.Cache.Get(key)
+		if !found {
+			return false
+		}
+		c.Cache.Set(key, val, expires)
+		err = nil
+		return true
+	})
+	return err
+}
+
+// Append (see RawStore interface)
+//
+// It returns ErrNotRaw if the item is not a byte slice.
+func (c *InMemoryStore) Append(key string, data []byte) error {
+	return c.modifyRaw(key, func(b []byte) []byte {
+		return append(b[:len(b):len(b)], data...)
+	})
+}
+
+// Prepend (see RawStore interface)
+//
+// It returns ErrNotRaw if the item is not a byte slice.
+func (c *InMemoryStore) Prepend(key string, data []byte) error {
+	return c.modifyRaw(key, func(b []byte) []byte {
+		return append(append(make([]byte, 0, len(data)+len(b)), data...), b...)
+	})
+}
+
+// modifyRaw replaces the byte slice at key with the one modify returns,
+// keeping its expiration. The slice is copied, not modified in place, as
+// values read from the store are shared.
+func (c *InMemoryStore) modifyRaw(key string, modify func([]byte) []byte) error {
+	err := ErrNotStored
+	c.evicted(c.limitOf(key).modify(key, func() bool {
+		val, found := c.Cache.Get(key)
+		if !found {
+			return false
+		}
+		b, ok := val.([]byte)
+		if !ok {
+			err = ErrNotRaw
+			return false
+		}
+		b = modify(b)
+		if c.limit.tracked && c.limit.tooLarge(entrySize(key, b)) {
+			err = ErrValueTooLarge
+			return false
+		}
+		// modify holds the lock of the capacity: its expiry is read
+		// directly
+		expires := remainingTTL(c.limitOf(key).expiry[key])
+		c.Cache.Set(key, b, expires)
+		err = nil
+		return true
+	}))
+	return err
+}
+
+// Stats (see StatsStore interface)
+//
+// Bytes estimates the memory used by the keys and values, not the store's
+// own structures. The size of each item is estimated when it is written,
+// with WithItemInfo or a limit on sizes; Bytes is -1 otherwise.
+func (c *InMemoryStore) Stats() Stats {
+	stats := c.stats.snapshot()
+	stats.Entries, stats.Bytes = 0, 0
+	for _, l := range c.limits {
+		entries, bytes := l.size()
+		stats.Entries += entries
+		stats.Bytes += bytes
+	}
+	if !c.limit.tracked {
+		stats.Bytes = -1
+	}
+	return stats
+}
+
+// TryLock (see LockStore interface)
+//
+// Locks are held in a table of their own, so they cannot be evicted.
+func (c *InMemoryStore) TryLock(key string, ttl time.Duration) (token string, acquired bool, err error) {
+	return c.locks.tryLock(key, ttl)
+}
+
+// Unlock (see LockStore interface)
+func (c *InMemoryStore) Unlock(key, token string) error {
+	c.locks.unlock(key, token)
+	return nil
+}
+
+// Tag (see TagStore interface)
+//
+// The tags of a key are forgotten once it is written again, deleted, evicted
+// or expires.
+func (c *InMemoryStore) Tag(key string, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	c.tagsMu.Lock()
+	defer c.tagsMu.Unlock()
+	if c.tags == nil {
+		c.tags = make(map[string]map[string]struct{})
+		c.keyTags = make(map[string][]string)
+		atomic.StoreInt32(&c.tagged, 1)
+	}
+	for _, tag := range tags {
+		keys, ok := c.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.tags[tag] = keys
+		}
+		if _, ok := keys[key]; !ok {
+			keys[key] = struct{}{}
+			c.keyTags[key] = append(c.keyTags[key], tag)
+		}
+	}
+	return nil
+}
+
+// untag forgets the tags of key
+func (c *InMemoryStore) untag(key string) {
+	if atomic.LoadInt32(&c.tagged) == 0 {
+		return
+	}
+	c.tagsMu.Lock()
+	defer c.tagsMu.Unlock()
+	for _, tag := range c.keyTags[key] {
+		keys := c.tags[tag]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+	delete(c.keyTags, key)
+}
+
+// InvalidateTag (see TagStore interface)
+func (c *InMemoryStore) InvalidateTag(tag string) error {
+	c.tagsMu.Lock()
+	keys := make([]string, 0, len(c.tags[tag]))
+	for key := range c.tags[tag] {
+		keys = append(keys, key)
+	}
+	c.tagsMu.Unlock()
+
+	for _, key := range keys {
+		// Deleting the key forgets its tags, even if it already expired
+		c.delete(key)
+	}
+	return nil
+}
+
+// Keys (see IterableStore interface)
+func (c *InMemoryStore) Keys(prefix string) ([]string, error) {
+	return collectKeys(c, prefix)
+}
+
+// Iterate (see IterableStore interface)
+func (c *InMemoryStore) Iterate(prefix string, fn func(key string, meta Meta) bool) error {
+	for _, key := range c.keys() {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if _, found := c.Cache.Get(key); !found {
+			continue
+		}
+		l := c.limitOf(key)
+		meta := Meta{TTL: remainingTTL(l.expiresAt(key)), Size: l.sizeOf(key)}
+		if !fn(key, meta) {
+			break
+		}
+	}
+	return nil
+}
+
+// InvalidatePattern (see PatternStore interface)
+//
+// Every key stored is matched against pattern.
+func (c *InMemoryStore) InvalidatePattern(pattern string) error {
+	for _, key := range c.keys() {
+		if matchGlob(pattern, key) {
+			c.delete(key)
+		}
+	}
+	return nil
+}
+
+// write calls set to write value at key, under the limits set with the
+// options if any, and reports the items evicted to make room for it. It
+// returns ErrValueTooLarge if the item exceeds the limits. The size of the
+// item is only estimated if sizes are tracked.
+func (c *InMemoryStore) write(key string, value interface{}, expires time.Duration, admission bool, set func() bool) error {
+	var size int64
+	if c.limit.tracked {
+		size = entrySize(key, value)
+		if c.limit.tooLarge(size) {
+			return ErrValueTooLarge
+		}
+	}
+	c.writeSized(key, size, expires, admission, set)
+	return nil
+}
+
+// writeSized is write for an item of the given size, < 0 to keep its
+// current one
+func (c *InMemoryStore) writeSized(key string, size int64, expires time.Duration, admission bool, set func() bool) {
+	// go-cache treats DEFAULT and a zero default expiration alike
+	if expires == DEFAULT {
+		expires = c.defaultExpiration
+	}
+	var expiresAt time.Time
+	if expires > 0 {
+		expiresAt = time.Now().Add(expires)
+	}
+	c.evicted(c.limitOf(key).write(key, size, expiresAt, admission, func() bool {
+		stored := set()
+		if stored {
+			c.stats.countSet(1, nil)
+		}
+		return stored
+	}))
+}
+
+// evicted reports the items evicted to make room for others
+func (c *InMemoryStore) evicted(evicted []evictedItem) {
+	atomic.AddUint64(&c.stats.evictions, uint64(len(evicted)))
+	if c.onEvict != nil {
+		for _, item := range evicted {
+			c.onEvict(item.key, item.value)
+		}
+	}
+}
+
+func (c *InMemoryStore) delete(key string) (found bool) {
+	c.limitOf(key).remove(key, func() {
+		found = c.Cache.Delete(key)
+	})
+	return found
+}
@@ -0,0 +1,480 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mlsen/cache/utils"
+)
+
+// PartitionFunc maps a key to a partition of a ShardedInMemoryStore. The
+// result is reduced modulo the number of partitions.
+type PartitionFunc func(key string) uint32
+
+// FNVPartition is the default PartitionFunc, hashing keys with 32-bit FNV-1a
+func FNVPartition(key string) uint32 {
+	// Inlined rather than using hash/fnv, which costs an allocation and
+	// interface calls on every operation
+	h := uint32(fnvOffset32)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= fnvPrime32
+	}
+	return h
+}
+
+const (
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+)
+
+// ShardedInMemoryStore represents the cache with memory persistence, split
+// into partitions that each have their own map and lock. Operations on keys
+// routed to different partitions do not contend with each other.
+type ShardedInMemoryStore struct {
+	*shardedMemory
+	// The janitor goroutine only references shardedMemory, so the finalizer
+	// set on ShardedInMemoryStore can stop it once the store is unreachable.
+}
+
+type shardedMemory struct {
+	shards            []*memoryShard
+	partition         PartitionFunc
+	defaultExpiration time.Duration
+	stop              chan struct{}
+	stopOnce          sync.Once
+	stopped           chan struct{}
+	locks             lockTable
+}
+
+type memoryShard struct {
+	sync.RWMutex
+	items map[string]memoryItem
+	// Pads shards to 64 bytes, so that the locks of neighbouring shards do
+	// not share a CPU cache line
+	_ [32]byte
+}
+
+type memoryItem struct {
+	value   interface{}
+	expires time.Time
+}
+
+func (i memoryItem) expired(now time.Time) bool {
+	return !i.expires.IsZero() && now.After(i.expires)
+}
+
+// NewShardedInMemoryStore returns a ShardedInMemoryStore with the given number
+// of partitions, routing keys with FNVPartition
+func NewShardedInMemoryStore(defaultExpiration time.Duration, partitions int) *ShardedInMemoryStore {
+	return NewShardedInMemoryStoreWithPartitionFunc(defaultExpiration, partitions, FNVPartition)
+}
+
+// NewShardedInMemoryStoreWithPartitionFunc returns a ShardedInMemoryStore
+// routing keys to partitions with the provided PartitionFunc
+func NewShardedInMemoryStoreWithPartitionFunc(defaultExpiration time.Duration, partitions int, partition PartitionFunc) *ShardedInMemoryStore {
+	if partitions < 1 {
+		partitions = 1
+	}
+	m := &shardedMemory{
+		shards:            make([]*memoryShard, partitions),
+		partition:         partition,
+		defaultExpiration: defaultExpiration,
+		stop:              make(chan struct{}),
+		stopped:           make(chan struct{}),
+	}
+	for i := range m.shards {
+		m.shards[i] = &memoryShard{items: make(map[string]memoryItem)}
+	}
+	go m.janitor(time.Minute)
+
+	s := &ShardedInMemoryStore{m}
+	runtime.SetFinalizer(s, func(s *ShardedInMemoryStore) { s.stopJanitor() })
+	return s
+}
+
+// Get (see CacheStore interface)
+func (c *ShardedInMemoryStore) Get(key string, value interface{}) error {
+	_, err := c.get(key, value)
+	return err
+}
+
+// GetWithTTL (see TTLStore interface)
+func (c *ShardedInMemoryStore) GetWithTTL(key string, value interface{}) (time.Duration, error) {
+	item, err := c.get(key, value)
+	if err != nil {
+		return 0, err
+	}
+	return remainingTTL(item.expires), nil
+}
+
+// Exists (see TTLStore interface)
+func (c *ShardedInMemoryStore) Exists(key string) (bool, error) {
+	s := c.shard(key)
+	s.RLock()
+	item, found := s.items[key]
+	s.RUnlock()
+	return found && !item.expired(time.Now()), nil
+}
+
+// Touch (see TouchStore interface)
+func (c *ShardedInMemoryStore) Touch(key string, expires time.Duration) error {
+	s := c.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	item, found := s.items[key]
+	if !found || item.expired(time.Now()) {
+		return ErrCacheMiss
+	}
+	s.items[key] = c.newItem(item.value, expires)
+	return nil
+}
+
+// Append (see RawStore interface)
+//
+// It returns ErrNotRaw if the item is not a byte slice.
+func (c *ShardedInMemoryStore) Append(key string, data []byte) error {
+	return c.modifyRaw(key, func(b []byte) []byte {
+		return append(b[:len(b):len(b)], data...)
+	})
+}
+
+// Prepend (see RawStore interface)
+//
+// It returns ErrNotRaw if the item is not a byte slice.
+func (c *ShardedInMemoryStore) Prepend(key string, data []byte) error {
+	return c.modifyRaw(key, func(b []byte) []byte {
+		return append(append(make([]byte, 0, len(data)+len(b)), data...), b...)
+	})
+}
+
+// modifyRaw replaces the byte slice at key with the one modify returns,
+// keeping its expiration
+func (c *ShardedInMemoryStore) modifyRaw(key string, modify func([]byte) []byte) error {
+	s := c.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	item, found := s.items[key]
+	if !found || item.expired(time.Now()) {
+		return ErrNotStored
+	}
+	b, ok := item.value.([]byte)
+	if !ok {
+		return ErrNotRaw
+	}
+	item.value = modify(b)
+	s.items[key] = item
+	return nil
+}
+
+// get reads the item at key into value, and returns it
+func (c *ShardedInMemoryStore) get(key string, value interface{}) (memoryItem, error) {
+	s := c.shard(key)
+	s.RLock()
+	item, found := s.items[key]
+	s.RUnlock()
+	if !found || item.expired(time.Now()) {
+		return item, ErrCacheMiss
+	}
+	return item, c.load(item.value, value)
+}
+
+// load sets value to the item val read from the store
+func (c *ShardedInMemoryStore) load(val interface{}, value interface{}) error {
+	if utils.IsNegative(val) {
+		return ErrNegativeHit
+	}
+	v := reflect.ValueOf(value)
+	if v.Type().Kind() == reflect.Ptr && v.Elem().CanSet() {
+		v.Elem().Set(reflect.ValueOf(val))
+		return nil
+	}
+	return ErrNotStored
+}
+
+// Set (see CacheStore interface)
+func (c *ShardedInMemoryStore) Set(key string, value interface{}, expires time.Duration) error {
+	s := c.shard(key)
+	s.Lock()
+	s.items[key] = c.newItem(value, expires)
+	s.Unlock()
+	return nil
+}
+
+// Add (see CacheStore interface)
+func (c *ShardedInMemoryStore) Add(key string, value interface{}, expires time.Duration) error {
+	s := c.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	if item, found := s.items[key]; found && !item.expired(time.Now()) {
+		return ErrNotStored
+	}
+	s.items[key] = c.newItem(value, expires)
+	return nil
+}
+
+// Replace (see CacheStore interface)
+func (c *ShardedInMemoryStore) Replace(key string, value interface{}, expires time.Duration) error {
+	s := c.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	if item, found := s.items[key]; !found || item.expired(time.Now()) {
+		return ErrNotStored
+	}
+	s.items[key] = c.newItem(value, expires)
+	return nil
+}
+
+// GetSet (see SwapStore interface)
+func (c *ShardedInMemoryStore) GetSet(key string, value interface{}, expires time.Duration, old interface{}) error {
+	s := c.shard(key)
+	s.Lock()
+	item, found := s.items[key]
+	s.items[key] = c.newItem(value, expires)
+	s.Unlock()
+	if !found || item.expired(time.Now()) {
+		return ErrCacheMiss
+	}
+	return c.load(item.value, old)
+}
+
+// GetDel (see SwapStore interface)
+func (c *ShardedInMemoryStore) GetDel(key string, value interface{}) error {
+	s := c.shard(key)
+	s.Lock()
+	item, found := s.items[key]
+	delete(s.items, key)
+	s.Unlock()
+	if !found || item.expired(time.Now()) {
+		return ErrCacheMiss
+	}
+	return c.load(item.value, value)
+}
+
+// Delete (see CacheStore interface)
+func (c *ShardedInMemoryStore) Delete(key string) error {
+	s := c.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	item, found := s.items[key]
+	delete(s.items, key)
+	if !found || item.expired(time.Now()) {
+		return ErrCacheMiss
+	}
+	return nil
+}
+
+// Increment (see CacheStore interface)
+func (c *ShardedInMemoryStore) Increment(key string, n uint64) (uint64, error) {
+	return c.incrDecr(key, n, false)
+}
+
+// Decrement (see CacheStore interface)
+func (c *ShardedInMemoryStore) Decrement(key string, n uint64) (uint64, error) {
+	return c.incrDecr(key, n, true)
+}
+
+// Flush (see CacheStore interface)
+func (c *ShardedInMemoryStore) Flush() error {
+	for _, s := range c.shards {
+		s.Lock()
+		s.items = make(map[string]memoryItem)
+		s.Unlock()
+	}
+	return nil
+}
+
+// Keys (see IterableStore interface)
+func (c *ShardedInMemoryStore) Keys(prefix string) ([]string, error) {
+	return collectKeys(c, prefix)
+}
+
+// Iterate (see IterableStore interface)
+//
+// The keys of a partition are collected under its lock, and fn is called
+// once it is released.
+func (c *ShardedInMemoryStore) Iterate(prefix string, fn func(key string, meta Meta) bool) error {
+	type listed struct {
+		key  string
+		meta Meta
+	}
+	var batch []listed
+	for _, s := range c.shards {
+		batch = batch[:0]
+		now := time.Now()
+		s.RLock()
+		for key, item := range s.items {
+			if strings.HasPrefix(key, prefix) && !item.expired(now) {
+				batch = append(batch, listed{key, Meta{TTL: remainingTTL(item.expires)}})
+			}
+		}
+		s.RUnlock()
+		for _, l := range batch {
+			if !fn(l.key, l.meta) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// InvalidatePattern (see PatternStore interface)
+//
+// Every key stored is matched against pattern, one partition at a time.
+func (c *ShardedInMemoryStore) InvalidatePattern(pattern string) error {
+	for _, s := range c.shards {
+		s.Lock()
+		for key := range s.items {
+			if matchGlob(pattern, key) {
+				delete(s.items, key)
+			}
+		}
+		s.Unlock()
+	}
+	return nil
+}
+
+// GetMulti (see CacheStore interface)
+func (c *ShardedInMemoryStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	return getMulti(c, keys, values)
+}
+
+// SetMulti (see CacheStore interface)
+func (c *ShardedInMemoryStore) SetMulti(items map[string]Item) error {
+	return setMulti(c, items)
+}
+
+// TryLock (see LockStore interface)
+func (c *ShardedInMemoryStore) TryLock(key string, ttl time.Duration) (token string, acquired bool, err error) {
+	return c.locks.tryLock(key, ttl)
+}
+
+// Unlock (see LockStore interface)
+func (c *ShardedInMemoryStore) Unlock(key, token string) error {
+	c.locks.unlock(key, token)
+	return nil
+}
+
+// Len returns the number of unexpired items across all partitions
+func (c *ShardedInMemoryStore) Len() int {
+	now := time.Now()
+	n := 0
+	for _, s := range c.shards {
+		s.RLock()
+		for _, item := range s.items {
+			if !item.expired(now) {
+				n++
+			}
+		}
+		s.RUnlock()
+	}
+	return n
+}
+
+func (c *ShardedInMemoryStore) shard(key string) *memoryShard {
+	return c.shards[c.partition(key)%uint32(len(c.shards))]
+}
+
+func (c *ShardedInMemoryStore) newItem(value interface{}, expires time.Duration) memoryItem {
+	if expires == DEFAULT {
+		expires = c.defaultExpiration
+	}
+	item := memoryItem{value: value}
+	if expires > 0 {
+		item.expires = time.Now().Add(expires)
+	}
+	return item
+}
+
+// incrDecr behaves like the go-cache counterparts used by InMemoryStore:
+// increments wrap around on overflow and decrements stop at 0.
+func (c *ShardedInMemoryStore) incrDecr(key string, n uint64, decrement bool) (uint64, error) {
+	s := c.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	item, found := s.items[key]
+	if !found || item.expired(time.Now()) {
+		return 0, ErrCacheMiss
+	}
+
+	v := reflect.New(reflect.TypeOf(item.value)).Elem()
+	v.Set(reflect.ValueOf(item.value))
+	var newValue uint64
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := v.Int()
+		switch {
+		case !decrement:
+			i += int64(n)
+		case i > int64(n):
+			i -= int64(n)
+		default:
+			i = 0
+		}
+		v.SetInt(i)
+		newValue = uint64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		switch {
+		case !decrement:
+			u += n
+		case u > n:
+			u -= n
+		default:
+			u = 0
+		}
+		v.SetUint(u)
+		newValue = v.Uint()
+	default:
+		return 0, fmt.Errorf("cache: the value for %s is not an integer", key)
+	}
+
+	item.value = v.Interface()
+	s.items[key] = item
+	return newValue, nil
+}
+
+// Close (see CacheStore interface)
+//
+// It stops the janitor deleting expired items, and waits for it to return.
+// The store can still be used, its expired items being dropped when read.
+func (c *ShardedInMemoryStore) Close(ctx context.Context) error {
+	c.stopJanitor()
+	return awaitDone(ctx, c.stopped)
+}
+
+func (m *shardedMemory) stopJanitor() {
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+func (m *shardedMemory) janitor(interval time.Duration) {
+	defer close(m.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.deleteExpired()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *shardedMemory) deleteExpired() {
+	now := time.Now()
+	for _, s := range m.shards {
+		s.Lock()
+		for k, item := range s.items {
+			if item.expired(now) {
+				delete(s.items, k)
+			}
+		}
+		s.Unlock()
+	}
+}
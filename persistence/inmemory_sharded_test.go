@@ -0,0 +1,162 @@
+package persistence
+
+import (
+	"hash/fnv"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var newShardedInMemoryStore = func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
+	return NewShardedInMemoryStore(defaultExpiration, 16)
+}
+
+// Test typical cache interactions
+func TestShardedInMemoryCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newShardedInMemoryStore)
+}
+
+func TestShardedInMemoryCache_IncrDecr(t *testing.T) {
+	incrDecr(t, newShardedInMemoryStore)
+}
+
+func TestShardedInMemoryCache_CounterPresence(t *testing.T) {
+	counterPresence(t, newShardedInMemoryStore)
+}
+
+func TestShardedInMemoryCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newShardedInMemoryStore)
+}
+
+func TestShardedInMemoryCache_Expiration(t *testing.T) {
+	expiration(t, newShardedInMemoryStore)
+}
+
+func TestShardedInMemoryCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newShardedInMemoryStore)
+}
+
+func TestShardedInMemoryCache_Replace(t *testing.T) {
+	testReplace(t, newShardedInMemoryStore)
+}
+
+func TestShardedInMemoryCache_Add(t *testing.T) {
+	testAdd(t, newShardedInMemoryStore)
+}
+
+func TestShardedInMemoryCache_Lock(t *testing.T) {
+	distributedLock(t, newShardedInMemoryStore(t, time.Hour))
+}
+
+func TestShardedInMemoryCache_InvalidatePattern(t *testing.T) {
+	patternInvalidation(t, newShardedInMemoryStore(t, time.Hour).(PatternStore))
+}
+
+func TestShardedInMemoryCache_Iterate(t *testing.T) {
+	keyIteration(t, newShardedInMemoryStore(t, time.Hour).(IterableStore))
+}
+
+func TestShardedInMemoryCache_Touch(t *testing.T) {
+	touchExpiration(t, newShardedInMemoryStore(t, time.Hour).(TouchStore))
+}
+
+func TestShardedInMemoryCache_GetSetGetDel(t *testing.T) {
+	swapValues(t, newShardedInMemoryStore(t, time.Hour))
+}
+
+func TestShardedInMemoryCache_AppendPrepend(t *testing.T) {
+	rawAppend(t, newShardedInMemoryStore(t, time.Hour).(*ShardedInMemoryStore))
+}
+
+func TestShardedInMemoryCache_TTL(t *testing.T) {
+	ttlInspection(t, newShardedInMemoryStore)
+}
+
+func TestShardedInMemoryCache_NegativeCaching(t *testing.T) {
+	negativeCaching(t, newShardedInMemoryStore)
+}
+
+func TestShardedInMemoryCache_LenFlush(t *testing.T) {
+	var partitions [4]int
+	store := NewShardedInMemoryStoreWithPartitionFunc(time.Hour, 4, func(key string) uint32 {
+		n, _ := strconv.Atoi(key)
+		partitions[n%4]++
+		return uint32(n)
+	})
+
+	for i := 0; i < 8; i++ {
+		if err := store.Set(strconv.Itoa(i), i, DEFAULT); err != nil {
+			t.Errorf("Error setting a value: %s", err)
+		}
+	}
+	for i, n := range partitions {
+		if n != 2 {
+			t.Errorf("Expected 2 keys routed to partition %d, got %d", i, n)
+		}
+	}
+	if n := store.Len(); n != 8 {
+		t.Errorf("Expected 8 items, got %d", n)
+	}
+
+	if err := store.Flush(); err != nil {
+		t.Errorf("Error flushing: %s", err)
+	}
+	if n := store.Len(); n != 0 {
+		t.Errorf("Expected no items after flush, got %d", n)
+	}
+}
+
+func TestFNVPartition(t *testing.T) {
+	for _, key := range []string{"", "a", "page:/products?id=42", "\xff\x00"} {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		if got, want := FNVPartition(key), h.Sum32(); got != want {
+			t.Errorf("FNVPartition(%q) = %d, expected %d", key, got, want)
+		}
+	}
+}
+
+// benchmarkParallel gets and sets keys from parallel goroutines, setting
+// every writeEvery operations (never if 0). Run with -cpu 1,8,32 to see how
+// the stores behave under contention.
+func benchmarkParallel(b *testing.B, store CacheStore, writeEvery int) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		store.Set(keys[i], i, DEFAULT)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var value int
+		i := 0
+		for pb.Next() {
+			key := keys[i%len(keys)]
+			if writeEvery > 0 && i%writeEvery == 0 {
+				store.Set(key, i, DEFAULT)
+			} else {
+				store.Get(key, &value)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkInMemoryCache_Parallel(b *testing.B) {
+	benchmarkParallel(b, NewInMemoryStore(time.Hour), 4)
+}
+
+func BenchmarkInMemoryCache_ParallelRead(b *testing.B) {
+	benchmarkParallel(b, NewInMemoryStore(time.Hour), 0)
+}
+
+func BenchmarkShardedInMemoryCache_Parallel1(b *testing.B) {
+	benchmarkParallel(b, NewShardedInMemoryStore(time.Hour, 1), 4)
+}
+
+func BenchmarkShardedInMemoryCache_Parallel32(b *testing.B) {
+	benchmarkParallel(b, NewShardedInMemoryStore(time.Hour, 32), 4)
+}
+
+func BenchmarkShardedInMemoryCache_ParallelRead32(b *testing.B) {
+	benchmarkParallel(b, NewShardedInMemoryStore(time.Hour, 32), 0)
+}
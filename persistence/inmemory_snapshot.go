@@ -0,0 +1,98 @@
+package persistence
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"time"
+)
+
+// snapshotVersion is the version of the format written by InMemoryStore's
+// Save. Load rejects snapshots of other versions with ErrSnapshotVersion.
+const snapshotVersion = 1
+
+// snapshotHeader starts a snapshot, followed by Items snapshotItem
+type snapshotHeader struct {
+	Version int
+	Items   int
+}
+
+type snapshotItem struct {
+	Key   string
+	Value interface{}
+	// ExpiresAt is zero for items that do not expire
+	ExpiresAt time.Time
+}
+
+// Save writes the items of the store to w, with their expiration time, so
+// that Load can restore them, for instance to keep the cache across a
+// restart. Items are encoded with gob: the types of the values must be
+// encodable, and registered with gob.Register on Load if they are not
+// registered by Save in the same process. Expired items are skipped; locks
+// and tags are not saved.
+//
+// Items written while Save runs may or may not be saved.
+func (c *InMemoryStore) Save(w io.Writer) (err error) {
+	var items []snapshotItem
+	now := time.Now()
+	for _, key := range c.keys() {
+		value, found := c.Cache.Get(key)
+		expiresAt := c.limitOf(key).expiresAt(key)
+		if !found || (!expiresAt.IsZero() && !expiresAt.After(now)) {
+			continue
+		}
+		items = append(items, snapshotItem{Key: key, Value: value, ExpiresAt: expiresAt})
+	}
+
+	defer func() {
+		if x := recover(); x != nil {
+			err = fmt.Errorf("cache: registering the type of a value with gob: %v", x)
+		}
+	}()
+	for _, item := range items {
+		gob.Register(item.Value)
+	}
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(snapshotHeader{Version: snapshotVersion, Items: len(items)}); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := enc.Encode(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load restores the items saved by Save from r, replacing the items already
+// stored at the same keys. Items keep the expiration time they had when
+// saved: those that expired meanwhile are skipped, and so are those too
+// large for the limits of c. Items beyond the limits set with WithMaxEntries
+// and WithMaxTotalBytes evict others as they are loaded.
+func (c *InMemoryStore) Load(r io.Reader) error {
+	dec := gob.NewDecoder(r)
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return err
+	}
+	if header.Version != snapshotVersion {
+		return ErrSnapshotVersion
+	}
+	for i := 0; i < header.Items; i++ {
+		var item snapshotItem
+		if err := dec.Decode(&item); err != nil {
+			return err
+		}
+		expires := FOREVER
+		if !item.ExpiresAt.IsZero() {
+			if expires = time.Until(item.ExpiresAt); expires <= 0 {
+				continue
+			}
+		}
+		c.write(item.Key, item.Value, expires, false, func() bool {
+			c.Cache.Set(item.Key, item.Value, expires)
+			return true
+		})
+	}
+	return nil
+}
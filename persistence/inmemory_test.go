@@ -1,6 +1,9 @@
 package persistence
 
 import (
+	"bytes"
+	"encoding/gob"
+	"sync"
 	"testing"
 	"time"
 )
@@ -18,6 +21,64 @@ func TestInMemoryCache_IncrDecr(t *testing.T) {
 	incrDecr(t, newInMemoryStore)
 }
 
+func TestInMemoryCache_CounterPresence(t *testing.T) {
+	counterPresence(t, newInMemoryStore)
+}
+
+func TestInMemoryCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newInMemoryStore)
+}
+
+func TestInMemoryCache_Tags(t *testing.T) {
+	tagInvalidation(t, newInMemoryStore(t, time.Hour).(TagStore))
+}
+
+func TestInMemoryCache_ForgetTags(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	SetWithTags(store, "rewritten", 1, DEFAULT, "a")
+	SetWithTags(store, "deleted", 1, DEFAULT, "a")
+	SetWithTags(store, "expired", 1, 10*time.Millisecond, "a", "b")
+	SetWithTags(store, "kept", 1, DEFAULT, "b")
+	store.Set("rewritten", 2, DEFAULT)
+	store.Delete("deleted")
+	time.Sleep(50 * time.Millisecond)
+
+	store.tagsMu.Lock()
+	if len(store.tags) != 1 || len(store.tags["b"]) != 1 || len(store.keyTags) != 1 {
+		t.Errorf("Expected only the tag of kept to be indexed, got %v and %v", store.tags, store.keyTags)
+	}
+	store.tagsMu.Unlock()
+
+	store.InvalidateTag("a")
+	var n int
+	if err := store.Get("rewritten", &n); err != nil || n != 2 {
+		t.Errorf("Expected a key written again without tags to be kept, got %d, %v", n, err)
+	}
+	if info, _ := store.GetWithInfo("rewritten", &n); info.Tags != nil {
+		t.Errorf("Expected no tags for a key written again, got %v", info.Tags)
+	}
+}
+
+func TestInMemoryCache_InvalidatePattern(t *testing.T) {
+	patternInvalidation(t, NewInMemoryStore(time.Hour))
+}
+
+func TestInMemoryCache_Iterate(t *testing.T) {
+	keyIteration(t, NewInMemoryStore(time.Hour))
+}
+
+func TestInMemoryCache_Touch(t *testing.T) {
+	touchExpiration(t, NewInMemoryStore(time.Hour))
+}
+
+func TestInMemoryCache_GetSetGetDel(t *testing.T) {
+	swapValues(t, NewInMemoryStore(time.Hour))
+}
+
+func TestInMemoryCache_AppendPrepend(t *testing.T) {
+	rawAppend(t, NewInMemoryStore(time.Hour))
+}
+
 func TestInMemoryCache_Expiration(t *testing.T) {
 	expiration(t, newInMemoryStore)
 }
@@ -33,3 +94,299 @@ func TestInMemoryCache_Replace(t *testing.T) {
 func TestInMemoryCache_Add(t *testing.T) {
 	testAdd(t, newInMemoryStore)
 }
+
+func TestInMemoryCache_CompareAndSwap(t *testing.T) {
+	compareAndSwap(t, newInMemoryStore)
+}
+
+func TestInMemoryCache_CompareAndSwapNoLostUpdate(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	if err := store.Set("counter", 0, DEFAULT); err != nil {
+		t.Fatalf("Error setting the counter: %s", err)
+	}
+
+	const writers, updates = 8, 100
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < updates; {
+				var count int
+				version, err := store.GetWithVersion("counter", &count)
+				if err != nil {
+					t.Errorf("Error reading the counter: %s", err)
+					return
+				}
+				switch err = store.CompareAndSwap("counter", count+1, version, DEFAULT); err {
+				case nil:
+					n++
+				case ErrCASConflict:
+				default:
+					t.Errorf("Error updating the counter: %s", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var count int
+	if err := store.Get("counter", &count); err != nil || count != writers*updates {
+		t.Errorf("Expected %d updates, got %d (%v)", writers*updates, count, err)
+	}
+}
+
+func TestInMemoryCache_TTL(t *testing.T) {
+	ttlInspection(t, newInMemoryStore)
+}
+
+func TestInMemoryCache_NegativeCaching(t *testing.T) {
+	negativeCaching(t, newInMemoryStore)
+}
+
+func TestInMemoryCache_SoftTTL(t *testing.T) {
+	softExpiration(t, newInMemoryStore)
+}
+
+func TestInMemoryCache_Lock(t *testing.T) {
+	distributedLock(t, newInMemoryStore(t, time.Hour))
+}
+
+func TestInMemoryCache_MaxEntriesLRU(t *testing.T) {
+	var evicted []string
+	store := NewInMemoryStore(time.Hour, WithMaxEntries(3, EvictLRU), WithEvictionCallback(func(key string, value interface{}) {
+		evicted = append(evicted, key+"="+value.(string))
+	}))
+	for _, key := range []string{"a", "b", "c"} {
+		store.Set(key, key, DEFAULT)
+	}
+	var s string
+	store.Get("a", &s)
+	store.Set("d", "d", DEFAULT)
+	store.Set("e", "e", DEFAULT)
+
+	if len(evicted) != 2 || evicted[0] != "b=b" || evicted[1] != "c=c" {
+		t.Errorf("Expected b and c to be evicted, got: %v", evicted)
+	}
+	for _, key := range []string{"a", "d", "e"} {
+		if err := store.Get(key, &s); err != nil {
+			t.Errorf("Expected %s to be kept, got: %v", key, err)
+		}
+	}
+
+	// Deleted keys free their slot without being reported
+	store.Delete("a")
+	store.Set("f", "f", DEFAULT)
+	if len(evicted) != 2 {
+		t.Errorf("Expected no eviction after a delete, got: %v", evicted)
+	}
+}
+
+func TestInMemoryCache_MaxEntriesLFU(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithMaxEntries(2, EvictLFU))
+	store.Set("a", "a", DEFAULT)
+	store.Set("b", "b", DEFAULT)
+	var s string
+	for i := 0; i < 3; i++ {
+		store.Get("a", &s)
+	}
+	store.Get("b", &s)
+	store.Set("c", "c", DEFAULT)
+
+	if err := store.Get("a", &s); err != nil {
+		t.Errorf("Expected the most used key to be kept, got: %v", err)
+	}
+	if err := store.Get("b", &s); err != ErrCacheMiss {
+		t.Errorf("Expected the least used key to be evicted, got: %v", err)
+	}
+}
+
+func TestInMemoryCache_MaxEntriesTinyLFU(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithMaxEntries(2, EvictTinyLFU))
+	var s string
+	for _, key := range []string{"a", "b"} {
+		store.Set(key, key, DEFAULT)
+		for i := 0; i < 3; i++ {
+			store.Get(key, &s)
+		}
+	}
+
+	// A key requested once does not displace popular ones...
+	store.Set("once", "once", DEFAULT)
+	if err := store.Get("once", &s); err != ErrCacheMiss {
+		t.Errorf("Expected a rarely requested key to be rejected, got: %v", err)
+	}
+
+	// ...but one requested often does
+	for i := 0; i < 5; i++ {
+		store.Get("hot", &s)
+	}
+	store.Set("hot", "hot", DEFAULT)
+	if err := store.Get("hot", &s); err != nil {
+		t.Errorf("Expected a frequently requested key to be admitted, got: %v", err)
+	}
+
+	// Add is never rejected
+	if err := store.Add("lock", "lock", DEFAULT); err != nil {
+		t.Errorf("Error adding a key: %s", err)
+	}
+	if err := store.Get("lock", &s); err != nil {
+		t.Errorf("Expected an added key to be stored, got: %v", err)
+	}
+}
+
+func TestInMemoryCache_MaxEntriesFlush(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithMaxEntries(2, EvictLRU))
+	store.Set("a", "a", DEFAULT)
+	store.Set("b", "b", DEFAULT)
+	store.Flush()
+	store.Set("c", "c", DEFAULT)
+	store.Set("d", "d", DEFAULT)
+	var s string
+	for _, key := range []string{"c", "d"} {
+		if err := store.Get(key, &s); err != nil {
+			t.Errorf("Expected %s to be kept after a flush, got: %v", key, err)
+		}
+	}
+}
+
+func TestInMemoryCache_ExpirationCallback(t *testing.T) {
+	expired := make(chan string, 4)
+	store := NewInMemoryStore(time.Hour, WithExpirationCallback(func(key string) {
+		expired <- key
+	}))
+	store.Set("late", 1, 60*time.Millisecond)
+	store.Set("early", 1, 20*time.Millisecond)
+	store.Set("rewritten", 1, 20*time.Millisecond)
+	store.Set("rewritten", 2, DEFAULT)
+	store.Set("deleted", 1, 20*time.Millisecond)
+	store.Delete("deleted")
+	start := time.Now()
+
+	for _, want := range []string{"early", "late"} {
+		select {
+		case key := <-expired:
+			if key != want {
+				t.Fatalf("Expected %s to expire, got %s", want, key)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected %s to expire", want)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected late to expire after its TTL, got %v", elapsed)
+	}
+	if keys := store.keys(); len(keys) != 1 || keys[0] != "rewritten" {
+		t.Errorf("Expected only rewritten to be tracked, got %v", keys)
+	}
+
+	store.Set("flushed", 1, 20*time.Millisecond)
+	store.Flush()
+	select {
+	case key := <-expired:
+		t.Errorf("Expected no callback for overwritten, deleted or flushed keys, got %s", key)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestInMemoryCache_MaxValueBytes(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithMaxValueBytes(256))
+	large := make([]byte, 512)
+	if err := store.Set("large", large, DEFAULT); err != ErrValueTooLarge {
+		t.Errorf("Expected ErrValueTooLarge, got: %v", err)
+	}
+	if err := store.Add("large", large, DEFAULT); err != ErrValueTooLarge {
+		t.Errorf("Expected ErrValueTooLarge from Add, got: %v", err)
+	}
+	var b []byte
+	if err := store.Get("large", &b); err != ErrCacheMiss {
+		t.Errorf("Expected the large item not to be stored, got: %v", err)
+	}
+
+	if err := store.Set("small", []byte("small"), DEFAULT); err != nil {
+		t.Fatalf("Error setting a small item: %v", err)
+	}
+	if err := store.Append("small", large); err != ErrValueTooLarge {
+		t.Errorf("Expected ErrValueTooLarge appending beyond the limit, got: %v", err)
+	}
+	if err := store.Get("small", &b); err != nil || string(b) != "small" {
+		t.Errorf("Expected small to be left unchanged, got %q: %v", b, err)
+	}
+}
+
+func TestInMemoryCache_MaxTotalBytes(t *testing.T) {
+	var evicted []string
+	store := NewInMemoryStore(time.Hour, WithMaxTotalBytes(1000), WithEvictionCallback(func(key string, value interface{}) {
+		evicted = append(evicted, key)
+	}))
+	value := make([]byte, 200)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := store.Set(key, value, DEFAULT); err != nil {
+			t.Fatalf("Error setting %s: %v", key, err)
+		}
+	}
+	var b []byte
+	store.Get("a", &b)
+	// The least recently used item makes room for e
+	store.Set("e", value, DEFAULT)
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Errorf("Expected b to be evicted, got %v", evicted)
+	}
+	if stats := store.Stats(); stats.Bytes > 1000 || stats.Entries != 4 {
+		t.Errorf("Expected 4 entries within 1000 bytes, got %+v", stats)
+	}
+
+	if err := store.Set("huge", make([]byte, 2000), DEFAULT); err != ErrValueTooLarge {
+		t.Errorf("Expected ErrValueTooLarge, got: %v", err)
+	}
+	if stats := store.Stats(); stats.Entries != 4 {
+		t.Errorf("Expected nothing evicted for a rejected item, got %+v", stats)
+	}
+}
+
+func TestInMemoryCache_SaveLoad(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	store.Set("forever", "value", FOREVER)
+	store.Set("ttl", 42, time.Minute)
+	store.Set("expiring", "gone", 50*time.Millisecond)
+	store.Set("counter", uint64(1), DEFAULT)
+
+	var snapshot bytes.Buffer
+	if err := store.Save(&snapshot); err != nil {
+		t.Fatalf("Error saving: %s", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	restored := NewInMemoryStore(time.Hour)
+	restored.Set("ttl", 0, FOREVER)
+	if err := restored.Load(&snapshot); err != nil {
+		t.Fatalf("Error loading: %s", err)
+	}
+	var s string
+	if err := restored.Get("forever", &s); err != nil || s != "value" {
+		t.Errorf("Expected value, got %q (%v)", s, err)
+	}
+	var n int
+	ttl, err := restored.GetWithTTL("ttl", &n)
+	if err != nil || n != 42 {
+		t.Errorf("Expected the loaded item to replace the stored one, got %d (%v)", n, err)
+	}
+	if ttl <= 55*time.Second || ttl > time.Minute {
+		t.Errorf("Expected the item to keep its TTL, got %s", ttl)
+	}
+	if err := restored.Get("expiring", &s); err != ErrCacheMiss {
+		t.Errorf("Expected the expired item to be skipped, got: %v", err)
+	}
+	if value, err := restored.Increment("counter", 1); err != nil || value != 2 {
+		t.Errorf("Expected the counter to be restored, got %d (%v)", value, err)
+	}
+}
+
+func TestInMemoryCache_LoadVersion(t *testing.T) {
+	var snapshot bytes.Buffer
+	gob.NewEncoder(&snapshot).Encode(snapshotHeader{Version: snapshotVersion + 1})
+	if err := NewInMemoryStore(time.Hour).Load(&snapshot); err != ErrSnapshotVersion {
+		t.Errorf("Expected ErrSnapshotVersion, got: %v", err)
+	}
+}
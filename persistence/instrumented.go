@@ -0,0 +1,198 @@
+package persistence
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StoreMetrics is a prometheus.Collector holding the metrics recorded by
+// InstrumentedStore: hits and misses, errors and latency per operation. Every
+// metric is labelled with the name given to the store, so a single
+// StoreMetrics can be shared by several stores.
+type StoreMetrics struct {
+	hits     *prometheus.CounterVec
+	misses   *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// NewStoreMetrics returns a StoreMetrics, to be registered with a
+// prometheus.Registerer
+func NewStoreMetrics() *StoreMetrics {
+	return &StoreMetrics{
+		hits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gincontrib",
+			Subsystem: "cache",
+			Name:      "hits_total",
+			Help:      "Number of keys read from the cache.",
+		}, []string{"store"}),
+		misses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gincontrib",
+			Subsystem: "cache",
+			Name:      "misses_total",
+			Help:      "Number of keys looked up but not found in the cache.",
+		}, []string{"store"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gincontrib",
+			Subsystem: "cache",
+			Name:      "errors_total",
+			Help:      "Number of cache operations that failed, misses excluded.",
+		}, []string{"store", "operation"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "gincontrib",
+			Subsystem: "cache",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of cache operations.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}, []string{"store", "operation"}),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (m *StoreMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.hits.Describe(ch)
+	m.misses.Describe(ch)
+	m.errors.Describe(ch)
+	m.duration.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (m *StoreMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.hits.Collect(ch)
+	m.misses.Collect(ch)
+	m.errors.Collect(ch)
+	m.duration.Collect(ch)
+}
+
+// InstrumentedStore is a CacheStore recording the operations of the store it
+// wraps in a StoreMetrics
+type InstrumentedStore struct {
+	store   CacheStore
+	name    string
+	metrics *StoreMetrics
+}
+
+// NewInstrumentedStore returns an InstrumentedStore wrapping store, whose
+// metrics are recorded in metrics with the store label set to name
+func NewInstrumentedStore(store CacheStore, name string, metrics *StoreMetrics) *InstrumentedStore {
+	return &InstrumentedStore{store: store, name: name, metrics: metrics}
+}
+
+// WithContext (see ContextBinder interface)
+func (s *InstrumentedStore) WithContext(ctx context.Context) CacheStore {
+	return &InstrumentedStore{store: BindContext(ctx, s.store), name: s.name, metrics: s.metrics}
+}
+
+// Get (see CacheStore interface)
+func (s *InstrumentedStore) Get(key string, value interface{}) error {
+	start := time.Now()
+	err := s.store.Get(key, value)
+	s.observe("get", start, err)
+	s.countLookup(err)
+	return err
+}
+
+// Set (see CacheStore interface)
+func (s *InstrumentedStore) Set(key string, value interface{}, expires time.Duration) error {
+	start := time.Now()
+	err := s.store.Set(key, value, expires)
+	s.observe("set", start, err)
+	return err
+}
+
+// Add (see CacheStore interface)
+func (s *InstrumentedStore) Add(key string, value interface{}, expires time.Duration) error {
+	start := time.Now()
+	err := s.store.Add(key, value, expires)
+	s.observe("add", start, err)
+	return err
+}
+
+// Replace (see CacheStore interface)
+func (s *InstrumentedStore) Replace(key string, value interface{}, expires time.Duration) error {
+	start := time.Now()
+	err := s.store.Replace(key, value, expires)
+	s.observe("replace", start, err)
+	return err
+}
+
+// Delete (see CacheStore interface)
+func (s *InstrumentedStore) Delete(key string) error {
+	start := time.Now()
+	err := s.store.Delete(key)
+	s.observe("delete", start, err)
+	return err
+}
+
+// Increment (see CacheStore interface)
+func (s *InstrumentedStore) Increment(key string, delta uint64) (uint64, error) {
+	start := time.Now()
+	n, err := s.store.Increment(key, delta)
+	s.observe("increment", start, err)
+	return n, err
+}
+
+// Decrement (see CacheStore interface)
+func (s *InstrumentedStore) Decrement(key string, delta uint64) (uint64, error) {
+	start := time.Now()
+	n, err := s.store.Decrement(key, delta)
+	s.observe("decrement", start, err)
+	return n, err
+}
+
+// Flush (see CacheStore interface)
+func (s *InstrumentedStore) Flush() error {
+	start := time.Now()
+	err := s.store.Flush()
+	s.observe("flush", start, err)
+	return err
+}
+
+// Close (see CacheStore interface)
+func (s *InstrumentedStore) Close(ctx context.Context) error {
+	return s.store.Close(ctx)
+}
+
+// GetMulti (see CacheStore interface)
+func (s *InstrumentedStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	start := time.Now()
+	found, err := s.store.GetMulti(keys, values)
+	s.observe("get_multi", start, err)
+	for _, ok := range found {
+		if ok {
+			s.metrics.hits.WithLabelValues(s.name).Inc()
+		} else {
+			s.metrics.misses.WithLabelValues(s.name).Inc()
+		}
+	}
+	return found, err
+}
+
+// SetMulti (see CacheStore interface)
+func (s *InstrumentedStore) SetMulti(items map[string]Item) error {
+	start := time.Now()
+	err := s.store.SetMulti(items)
+	s.observe("set_multi", start, err)
+	return err
+}
+
+// observe records the latency of an operation started at start, and its
+// error unless it only reports a miss or an unmet Add or Replace condition
+func (s *InstrumentedStore) observe(operation string, start time.Time, err error) {
+	s.metrics.duration.WithLabelValues(s.name, operation).Observe(time.Since(start).Seconds())
+	if err != nil && err != ErrCacheMiss && err != ErrNotStored {
+		s.metrics.errors.WithLabelValues(s.name, operation).Inc()
+	}
+}
+
+// countLookup counts a single key lookup that returned err as a hit or a miss
+func (s *InstrumentedStore) countLookup(err error) {
+	switch err {
+	case nil:
+		s.metrics.hits.WithLabelValues(s.name).Inc()
+	case ErrCacheMiss:
+		s.metrics.misses.WithLabelValues(s.name).Inc()
+	}
+}
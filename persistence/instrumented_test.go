@@ -0,0 +1,50 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentedStore(t *testing.T) {
+	metrics := NewStoreMetrics()
+	if err := prometheus.NewRegistry().Register(metrics); err != nil {
+		t.Fatalf("Error registering the metrics: %s", err)
+	}
+	store := NewInstrumentedStore(NewInMemoryStore(time.Hour), "memory", metrics)
+
+	var value int
+	store.Set("a", 1, DEFAULT)
+	store.Get("a", &value)
+	store.Get("b", &value)
+	store.GetMulti([]string{"a", "b", "c"}, []interface{}{new(int), new(int), new(int)})
+	if err := store.Add("a", 2, DEFAULT); err != ErrNotStored {
+		t.Errorf("Expected ErrNotStored, got %v", err)
+	}
+	if _, err := store.Increment("a", 1); err != nil {
+		t.Errorf("Error incrementing: %s", err)
+	}
+	store.Set("s", "string", DEFAULT)
+	if _, err := store.Increment("s", 1); err == nil {
+		t.Errorf("Expected an error incrementing a string")
+	}
+
+	if n := testutil.ToFloat64(metrics.hits.WithLabelValues("memory")); n != 2 {
+		t.Errorf("Expected 2 hits, got %v", n)
+	}
+	if n := testutil.ToFloat64(metrics.misses.WithLabelValues("memory")); n != 3 {
+		t.Errorf("Expected 3 misses, got %v", n)
+	}
+	if n := testutil.ToFloat64(metrics.errors.WithLabelValues("memory", "add")); n != 0 {
+		t.Errorf("Expected no add error, got %v", n)
+	}
+	if n := testutil.ToFloat64(metrics.errors.WithLabelValues("memory", "increment")); n != 1 {
+		t.Errorf("Expected 1 increment error, got %v", n)
+	}
+	// hits, misses, one error and 6 operation histograms
+	if n := testutil.CollectAndCount(metrics); n != 9 {
+		t.Errorf("Expected 9 metrics, got %d", n)
+	}
+}
@@ -0,0 +1,119 @@
+package persistence
+
+import (
+	"context"
+	"time"
+)
+
+// InvalidatingStore wraps a store local to an instance, such as an
+// InMemoryStore, to run it on several replicas: every write is broadcast with
+// a PubSubInvalidator, and keys written by other replicas are evicted, so that
+// a replica never serves a value another one replaced or deleted.
+type InvalidatingStore struct {
+	CacheStore
+	invalidator *PubSubInvalidator
+	stop        func()
+}
+
+// NewInvalidatingStore returns an InvalidatingStore wrapping local, subscribed
+// to the invalidations of inv. Close ends the subscription.
+func NewInvalidatingStore(local CacheStore, inv *PubSubInvalidator) (*InvalidatingStore, error) {
+	s := &InvalidatingStore{CacheStore: local, invalidator: inv}
+	stop, err := inv.Subscribe(func(key string) {
+		local.Delete(key)
+	}, func() {
+		local.Flush()
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.stop = stop
+	return s, nil
+}
+
+// Close (see CacheStore interface)
+//
+// It ends the subscription to invalidations from other replicas, and closes
+// the local store.
+func (s *InvalidatingStore) Close(ctx context.Context) error {
+	s.stop()
+	return s.CacheStore.Close(ctx)
+}
+
+// Set (see CacheStore interface)
+func (s *InvalidatingStore) Set(key string, value interface{}, expires time.Duration) error {
+	if err := s.CacheStore.Set(key, value, expires); err != nil {
+		return err
+	}
+	return s.invalidator.Invalidate(key)
+}
+
+// Add (see CacheStore interface)
+func (s *InvalidatingStore) Add(key string, value interface{}, expires time.Duration) error {
+	if err := s.CacheStore.Add(key, value, expires); err != nil {
+		return err
+	}
+	return s.invalidator.Invalidate(key)
+}
+
+// Replace (see CacheStore interface)
+func (s *InvalidatingStore) Replace(key string, value interface{}, expires time.Duration) error {
+	if err := s.CacheStore.Replace(key, value, expires); err != nil {
+		return err
+	}
+	return s.invalidator.Invalidate(key)
+}
+
+// Delete (see CacheStore interface)
+//
+// The key is invalidated on the other replicas even if it was not found
+// locally.
+func (s *InvalidatingStore) Delete(key string) error {
+	err := s.CacheStore.Delete(key)
+	if err != nil && err != ErrCacheMiss {
+		return err
+	}
+	if invErr := s.invalidator.Invalidate(key); invErr != nil {
+		return invErr
+	}
+	return err
+}
+
+// Increment (see CacheStore interface)
+func (s *InvalidatingStore) Increment(key string, delta uint64) (uint64, error) {
+	n, err := s.CacheStore.Increment(key, delta)
+	if err != nil {
+		return n, err
+	}
+	return n, s.invalidator.Invalidate(key)
+}
+
+// Decrement (see CacheStore interface)
+func (s *InvalidatingStore) Decrement(key string, delta uint64) (uint64, error) {
+	n, err := s.CacheStore.Decrement(key, delta)
+	if err != nil {
+		return n, err
+	}
+	return n, s.invalidator.Invalidate(key)
+}
+
+// Flush (see CacheStore interface)
+func (s *InvalidatingStore) Flush() error {
+	if err := s.CacheStore.Flush(); err != nil {
+		return err
+	}
+	return s.invalidator.InvalidateAll()
+}
+
+// SetMulti (see CacheStore interface)
+func (s *InvalidatingStore) SetMulti(items map[string]Item) error {
+	if err := s.CacheStore.SetMulti(items); err != nil {
+		return err
+	}
+	for key := range items {
+		if err := s.invalidator.Invalidate(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package persistence
+
+import (
+	"errors"
+	"time"
+)
+
+// errStopIteration stops the walk of the keys once the function passed to
+// Iterate returns false
+var errStopIteration = errors.New("cache: iteration stopped.")
+
+// Meta describes an item listed by Iterate
+type Meta struct {
+	// TTL is the time the item has left to live, FOREVER if it does not
+	// expire
+	TTL time.Duration
+	// Size is an estimate of the memory used by the item, 0 if the store
+	// does not know it
+	Size int64
+}
+
+// IterableStore is implemented by stores able to list their keys, so that
+// tooling can inspect, migrate or selectively evict items
+type IterableStore interface {
+	CacheStore
+
+	// Keys returns the keys starting with prefix, in no particular order.
+	Keys(prefix string) ([]string, error)
+
+	// Iterate calls fn with each key starting with prefix and the metadata
+	// of its item, in no particular order, until fn returns false. Items
+	// written while Iterate runs may or may not be listed; fn may delete
+	// items, or write other ones.
+	Iterate(prefix string, fn func(key string, meta Meta) bool) error
+}
+
+// Keys returns the keys of store starting with prefix. It returns
+// ErrNotSupport if the store is not an IterableStore: memcached, for one,
+// cannot list its keys.
+func Keys(store CacheStore, prefix string) ([]string, error) {
+	iterable, ok := store.(IterableStore)
+	if !ok {
+		return nil, ErrNotSupport
+	}
+	return iterable.Keys(prefix)
+}
+
+// Iterate calls fn with each key of store starting with prefix, until fn
+// returns false. It returns ErrNotSupport if the store is not an
+// IterableStore: memcached, for one, cannot list its keys.
+func Iterate(store CacheStore, prefix string, fn func(key string, meta Meta) bool) error {
+	iterable, ok := store.(IterableStore)
+	if !ok {
+		return ErrNotSupport
+	}
+	return iterable.Iterate(prefix, fn)
+}
+
+// collectKeys implements Keys with Iterate
+func collectKeys(store IterableStore, prefix string) ([]string, error) {
+	var keys []string
+	err := store.Iterate(prefix, func(key string, _ Meta) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys, err
+}
@@ -0,0 +1,260 @@
+package persistence
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+// KeyFilter records the keys that may exist, such as the IDs of a dataset or
+// the keys written to a store, so that lookups of keys that definitely do not
+// exist can be answered without reaching a store or an origin. Filters may
+// report false positives, but no false negatives as long as Remove is only
+// called for keys that were added.
+type KeyFilter interface {
+	// Add records that key may exist
+	Add(key string) error
+	// Remove forgets one Add of key
+	Remove(key string) error
+	// MayContain reports whether key may exist, false meaning that it
+	// definitely does not
+	MayContain(key string) (bool, error)
+	// Reset forgets every key, e.g. before adding the keys of a dataset
+	// again
+	Reset() error
+}
+
+// CountingBloomFilter is a KeyFilter held in memory, counting the keys added
+// in each of its cells so that they can be removed. It is safe for
+// concurrent use.
+type CountingBloomFilter struct {
+	mu     sync.RWMutex
+	counts []uint8
+	hashes int
+}
+
+// NewCountingBloomFilter returns a CountingBloomFilter sized to hold
+// expectedKeys with falsePositiveRate of false positives, e.g. 0.01
+func NewCountingBloomFilter(expectedKeys int, falsePositiveRate float64) *CountingBloomFilter {
+	if expectedKeys < 1 {
+		expectedKeys = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+	cells := math.Ceil(-float64(expectedKeys) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashes := int(math.Round(cells / float64(expectedKeys) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &CountingBloomFilter{counts: make([]uint8, int(cells)), hashes: hashes}
+}
+
+// cells calls fn with the cells of key, computed by double hashing
+func (f *CountingBloomFilter) cells(key string, fn func(cell int)) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&math.MaxUint32, sum>>32|1
+	n := uint64(len(f.counts))
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		fn(int((h1 + i*h2) % n))
+	}
+}
+
+// Add (see KeyFilter interface)
+func (f *CountingBloomFilter) Add(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cells(key, func(cell int) {
+		if f.counts[cell] < math.MaxUint8 {
+			f.counts[cell]++
+		}
+	})
+	return nil
+}
+
+// Remove (see KeyFilter interface)
+//
+// Saturated cells are never decremented, as their count is unknown.
+func (f *CountingBloomFilter) Remove(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cells(key, func(cell int) {
+		if count := f.counts[cell]; count > 0 && count < math.MaxUint8 {
+			f.counts[cell]--
+		}
+	})
+	return nil
+}
+
+// MayContain (see KeyFilter interface)
+func (f *CountingBloomFilter) MayContain(key string) (bool, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	contains := true
+	f.cells(key, func(cell int) {
+		if f.counts[cell] == 0 {
+			contains = false
+		}
+	})
+	return contains, nil
+}
+
+// Reset (see KeyFilter interface)
+func (f *CountingBloomFilter) Reset() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.counts {
+		f.counts[i] = 0
+	}
+	return nil
+}
+
+// FilteredStore is a CacheStore recording the keys written to the store it
+// wraps in a KeyFilter, and answering the reads of keys the filter does not
+// contain with ErrCacheMiss without reaching the store. This keeps floods of
+// requests for keys that were never cached off a remote store.
+//
+// Keys written several times stay in the filter until deleted as many times,
+// or until the store is flushed, which resets the filter. If the filter
+// fails, reads go to the store.
+type FilteredStore struct {
+	store  CacheStore
+	filter KeyFilter
+}
+
+// NewFilteredStore returns a FilteredStore filtering the reads of store with
+// filter. Keys already in store must be added to filter, or they are missed.
+func NewFilteredStore(store CacheStore, filter KeyFilter) *FilteredStore {
+	return &FilteredStore{store: store, filter: filter}
+}
+
+// WithContext (see ContextBinder interface)
+func (s *FilteredStore) WithContext(ctx context.Context) CacheStore {
+	return &FilteredStore{store: BindContext(ctx, s.store), filter: s.filter}
+}
+
+// excluded reports whether key definitely is not in the store
+func (s *FilteredStore) excluded(key string) bool {
+	contains, err := s.filter.MayContain(key)
+	return err == nil && !contains
+}
+
+// Get (see CacheStore interface)
+func (s *FilteredStore) Get(key string, value interface{}) error {
+	if s.excluded(key) {
+		return ErrCacheMiss
+	}
+	return s.store.Get(key, value)
+}
+
+// Set (see CacheStore interface)
+func (s *FilteredStore) Set(key string, value interface{}, expires time.Duration) error {
+	// Keys are added first, so that they are found as soon as written
+	if err := s.filter.Add(key); err != nil {
+		return err
+	}
+	return s.store.Set(key, value, expires)
+}
+
+// Add (see CacheStore interface)
+func (s *FilteredStore) Add(key string, value interface{}, expires time.Duration) error {
+	if err := s.filter.Add(key); err != nil {
+		return err
+	}
+	return s.store.Add(key, value, expires)
+}
+
+// Replace (see CacheStore interface)
+func (s *FilteredStore) Replace(key string, value interface{}, expires time.Duration) error {
+	if s.excluded(key) {
+		return ErrNotStored
+	}
+	return s.store.Replace(key, value, expires)
+}
+
+// Delete (see CacheStore interface)
+func (s *FilteredStore) Delete(key string) error {
+	if s.excluded(key) {
+		return ErrCacheMiss
+	}
+	if err := s.store.Delete(key); err != nil {
+		return err
+	}
+	return s.filter.Remove(key)
+}
+
+// Increment (see CacheStore interface)
+func (s *FilteredStore) Increment(key string, delta uint64) (uint64, error) {
+	if s.excluded(key) {
+		return 0, ErrCacheMiss
+	}
+	return s.store.Increment(key, delta)
+}
+
+// Decrement (see CacheStore interface)
+func (s *FilteredStore) Decrement(key string, delta uint64) (uint64, error) {
+	if s.excluded(key) {
+		return 0, ErrCacheMiss
+	}
+	return s.store.Decrement(key, delta)
+}
+
+// Flush (see CacheStore interface)
+func (s *FilteredStore) Flush() error {
+	if err := s.store.Flush(); err != nil {
+		return err
+	}
+	return s.filter.Reset()
+}
+
+// Close (see CacheStore interface)
+func (s *FilteredStore) Close(ctx context.Context) error {
+	return s.store.Close(ctx)
+}
+
+// GetMulti (see CacheStore interface)
+//
+// Only the keys the filter may contain are read from the store.
+func (s *FilteredStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	if len(keys) != len(values) {
+		return nil, errGetMultiLength
+	}
+	var (
+		indexes []int
+		wanted  []string
+		targets []interface{}
+	)
+	for i, key := range keys {
+		if !s.excluded(key) {
+			indexes = append(indexes, i)
+			wanted = append(wanted, key)
+			targets = append(targets, values[i])
+		}
+	}
+	found := make([]bool, len(keys))
+	if len(wanted) == 0 {
+		return found, nil
+	}
+	got, err := s.store.GetMulti(wanted, targets)
+	if err != nil {
+		return nil, err
+	}
+	for n, i := range indexes {
+		found[i] = got[n]
+	}
+	return found, nil
+}
+
+// SetMulti (see CacheStore interface)
+func (s *FilteredStore) SetMulti(items map[string]Item) error {
+	for key := range items {
+		if err := s.filter.Add(key); err != nil {
+			return err
+		}
+	}
+	return s.store.SetMulti(items)
+}
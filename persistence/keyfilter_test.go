@@ -0,0 +1,91 @@
+package persistence
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCountingBloomFilter(t *testing.T) {
+	filter := NewCountingBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		filter.Add(fmt.Sprint("key:", i))
+	}
+	for i := 0; i < 1000; i++ {
+		if contains, _ := filter.MayContain(fmt.Sprint("key:", i)); !contains {
+			t.Fatalf("Expected no false negative, key:%d is missing", i)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if contains, _ := filter.MayContain(fmt.Sprint("missing:", i)); contains {
+			falsePositives++
+		}
+	}
+	if falsePositives > 300 {
+		t.Errorf("Expected about 1%% of false positives, got %d in 10000", falsePositives)
+	}
+
+	filter.Remove("key:1")
+	if contains, _ := filter.MayContain("key:1"); contains {
+		t.Errorf("Expected a removed key to be forgotten")
+	}
+	filter.Reset()
+	if contains, _ := filter.MayContain("key:2"); contains {
+		t.Errorf("Expected a reset to forget every key")
+	}
+}
+
+func TestFilteredStore(t *testing.T) {
+	backend := &flakyStore{CacheStore: NewInMemoryStore(time.Hour)}
+	store := NewFilteredStore(backend, NewCountingBloomFilter(100, 0.01))
+
+	var s string
+	if err := store.Get("missing", &s); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss, got: %v", err)
+	}
+	if backend.calls != 0 {
+		t.Errorf("Expected a filtered key not to reach the store, got %d calls", backend.calls)
+	}
+
+	if err := store.Set("present", "value", DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	if err := store.Get("present", &s); err != nil || s != "value" {
+		t.Errorf("Expected to read the value back, got %q, %v", s, err)
+	}
+
+	var missing string
+	found, err := store.GetMulti([]string{"missing", "present"}, []interface{}{&missing, &s})
+	if err != nil || found[0] || !found[1] {
+		t.Errorf("Expected only present to be found, got %v, %v", found, err)
+	}
+
+	if err := store.Delete("present"); err != nil {
+		t.Errorf("Error deleting a value: %s", err)
+	}
+	calls := backend.calls
+	if err := store.Get("present", &s); err != ErrCacheMiss || backend.calls != calls {
+		t.Errorf("Expected a deleted key to be filtered, got: %v", err)
+	}
+}
+
+func TestRedisCuckooFilter(t *testing.T) {
+	store := newRedisStore(t, time.Hour).(*RedisStore)
+	filter, err := NewRedisCuckooFilter(store, "filter:test", 1000)
+	if err != nil && strings.Contains(strings.ToLower(err.Error()), "unknown command") {
+		t.Skip("Redis does not have the RedisBloom module")
+	}
+	if err != nil {
+		t.Fatalf("Error creating the filter: %s", err)
+	}
+	filter.Add("a")
+	if contains, err := filter.MayContain("a"); err != nil || !contains {
+		t.Errorf("Expected a to be in the filter, got %v, %v", contains, err)
+	}
+	filter.Remove("a")
+	if contains, err := filter.MayContain("a"); err != nil || contains {
+		t.Errorf("Expected a to be removed, got %v, %v", contains, err)
+	}
+}
@@ -0,0 +1,178 @@
+package persistence
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// LimitedStore is a CacheStore bounding the number of operations in flight
+// on the store it wraps. When the backend slows down, operations beyond the
+// limit wait in line for up to the queue timeout, then fail with
+// ErrCacheUnavailable instead of piling up goroutines and connections: the
+// middleware treats the error like any other store error and runs the
+// handler, so requests are served uncached until the backend recovers.
+type LimitedStore struct {
+	store   CacheStore
+	ctx     context.Context
+	limiter *limiter
+}
+
+type limiter struct {
+	// shed is accessed atomically and kept first for 64-bit alignment
+	shed uint64
+
+	slots        chan struct{}
+	queueTimeout time.Duration
+}
+
+// NewLimitedStore returns a LimitedStore running at most maxConcurrent
+// operations on store at once. An operation waits for up to queueTimeout
+// for another to return, or fails right away if queueTimeout is not
+// positive.
+func NewLimitedStore(store CacheStore, maxConcurrent int, queueTimeout time.Duration) *LimitedStore {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	return &LimitedStore{
+		store: store,
+		ctx:   context.Background(),
+		limiter: &limiter{
+			slots:        make(chan struct{}, maxConcurrent),
+			queueTimeout: queueTimeout,
+		},
+	}
+}
+
+// WithContext (see ContextBinder interface)
+//
+// The bound store shares the limit of c, and stops waiting in line once ctx
+// is done, returning its error.
+func (c *LimitedStore) WithContext(ctx context.Context) CacheStore {
+	return &LimitedStore{store: BindContext(ctx, c.store), ctx: ctx, limiter: c.limiter}
+}
+
+// Shed returns the number of operations that failed with ErrCacheUnavailable
+func (c *LimitedStore) Shed() uint64 {
+	return atomic.LoadUint64(&c.limiter.shed)
+}
+
+// Get (see CacheStore interface)
+func (c *LimitedStore) Get(key string, value interface{}) error {
+	return c.do(func() error {
+		return c.store.Get(key, value)
+	})
+}
+
+// Set (see CacheStore interface)
+func (c *LimitedStore) Set(key string, value interface{}, expires time.Duration) error {
+	return c.do(func() error {
+		return c.store.Set(key, value, expires)
+	})
+}
+
+// Add (see CacheStore interface)
+func (c *LimitedStore) Add(key string, value interface{}, expires time.Duration) error {
+	return c.do(func() error {
+		return c.store.Add(key, value, expires)
+	})
+}
+
+// Replace (see CacheStore interface)
+func (c *LimitedStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return c.do(func() error {
+		return c.store.Replace(key, value, expires)
+	})
+}
+
+// Delete (see CacheStore interface)
+func (c *LimitedStore) Delete(key string) error {
+	return c.do(func() error {
+		return c.store.Delete(key)
+	})
+}
+
+// Increment (see CacheStore interface)
+func (c *LimitedStore) Increment(key string, delta uint64) (n uint64, err error) {
+	err = c.do(func() error {
+		n, err = c.store.Increment(key, delta)
+		return err
+	})
+	return n, err
+}
+
+// Decrement (see CacheStore interface)
+func (c *LimitedStore) Decrement(key string, delta uint64) (n uint64, err error) {
+	err = c.do(func() error {
+		n, err = c.store.Decrement(key, delta)
+		return err
+	})
+	return n, err
+}
+
+// Flush (see CacheStore interface)
+func (c *LimitedStore) Flush() error {
+	return c.do(c.store.Flush)
+}
+
+// Close (see CacheStore interface)
+func (c *LimitedStore) Close(ctx context.Context) error {
+	return c.store.Close(ctx)
+}
+
+// GetMulti (see CacheStore interface)
+//
+// It counts as a single operation.
+func (c *LimitedStore) GetMulti(keys []string, values []interface{}) (found []bool, err error) {
+	err = c.do(func() error {
+		found, err = c.store.GetMulti(keys, values)
+		return err
+	})
+	return found, err
+}
+
+// SetMulti (see CacheStore interface)
+//
+// It counts as a single operation.
+func (c *LimitedStore) SetMulti(items map[string]Item) error {
+	return c.do(func() error {
+		return c.store.SetMulti(items)
+	})
+}
+
+// do runs op once a slot is free, or fails if none frees up in time
+func (c *LimitedStore) do(op func() error) error {
+	if err := c.limiter.acquire(c.ctx); err != nil {
+		return err
+	}
+	defer c.limiter.release()
+	return op()
+}
+
+func (l *limiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if l.queueTimeout <= 0 {
+		atomic.AddUint64(&l.shed, 1)
+		return ErrCacheUnavailable
+	}
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		atomic.AddUint64(&l.shed, 1)
+		return ErrCacheUnavailable
+	}
+}
+
+func (l *limiter) release() {
+	<-l.slots
+}
@@ -0,0 +1,71 @@
+package persistence
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+var newLimitedStore = func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
+	return NewLimitedStore(NewInMemoryStore(defaultExpiration), 4, time.Second)
+}
+
+// Test typical cache interactions
+func TestLimitedCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newLimitedStore)
+}
+
+func TestLimitedCache_IncrDecr(t *testing.T) {
+	incrDecr(t, newLimitedStore)
+}
+
+func TestLimitedCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newLimitedStore)
+}
+
+func TestLimitedCache_Add(t *testing.T) {
+	testAdd(t, newLimitedStore)
+}
+
+func TestLimitedCache_Saturated(t *testing.T) {
+	gate := make(chan struct{})
+	store := NewLimitedStore(gatedStore{NewInMemoryStore(time.Hour), gate}, 2, 50*time.Millisecond)
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- store.Set("slow", "value", DEFAULT)
+		}()
+	}
+	for len(store.limiter.slots) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var s string
+	start := time.Now()
+	if err := store.Get("slow", &s); err != ErrCacheUnavailable {
+		t.Errorf("Expected ErrCacheUnavailable, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the operation to wait for the queue timeout, waited %s", elapsed)
+	}
+	if store.Shed() != 1 {
+		t.Errorf("Expected 1 operation shed, got %d", store.Shed())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := store.WithContext(ctx).Get("slow", &s); err != context.Canceled {
+		t.Errorf("Expected the wait to stop with the context, got: %v", err)
+	}
+
+	close(gate)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("Unexpected error setting a value: %s", err)
+		}
+	}
+	if err := store.Get("slow", &s); err != nil || s != "value" {
+		t.Errorf("Expected the store to be available again, got: %q, %v", s, err)
+	}
+}
@@ -0,0 +1,165 @@
+package persistence
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// unlockSource deletes the lock at KEYS[1] if it still holds the token
+// ARGV[1], so that a process whose lock expired does not release the lock
+// since acquired by another
+const unlockSource = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`
+
+// LockStore is implemented by stores providing locks shared by every process
+// using the store, e.g. to let a single replica regenerate an expired page.
+type LockStore interface {
+	CacheStore
+
+	// TryLock acquires the lock named key for ttl, unless it is held. The
+	// token returned releases it.
+	TryLock(key string, ttl time.Duration) (token string, acquired bool, err error)
+
+	// Unlock releases the lock named key if it is still held with token.
+	Unlock(key, token string) error
+}
+
+// TryLock acquires the lock named key in store for ttl, unless it is held.
+// Stores that are not a LockStore hold the lock as an item added with Add.
+func TryLock(store CacheStore, key string, ttl time.Duration) (token string, acquired bool, err error) {
+	if locker, ok := store.(LockStore); ok {
+		return locker.TryLock(key, ttl)
+	}
+	token, err = newLockToken()
+	if err != nil {
+		return "", false, err
+	}
+	switch err = store.Add(key, token, ttl); err {
+	case nil:
+		return token, true, nil
+	case ErrNotStored:
+		return "", false, nil
+	}
+	return "", false, err
+}
+
+// lockBackoff spaces the attempts of Lock to acquire a held lock
+var lockBackoff Backoff = ExponentialBackoff{
+	Base:   10 * time.Millisecond,
+	Max:    500 * time.Millisecond,
+	Jitter: 0.5,
+}
+
+// Lock acquires the lock named key in store for ttl, like TryLock, waiting
+// for it to be released or to expire if it is held. It gives up when ctx is
+// done, returning its error. Release the lock with Unlock and the token
+// returned.
+//
+// Use it to run a job in a single process among those sharing the store:
+//
+//	token, err := persistence.Lock(ctx, store, "refresh:products", time.Minute)
+//	if err != nil {
+//		return err
+//	}
+//	defer persistence.Unlock(store, "refresh:products", token)
+//
+// The lock is held for ttl at most: set it above the time the job takes.
+func Lock(ctx context.Context, store CacheStore, key string, ttl time.Duration) (token string, err error) {
+	store = BindContext(ctx, store)
+	for attempt := 0; ; attempt++ {
+		token, acquired, err := TryLock(store, key, ttl)
+		if err != nil || acquired {
+			return token, err
+		}
+		timer := time.NewTimer(lockBackoff.NextDelay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Unlock releases the lock named key in store, acquired with TryLock, if it
+// is still held with token. For stores that are not a LockStore, the check
+// and the deletion are not atomic.
+func Unlock(store CacheStore, key, token string) error {
+	if locker, ok := store.(LockStore); ok {
+		return locker.Unlock(key, token)
+	}
+	var held string
+	if err := store.Get(key, &held); err != nil {
+		if err == ErrCacheMiss {
+			return nil
+		}
+		return err
+	}
+	if held != token {
+		return nil
+	}
+	return DeleteQuiet(store, key)
+}
+
+// lockTable holds the locks of the in-memory stores, apart from their items
+// so that locks are neither evicted nor flushed
+type lockTable struct {
+	mu    sync.Mutex
+	locks map[string]heldLock
+}
+
+type heldLock struct {
+	token     string
+	expiresAt time.Time
+}
+
+func (t *lockTable) tryLock(key string, ttl time.Duration) (token string, acquired bool, err error) {
+	token, err = newLockToken()
+	if err != nil {
+		return "", false, err
+	}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if held, ok := t.locks[key]; ok && now.Before(held.expiresAt) {
+		return "", false, nil
+	}
+	if t.locks == nil {
+		t.locks = make(map[string]heldLock)
+	}
+	t.locks[key] = heldLock{token, now.Add(ttl)}
+	return token, true, nil
+}
+
+func (t *lockTable) unlock(key, token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if held, ok := t.locks[key]; ok && held.token == token {
+		delete(t.locks, key)
+	}
+}
+
+// lockSeconds converts the ttl of a lock to a memcached expiration, rounding
+// it up: an expiration of 0 would hold the lock forever
+func lockSeconds(ttl time.Duration) int32 {
+	seconds := int32((ttl + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,200 @@
+package persistence
+
+import (
+	"context"
+	"time"
+)
+
+// Kinds of LogEvent
+const (
+	LogHit   = "hit"
+	LogMiss  = "miss"
+	LogStore = "store"
+	LogError = "error"
+
+	// LogDryRun reports a write skipped by a RedisStore created with
+	// WithDryRunWrites
+	LogDryRun = "dry_run"
+)
+
+// LogEvent is an event reported to a Logger, with its fields
+type LogEvent struct {
+	// Kind is LogHit, LogMiss, LogStore, LogError or LogDryRun
+	Kind string
+	// Operation is the name of the method of the store, such as "get" or
+	// "set_multi", or "page" for the events of the page cache middleware
+	Operation string
+	// Key is the key of the item, empty for Flush
+	Key string
+	// Expires is the expiration the item was stored with
+	Expires time.Duration
+	// Duration is the time the operation took, zero for the events of the
+	// page cache middleware
+	Duration time.Duration
+	// Err is the error of LogError events
+	Err error
+}
+
+// Logger receives the events of a LoggedStore, or of the pages cached with
+// the WithLogger option of the middleware, to log them with the structured
+// logger of the application
+type Logger interface {
+	Log(event LogEvent)
+}
+
+// LoggerFunc is a function used as a Logger
+type LoggerFunc func(event LogEvent)
+
+// Log (see Logger interface)
+func (f LoggerFunc) Log(event LogEvent) {
+	f(event)
+}
+
+// LoggedStore is a CacheStore reporting the hits, misses, writes and errors
+// of the store it wraps to a Logger. Misses, and writes skipped by Add and
+// Replace, are not errors.
+type LoggedStore struct {
+	store  CacheStore
+	logger Logger
+}
+
+// NewLoggedStore returns a LoggedStore wrapping store, reporting to logger
+func NewLoggedStore(store CacheStore, logger Logger) *LoggedStore {
+	return &LoggedStore{store: store, logger: logger}
+}
+
+// WithContext (see ContextBinder interface)
+func (s *LoggedStore) WithContext(ctx context.Context) CacheStore {
+	return &LoggedStore{store: BindContext(ctx, s.store), logger: s.logger}
+}
+
+// Get (see CacheStore interface)
+func (s *LoggedStore) Get(key string, value interface{}) error {
+	start := time.Now()
+	err := s.store.Get(key, value)
+	switch err {
+	case nil:
+		s.log(LogHit, "get", key, 0, start, nil)
+	case ErrCacheMiss:
+		s.log(LogMiss, "get", key, 0, start, nil)
+	default:
+		s.log(LogError, "get", key, 0, start, err)
+	}
+	return err
+}
+
+// Set (see CacheStore interface)
+func (s *LoggedStore) Set(key string, value interface{}, expires time.Duration) error {
+	start := time.Now()
+	err := s.store.Set(key, value, expires)
+	s.logWrite("set", key, expires, start, err)
+	return err
+}
+
+// Add (see CacheStore interface)
+func (s *LoggedStore) Add(key string, value interface{}, expires time.Duration) error {
+	start := time.Now()
+	err := s.store.Add(key, value, expires)
+	s.logWrite("add", key, expires, start, err)
+	return err
+}
+
+// Replace (see CacheStore interface)
+func (s *LoggedStore) Replace(key string, value interface{}, expires time.Duration) error {
+	start := time.Now()
+	err := s.store.Replace(key, value, expires)
+	s.logWrite("replace", key, expires, start, err)
+	return err
+}
+
+// Delete (see CacheStore interface)
+func (s *LoggedStore) Delete(key string) error {
+	start := time.Now()
+	err := s.store.Delete(key)
+	if err != nil && err != ErrCacheMiss {
+		s.log(LogError, "delete", key, 0, start, err)
+	}
+	return err
+}
+
+// Increment (see CacheStore interface)
+func (s *LoggedStore) Increment(key string, delta uint64) (uint64, error) {
+	start := time.Now()
+	n, err := s.store.Increment(key, delta)
+	if err != nil && err != ErrCacheMiss {
+		s.log(LogError, "increment", key, 0, start, err)
+	}
+	return n, err
+}
+
+// Decrement (see CacheStore interface)
+func (s *LoggedStore) Decrement(key string, delta uint64) (uint64, error) {
+	start := time.Now()
+	n, err := s.store.Decrement(key, delta)
+	if err != nil && err != ErrCacheMiss {
+		s.log(LogError, "decrement", key, 0, start, err)
+	}
+	return n, err
+}
+
+// Flush (see CacheStore interface)
+func (s *LoggedStore) Flush() error {
+	start := time.Now()
+	err := s.store.Flush()
+	if err != nil {
+		s.log(LogError, "flush", "", 0, start, err)
+	}
+	return err
+}
+
+// Close (see CacheStore interface)
+func (s *LoggedStore) Close(ctx context.Context) error {
+	return s.store.Close(ctx)
+}
+
+// GetMulti (see CacheStore interface)
+func (s *LoggedStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	start := time.Now()
+	found, err := s.store.GetMulti(keys, values)
+	if err != nil {
+		s.log(LogError, "get_multi", "", 0, start, err)
+		return found, err
+	}
+	for i, ok := range found {
+		if ok {
+			s.log(LogHit, "get_multi", keys[i], 0, start, nil)
+		} else {
+			s.log(LogMiss, "get_multi", keys[i], 0, start, nil)
+		}
+	}
+	return found, err
+}
+
+// SetMulti (see CacheStore interface)
+func (s *LoggedStore) SetMulti(items map[string]Item) error {
+	start := time.Now()
+	err := s.store.SetMulti(items)
+	if err != nil {
+		s.log(LogError, "set_multi", "", 0, start, err)
+		return err
+	}
+	for key, item := range items {
+		s.log(LogStore, "set_multi", key, item.Expire, start, nil)
+	}
+	return nil
+}
+
+// logWrite reports a write of key that returned err
+func (s *LoggedStore) logWrite(operation, key string, expires time.Duration, start time.Time, err error) {
+	switch err {
+	case nil:
+		s.log(LogStore, operation, key, expires, start, nil)
+	case ErrNotStored:
+	default:
+		s.log(LogError, operation, key, expires, start, err)
+	}
+}
+
+func (s *LoggedStore) log(kind, operation, key string, expires time.Duration, start time.Time, err error) {
+	s.logger.Log(LogEvent{Kind: kind, Operation: operation, Key: key, Expires: expires, Duration: time.Since(start), Err: err})
+}
@@ -0,0 +1,59 @@
+package persistence
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var newLoggedStore = func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
+	return NewLoggedStore(NewInMemoryStore(defaultExpiration), LoggerFunc(func(LogEvent) {}))
+}
+
+// Test typical cache interactions
+func TestLoggedCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newLoggedStore)
+}
+
+func TestLoggedCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newLoggedStore)
+}
+
+func TestLoggedStore_Events(t *testing.T) {
+	var events []string
+	backend := &flakyStore{CacheStore: NewInMemoryStore(time.Hour)}
+	store := NewLoggedStore(backend, LoggerFunc(func(e LogEvent) {
+		event := e.Kind + " " + e.Operation + " " + e.Key
+		if e.Expires != 0 {
+			event += " " + e.Expires.String()
+		}
+		if e.Err != nil {
+			event += ": " + e.Err.Error()
+		}
+		events = append(events, event)
+	}))
+
+	var value int
+	store.Set("a", 1, time.Minute)
+	store.Get("a", &value)
+	store.Get("b", &value)
+	store.Add("a", 2, DEFAULT)
+	store.GetMulti([]string{"a", "c"}, []interface{}{new(int), new(int)})
+	store.Delete("c")
+	backend.down = 1
+	store.Get("a", &value)
+	store.Set("a", 1, time.Minute)
+
+	expected := []string{
+		"store set a 1m0s",
+		"hit get a",
+		"miss get b",
+		"hit get_multi a",
+		"miss get_multi c",
+		"error get a: connection refused",
+		"error set a 1m0s: connection refused",
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected events %q, got %q", expected, events)
+	}
+}
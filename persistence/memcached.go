@@ -1,21 +1,68 @@
 package persistence
 
 import (
+	"context"
+	"crypto/tls"
 	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
-	"github.com/gin-contrib/cache/utils"
+	"github.com/mlsen/cache/utils"
 )
 
-// MemcachedStore represents the cache with memcached persistence
+// MemcachedStore represents the cache with memcached persistence. It is not
+// an IterableStore: memcached cannot list its keys, and Keys and Iterate
+// return ErrNotSupport for it.
 type MemcachedStore struct {
 	*memcache.Client
 	defaultExpiration time.Duration
+	codec             utils.Codec
+	tunnels           tlsTunnels
+}
+
+// MemcachedOption configures optional behaviour of a MemcachedStore
+type MemcachedOption func(*MemcachedStore)
+
+// WithMemcachedCodec sets the Codec used to encode values other than byte
+// slices and integers. The default is utils.GobCodec.
+func WithMemcachedCodec(codec utils.Codec) MemcachedOption {
+	return func(c *MemcachedStore) {
+		c.codec = codec
+	}
 }
 
 // NewMemcachedStore returns a MemcachedStore
-func NewMemcachedStore(hostList []string, defaultExpiration time.Duration) *MemcachedStore {
-	return &MemcachedStore{memcache.New(hostList...), defaultExpiration}
+func NewMemcachedStore(hostList []string, defaultExpiration time.Duration, options ...MemcachedOption) *MemcachedStore {
+	c := &MemcachedStore{
+		Client:            memcache.New(hostList...),
+		defaultExpiration: defaultExpiration,
+		codec:             utils.GobCodec,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// NewMemcachedStoreTLS returns a MemcachedStore connecting to the servers of
+// hostList over TLS, as AWS ElastiCache requires with in-transit encryption.
+// The server name is verified against the host of each server, unless config
+// sets one. Call Close to release the connections.
+func NewMemcachedStoreTLS(hostList []string, defaultExpiration time.Duration, config *tls.Config, options ...MemcachedOption) (*MemcachedStore, error) {
+	tunnels, locals, err := openTLSTunnels(hostList, config)
+	if err != nil {
+		return nil, err
+	}
+	c := NewMemcachedStore(locals, defaultExpiration, options...)
+	c.tunnels = tunnels
+	return c, nil
+}
+
+// Close (see CacheStore interface)
+//
+// It closes the TLS connections of a store created with
+// NewMemcachedStoreTLS, and does nothing for other stores.
+func (c *MemcachedStore) Close(ctx context.Context) error {
+	return c.tunnels.Close()
 }
 
 // Set (see CacheStore interface)
@@ -39,7 +86,147 @@ func (c *MemcachedStore) Get(key string, value interface{}) error {
 	if err != nil {
 		return convertMemcacheError(err)
 	}
-	return utils.Deserialize(item.Value, value)
+	return utils.DeserializeWith(c.codec, item.Value, value)
+}
+
+// GetWithTTL (see TTLStore interface)
+//
+// The time to live is derived from the expiration time the store keeps in
+// the flags of items, with a precision of a second. Items written by other
+// clients report FOREVER.
+func (c *MemcachedStore) GetWithTTL(key string, value interface{}) (time.Duration, error) {
+	item, err := c.Client.Get(key)
+	if err != nil {
+		return 0, convertMemcacheError(err)
+	}
+	if err := utils.DeserializeWith(c.codec, item.Value, value); err != nil {
+		return 0, err
+	}
+	if item.Flags == 0 {
+		return FOREVER, nil
+	}
+	return remainingTTL(time.Unix(int64(item.Flags), 0)), nil
+}
+
+// Exists (see TTLStore interface)
+//
+// Memcached has no such command: the item is read without being decoded.
+func (c *MemcachedStore) Exists(key string) (bool, error) {
+	_, err := c.Client.Get(key)
+	switch err {
+	case nil:
+		return true, nil
+	case memcache.ErrCacheMiss:
+		return false, nil
+	}
+	return false, err
+}
+
+// Touch (see TouchStore interface)
+//
+// It sends TOUCH. The flags of the item are left as they are, so GetWithTTL
+// keeps reporting the expiration the item was written with.
+func (c *MemcachedStore) Touch(key string, expires time.Duration) error {
+	switch expires {
+	case DEFAULT:
+		expires = c.defaultExpiration
+	case FOREVER:
+		expires = time.Duration(0)
+	}
+	return convertMemcacheError(c.Client.Touch(key, int32(expires/time.Second)))
+}
+
+// GetWithVersion (see CASStore interface)
+//
+// The client does not expose the CAS identifiers of memcached: the version
+// is derived from a hash of the stored value. CompareAndSwap reads the item
+// again to compare it, and writes it with the native CAS command, which
+// fails if the item was written in between.
+func (c *MemcachedStore) GetWithVersion(key string, value interface{}) (uint64, error) {
+	item, err := c.Client.Get(key)
+	if err != nil {
+		return 0, convertMemcacheError(err)
+	}
+	if err := utils.DeserializeWith(c.codec, item.Value, value); err != nil {
+		if err == ErrNegativeHit {
+			return casVersion(item.Value), err
+		}
+		return 0, err
+	}
+	return casVersion(item.Value), nil
+}
+
+// CompareAndSwap (see CASStore interface)
+func (c *MemcachedStore) CompareAndSwap(key string, value interface{}, version uint64, expires time.Duration) error {
+	item, err := c.newItem(key, value, expires)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		if err = c.Client.Add(item); err == memcache.ErrNotStored {
+			return ErrCASConflict
+		}
+		return convertMemcacheError(err)
+	}
+
+	current, err := c.Client.Get(key)
+	if err != nil {
+		return convertMemcacheError(err)
+	}
+	if casVersion(current.Value) != version {
+		return ErrCASConflict
+	}
+	current.Value, current.Flags, current.Expiration = item.Value, item.Flags, item.Expiration
+	switch err = c.Client.CompareAndSwap(current); err {
+	case memcache.ErrCASConflict:
+		return ErrCASConflict
+	case memcache.ErrNotStored:
+		// The item was deleted since it was read
+		return ErrCacheMiss
+	}
+	return convertMemcacheError(err)
+}
+
+// TryLock (see LockStore interface)
+//
+// The lock is an item created with add, holding a random token. Its ttl is
+// rounded up to the second.
+func (c *MemcachedStore) TryLock(key string, ttl time.Duration) (token string, acquired bool, err error) {
+	token, err = newLockToken()
+	if err != nil {
+		return "", false, err
+	}
+	err = c.Client.Add(&memcache.Item{Key: key, Value: []byte(token), Expiration: lockSeconds(ttl)})
+	switch err {
+	case nil:
+		return token, true, nil
+	case memcache.ErrNotStored:
+		return "", false, nil
+	}
+	return "", false, err
+}
+
+// Unlock (see LockStore interface)
+//
+// The client has no conditional delete: the lock is released by swapping it
+// for an item expiring immediately, which fails if it was acquired since.
+func (c *MemcachedStore) Unlock(key, token string) error {
+	item, err := c.Client.Get(key)
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+			return nil
+		}
+		return err
+	}
+	if string(item.Value) != token {
+		return nil
+	}
+	item.Value, item.Expiration = nil, -1
+	switch err = c.Client.CompareAndSwap(item); err {
+	case memcache.ErrCASConflict, memcache.ErrNotStored:
+		return nil
+	}
+	return err
 }
 
 // Delete (see CacheStore interface)
@@ -64,9 +251,52 @@ func (c *MemcachedStore) Flush() error {
 	return ErrNotSupport
 }
 
+// GetMulti (see CacheStore interface)
+func (c *MemcachedStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	if len(keys) != len(values) {
+		return nil, errGetMultiLength
+	}
+	items, err := c.Client.GetMulti(keys)
+	if err != nil {
+		return nil, convertMemcacheError(err)
+	}
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		item, ok := items[key]
+		if !ok {
+			continue
+		}
+		err = utils.DeserializeWith(c.codec, item.Value, values[i])
+		if err == ErrNegativeHit {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found[i] = true
+	}
+	return found, nil
+}
+
+// SetMulti (see CacheStore interface)
+//
+// Memcached has no multi-set, so items are stored one by one.
+func (c *MemcachedStore) SetMulti(items map[string]Item) error {
+	return setMulti(c, items)
+}
+
 func (c *MemcachedStore) invoke(storeFn func(*memcache.Client, *memcache.Item) error,
 	key string, value interface{}, expire time.Duration) error {
 
+	item, err := c.newItem(key, value, expire)
+	if err != nil {
+		return err
+	}
+	return convertMemcacheError(storeFn(c.Client, item))
+}
+
+// newItem returns the item storing value at key
+func (c *MemcachedStore) newItem(key string, value interface{}, expire time.Duration) (*memcache.Item, error) {
 	switch expire {
 	case DEFAULT:
 		expire = c.defaultExpiration
@@ -74,15 +304,21 @@ func (c *MemcachedStore) invoke(storeFn func(*memcache.Client, *memcache.Item) e
 		expire = time.Duration(0)
 	}
 
-	b, err := utils.Serialize(value)
+	b, err := utils.SerializeWith(c.codec, value)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return convertMemcacheError(storeFn(c.Client, &memcache.Item{
+	item := &memcache.Item{
 		Key:        key,
 		Value:      b,
 		Expiration: int32(expire / time.Second),
-	}))
+	}
+	if item.Expiration > 0 {
+		// Memcached cannot report the time to live of an item: the flags
+		// hold its expiration time for GetWithTTL
+		item.Flags = uint32(time.Now().Unix()) + uint32(item.Expiration)
+	}
+	return item, nil
 }
 
 func convertMemcacheError(err error) error {
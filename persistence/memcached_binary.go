@@ -1,10 +1,13 @@
 package persistence
 
 import (
+	"context"
+	"crypto/tls"
+	"strings"
 	"time"
 
 	"github.com/memcachier/mc"
-	"github.com/gin-contrib/cache/utils"
+	"github.com/mlsen/cache/utils"
 )
 
 // MemcachedBinaryStore represents the cache with memcached persistence using
@@ -12,22 +15,76 @@ import (
 type MemcachedBinaryStore struct {
 	*mc.Client
 	defaultExpiration time.Duration
+	codec             utils.Codec
+	tunnels           tlsTunnels
 }
 
-// NewMemcachedBinaryStore returns a MemcachedBinaryStore
-func NewMemcachedBinaryStore(hostList, username, password string, defaultExpiration time.Duration) *MemcachedBinaryStore {
-	return &MemcachedBinaryStore{mc.NewMC(hostList, username, password), defaultExpiration}
+// MemcachedBinaryOption configures optional behaviour of a
+// MemcachedBinaryStore
+type MemcachedBinaryOption func(*MemcachedBinaryStore)
+
+// WithMemcachedBinaryCodec sets the Codec used to encode values other than
+// byte slices and integers. The default is utils.GobCodec.
+func WithMemcachedBinaryCodec(codec utils.Codec) MemcachedBinaryOption {
+	return func(s *MemcachedBinaryStore) {
+		s.codec = codec
+	}
+}
+
+// NewMemcachedBinaryStore returns a MemcachedBinaryStore. If username is not
+// empty, the client authenticates with SASL PLAIN.
+func NewMemcachedBinaryStore(hostList, username, password string, defaultExpiration time.Duration, options ...MemcachedBinaryOption) *MemcachedBinaryStore {
+	return NewMemcachedBinaryStoreWithConfig(hostList, username, password, defaultExpiration, mc.DefaultConfig(), options...)
 }
 
 // NewMemcachedBinaryStoreWithConfig returns a MemcachedBinaryStore using the provided configuration
-func NewMemcachedBinaryStoreWithConfig(hostList, username, password string, defaultExpiration time.Duration, config *mc.Config) *MemcachedBinaryStore {
-	return &MemcachedBinaryStore{mc.NewMCwithConfig(hostList, username, password, config), defaultExpiration}
+func NewMemcachedBinaryStoreWithConfig(hostList, username, password string, defaultExpiration time.Duration, config *mc.Config, options ...MemcachedBinaryOption) *MemcachedBinaryStore {
+	s := &MemcachedBinaryStore{
+		Client:            mc.NewMCwithConfig(hostList, username, password, config),
+		defaultExpiration: defaultExpiration,
+		codec:             utils.GobCodec,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// NewMemcachedBinaryStoreTLS returns a MemcachedBinaryStore connecting to the
+// servers of hostList over TLS, and authenticating with SASL if username is
+// not empty, as Memcached Cloud and AWS ElastiCache require. The server name
+// is verified against the host of each server, unless tlsConfig sets one. A
+// nil config uses mc.DefaultConfig(). Call Close to release the connections.
+func NewMemcachedBinaryStoreTLS(hostList, username, password string, defaultExpiration time.Duration,
+	tlsConfig *tls.Config, config *mc.Config, options ...MemcachedBinaryOption) (*MemcachedBinaryStore, error) {
+
+	hosts := strings.FieldsFunc(hostList, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' '
+	})
+	tunnels, locals, err := openTLSTunnels(hosts, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = mc.DefaultConfig()
+	}
+	s := NewMemcachedBinaryStoreWithConfig(strings.Join(locals, ","), username, password, defaultExpiration, config, options...)
+	s.tunnels = tunnels
+	return s, nil
+}
+
+// Close (see CacheStore interface)
+//
+// It closes the connections of the store.
+func (s *MemcachedBinaryStore) Close(ctx context.Context) error {
+	s.Client.Quit()
+	return s.tunnels.Close()
 }
 
 // Set (see CacheStore interface)
 func (s *MemcachedBinaryStore) Set(key string, value interface{}, expires time.Duration) error {
 	exp := s.getExpiration(expires)
-	b, err := utils.Serialize(value)
+	b, err := utils.SerializeWith(s.codec, value)
 	if err != nil {
 		return err
 	}
@@ -38,7 +95,7 @@ func (s *MemcachedBinaryStore) Set(key string, value interface{}, expires time.D
 // Add (see CacheStore interface)
 func (s *MemcachedBinaryStore) Add(key string, value interface{}, expires time.Duration) error {
 	exp := s.getExpiration(expires)
-	b, err := utils.Serialize(value)
+	b, err := utils.SerializeWith(s.codec, value)
 	if err != nil {
 		return err
 	}
@@ -49,7 +106,7 @@ func (s *MemcachedBinaryStore) Add(key string, value interface{}, expires time.D
 // Replace (see CacheStore interface)
 func (s *MemcachedBinaryStore) Replace(key string, value interface{}, expires time.Duration) error {
 	exp := s.getExpiration(expires)
-	b, err := utils.Serialize(value)
+	b, err := utils.SerializeWith(s.codec, value)
 	if err != nil {
 		return err
 	}
@@ -63,7 +120,112 @@ func (s *MemcachedBinaryStore) Get(key string, value interface{}) error {
 	if err != nil {
 		return convertMcError(err)
 	}
-	return utils.Deserialize([]byte(val), value)
+	return utils.DeserializeWith(s.codec, []byte(val), value)
+}
+
+// Append (see RawStore interface)
+func (s *MemcachedBinaryStore) Append(key string, data []byte) error {
+	_, err := s.Client.Append(key, string(data), 0)
+	return convertRawMcError(err)
+}
+
+// Prepend (see RawStore interface)
+func (s *MemcachedBinaryStore) Prepend(key string, data []byte) error {
+	_, err := s.Client.Prepend(key, string(data), 0)
+	return convertRawMcError(err)
+}
+
+// convertRawMcError converts the errors of Append and Prepend, which report
+// missing keys as not stored or not found depending on the server
+func convertRawMcError(err error) error {
+	if err == mc.ErrNotFound {
+		return ErrNotStored
+	}
+	return convertMcError(err)
+}
+
+// Ping (see PingStore interface)
+//
+// It sends a no-op to the servers. The client has no context support: ctx
+// is ignored.
+func (s *MemcachedBinaryStore) Ping(_ context.Context) error {
+	return convertMcError(s.Client.NoOp())
+}
+
+// GetWithVersion (see CASStore interface)
+//
+// The version is the CAS identifier memcached assigns to the item.
+func (s *MemcachedBinaryStore) GetWithVersion(key string, value interface{}) (uint64, error) {
+	val, _, cas, err := s.Client.Get(key)
+	if err != nil {
+		return 0, convertMcError(err)
+	}
+	if err := utils.DeserializeWith(s.codec, []byte(val), value); err != nil {
+		if err == ErrNegativeHit {
+			return cas, err
+		}
+		return 0, err
+	}
+	return cas, nil
+}
+
+// CompareAndSwap (see CASStore interface)
+func (s *MemcachedBinaryStore) CompareAndSwap(key string, value interface{}, version uint64, expires time.Duration) error {
+	exp := s.getExpiration(expires)
+	b, err := utils.SerializeWith(s.codec, value)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		_, err = s.Client.Add(key, string(b), 0, exp)
+	} else {
+		_, err = s.Client.Set(key, string(b), 0, exp, version)
+	}
+	if err == mc.ErrKeyExists {
+		return ErrCASConflict
+	}
+	return convertMcError(err)
+}
+
+// TryLock (see LockStore interface)
+//
+// The lock is an item created with add, holding a random token. Its ttl is
+// rounded up to the second.
+func (s *MemcachedBinaryStore) TryLock(key string, ttl time.Duration) (token string, acquired bool, err error) {
+	token, err = newLockToken()
+	if err != nil {
+		return "", false, err
+	}
+	_, err = s.Client.Add(key, token, 0, uint32(lockSeconds(ttl)))
+	switch err {
+	case nil:
+		return token, true, nil
+	case mc.ErrKeyExists:
+		return "", false, nil
+	}
+	return "", false, err
+}
+
+// Unlock (see LockStore interface)
+//
+// The lock is deleted with its CAS identifier, so that it is kept if it was
+// acquired by another process since it was read.
+func (s *MemcachedBinaryStore) Unlock(key, token string) error {
+	val, _, cas, err := s.Client.Get(key)
+	if err != nil {
+		if err == mc.ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	if val != token {
+		return nil
+	}
+	switch err = s.Client.DelCAS(key, cas); err {
+	case mc.ErrKeyExists, mc.ErrNotFound:
+		return nil
+	}
+	return err
 }
 
 // Delete (see CacheStore interface)
@@ -88,6 +250,18 @@ func (s *MemcachedBinaryStore) Flush() error {
 	return convertMcError(s.Client.Flush(0))
 }
 
+// GetMulti (see CacheStore interface)
+//
+// The binary protocol client has no multi-get, so keys are fetched one by one.
+func (s *MemcachedBinaryStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	return getMulti(s, keys, values)
+}
+
+// SetMulti (see CacheStore interface)
+func (s *MemcachedBinaryStore) SetMulti(items map[string]Item) error {
+	return setMulti(s, items)
+}
+
 // getExpiration converts a gin-contrib/cache expiration in the form of a
 // time.Duration to a valid memcached expiration either in seconds (<30 days)
 // or a Unix timestamp (>30 days)
@@ -29,6 +29,14 @@ func TestMemcachedBinary_IncrDecr(t *testing.T) {
 	incrDecr(t, newMcStore)
 }
 
+func TestMemcachedBinary_CounterPresence(t *testing.T) {
+	counterPresence(t, newMcStore)
+}
+
+func TestMemcachedBinary_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newMcStore)
+}
+
 func TestMemcachedBinary_Expiration(t *testing.T) {
 	expiration(t, newMcStore)
 }
@@ -41,10 +49,22 @@ func TestMemcachedBinary_Replace(t *testing.T) {
 	testReplace(t, newMcStore)
 }
 
+func TestMemcachedBinary_AppendPrepend(t *testing.T) {
+	rawAppend(t, newMcStore(t, time.Hour).(*MemcachedBinaryStore))
+}
+
 func TestMemcachedBinary_Add(t *testing.T) {
 	testAdd(t, newMcStore)
 }
 
+func TestMemcachedBinary_Lock(t *testing.T) {
+	distributedLock(t, newMcStore(t, time.Hour))
+}
+
+func TestMemcachedBinary_CompareAndSwap(t *testing.T) {
+	compareAndSwap(t, newMcStore)
+}
+
 var newMcStoreWithConfig = func(t *testing.T, defaultExpiration time.Duration) CacheStore {
 	config := mc.DefaultConfig()
 	config.PoolSize = 2
@@ -66,6 +86,14 @@ func TestMemcachedBinaryWithConfig_IncrDecr(t *testing.T) {
 	incrDecr(t, newMcStoreWithConfig)
 }
 
+func TestMemcachedBinaryWithConfig_CounterPresence(t *testing.T) {
+	counterPresence(t, newMcStoreWithConfig)
+}
+
+func TestMemcachedBinaryWithConfig_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newMcStoreWithConfig)
+}
+
 func TestMemcachedBinaryWithConfig_Expiration(t *testing.T) {
 	expiration(t, newMcStoreWithConfig)
 }
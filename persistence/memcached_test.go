@@ -4,6 +4,8 @@ import (
 	"net"
 	"testing"
 	"time"
+
+	"github.com/mlsen/cache/utils"
 )
 
 // These tests require memcached running on localhost:11211 (the default)
@@ -29,10 +31,26 @@ func TestMemcachedCache_IncrDecr(t *testing.T) {
 	incrDecr(t, newMemcachedStore)
 }
 
+func TestMemcachedCache_CounterPresence(t *testing.T) {
+	counterPresence(t, newMemcachedStore)
+}
+
+func TestMemcachedCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newMemcachedStore)
+}
+
 func TestMemcachedCache_Expiration(t *testing.T) {
 	expiration(t, newMemcachedStore)
 }
 
+func TestMemcachedCache_Touch(t *testing.T) {
+	touchExpiration(t, newMemcachedStore(t, time.Hour).(TouchStore))
+}
+
+func TestMemcachedCache_GetSetGetDel(t *testing.T) {
+	swapValues(t, newMemcachedStore(t, time.Hour))
+}
+
 func TestMemcachedCache_EmptyCache(t *testing.T) {
 	emptyCache(t, newMemcachedStore)
 }
@@ -44,3 +62,27 @@ func TestMemcachedCache_Replace(t *testing.T) {
 func TestMemcachedCache_Add(t *testing.T) {
 	testAdd(t, newMemcachedStore)
 }
+
+func TestMemcachedCache_Lock(t *testing.T) {
+	distributedLock(t, newMemcachedStore(t, time.Hour))
+}
+
+func TestMemcachedCache_CompareAndSwap(t *testing.T) {
+	compareAndSwap(t, newMemcachedStore)
+}
+
+func TestMemcachedCache_TTL(t *testing.T) {
+	ttlInspection(t, newMemcachedStore)
+}
+
+func TestMemcachedCache_CodecOption(t *testing.T) {
+	if c := NewMemcachedStore([]string{testServer}, time.Hour); c.codec != utils.GobCodec {
+		t.Errorf("Expected gob to be the default codec")
+	}
+	if c := NewMemcachedStore([]string{testServer}, time.Hour, WithMemcachedCodec(utils.JSONCodec)); c.codec != utils.JSONCodec {
+		t.Errorf("Expected the codec of the option to be used")
+	}
+	if s := NewMemcachedBinaryStore(testServer, "", "", time.Hour, WithMemcachedBinaryCodec(utils.JSONCodec)); s.codec != utils.JSONCodec {
+		t.Errorf("Expected the codec of the option to be used")
+	}
+}
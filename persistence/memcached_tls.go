@@ -0,0 +1,157 @@
+package persistence
+
+import (
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// tlsDialTimeout bounds the TLS handshake of a tunnel with a server
+const tlsDialTimeout = 5 * time.Second
+
+// tlsTunnel forwards the plain connections opened on a loopback address to a
+// memcached server over TLS. Neither memcached client can dial TLS itself:
+// they are pointed at the tunnels instead of the servers. As both route keys
+// by the position of a server in the list, the routing is unchanged.
+type tlsTunnel struct {
+	listener net.Listener
+	addr     string
+	config   *tls.Config
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+type tlsTunnels []*tlsTunnel
+
+// openTLSTunnels opens a tunnel to each of addrs, and returns the addresses
+// to connect to in their place
+func openTLSTunnels(addrs []string, config *tls.Config) (tlsTunnels, []string, error) {
+	var tunnels tlsTunnels
+	locals := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		tunnel, err := openTLSTunnel(addr, config)
+		if err != nil {
+			tunnels.Close()
+			return nil, nil, err
+		}
+		tunnels = append(tunnels, tunnel)
+		locals = append(locals, tunnel.listener.Addr().String())
+	}
+	return tunnels, locals, nil
+}
+
+func openTLSTunnel(addr string, config *tls.Config) (*tlsTunnel, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		// The binary protocol client defaults to the standard port
+		host, addr = addr, net.JoinHostPort(addr, "11211")
+	}
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = host
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	t := &tlsTunnel{
+		listener: listener,
+		addr:     addr,
+		config:   config,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	go t.serve()
+	return t, nil
+}
+
+// Close stops the tunnels, closing the connections they forward
+func (tunnels tlsTunnels) Close() error {
+	var firstErr error
+	for _, t := range tunnels {
+		if err := t.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (t *tlsTunnel) serve() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.forward(conn)
+	}
+}
+
+func (t *tlsTunnel) forward(local net.Conn) {
+	if !t.track(local) {
+		return
+	}
+	defer t.untrack(local)
+
+	dialer := &net.Dialer{Timeout: tlsDialTimeout}
+	remote, err := tls.DialWithDialer(dialer, "tcp", t.addr, t.config)
+	if err != nil {
+		log.Printf("cache: memcached TLS connection to %s: %s", t.addr, err)
+		return
+	}
+	if !t.track(remote) {
+		return
+	}
+	defer t.untrack(remote)
+
+	// Closing both connections, once either side is done, ends the other copy
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// track registers conn to be closed with the tunnel, unless it is closed
+// already, in which case conn is closed and false returned
+func (t *tlsTunnel) track(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		conn.Close()
+		return false
+	}
+	t.conns[conn] = struct{}{}
+	return true
+}
+
+func (t *tlsTunnel) untrack(conn net.Conn) {
+	conn.Close()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, conn)
+}
+
+func (t *tlsTunnel) close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	for conn := range t.conns {
+		conn.Close()
+	}
+	return t.listener.Close()
+}
@@ -0,0 +1,71 @@
+package persistence
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTLSTestServer(t *testing.T) (*httptest.Server, *tls.Config) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("over tls"))
+	}))
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	return server, &tls.Config{RootCAs: roots}
+}
+
+// roundTrip sends a plain HTTP request to addr, and returns the status line
+// of the response, or an empty string if the connection is closed
+func roundTrip(t *testing.T, addr string) string {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Error connecting to the tunnel: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n"))
+	status, _ := bufio.NewReader(conn).ReadString('\n')
+	return strings.TrimSpace(status)
+}
+
+func TestTLSTunnel(t *testing.T) {
+	server, config := newTLSTestServer(t)
+	defer server.Close()
+
+	tunnels, locals, err := openTLSTunnels([]string{server.Listener.Addr().String()}, config)
+	if err != nil {
+		t.Fatalf("Error opening the tunnel: %s", err)
+	}
+	if status := roundTrip(t, locals[0]); status != "HTTP/1.1 200 OK" {
+		t.Errorf("Expected the request to be forwarded over TLS, got %q", status)
+	}
+
+	if err := tunnels.Close(); err != nil {
+		t.Errorf("Error closing the tunnel: %s", err)
+	}
+	if _, err := net.Dial("tcp", locals[0]); err == nil {
+		t.Error("Expected the tunnel to be closed")
+	}
+}
+
+func TestTLSTunnel_VerifiesServer(t *testing.T) {
+	server, _ := newTLSTestServer(t)
+	defer server.Close()
+
+	// The certificate of the test server is not trusted by default
+	tunnels, locals, err := openTLSTunnels([]string{server.Listener.Addr().String()}, nil)
+	if err != nil {
+		t.Fatalf("Error opening the tunnel: %s", err)
+	}
+	defer tunnels.Close()
+	if status := roundTrip(t, locals[0]); status != "" {
+		t.Errorf("Expected the connection to be refused, got %q", status)
+	}
+}
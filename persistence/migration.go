@@ -0,0 +1,281 @@
+package persistence
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// MigrationStore moves a cache from an old store to a new one, e.g. from
+// memcached to Redis, without a cold cutover. Writes go to the new store, and
+// reads fall back to the old one for the keys the new store does not hold
+// yet. Once Migrate has copied the items of the old store, or they have all
+// expired, the old store can be dropped.
+//
+// The key of an item written is deleted from the old store, so that its stale
+// copy is not read once the new item expires. Add, Replace and the counters
+// first copy the item of the old store, so that they see it.
+type MigrationStore struct {
+	from CacheStore
+	to   CacheStore
+
+	backfill bool
+}
+
+// MigrationOption configures optional behaviour of a MigrationStore
+type MigrationOption func(*MigrationStore)
+
+// WithBackfill copies the items read from the old store to the new one, so
+// that the new store warms up with the keys in use
+func WithBackfill() MigrationOption {
+	return func(c *MigrationStore) {
+		c.backfill = true
+	}
+}
+
+// NewMigrationStore returns a MigrationStore moving the items of from to to
+func NewMigrationStore(from, to CacheStore, options ...MigrationOption) *MigrationStore {
+	c := &MigrationStore{from: from, to: to}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// WithContext (see ContextBinder interface)
+func (c *MigrationStore) WithContext(ctx context.Context) CacheStore {
+	return &MigrationStore{
+		from:     BindContext(ctx, c.from),
+		to:       BindContext(ctx, c.to),
+		backfill: c.backfill,
+	}
+}
+
+// Get (see CacheStore interface)
+func (c *MigrationStore) Get(key string, value interface{}) error {
+	err := c.to.Get(key, value)
+	if err != ErrCacheMiss {
+		return err
+	}
+	ttl, err := c.readOld(key, value)
+	if err != nil || !c.backfill {
+		return err
+	}
+	if v := reflect.ValueOf(value); v.Kind() == reflect.Ptr && !v.IsNil() {
+		// A newer item may have been written meanwhile: keep it
+		c.to.Add(key, v.Elem().Interface(), ttl)
+	}
+	return nil
+}
+
+// readOld reads the item at key from the old store into value, and returns
+// its time to live, DEFAULT if the old store cannot tell it
+func (c *MigrationStore) readOld(key string, value interface{}) (time.Duration, error) {
+	if ttlStore, ok := c.from.(TTLStore); ok {
+		return ttlStore.GetWithTTL(key, value)
+	}
+	return DEFAULT, c.from.Get(key, value)
+}
+
+// Set (see CacheStore interface)
+func (c *MigrationStore) Set(key string, value interface{}, expires time.Duration) error {
+	if err := c.to.Set(key, value, expires); err != nil {
+		return err
+	}
+	return c.forgetOld(key)
+}
+
+// Add (see CacheStore interface)
+func (c *MigrationStore) Add(key string, value interface{}, expires time.Duration) error {
+	if _, err := c.copyItem(key); err != nil {
+		return err
+	}
+	if err := c.to.Add(key, value, expires); err != nil {
+		return err
+	}
+	return c.forgetOld(key)
+}
+
+// Replace (see CacheStore interface)
+func (c *MigrationStore) Replace(key string, value interface{}, expires time.Duration) error {
+	if _, err := c.copyItem(key); err != nil {
+		return err
+	}
+	if err := c.to.Replace(key, value, expires); err != nil {
+		return err
+	}
+	return c.forgetOld(key)
+}
+
+// Delete (see CacheStore interface)
+//
+// It returns ErrCacheMiss if neither store held key.
+func (c *MigrationStore) Delete(key string) error {
+	errTo := c.to.Delete(key)
+	errFrom := c.from.Delete(key)
+	if errTo != nil && errTo != ErrCacheMiss {
+		return errTo
+	}
+	if errFrom != nil && errFrom != ErrCacheMiss {
+		return errFrom
+	}
+	if errTo == ErrCacheMiss && errFrom == ErrCacheMiss {
+		return ErrCacheMiss
+	}
+	return nil
+}
+
+// Increment (see CacheStore interface)
+func (c *MigrationStore) Increment(key string, delta uint64) (uint64, error) {
+	if _, err := c.copyItem(key); err != nil {
+		return 0, err
+	}
+	n, err := c.to.Increment(key, delta)
+	if err != nil {
+		return 0, err
+	}
+	return n, c.forgetOld(key)
+}
+
+// Decrement (see CacheStore interface)
+func (c *MigrationStore) Decrement(key string, delta uint64) (uint64, error) {
+	if _, err := c.copyItem(key); err != nil {
+		return 0, err
+	}
+	n, err := c.to.Decrement(key, delta)
+	if err != nil {
+		return 0, err
+	}
+	return n, c.forgetOld(key)
+}
+
+// Flush (see CacheStore interface)
+func (c *MigrationStore) Flush() error {
+	if err := c.to.Flush(); err != nil {
+		return err
+	}
+	return c.from.Flush()
+}
+
+// Close (see CacheStore interface)
+//
+// It closes both the store migrated from and the one migrated to.
+func (c *MigrationStore) Close(ctx context.Context) error {
+	return closeAll(ctx, c.from, c.to)
+}
+
+// GetMulti (see CacheStore interface)
+func (c *MigrationStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	return getMulti(c, keys, values)
+}
+
+// SetMulti (see CacheStore interface)
+func (c *MigrationStore) SetMulti(items map[string]Item) error {
+	return setMulti(c, items)
+}
+
+// forgetOld deletes key from the old store once written to the new one
+func (c *MigrationStore) forgetOld(key string) error {
+	if err := c.from.Delete(key); err != nil && err != ErrCacheMiss {
+		return err
+	}
+	return nil
+}
+
+// copyItem copies the item at key from the old store to the new one, unless
+// the new store holds one already, and reports whether it did. Items are
+// copied as read into an interface{}, which keeps their type in stores
+// holding values in memory. Items the codec of the old store cannot decode
+// into an interface{} are copied as the bytes it holds, which the new store
+// reads back the same if it uses the same codec.
+func (c *MigrationStore) copyItem(key string) (bool, error) {
+	var value interface{}
+	ttl, err := c.readOld(key, &value)
+	if err != nil && err != ErrCacheMiss && err != ErrNegativeHit {
+		var raw []byte
+		if ttl, err = c.readOld(key, &raw); err == nil {
+			value = raw
+		}
+	}
+	switch err {
+	case nil:
+	case ErrCacheMiss, ErrNegativeHit:
+		return false, nil
+	default:
+		return false, err
+	}
+	switch err := c.to.Add(key, value, ttl); err {
+	case nil:
+		return true, nil
+	case ErrNotStored:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// Migrate copies the items of the old store to the new one, batchSize at a
+// time, skipping the keys the new store holds already. It returns the number
+// of items copied, and stops early with the error of ctx if it is done. It
+// returns ErrNotSupport if the old store is not an IterableStore.
+//
+// Items keep their time to live if the old store is a TTLStore, and get the
+// default expiration of the new store otherwise.
+func (c *MigrationStore) Migrate(ctx context.Context, batchSize int) (int, error) {
+	iterable, ok := c.from.(IterableStore)
+	if !ok {
+		return 0, ErrNotSupport
+	}
+	keys, err := iterable.Keys("")
+	if err != nil {
+		return 0, err
+	}
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	copied := 0
+	for start := 0; start < len(keys); start += batchSize {
+		if err := ctx.Err(); err != nil {
+			return copied, err
+		}
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		n, err := c.copyBatch(keys[start:end])
+		copied += n
+		if err != nil {
+			return copied, err
+		}
+	}
+	return copied, nil
+}
+
+// copyBatch copies the items at keys concurrently, and returns the number
+// of items copied and the first error
+func (c *MigrationStore) copyBatch(keys []string) (int, error) {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		copied   int
+		firstErr error
+	)
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			ok, err := c.copyItem(key)
+			mu.Lock()
+			defer mu.Unlock()
+			if ok {
+				copied++
+			}
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(key)
+	}
+	wg.Wait()
+	return copied, firstErr
+}
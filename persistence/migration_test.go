@@ -0,0 +1,99 @@
+package persistence
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMigrationStore(t *testing.T) {
+	from := NewInMemoryStore(time.Hour)
+	to := NewInMemoryStore(time.Hour)
+	store := NewMigrationStore(from, to, WithBackfill())
+
+	from.Set("old", "old value", time.Minute)
+	from.Set("counter", uint64(5), DEFAULT)
+	from.Set("stale", "stale value", DEFAULT)
+
+	var value string
+	if err := store.Get("old", &value); err != nil || value != "old value" {
+		t.Fatalf("Expected the old value, got %q: %v", value, err)
+	}
+	if ttl, err := to.GetWithTTL("old", &value); err != nil || ttl > time.Minute {
+		t.Errorf("Expected the old value to be backfilled with its TTL, got %v: %v", ttl, err)
+	}
+
+	if n, err := store.Increment("counter", 2); err != nil || n != 7 {
+		t.Errorf("Expected the old counter to be incremented, got %d: %v", n, err)
+	}
+	if err := store.Add("stale", "new value", DEFAULT); err != ErrNotStored {
+		t.Errorf("Expected Add to see the old item, got: %v", err)
+	}
+
+	store.Set("stale", "new value", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if err := store.Get("stale", &value); err != ErrCacheMiss {
+		t.Errorf("Expected the old copy of a written item to be deleted, got %q: %v", value, err)
+	}
+
+	if err := store.Delete("old"); err != nil {
+		t.Errorf("Error deleting an item: %v", err)
+	}
+	if err := store.Delete("old"); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss deleting a missing item, got: %v", err)
+	}
+}
+
+func TestMigrationStore_Migrate(t *testing.T) {
+	from, err := NewRedisCache(&ClientOptions{Addrs: []string{redisTestServer}, DB: 2}, time.Hour)
+	if err != nil {
+		t.Fatalf("Error connecting to redis: %s", err)
+	}
+	to, err := NewRedisCache(&ClientOptions{Addrs: []string{redisTestServer}, DB: 3}, time.Hour)
+	if err != nil {
+		t.Fatalf("Error connecting to redis: %s", err)
+	}
+	from.Flush()
+	to.Flush()
+	defer from.Flush()
+	defer to.Flush()
+
+	type page struct {
+		Status int
+		Body   []byte
+	}
+	for i := 0; i < 25; i++ {
+		from.Set("page"+strconv.Itoa(i), page{Status: 200, Body: []byte("body")}, time.Minute)
+	}
+	to.Set("page0", page{Status: 404}, time.Minute)
+
+	store := NewMigrationStore(from, to)
+	copied, err := store.Migrate(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+	if copied != 24 {
+		t.Errorf("Expected 24 items copied, got %d", copied)
+	}
+
+	var p page
+	if err := to.Get("page7", &p); err != nil || p.Status != 200 || string(p.Body) != "body" {
+		t.Errorf("Expected the page to be copied, got %+v: %v", p, err)
+	}
+	if ttl, err := to.GetWithTTL("page7", &p); err != nil || ttl > time.Minute {
+		t.Errorf("Expected the page to keep its TTL, got %v: %v", ttl, err)
+	}
+	if err := to.Get("page0", &p); err != nil || p.Status != 404 {
+		t.Errorf("Expected the item of the new store to be kept, got %+v: %v", p, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := store.Migrate(ctx, 10); err != context.Canceled {
+		t.Errorf("Expected the migration to stop once canceled, got: %v", err)
+	}
+	if _, err := NewMigrationStore(struct{ CacheStore }{from}, to).Migrate(context.Background(), 10); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport for a store without keys, got: %v", err)
+	}
+}
@@ -0,0 +1,206 @@
+package persistence
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// NamespacedStore is a CacheStore keeping its keys under a namespace in the
+// store it wraps, so that several applications can share a RedisStore or a
+// MemcachedStore without their keys colliding. Tags are namespaced too.
+type NamespacedStore struct {
+	store     CacheStore
+	namespace string
+}
+
+// NewNamespacedStore returns a NamespacedStore prefixing the keys stored in
+// store with namespace, e.g. "app1:"
+func NewNamespacedStore(store CacheStore, namespace string) *NamespacedStore {
+	return &NamespacedStore{store: store, namespace: namespace}
+}
+
+// WithContext (see ContextBinder interface)
+func (s *NamespacedStore) WithContext(ctx context.Context) CacheStore {
+	return &NamespacedStore{store: BindContext(ctx, s.store), namespace: s.namespace}
+}
+
+// Get (see CacheStore interface)
+func (s *NamespacedStore) Get(key string, value interface{}) error {
+	return s.store.Get(s.namespace+key, value)
+}
+
+// Set (see CacheStore interface)
+func (s *NamespacedStore) Set(key string, value interface{}, expires time.Duration) error {
+	return s.store.Set(s.namespace+key, value, expires)
+}
+
+// Add (see CacheStore interface)
+func (s *NamespacedStore) Add(key string, value interface{}, expires time.Duration) error {
+	return s.store.Add(s.namespace+key, value, expires)
+}
+
+// Replace (see CacheStore interface)
+func (s *NamespacedStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return s.store.Replace(s.namespace+key, value, expires)
+}
+
+// Delete (see CacheStore interface)
+func (s *NamespacedStore) Delete(key string) error {
+	return s.store.Delete(s.namespace + key)
+}
+
+// Increment (see CacheStore interface)
+func (s *NamespacedStore) Increment(key string, delta uint64) (uint64, error) {
+	return s.store.Increment(s.namespace+key, delta)
+}
+
+// Decrement (see CacheStore interface)
+func (s *NamespacedStore) Decrement(key string, delta uint64) (uint64, error) {
+	return s.store.Decrement(s.namespace+key, delta)
+}
+
+// Flush (see CacheStore interface)
+//
+// Only the keys of the namespace are deleted if the wrapped store is a
+// PrefixStore. Otherwise, the whole store is flushed.
+func (s *NamespacedStore) Flush() error {
+	if prefixStore, ok := s.store.(PrefixStore); ok {
+		return prefixStore.DeletePrefix(s.namespace)
+	}
+	return s.store.Flush()
+}
+
+// Close (see CacheStore interface)
+func (s *NamespacedStore) Close(ctx context.Context) error {
+	return s.store.Close(ctx)
+}
+
+// GetMulti (see CacheStore interface)
+func (s *NamespacedStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	namespaced := make([]string, len(keys))
+	for i, key := range keys {
+		namespaced[i] = s.namespace + key
+	}
+	return s.store.GetMulti(namespaced, values)
+}
+
+// SetMulti (see CacheStore interface)
+func (s *NamespacedStore) SetMulti(items map[string]Item) error {
+	namespaced := make(map[string]Item, len(items))
+	for key, item := range items {
+		namespaced[s.namespace+key] = item
+	}
+	return s.store.SetMulti(namespaced)
+}
+
+// DeletePrefix (see PrefixStore interface)
+//
+// It returns ErrNotSupport if the wrapped store is not a PrefixStore.
+func (s *NamespacedStore) DeletePrefix(prefix string) error {
+	prefixStore, ok := s.store.(PrefixStore)
+	if !ok {
+		return ErrNotSupport
+	}
+	return prefixStore.DeletePrefix(s.namespace + prefix)
+}
+
+// Keys (see IterableStore interface)
+//
+// It returns ErrNotSupport if the wrapped store is not an IterableStore.
+func (s *NamespacedStore) Keys(prefix string) ([]string, error) {
+	return collectKeys(s, prefix)
+}
+
+// Iterate (see IterableStore interface)
+//
+// It returns ErrNotSupport if the wrapped store is not an IterableStore.
+// Keys are listed without the namespace.
+func (s *NamespacedStore) Iterate(prefix string, fn func(key string, meta Meta) bool) error {
+	iterable, ok := s.store.(IterableStore)
+	if !ok {
+		return ErrNotSupport
+	}
+	return iterable.Iterate(s.namespace+prefix, func(key string, meta Meta) bool {
+		return fn(strings.TrimPrefix(key, s.namespace), meta)
+	})
+}
+
+// InvalidatePattern (see PatternStore interface)
+//
+// It returns ErrNotSupport if the wrapped store is not a PatternStore.
+func (s *NamespacedStore) InvalidatePattern(pattern string) error {
+	patternStore, ok := s.store.(PatternStore)
+	if !ok {
+		return ErrNotSupport
+	}
+	return patternStore.InvalidatePattern(globEscaper.Replace(s.namespace) + pattern)
+}
+
+// Touch (see TouchStore interface)
+//
+// It returns ErrNotSupport if the wrapped store is not a TouchStore.
+func (s *NamespacedStore) Touch(key string, expires time.Duration) error {
+	return Touch(s.store, s.namespace+key, expires)
+}
+
+// GetSet (see SwapStore interface)
+//
+// Like the package function, it is emulated if the wrapped store is not a
+// SwapStore.
+func (s *NamespacedStore) GetSet(key string, value interface{}, expires time.Duration, old interface{}) error {
+	return GetSet(s.store, s.namespace+key, value, expires, old)
+}
+
+// GetDel (see SwapStore interface)
+//
+// Like the package function, it is emulated if the wrapped store is not a
+// SwapStore.
+func (s *NamespacedStore) GetDel(key string, value interface{}) error {
+	return GetDel(s.store, s.namespace+key, value)
+}
+
+// Append (see RawStore interface)
+//
+// It returns ErrNotSupport if the wrapped store is not a RawStore.
+func (s *NamespacedStore) Append(key string, data []byte) error {
+	return Append(s.store, s.namespace+key, data)
+}
+
+// Prepend (see RawStore interface)
+//
+// It returns ErrNotSupport if the wrapped store is not a RawStore.
+func (s *NamespacedStore) Prepend(key string, data []byte) error {
+	return Prepend(s.store, s.namespace+key, data)
+}
+
+// Ping (see PingStore interface)
+func (s *NamespacedStore) Ping(ctx context.Context) error {
+	return Ping(ctx, s.store)
+}
+
+// Tag (see TagStore interface)
+//
+// It returns ErrNotSupport if the wrapped store is not a TagStore.
+func (s *NamespacedStore) Tag(key string, tags ...string) error {
+	tagStore, ok := s.store.(TagStore)
+	if !ok {
+		return ErrNotSupport
+	}
+	namespaced := make([]string, len(tags))
+	for i, tag := range tags {
+		namespaced[i] = s.namespace + tag
+	}
+	return tagStore.Tag(s.namespace+key, namespaced...)
+}
+
+// InvalidateTag (see TagStore interface)
+//
+// It returns ErrNotSupport if the wrapped store is not a TagStore.
+func (s *NamespacedStore) InvalidateTag(tag string) error {
+	tagStore, ok := s.store.(TagStore)
+	if !ok {
+		return ErrNotSupport
+	}
+	return tagStore.InvalidateTag(s.namespace + tag)
+}
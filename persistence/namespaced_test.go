@@ -0,0 +1,85 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+var newNamespacedStore = func(t *testing.T, defaultExpiration time.Duration) CacheStore {
+	return NewNamespacedStore(NewInMemoryStore(defaultExpiration), "app:")
+}
+
+// Test typical cache interactions
+func TestNamespacedCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newNamespacedStore)
+}
+
+func TestNamespacedCache_IncrDecr(t *testing.T) {
+	incrDecr(t, newNamespacedStore)
+}
+
+func TestNamespacedCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newNamespacedStore)
+}
+
+func TestNamespacedCache_Tags(t *testing.T) {
+	tagInvalidation(t, NewNamespacedStore(NewInMemoryStore(time.Hour), "app:"))
+}
+
+func TestNamespacedCache_DeletePrefix(t *testing.T) {
+	prefixDeletion(t, NewNamespacedStore(newRedisStore(t, time.Hour), "app:"))
+}
+
+func TestNamespacedCache_InvalidatePattern(t *testing.T) {
+	patternInvalidation(t, NewNamespacedStore(NewInMemoryStore(time.Hour), "app[1]:"))
+}
+
+func TestNamespacedCache_Iterate(t *testing.T) {
+	keyIteration(t, NewNamespacedStore(NewInMemoryStore(time.Hour), "app:"))
+
+	if _, err := Keys(NewNamespacedStore(NewSlabStore(time.Hour, 1<<20, 1), "app:"), ""); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport, got: %v", err)
+	}
+}
+
+func TestNamespacedCache_Touch(t *testing.T) {
+	touchExpiration(t, NewNamespacedStore(NewInMemoryStore(time.Hour), "app:"))
+
+	if err := Touch(NewNamespacedStore(NewSlabStore(time.Hour, 1<<20, 1), "app:"), "key", time.Hour); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport touching an item of a store without Touch, got: %v", err)
+	}
+}
+
+func TestNamespacedCache_GetSetGetDel(t *testing.T) {
+	swapValues(t, NewNamespacedStore(NewInMemoryStore(time.Hour), "app:"))
+}
+
+func TestNamespacedCache_AppendPrepend(t *testing.T) {
+	rawAppend(t, NewNamespacedStore(NewInMemoryStore(time.Hour), "app:"))
+}
+
+func TestNamespacedCache_SharedRedis(t *testing.T) {
+	redisCache := newRedisStore(t, time.Hour)
+	app1 := NewNamespacedStore(redisCache, "app1:")
+	app2 := NewNamespacedStore(redisCache, "app2:")
+
+	app1.Set("key", "one", DEFAULT)
+	app2.Set("key", "two", DEFAULT)
+	var s string
+	if err := app1.Get("key", &s); err != nil || s != "one" {
+		t.Errorf("Expected one, got %q (%v)", s, err)
+	}
+
+	if err := app1.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+	if err := app1.Get("key", &s); err != ErrCacheMiss {
+		t.Errorf("Expected app1 to be flushed, got %v", err)
+	}
+	if err := app2.Get("key", &s); err != nil || s != "two" {
+		t.Errorf("Expected app2 to be kept, got %q (%v)", s, err)
+	}
+	if err := redisCache.Get("app2:key", &s); err != nil {
+		t.Errorf("Expected the key to be stored under its namespace, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package persistence
+
+// PatternStore is implemented by stores able to delete every key matching a
+// glob pattern, e.g. all the cached pages of "/api/v1/products/*"
+type PatternStore interface {
+	CacheStore
+
+	// InvalidatePattern deletes every key matching pattern, with the syntax
+	// of Redis patterns: * matches any sequence of characters, slashes
+	// included, ? any single character, [abc] and [a-z] one of a set of
+	// characters, [^a] any character but those, and \ escapes the character
+	// following it.
+	InvalidatePattern(pattern string) error
+}
+
+// QuoteGlob escapes the characters of s that PatternStore interprets, so that
+// the pattern only matches s itself
+func QuoteGlob(s string) string {
+	return globEscaper.Replace(s)
+}
+
+// matchGlob reports whether key matches pattern, the way Redis matches keys
+// against the pattern of SCAN MATCH. Characters are compared byte by byte.
+func matchGlob(pattern, key string) bool {
+	// On a mismatch, the last * seen so far absorbs one more byte of key and
+	// matching resumes after it: earlier stars need not be revisited
+	starP, starK := -1, 0
+	p, k := 0, 0
+	for k < len(key) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				starP, starK = p, k
+				p++
+				continue
+			case '?':
+				p++
+				k++
+				continue
+			case '[':
+				if next, ok := matchClass(pattern, p, key[k]); ok {
+					p = next
+					k++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) && pattern[p+1] == key[k] {
+					p += 2
+					k++
+					continue
+				}
+			default:
+				if pattern[p] == key[k] {
+					p++
+					k++
+					continue
+				}
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		starK++
+		p, k = starP+1, starK
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass reports whether b matches the character class starting at
+// pattern[start], a '[', and returns the index following the class. An
+// unterminated class extends to the end of the pattern, as in Redis.
+func matchClass(pattern string, start int, b byte) (next int, ok bool) {
+	p := start + 1
+	negate := p < len(pattern) && pattern[p] == '^'
+	if negate {
+		p++
+	}
+	matched := false
+	for ; p < len(pattern) && pattern[p] != ']'; p++ {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			if pattern[p] == b {
+				matched = true
+			}
+		case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+			lo, hi := pattern[p], pattern[p+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if lo <= b && b <= hi {
+				matched = true
+			}
+			p += 2
+		case pattern[p] == b:
+			matched = true
+		}
+	}
+	if p < len(pattern) {
+		p++
+	}
+	return p, matched != negate
+}
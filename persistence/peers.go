@@ -0,0 +1,445 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mlsen/cache/utils"
+)
+
+// DefaultPeerBasePath is the path under which a PeerStore serves its peers,
+// unless set with WithPeerBasePath
+const DefaultPeerBasePath = "/_cache/peers/"
+
+// peerReplicas is the number of points of each peer on the hash ring, which
+// evens out the share of keys each peer owns
+const peerReplicas = 64
+
+var errPeerResponse = errors.New("cache: unexpected response from peer.")
+
+// PeerStore is a CacheStore shared by a fleet of instances without a central
+// cache server, in the manner of groupcache: each key is owned by one of the
+// peers, picked by consistent hashing, which keeps it in its local store.
+// Operations on keys owned by another peer are sent to it over HTTP, and
+// served by its PeerStore's ServeHTTP, to be mounted at the base path on every
+// instance (see cache.MountPeers for gin).
+//
+// Values are serialized before they are stored, in the local store of their
+// owner. Counters are updated by their owner under a lock, so they are
+// consistent across the fleet. When the peers change, keys whose owner changes
+// are missed until they are stored again.
+//
+// Peers trust each other: the base path must not be reachable by clients.
+type PeerStore struct {
+	self     string
+	local    CacheStore
+	client   *http.Client
+	basePath string
+	ctx      context.Context
+
+	ring *peerRing
+	// counters serializes the counter updates of the keys this peer owns
+	counters *sync.Mutex
+}
+
+// PeerOption configures a PeerStore
+type PeerOption func(*PeerStore)
+
+// WithPeerBasePath sets the path under which peers are served, the same on
+// every peer. It defaults to DefaultPeerBasePath.
+func WithPeerBasePath(basePath string) PeerOption {
+	return func(s *PeerStore) {
+		s.basePath = basePath
+	}
+}
+
+// WithPeerClient sets the client requests to peers are sent with. It defaults
+// to a client with a timeout of 5 seconds.
+func WithPeerClient(client *http.Client) PeerOption {
+	return func(s *PeerStore) {
+		s.client = client
+	}
+}
+
+// NewPeerStore returns a PeerStore for the peer reachable at self, a base URL
+// such as "http://10.0.0.1:8080", keeping the keys it owns in local. Until
+// SetPeers is called, it owns every key.
+func NewPeerStore(self string, local CacheStore, opts ...PeerOption) *PeerStore {
+	s := &PeerStore{
+		self:     self,
+		local:    local,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		basePath: DefaultPeerBasePath,
+		ctx:      context.Background(),
+		ring:     &peerRing{},
+		counters: &sync.Mutex{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.ring.set([]string{self})
+	return s
+}
+
+// SetPeers replaces the peers sharing the cache, given by their base URLs.
+// self is added if missing. Every peer must be given the same list.
+func (s *PeerStore) SetPeers(peers ...string) {
+	s.ring.set(append(append([]string(nil), peers...), s.self))
+}
+
+// BasePath returns the path under which peers are served
+func (s *PeerStore) BasePath() string {
+	return s.basePath
+}
+
+// WithContext (see ContextBinder interface)
+//
+// The context bounds the requests to peers, and is passed to the local store.
+func (s *PeerStore) WithContext(ctx context.Context) CacheStore {
+	bound := *s
+	bound.ctx = ctx
+	bound.local = BindContext(ctx, s.local)
+	return &bound
+}
+
+// Get (see CacheStore interface)
+func (s *PeerStore) Get(key string, value interface{}) error {
+	var data []byte
+	if peer := s.owner(key); peer != "" {
+		body, err := s.request(peer, "GET", key, nil, nil)
+		if err != nil {
+			return err
+		}
+		data = body
+	} else if err := s.local.Get(key, &data); err != nil {
+		return err
+	}
+	return utils.Deserialize(data, value)
+}
+
+// Set (see CacheStore interface)
+func (s *PeerStore) Set(key string, value interface{}, expires time.Duration) error {
+	return s.write("set", key, value, expires)
+}
+
+// Add (see CacheStore interface)
+func (s *PeerStore) Add(key string, value interface{}, expires time.Duration) error {
+	return s.write("add", key, value, expires)
+}
+
+// Replace (see CacheStore interface)
+func (s *PeerStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return s.write("replace", key, value, expires)
+}
+
+// Delete (see CacheStore interface)
+func (s *PeerStore) Delete(key string) error {
+	if peer := s.owner(key); peer != "" {
+		_, err := s.request(peer, "DELETE", key, nil, nil)
+		return err
+	}
+	return s.local.Delete(key)
+}
+
+// Increment (see CacheStore interface)
+//
+// Like InMemoryStore, the counter wraps around on overflow.
+func (s *PeerStore) Increment(key string, delta uint64) (uint64, error) {
+	return s.count("incr", key, delta)
+}
+
+// Decrement (see CacheStore interface)
+//
+// Like InMemoryStore, the counter stops at 0.
+func (s *PeerStore) Decrement(key string, delta uint64) (uint64, error) {
+	return s.count("decr", key, delta)
+}
+
+// Flush (see CacheStore interface)
+//
+// Flush flushes the local store of every peer.
+func (s *PeerStore) Flush() error {
+	for _, peer := range s.ring.list() {
+		var err error
+		if peer == s.self {
+			err = s.local.Flush()
+		} else {
+			_, err = s.request(peer, "DELETE", "", nil, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close (see CacheStore interface)
+//
+// It closes the local store. The other peers are left running.
+func (s *PeerStore) Close(ctx context.Context) error {
+	return s.local.Close(ctx)
+}
+
+// GetMulti (see CacheStore interface)
+func (s *PeerStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	return getMulti(s, keys, values)
+}
+
+// SetMulti (see CacheStore interface)
+func (s *PeerStore) SetMulti(items map[string]Item) error {
+	return setMulti(s, items)
+}
+
+// ServeHTTP serves the operations of the other peers on the keys this peer
+// owns, under the base path
+func (s *PeerStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, s.basePath) {
+		http.NotFound(w, r)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, s.basePath)
+	local := BindContext(r.Context(), s.local)
+	query := r.URL.Query()
+
+	var (
+		body []byte
+		err  error
+	)
+	switch {
+	case r.Method == "GET":
+		err = local.Get(key, &body)
+	case r.Method == "PUT":
+		var expires int64
+		if expires, err = strconv.ParseInt(query.Get("expires"), 10, 64); err != nil {
+			break
+		}
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			break
+		}
+		err = s.writeLocal(local, query.Get("op"), key, body, time.Duration(expires))
+		body = nil
+	case r.Method == "DELETE" && key == "":
+		err = local.Flush()
+	case r.Method == "DELETE":
+		err = local.Delete(key)
+	case r.Method == "POST":
+		var delta, n uint64
+		if delta, err = strconv.ParseUint(query.Get("delta"), 10, 64); err != nil {
+			break
+		}
+		if n, err = s.countLocal(local, query.Get("op"), key, delta); err == nil {
+			body = []byte(strconv.FormatUint(n, 10))
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch err {
+	case nil:
+		w.Write(body)
+	case ErrCacheMiss:
+		w.WriteHeader(http.StatusNotFound)
+	case ErrNegativeHit:
+		w.WriteHeader(http.StatusGone)
+	case ErrNotStored:
+		w.WriteHeader(http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// owner returns the peer owning key, or an empty string if it is this peer
+func (s *PeerStore) owner(key string) string {
+	if peer := s.ring.get(key); peer != s.self {
+		return peer
+	}
+	return ""
+}
+
+func (s *PeerStore) write(op, key string, value interface{}, expires time.Duration) error {
+	data, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	if peer := s.owner(key); peer != "" {
+		query := url.Values{"op": {op}, "expires": {strconv.FormatInt(int64(expires), 10)}}
+		_, err := s.request(peer, "PUT", key, query, data)
+		return err
+	}
+	return s.writeLocal(s.local, op, key, data, expires)
+}
+
+func (s *PeerStore) writeLocal(local CacheStore, op, key string, data []byte, expires time.Duration) error {
+	switch op {
+	case "set":
+		return local.Set(key, data, expires)
+	case "add":
+		return local.Add(key, data, expires)
+	case "replace":
+		return local.Replace(key, data, expires)
+	}
+	return fmt.Errorf("cache: unknown peer write operation %q", op)
+}
+
+func (s *PeerStore) count(op, key string, delta uint64) (uint64, error) {
+	if peer := s.owner(key); peer != "" {
+		query := url.Values{"op": {op}, "delta": {strconv.FormatUint(delta, 10)}}
+		body, err := s.request(peer, "POST", key, query, nil)
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseUint(string(body), 10, 64)
+	}
+	return s.countLocal(s.local, op, key, delta)
+}
+
+// countLocal updates the counter at key in local. The counter keeps its
+// expiration if local is a TTLStore, and gets the default one otherwise.
+func (s *PeerStore) countLocal(local CacheStore, op, key string, delta uint64) (uint64, error) {
+	s.counters.Lock()
+	defer s.counters.Unlock()
+
+	var data []byte
+	expires := DEFAULT
+	var err error
+	if ttlStore, ok := local.(TTLStore); ok {
+		expires, err = ttlStore.GetWithTTL(key, &data)
+	} else {
+		err = local.Get(key, &data)
+	}
+	if err != nil {
+		return 0, err
+	}
+	var value uint64
+	if err := utils.Deserialize(data, &value); err != nil {
+		return 0, err
+	}
+	switch {
+	case op == "incr":
+		value += delta
+	case delta > value:
+		value = 0
+	default:
+		value -= delta
+	}
+	data, _ = utils.Serialize(value)
+	return value, local.Set(key, data, expires)
+}
+
+// request sends an operation on key to peer, and returns the body of the
+// response
+func (s *PeerStore) request(peer, method, key string, query url.Values, body []byte) ([]byte, error) {
+	u := strings.TrimSuffix(peer, "/") + s.basePath + url.PathEscape(key)
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req.WithContext(s.ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return data, nil
+	case http.StatusNotFound:
+		return nil, ErrCacheMiss
+	case http.StatusGone:
+		return nil, ErrNegativeHit
+	case http.StatusConflict:
+		return nil, ErrNotStored
+	}
+	return nil, fmt.Errorf("%w %s: %s %s", errPeerResponse, peer, resp.Status, strings.TrimSpace(string(data)))
+}
+
+// peerRing maps keys to peers by consistent hashing
+type peerRing struct {
+	// replicas is the number of points of each peer, peerReplicas if 0
+	replicas int
+
+	mu     sync.RWMutex
+	hashes []uint64
+	peers  map[uint64]string
+	all    []string
+}
+
+func (r *peerRing) set(peers []string) {
+	replicas := r.replicas
+	if replicas <= 0 {
+		replicas = peerReplicas
+	}
+	hashes := make([]uint64, 0, len(peers)*replicas)
+	owners := make(map[uint64]string, len(peers)*replicas)
+	var all []string
+	seen := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		if seen[peer] {
+			continue
+		}
+		seen[peer] = true
+		all = append(all, peer)
+		for i := 0; i < replicas; i++ {
+			hash := ringHash(strconv.Itoa(i) + peer)
+			hashes = append(hashes, hash)
+			owners[hash] = peer
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hashes, r.peers, r.all = hashes, owners, all
+}
+
+// get returns the peer owning key: the first point of the ring at or after
+// the hash of key, or "" if the ring is empty
+func (r *peerRing) get(key string) string {
+	hash := ringHash(key)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.peers[r.hashes[i]]
+}
+
+func (r *peerRing) list() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.all
+}
+
+// ringHash hashes s with FNV-1a, then mixes the bits with the finalizer of
+// MurmurHash3: FNV alone places the points of similar peer addresses close
+// to each other on the ring
+func ringHash(s string) uint64 {
+	h := fnv64a(s)
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,119 @@
+package persistence
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newPeerStores returns n PeerStores serving each other, keeping their keys in
+// InMemoryStores
+func newPeerStores(t *testing.T, n int, defaultExpiration time.Duration) []*PeerStore {
+	stores := make([]*PeerStore, n)
+	urls := make([]string, n)
+	for i := range stores {
+		var store *PeerStore
+		server := httptest.NewServer(nil)
+		t.Cleanup(server.Close)
+		store = NewPeerStore(server.URL, NewInMemoryStore(defaultExpiration))
+		server.Config.Handler = store
+		stores[i], urls[i] = store, server.URL
+	}
+	for _, store := range stores {
+		store.SetPeers(urls...)
+	}
+	return stores
+}
+
+var newPeerStore = func(t *testing.T, defaultExpiration time.Duration) CacheStore {
+	return newPeerStores(t, 3, defaultExpiration)[0]
+}
+
+// Test typical cache interactions
+func TestPeerCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newPeerStore)
+}
+
+func TestPeerCache_IncrDecr(t *testing.T) {
+	incrDecr(t, newPeerStore)
+}
+
+func TestPeerCache_CounterPresence(t *testing.T) {
+	counterPresence(t, newPeerStore)
+}
+
+func TestPeerCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newPeerStore)
+}
+
+func TestPeerCache_Expiration(t *testing.T) {
+	expiration(t, newPeerStore)
+}
+
+func TestPeerCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newPeerStore)
+}
+
+func TestPeerCache_Replace(t *testing.T) {
+	testReplace(t, newPeerStore)
+}
+
+func TestPeerCache_Add(t *testing.T) {
+	testAdd(t, newPeerStore)
+}
+
+func TestPeerCache_NegativeCaching(t *testing.T) {
+	negativeCaching(t, newPeerStore)
+}
+
+func TestPeerCache_Distribution(t *testing.T) {
+	stores := newPeerStores(t, 3, time.Hour)
+	for i := 0; i < 300; i++ {
+		if err := stores[i%3].Set(fmt.Sprint("key", i), i, DEFAULT); err != nil {
+			t.Fatalf("Error setting: %s", err)
+		}
+	}
+
+	// Every peer reads every key, and each key is kept by its owner only
+	for _, store := range stores {
+		for i := 0; i < 300; i++ {
+			var value int
+			if err := store.Get(fmt.Sprint("key", i), &value); err != nil || value != i {
+				t.Fatalf("Expected %d, got %d (%v)", i, value, err)
+			}
+		}
+	}
+	total := 0
+	for _, store := range stores {
+		entries := store.local.(*InMemoryStore).Stats().Entries
+		if entries < 50 {
+			t.Errorf("Expected keys to be spread over the peers, got %d on %s", entries, store.self)
+		}
+		total += entries
+	}
+	if total != 300 {
+		t.Errorf("Expected each key to be kept once, got %d entries", total)
+	}
+}
+
+func TestPeerRing(t *testing.T) {
+	var ring peerRing
+	ring.set([]string{"a", "b", "c", "a"})
+	if peers := ring.list(); len(peers) != 3 {
+		t.Errorf("Expected duplicate peers to be ignored, got %v", peers)
+	}
+	owners := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint(i)
+		owners[key] = ring.get(key)
+	}
+
+	// Removing a peer only moves its own keys
+	ring.set([]string{"a", "b"})
+	for key, owner := range owners {
+		if owner != "c" && ring.get(key) != owner {
+			t.Fatalf("Expected %s to stay on %s, moved to %s", key, owner, ring.get(key))
+		}
+	}
+}
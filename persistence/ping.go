@@ -0,0 +1,49 @@
+package persistence
+
+import (
+	"context"
+)
+
+// pingKey is the key read by Ping to check stores which are not a PingStore
+const pingKey = "gincontrib.cache.ping"
+
+// PingStore is implemented by stores able to check the connectivity to their
+// backend without reading or writing items
+type PingStore interface {
+	CacheStore
+
+	// Ping returns an error if the backend cannot be reached
+	Ping(ctx context.Context) error
+}
+
+// Ping checks that store can reach its backend. Stores which are not a
+// PingStore are checked by reading a key no one writes: a miss means the
+// backend answered. Ping returns ctx.Err() if ctx is done before the backend
+// answers, even for stores ignoring ctx.
+func Ping(ctx context.Context, store CacheStore) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		if pingStore, ok := store.(PingStore); ok {
+			done <- pingStore.Ping(ctx)
+			return
+		}
+		var b []byte
+		switch err := BindContext(ctx, store).Get(pingKey, &b); err {
+		case nil, ErrCacheMiss, ErrNegativeHit:
+			done <- nil
+		default:
+			done <- err
+		}
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
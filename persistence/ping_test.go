@@ -0,0 +1,45 @@
+package persistence
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// slowStore blocks reads until released, whatever their context
+type slowStore struct {
+	CacheStore
+	release chan struct{}
+}
+
+func (s slowStore) Get(key string, value interface{}) error {
+	<-s.release
+	return ErrCacheMiss
+}
+
+func TestPing(t *testing.T) {
+	ctx := context.Background()
+	for name, store := range map[string]CacheStore{
+		"inmemory":   NewInMemoryStore(time.Hour),
+		"redis":      newRedisStore(t, time.Hour),
+		"redisv9":    newRedisStoreV9(t, time.Hour),
+		"namespaced": NewNamespacedStore(newRedisStore(t, time.Hour), "app:"),
+	} {
+		if err := Ping(ctx, store); err != nil {
+			t.Errorf("Error pinging the %s store: %s", name, err)
+		}
+	}
+
+	backend := &flakyStore{CacheStore: NewInMemoryStore(time.Hour), down: 1}
+	if err := Ping(ctx, backend); err != errBackendDown {
+		t.Errorf("Expected the error of the backend, got: %v", err)
+	}
+
+	slow := slowStore{NewInMemoryStore(time.Hour), make(chan struct{})}
+	defer close(slow.release)
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := Ping(ctx, slow); err != context.DeadlineExceeded {
+		t.Errorf("Expected the ping to time out, got: %v", err)
+	}
+}
@@ -0,0 +1,10 @@
+package persistence
+
+// PrefixStore is implemented by stores able to delete every key starting
+// with a prefix, e.g. all the cached pages under a path
+type PrefixStore interface {
+	CacheStore
+
+	// DeletePrefix deletes every key starting with prefix.
+	DeletePrefix(prefix string) error
+}
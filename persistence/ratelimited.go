@@ -0,0 +1,159 @@
+package persistence
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimitedStore is a CacheStore bounding the rate of the writes to the
+// store it wraps with a token bucket, so that a burst of uncached traffic,
+// after a flush or a deploy, does not overwhelm the backend with Set calls.
+// Writes beyond the rate are skipped without error: the value is simply not
+// cached, and the request is served as usual.
+//
+// Only Set and SetMulti are limited. Add, Replace, Delete and the counters
+// report whether they succeeded, which callers such as locks rely on.
+type RateLimitedStore struct {
+	store  CacheStore
+	bucket *tokenBucket
+}
+
+// tokenBucket holds up to burst tokens, refilled at rate per second
+type tokenBucket struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	skipped uint64
+}
+
+// NewRateLimitedStore returns a RateLimitedStore writing at most rate items
+// per second to store on average, and up to burst items at once
+func NewRateLimitedStore(store CacheStore, rate float64, burst int) *RateLimitedStore {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimitedStore{
+		store: store,
+		bucket: &tokenBucket{
+			rate:   rate,
+			burst:  float64(burst),
+			tokens: float64(burst),
+			last:   time.Now(),
+		},
+	}
+}
+
+// WithContext (see ContextBinder interface)
+//
+// The bound store shares the rate of c.
+func (c *RateLimitedStore) WithContext(ctx context.Context) CacheStore {
+	return &RateLimitedStore{store: BindContext(ctx, c.store), bucket: c.bucket}
+}
+
+// Skipped returns the number of items whose write was skipped
+func (c *RateLimitedStore) Skipped() uint64 {
+	c.bucket.mu.Lock()
+	defer c.bucket.mu.Unlock()
+	return c.bucket.skipped
+}
+
+// Get (see CacheStore interface)
+func (c *RateLimitedStore) Get(key string, value interface{}) error {
+	return c.store.Get(key, value)
+}
+
+// Set (see CacheStore interface)
+//
+// It returns nil without writing the item if the rate is exceeded.
+func (c *RateLimitedStore) Set(key string, value interface{}, expires time.Duration) error {
+	if c.bucket.take(1) == 0 {
+		return nil
+	}
+	return c.store.Set(key, value, expires)
+}
+
+// Add (see CacheStore interface)
+func (c *RateLimitedStore) Add(key string, value interface{}, expires time.Duration) error {
+	return c.store.Add(key, value, expires)
+}
+
+// Replace (see CacheStore interface)
+func (c *RateLimitedStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return c.store.Replace(key, value, expires)
+}
+
+// Delete (see CacheStore interface)
+func (c *RateLimitedStore) Delete(key string) error {
+	return c.store.Delete(key)
+}
+
+// Increment (see CacheStore interface)
+func (c *RateLimitedStore) Increment(key string, delta uint64) (uint64, error) {
+	return c.store.Increment(key, delta)
+}
+
+// Decrement (see CacheStore interface)
+func (c *RateLimitedStore) Decrement(key string, delta uint64) (uint64, error) {
+	return c.store.Decrement(key, delta)
+}
+
+// Flush (see CacheStore interface)
+func (c *RateLimitedStore) Flush() error {
+	return c.store.Flush()
+}
+
+// Close (see CacheStore interface)
+func (c *RateLimitedStore) Close(ctx context.Context) error {
+	return c.store.Close(ctx)
+}
+
+// GetMulti (see CacheStore interface)
+func (c *RateLimitedStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	return c.store.GetMulti(keys, values)
+}
+
+// SetMulti (see CacheStore interface)
+//
+// Each item counts against the rate. If it is exceeded, only as many items as
+// it allows are written, picked arbitrarily.
+func (c *RateLimitedStore) SetMulti(items map[string]Item) error {
+	n := c.bucket.take(len(items))
+	if n == 0 {
+		return nil
+	}
+	if n < len(items) {
+		allowed := make(map[string]Item, n)
+		for key, item := range items {
+			if len(allowed) == n {
+				break
+			}
+			allowed[key] = item
+		}
+		items = allowed
+	}
+	return c.store.SetMulti(items)
+}
+
+// take removes up to n tokens from the bucket, and returns how many it did.
+// The others are counted as skipped.
+func (b *tokenBucket) take(n int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	taken := n
+	if available := int(b.tokens); taken > available {
+		taken = available
+	}
+	b.tokens -= float64(taken)
+	b.skipped += uint64(n - taken)
+	return taken
+}
@@ -0,0 +1,64 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+var newRateLimitedStore = func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
+	return NewRateLimitedStore(NewInMemoryStore(defaultExpiration), 1000, 1000)
+}
+
+// Test typical cache interactions
+func TestRateLimitedCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newRateLimitedStore)
+}
+
+func TestRateLimitedCache_IncrDecr(t *testing.T) {
+	incrDecr(t, newRateLimitedStore)
+}
+
+func TestRateLimitedCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newRateLimitedStore)
+}
+
+func TestRateLimitedCache_Add(t *testing.T) {
+	testAdd(t, newRateLimitedStore)
+}
+
+func TestRateLimitedCache_SkipWrites(t *testing.T) {
+	backend := NewInMemoryStore(time.Hour)
+	store := NewRateLimitedStore(backend, 10, 2)
+
+	for _, key := range []string{"a", "b", "c"} {
+		if err := store.Set(key, "value", DEFAULT); err != nil {
+			t.Errorf("Expected writes beyond the rate to be skipped without error, got: %s", err)
+		}
+	}
+	var s string
+	if err := backend.Get("c", &s); err != ErrCacheMiss {
+		t.Errorf("Expected the write beyond the burst to be skipped, got: %q, %v", s, err)
+	}
+	if err := store.Add("d", "value", DEFAULT); err != nil {
+		t.Errorf("Expected Add not to be limited, got: %s", err)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	items := map[string]Item{"e": {Value: "value"}, "f": {Value: "value"}, "g": {Value: "value"}}
+	if err := store.SetMulti(items); err != nil {
+		t.Errorf("Expected writes beyond the rate to be skipped without error, got: %s", err)
+	}
+	found, _ := backend.GetMulti([]string{"e", "f", "g"}, []interface{}{new(string), new(string), new(string)})
+	written := 0
+	for _, ok := range found {
+		if ok {
+			written++
+		}
+	}
+	if written != 2 {
+		t.Errorf("Expected the items allowed by the rate to be written, got %d", written)
+	}
+	if skipped := store.Skipped(); skipped != 2 {
+		t.Errorf("Expected 2 skipped writes, got %d", skipped)
+	}
+}
@@ -0,0 +1,72 @@
+package persistence
+
+// appendSource appends ARGV[1] to KEYS[1] if it exists, and returns 1 if it
+// did, 0 otherwise
+const appendSource = `
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("APPEND", KEYS[1], ARGV[1])
+return 1
+`
+
+// prependSource prepends ARGV[1] to KEYS[1] if it exists, keeping its time
+// to live, and returns 1 if it did, 0 otherwise
+const prependSource = `
+local current = redis.call("GET", KEYS[1])
+if not current then
+	return 0
+end
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl > 0 then
+	redis.call("SET", KEYS[1], ARGV[1] .. current, "PX", ttl)
+else
+	redis.call("SET", KEYS[1], ARGV[1] .. current)
+end
+return 1
+`
+
+// RawStore is implemented by stores able to add bytes to the end or the
+// beginning of an item in place, so that accumulating data in an item, like
+// a log buffer, does not read and write the whole value each time. Byte
+// slices are stored as is, whatever the codec of the store, so items set to
+// a []byte and read into a *[]byte hold the bytes appended to them.
+type RawStore interface {
+	CacheStore
+
+	// Append adds data to the end of the item at key, keeping its
+	// expiration. It returns ErrNotStored if key holds no item.
+	Append(key string, data []byte) error
+
+	// Prepend adds data to the beginning of the item at key, keeping its
+	// expiration. It returns ErrNotStored if key holds no item.
+	Prepend(key string, data []byte) error
+}
+
+// Append adds data to the end of the item of store at key. It returns
+// ErrNotSupport if the store is not a RawStore.
+func Append(store CacheStore, key string, data []byte) error {
+	rawStore, ok := store.(RawStore)
+	if !ok {
+		return ErrNotSupport
+	}
+	return rawStore.Append(key, data)
+}
+
+// Prepend adds data to the beginning of the item of store at key. It returns
+// ErrNotSupport if the store is not a RawStore.
+func Prepend(store CacheStore, key string, data []byte) error {
+	rawStore, ok := store.(RawStore)
+	if !ok {
+		return ErrNotSupport
+	}
+	return rawStore.Prepend(key, data)
+}
+
+// rawResult converts the result of appendSource or prependSource to an error
+func rawResult(stored int64) error {
+	if stored == 0 {
+		return ErrNotStored
+	}
+	return nil
+}
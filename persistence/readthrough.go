@@ -0,0 +1,109 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var errLoaderType = errors.New("cache: loader returned a value of another type.")
+
+// ReadThroughStore is a CacheStore adding Fetch to the store it wraps, which
+// loads and stores the values missing from the cache
+type ReadThroughStore struct {
+	CacheStore
+
+	group *loadGroup
+}
+
+// NewReadThroughStore returns a ReadThroughStore wrapping store
+func NewReadThroughStore(store CacheStore) *ReadThroughStore {
+	return &ReadThroughStore{CacheStore: store, group: &loadGroup{}}
+}
+
+// WithContext (see ContextBinder interface)
+//
+// The bound store shares in-flight loads with s.
+func (s *ReadThroughStore) WithContext(ctx context.Context) CacheStore {
+	return &ReadThroughStore{CacheStore: BindContext(ctx, s.CacheStore), group: s.group}
+}
+
+// Fetch gets the item at key into value, a pointer. If the key cannot be read,
+// the item is loaded with loader, stored for expires and assigned to value;
+// the value returned by loader must be assignable to *value.
+//
+// Concurrent fetches of the same key missing the cache share a single call to
+// loader. An error returned by loader is returned to all of them and nothing is
+// stored. If storing the loaded item fails, value is set anyway and the error
+// is returned.
+func (s *ReadThroughStore) Fetch(key string, value interface{}, expires time.Duration, loader func() (interface{}, error)) error {
+	if err := s.Get(key, value); err == nil {
+		return nil
+	}
+	var storeErr error
+	loaded, err := s.group.do(key, func() (interface{}, error) {
+		v, err := loader()
+		if err != nil {
+			return nil, err
+		}
+		storeErr = s.Set(key, v, expires)
+		return v, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ptr := reflect.ValueOf(value)
+	if ptr.Kind() != reflect.Ptr || !ptr.Elem().CanSet() {
+		return ErrNotStored
+	}
+	v := reflect.ValueOf(loaded)
+	if !v.IsValid() {
+		ptr.Elem().Set(reflect.Zero(ptr.Elem().Type()))
+	} else if v.Type().AssignableTo(ptr.Elem().Type()) {
+		ptr.Elem().Set(v)
+	} else {
+		return errLoaderType
+	}
+	return storeErr
+}
+
+// loadGroup dedupes concurrent loads of the same key
+type loadGroup struct {
+	mu    sync.Mutex
+	calls map[string]*loadCall
+}
+
+type loadCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// do runs fn if no call for key is in flight, and otherwise waits for the
+// in-flight call. Either way it returns the result of the call.
+func (g *loadGroup) do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*loadCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return call.value, call.err
+	}
+	call := &loadCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.value, call.err = fn()
+	return call.value, call.err
+}
@@ -0,0 +1,84 @@
+package persistence
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadThroughStore_Fetch(t *testing.T) {
+	store := NewReadThroughStore(NewInMemoryStore(time.Hour))
+
+	calls := 0
+	loader := func() (interface{}, error) {
+		calls++
+		return "loaded", nil
+	}
+	for i := 0; i < 2; i++ {
+		var value string
+		if err := store.Fetch("key", &value, DEFAULT, loader); err != nil {
+			t.Fatalf("Error fetching: %s", err)
+		}
+		if value != "loaded" {
+			t.Errorf("Expected loaded, got %q", value)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected the loader to be called once, got %d calls", calls)
+	}
+
+	failure := errors.New("load failed")
+	var value string
+	err := store.Fetch("failing", &value, DEFAULT, func() (interface{}, error) {
+		return nil, failure
+	})
+	if err != failure {
+		t.Errorf("Expected the loader error, got %v", err)
+	}
+	if err := store.Get("failing", &value); err != ErrCacheMiss {
+		t.Errorf("Expected nothing to be stored, got %v", err)
+	}
+
+	var n int
+	if err := store.Fetch("typed", &n, DEFAULT, loader); err != errLoaderType {
+		t.Errorf("Expected errLoaderType, got %v", err)
+	}
+}
+
+func TestReadThroughStore_FetchConcurrent(t *testing.T) {
+	store := NewReadThroughStore(NewInMemoryStore(time.Hour))
+
+	var calls int32
+	release := make(chan struct{})
+	loader := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return 42, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]int, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := store.Fetch("key", &results[i], DEFAULT, loader); err != nil {
+				t.Errorf("Error fetching: %s", err)
+			}
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("Expected a single load, got %d", calls)
+	}
+	for i, n := range results {
+		if n != 42 {
+			t.Errorf("Expected fetch %d to return 42, got %d", i, n)
+		}
+	}
+}
@@ -1,53 +1,200 @@
 package persistence
 
 import (
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"log"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/gin-contrib/cache/utils"
 	"github.com/go-redis/redis/v7"
+	"github.com/mlsen/cache/utils"
 )
 
+// dumpBatchSize is the number of keys scanned or written per round trip by
+// Export and LoadFrom
+const dumpBatchSize = 100
+
+// pinnedKeysKey is the Redis set holding the keys stored with SetPinned
+const pinnedKeysKey = "gincontrib.cache.pinned"
+
 // RedisStore represents the cache with redis cluster persistence
 type RedisStore struct {
+	// evictions is accessed atomically and kept first for 64-bit alignment
+	evictions uint64
+
 	client            redis.UniversalClient
 	defaultExpiration time.Duration
+
+	invalidationStream       string
+	invalidationStreamMaxLen int64
+
+	versioned      bool
+	schemaVersion  byte
+	mismatchAsMiss bool
+
+	startupTimeout time.Duration
+	backoff        Backoff
+	lazyConnect    bool
+	onReconnect    func()
+	// connection holds the connection settings of the options, applied by
+	// NewRedisCache over its ClientOptions
+	connection []func(*redis.UniversalOptions)
+
+	dryRunWrites bool
+
+	trackAge bool
+
+	readOnly bool
+
+	flushScope string
+
+	codec utils.Codec
+
+	logger Logger
 }
 
 // ClientOptions proxies Options from the go-redis library
 type ClientOptions redis.UniversalOptions
 
-// NewRedisCache returns a RedisStore
-func NewRedisCache(opts *ClientOptions, defaultExpiration time.Duration) (*RedisStore, error) {
+// RedisOption configures optional behaviour of a RedisStore
+type RedisOption func(*RedisStore)
+
+// NewRedisCache returns a RedisStore. The connection settings of options,
+// such as WithPoolSize or WithTLS, override those of opts, and production
+// defaults apply to those neither sets. It returns an error wrapping
+// ErrInvalidOptions if the settings are invalid.
+func NewRedisCache(opts *ClientOptions, defaultExpiration time.Duration, options ...RedisOption) (*RedisStore, error) {
+	c := NewRedisCacheFromClient(nil, defaultExpiration, options...)
+	opts, err := c.clientOptions(opts)
+	if err != nil {
+		return nil, err
+	}
 	uniopts := redis.UniversalOptions(*opts)
-	c := redis.NewUniversalClient(&uniopts)
+	if c.lazyConnect || c.onReconnect != nil {
+		uniopts.Dialer = newReconnector(opts, c.backoff, c.onReconnect).Dial
+	}
+	c.client = redis.NewUniversalClient(&uniopts)
+	if c.lazyConnect {
+		return c, nil
+	}
 
-	err := c.Ping().Err()
+	err = pingWithRetry(c.client, c.startupTimeout, c.backoff)
 	if err != nil {
 		return nil, err
 	}
-	return &RedisStore{c, defaultExpiration}, nil
+	return c, nil
+}
+
+// NewRedisCacheFromClient returns a RedisStore from an existing go-redis
+// client. The connection settings of options are ignored.
+func NewRedisCacheFromClient(client redis.UniversalClient, defaultExpiration time.Duration, options ...RedisOption) *RedisStore {
+	c := &RedisStore{
+		client:            client,
+		defaultExpiration: defaultExpiration,
+		backoff:           defaultBackoff,
+		codec:             utils.GobCodec,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// WithStartupRetry makes NewRedisCache retry the initial Ping for up to
+// timeout, instead of failing on the first error. This keeps a fleet of
+// replicas starting against a Redis that is still booting from crash-looping.
+// Retries are spaced by the store's Backoff, see WithBackoff.
+func WithStartupRetry(timeout time.Duration) RedisOption {
+	return func(c *RedisStore) {
+		c.startupTimeout = timeout
+	}
 }
 
-// NewRedisCacheFromClient returns a RedisStore from an existing go-redis client
-func NewRedisCacheFromClient(client redis.UniversalClient, defaultExpiration time.Duration) *RedisStore {
-	return &RedisStore{client, defaultExpiration}
+// WithBackoff sets the Backoff used by every retrying feature of the store.
+// The default is a jittered exponential backoff from 50ms up to 5s.
+func WithBackoff(b Backoff) RedisOption {
+	return func(c *RedisStore) {
+		c.backoff = b
+	}
+}
+
+// pingWithRetry pings the server until it succeeds or until the next attempt
+// would start after timeout has elapsed, in which case the last error is
+// returned.
+func pingWithRetry(client interface{ Ping() *redis.StatusCmd }, timeout time.Duration, backoff Backoff) error {
+	deadline := time.Now().Add(timeout)
+	for attempt := 0; ; attempt++ {
+		err := client.Ping().Err()
+		if err == nil {
+			return nil
+		}
+
+		delay := backoff.NextDelay(attempt)
+		if time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		time.Sleep(delay)
+	}
+}
+
+// Ping (see PingStore interface)
+func (c *RedisStore) Ping(ctx context.Context) error {
+	return c.WithContext(ctx).(*RedisStore).client.Ping().Err()
+}
+
+// Close (see CacheStore interface)
+//
+// It closes the client of the store, including one passed to
+// NewRedisCacheFromClient, unless ctx is already done. Subscriptions such as
+// MonitorEvictions end as their connection is closed.
+func (c *RedisStore) Close(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.client.Close()
 }
 
 // Set (see CacheStore interface)
 func (c *RedisStore) Set(key string, value interface{}, expires time.Duration) error {
-	value, err := utils.Serialize(value)
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	value, err := c.serialize(value, expires)
 	if err != nil {
 		return err
 	}
-	return c.client.Set(key, value, c.expval(expires)).Err()
+	if c.dryRun("SET", key) {
+		return nil
+	}
+	if err = c.client.Set(key, value, c.expval(expires)).Err(); err != nil {
+		return err
+	}
+	c.publishInvalidation(key)
+	return nil
 }
 
 // Add (see CacheStore interface)
 func (c *RedisStore) Add(key string, value interface{}, expires time.Duration) error {
-	value, err := utils.Serialize(value)
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	value, err := c.serialize(value, expires)
 	if err != nil {
 		return err
 	}
+	if c.dryRunWrites {
+		if c.client.Exists(key).Val() != 0 {
+			return ErrNotStored
+		}
+		c.dryRun("ADD", key)
+		return nil
+	}
 	stored, err := c.client.SetNX(key, value, c.expval(expires)).Result()
 	if err != nil {
 		return err
@@ -55,12 +202,16 @@ func (c *RedisStore) Add(key string, value interface{}, expires time.Duration) e
 	if !stored {
 		return ErrNotStored
 	}
+	c.publishInvalidation(key)
 	return nil
 }
 
 // Replace (see CacheStore interface)
 func (c *RedisStore) Replace(key string, value interface{}, expires time.Duration) error {
-	value, err := utils.Serialize(value)
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	value, err := c.serialize(value, expires)
 	if err != nil {
 		return err
 	}
@@ -70,6 +221,9 @@ func (c *RedisStore) Replace(key string, value interface{}, expires time.Duratio
 	if value == nil {
 		return ErrNotStored
 	}
+	if c.dryRun("REPLACE", key) {
+		return nil
+	}
 	return c.Set(key, value, c.expval(expires))
 }
 
@@ -82,11 +236,250 @@ func (c *RedisStore) Get(key string, ptrValue interface{}) error {
 		}
 		return err
 	}
-	return utils.Deserialize(val, ptrValue)
+	return c.deserialize(val, ptrValue)
+}
+
+// GetWithTTL (see TTLStore interface)
+//
+// The value and its time to live are fetched with a pipelined GET and PTTL.
+func (c *RedisStore) GetWithTTL(key string, ptrValue interface{}) (time.Duration, error) {
+	pipe := c.client.Pipeline()
+	get := pipe.Get(key)
+	pttl := pipe.PTTL(key)
+	if _, err := pipe.Exec(); err != nil {
+		if err == redis.Nil {
+			return 0, ErrCacheMiss
+		}
+		return 0, err
+	}
+	val, _ := get.Bytes()
+	if err := c.deserialize(val, ptrValue); err != nil {
+		return 0, err
+	}
+	return redisTTL(pttl.Val()), nil
+}
+
+// GetWithInfo (see InfoStore interface)
+//
+// StoredAt is only known for items written with WithAgeTracking, and Size
+// is the size of the item as serialized. Hits and Tags are not tracked.
+func (c *RedisStore) GetWithInfo(key string, ptrValue interface{}) (Info, error) {
+	pipe := c.client.Pipeline()
+	get := pipe.Get(key)
+	pttl := pipe.PTTL(key)
+	if _, err := pipe.Exec(); err != nil {
+		if err == redis.Nil {
+			return Info{}, ErrCacheMiss
+		}
+		return Info{}, err
+	}
+	val, _ := get.Bytes()
+	age, err := c.decode(val, ptrValue)
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{TTL: redisTTL(pttl.Val()), Size: int64(len(val)), Hits: -1}
+	if age.tracked {
+		info.StoredAt = age.insertedAt
+	}
+	return info, nil
+}
+
+// Exists (see TTLStore interface)
+func (c *RedisStore) Exists(key string) (bool, error) {
+	n, err := c.client.Exists(key).Result()
+	return n > 0, err
+}
+
+// Touch (see TouchStore interface)
+//
+// It sends PEXPIRE, or PERSIST for items that do not expire. With
+// WithAgeTracking, the age of the item is still computed from the expiration
+// it was written with.
+func (c *RedisStore) Touch(key string, expires time.Duration) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if c.dryRun("EXPIRE", key) {
+		return nil
+	}
+	var found bool
+	var err error
+	if expires := c.expval(expires); expires > 0 {
+		found, err = c.client.PExpire(key, expires).Result()
+	} else if found, err = c.client.Persist(key).Result(); err == nil && !found {
+		// PERSIST fails on keys without an expiration too
+		found, err = c.Exists(key)
+	}
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrCacheMiss
+	}
+	return nil
+}
+
+// redisTTL converts the result of PTTL on an existing key to a TTLStore
+// time to live
+func redisTTL(pttl time.Duration) time.Duration {
+	if pttl < 0 {
+		return FOREVER
+	}
+	if pttl == 0 {
+		// The key expires right now
+		return time.Nanosecond
+	}
+	return pttl
+}
+
+// GetMulti (see CacheStore interface)
+//
+// All keys are fetched with a single MGET, or with a pipeline of GET on a
+// cluster, where the keys may hash to slots of different nodes.
+func (c *RedisStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	if len(keys) != len(values) {
+		return nil, errGetMultiLength
+	}
+	found := make([]bool, len(keys))
+	if len(keys) == 0 {
+		return found, nil
+	}
+	vals, err := c.mget(keys)
+	if err != nil {
+		return nil, err
+	}
+	for i, val := range vals {
+		// Missing keys are nil, empty values are ""
+		s, ok := val.(string)
+		if !ok {
+			continue
+		}
+		err = c.deserialize([]byte(s), values[i])
+		if err == ErrNegativeHit {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found[i] = true
+	}
+	return found, nil
+}
+
+// SetMulti (see CacheStore interface)
+//
+// All items are written with a single pipelined round trip, of one SET per
+// item rather than an MSET, so that a cluster routes each to its node.
+func (c *RedisStore) SetMulti(items map[string]Item) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	pipe := c.client.Pipeline()
+	defer pipe.Close()
+	keys := make([]string, 0, len(items))
+	for key, item := range items {
+		b, err := c.serialize(item.Value, item.Expire)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		pipe.Set(key, b, c.expval(item.Expire))
+	}
+	if len(keys) == 0 || c.dryRun("SET", keys...) {
+		return nil
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		c.publishInvalidation(key)
+	}
+	return nil
+}
+
+// mget returns the values of keys like MGET: strings, or nil for missing
+// keys. A cluster rejects an MGET of keys in different hash slots, so each key
+// is fetched with its own GET there, pipelined to the nodes holding them.
+func (c *RedisStore) mget(keys []string) ([]interface{}, error) {
+	if _, ok := c.client.(*redis.ClusterClient); !ok {
+		return c.client.MGet(keys...).Result()
+	}
+	pipe := c.client.Pipeline()
+	defer pipe.Close()
+	gets := make([]*redis.StringCmd, len(keys))
+	for i, key := range keys {
+		gets[i] = pipe.Get(key)
+	}
+	if _, err := pipe.Exec(); err != nil && err != redis.Nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(keys))
+	for i, get := range gets {
+		if val, err := get.Result(); err == nil {
+			vals[i] = val
+		}
+	}
+	return vals, nil
+}
+
+// GetWithAge works like Get, and additionally returns how long ago the value
+// was written and the expiration it was written with (0 if it never
+// expires), as recorded by WithAgeTracking. It returns ErrNotSupport for
+// values stored without that information: byte slices, integers, and values
+// written before age tracking was enabled.
+func (c *RedisStore) GetWithAge(key string, ptrValue interface{}) (age time.Duration, maxAge time.Duration, err error) {
+	val, err := c.client.Get(key).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, 0, ErrCacheMiss
+		}
+		return 0, 0, err
+	}
+	entry, err := c.decode(val, ptrValue)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !entry.tracked {
+		return 0, 0, ErrNotSupport
+	}
+	age = time.Since(entry.insertedAt)
+	if age < 0 {
+		age = 0
+	}
+	return age, entry.maxAge, nil
+}
+
+// Size returns the number of bytes stored for key, without transferring the
+// value. This is the size of the value as serialized and stored in Redis, not
+// of the Go value it deserializes to. Returns ErrCacheMiss if the key does
+// not exist.
+func (c *RedisStore) Size(key string) (int64, error) {
+	pipe := c.client.Pipeline()
+	defer pipe.Close()
+	exists := pipe.Exists(key)
+	strlen := pipe.StrLen(key)
+	if _, err := pipe.Exec(); err != nil {
+		return 0, err
+	}
+	if exists.Val() == 0 {
+		return 0, ErrCacheMiss
+	}
+	return strlen.Val(), nil
 }
 
 // Delete (see CacheStore interface)
 func (c *RedisStore) Delete(key string) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if c.dryRunWrites {
+		if c.client.Exists(key).Val() == 0 {
+			return ErrCacheMiss
+		}
+		c.dryRun("DEL", key)
+		return nil
+	}
 	del, err := c.client.Del(key).Result()
 	if err != nil {
 		return err
@@ -94,28 +487,697 @@ func (c *RedisStore) Delete(key string) error {
 	if del == 0 {
 		return ErrCacheMiss
 	}
+	c.publishInvalidation(key)
 	return nil
 }
 
+// DeleteMultiReport removes keys from the cache with a single pipelined round
+// trip and reports, per key, whether it was present and got deleted.
+func (c *RedisStore) DeleteMultiReport(keys []string) (map[string]bool, error) {
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+	pipe := c.client.Pipeline()
+	defer pipe.Close()
+	dels := make([]*redis.IntCmd, len(keys))
+	dryRun := c.dryRun("DEL", keys...)
+	for i, key := range keys {
+		if dryRun {
+			dels[i] = pipe.Exists(key)
+		} else {
+			dels[i] = pipe.Del(key)
+		}
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return nil, err
+	}
+
+	deleted := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		deleted[key] = dels[i].Val() > 0
+		if deleted[key] && !dryRun {
+			c.publishInvalidation(key)
+		}
+	}
+	return deleted, nil
+}
+
 // Increment (see CacheStore interface)
+//
+// Redis stores counters as signed 64-bit integers, so RedisStore only accepts
+// counters between 0 and math.MaxInt64. The sum is computed modulo 2^64 like
+// any uint64 addition; if the result does not fit in an int64, the stored
+// value is left untouched and ErrCounterOverflow is returned. A negative
+// stored value yields ErrNegativeCounter. The key's time to live is kept.
 func (c *RedisStore) Increment(key string, delta uint64) (uint64, error) {
-	val, err := c.client.Get(key).Int64()
+	return c.increment(key, delta, DEFAULT, true)
+}
+
+// IncrementWithTTL increments a counter like Increment, and sets its
+// expiration to expires instead of keeping the current one
+func (c *RedisStore) IncrementWithTTL(key string, delta uint64, expires time.Duration) (uint64, error) {
+	return c.increment(key, delta, expires, false)
+}
+
+// Decrement (see CacheStore interface)
+//
+// Decrementing stops at 0. A negative stored value yields ErrNegativeCounter.
+// The key's time to live is kept.
+func (c *RedisStore) Decrement(key string, delta uint64) (uint64, error) {
+	return c.decrement(key, delta, DEFAULT, true)
+}
+
+// DecrementWithTTL decrements a counter like Decrement, and sets its
+// expiration to expires instead of keeping the current one
+func (c *RedisStore) DecrementWithTTL(key string, delta uint64, expires time.Duration) (uint64, error) {
+	return c.decrement(key, delta, expires, false)
+}
+
+func (c *RedisStore) increment(key string, delta uint64, expires time.Duration, keepTTL bool) (uint64, error) {
+	if c.readOnly {
+		return 0, ErrReadOnly
+	}
+	if c.dryRunWrites {
+		val, err := c.getCounter(key)
+		if err != nil {
+			return 0, err
+		}
+		sum := uint64(val) + delta
+		if sum > math.MaxInt64 {
+			return 0, ErrCounterOverflow
+		}
+		c.dryRun("INCRBY", key)
+		return sum, nil
+	}
+	// Adding delta as an int64 gives the uint64 sum modulo 2^64, as long as
+	// it fits in an int64
+	return c.runCounterScript(incrementScript, key, strconv.FormatInt(int64(delta), 10), expires, keepTTL)
+}
+
+func (c *RedisStore) decrement(key string, delta uint64, expires time.Duration, keepTTL bool) (uint64, error) {
+	if c.readOnly {
+		return 0, ErrReadOnly
+	}
+	if c.dryRunWrites {
+		val, err := c.getCounter(key)
+		if err != nil {
+			return 0, err
+		}
+		if delta > uint64(val) {
+			delta = uint64(val)
+		}
+		c.dryRun("DECRBY", key)
+		return uint64(val) - delta, nil
+	}
+	return c.runCounterScript(decrementScript, key, strconv.FormatUint(delta, 10), expires, keepTTL)
+}
+
+// Counter scripts check and update a counter atomically. INCRBY and DECRBY
+// keep the time to live, unlike SET. Lua numbers are doubles, so values are
+// handled as strings wherever precision matters, and the new value is
+// returned as stored.
+//
+// KEYS[1] is the counter, ARGV[1] the delta, and ARGV[2] is empty to keep the
+// time to live, "0" to remove it, or the new time to live in milliseconds.
+const (
+	counterScriptHead = `
+local v = redis.call('GET', KEYS[1])
+if not v then
+	return redis.error_reply('CACHEMISS')
+end
+if string.sub(v, 1, 1) == '-' then
+	return redis.error_reply('NEGATIVE')
+end
+`
+	counterScriptTail = `
+if ARGV[2] == '0' then
+	redis.call('PERSIST', KEYS[1])
+elseif ARGV[2] ~= '' then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return redis.call('GET', KEYS[1])
+`
+)
+
+// incrementSource restores the counter and fails with OVERFLOW if the sum
+// does not fit in a non-negative int64
+const incrementSource = counterScriptHead + `
+local n = redis.pcall('INCRBY', KEYS[1], ARGV[1])
+if type(n) == 'table' then
+	if n.err and string.find(n.err, 'overflow') then
+		return redis.error_reply('OVERFLOW')
+	end
+	return n
+end
+if n < 0 then
+	local ttl = redis.call('PTTL', KEYS[1])
+	redis.call('SET', KEYS[1], v)
+	if ttl > 0 then
+		redis.call('PEXPIRE', KEYS[1], ttl)
+	end
+	return redis.error_reply('OVERFLOW')
+end
+` + counterScriptTail
+
+// decrementSource stops at 0. Both numbers are non-negative decimal strings
+// without leading zeros, so they compare by length, then lexically.
+const decrementSource = counterScriptHead + `
+local d = ARGV[1]
+if #d > #v or (#d == #v and d >= v) then
+	d = v
+end
+redis.call('DECRBY', KEYS[1], d)
+` + counterScriptTail
+
+var (
+	incrementScript = redis.NewScript(incrementSource)
+	decrementScript = redis.NewScript(decrementSource)
+)
+
+func (c *RedisStore) runCounterScript(script *redis.Script, key, delta string, expires time.Duration, keepTTL bool) (uint64, error) {
+	val, err := script.Run(c.client, []string{key}, delta, counterTTL(c.expval(expires), keepTTL)).Uint64()
 	if err != nil {
-		if err == redis.Nil {
-			return 0, ErrCacheMiss
+		return 0, counterScriptError(err)
+	}
+	return val, nil
+}
+
+// counterTTL returns the ARGV[2] of the counter scripts for an expiration
+func counterTTL(expires time.Duration, keepTTL bool) string {
+	if keepTTL {
+		return ""
+	}
+	ms := expires / time.Millisecond
+	if ms == 0 && expires > 0 {
+		ms = 1
+	}
+	return strconv.FormatInt(int64(ms), 10)
+}
+
+// counterScriptError converts the errors replied by the counter scripts
+func counterScriptError(err error) error {
+	// Some servers prefix script errors with the generic ERR code
+	switch strings.TrimPrefix(err.Error(), "ERR ") {
+	case "CACHEMISS":
+		return ErrCacheMiss
+	case "NEGATIVE":
+		return ErrNegativeCounter
+	case "OVERFLOW":
+		return ErrCounterOverflow
+	}
+	return err
+}
+
+// Flush (see CacheStore interface)
+//
+// Flush deletes the keys of the current database within the scope set with
+// WithFlushScope, all of them by default, and never touches other databases.
+// Keys are found with SCAN, on every master of a cluster. Keys stored with
+// SetPinned survive a Flush: use FlushForce to clear them as well.
+func (c *RedisStore) Flush() error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if c.dryRun("FLUSH", c.flushScope+"*") {
+		return nil
+	}
+	pinned, err := c.client.SMembers(pinnedKeysKey).Result()
+	if err != nil {
+		return err
+	}
+
+	keep := map[string]bool{pinnedKeysKey: true}
+	for _, key := range pinned {
+		keep[key] = true
+	}
+	err = c.scanKeys(globEscaper.Replace(c.flushScope)+"*", func(node redis.Cmdable, keys []string) error {
+		del := keys[:0]
+		for _, key := range keys {
+			if !keep[key] {
+				del = append(del, key)
+			}
 		}
-		return 0, err
+		return deleteKeys(node, del)
+	})
+	if err != nil {
+		return err
 	}
-	sum := val + int64(delta)
-	err = c.client.Set(key, sum, 0).Err()
+
+	// Forget pinned keys that expired or were deleted in the meantime
+	for _, key := range pinned {
+		if c.client.Exists(key).Val() == 0 {
+			c.client.SRem(pinnedKeysKey, key)
+		}
+	}
+	return nil
+}
+
+// FlushForce deletes all items from the cache like Flush, including pinned
+// ones. Only the pins within the scope set with WithFlushScope are forgotten,
+// those of other applications sharing the database are kept.
+func (c *RedisStore) FlushForce() error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if c.dryRun("FLUSH (force)", c.flushScope+"*") {
+		return nil
+	}
+	pinned, err := c.client.SMembers(pinnedKeysKey).Result()
 	if err != nil {
-		return 0, err
+		return err
+	}
+	err = c.scanKeys(globEscaper.Replace(c.flushScope)+"*", deleteKeys)
+	if err != nil || c.flushScope == "" {
+		return err
 	}
-	return uint64(sum), nil
+	var scoped []interface{}
+	for _, key := range pinned {
+		if strings.HasPrefix(key, c.flushScope) {
+			scoped = append(scoped, key)
+		}
+	}
+	if len(scoped) == 0 {
+		return nil
+	}
+	return c.client.SRem(pinnedKeysKey, scoped...).Err()
 }
 
-// Decrement (see CacheStore interface)
-func (c *RedisStore) Decrement(key string, delta uint64) (uint64, error) {
+// FlushAll issues FLUSHALL, deleting every key of every database of the
+// server, including those of other applications sharing it. Prefer Flush.
+func (c *RedisStore) FlushAll() error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if c.dryRun("FLUSHALL") {
+		return nil
+	}
+	return c.client.FlushAll().Err()
+}
+
+// WithFlushScope restricts Flush and FlushForce to the keys starting with
+// prefix, typically the namespace of a NamespacedStore wrapping the store, so
+// that flushing the cache of an application leaves the keys of the others
+// sharing the database.
+func WithFlushScope(prefix string) RedisOption {
+	return func(c *RedisStore) {
+		c.flushScope = prefix
+	}
+}
+
+// scanKeys calls fn with the keys matching pattern, by batches, along with
+// the node holding them: every master of a cluster, or the server
+func (c *RedisStore) scanKeys(pattern string, fn func(node redis.Cmdable, keys []string) error) error {
+	if cluster, ok := c.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(func(node *redis.Client) error {
+			return scanNode(node, pattern, fn)
+		})
+	}
+	return scanNode(c.client, pattern, fn)
+}
+
+func scanNode(node redis.Cmdable, pattern string, fn func(node redis.Cmdable, keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(cursor, pattern, dumpBatchSize).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err = fn(node, keys); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// deleteKeys deletes keys from node with a pipeline of single-key DEL, as the
+// keys of a cluster node may belong to different hash slots
+func deleteKeys(node redis.Cmdable, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	pipe := node.Pipeline()
+	defer pipe.Close()
+	for _, key := range keys {
+		pipe.Del(key)
+	}
+	_, err := pipe.Exec()
+	return err
+}
+
+// SetPinned sets an item to the cache like Set, and pins it so that it
+// survives Flush. Pinned keys are tracked in a Redis set; only FlushForce
+// clears them.
+func (c *RedisStore) SetPinned(key string, value interface{}, expires time.Duration) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if c.dryRun("SET (pinned)", key) {
+		return nil
+	}
+	if err := c.Set(key, value, expires); err != nil {
+		return err
+	}
+	return c.client.SAdd(pinnedKeysKey, key).Err()
+}
+
+// AddToSet adds value to the Redis set stored at setKey. Together with
+// ClaimOne it can be used as a simple work queue shared between processes.
+func (c *RedisStore) AddToSet(setKey string, value interface{}) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	b, err := c.serialize(value, FOREVER)
+	if err != nil {
+		return err
+	}
+	if c.dryRun("SADD", setKey) {
+		return nil
+	}
+	return c.client.SAdd(setKey, b).Err()
+}
+
+// ClaimOne atomically removes a random member from the Redis set stored at
+// setKey and deserializes it into ptrValue. Since SPOP is atomic, a member is
+// never handed out to more than one caller. claimed is false if the set is
+// empty or does not exist.
+func (c *RedisStore) ClaimOne(setKey string, ptrValue interface{}) (claimed bool, err error) {
+	if c.readOnly {
+		return false, ErrReadOnly
+	}
+	if c.dryRun("SPOP", setKey) {
+		return false, nil
+	}
+	val, err := c.client.SPop(setKey).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return false, nil
+		}
+		return false, err
+	}
+	if err = c.deserialize(val, ptrValue); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// dumpEntry is a single record of the stream written by Export and read by
+// LoadFrom. ExpiresAt is the zero time for keys without an expiration.
+type dumpEntry struct {
+	Key       string
+	Value     []byte
+	ExpiresAt time.Time
+}
+
+// Export writes the string keys matching pattern, along with their values and
+// expiration times, to w. Keys holding other Redis types are skipped. On a
+// cluster, the keys of every master are exported. The stream can be read back
+// with LoadFrom.
+func (c *RedisStore) Export(w io.Writer, pattern string) error {
+	enc := gob.NewEncoder(w)
+	return c.scanKeys(pattern, func(node redis.Cmdable, keys []string) error {
+		pipe := node.Pipeline()
+		defer pipe.Close()
+		values := make([]*redis.StringCmd, len(keys))
+		ttls := make([]*redis.DurationCmd, len(keys))
+		for i, key := range keys {
+			values[i] = pipe.Get(key)
+			ttls[i] = pipe.PTTL(key)
+		}
+		// Errors are checked per command below
+		pipe.Exec()
+		now := time.Now()
+		for i, key := range keys {
+			val, err := values[i].Bytes()
+			if err == redis.Nil || (err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE")) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			ttl, err := ttls[i].Result()
+			if err != nil {
+				return err
+			}
+			entry := dumpEntry{Key: key, Value: val}
+			if ttl > 0 {
+				entry.ExpiresAt = now.Add(ttl)
+			}
+			if err = enc.Encode(&entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// LoadFrom seeds the cache from a stream written by Export, e.g. a dump of hot
+// keys kept on local disk to speed up cold starts. Entries are written with
+// pipelined SETs using their remaining time to live; entries that have already
+// expired are skipped.
+func (c *RedisStore) LoadFrom(r io.Reader) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	dec := gob.NewDecoder(r)
+	pipe := c.client.Pipeline()
+	defer pipe.Close()
+	queued := 0
+	for {
+		var entry dumpEntry
+		err := dec.Decode(&entry)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var ttl time.Duration
+		if !entry.ExpiresAt.IsZero() {
+			if ttl = time.Until(entry.ExpiresAt); ttl <= 0 {
+				continue
+			}
+		}
+		if c.dryRun("SET", entry.Key) {
+			continue
+		}
+		pipe.Set(entry.Key, entry.Value, ttl)
+		if queued++; queued == dumpBatchSize {
+			if _, err = pipe.Exec(); err != nil {
+				return err
+			}
+			queued = 0
+		}
+	}
+	if queued > 0 {
+		_, err := pipe.Exec()
+		return err
+	}
+	return nil
+}
+
+// WithSchemaVersion prefixes encoded values with a schema version byte,
+// so values written for an incompatible version of a struct are rejected by
+// Get with ErrSchemaVersionMismatch, or reported as ErrCacheMiss if
+// mismatchAsMiss is set, instead of silently decoding into a partially
+// populated struct. Byte slices and integers are stored as is, so counters
+// keep working with Increment and Decrement.
+func WithSchemaVersion(version byte, mismatchAsMiss bool) RedisOption {
+	return func(c *RedisStore) {
+		c.versioned = true
+		c.schemaVersion = version
+		c.mismatchAsMiss = mismatchAsMiss
+	}
+}
+
+// WithAgeTracking prefixes encoded values with the time they were written and
+// the expiration they were written with, which GetWithAge reports so that
+// HTTP middleware can emit accurate Age and Cache-Control headers. Gob and
+// JSON values written before it was enabled are still readable with Get. Like
+// WithSchemaVersion, byte slices and integers are stored as is.
+func WithAgeTracking() RedisOption {
+	return func(c *RedisStore) {
+		c.trackAge = true
+	}
+}
+
+// Envelopes prepended to encoded values start with envelopeMarker, then the
+// magic byte of the envelope. 0xc1 is never used by MessagePack, and starts
+// neither a gob stream, whose first byte is a message length never in the
+// range 0x80-0xf7, nor a JSON document, so an envelope cannot be mistaken for
+// the start of a value written without one by any codec. The header of values
+// compressed by utils.NewCompressedCodec also starts with 0xc1, followed by a
+// Compression, which the magic bytes are chosen apart from.
+const (
+	envelopeMarker = 0xc1
+
+	// schemaMagic is followed by the schema version
+	schemaMagic = 0x80
+	// ageMagic is followed by the schema version, the write time in Unix
+	// nanoseconds and the expiration in nanoseconds (0 if none), both as
+	// big endian uint64
+	ageMagic = 0x81
+
+	ageHeaderSize = 19
+)
+
+// WithCodec sets the Codec used to encode values other than byte slices and
+// integers, e.g. utils.JSONCodec to share the cache with services written in
+// other languages. The default is utils.GobCodec.
+func WithCodec(codec utils.Codec) RedisOption {
+	return func(c *RedisStore) {
+		c.codec = codec
+	}
+}
+
+// WithLogger reports the errors of the store that no caller sees, such as
+// failures to append to the invalidation stream after a successful write, to
+// logger as LogError events, and the writes skipped by WithDryRunWrites as
+// LogDryRun events, instead of logging them with the log package
+func WithLogger(logger Logger) RedisOption {
+	return func(c *RedisStore) {
+		c.logger = logger
+	}
+}
+
+// logError reports err, returned by operation for key, to the logger of the
+// store, or logs it with the log package
+func (c *RedisStore) logError(operation, key string, err error) {
+	if c.logger == nil {
+		log.Printf("cache: %s of %s failed: %s", operation, key, err)
+		return
+	}
+	c.logger.Log(LogEvent{Kind: LogError, Operation: operation, Key: key, Err: err})
+}
+
+// WithDryRunWrites logs writes (Set, Add, Replace, Delete, Flush, counter
+// updates, ...) instead of performing them, while
+// reads go to Redis as usual. It helps validating which keys a change would
+// write or invalidate without touching a production cache. Return values are
+// computed from the current state where possible, e.g. Add still reports
+// ErrNotStored for an existing key. Skipped writes are reported to the logger
+// set with WithLogger, one LogDryRun event per key, or logged with the log
+// package.
+func WithDryRunWrites(enabled bool) RedisOption {
+	return func(c *RedisStore) {
+		c.dryRunWrites = enabled
+	}
+}
+
+// WithReadOnly makes every write (Set, Add, Replace, Delete, Flush, counter
+// updates, ...) fail with ErrReadOnly without contacting Redis, while reads
+// work as usual. Use it for services that consume a cache owned by another
+// service and must never modify it.
+func WithReadOnly(enabled bool) RedisOption {
+	return func(c *RedisStore) {
+		c.readOnly = enabled
+	}
+}
+
+// dryRun reports whether writes are disabled by WithDryRunWrites, logging
+// the skipped operation if so
+func (c *RedisStore) dryRun(op string, keys ...string) bool {
+	if !c.dryRunWrites {
+		return false
+	}
+	if c.logger == nil {
+		log.Printf("cache: dry run, skipped %s %s", op, strings.Join(keys, " "))
+		return true
+	}
+	if len(keys) == 0 {
+		c.logger.Log(LogEvent{Kind: LogDryRun, Operation: op})
+	}
+	for _, key := range keys {
+		c.logger.Log(LogEvent{Kind: LogDryRun, Operation: op, Key: key})
+	}
+	return true
+}
+
+func (c *RedisStore) serialize(value interface{}, expires time.Duration) ([]byte, error) {
+	b, err := utils.SerializeWith(c.codec, value)
+	if err != nil || isRawValue(reflect.ValueOf(value)) {
+		return b, err
+	}
+	switch {
+	case c.trackAge:
+		header := make([]byte, ageHeaderSize, ageHeaderSize+len(b))
+		header[0], header[1] = envelopeMarker, ageMagic
+		header[2] = c.schemaVersion
+		binary.BigEndian.PutUint64(header[3:], uint64(time.Now().UnixNano()))
+		binary.BigEndian.PutUint64(header[11:], uint64(c.expval(expires)))
+		return append(header, b...), nil
+	case c.versioned:
+		return append([]byte{envelopeMarker, schemaMagic, c.schemaVersion}, b...), nil
+	}
+	return b, nil
+}
+
+func (c *RedisStore) deserialize(b []byte, ptr interface{}) error {
+	_, err := c.decode(b, ptr)
+	return err
+}
+
+// entryAge is the write time and expiration recorded by WithAgeTracking
+type entryAge struct {
+	tracked    bool
+	insertedAt time.Time
+	maxAge     time.Duration
+}
+
+// decode strips the envelope added by serialize, if any, and deserializes the
+// value into ptr
+func (c *RedisStore) decode(b []byte, ptr interface{}) (age entryAge, err error) {
+	if utils.IsNegative(b) {
+		return age, ErrNegativeHit
+	}
+	if (!c.versioned && !c.trackAge) || isRawValue(reflect.Indirect(reflect.ValueOf(ptr))) {
+		return age, utils.DeserializeWith(c.codec, b, ptr)
+	}
+
+	enveloped := true
+	var version byte
+	switch {
+	case len(b) >= ageHeaderSize && b[0] == envelopeMarker && b[1] == ageMagic:
+		version = b[2]
+		age.tracked = true
+		age.insertedAt = time.Unix(0, int64(binary.BigEndian.Uint64(b[3:])))
+		age.maxAge = time.Duration(binary.BigEndian.Uint64(b[11:]))
+		b = b[ageHeaderSize:]
+	case len(b) >= 3 && b[0] == envelopeMarker && b[1] == schemaMagic:
+		version = b[2]
+		b = b[3:]
+	default:
+		enveloped = false
+	}
+
+	if c.versioned && (!enveloped || version != c.schemaVersion) {
+		if c.mismatchAsMiss {
+			return age, ErrCacheMiss
+		}
+		return age, ErrSchemaVersionMismatch
+	}
+	return age, utils.DeserializeWith(c.codec, b, ptr)
+}
+
+// isRawValue reports whether utils.SerializeWith stores v as is rather than
+// encoding it with the codec
+func isRawValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	case reflect.Slice:
+		return v.Type() == reflect.TypeOf([]byte(nil))
+	}
+	return false
+}
+
+func (c *RedisStore) getCounter(key string) (int64, error) {
 	val, err := c.client.Get(key).Int64()
 	if err != nil {
 		if err == redis.Nil {
@@ -123,16 +1185,10 @@ func (c *RedisStore) Decrement(key string, delta uint64) (uint64, error) {
 		}
 		return 0, err
 	}
-	if delta > uint64(val) {
-		delta = uint64(val)
+	if val < 0 {
+		return 0, ErrNegativeCounter
 	}
-	tempint, err := c.client.DecrBy(key, int64(delta)).Result()
-	return uint64(tempint), err
-}
-
-// Flush (see CacheStore interface)
-func (c *RedisStore) Flush() error {
-	return c.client.FlushAll().Err()
+	return val, nil
 }
 
 func (c *RedisStore) expval(expires time.Duration) time.Duration {
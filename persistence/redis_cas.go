@@ -0,0 +1,54 @@
+package persistence
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+var compareAndSwapScript = redis.NewScript(compareAndSwapSource)
+
+// GetWithVersion (see CASStore interface)
+//
+// Redis does not version keys: the version is derived from a hash of the
+// stored value, which the swap compares in a Lua script.
+func (c *RedisStore) GetWithVersion(key string, ptrValue interface{}) (uint64, error) {
+	val, err := c.client.Get(key).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, ErrCacheMiss
+		}
+		return 0, err
+	}
+	if err := c.deserialize(val, ptrValue); err != nil {
+		if err == ErrNegativeHit {
+			return casVersion(val), err
+		}
+		return 0, err
+	}
+	return casVersion(val), nil
+}
+
+// CompareAndSwap (see CASStore interface)
+func (c *RedisStore) CompareAndSwap(key string, value interface{}, version uint64, expires time.Duration) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	b, err := c.serialize(value, expires)
+	if err != nil {
+		return err
+	}
+	if c.dryRun("CAS", key) {
+		return nil
+	}
+	result, err := compareAndSwapScript.Run(c.client, []string{key},
+		casVersionArg(version), b, c.expval(expires).Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if err = casResult(result); err != nil {
+		return err
+	}
+	c.publishInvalidation(key)
+	return nil
+}
@@ -0,0 +1,139 @@
+package persistence
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// ReadPolicy selects the nodes of a Redis Cluster that serve the reads of a
+// RedisStore. Reading from replicas spreads the load of Get-heavy workloads,
+// at the cost of reading values a little behind the writes.
+type ReadPolicy int
+
+const (
+	// ReadByLatency reads from the node with the lowest latency to the
+	// client, master or replica. It is the default.
+	ReadByLatency ReadPolicy = iota
+	// ReadFromMaster reads from the master of each slot only.
+	ReadFromMaster
+	// ReadFromReplicas reads from a random replica of each slot, or from its
+	// master if it has none.
+	ReadFromReplicas
+)
+
+// NewRedisCacheCluster returns a RedisStore connected to the Redis Cluster
+// whose nodes are seeded by addrs, sending reads to the nodes selected by
+// reads. Unlike
+// NewRedisCache, it uses a cluster client even with a single seed address.
+// The other fields of opts, which may be nil, and the connection settings of
+// options configure the connections, as with NewRedisCache; its Addrs,
+// MasterName, DB and routing fields are ignored.
+func NewRedisCacheCluster(addrs []string, reads ReadPolicy, opts *ClientOptions, defaultExpiration time.Duration, options ...RedisOption) (*RedisStore, error) {
+	c := NewRedisCacheFromClient(nil, defaultExpiration, options...)
+	opts, err := c.clientOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	c.client = newClusterClient(addrs, reads, opts)
+
+	if err := pingWithRetry(c.client, c.startupTimeout, c.backoff); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func newClusterClient(addrs []string, reads ReadPolicy, opts *ClientOptions) *redis.ClusterClient {
+	if opts == nil {
+		opts = &ClientOptions{}
+	}
+	clusterOpts := clusterOptions(opts)
+	clusterOpts.Addrs = addrs
+	switch reads {
+	case ReadByLatency:
+		clusterOpts.RouteByLatency = true
+	case ReadFromReplicas:
+		clusterOpts.ReadOnly = true
+	}
+	return redis.NewClusterClient(clusterOpts)
+}
+
+// clusterOptions returns the cluster client options matching opts, leaving
+// the addresses and routing unset
+func clusterOptions(opts *ClientOptions) *redis.ClusterOptions {
+	return &redis.ClusterOptions{
+		Dialer:    opts.Dialer,
+		OnConnect: opts.OnConnect,
+
+		Password: opts.Password,
+
+		MaxRedirects: opts.MaxRedirects,
+
+		MaxRetries:      opts.MaxRetries,
+		MinRetryBackoff: opts.MinRetryBackoff,
+		MaxRetryBackoff: opts.MaxRetryBackoff,
+
+		DialTimeout:        opts.DialTimeout,
+		ReadTimeout:        opts.ReadTimeout,
+		WriteTimeout:       opts.WriteTimeout,
+		PoolSize:           opts.PoolSize,
+		MinIdleConns:       opts.MinIdleConns,
+		MaxConnAge:         opts.MaxConnAge,
+		PoolTimeout:        opts.PoolTimeout,
+		IdleTimeout:        opts.IdleTimeout,
+		IdleCheckFrequency: opts.IdleCheckFrequency,
+
+		TLSConfig: opts.TLSConfig,
+	}
+}
+
+// NodeInfo describes a node of a Redis Cluster as seen by a RedisStore
+type NodeInfo struct {
+	ID     string
+	Addr   string
+	Master bool
+	// Slots are the hash slot ranges the node serves, or replicates if it
+	// is not a master
+	Slots []SlotRange
+}
+
+// SlotRange is an inclusive range of Redis Cluster hash slots
+type SlotRange struct {
+	Start int
+	End   int
+}
+
+// ClusterNodes returns the masters and replicas of the Redis Cluster the store
+// is connected to, along with their slot ranges. It is meant for diagnosing
+// routing issues such as CROSSSLOT errors. Returns ErrNotCluster if the store
+// does not use a cluster client.
+func (c *RedisStore) ClusterNodes() ([]NodeInfo, error) {
+	cluster, ok := c.client.(*redis.ClusterClient)
+	if !ok {
+		return nil, ErrNotCluster
+	}
+	return clusterNodes(cluster)
+}
+
+func clusterNodes(client interface{ ClusterSlots() *redis.ClusterSlotsCmd }) ([]NodeInfo, error) {
+	slots, err := client.ClusterSlots().Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []NodeInfo
+	index := make(map[string]int)
+	for _, slot := range slots {
+		for i, node := range slot.Nodes {
+			n, found := index[node.Addr]
+			if !found {
+				n = len(nodes)
+				index[node.Addr] = n
+				// CLUSTER SLOTS lists the master of a range first
+				nodes = append(nodes, NodeInfo{ID: node.ID, Addr: node.Addr, Master: i == 0})
+			}
+			nodes[n].Slots = append(nodes[n].Slots, SlotRange{slot.Start, slot.End})
+		}
+	}
+	return nodes, nil
+}
@@ -0,0 +1,173 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+type fakeClusterClient []redis.ClusterSlot
+
+func (f fakeClusterClient) ClusterSlots() *redis.ClusterSlotsCmd {
+	return redis.NewClusterSlotsCmdResult(f, nil)
+}
+
+func TestRedisStore_ClusterNodes(t *testing.T) {
+	client := fakeClusterClient{
+		{Start: 0, End: 8191, Nodes: []redis.ClusterNode{{ID: "m1", Addr: "10.0.0.1:6379"}, {ID: "r1", Addr: "10.0.0.2:6379"}}},
+		{Start: 8192, End: 16383, Nodes: []redis.ClusterNode{{ID: "m2", Addr: "10.0.0.3:6379"}}},
+		{Start: 16000, End: 16100, Nodes: []redis.ClusterNode{{ID: "m1", Addr: "10.0.0.1:6379"}}},
+	}
+
+	nodes, err := clusterNodes(client)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := []NodeInfo{
+		{ID: "m1", Addr: "10.0.0.1:6379", Master: true, Slots: []SlotRange{{0, 8191}, {16000, 16100}}},
+		{ID: "r1", Addr: "10.0.0.2:6379", Master: false, Slots: []SlotRange{{0, 8191}}},
+		{ID: "m2", Addr: "10.0.0.3:6379", Master: true, Slots: []SlotRange{{8192, 16383}}},
+	}
+	if !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, nodes)
+	}
+}
+
+func TestRedisStore_ClusterNodesNotCluster(t *testing.T) {
+	store := NewRedisCacheFromClient(redis.NewClient(&redis.Options{}), time.Hour)
+	if _, err := store.ClusterNodes(); err != ErrNotCluster {
+		t.Errorf("Expected ErrNotCluster, got: %v", err)
+	}
+}
+
+func TestNewRedisCacheCluster(t *testing.T) {
+	tests := []struct {
+		reads          ReadPolicy
+		routeByLatency bool
+		readOnly       bool
+	}{
+		{ReadByLatency, true, true},
+		{ReadFromMaster, false, false},
+		{ReadFromReplicas, false, true},
+	}
+	for _, test := range tests {
+		client := newClusterClient([]string{redisTestServer}, test.reads, nil)
+		opts := client.Options()
+		if opts.RouteByLatency != test.routeByLatency || opts.ReadOnly != test.readOnly {
+			t.Errorf("Expected RouteByLatency %v and ReadOnly %v for policy %d, got %v and %v",
+				test.routeByLatency, test.readOnly, test.reads, opts.RouteByLatency, opts.ReadOnly)
+		}
+		client.Close()
+	}
+
+	// A single seed address still makes a cluster client
+	store, err := NewRedisCacheCluster([]string{redisTestServer}, ReadFromMaster, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("Error connecting to the cluster: %s", err)
+	}
+	if _, err := store.ClusterNodes(); err != nil {
+		t.Errorf("Expected a cluster client, got: %v", err)
+	}
+	if err := store.Set("cluster", "value", DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	var value string
+	if err := store.Get("cluster", &value); err != nil || value != "value" {
+		t.Errorf("Expected to read the value, got %q (%v)", value, err)
+	}
+
+	// Flush scans every master
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+	if err := store.Get("cluster", &value); err != ErrCacheMiss {
+		t.Errorf("Expected the value to be flushed, got: %v", err)
+	}
+}
+
+// rejectHook fails the commands for which it returns an error, to reproduce
+// the errors of a cluster with a single test server
+type rejectHook func(cmd redis.Cmder) error
+
+func (h rejectHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, h(cmd)
+}
+
+func (h rejectHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h rejectHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	for _, cmd := range cmds {
+		if err := h(cmd); err != nil {
+			return ctx, err
+		}
+	}
+	return ctx, nil
+}
+
+func (h rejectHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// crossSlotHook fails the multi-key commands a cluster rejects when their
+// keys hash to different slots, which a single test server does not
+var crossSlotHook = rejectHook(func(cmd redis.Cmder) error {
+	if name := cmd.Name(); (name == "mget" || name == "mset") && len(cmd.Args()) > 2 {
+		return errors.New("CROSSSLOT Keys in request don't hash to the same slot")
+	}
+	return nil
+})
+
+func TestRedisCacheCluster_MultiCrossSlot(t *testing.T) {
+	store, err := NewRedisCacheCluster([]string{redisTestServer}, ReadFromMaster, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("Error connecting to the cluster: %s", err)
+	}
+	store.client.(*redis.ClusterClient).AddHook(crossSlotHook)
+
+	// a and b hash to different slots
+	err = store.SetMulti(map[string]Item{"a": {Value: 1}, "b": {Value: 2}})
+	if err != nil {
+		t.Fatalf("Error setting values: %s", err)
+	}
+	store.Delete("missing")
+	var a, b, missing int
+	found, err := store.GetMulti([]string{"a", "missing", "b"}, []interface{}{&a, &missing, &b})
+	if err != nil {
+		t.Fatalf("Error getting values: %s", err)
+	}
+	if !found[0] || found[1] || !found[2] || a != 1 || b != 2 {
+		t.Errorf("Expected a and b to be found, got %v, %d, %d", found, a, b)
+	}
+}
+
+func TestRedisCacheCluster_Export(t *testing.T) {
+	store, err := NewRedisCacheCluster([]string{redisTestServer}, ReadFromMaster, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("Error connecting to the cluster: %s", err)
+	}
+	store.Set("hot:1", "one", time.Hour)
+	// SCAN through the cluster client only reaches one of its nodes
+	store.client.(*redis.ClusterClient).AddHook(rejectHook(func(cmd redis.Cmder) error {
+		if cmd.Name() == "scan" {
+			return errors.New("scanned a single node")
+		}
+		return nil
+	}))
+
+	var dump bytes.Buffer
+	if err := store.Export(&dump, "hot:*"); err != nil {
+		t.Fatalf("Error exporting: %s", err)
+	}
+	var entry dumpEntry
+	if err := gob.NewDecoder(&dump).Decode(&entry); err != nil || entry.Key != "hot:1" {
+		t.Errorf("Expected hot:1 to be exported, got %q, %v", entry.Key, err)
+	}
+}
@@ -0,0 +1,58 @@
+package persistence
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// MonitorEvictions subscribes to the evicted keyevent notifications of Redis
+// database db, so keys dropped because of memory pressure can be told apart
+// from keys that simply expired. Only keys starting with prefix are reported
+// (an empty prefix matches every key): each one increments the counter
+// returned by Evictions and is passed to fn, which may be nil.
+//
+// Redis only publishes these events if keyspace notifications are enabled for
+// evictions, e.g. with "CONFIG SET notify-keyspace-events Ee".
+//
+// Calling stop closes the subscription and waits for the monitor to exit.
+func (c *RedisStore) MonitorEvictions(db int, prefix string, fn func(key string)) (stop func(), err error) {
+	pubsub := c.client.Subscribe(fmt.Sprintf("__keyevent@%d__:evicted", db))
+	if _, err = pubsub.Receive(); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.watchEvictions(pubsub.Channel(), prefix, fn)
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { pubsub.Close() })
+		wg.Wait()
+	}, nil
+}
+
+// Evictions returns the number of evictions observed by MonitorEvictions
+func (c *RedisStore) Evictions() uint64 {
+	return atomic.LoadUint64(&c.evictions)
+}
+
+func (c *RedisStore) watchEvictions(ch <-chan *redis.Message, prefix string, fn func(key string)) {
+	for msg := range ch {
+		if !strings.HasPrefix(msg.Payload, prefix) {
+			continue
+		}
+		atomic.AddUint64(&c.evictions, 1)
+		if fn != nil {
+			fn(msg.Payload)
+		}
+	}
+}
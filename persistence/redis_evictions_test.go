@@ -0,0 +1,58 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+func TestRedisStore_WatchEvictions(t *testing.T) {
+	store := NewRedisCacheFromClient(nil, time.Hour)
+
+	// A fake subscriber delivering evicted keyevent notifications
+	ch := make(chan *redis.Message, 3)
+	ch <- &redis.Message{Channel: "__keyevent@0__:evicted", Payload: "app:1"}
+	ch <- &redis.Message{Channel: "__keyevent@0__:evicted", Payload: "other:1"}
+	ch <- &redis.Message{Channel: "__keyevent@0__:evicted", Payload: "app:2"}
+	close(ch)
+
+	var evicted []string
+	store.watchEvictions(ch, "app:", func(key string) {
+		evicted = append(evicted, key)
+	})
+
+	if n := store.Evictions(); n != 2 {
+		t.Errorf("Expected 2 evictions, got %d", n)
+	}
+	if len(evicted) != 2 || evicted[0] != "app:1" || evicted[1] != "app:2" {
+		t.Errorf("Expected app:1 and app:2 to be reported, got %v", evicted)
+	}
+}
+
+func TestRedisCache_MonitorEvictions(t *testing.T) {
+	redisCache := newRedisStore(t, time.Hour).(*RedisStore)
+
+	evicted := make(chan string, 1)
+	stop, err := redisCache.MonitorEvictions(0, "app:", func(key string) {
+		evicted <- key
+	})
+	if err != nil {
+		t.Fatalf("Error monitoring evictions: %s", err)
+	}
+	defer stop()
+
+	// Publish the notification Redis would send when evicting app:1
+	redisCache.client.Publish("__keyevent@0__:evicted", "app:1")
+	select {
+	case key := <-evicted:
+		if key != "app:1" {
+			t.Errorf("Expected app:1 to be reported, got %s", key)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timed out waiting for the eviction")
+	}
+	if n := redisCache.Evictions(); n != 1 {
+		t.Errorf("Expected 1 eviction, got %d", n)
+	}
+}
@@ -0,0 +1,63 @@
+package persistence
+
+import (
+	"strings"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// RedisCuckooFilter is a KeyFilter kept in Redis as a cuckoo filter of the
+// RedisBloom module, so that the instances of a service share it
+type RedisCuckooFilter struct {
+	client   redis.UniversalClient
+	name     string
+	capacity int64
+}
+
+// NewRedisCuckooFilter returns a RedisCuckooFilter stored at name in the
+// Redis of store, creating it to hold capacity keys unless it exists. It
+// fails if Redis does not have the RedisBloom module.
+func NewRedisCuckooFilter(store *RedisStore, name string, capacity int64) (*RedisCuckooFilter, error) {
+	f := &RedisCuckooFilter{client: store.client, name: name, capacity: capacity}
+	if err := f.reserve(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// reserve creates the filter, unless it exists
+func (f *RedisCuckooFilter) reserve() error {
+	err := f.client.Do("CF.RESERVE", f.name, f.capacity).Err()
+	if err != nil && strings.Contains(err.Error(), "exists") {
+		return nil
+	}
+	return err
+}
+
+// Add (see KeyFilter interface)
+func (f *RedisCuckooFilter) Add(key string) error {
+	return f.client.Do("CF.ADD", f.name, key).Err()
+}
+
+// Remove (see KeyFilter interface)
+func (f *RedisCuckooFilter) Remove(key string) error {
+	err := f.client.Do("CF.DEL", f.name, key).Err()
+	if err != nil && strings.Contains(strings.ToLower(err.Error()), "not found") {
+		return nil
+	}
+	return err
+}
+
+// MayContain (see KeyFilter interface)
+func (f *RedisCuckooFilter) MayContain(key string) (bool, error) {
+	exists, err := f.client.Do("CF.EXISTS", f.name, key).Int64()
+	return exists == 1, err
+}
+
+// Reset (see KeyFilter interface)
+func (f *RedisCuckooFilter) Reset() error {
+	if err := f.client.Del(f.name).Err(); err != nil {
+		return err
+	}
+	return f.reserve()
+}
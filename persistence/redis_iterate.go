@@ -0,0 +1,41 @@
+package persistence
+
+import "github.com/go-redis/redis/v7"
+
+// Keys (see IterableStore interface)
+func (c *RedisStore) Keys(prefix string) ([]string, error) {
+	return collectKeys(c, prefix)
+}
+
+// Iterate (see IterableStore interface)
+//
+// Keys are found with SCAN, on every master of a cluster, and their TTL read
+// by batches. The keys the store uses for its own bookkeeping, such as the
+// sets of tagged keys, are listed too when they start with prefix.
+func (c *RedisStore) Iterate(prefix string, fn func(key string, meta Meta) bool) error {
+	err := c.scanKeys(globEscaper.Replace(prefix)+"*", func(node redis.Cmdable, keys []string) error {
+		pipe := node.Pipeline()
+		pttls := make([]*redis.DurationCmd, len(keys))
+		for i, key := range keys {
+			pttls[i] = pipe.PTTL(key)
+		}
+		if _, err := pipe.Exec(); err != nil {
+			return err
+		}
+		for i, key := range keys {
+			pttl := pttls[i].Val()
+			if pttl == -2 {
+				// Deleted since the scan found it
+				continue
+			}
+			if !fn(key, Meta{TTL: redisTTL(pttl)}) {
+				return errStopIteration
+			}
+		}
+		return nil
+	})
+	if err == errStopIteration {
+		return nil
+	}
+	return err
+}
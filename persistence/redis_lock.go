@@ -0,0 +1,41 @@
+package persistence
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+var unlockScript = redis.NewScript(unlockSource)
+
+// TryLock (see LockStore interface)
+//
+// The lock is a key set with SET NX PX, holding a random token.
+func (c *RedisStore) TryLock(key string, ttl time.Duration) (token string, acquired bool, err error) {
+	if c.readOnly {
+		return "", false, ErrReadOnly
+	}
+	token, err = newLockToken()
+	if err != nil {
+		return "", false, err
+	}
+	if c.dryRun("LOCK", key) {
+		return token, true, nil
+	}
+	acquired, err = c.client.SetNX(key, token, ttl).Result()
+	if err != nil || !acquired {
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+// Unlock (see LockStore interface)
+func (c *RedisStore) Unlock(key, token string) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if c.dryRun("UNLOCK", key) {
+		return nil
+	}
+	return unlockScript.Run(c.client, []string{key}, token).Err()
+}
@@ -0,0 +1,153 @@
+package persistence
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultKeyAgeBuckets are the bucket upper bounds used by NewKeyAgeCollector
+// when none are given
+var DefaultKeyAgeBuckets = []time.Duration{
+	time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour,
+}
+
+// KeyAgeCollector is a prometheus.Collector exposing how long the keys of a
+// RedisStore have been idle and how long they have left to live, as
+// cumulative buckets labelled with their upper bound in seconds ("le").
+//
+// Computing the distribution requires scanning the keyspace, so it is not
+// done on scrape: Sample refreshes the gauges, and Run calls it on an
+// interval. Only a fraction of the keys may be sampled, in which case the
+// gauges hold the estimated number of keys.
+type KeyAgeCollector struct {
+	store      *RedisStore
+	pattern    string
+	sampleRate float64
+	buckets    []time.Duration
+
+	idle *prometheus.GaugeVec
+	ttl  *prometheus.GaugeVec
+}
+
+// NewKeyAgeCollector returns a KeyAgeCollector sampling the keys of store
+// matching pattern, each with probability sampleRate (between 0 and 1)
+func NewKeyAgeCollector(store *RedisStore, pattern string, sampleRate float64, buckets []time.Duration) *KeyAgeCollector {
+	if len(buckets) == 0 {
+		buckets = DefaultKeyAgeBuckets
+	}
+	return &KeyAgeCollector{
+		store:      store,
+		pattern:    pattern,
+		sampleRate: sampleRate,
+		buckets:    buckets,
+		idle: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "gincontrib",
+			Subsystem: "cache",
+			Name:      "key_idle_seconds",
+			Help:      "Estimated number of keys idle for at most le seconds.",
+		}, []string{"le"}),
+		ttl: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "gincontrib",
+			Subsystem: "cache",
+			Name:      "key_ttl_seconds",
+			Help:      "Estimated number of keys expiring in at most le seconds.",
+		}, []string{"le"}),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (k *KeyAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	k.idle.Describe(ch)
+	k.ttl.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (k *KeyAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	k.idle.Collect(ch)
+	k.ttl.Collect(ch)
+}
+
+// Run calls Sample every interval until stop is closed
+func (k *KeyAgeCollector) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		k.Sample()
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Sample scans the keys matching the collector's pattern and updates the
+// gauges from their idle time and remaining time to live. Keys without an
+// expiration are only counted in the "+Inf" TTL bucket.
+func (k *KeyAgeCollector) Sample() error {
+	idle := make([]float64, len(k.buckets)+1)
+	ttl := make([]float64, len(k.buckets)+1)
+	weight := 1 / k.sampleRate
+
+	client := k.store.client
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(cursor, k.pattern, dumpBatchSize).Result()
+		if err != nil {
+			return err
+		}
+
+		pipe := client.Pipeline()
+		idles := make([]*redis.DurationCmd, 0, len(keys))
+		ttls := make([]*redis.DurationCmd, 0, len(keys))
+		for _, key := range keys {
+			if rand.Float64() < k.sampleRate {
+				idles = append(idles, pipe.ObjectIdleTime(key))
+				ttls = append(ttls, pipe.PTTL(key))
+			}
+		}
+		// Keys that vanished since the scan report errors and are skipped
+		pipe.Exec()
+		pipe.Close()
+		for i := range idles {
+			if d, err := idles[i].Result(); err == nil {
+				k.count(idle, d, weight)
+			}
+			if d, err := ttls[i].Result(); err == nil {
+				if d < 0 {
+					d = math.MaxInt64
+				}
+				k.count(ttl, d, weight)
+			}
+		}
+
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+
+	for i, bound := range k.buckets {
+		le := strconv.FormatFloat(bound.Seconds(), 'g', -1, 64)
+		k.idle.WithLabelValues(le).Set(idle[i])
+		k.ttl.WithLabelValues(le).Set(ttl[i])
+	}
+	k.idle.WithLabelValues("+Inf").Set(idle[len(k.buckets)])
+	k.ttl.WithLabelValues("+Inf").Set(ttl[len(k.buckets)])
+	return nil
+}
+
+// count adds weight to every cumulative bucket d falls into
+func (k *KeyAgeCollector) count(counts []float64, d time.Duration, weight float64) {
+	for i, bound := range k.buckets {
+		if d <= bound {
+			counts[i] += weight
+		}
+	}
+	counts[len(k.buckets)] += weight
+}
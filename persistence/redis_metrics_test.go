@@ -0,0 +1,36 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRedisCache_KeyAgeCollector(t *testing.T) {
+	redisCache := newRedisStore(t, time.Hour).(*RedisStore)
+
+	redisCache.Set("app:a", 1, 30*time.Second)
+	redisCache.Set("app:b", 1, 10*time.Minute)
+	redisCache.Set("app:c", 1, 2*time.Hour)
+	redisCache.Set("app:d", 1, FOREVER)
+	redisCache.Set("other", 1, 30*time.Second)
+
+	collector := NewKeyAgeCollector(redisCache, "app:*", 1, []time.Duration{time.Minute, time.Hour})
+	if err := collector.Sample(); err != nil {
+		t.Fatalf("Error sampling: %s", err)
+	}
+
+	for le, expected := range map[string]float64{"60": 1, "3600": 2, "+Inf": 4} {
+		if n := testutil.ToFloat64(collector.ttl.WithLabelValues(le)); n != expected {
+			t.Errorf("Expected %v keys expiring within le=%s, got %v", expected, le, n)
+		}
+	}
+	// The keys were just written
+	if n := testutil.ToFloat64(collector.idle.WithLabelValues("60")); n != 4 {
+		t.Errorf("Expected 4 keys idle for less than a minute, got %v", n)
+	}
+	if n := testutil.CollectAndCount(collector); n != 6 {
+		t.Errorf("Expected 6 metrics, got %d", n)
+	}
+}
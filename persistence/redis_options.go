@@ -0,0 +1,150 @@
+package persistence
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// ErrInvalidOptions is wrapped by the errors NewRedisCache returns for
+// connection settings that are out of range or contradict each other
+var ErrInvalidOptions = errors.New("cache: invalid options.")
+
+// The connection settings NewRedisCache applies when neither its
+// ClientOptions nor its options set them. Operations give up within seconds
+// instead of hanging on an unresponsive server, and are retried a few times
+// on network errors, which a failover or a dropped connection causes.
+const (
+	defaultRedisDialTimeout = 5 * time.Second
+	defaultRedisReadTimeout = 3 * time.Second
+	defaultRedisMaxRetries  = 3
+)
+
+// WithTLS connects to Redis over TLS with config
+func WithTLS(config *tls.Config) RedisOption {
+	return func(c *RedisStore) {
+		c.connection = append(c.connection, func(o *redis.UniversalOptions) {
+			o.TLSConfig = config
+		})
+	}
+}
+
+// WithPoolSize bounds the connections opened to each Redis server. The
+// default is 10 per CPU.
+func WithPoolSize(size int) RedisOption {
+	return func(c *RedisStore) {
+		c.connection = append(c.connection, func(o *redis.UniversalOptions) {
+			o.PoolSize = size
+		})
+	}
+}
+
+// WithMinIdleConns keeps n idle connections open to each Redis server, so
+// that bursts do not wait for new ones. It must not exceed the pool size.
+func WithMinIdleConns(n int) RedisOption {
+	return func(c *RedisStore) {
+		c.connection = append(c.connection, func(o *redis.UniversalOptions) {
+			o.MinIdleConns = n
+		})
+	}
+}
+
+// WithDialTimeout bounds the time taken to connect to Redis. The default is
+// 5 seconds.
+func WithDialTimeout(timeout time.Duration) RedisOption {
+	return func(c *RedisStore) {
+		c.connection = append(c.connection, func(o *redis.UniversalOptions) {
+			o.DialTimeout = timeout
+		})
+	}
+}
+
+// WithReadTimeout bounds the time waited for the reply of Redis to a
+// command, -1 for no timeout. The default is 3 seconds.
+func WithReadTimeout(timeout time.Duration) RedisOption {
+	return func(c *RedisStore) {
+		c.connection = append(c.connection, func(o *redis.UniversalOptions) {
+			o.ReadTimeout = timeout
+		})
+	}
+}
+
+// WithWriteTimeout bounds the time taken to send a command to Redis, -1 for
+// no timeout. The default is the read timeout.
+func WithWriteTimeout(timeout time.Duration) RedisOption {
+	return func(c *RedisStore) {
+		c.connection = append(c.connection, func(o *redis.UniversalOptions) {
+			o.WriteTimeout = timeout
+		})
+	}
+}
+
+// WithRetries retries commands failing with network errors up to
+// maxRetries times, waiting between minBackoff and maxBackoff, growing
+// exponentially, before each retry. A maxRetries of 0 disables retries; the
+// default is 3 retries, waiting from 8ms to 512ms.
+//
+// The backoff of the features of the store retrying on their own is set by
+// WithBackoff instead.
+func WithRetries(maxRetries int, minBackoff, maxBackoff time.Duration) RedisOption {
+	return func(c *RedisStore) {
+		c.connection = append(c.connection, func(o *redis.UniversalOptions) {
+			o.MaxRetries = maxRetries
+			o.MinRetryBackoff, o.MaxRetryBackoff = minBackoff, maxBackoff
+		})
+	}
+}
+
+// clientOptions returns opts, which may be nil, with the connection
+// settings of the options of c and the defaults applied, or an error
+// wrapping ErrInvalidOptions if they are invalid
+func (c *RedisStore) clientOptions(opts *ClientOptions) (*ClientOptions, error) {
+	var o redis.UniversalOptions
+	if opts != nil {
+		o = redis.UniversalOptions(*opts)
+	}
+	// Options may disable retries with 0, so the default is applied first
+	if o.MaxRetries == 0 {
+		o.MaxRetries = defaultRedisMaxRetries
+	}
+	for _, apply := range c.connection {
+		apply(&o)
+	}
+	if o.DialTimeout == 0 {
+		o.DialTimeout = defaultRedisDialTimeout
+	}
+	if o.ReadTimeout == 0 {
+		o.ReadTimeout = defaultRedisReadTimeout
+	}
+	if err := validateClientOptions(&o); err != nil {
+		return nil, err
+	}
+	return (*ClientOptions)(&o), nil
+}
+
+func validateClientOptions(o *redis.UniversalOptions) error {
+	switch {
+	case o.PoolSize < 0:
+		return fmt.Errorf("%w pool size %d is negative", ErrInvalidOptions, o.PoolSize)
+	case o.MinIdleConns < 0:
+		return fmt.Errorf("%w min idle connections %d is negative", ErrInvalidOptions, o.MinIdleConns)
+	case o.PoolSize > 0 && o.MinIdleConns > o.PoolSize:
+		return fmt.Errorf("%w min idle connections %d exceed the pool size %d", ErrInvalidOptions, o.MinIdleConns, o.PoolSize)
+	case o.DialTimeout < 0:
+		return fmt.Errorf("%w dial timeout %v is negative", ErrInvalidOptions, o.DialTimeout)
+	case o.ReadTimeout < -1 || o.WriteTimeout < -1:
+		return fmt.Errorf("%w read and write timeouts must be positive, or -1 for none", ErrInvalidOptions)
+	case o.MaxRetries < 0:
+		return fmt.Errorf("%w max retries %d is negative", ErrInvalidOptions, o.MaxRetries)
+	case o.MinRetryBackoff < -1 || o.MaxRetryBackoff < -1:
+		return fmt.Errorf("%w retry backoffs must be positive, or -1 for none", ErrInvalidOptions)
+	case o.MinRetryBackoff > 0 && o.MaxRetryBackoff > 0 && o.MinRetryBackoff > o.MaxRetryBackoff:
+		return fmt.Errorf("%w min retry backoff %v exceeds the max retry backoff %v", ErrInvalidOptions, o.MinRetryBackoff, o.MaxRetryBackoff)
+	case o.TLSConfig != nil && o.TLSConfig.InsecureSkipVerify && o.TLSConfig.ServerName != "":
+		return fmt.Errorf("%w TLS server name %q is not verified with InsecureSkipVerify", ErrInvalidOptions, o.TLSConfig.ServerName)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package persistence
+
+import (
+	"strings"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// globEscaper escapes the characters Redis interprets in SCAN patterns
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// DeletePrefix (see PrefixStore interface)
+//
+// Keys are found with SCAN, on every master of a cluster, and deleted by
+// batches as the scan goes.
+func (c *RedisStore) DeletePrefix(prefix string) error {
+	return c.InvalidatePattern(globEscaper.Replace(prefix) + "*")
+}
+
+// InvalidatePattern (see PatternStore interface)
+//
+// Keys are found with SCAN MATCH, on every master of a cluster, and deleted
+// by batches as the scan goes.
+func (c *RedisStore) InvalidatePattern(pattern string) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	return c.scanKeys(pattern, func(node redis.Cmdable, keys []string) error {
+		if c.dryRun("DEL", keys...) {
+			return nil
+		}
+		if err := deleteKeys(node, keys); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			c.publishInvalidation(key)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,92 @@
+package persistence
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+)
+
+// Payload markers of the messages published by PubSubInvalidator, following
+// the sender id
+const (
+	invalidateKeyMarker = "|k"
+	invalidateAllMarker = "|*"
+)
+
+// PubSubInvalidator broadcasts invalidated keys to the other instances of a
+// service over a Redis pub/sub channel, so that each can evict its local copy.
+// Unlike WithInvalidationStream, messages sent while an instance is
+// disconnected are lost, which is fine for short lived local copies.
+type PubSubInvalidator struct {
+	store   *RedisStore
+	channel string
+	// id tells the messages of this instance apart, so it does not evict what
+	// it just wrote
+	id string
+}
+
+// NewPubSubInvalidator returns a PubSubInvalidator publishing on channel
+// through the Redis connection of store
+func NewPubSubInvalidator(store *RedisStore, channel string) *PubSubInvalidator {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &PubSubInvalidator{store: store, channel: channel, id: hex.EncodeToString(id)}
+}
+
+// Invalidate tells the other instances to evict key
+func (p *PubSubInvalidator) Invalidate(key string) error {
+	return p.publish(invalidateKeyMarker + key)
+}
+
+// InvalidateAll tells the other instances to evict every key
+func (p *PubSubInvalidator) InvalidateAll() error {
+	return p.publish(invalidateAllMarker)
+}
+
+func (p *PubSubInvalidator) publish(payload string) error {
+	if p.store.dryRun("PUBLISH", p.channel) {
+		return nil
+	}
+	return p.store.client.Publish(p.channel, p.id+payload).Err()
+}
+
+// Subscribe calls evict for every key invalidated by another instance, and
+// evictAll when another instance invalidates every key.
+//
+// Calling stop closes the subscription and waits for the subscriber to exit.
+func (p *PubSubInvalidator) Subscribe(evict func(key string), evictAll func()) (stop func(), err error) {
+	pubsub := p.store.client.Subscribe(p.channel)
+	if _, err = pubsub.Receive(); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for msg := range pubsub.Channel() {
+			payload := msg.Payload
+			if strings.HasPrefix(payload, p.id) {
+				continue
+			}
+			i := strings.IndexByte(payload, '|')
+			if i < 0 {
+				continue
+			}
+			switch payload = payload[i:]; {
+			case strings.HasPrefix(payload, invalidateKeyMarker):
+				evict(payload[len(invalidateKeyMarker):])
+			case payload == invalidateAllMarker:
+				evictAll()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { pubsub.Close() })
+		wg.Wait()
+	}, nil
+}
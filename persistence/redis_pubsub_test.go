@@ -0,0 +1,78 @@
+package persistence
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// eventually polls cond until it holds or a few seconds have passed
+func eventually(cond func() bool) bool {
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+func TestRedisCache_TieredInvalidation(t *testing.T) {
+	remote := newRedisStore(t, time.Hour).(*RedisStore)
+	localA, localB := NewInMemoryStore(time.Hour), NewInMemoryStore(time.Hour)
+	a := NewTieredStore(localA, remote, 0)
+	b := NewTieredStore(localB, remote, 0)
+	for _, store := range []*TieredStore{a, b} {
+		stop, err := store.BroadcastInvalidations(NewPubSubInvalidator(remote, "invalidations"))
+		if err != nil {
+			t.Fatalf("Error subscribing: %s", err)
+		}
+		defer stop()
+	}
+
+	a.Set("key", "v1", DEFAULT)
+	var s string
+	if err := b.Get("key", &s); err != nil || s != "v1" {
+		t.Fatalf("Expected v1, got %s, %v", s, err)
+	}
+
+	a.Set("key", "v2", DEFAULT)
+	if !eventually(func() bool { return localB.Get("key", &s) == ErrCacheMiss }) {
+		t.Errorf("Expected the local copy of the other instance to be evicted")
+	}
+	if err := b.Get("key", &s); err != nil || s != "v2" {
+		t.Errorf("Expected v2, got %s, %v", s, err)
+	}
+	if err := localA.Get("key", &s); err != nil || s != "v2" {
+		t.Errorf("Expected the writer to keep its local copy, got %s, %v", s, err)
+	}
+}
+
+func TestRedisCache_InvalidatingStore(t *testing.T) {
+	remote := newRedisStore(t, time.Hour).(*RedisStore)
+	localA, localB := NewInMemoryStore(time.Hour), NewInMemoryStore(time.Hour)
+	a, err := NewInvalidatingStore(localA, NewPubSubInvalidator(remote, "invalidations"))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err)
+	}
+	defer a.Close(context.Background())
+	b, err := NewInvalidatingStore(localB, NewPubSubInvalidator(remote, "invalidations"))
+	if err != nil {
+		t.Fatalf("Error subscribing: %s", err)
+	}
+	defer b.Close(context.Background())
+
+	a.Set("key", "from a", DEFAULT)
+	b.Set("key", "from b", DEFAULT)
+	var s string
+	if !eventually(func() bool { return localA.Get("key", &s) == ErrCacheMiss }) {
+		t.Errorf("Expected the value set by a to be evicted")
+	}
+
+	b.Set("other", "from b", DEFAULT)
+	a.Flush()
+	if !eventually(func() bool { return localB.Get("other", &s) == ErrCacheMiss }) {
+		t.Errorf("Expected Flush to be broadcast")
+	}
+}
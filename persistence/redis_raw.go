@@ -0,0 +1,44 @@
+package persistence
+
+import (
+	"github.com/go-redis/redis/v7"
+)
+
+var (
+	appendScript  = redis.NewScript(appendSource)
+	prependScript = redis.NewScript(prependSource)
+)
+
+// Append (see RawStore interface)
+//
+// It sends APPEND in a Lua script checking that the key exists, as APPEND
+// creates missing keys.
+func (c *RedisStore) Append(key string, data []byte) error {
+	return c.runRawScript(appendScript, "APPEND", key, data)
+}
+
+// Prepend (see RawStore interface)
+//
+// Redis has no such command: the value is rewritten in a Lua script, which
+// keeps its time to live.
+func (c *RedisStore) Prepend(key string, data []byte) error {
+	return c.runRawScript(prependScript, "PREPEND", key, data)
+}
+
+func (c *RedisStore) runRawScript(script *redis.Script, op, key string, data []byte) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if c.dryRun(op, key) {
+		return nil
+	}
+	stored, err := script.Run(c.client, []string{key}, data).Int64()
+	if err != nil {
+		return err
+	}
+	if err := rawResult(stored); err != nil {
+		return err
+	}
+	c.publishInvalidation(key)
+	return nil
+}
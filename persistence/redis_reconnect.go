@@ -0,0 +1,99 @@
+package persistence
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
+// WithLazyConnect makes NewRedisCache return the store without waiting for
+// Redis to be reachable, so that the application can boot while it is down.
+// Operations fail meanwhile, which CachePage serves as misses. After a failed
+// connection, new ones to the same address are only attempted once the
+// store's Backoff has elapsed, see WithBackoff: until then operations on it
+// fail fast with ErrCacheUnavailable, instead of each waiting for the dial
+// timeout. The nodes of a cluster back off independently.
+func WithLazyConnect() RedisOption {
+	return func(c *RedisStore) {
+		c.lazyConnect = true
+	}
+}
+
+// WithOnReconnect sets a function called, in its own goroutine, each time
+// NewRedisCache's store connects to Redis after having failed to, e.g. to
+// warm the cache up or to clear a degraded mode. Connections are retried
+// with the backoff of WithLazyConnect.
+func WithOnReconnect(onReconnect func()) RedisOption {
+	return func(c *RedisStore) {
+		c.onReconnect = onReconnect
+	}
+}
+
+// reconnector dials the connections of a store, backing off after failures
+// to connect to each address
+type reconnector struct {
+	dial        func(ctx context.Context, network, addr string) (net.Conn, error)
+	backoff     Backoff
+	onReconnect func()
+
+	mu    sync.Mutex
+	addrs map[string]*dialState
+}
+
+// dialState is the backoff state of the connections to an address
+type dialState struct {
+	failures int
+	retryAt  time.Time
+}
+
+// newReconnector returns a reconnector dialing with the Dialer of opts, or
+// like go-redis does if it has none
+func newReconnector(opts *ClientOptions, backoff Backoff, onReconnect func()) *reconnector {
+	dial := opts.Dialer
+	if dial == nil {
+		timeout := opts.DialTimeout
+		if timeout == 0 {
+			timeout = 5 * time.Second
+		}
+		dialer := &net.Dialer{Timeout: timeout, KeepAlive: 5 * time.Minute}
+		dial = dialer.DialContext
+		if opts.TLSConfig != nil {
+			tlsDialer := &tls.Dialer{NetDialer: dialer, Config: opts.TLSConfig}
+			dial = tlsDialer.DialContext
+		}
+	}
+	return &reconnector{dial: dial, backoff: backoff, onReconnect: onReconnect, addrs: make(map[string]*dialState)}
+}
+
+// Dial connects to addr unless the last connection to it failed and the
+// backoff has not elapsed since, in which case it returns ErrCacheUnavailable
+func (r *reconnector) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	r.mu.Lock()
+	state, ok := r.addrs[addr]
+	if !ok {
+		state = &dialState{}
+		r.addrs[addr] = state
+	}
+	backingOff := state.failures > 0 && time.Now().Before(state.retryAt)
+	r.mu.Unlock()
+	if backingOff {
+		return nil, ErrCacheUnavailable
+	}
+
+	conn, err := r.dial(ctx, network, addr)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		state.retryAt = time.Now().Add(r.backoff.NextDelay(state.failures))
+		state.failures++
+		return nil, err
+	}
+	if state.failures > 0 && r.onReconnect != nil {
+		go r.onReconnect()
+	}
+	state.failures = 0
+	return conn, nil
+}
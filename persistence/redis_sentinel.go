@@ -0,0 +1,146 @@
+package persistence
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+var errNoReplica = errors.New("cache: no replica available.")
+
+// NewRedisCacheSentinel returns a RedisStore connected to the master named
+// masterName, as reported by the Sentinel servers of addrs. The store follows
+// the master when Sentinel fails it over. The other fields of opts, which may
+// be nil, configure the connections; its Addrs and MasterName are ignored.
+func NewRedisCacheSentinel(masterName string, addrs []string, opts *ClientOptions, defaultExpiration time.Duration, options ...RedisOption) (*RedisStore, error) {
+	uniopts := redis.UniversalOptions{}
+	if opts != nil {
+		uniopts = redis.UniversalOptions(*opts)
+	}
+	uniopts.MasterName = masterName
+	uniopts.Addrs = addrs
+	return NewRedisCache((*ClientOptions)(&uniopts), defaultExpiration, options...)
+}
+
+// NewRedisCacheSentinelReplica returns a read-only RedisStore reading from
+// the replicas of the master named masterName, as reported by the Sentinel
+// servers of addrs, to take Get-heavy workloads off the master. Each
+// connection is opened to a replica picked at random among those Sentinel
+// does not consider down. Writes return ErrReadOnly: write through a store
+// returned by NewRedisCacheSentinel.
+func NewRedisCacheSentinelReplica(masterName string, addrs []string, opts *ClientOptions, defaultExpiration time.Duration, options ...RedisOption) (*RedisStore, error) {
+	uniopts := redis.UniversalOptions{}
+	if opts != nil {
+		uniopts = redis.UniversalOptions(*opts)
+	}
+	resolver := newReplicaResolver(masterName, addrs, &uniopts)
+	uniopts.Addrs = []string{masterName}
+	uniopts.MasterName = ""
+	uniopts.Dialer = resolver.dial
+	options = append(options, WithReadOnly(true))
+	return NewRedisCache((*ClientOptions)(&uniopts), defaultExpiration, options...)
+}
+
+// replicaResolver opens connections to the replicas of a master, asking
+// Sentinel servers for their addresses
+type replicaResolver struct {
+	masterName string
+	sentinels  []*redis.SentinelClient
+	dialer     *net.Dialer
+	tlsConfig  *tls.Config
+}
+
+func newReplicaResolver(masterName string, addrs []string, opts *redis.UniversalOptions) *replicaResolver {
+	r := &replicaResolver{
+		masterName: masterName,
+		dialer:     &net.Dialer{Timeout: opts.DialTimeout, KeepAlive: 5 * time.Minute},
+		tlsConfig:  opts.TLSConfig,
+	}
+	for _, addr := range addrs {
+		r.sentinels = append(r.sentinels, redis.NewSentinelClient(&redis.Options{
+			Addr:         addr,
+			Dialer:       opts.Dialer,
+			MaxRetries:   opts.MaxRetries,
+			DialTimeout:  opts.DialTimeout,
+			ReadTimeout:  opts.ReadTimeout,
+			WriteTimeout: opts.WriteTimeout,
+			PoolSize:     1,
+			TLSConfig:    opts.TLSConfig,
+		}))
+	}
+	return r
+}
+
+// dial opens a connection to a replica, ignoring addr
+func (r *replicaResolver) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	replica, err := r.replicaAddr()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := r.dialer.DialContext(ctx, network, replica)
+	if err != nil || r.tlsConfig == nil {
+		return conn, err
+	}
+	config := r.tlsConfig
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName, _, _ = net.SplitHostPort(replica)
+	}
+	return tls.Client(conn, config), nil
+}
+
+// replicaAddr returns the address of a replica picked at random, asking the
+// Sentinel servers in turn until one answers
+func (r *replicaResolver) replicaAddr() (string, error) {
+	lastErr := errNoReplica
+	for _, sentinel := range r.sentinels {
+		replicas, err := sentinel.Slaves(r.masterName).Result()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if addrs := healthyReplicas(replicas); len(addrs) > 0 {
+			return addrs[rand.Intn(len(addrs))], nil
+		}
+	}
+	return "", lastErr
+}
+
+// healthyReplicas returns the addresses of the replicas listed by SENTINEL
+// SLAVES that are neither down nor disconnected
+func healthyReplicas(replicas []interface{}) []string {
+	var addrs []string
+	for _, replica := range replicas {
+		fields, ok := replica.([]interface{})
+		if !ok {
+			continue
+		}
+		info := make(map[string]string, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			key, _ := fields[i].(string)
+			value, _ := fields[i+1].(string)
+			info[key] = value
+		}
+		if info["ip"] == "" || info["port"] == "" || isDown(info["flags"]) {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(info["ip"], info["port"]))
+	}
+	return addrs
+}
+
+func isDown(flags string) bool {
+	for _, flag := range strings.Split(flags, ",") {
+		switch flag {
+		case "s_down", "o_down", "disconnected":
+			return true
+		}
+	}
+	return false
+}
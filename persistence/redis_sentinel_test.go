@@ -0,0 +1,142 @@
+package persistence
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSentinel answers SENTINEL commands with replicas, given as
+// lists of fields and values
+type fakeSentinel struct {
+	listener net.Listener
+	replicas [][]string
+}
+
+func newFakeSentinel(t *testing.T, replicas ...[]string) *fakeSentinel {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	s := &fakeSentinel{listener: listener, replicas: replicas}
+	go s.serve()
+	return s
+}
+
+func (s *fakeSentinel) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeSentinel) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSentinel) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if len(args) == 3 && strings.EqualFold(args[0], "sentinel") {
+			fmt.Fprintf(conn, "*%d\r\n", len(s.replicas))
+			for _, fields := range s.replicas {
+				fmt.Fprintf(conn, "*%d\r\n", len(fields))
+				for _, field := range fields {
+					fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(field), field)
+				}
+			}
+			continue
+		}
+		fmt.Fprint(conn, "-ERR unknown command\r\n")
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func TestHealthyReplicas(t *testing.T) {
+	replicas := []interface{}{
+		[]interface{}{"name", "10.0.0.1:6379", "ip", "10.0.0.1", "port", "6379", "flags", "slave"},
+		[]interface{}{"ip", "10.0.0.2", "port", "6379", "flags", "s_down,slave"},
+		[]interface{}{"ip", "10.0.0.3", "port", "6380", "flags", "slave,disconnected"},
+		[]interface{}{"ip", "10.0.0.4", "port", "6381", "flags", "slave"},
+		"unexpected",
+	}
+	expected := []string{"10.0.0.1:6379", "10.0.0.4:6381"}
+	if addrs := healthyReplicas(replicas); !reflect.DeepEqual(addrs, expected) {
+		t.Errorf("Expected %v, got %v", expected, addrs)
+	}
+}
+
+func TestNewRedisCacheSentinelReplica(t *testing.T) {
+	host, port, _ := net.SplitHostPort(redisTestServer)
+	sentinel := newFakeSentinel(t,
+		[]string{"ip", "192.0.2.1", "port", "6379", "flags", "slave,o_down"},
+		[]string{"ip", host, "port", port, "flags", "slave"},
+	)
+	defer sentinel.listener.Close()
+
+	master := newRedisStore(t, time.Hour)
+	if err := master.Set("replicated", "value", DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	// The first sentinel is unreachable: the next one is asked
+	down := newFakeSentinel(t)
+	down.listener.Close()
+	store, err := NewRedisCacheSentinelReplica("mymaster", []string{down.addr(), sentinel.addr()}, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("Error connecting to a replica: %s", err)
+	}
+	var value string
+	if err := store.Get("replicated", &value); err != nil || value != "value" {
+		t.Errorf("Expected to read the value from the replica, got %q (%v)", value, err)
+	}
+	if err := store.Set("replicated", "other", DEFAULT); err != ErrReadOnly {
+		t.Errorf("Expected ErrReadOnly, got: %v", err)
+	}
+}
+
+func TestNewRedisCacheSentinelReplica_NoReplica(t *testing.T) {
+	sentinel := newFakeSentinel(t)
+	defer sentinel.listener.Close()
+
+	_, err := NewRedisCacheSentinelReplica("mymaster", []string{sentinel.addr()}, nil, time.Hour)
+	if err != errNoReplica {
+		t.Errorf("Expected errNoReplica, got: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package persistence
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// invalidationKeyField is the stream entry field holding the invalidated key
+const invalidationKeyField = "key"
+
+var errNoInvalidationStream = errors.New("cache: no invalidation stream configured.")
+
+// WithInvalidationStream makes Set, Add, Replace and Delete append the written
+// key to the Redis Stream named stream, trimmed to approximately maxLen
+// entries (0 means untrimmed). Unlike pub/sub, stream entries are kept for
+// consumers that are offline, which lets every instance keeping a local copy
+// of cached values catch up with ConsumeInvalidations after a restart.
+//
+// Keys are appended once written, so that a failure to append does not fail
+// a write that was applied: it is reported to the logger set by WithLogger
+// instead.
+func WithInvalidationStream(stream string, maxLen int64) RedisOption {
+	return func(c *RedisStore) {
+		c.invalidationStream = stream
+		c.invalidationStreamMaxLen = maxLen
+	}
+}
+
+// publishInvalidation appends key to the invalidation stream, if any, and
+// reports a failure to the logger
+func (c *RedisStore) publishInvalidation(key string) {
+	if c.invalidationStream == "" {
+		return
+	}
+	err := c.client.XAdd(&redis.XAddArgs{
+		Stream:       c.invalidationStream,
+		MaxLenApprox: c.invalidationStreamMaxLen,
+		Values:       map[string]interface{}{invalidationKeyField: key},
+	}).Err()
+	if err != nil {
+		c.logError("publish_invalidation", key, err)
+	}
+}
+
+// ConsumeInvalidations reads the invalidation stream configured with
+// WithInvalidationStream as consumer of the consumer group group, creating the
+// group if needed, and calls fn for every invalidated key. Entries are
+// acknowledged once fn returns. Entries that were delivered to consumer but
+// never acknowledged, e.g. because the process crashed, are handed to fn again
+// first, followed by everything added to the stream since the group last read
+// from it. Entries that cannot be acknowledged are reported to the logger set
+// by WithLogger, and handed to fn again once Redis is reachable.
+//
+// Calling stop ends consumption and waits for the reading goroutine to exit.
+func (c *RedisStore) ConsumeInvalidations(group, consumer string, fn func(key string)) (stop func(), err error) {
+	if c.invalidationStream == "" {
+		return nil, errNoInvalidationStream
+	}
+	err = c.client.XGroupCreateMkStream(c.invalidationStream, group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// Start with the entries pending for this consumer, then switch to
+		// new entries once those are drained.
+		id := "0"
+		failures := 0
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			streams, err := c.client.XReadGroup(&redis.XReadGroupArgs{
+				Group:    group,
+				Consumer: consumer,
+				Streams:  []string{c.invalidationStream, id},
+				Count:    100,
+				Block:    time.Second,
+			}).Result()
+			if err == redis.Nil {
+				continue
+			}
+			if err == nil {
+				err = c.handleInvalidations(streams, group, fn)
+				if id == "0" && err == nil && len(streams) > 0 && len(streams[0].Messages) == 0 {
+					id = ">"
+				}
+			}
+			if err != nil {
+				// The entries not acknowledged stay pending, and are read
+				// again from the start
+				id = "0"
+				select {
+				case <-done:
+					return
+				case <-time.After(c.backoff.NextDelay(failures)):
+				}
+				failures++
+				continue
+			}
+			failures = 0
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		wg.Wait()
+	}, nil
+}
+
+// handleInvalidations calls fn with the keys of the entries read from the
+// invalidation stream by group, and acknowledges them. It returns the first
+// error acknowledging an entry, after reporting it to the logger.
+func (c *RedisStore) handleInvalidations(streams []redis.XStream, group string, fn func(key string)) error {
+	for _, stream := range streams {
+		for _, msg := range stream.Messages {
+			key, ok := msg.Values[invalidationKeyField].(string)
+			if ok {
+				fn(key)
+			}
+			if err := c.client.XAck(c.invalidationStream, group, msg.ID).Err(); err != nil {
+				c.logError("ack_invalidation", key, err)
+				return err
+			}
+		}
+	}
+	return nil
+}
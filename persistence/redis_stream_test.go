@@ -0,0 +1,103 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRedisCache_ConsumeInvalidations(t *testing.T) {
+	client := newRedisStore(t, time.Hour).(*RedisStore).client
+	writer := NewRedisCacheFromClient(client, time.Hour, WithInvalidationStream("invalidations", 1000))
+	reader := NewRedisCacheFromClient(client, time.Hour, WithInvalidationStream("invalidations", 1000))
+
+	keys := make(chan string, 10)
+	consume := func() func() {
+		stop, err := reader.ConsumeInvalidations("l1", "node-1", func(key string) {
+			keys <- key
+		})
+		if err != nil {
+			t.Fatalf("Error consuming invalidations: %s", err)
+		}
+		return stop
+	}
+	expect := func(expected ...string) {
+		for _, e := range expected {
+			select {
+			case key := <-keys:
+				if key != e {
+					t.Errorf("Expected invalidation of %s, got %s", e, key)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("Timed out waiting for invalidation of %s", e)
+			}
+		}
+	}
+
+	stop := consume()
+	writer.Set("a", 1, DEFAULT)
+	expect("a")
+	stop()
+
+	// Events written while the consumer is offline are read from the backlog
+	writer.Delete("a")
+	writer.Add("b", 2, DEFAULT)
+	writer.Replace("b", 3, DEFAULT)
+
+	stop = consume()
+	defer stop()
+	expect("a", "b", "b")
+}
+
+func TestRedisCache_DeleteMultiReportInvalidations(t *testing.T) {
+	client := newRedisStore(t, time.Hour).(*RedisStore).client
+	client.Del("invalidations:delete_multi")
+	plain := NewRedisCacheFromClient(client, time.Hour)
+	writer := NewRedisCacheFromClient(client, time.Hour, WithInvalidationStream("invalidations:delete_multi", 1000))
+
+	plain.Set("a", 1, DEFAULT)
+	plain.Set("b", 2, DEFAULT)
+	if _, err := writer.DeleteMultiReport([]string{"a", "missing", "b"}); err != nil {
+		t.Fatalf("Unexpected error deleting: %s", err)
+	}
+
+	entries, err := client.XRange("invalidations:delete_multi", "-", "+").Result()
+	if err != nil {
+		t.Fatalf("Error reading the stream: %s", err)
+	}
+	var invalidated []string
+	for _, entry := range entries {
+		invalidated = append(invalidated, entry.Values[invalidationKeyField].(string))
+	}
+	if len(invalidated) != 2 || invalidated[0] != "a" || invalidated[1] != "b" {
+		t.Errorf("Expected the deleted keys a and b to be invalidated, got %v", invalidated)
+	}
+}
+
+func TestRedisCache_ConsumeInvalidationsWithoutStream(t *testing.T) {
+	redisCache := newRedisStore(t, time.Hour).(*RedisStore)
+	if _, err := redisCache.ConsumeInvalidations("l1", "node-1", func(string) {}); err == nil {
+		t.Errorf("Expected an error without an invalidation stream")
+	}
+}
+
+func TestRedisCache_InvalidationStreamFailure(t *testing.T) {
+	client := newRedisStore(t, time.Hour).(*RedisStore).client
+	var events []LogEvent
+	store := NewRedisCacheFromClient(client, time.Hour, WithInvalidationStream("invalidations-broken", 0),
+		WithLogger(LoggerFunc(func(event LogEvent) {
+			events = append(events, event)
+		})))
+	// XADD fails on a key that does not hold a stream
+	client.Set("invalidations-broken", "not a stream", 0)
+
+	if err := store.Set("a", "value", DEFAULT); err != nil {
+		t.Errorf("Expected a write to succeed when its invalidation cannot be published, got: %s", err)
+	}
+	var s string
+	if err := store.Get("a", &s); err != nil || s != "value" {
+		t.Errorf("Expected the value to be written, got %q, %v", s, err)
+	}
+	if len(events) != 1 || events[0].Kind != LogError || events[0].Key != "a" || events[0].Err == nil {
+		t.Errorf("Expected the failure to be reported to the logger, got %+v", events)
+	}
+}
@@ -0,0 +1,59 @@
+package persistence
+
+import (
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+var (
+	getSetScript = redis.NewScript(getSetSource)
+	getDelScript = redis.NewScript(getDelSource)
+)
+
+// GetSet (see SwapStore interface)
+//
+// The item is read and written in a Lua script, as GETSET cannot set an
+// expiration.
+func (c *RedisStore) GetSet(key string, value interface{}, expires time.Duration, old interface{}) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	b, err := c.serialize(value, expires)
+	if err != nil {
+		return err
+	}
+	if c.dryRun("GETSET", key) {
+		return c.Get(key, old)
+	}
+	previous, err := getSetScript.Run(c.client, []string{key}, b, c.expval(expires).Milliseconds()).String()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	c.publishInvalidation(key)
+	if err == redis.Nil {
+		return ErrCacheMiss
+	}
+	return c.deserialize([]byte(previous), old)
+}
+
+// GetDel (see SwapStore interface)
+//
+// The item is read and deleted in a Lua script, as GETDEL needs Redis 6.2.
+func (c *RedisStore) GetDel(key string, value interface{}) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if c.dryRun("GETDEL", key) {
+		return c.Get(key, value)
+	}
+	previous, err := getDelScript.Run(c.client, []string{key}).String()
+	if err != nil {
+		if err == redis.Nil {
+			return ErrCacheMiss
+		}
+		return err
+	}
+	c.publishInvalidation(key)
+	return c.deserialize([]byte(previous), value)
+}
@@ -0,0 +1,76 @@
+package persistence
+
+import "github.com/go-redis/redis/v7"
+
+// tagKeyPrefix prefixes the Redis sets holding the keys associated with a tag
+const tagKeyPrefix = "gincontrib.cache.tag:"
+
+// Tag (see TagStore interface)
+//
+// The keys associated with a tag are kept in a Redis set, which expires with
+// the last of its members. Keys that expire or are written again in the
+// meantime stay members until then.
+func (c *RedisStore) Tag(key string, tags ...string) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if len(tags) == 0 || c.dryRun("TAG", key) {
+		return nil
+	}
+	pipe := c.client.Pipeline()
+	defer pipe.Close()
+	keyTTL := pipe.PTTL(key)
+	setTTLs := make([]*redis.DurationCmd, len(tags))
+	for i, tag := range tags {
+		setTTLs[i] = pipe.PTTL(tagKeyPrefix + tag)
+	}
+	if _, err := pipe.Exec(); err != nil {
+		return err
+	}
+	for i, tag := range tags {
+		setKey := tagKeyPrefix + tag
+		pipe.SAdd(setKey, key)
+		switch ttl := tagSetTTL(keyTTL.Val(), setTTLs[i].Val(), c.defaultExpiration); ttl {
+		case 0:
+		case -1:
+			pipe.Persist(setKey)
+		default:
+			pipe.PExpire(setKey, ttl)
+		}
+	}
+	_, err := pipe.Exec()
+	return err
+}
+
+// InvalidateTag (see TagStore interface)
+//
+// Keys tagged while the invalidation runs are kept, along with their tag.
+func (c *RedisStore) InvalidateTag(tag string) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	tagKey := tagKeyPrefix + tag
+	keys, err := c.client.SMembers(tagKey).Result()
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	if c.dryRun("DEL", keys...) {
+		return nil
+	}
+
+	members := make([]interface{}, len(keys))
+	pipe := c.client.Pipeline()
+	defer pipe.Close()
+	for i, key := range keys {
+		pipe.Del(key)
+		members[i] = key
+	}
+	pipe.SRem(tagKey, members...)
+	if _, err = pipe.Exec(); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		c.publishInvalidation(key)
+	}
+	return nil
+}
@@ -1,9 +1,24 @@
 package persistence
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/gob"
+	"errors"
+	"log"
+	"math"
 	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/mlsen/cache/utils"
 )
 
 // These tests require redis server running on localhost:6379 (the default)
@@ -34,6 +49,70 @@ func TestRedisCache_IncrDecr(t *testing.T) {
 	incrDecr(t, newRedisStore)
 }
 
+func TestRedisCache_CounterPresence(t *testing.T) {
+	counterPresence(t, newRedisStore)
+}
+
+func TestRedisCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newRedisStore)
+}
+
+func TestRedisCache_Tags(t *testing.T) {
+	tagInvalidation(t, newRedisStore(t, time.Hour).(TagStore))
+}
+
+func TestRedisCache_TagSetExpiration(t *testing.T) {
+	redisCache := newRedisStore(t, time.Hour).(*RedisStore)
+	setTTL := func(tag string) time.Duration {
+		return redisCache.client.PTTL(tagKeyPrefix + tag).Val()
+	}
+
+	SetWithTags(redisCache, "short", "value", time.Minute, "expiring", "mixed")
+	if ttl := setTTL("expiring"); ttl <= 50*time.Second || ttl > time.Minute {
+		t.Errorf("Expected the tag set to expire with its member, got %v", ttl)
+	}
+	SetWithTags(redisCache, "long", "value", 2*time.Minute, "expiring")
+	if ttl := setTTL("expiring"); ttl <= time.Minute {
+		t.Errorf("Expected the tag set to outlive its longest member, got %v", ttl)
+	}
+	SetWithTags(redisCache, "shorter", "value", time.Second, "expiring")
+	if ttl := setTTL("expiring"); ttl <= time.Minute {
+		t.Errorf("Expected the tag set not to expire before its members, got %v", ttl)
+	}
+	SetWithTags(redisCache, "forever", "value", FOREVER, "mixed")
+	if ttl := setTTL("mixed"); ttl != -1 {
+		t.Errorf("Expected the tag set of a persistent member to be persistent, got %v", ttl)
+	}
+	redisCache.Tag("missing", "untagged")
+	if ttl := setTTL("untagged"); ttl <= 50*time.Minute || ttl > time.Hour {
+		t.Errorf("Expected a key tagged before it is stored to get the default expiration, got %v", ttl)
+	}
+}
+
+func TestRedisCache_DeletePrefix(t *testing.T) {
+	prefixDeletion(t, newRedisStore(t, time.Hour).(PrefixStore))
+}
+
+func TestRedisCache_InvalidatePattern(t *testing.T) {
+	patternInvalidation(t, newRedisStore(t, time.Hour).(PatternStore))
+}
+
+func TestRedisCache_Iterate(t *testing.T) {
+	keyIteration(t, newRedisStore(t, time.Hour).(IterableStore))
+}
+
+func TestRedisCache_Touch(t *testing.T) {
+	touchExpiration(t, newRedisStore(t, time.Hour).(TouchStore))
+}
+
+func TestRedisCache_GetSetGetDel(t *testing.T) {
+	swapValues(t, newRedisStore(t, time.Hour))
+}
+
+func TestRedisCache_AppendPrepend(t *testing.T) {
+	rawAppend(t, newRedisStore(t, time.Hour).(*RedisStore))
+}
+
 func TestRedisCache_Expiration(t *testing.T) {
 	expiration(t, newRedisStore)
 }
@@ -49,3 +128,914 @@ func TestRedisCache_Replace(t *testing.T) {
 func TestRedisCache_Add(t *testing.T) {
 	testAdd(t, newRedisStore)
 }
+
+func TestRedisCache_CompareAndSwap(t *testing.T) {
+	compareAndSwap(t, newRedisStore)
+}
+
+func TestRedisCache_TTL(t *testing.T) {
+	ttlInspection(t, newRedisStore)
+}
+
+func TestRedisCache_NegativeCaching(t *testing.T) {
+	negativeCaching(t, newRedisStore)
+}
+
+func TestRedisCache_SoftTTL(t *testing.T) {
+	softExpiration(t, newRedisStore)
+}
+
+func TestRedisCache_Lock(t *testing.T) {
+	distributedLock(t, newRedisStore(t, time.Hour))
+}
+
+func TestRedisCache_ClaimOne(t *testing.T) {
+	redisCache := newRedisStore(t, time.Hour).(*RedisStore)
+
+	const items = 100
+	for i := 0; i < items; i++ {
+		if err := redisCache.AddToSet("queue", i); err != nil {
+			t.Fatalf("Error adding to set: %s", err)
+		}
+	}
+
+	var mu sync.Mutex
+	claimed := make(map[int]int)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var item int
+				ok, err := redisCache.ClaimOne("queue", &item)
+				if err != nil {
+					t.Errorf("Error claiming item: %s", err)
+					return
+				}
+				if !ok {
+					return
+				}
+				mu.Lock()
+				claimed[item]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claimed) != items {
+		t.Errorf("Expected %d claimed items, got %d", items, len(claimed))
+	}
+	for item, n := range claimed {
+		if n != 1 {
+			t.Errorf("Expected item %d to be claimed once, was claimed %d times", item, n)
+		}
+	}
+
+	var item int
+	if ok, err := redisCache.ClaimOne("queue", &item); ok || err != nil {
+		t.Errorf("Expected nothing to claim from an empty set, got %v, %v", ok, err)
+	}
+}
+
+func TestRedisCache_IncrementBounds(t *testing.T) {
+	redisCache := newRedisStore(t, time.Hour)
+
+	if err := redisCache.Set("max", int64(math.MaxInt64), DEFAULT); err != nil {
+		t.Errorf("Error setting int: %s", err)
+	}
+	if n, err := redisCache.Increment("max", 0); err != nil || n != math.MaxInt64 {
+		t.Errorf("Expected %d, got %d, %v", int64(math.MaxInt64), n, err)
+	}
+	if _, err := redisCache.Increment("max", 1); err != ErrCounterOverflow {
+		t.Errorf("Expected ErrCounterOverflow, got: %v", err)
+	}
+	var max int64
+	if err := redisCache.Get("max", &max); err != nil || max != math.MaxInt64 {
+		t.Errorf("Expected value to be left at %d, got %d, %v", int64(math.MaxInt64), max, err)
+	}
+
+	if err := redisCache.Set("negative", -5, DEFAULT); err != nil {
+		t.Errorf("Error setting int: %s", err)
+	}
+	if _, err := redisCache.Increment("negative", 1); err != ErrNegativeCounter {
+		t.Errorf("Expected ErrNegativeCounter incrementing, got: %v", err)
+	}
+	if _, err := redisCache.Decrement("negative", 1); err != ErrNegativeCounter {
+		t.Errorf("Expected ErrNegativeCounter decrementing, got: %v", err)
+	}
+}
+
+func TestRedisCache_LoadFrom(t *testing.T) {
+	redisCache := newRedisStore(t, time.Hour).(*RedisStore)
+
+	var dump bytes.Buffer
+	enc := gob.NewEncoder(&dump)
+	now := time.Now()
+	for _, entry := range []dumpEntry{
+		{Key: "forever", Value: []byte("a")},
+		{Key: "hour", Value: []byte("b"), ExpiresAt: now.Add(time.Hour)},
+		{Key: "expired", Value: []byte("c"), ExpiresAt: now.Add(-time.Second)},
+	} {
+		if err := enc.Encode(&entry); err != nil {
+			t.Fatalf("Error writing dump: %s", err)
+		}
+	}
+
+	if err := redisCache.LoadFrom(&dump); err != nil {
+		t.Fatalf("Error loading dump: %s", err)
+	}
+
+	var value []byte
+	if err := redisCache.Get("forever", &value); err != nil || string(value) != "a" {
+		t.Errorf("Expected a, got %s, %v", value, err)
+	}
+	if ttl := redisCache.client.PTTL("forever").Val(); ttl >= 0 {
+		t.Errorf("Expected no expiration, got %s", ttl)
+	}
+	if err := redisCache.Get("hour", &value); err != nil || string(value) != "b" {
+		t.Errorf("Expected b, got %s, %v", value, err)
+	}
+	if ttl := redisCache.client.PTTL("hour").Val(); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("Expected a TTL of at most an hour, got %s", ttl)
+	}
+	if err := redisCache.Get("expired", &value); err != ErrCacheMiss {
+		t.Errorf("Expected expired entry to be skipped, got: %v", err)
+	}
+}
+
+func TestRedisCache_ExportLoadFrom(t *testing.T) {
+	redisCache := newRedisStore(t, time.Hour).(*RedisStore)
+
+	redisCache.Set("hot:1", "one", time.Hour)
+	redisCache.Set("hot:2", 2, FOREVER)
+	redisCache.Set("cold", "three", time.Hour)
+	redisCache.AddToSet("hot:set", "four")
+
+	var dump bytes.Buffer
+	if err := redisCache.Export(&dump, "hot:*"); err != nil {
+		t.Fatalf("Error exporting: %s", err)
+	}
+	redisCache.Flush()
+	if err := redisCache.LoadFrom(&dump); err != nil {
+		t.Fatalf("Error loading dump: %s", err)
+	}
+
+	var s string
+	if err := redisCache.Get("hot:1", &s); err != nil || s != "one" {
+		t.Errorf("Expected one, got %s, %v", s, err)
+	}
+	var i int
+	if err := redisCache.Get("hot:2", &i); err != nil || i != 2 {
+		t.Errorf("Expected 2, got %d, %v", i, err)
+	}
+	if err := redisCache.Get("cold", &s); err != ErrCacheMiss {
+		t.Errorf("Expected key outside the pattern to be missing, got: %v", err)
+	}
+	if n := redisCache.client.Exists("hot:set").Val(); n != 0 {
+		t.Errorf("Expected non-string key to be skipped")
+	}
+}
+
+func TestRedisCache_DeleteMultiReport(t *testing.T) {
+	redisCache := newRedisStore(t, time.Hour).(*RedisStore)
+
+	redisCache.Set("present:1", 1, DEFAULT)
+	redisCache.Set("present:2", 2, DEFAULT)
+
+	deleted, err := redisCache.DeleteMultiReport([]string{"present:1", "absent", "present:2"})
+	if err != nil {
+		t.Fatalf("Error deleting: %s", err)
+	}
+	expected := map[string]bool{"present:1": true, "absent": false, "present:2": true}
+	if !reflect.DeepEqual(deleted, expected) {
+		t.Errorf("Expected %v, got %v", expected, deleted)
+	}
+
+	var i int
+	if err = redisCache.Get("present:1", &i); err != ErrCacheMiss {
+		t.Errorf("Expected deleted key to be missing, got: %v", err)
+	}
+}
+
+func TestRedisCache_FlushPinned(t *testing.T) {
+	redisCache := newRedisStore(t, time.Hour).(*RedisStore)
+
+	if err := redisCache.SetPinned("config", "critical", DEFAULT); err != nil {
+		t.Fatalf("Error setting pinned value: %s", err)
+	}
+	redisCache.Set("page", "routine", DEFAULT)
+
+	if err := redisCache.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+	var s string
+	if err := redisCache.Get("config", &s); err != nil || s != "critical" {
+		t.Errorf("Expected pinned key to survive Flush, got %s, %v", s, err)
+	}
+	if err := redisCache.Get("page", &s); err != ErrCacheMiss {
+		t.Errorf("Expected unpinned key to be flushed, got: %v", err)
+	}
+
+	if err := redisCache.FlushForce(); err != nil {
+		t.Fatalf("Error force flushing: %s", err)
+	}
+	if err := redisCache.Get("config", &s); err != ErrCacheMiss {
+		t.Errorf("Expected pinned key to be cleared by FlushForce, got: %v", err)
+	}
+}
+
+func TestRedisCache_FlushScope(t *testing.T) {
+	newRedisStore(t, time.Hour)
+	other, err := NewRedisCache(&ClientOptions{Addrs: []string{redisTestServer}, DB: 1}, time.Hour)
+	if err != nil {
+		t.Fatalf("Error connecting to redis: %s", err)
+	}
+	defer other.FlushForce()
+	redisCache, err := NewRedisCache(&ClientOptions{Addrs: []string{redisTestServer}}, time.Hour,
+		WithFlushScope("app1:"))
+	if err != nil {
+		t.Fatalf("Error connecting to redis: %s", err)
+	}
+
+	redisCache.Set("app1:page", "mine", DEFAULT)
+	redisCache.SetPinned("app1:config", "pinned", DEFAULT)
+	redisCache.Set("app2:page", "theirs", DEFAULT)
+	redisCache.SetPinned("app2:config", "theirs", DEFAULT)
+	other.Set("app1:page", "other database", DEFAULT)
+
+	if err := redisCache.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+	var s string
+	if err := redisCache.Get("app1:page", &s); err != ErrCacheMiss {
+		t.Errorf("Expected the key in scope to be flushed, got: %v", err)
+	}
+	if err := redisCache.Get("app1:config", &s); err != nil {
+		t.Errorf("Expected the pinned key to survive Flush, got: %v", err)
+	}
+	if err := redisCache.Get("app2:page", &s); err != nil || s != "theirs" {
+		t.Errorf("Expected the key out of scope to survive Flush, got %q (%v)", s, err)
+	}
+	if err := other.Get("app1:page", &s); err != nil || s != "other database" {
+		t.Errorf("Expected the other database to be untouched, got %q (%v)", s, err)
+	}
+
+	if err := redisCache.FlushForce(); err != nil {
+		t.Fatalf("Error force flushing: %s", err)
+	}
+	if err := redisCache.Get("app1:config", &s); err != ErrCacheMiss {
+		t.Errorf("Expected the pinned key to be cleared by FlushForce, got: %v", err)
+	}
+	if err := redisCache.Get("app2:page", &s); err != nil {
+		t.Errorf("Expected the key out of scope to survive FlushForce, got: %v", err)
+	}
+	if pinned := redisCache.client.SMembers(pinnedKeysKey).Val(); len(pinned) != 1 || pinned[0] != "app2:config" {
+		t.Errorf("Expected only the pins out of scope to be kept, got %v", pinned)
+	}
+
+	if err := redisCache.FlushAll(); err != nil {
+		t.Fatalf("Error flushing all: %s", err)
+	}
+	if err := other.Get("app1:page", &s); err != ErrCacheMiss {
+		t.Errorf("Expected FlushAll to clear every database, got: %v", err)
+	}
+}
+
+func TestRedisCache_Size(t *testing.T) {
+	redisCache := newRedisStore(t, time.Hour).(*RedisStore)
+
+	value := struct{ Name, Description string }{"name", "a longer description"}
+	b, err := utils.Serialize(value)
+	if err != nil {
+		t.Fatalf("Error serializing: %s", err)
+	}
+	redisCache.Set("struct", value, DEFAULT)
+	if n, err := redisCache.Size("struct"); err != nil || n != int64(len(b)) {
+		t.Errorf("Expected size %d, got %d, %v", len(b), n, err)
+	}
+
+	redisCache.Set("empty", []byte{}, DEFAULT)
+	if n, err := redisCache.Size("empty"); err != nil || n != 0 {
+		t.Errorf("Expected size 0, got %d, %v", n, err)
+	}
+
+	if _, err := redisCache.Size("notexist"); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss, got: %v", err)
+	}
+}
+
+func TestRedisCache_SchemaVersion(t *testing.T) {
+	client := newRedisStore(t, time.Hour).(*RedisStore).client
+	v1 := NewRedisCacheFromClient(client, time.Hour, WithSchemaVersion(1, false))
+	v2 := NewRedisCacheFromClient(client, time.Hour, WithSchemaVersion(2, false))
+	v2AsMiss := NewRedisCacheFromClient(client, time.Hour, WithSchemaVersion(2, true))
+
+	type user struct{ Name string }
+	if err := v1.Set("user", user{"gopher"}, DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	var u user
+	if err := v1.Get("user", &u); err != nil || u.Name != "gopher" {
+		t.Errorf("Expected to read back the value under v1, got %v, %v", u, err)
+	}
+	if err := v2.Get("user", &u); err != ErrSchemaVersionMismatch {
+		t.Errorf("Expected ErrSchemaVersionMismatch under v2, got: %v", err)
+	}
+	if err := v2AsMiss.Get("user", &u); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss under v2, got: %v", err)
+	}
+
+	// Counters are not versioned
+	v1.Set("int", 1, DEFAULT)
+	if n, err := v2.Increment("int", 1); err != nil || n != 2 {
+		t.Errorf("Expected 2, got %d, %v", n, err)
+	}
+	var i int
+	if err := v2.Get("int", &i); err != nil || i != 2 {
+		t.Errorf("Expected 2, got %d, %v", i, err)
+	}
+
+	// Values written without an envelope are not mistaken for enveloped
+	// ones, whatever their codec: a MessagePack map of one entry starts with
+	// 0x81, then 0xa5 for a key of five bytes
+	plain := NewRedisCacheFromClient(client, time.Hour, WithCodec(utils.MsgpackCodec))
+	versioned := NewRedisCacheFromClient(client, time.Hour, WithCodec(utils.MsgpackCodec), WithSchemaVersion(0xa5, false), WithAgeTracking())
+	if err := plain.Set("map", map[string]string{"hello": "a value longer than a header"}, DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	var m map[string]string
+	if err := versioned.Get("map", &m); err != ErrSchemaVersionMismatch {
+		t.Errorf("Expected ErrSchemaVersionMismatch for a value without an envelope, got: %v", err)
+	}
+}
+
+func TestRedisCache_GetWithAge(t *testing.T) {
+	client := newRedisStore(t, time.Hour).(*RedisStore).client
+	plain := NewRedisCacheFromClient(client, time.Hour)
+	store := NewRedisCacheFromClient(client, time.Hour, WithAgeTracking())
+
+	type page struct{ Body string }
+	if err := store.Set("page", page{"hello"}, time.Minute); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	var p page
+	age, maxAge, err := store.GetWithAge("page", &p)
+	if err != nil || p.Body != "hello" {
+		t.Fatalf("Expected to read back the value, got %v, %v", p, err)
+	}
+	if maxAge != time.Minute {
+		t.Errorf("Expected a max age of 1m, got %s", maxAge)
+	}
+	time.Sleep(100 * time.Millisecond)
+	later, _, err := store.GetWithAge("page", &p)
+	if err != nil || later < age+100*time.Millisecond {
+		t.Errorf("Expected the age to grow from %s by at least 100ms, got %s, %v", age, later, err)
+	}
+
+	if err := store.Set("forever", page{"hello"}, FOREVER); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	if _, maxAge, err = store.GetWithAge("forever", &p); err != nil || maxAge != 0 {
+		t.Errorf("Expected no max age, got %s, %v", maxAge, err)
+	}
+
+	// Values written without age tracking are readable, but have no age
+	plain.Set("legacy", page{"old"}, DEFAULT)
+	if err := store.Get("legacy", &p); err != nil || p.Body != "old" {
+		t.Errorf("Expected to read the legacy value, got %v, %v", p, err)
+	}
+	if _, _, err := store.GetWithAge("legacy", &p); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport, got: %v", err)
+	}
+	store.Set("int", 1, DEFAULT)
+	var i int
+	if _, _, err := store.GetWithAge("int", &i); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport for a counter, got: %v", err)
+	}
+	if _, _, err := store.GetWithAge("missing", &p); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss, got: %v", err)
+	}
+}
+
+func TestRedisCache_Codec(t *testing.T) {
+	client := newRedisStore(t, time.Hour).(*RedisStore).client
+
+	type user struct{ Name string }
+	for name, codec := range map[string]utils.Codec{
+		"json":    utils.JSONCodec,
+		"msgpack": utils.MsgpackCodec,
+	} {
+		store := NewRedisCacheFromClient(client, time.Hour, WithCodec(codec))
+		if err := store.Set(name, user{"gopher"}, DEFAULT); err != nil {
+			t.Fatalf("Error setting a %s value: %s", name, err)
+		}
+		var u user
+		if err := store.Get(name, &u); err != nil || u.Name != "gopher" {
+			t.Errorf("Expected to read back the %s value, got %v, %v", name, u, err)
+		}
+
+		// Counters are stored as is
+		store.Set(name+":counter", 1, DEFAULT)
+		if n, err := store.Increment(name+":counter", 1); err != nil || n != 2 {
+			t.Errorf("Expected 2 with %s, got %d, %v", name, n, err)
+		}
+	}
+	if raw := client.Get("json").Val(); raw != `{"Name":"gopher"}` {
+		t.Errorf("Expected plain JSON in Redis, got %s", raw)
+	}
+
+	raw := NewRedisCacheFromClient(client, time.Hour, WithCodec(utils.RawCodec))
+	if err := raw.Set("raw", "hello", DEFAULT); err != nil {
+		t.Fatalf("Error setting a raw value: %s", err)
+	}
+	var s string
+	if err := raw.Get("raw", &s); err != nil || s != "hello" {
+		t.Errorf("Expected hello, got %s, %v", s, err)
+	}
+	if err := raw.Set("raw", user{"gopher"}, DEFAULT); err != utils.ErrRawCodecType {
+		t.Errorf("Expected ErrRawCodecType, got: %v", err)
+	}
+}
+
+func TestRedisCache_CompressedCodec(t *testing.T) {
+	client := newRedisStore(t, time.Hour).(*RedisStore).client
+	plain := NewRedisCacheFromClient(client, time.Hour)
+	plain.Set("legacy", "stored before compression", DEFAULT)
+
+	body := strings.Repeat("<p>cached page</p>", 1000)
+	for name, compression := range map[string]utils.Compression{
+		"gzip":   utils.Gzip,
+		"snappy": utils.Snappy,
+		"zstd":   utils.Zstd,
+	} {
+		store := NewRedisCacheFromClient(client, time.Hour, WithCodec(utils.NewCompressedCodec(utils.GobCodec, compression, 1024)))
+		if err := store.Set(name, body, DEFAULT); err != nil {
+			t.Fatalf("Error setting a %s value: %s", name, err)
+		}
+		if size := len(client.Get(name).Val()); size >= len(body) {
+			t.Errorf("Expected %s to compress the value, got %d bytes", name, size)
+		}
+		store.Set(name+":small", "small", DEFAULT)
+
+		// Values are read whatever their compression
+		for _, key := range []string{"gzip", "snappy", "zstd", name + ":small", "legacy"} {
+			var s string
+			if err := store.Get(key, &s); err == ErrCacheMiss {
+				continue
+			} else if err != nil {
+				t.Errorf("Error reading %s with %s: %s", key, name, err)
+			}
+		}
+		var s string
+		if err := store.Get(name, &s); err != nil || s != body {
+			t.Errorf("Expected to read back the %s value, got %v", name, err)
+		}
+	}
+
+	client.Set("unknown", []byte{0xc1, 0xff, 0}, 0)
+	store := NewRedisCacheFromClient(client, time.Hour, WithCodec(utils.NewCompressedCodec(utils.GobCodec, utils.Gzip, 1024)))
+	var s string
+	if err := store.Get("unknown", &s); err != utils.ErrUnknownCodec {
+		t.Errorf("Expected ErrUnknownCodec, got: %v", err)
+	}
+}
+
+func TestRedisCache_EncryptedCodec(t *testing.T) {
+	client := newRedisStore(t, time.Hour).(*RedisStore).client
+	keys := map[byte][]byte{
+		1: bytes.Repeat([]byte{1}, 32),
+		2: bytes.Repeat([]byte{2}, 32),
+	}
+	codec, err := utils.NewEncryptedCodec(utils.JSONCodec, 1, keys)
+	if err != nil {
+		t.Fatalf("Error creating the codec: %s", err)
+	}
+	store := NewRedisCacheFromClient(client, time.Hour, WithCodec(codec))
+	store.Set("secret", "jane@example.com", DEFAULT)
+	if raw := client.Get("secret").Val(); strings.Contains(raw, "jane") {
+		t.Errorf("Expected the value to be encrypted, got %q", raw)
+	}
+
+	// After a rotation, values encrypted with the previous key are still read
+	rotated, _ := utils.NewEncryptedCodec(utils.JSONCodec, 2, keys)
+	store = NewRedisCacheFromClient(client, time.Hour, WithCodec(rotated))
+	var s string
+	if err := store.Get("secret", &s); err != nil || s != "jane@example.com" {
+		t.Errorf("Expected to read back the value, got %s, %v", s, err)
+	}
+
+	withoutKey, _ := utils.NewEncryptedCodec(utils.JSONCodec, 2, map[byte][]byte{2: keys[2]})
+	store = NewRedisCacheFromClient(client, time.Hour, WithCodec(withoutKey))
+	if err := store.Get("secret", &s); err != utils.ErrUnknownKey {
+		t.Errorf("Expected ErrUnknownKey, got: %v", err)
+	}
+
+	raw, _ := client.Get("secret").Bytes()
+	raw[len(raw)-1] ^= 1
+	client.Set("secret", raw, 0)
+	store = NewRedisCacheFromClient(client, time.Hour, WithCodec(codec))
+	if err := store.Get("secret", &s); err == nil {
+		t.Errorf("Expected an error for an altered value")
+	}
+
+	client.Set("plain", `"jane@example.com"`, 0)
+	if err := store.Get("plain", &s); err != utils.ErrNotEncrypted {
+		t.Errorf("Expected ErrNotEncrypted, got: %v", err)
+	}
+}
+
+func TestRedisCache_SignedCodec(t *testing.T) {
+	client := newRedisStore(t, time.Hour).(*RedisStore).client
+	keys := map[byte][]byte{
+		1: bytes.Repeat([]byte{1}, 32),
+		2: bytes.Repeat([]byte{2}, 32),
+	}
+	codec, err := utils.NewSignedCodec(utils.JSONCodec, 1, keys)
+	if err != nil {
+		t.Fatalf("Error creating the codec: %s", err)
+	}
+	store := NewRedisCacheFromClient(client, time.Hour, WithCodec(codec))
+	store.Set("page", "<html>", DEFAULT)
+
+	// After a rotation, values signed with the previous key are still read
+	rotated, _ := utils.NewSignedCodec(utils.JSONCodec, 2, keys)
+	store = NewRedisCacheFromClient(client, time.Hour, WithCodec(rotated))
+	var s string
+	if err := store.Get("page", &s); err != nil || s != "<html>" {
+		t.Errorf("Expected to read back the value, got %s, %v", s, err)
+	}
+
+	withoutKey, _ := utils.NewSignedCodec(utils.JSONCodec, 2, map[byte][]byte{2: keys[2]})
+	store = NewRedisCacheFromClient(client, time.Hour, WithCodec(withoutKey))
+	if err := store.Get("page", &s); err != utils.ErrUnknownKey {
+		t.Errorf("Expected ErrUnknownKey, got: %v", err)
+	}
+
+	// A tenant sharing the server rewrites the value, keeping the header
+	raw, _ := client.Get("page").Bytes()
+	client.Set("page", append(raw[:len(raw)-2:len(raw)-2], `!"`...), 0)
+	store = NewRedisCacheFromClient(client, time.Hour, WithCodec(codec))
+	if err := store.Get("page", &s); err != utils.ErrInvalidSignature {
+		t.Errorf("Expected ErrInvalidSignature for an altered value, got: %v", err)
+	}
+
+	client.Set("plain", `"<script>"`, 0)
+	if err := store.Get("plain", &s); err != utils.ErrNotSigned {
+		t.Errorf("Expected ErrNotSigned, got: %v", err)
+	}
+}
+
+type flakyPinger struct {
+	failures int
+	pings    int
+}
+
+func (p *flakyPinger) Ping() *redis.StatusCmd {
+	p.pings++
+	if p.pings <= p.failures {
+		return redis.NewStatusResult("", errors.New("connection refused"))
+	}
+	return redis.NewStatusResult("PONG", nil)
+}
+
+func TestPingWithRetry(t *testing.T) {
+	pinger := &flakyPinger{failures: 3}
+	if err := pingWithRetry(pinger, 5*time.Second, defaultBackoff); err != nil {
+		t.Errorf("Expected ping to succeed after retrying, got: %s", err)
+	}
+	if pinger.pings != 4 {
+		t.Errorf("Expected 4 pings, got %d", pinger.pings)
+	}
+
+	pinger = &flakyPinger{failures: 1}
+	if err := pingWithRetry(pinger, 0, defaultBackoff); err == nil {
+		t.Errorf("Expected the first error without a startup timeout")
+	}
+	if pinger.pings != 1 {
+		t.Errorf("Expected a single ping, got %d", pinger.pings)
+	}
+
+	pinger = &flakyPinger{failures: 1000}
+	start := time.Now()
+	if err := pingWithRetry(pinger, 300*time.Millisecond, defaultBackoff); err == nil {
+		t.Errorf("Expected an error once the startup timeout elapsed")
+	}
+	if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+		t.Errorf("Expected retries to stop within the startup timeout, took %s", elapsed)
+	}
+}
+
+func TestRedisCache_LazyConnect(t *testing.T) {
+	var down int32 = 1
+	var dials int32
+	dialer := &net.Dialer{}
+	opts := &ClientOptions{
+		Addrs: []string{redisTestServer},
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			atomic.AddInt32(&dials, 1)
+			if atomic.LoadInt32(&down) != 0 {
+				return nil, errBackendDown
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+	reconnected := make(chan struct{}, 1)
+	store, err := NewRedisCache(opts, time.Hour, WithLazyConnect(), WithBackoff(ConstantBackoff(200*time.Millisecond)),
+		WithOnReconnect(func() { reconnected <- struct{}{} }))
+	if err != nil {
+		t.Fatalf("Expected the store to be created while Redis is down, got: %s", err)
+	}
+
+	var s string
+	if err := store.Get("lazy", &s); err != errBackendDown {
+		t.Errorf("Expected the dial error, got: %v", err)
+	}
+	if err := store.Get("lazy", &s); err != ErrCacheUnavailable {
+		t.Errorf("Expected ErrCacheUnavailable while backing off, got: %v", err)
+	}
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Errorf("Expected a single dial while backing off, got %d", n)
+	}
+
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(250 * time.Millisecond)
+	if err := store.Set("lazy", "value", time.Minute); err != nil {
+		t.Errorf("Expected the store to connect once Redis is up, got: %s", err)
+	}
+	select {
+	case <-reconnected:
+	case <-time.After(time.Second):
+		t.Errorf("Expected OnReconnect to be called")
+	}
+}
+
+func TestRedisCache_ReconnectBackoffPerAddress(t *testing.T) {
+	var dialer net.Dialer
+	opts := &ClientOptions{
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if addr == "dead:6379" {
+				return nil, errBackendDown
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+	r := newReconnector(opts, ConstantBackoff(time.Minute), nil)
+
+	if _, err := r.Dial(context.Background(), "tcp", "dead:6379"); err != errBackendDown {
+		t.Fatalf("Expected the dial error, got: %v", err)
+	}
+	if _, err := r.Dial(context.Background(), "tcp", "dead:6379"); err != ErrCacheUnavailable {
+		t.Errorf("Expected the dead node to back off, got: %v", err)
+	}
+	conn, err := r.Dial(context.Background(), "tcp", redisTestServer)
+	if err != nil {
+		t.Fatalf("Expected the healthy node not to back off, got: %s", err)
+	}
+	conn.Close()
+}
+
+func TestRedisCache_DryRunWrites(t *testing.T) {
+	client := newRedisStore(t, time.Hour).(*RedisStore).client
+	live := NewRedisCacheFromClient(client, time.Hour)
+	dryRun := NewRedisCacheFromClient(client, time.Hour, WithDryRunWrites(true))
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	live.Set("existing", "old", DEFAULT)
+	if err := dryRun.Set("existing", "new", DEFAULT); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if err := dryRun.Set("new", "value", DEFAULT); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if err := dryRun.Delete("existing"); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if err := dryRun.Delete("notexist"); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss deleting a missing key, got: %v", err)
+	}
+
+	// Reads pass through, the backing store is unchanged
+	var s string
+	if err := dryRun.Get("existing", &s); err != nil || s != "old" {
+		t.Errorf("Expected old, got %s, %v", s, err)
+	}
+	if err := live.Get("new", &s); err != ErrCacheMiss {
+		t.Errorf("Expected new to not be written, got: %v", err)
+	}
+
+	for _, expected := range []string{"SET existing", "SET new", "DEL existing"} {
+		if !strings.Contains(logged.String(), expected) {
+			t.Errorf("Expected %q to be logged, got %q", expected, logged.String())
+		}
+	}
+}
+
+func TestRedisCache_DryRunWritesLogger(t *testing.T) {
+	client := newRedisStore(t, time.Hour).(*RedisStore).client
+	var events []LogEvent
+	dryRun := NewRedisCacheFromClient(client, time.Hour, WithDryRunWrites(true),
+		WithLogger(LoggerFunc(func(event LogEvent) {
+			events = append(events, event)
+		})))
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	if err := dryRun.Set("key", "value", DEFAULT); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if len(events) != 1 || events[0] != (LogEvent{Kind: LogDryRun, Operation: "SET", Key: "key"}) {
+		t.Errorf("Expected the skipped SET to be reported to the logger, got %+v", events)
+	}
+	if logged.Len() != 0 {
+		t.Errorf("Expected nothing to be logged with the log package, got %q", logged.String())
+	}
+}
+
+func TestRedisCache_ReadOnly(t *testing.T) {
+	client := newRedisStore(t, time.Hour).(*RedisStore).client
+	owner := NewRedisCacheFromClient(client, time.Hour)
+	readOnly := NewRedisCacheFromClient(client, time.Hour, WithReadOnly(true))
+
+	owner.Set("value", "owned", DEFAULT)
+	owner.Set("counter", 10, DEFAULT)
+	owner.AddToSet("set", "member")
+
+	writes := map[string]func() error{
+		"Set":     func() error { return readOnly.Set("value", "new", DEFAULT) },
+		"Add":     func() error { return readOnly.Add("other", "new", DEFAULT) },
+		"Replace": func() error { return readOnly.Replace("value", "new", DEFAULT) },
+		"Delete":  func() error { return readOnly.Delete("value") },
+		"DeleteMultiReport": func() error {
+			_, err := readOnly.DeleteMultiReport([]string{"value"})
+			return err
+		},
+		"Increment": func() error {
+			_, err := readOnly.Increment("counter", 1)
+			return err
+		},
+		"Decrement": func() error {
+			_, err := readOnly.Decrement("counter", 1)
+			return err
+		},
+		"IncrementWithTTL": func() error {
+			_, err := readOnly.IncrementWithTTL("counter", 1, time.Minute)
+			return err
+		},
+		"DecrementWithTTL": func() error {
+			_, err := readOnly.DecrementWithTTL("counter", 1, time.Minute)
+			return err
+		},
+		"Flush":      readOnly.Flush,
+		"FlushForce": readOnly.FlushForce,
+		"FlushAll":   readOnly.FlushAll,
+		"SetPinned":  func() error { return readOnly.SetPinned("value", "new", DEFAULT) },
+		"AddToSet":   func() error { return readOnly.AddToSet("set", "new") },
+		"ClaimOne": func() error {
+			var s string
+			_, err := readOnly.ClaimOne("set", &s)
+			return err
+		},
+		"LoadFrom": func() error { return readOnly.LoadFrom(&bytes.Buffer{}) },
+	}
+	for name, write := range writes {
+		if err := write(); err != ErrReadOnly {
+			t.Errorf("Expected %s to return ErrReadOnly, got: %v", name, err)
+		}
+	}
+
+	var s string
+	if err := readOnly.Get("value", &s); err != nil || s != "owned" {
+		t.Errorf("Expected owned, got %s, %v", s, err)
+	}
+	var n int
+	if err := readOnly.Get("counter", &n); err != nil || n != 10 {
+		t.Errorf("Expected 10, got %d, %v", n, err)
+	}
+	if err := readOnly.Get("other", &s); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss, got: %v", err)
+	}
+	if size := client.SCard("set").Val(); size != 1 {
+		t.Errorf("Expected the set to be unchanged, got %d members", size)
+	}
+}
+
+func TestRedisCache_ConcurrentCounters(t *testing.T) {
+	redisCache := newRedisStore(t, time.Hour).(*RedisStore)
+
+	redisCache.Set("hits", 0, time.Hour)
+	redisCache.Set("stock", 100, time.Hour)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if _, err := redisCache.Increment("hits", 1); err != nil {
+					t.Errorf("Error incrementing: %s", err)
+				}
+				if _, err := redisCache.Decrement("stock", 1); err != nil {
+					t.Errorf("Error decrementing: %s", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var n int64
+	if err := redisCache.Get("hits", &n); err != nil || n != 400 {
+		t.Errorf("Expected no lost increments, got %d, %v", n, err)
+	}
+	if err := redisCache.Get("stock", &n); err != nil || n != 0 {
+		t.Errorf("Expected decrements to stop at 0, got %d, %v", n, err)
+	}
+	if ttl := redisCache.client.PTTL("stock").Val(); ttl <= 0 {
+		t.Errorf("Expected the TTL to be kept, got %s", ttl)
+	}
+}
+
+func TestRedisCache_IncrementKeepsTTL(t *testing.T) {
+	redisCache := newRedisStore(t, time.Hour).(*RedisStore)
+
+	redisCache.Set("counter", 10, time.Hour)
+	if n, err := redisCache.Increment("counter", 5); err != nil || n != 15 {
+		t.Errorf("Expected 15, got %d, %v", n, err)
+	}
+	if ttl := redisCache.client.PTTL("counter").Val(); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("Expected the TTL to survive Increment, got %s", ttl)
+	}
+	if n, err := redisCache.Decrement("counter", 3); err != nil || n != 12 {
+		t.Errorf("Expected 12, got %d, %v", n, err)
+	}
+	if ttl := redisCache.client.PTTL("counter").Val(); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("Expected the TTL to survive Decrement, got %s", ttl)
+	}
+
+	if n, err := redisCache.IncrementWithTTL("counter", 1, time.Minute); err != nil || n != 13 {
+		t.Errorf("Expected 13, got %d, %v", n, err)
+	}
+	if ttl := redisCache.client.PTTL("counter").Val(); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Expected the TTL to be overridden, got %s", ttl)
+	}
+	if n, err := redisCache.DecrementWithTTL("counter", 1, FOREVER); err != nil || n != 12 {
+		t.Errorf("Expected 12, got %d, %v", n, err)
+	}
+	if ttl := redisCache.client.PTTL("counter").Val(); ttl >= 0 {
+		t.Errorf("Expected the counter to no longer expire, got %s", ttl)
+	}
+}
+
+func TestRedisCache_ConnectionOptions(t *testing.T) {
+	redisCache, err := NewRedisCache(&ClientOptions{Addrs: []string{redisTestServer}, PoolSize: 4}, time.Hour,
+		WithPoolSize(8), WithMinIdleConns(2), WithReadTimeout(time.Second), WithRetries(0, 0, 0))
+	if err != nil {
+		t.Fatalf("Error connecting to redis: %s", err)
+	}
+	opts := redisCache.client.(*redis.Client).Options()
+	if opts.PoolSize != 8 || opts.MinIdleConns != 2 {
+		t.Errorf("Expected the options to override the pool settings, got %d and %d", opts.PoolSize, opts.MinIdleConns)
+	}
+	if opts.ReadTimeout != time.Second || opts.DialTimeout != defaultRedisDialTimeout || opts.MaxRetries != 0 {
+		t.Errorf("Expected the timeouts and retries set, got %+v", opts)
+	}
+	// Commands are still sent with retries disabled
+	if err := redisCache.Set("options", "value", time.Minute); err != nil {
+		t.Errorf("Error setting a value: %s", err)
+	}
+	var s string
+	if err := redisCache.Get("options", &s); err != nil || s != "value" {
+		t.Errorf("Expected to read the value back without retries, got %q, %v", s, err)
+	}
+	if n, err := redisCache.client.Exists("options").Result(); err != nil || n != 1 {
+		t.Errorf("Expected the command to reach redis, got %d, %v", n, err)
+	}
+
+	defaulted, err := NewRedisCache(&ClientOptions{Addrs: []string{redisTestServer}}, time.Hour)
+	if err != nil {
+		t.Fatalf("Error connecting to redis: %s", err)
+	}
+	if n := defaulted.client.(*redis.Client).Options().MaxRetries; n != defaultRedisMaxRetries {
+		t.Errorf("Expected %d retries by default, got %d", defaultRedisMaxRetries, n)
+	}
+
+	for name, options := range map[string][]RedisOption{
+		"idle connections beyond the pool": {WithPoolSize(2), WithMinIdleConns(4)},
+		"negative pool size":               {WithPoolSize(-1)},
+		"negative dial timeout":            {WithDialTimeout(-time.Second)},
+		"inverted retry backoffs":          {WithRetries(2, time.Second, time.Millisecond)},
+		"negative retries":                 {WithRetries(-1, 0, 0)},
+		"unverified server name":           {WithTLS(&tls.Config{InsecureSkipVerify: true, ServerName: "redis"})},
+	} {
+		if _, err := NewRedisCache(&ClientOptions{Addrs: []string{redisTestServer}}, time.Hour, options...); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions for %s, got: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,632 @@
+package persistence
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/mlsen/cache/utils"
+	redisv9 "github.com/redis/go-redis/v9"
+)
+
+var (
+	incrementScriptV9 = redisv9.NewScript(incrementSource)
+	decrementScriptV9 = redisv9.NewScript(decrementSource)
+	unlockScriptV9    = redisv9.NewScript(unlockSource)
+	casScriptV9       = redisv9.NewScript(compareAndSwapSource)
+	getSetScriptV9    = redisv9.NewScript(getSetSource)
+	getDelScriptV9    = redisv9.NewScript(getDelSource)
+	appendScriptV9    = redisv9.NewScript(appendSource)
+	prependScriptV9   = redisv9.NewScript(prependSource)
+)
+
+// RedisStoreV9 represents the cache with redis persistence through go-redis
+// v9, which passes a context to every command. It stores values, counters and
+// tags like RedisStore, so that both can share a database while migrating.
+// The features specific to RedisStore (pinned keys, schema versions, age
+// tracking, invalidation streams, dry runs, ...) are not available.
+type RedisStoreV9 struct {
+	client            redisv9.UniversalClient
+	ctx               context.Context
+	defaultExpiration time.Duration
+	codec             utils.Codec
+	flushScope        string
+
+	// local holds the values of keys read by Get, see EnableClientSideCaching,
+	// and stopTracking stops it
+	local        *localCache
+	stopTracking func()
+}
+
+// RedisV9Option configures optional behaviour of a RedisStoreV9
+type RedisV9Option func(*RedisStoreV9)
+
+// WithCodecV9 sets the Codec used to encode values other than byte slices and
+// integers. The default is utils.GobCodec.
+func WithCodecV9(codec utils.Codec) RedisV9Option {
+	return func(c *RedisStoreV9) {
+		c.codec = codec
+	}
+}
+
+// WithFlushScopeV9 restricts Flush to the keys starting with prefix, like
+// WithFlushScope does for RedisStore.
+func WithFlushScopeV9(prefix string) RedisV9Option {
+	return func(c *RedisStoreV9) {
+		c.flushScope = prefix
+	}
+}
+
+// NewRedisStoreV9 returns a RedisStoreV9 from an existing go-redis v9 client,
+// e.g. one returned by redis.NewUniversalClient. Commands use
+// context.Background() unless the store is bound to a context with
+// WithContext.
+func NewRedisStoreV9(client redisv9.UniversalClient, defaultExpiration time.Duration, options ...RedisV9Option) *RedisStoreV9 {
+	c := &RedisStoreV9{
+		client:            client,
+		ctx:               context.Background(),
+		defaultExpiration: defaultExpiration,
+		codec:             utils.GobCodec,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Close (see CacheStore interface)
+//
+// It stops client-side caching, and closes the client the store was built
+// from, unless ctx is already done.
+func (c *RedisStoreV9) Close(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c.stopTracking != nil {
+		c.stopTracking()
+	}
+	return c.client.Close()
+}
+
+// WithContext (see ContextBinder interface)
+func (c *RedisStoreV9) WithContext(ctx context.Context) CacheStore {
+	bound := *c
+	bound.ctx = ctx
+	return &bound
+}
+
+// Get (see CacheStore interface)
+func (c *RedisStoreV9) Get(key string, value interface{}) error {
+	if c.local != nil && c.local.active() {
+		b, err := c.getLocal(key)
+		if err != nil {
+			return err
+		}
+		return utils.DeserializeWith(c.codec, b, value)
+	}
+	b, err := c.client.Get(c.ctx, key).Bytes()
+	if err != nil {
+		return convertRedisV9Error(err)
+	}
+	return utils.DeserializeWith(c.codec, b, value)
+}
+
+// GetWithTTL (see TTLStore interface)
+//
+// The value and its time to live are fetched with a pipelined GET and PTTL,
+// bypassing client-side caching.
+func (c *RedisStoreV9) GetWithTTL(key string, value interface{}) (time.Duration, error) {
+	pipe := c.client.Pipeline()
+	get := pipe.Get(c.ctx, key)
+	pttl := pipe.PTTL(c.ctx, key)
+	if _, err := pipe.Exec(c.ctx); err != nil {
+		return 0, convertRedisV9Error(err)
+	}
+	b, _ := get.Bytes()
+	if err := utils.DeserializeWith(c.codec, b, value); err != nil {
+		return 0, err
+	}
+	return redisTTL(pttl.Val()), nil
+}
+
+// Exists (see TTLStore interface)
+func (c *RedisStoreV9) Exists(key string) (bool, error) {
+	n, err := c.client.Exists(c.ctx, key).Result()
+	return n > 0, err
+}
+
+// Touch (see TouchStore interface)
+//
+// It sends PEXPIRE, or PERSIST for items that do not expire.
+func (c *RedisStoreV9) Touch(key string, expires time.Duration) error {
+	var found bool
+	var err error
+	if expires := c.expval(expires); expires > 0 {
+		found, err = c.client.PExpire(c.ctx, key, expires).Result()
+	} else if found, err = c.client.Persist(c.ctx, key).Result(); err == nil && !found {
+		// PERSIST fails on keys without an expiration too
+		found, err = c.Exists(key)
+	}
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrCacheMiss
+	}
+	return nil
+}
+
+// Set (see CacheStore interface)
+func (c *RedisStoreV9) Set(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.SerializeWith(c.codec, value)
+	if err != nil {
+		return err
+	}
+	defer c.forget(key)
+	return c.client.Set(c.ctx, key, b, c.expval(expires)).Err()
+}
+
+// Add (see CacheStore interface)
+func (c *RedisStoreV9) Add(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.SerializeWith(c.codec, value)
+	if err != nil {
+		return err
+	}
+	defer c.forget(key)
+	stored, err := c.client.SetNX(c.ctx, key, b, c.expval(expires)).Result()
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return ErrNotStored
+	}
+	return nil
+}
+
+// Replace (see CacheStore interface)
+func (c *RedisStoreV9) Replace(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.SerializeWith(c.codec, value)
+	if err != nil {
+		return err
+	}
+	defer c.forget(key)
+	stored, err := c.client.SetXX(c.ctx, key, b, c.expval(expires)).Result()
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return ErrNotStored
+	}
+	return nil
+}
+
+// Delete (see CacheStore interface)
+func (c *RedisStoreV9) Delete(key string) error {
+	defer c.forget(key)
+	del, err := c.client.Del(c.ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if del == 0 {
+		return ErrCacheMiss
+	}
+	return nil
+}
+
+// Increment (see CacheStore interface)
+//
+// Counters are bounded like those of RedisStore (see RedisStore.Increment),
+// and keep their time to live.
+func (c *RedisStoreV9) Increment(key string, delta uint64) (uint64, error) {
+	return c.runCounterScript(incrementScriptV9, key, strconv.FormatInt(int64(delta), 10))
+}
+
+// Decrement (see CacheStore interface)
+//
+// Decrementing stops at 0. The key's time to live is kept.
+func (c *RedisStoreV9) Decrement(key string, delta uint64) (uint64, error) {
+	return c.runCounterScript(decrementScriptV9, key, strconv.FormatUint(delta, 10))
+}
+
+func (c *RedisStoreV9) runCounterScript(script *redisv9.Script, key, delta string) (uint64, error) {
+	defer c.forget(key)
+	val, err := script.Run(c.ctx, c.client, []string{key}, delta, "").Uint64()
+	if err != nil {
+		return 0, counterScriptError(err)
+	}
+	return val, nil
+}
+
+// Flush (see CacheStore interface)
+//
+// Flush deletes the keys of the current database within the scope set with
+// WithFlushScopeV9, all of them by default, and never touches other
+// databases. Keys are found with SCAN, on every master of a cluster.
+func (c *RedisStoreV9) Flush() error {
+	if c.local != nil {
+		defer c.local.invalidateAll()
+	}
+	return c.DeletePrefix(c.flushScope)
+}
+
+// FlushAll issues FLUSHALL, deleting every key of every database of the
+// server, including those of other applications sharing it. Prefer Flush.
+func (c *RedisStoreV9) FlushAll() error {
+	if c.local != nil {
+		defer c.local.invalidateAll()
+	}
+	return c.client.FlushAll(c.ctx).Err()
+}
+
+// GetMulti (see CacheStore interface)
+//
+// All keys are fetched with a single MGET, or with a pipeline of GET on a
+// cluster, where the keys may hash to slots of different nodes.
+func (c *RedisStoreV9) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	if len(keys) != len(values) {
+		return nil, errGetMultiLength
+	}
+	found := make([]bool, len(keys))
+	if len(keys) == 0 {
+		return found, nil
+	}
+	vals, err := c.mget(keys)
+	if err != nil {
+		return nil, err
+	}
+	for i, val := range vals {
+		// Missing keys are nil, empty values are ""
+		s, ok := val.(string)
+		if !ok {
+			continue
+		}
+		err = utils.DeserializeWith(c.codec, []byte(s), values[i])
+		if err == ErrNegativeHit {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found[i] = true
+	}
+	return found, nil
+}
+
+// mget returns the values of keys like MGET: strings, or nil for missing
+// keys. A cluster rejects an MGET of keys in different hash slots, so each key
+// is fetched with its own GET there, pipelined to the nodes holding them.
+func (c *RedisStoreV9) mget(keys []string) ([]interface{}, error) {
+	if _, ok := c.client.(*redisv9.ClusterClient); !ok {
+		return c.client.MGet(c.ctx, keys...).Result()
+	}
+	pipe := c.client.Pipeline()
+	gets := make([]*redisv9.StringCmd, len(keys))
+	for i, key := range keys {
+		gets[i] = pipe.Get(c.ctx, key)
+	}
+	if _, err := pipe.Exec(c.ctx); err != nil && err != redisv9.Nil {
+		return nil, err
+	}
+	vals := make([]interface{}, len(keys))
+	for i, get := range gets {
+		if val, err := get.Result(); err == nil {
+			vals[i] = val
+		}
+	}
+	return vals, nil
+}
+
+// SetMulti (see CacheStore interface)
+//
+// All items are written with a single pipelined round trip, of one SET per
+// item rather than an MSET, so that a cluster routes each to its node.
+func (c *RedisStoreV9) SetMulti(items map[string]Item) error {
+	if len(items) == 0 {
+		return nil
+	}
+	pipe := c.client.Pipeline()
+	keys := make([]string, 0, len(items))
+	for key, item := range items {
+		b, err := utils.SerializeWith(c.codec, item.Value)
+		if err != nil {
+			return err
+		}
+		pipe.Set(c.ctx, key, b, c.expval(item.Expire))
+		keys = append(keys, key)
+	}
+	defer c.forget(keys...)
+	_, err := pipe.Exec(c.ctx)
+	return err
+}
+
+// Tag (see TagStore interface)
+//
+// Tags are kept in the same Redis sets as RedisStore's, expiring alike.
+func (c *RedisStoreV9) Tag(key string, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	pipe := c.client.Pipeline()
+	keyTTL := pipe.PTTL(c.ctx, key)
+	setTTLs := make([]*redisv9.DurationCmd, len(tags))
+	for i, tag := range tags {
+		setTTLs[i] = pipe.PTTL(c.ctx, tagKeyPrefix+tag)
+	}
+	if _, err := pipe.Exec(c.ctx); err != nil {
+		return err
+	}
+	for i, tag := range tags {
+		setKey := tagKeyPrefix + tag
+		pipe.SAdd(c.ctx, setKey, key)
+		switch ttl := tagSetTTL(keyTTL.Val(), setTTLs[i].Val(), c.defaultExpiration); ttl {
+		case 0:
+		case -1:
+			pipe.Persist(c.ctx, setKey)
+		default:
+			pipe.PExpire(c.ctx, setKey, ttl)
+		}
+	}
+	_, err := pipe.Exec(c.ctx)
+	return err
+}
+
+// InvalidateTag (see TagStore interface)
+//
+// Keys tagged while the invalidation runs are kept, along with their tag.
+func (c *RedisStoreV9) InvalidateTag(tag string) error {
+	tagKey := tagKeyPrefix + tag
+	keys, err := c.client.SMembers(c.ctx, tagKey).Result()
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	members := make([]interface{}, len(keys))
+	pipe := c.client.Pipeline()
+	for i, key := range keys {
+		pipe.Del(c.ctx, key)
+		members[i] = key
+	}
+	pipe.SRem(c.ctx, tagKey, members...)
+	defer c.forget(keys...)
+	_, err = pipe.Exec(c.ctx)
+	return err
+}
+
+// DeletePrefix (see PrefixStore interface)
+//
+// Keys are found with SCAN, on every master of a cluster, and deleted by
+// batches as the scan goes.
+func (c *RedisStoreV9) DeletePrefix(prefix string) error {
+	return c.InvalidatePattern(globEscaper.Replace(prefix) + "*")
+}
+
+// InvalidatePattern (see PatternStore interface)
+//
+// Keys are found with SCAN MATCH, on every master of a cluster.
+func (c *RedisStoreV9) InvalidatePattern(pattern string) error {
+	if cluster, ok := c.client.(*redisv9.ClusterClient); ok {
+		return cluster.ForEachMaster(c.ctx, func(ctx context.Context, node *redisv9.Client) error {
+			return c.deleteMatching(ctx, node, pattern)
+		})
+	}
+	return c.deleteMatching(c.ctx, c.client, pattern)
+}
+
+// deleteMatching deletes the keys of node matching pattern, by batches as
+// SCAN finds them. Keys are deleted one by one in a pipeline, as the keys of
+// a cluster node may belong to different hash slots.
+func (c *RedisStoreV9) deleteMatching(ctx context.Context, node redisv9.Cmdable, pattern string) error {
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(ctx, cursor, pattern, dumpBatchSize).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			pipe := node.Pipeline()
+			for _, key := range keys {
+				pipe.Del(ctx, key)
+			}
+			_, err = pipe.Exec(ctx)
+			c.forget(keys...)
+			if err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Keys (see IterableStore interface)
+func (c *RedisStoreV9) Keys(prefix string) ([]string, error) {
+	return collectKeys(c, prefix)
+}
+
+// Iterate (see IterableStore interface)
+//
+// Keys are found with SCAN, on every master of a cluster, and their TTL read
+// by batches. The sets of tagged keys are listed too when they start with
+// prefix.
+func (c *RedisStoreV9) Iterate(prefix string, fn func(key string, meta Meta) bool) error {
+	pattern := globEscaper.Replace(prefix) + "*"
+	var err error
+	if cluster, ok := c.client.(*redisv9.ClusterClient); ok {
+		err = cluster.ForEachMaster(c.ctx, func(ctx context.Context, node *redisv9.Client) error {
+			return iterateNodeV9(ctx, node, pattern, fn)
+		})
+	} else {
+		err = iterateNodeV9(c.ctx, c.client, pattern, fn)
+	}
+	if err == errStopIteration {
+		return nil
+	}
+	return err
+}
+
+// iterateNodeV9 calls fn with the keys of node matching pattern, returning
+// errStopIteration once fn returns false
+func iterateNodeV9(ctx context.Context, node redisv9.Cmdable, pattern string, fn func(key string, meta Meta) bool) error {
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(ctx, cursor, pattern, dumpBatchSize).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			pipe := node.Pipeline()
+			pttls := make([]*redisv9.DurationCmd, len(keys))
+			for i, key := range keys {
+				pttls[i] = pipe.PTTL(ctx, key)
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				return err
+			}
+			for i, key := range keys {
+				pttl := pttls[i].Val()
+				if pttl == -2 {
+					// Deleted since the scan found it
+					continue
+				}
+				if !fn(key, Meta{TTL: redisTTL(pttl)}) {
+					return errStopIteration
+				}
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// TryLock (see LockStore interface)
+//
+// The lock is a key set with SET NX PX, holding a random token.
+func (c *RedisStoreV9) TryLock(key string, ttl time.Duration) (token string, acquired bool, err error) {
+	token, err = newLockToken()
+	if err != nil {
+		return "", false, err
+	}
+	acquired, err = c.client.SetNX(c.ctx, key, token, ttl).Result()
+	if err != nil || !acquired {
+		return "", false, err
+	}
+	return token, true, nil
+}
+
+// Unlock (see LockStore interface)
+func (c *RedisStoreV9) Unlock(key, token string) error {
+	return unlockScriptV9.Run(c.ctx, c.client, []string{key}, token).Err()
+}
+
+// GetWithVersion (see CASStore interface)
+//
+// The version is derived from a hash of the stored value, bypassing
+// client-side caching.
+func (c *RedisStoreV9) GetWithVersion(key string, value interface{}) (uint64, error) {
+	b, err := c.client.Get(c.ctx, key).Bytes()
+	if err != nil {
+		return 0, convertRedisV9Error(err)
+	}
+	if err := utils.DeserializeWith(c.codec, b, value); err != nil {
+		if err == ErrNegativeHit {
+			return casVersion(b), err
+		}
+		return 0, err
+	}
+	return casVersion(b), nil
+}
+
+// CompareAndSwap (see CASStore interface)
+func (c *RedisStoreV9) CompareAndSwap(key string, value interface{}, version uint64, expires time.Duration) error {
+	b, err := utils.SerializeWith(c.codec, value)
+	if err != nil {
+		return err
+	}
+	defer c.forget(key)
+	result, err := casScriptV9.Run(c.ctx, c.client, []string{key},
+		casVersionArg(version), b, c.expval(expires).Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	return casResult(result)
+}
+
+// GetSet (see SwapStore interface)
+//
+// The item is read and written in a Lua script, as GETSET cannot set an
+// expiration.
+func (c *RedisStoreV9) GetSet(key string, value interface{}, expires time.Duration, old interface{}) error {
+	b, err := utils.SerializeWith(c.codec, value)
+	if err != nil {
+		return err
+	}
+	defer c.forget(key)
+	previous, err := getSetScriptV9.Run(c.ctx, c.client, []string{key}, b, c.expval(expires).Milliseconds()).Text()
+	if err != nil {
+		return convertRedisV9Error(err)
+	}
+	return utils.DeserializeWith(c.codec, []byte(previous), old)
+}
+
+// GetDel (see SwapStore interface)
+//
+// The item is read and deleted in a Lua script, as GETDEL needs Redis 6.2.
+func (c *RedisStoreV9) GetDel(key string, value interface{}) error {
+	defer c.forget(key)
+	previous, err := getDelScriptV9.Run(c.ctx, c.client, []string{key}).Text()
+	if err != nil {
+		return convertRedisV9Error(err)
+	}
+	return utils.DeserializeWith(c.codec, []byte(previous), value)
+}
+
+// Append (see RawStore interface)
+//
+// It sends APPEND in a Lua script checking that the key exists, as APPEND
+// creates missing keys.
+func (c *RedisStoreV9) Append(key string, data []byte) error {
+	defer c.forget(key)
+	stored, err := appendScriptV9.Run(c.ctx, c.client, []string{key}, data).Int64()
+	if err != nil {
+		return err
+	}
+	return rawResult(stored)
+}
+
+// Prepend (see RawStore interface)
+//
+// Redis has no such command: the value is rewritten in a Lua script, which
+// keeps its time to live.
+func (c *RedisStoreV9) Prepend(key string, data []byte) error {
+	defer c.forget(key)
+	stored, err := prependScriptV9.Run(c.ctx, c.client, []string{key}, data).Int64()
+	if err != nil {
+		return err
+	}
+	return rawResult(stored)
+}
+
+// Ping (see PingStore interface)
+func (c *RedisStoreV9) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+func (c *RedisStoreV9) expval(expires time.Duration) time.Duration {
+	switch expires {
+	case DEFAULT:
+		return c.defaultExpiration
+	case FOREVER:
+		return time.Duration(0)
+	}
+	return expires
+}
+
+func convertRedisV9Error(err error) error {
+	if err == redisv9.Nil {
+		return ErrCacheMiss
+	}
+	return err
+}
@@ -0,0 +1,223 @@
+package persistence
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	redisv9 "github.com/redis/go-redis/v9"
+)
+
+var newRedisStoreV9 = func(t *testing.T, defaultExpiration time.Duration) CacheStore {
+	client := redisv9.NewClient(&redisv9.Options{Addr: redisTestServer})
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Fatalf("couldn't connect to redis on %s: %s", redisTestServer, err)
+	}
+	t.Cleanup(func() { client.Close() })
+	store := NewRedisStoreV9(client, defaultExpiration)
+	store.Flush()
+	return store
+}
+
+func TestRedisV9Cache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newRedisStoreV9)
+}
+
+func TestRedisV9Cache_IncrDecr(t *testing.T) {
+	incrDecr(t, newRedisStoreV9)
+}
+
+func TestRedisV9Cache_CounterPresence(t *testing.T) {
+	counterPresence(t, newRedisStoreV9)
+}
+
+func TestRedisV9Cache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newRedisStoreV9)
+}
+
+func TestRedisV9Cache_Tags(t *testing.T) {
+	tagInvalidation(t, newRedisStoreV9(t, time.Hour).(TagStore))
+}
+
+func TestRedisV9Cache_DeletePrefix(t *testing.T) {
+	prefixDeletion(t, newRedisStoreV9(t, time.Hour).(PrefixStore))
+}
+
+func TestRedisV9Cache_InvalidatePattern(t *testing.T) {
+	patternInvalidation(t, newRedisStoreV9(t, time.Hour).(PatternStore))
+}
+
+func TestRedisV9Cache_Iterate(t *testing.T) {
+	keyIteration(t, newRedisStoreV9(t, time.Hour).(IterableStore))
+}
+
+func TestRedisV9Cache_Touch(t *testing.T) {
+	touchExpiration(t, newRedisStoreV9(t, time.Hour).(TouchStore))
+}
+
+func TestRedisV9Cache_GetSetGetDel(t *testing.T) {
+	swapValues(t, newRedisStoreV9(t, time.Hour))
+}
+
+func TestRedisV9Cache_AppendPrepend(t *testing.T) {
+	rawAppend(t, newRedisStoreV9(t, time.Hour).(*RedisStoreV9))
+}
+
+func TestRedisV9Cache_Expiration(t *testing.T) {
+	expiration(t, newRedisStoreV9)
+}
+
+func TestRedisV9Cache_EmptyCache(t *testing.T) {
+	emptyCache(t, newRedisStoreV9)
+}
+
+func TestRedisV9Cache_Replace(t *testing.T) {
+	testReplace(t, newRedisStoreV9)
+}
+
+func TestRedisV9Cache_Add(t *testing.T) {
+	testAdd(t, newRedisStoreV9)
+}
+
+func TestRedisV9Cache_CompareAndSwap(t *testing.T) {
+	compareAndSwap(t, newRedisStoreV9)
+}
+
+func TestRedisV9Cache_TTL(t *testing.T) {
+	ttlInspection(t, newRedisStoreV9)
+}
+
+func TestRedisV9Cache_NegativeCaching(t *testing.T) {
+	negativeCaching(t, newRedisStoreV9)
+}
+
+func TestRedisV9Cache_Lock(t *testing.T) {
+	distributedLock(t, newRedisStoreV9(t, time.Hour))
+}
+
+func TestRedisV9Cache_SharedWithRedisStore(t *testing.T) {
+	v9 := newRedisStoreV9(t, time.Hour)
+	v7 := NewRedisCacheFromClient(newRedisStore(t, time.Hour).(*RedisStore).client, time.Hour)
+
+	v7.Set("page", "written by v7", DEFAULT)
+	v7.Set("counter", 1, DEFAULT)
+	var s string
+	if err := v9.Get("page", &s); err != nil || s != "written by v7" {
+		t.Errorf("Expected to read the v7 value, got %s, %v", s, err)
+	}
+	if n, err := v9.Increment("counter", 1); err != nil || n != 2 {
+		t.Errorf("Expected 2, got %d, %v", n, err)
+	}
+}
+
+func TestRedisV9Cache_WithContext(t *testing.T) {
+	store := newRedisStoreV9(t, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := BindContext(ctx, store).Set("key", "value", DEFAULT); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got: %v", err)
+	}
+}
+
+func TestRedisV9Cache_ClientSideCaching(t *testing.T) {
+	store := newRedisStoreV9(t, time.Hour).(*RedisStoreV9)
+	// Miniredis does not implement tracking: invalidation messages are fed
+	// to the local map directly
+	store.local = newLocalCache(10)
+	other := NewRedisStoreV9(store.client, time.Hour)
+
+	store.Set("a", "one", DEFAULT)
+	var s string
+	if err := store.Get("a", &s); err != nil || s != "one" {
+		t.Fatalf("Expected one, got %s, %v", s, err)
+	}
+	other.Set("a", "two", DEFAULT)
+	if err := store.Get("a", &s); err != nil || s != "one" {
+		t.Errorf("Expected the local copy, got %s, %v", s, err)
+	}
+	store.local.handleInvalidation(&redisv9.Message{Channel: invalidateChannel, PayloadSlice: []string{"a"}})
+	if err := store.Get("a", &s); err != nil || s != "two" {
+		t.Errorf("Expected the invalidated value to be read again, got %s, %v", s, err)
+	}
+
+	// Writes through the store are visible immediately
+	store.Set("a", "three", DEFAULT)
+	if err := store.Get("a", &s); err != nil || s != "three" {
+		t.Errorf("Expected three, got %s, %v", s, err)
+	}
+
+	// A reconnection drops every local copy
+	other.Set("a", "four", DEFAULT)
+	store.local.handleInvalidation(&redisv9.Subscription{Kind: "subscribe", Channel: invalidateChannel})
+	if err := store.Get("a", &s); err != nil || s != "four" {
+		t.Errorf("Expected four, got %s, %v", s, err)
+	}
+
+	// Local copies expire with the key
+	store.Set("short", "value", 100*time.Millisecond)
+	store.Get("short", &s)
+	time.Sleep(200 * time.Millisecond)
+	if err := store.Get("short", &s); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss, got: %v", err)
+	}
+}
+
+func TestLocalCache_InvalidatedRead(t *testing.T) {
+	local := newLocalCache(1)
+
+	// A value read while its key is invalidated may predate the invalidation
+	start := local.begin("a")
+	local.invalidate("a")
+	local.end("a", start, []byte("old"), -1)
+	if _, ok := local.get("a"); ok {
+		t.Errorf("Expected the value read during the invalidation to be dropped")
+	}
+
+	start = local.begin("a")
+	local.invalidate("b")
+	local.end("a", start, []byte("new"), -1)
+	if b, ok := local.get("a"); !ok || string(b) != "new" {
+		t.Errorf("Expected new, got %s, %v", b, ok)
+	}
+
+	// The map is bounded
+	start = local.begin("b")
+	local.end("b", start, []byte("b"), -1)
+	if _, ok := local.get("a"); ok {
+		t.Errorf("Expected a to be evicted")
+	}
+
+	local.disable()
+	start = local.begin("c")
+	local.end("c", start, []byte("c"), -1)
+	if _, ok := local.get("c"); ok {
+		t.Errorf("Expected no value to be stored once disabled")
+	}
+}
+
+func TestRedisV9Cache_FlushScope(t *testing.T) {
+	newRedisStoreV9(t, time.Hour)
+	client := redisv9.NewClient(&redisv9.Options{Addr: redisTestServer})
+	defer client.Close()
+	store := NewRedisStoreV9(client, time.Hour, WithFlushScopeV9("app1:"))
+
+	store.Set("app1:page", "mine", DEFAULT)
+	store.Set("app2:page", "theirs", DEFAULT)
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+	var s string
+	if err := store.Get("app1:page", &s); err != ErrCacheMiss {
+		t.Errorf("Expected the key in scope to be flushed, got: %v", err)
+	}
+	if err := store.Get("app2:page", &s); err != nil || s != "theirs" {
+		t.Errorf("Expected the key out of scope to survive Flush, got %q (%v)", s, err)
+	}
+
+	if err := store.FlushAll(); err != nil {
+		t.Fatalf("Error flushing all: %s", err)
+	}
+	if err := store.Get("app2:page", &s); err != ErrCacheMiss {
+		t.Errorf("Expected FlushAll to clear every key, got: %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+package persistence
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	redisv9 "github.com/redis/go-redis/v9"
+)
+
+// invalidateChannel is the channel Redis publishes tracking invalidations to
+// for RESP2 connections
+const invalidateChannel = "__redis__:invalidate"
+
+// trackingPingInterval is the interval at which an idle tracking connection
+// is checked
+const trackingPingInterval = 5 * time.Second
+
+// EnableClientSideCaching keeps the values read by Get in a local map of up
+// to maxKeys entries, so that hot keys are served without a network hop. The
+// map is kept coherent with Redis through client-side caching (Redis 6 or
+// later): a dedicated connection to the server described by opts enables
+// tracking in broadcasting mode and receives an invalidation message for
+// every key written, by any client, starting with one of prefixes (every key
+// if no prefix is given). Choose prefixes to limit this traffic on busy
+// servers. Writes made through the store evict the local copies immediately,
+// and the whole map is dropped whenever the connection is lost, since
+// invalidations may have been missed.
+//
+// Only Get is served locally. opts must describe the server the store's
+// client talks to; cluster and sentinel setups are not supported. Call it
+// before the store is used. Calling stop, or closing the store, closes the
+// connection and stops serving values locally.
+func (c *RedisStoreV9) EnableClientSideCaching(opts *redisv9.Options, maxKeys int, prefixes ...string) (stop func(), err error) {
+	local := newLocalCache(maxKeys)
+	subOpts := *opts
+	// Invalidations are only delivered as pub/sub messages with RESP2
+	subOpts.Protocol = 2
+	subOpts.OnConnect = func(ctx context.Context, cn *redisv9.Conn) error {
+		if opts.OnConnect != nil {
+			if err := opts.OnConnect(ctx, cn); err != nil {
+				return err
+			}
+		}
+		id, err := cn.ClientID(ctx).Result()
+		if err != nil {
+			return err
+		}
+		// The connection redirects the invalidations to itself, and receives
+		// them once subscribed to invalidateChannel
+		args := []interface{}{"CLIENT", "TRACKING", "ON", "REDIRECT", strconv.FormatInt(id, 10), "BCAST"}
+		for _, prefix := range prefixes {
+			args = append(args, "PREFIX", prefix)
+		}
+		return cn.Process(ctx, redisv9.NewStatusCmd(ctx, args...))
+	}
+	sub := redisv9.NewClient(&subOpts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pubsub := sub.Subscribe(ctx, invalidateChannel)
+	if _, err = pubsub.Receive(ctx); err != nil {
+		cancel()
+		pubsub.Close()
+		sub.Close()
+		return nil, err
+	}
+	c.local = local
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c.watchInvalidations(ctx, pubsub)
+	}()
+
+	var once sync.Once
+	c.stopTracking = func() {
+		once.Do(func() {
+			cancel()
+			pubsub.Close()
+			wg.Wait()
+			sub.Close()
+			local.disable()
+		})
+	}
+	return c.stopTracking, nil
+}
+
+func (c *RedisStoreV9) watchInvalidations(ctx context.Context, pubsub *redisv9.PubSub) {
+	for attempt := 0; ; {
+		msg, err := pubsub.ReceiveTimeout(ctx, trackingPingInterval)
+		if err == nil {
+			attempt = 0
+			c.local.handleInvalidation(msg)
+			continue
+		}
+		if ctx.Err() != nil || err == redisv9.ErrClosed {
+			return
+		}
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			if err = pubsub.Ping(ctx); err == nil {
+				continue
+			}
+		}
+		// The connection is re-established by the next receive, and messages
+		// may have been lost in the meantime. A flush is also reported as an
+		// error, as go-redis cannot decode its null payload.
+		c.local.invalidateAll()
+		time.Sleep(defaultBackoff.NextDelay(attempt))
+		attempt++
+	}
+}
+
+// getLocal serves Get from the local map, filling it from Redis on a miss
+func (c *RedisStoreV9) getLocal(key string) ([]byte, error) {
+	if b, ok := c.local.get(key); ok {
+		return b, nil
+	}
+	start := c.local.begin(key)
+	pipe := c.client.Pipeline()
+	get := pipe.Get(c.ctx, key)
+	pttl := pipe.PTTL(c.ctx, key)
+	_, err := pipe.Exec(c.ctx)
+	b, getErr := get.Bytes()
+	if err != nil {
+		c.local.end(key, start, nil, 0)
+		if getErr == redisv9.Nil {
+			return nil, ErrCacheMiss
+		}
+		return nil, err
+	}
+	c.local.end(key, start, b, pttl.Val())
+	return b, nil
+}
+
+// forget evicts the local copies of keys written through the store
+func (c *RedisStoreV9) forget(keys ...string) {
+	if c.local == nil {
+		return
+	}
+	for _, key := range keys {
+		c.local.invalidate(key)
+	}
+}
+
+// localCache holds the values of a RedisStoreV9 with client-side caching. A
+// value read from Redis is only stored if its key was not invalidated while
+// the read was in flight, as the value read may predate the invalidation.
+type localCache struct {
+	mu       sync.Mutex
+	enabled  bool
+	maxKeys  int
+	items    map[string]localValue
+	seq      uint64
+	inflight map[string]int
+	// dirty holds the seq of the last invalidation of the keys being read,
+	// and flushed the seq of the last invalidation of all keys
+	dirty   map[string]uint64
+	flushed uint64
+}
+
+type localValue struct {
+	value []byte
+	// expiresAt is zero if the value does not expire
+	expiresAt time.Time
+}
+
+func newLocalCache(maxKeys int) *localCache {
+	return &localCache{
+		enabled:  true,
+		maxKeys:  maxKeys,
+		items:    make(map[string]localValue),
+		inflight: make(map[string]int),
+		dirty:    make(map[string]uint64),
+	}
+}
+
+func (l *localCache) get(key string) ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	item, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	if !item.expiresAt.IsZero() && !time.Now().Before(item.expiresAt) {
+		delete(l.items, key)
+		return nil, false
+	}
+	return item.value, true
+}
+
+// begin registers a read of key from Redis, and returns the seq to pass to end
+func (l *localCache) begin(key string) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inflight[key]++
+	return l.seq
+}
+
+// end stores value, read from Redis with a time to live of pttl (negative if
+// none), unless key was invalidated since begin returned start. A nil value
+// only ends the read.
+func (l *localCache) end(key string, start uint64, value []byte, pttl time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stale := l.dirty[key] > start || l.flushed > start
+	if l.inflight[key]--; l.inflight[key] == 0 {
+		delete(l.inflight, key)
+		delete(l.dirty, key)
+	}
+	if value == nil || stale || !l.enabled || pttl == 0 {
+		return
+	}
+	if len(l.items) >= l.maxKeys {
+		if l.maxKeys <= 0 {
+			return
+		}
+		for evicted := range l.items {
+			delete(l.items, evicted)
+			break
+		}
+	}
+	item := localValue{value: value}
+	if pttl > 0 {
+		item.expiresAt = time.Now().Add(pttl)
+	}
+	l.items[key] = item
+}
+
+func (l *localCache) invalidate(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	delete(l.items, key)
+	if l.inflight[key] > 0 {
+		l.dirty[key] = l.seq
+	}
+}
+
+func (l *localCache) invalidateAll() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	l.flushed = l.seq
+	l.items = make(map[string]localValue)
+}
+
+// disable drops the local values and stops storing new ones
+func (l *localCache) disable() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.enabled = false
+	l.seq++
+	l.flushed = l.seq
+	l.items = make(map[string]localValue)
+}
+
+func (l *localCache) active() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.enabled
+}
+
+// handleInvalidation applies a message received on the tracking connection
+func (l *localCache) handleInvalidation(msg interface{}) {
+	switch msg := msg.(type) {
+	case *redisv9.Message:
+		if msg.Channel != invalidateChannel {
+			return
+		}
+		for _, key := range msg.PayloadSlice {
+			l.invalidate(key)
+		}
+		if msg.Payload != "" {
+			l.invalidate(msg.Payload)
+		}
+	case *redisv9.Subscription:
+		// The connection was re-established: invalidations may have been
+		// missed while it was down
+		l.invalidateAll()
+	}
+}
@@ -0,0 +1,271 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// defaultRefreshConcurrency bounds the number of loaders a RefreshAheadStore
+// runs at once, unless set with WithRefreshConcurrency
+const defaultRefreshConcurrency = 4
+
+// minRefreshInterval is the shortest default interval between two checks for
+// items to refresh
+const minRefreshInterval = 10 * time.Millisecond
+
+var errRefreshExpiration = errors.New("cache: refresh-ahead needs a positive expiration.")
+
+// RefreshAheadStore is a CacheStore keeping the items registered with
+// Register warm: each is loaded again and stored before it expires, in the
+// background, so that requests for hot keys never miss the cache.
+type RefreshAheadStore struct {
+	CacheStore
+
+	refresher *refresher
+}
+
+// RefreshAheadOption configures optional behaviour of a RefreshAheadStore
+type RefreshAheadOption func(*refresher)
+
+// WithRefreshInterval sets how often the store checks for items to refresh.
+// The default is a quarter of the threshold, and at least 10ms.
+func WithRefreshInterval(interval time.Duration) RefreshAheadOption {
+	return func(r *refresher) {
+		r.interval = interval
+	}
+}
+
+// WithRefreshConcurrency sets the number of loaders run at once. Items due
+// meanwhile wait for a loader to return. The default is 4.
+func WithRefreshConcurrency(n int) RefreshAheadOption {
+	return func(r *refresher) {
+		r.concurrency = n
+	}
+}
+
+// WithRefreshErrorHandler sets the function called when an item fails to
+// refresh, instead of logging the error. The item keeps its value until it
+// expires, and the refresh is retried at the next check.
+func WithRefreshErrorHandler(fn func(key string, err error)) RefreshAheadOption {
+	return func(r *refresher) {
+		r.onError = fn
+	}
+}
+
+// NewRefreshAheadStore returns a RefreshAheadStore wrapping store, which
+// refreshes registered items once they have less than threshold left to
+// live. It starts a goroutine, stopped by Close.
+func NewRefreshAheadStore(store CacheStore, threshold time.Duration, options ...RefreshAheadOption) *RefreshAheadStore {
+	r := &refresher{
+		store:       store,
+		threshold:   threshold,
+		interval:    threshold / 4,
+		concurrency: defaultRefreshConcurrency,
+		onError: func(key string, err error) {
+			log.Printf("cache: refreshing %s: %s", key, err)
+		},
+		entries: make(map[string]*refreshEntry),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	for _, option := range options {
+		option(r)
+	}
+	if r.interval < minRefreshInterval {
+		r.interval = minRefreshInterval
+	}
+	if r.concurrency < 1 {
+		r.concurrency = 1
+	}
+	r.sem = make(chan struct{}, r.concurrency)
+	go r.run()
+	return &RefreshAheadStore{CacheStore: store, refresher: r}
+}
+
+// WithContext (see ContextBinder interface)
+//
+// The bound store shares the registered items of s. Refreshes run with the
+// store s wraps, unbound.
+func (s *RefreshAheadStore) WithContext(ctx context.Context) CacheStore {
+	return &RefreshAheadStore{CacheStore: BindContext(ctx, s.CacheStore), refresher: s.refresher}
+}
+
+// Register loads the item at key with loader and stores it for expires, then
+// keeps refreshing it the same way until Unregister is called. If threshold
+// is not shorter than expires, the item is refreshed halfway through its
+// lifetime. expires must be a positive duration, as the store tracks the time
+// left to items itself.
+//
+// If the initial load or write fails, the error is returned and key is not
+// registered. Registering a key again replaces its loader.
+func (s *RefreshAheadStore) Register(key string, expires time.Duration, loader func() (interface{}, error)) error {
+	if expires <= 0 {
+		return errRefreshExpiration
+	}
+	value, err := loader()
+	if err != nil {
+		return err
+	}
+	if err := s.Set(key, value, expires); err != nil {
+		return err
+	}
+	s.refresher.register(key, &refreshEntry{
+		expires: expires,
+		loader:  loader,
+		due:     s.refresher.dueAfter(time.Now(), expires),
+	})
+	return nil
+}
+
+// Unregister stops refreshing the item at key. The item is kept until it
+// expires.
+func (s *RefreshAheadStore) Unregister(key string) {
+	s.refresher.unregister(key)
+}
+
+// Close (see CacheStore interface)
+//
+// It stops refreshing items, waits for the refreshes in progress to return,
+// and closes the store it wraps.
+func (s *RefreshAheadStore) Close(ctx context.Context) error {
+	if err := s.refresher.close(ctx); err != nil {
+		return err
+	}
+	return s.CacheStore.Close(ctx)
+}
+
+// refresher schedules the refreshes of a RefreshAheadStore
+type refresher struct {
+	store       CacheStore
+	threshold   time.Duration
+	interval    time.Duration
+	concurrency int
+	onError     func(key string, err error)
+
+	mu      sync.Mutex
+	entries map[string]*refreshEntry
+
+	sem      chan struct{}
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+type refreshEntry struct {
+	expires  time.Duration
+	loader   func() (interface{}, error)
+	due      time.Time
+	inFlight bool
+}
+
+// dueAfter returns when an item stored at now for expires must be refreshed
+func (r *refresher) dueAfter(now time.Time, expires time.Duration) time.Time {
+	lead := r.threshold
+	if lead >= expires {
+		lead = expires / 2
+	}
+	return now.Add(expires - lead)
+}
+
+func (r *refresher) register(key string, entry *refreshEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[key] = entry
+}
+
+func (r *refresher) unregister(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, key)
+}
+
+// close stops starting refreshes, and waits for the running ones to return
+// by taking every slot of sem, or returns ctx.Err() if ctx is done first
+func (r *refresher) close(ctx context.Context) error {
+	r.stopOnce.Do(func() { close(r.stop) })
+	if err := awaitDone(ctx, r.stopped); err != nil {
+		return err
+	}
+	taken := 0
+	defer func() {
+		for ; taken > 0; taken-- {
+			<-r.sem
+		}
+	}()
+	for taken < cap(r.sem) {
+		select {
+		case r.sem <- struct{}{}:
+			taken++
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (r *refresher) run() {
+	defer close(r.stopped)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case now := <-ticker.C:
+			r.refreshDue(now)
+		}
+	}
+}
+
+// refreshDue starts refreshing the items due at now, waiting for loaders to
+// return when concurrency are running
+func (r *refresher) refreshDue(now time.Time) {
+	type dueEntry struct {
+		key   string
+		entry *refreshEntry
+	}
+	var due []dueEntry
+	r.mu.Lock()
+	for key, entry := range r.entries {
+		if !entry.inFlight && !now.Before(entry.due) {
+			entry.inFlight = true
+			due = append(due, dueEntry{key, entry})
+		}
+	}
+	r.mu.Unlock()
+
+	for i, d := range due {
+		select {
+		case r.sem <- struct{}{}:
+		case <-r.stop:
+			r.mu.Lock()
+			for _, d := range due[i:] {
+				d.entry.inFlight = false
+			}
+			r.mu.Unlock()
+			return
+		}
+		go r.refresh(d.key, d.entry)
+	}
+}
+
+func (r *refresher) refresh(key string, entry *refreshEntry) {
+	defer func() { <-r.sem }()
+	value, err := entry.loader()
+	if err == nil {
+		err = r.store.Set(key, value, entry.expires)
+	}
+
+	r.mu.Lock()
+	entry.inFlight = false
+	if err == nil {
+		entry.due = r.dueAfter(time.Now(), entry.expires)
+	}
+	r.mu.Unlock()
+	if err != nil {
+		r.onError(key, err)
+	}
+}
@@ -0,0 +1,158 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefreshAheadStore_Register(t *testing.T) {
+	store := NewRefreshAheadStore(NewInMemoryStore(time.Hour), 150*time.Millisecond,
+		WithRefreshInterval(10*time.Millisecond))
+	defer store.Close(context.Background())
+
+	var loads int32
+	err := store.Register("key", 200*time.Millisecond, func() (interface{}, error) {
+		return int(atomic.AddInt32(&loads, 1)), nil
+	})
+	if err != nil {
+		t.Fatalf("Error registering: %s", err)
+	}
+	var value int
+	if err := store.Get("key", &value); err != nil || value != 1 {
+		t.Fatalf("Expected the item to be loaded on registration, got %d (%v)", value, err)
+	}
+
+	// The item is refreshed every 50ms, and never expires
+	for i := 0; i < 4; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if err := store.Get("key", &value); err != nil {
+			t.Fatalf("Expected the item to be kept warm, got: %v", err)
+		}
+	}
+	if value < 4 {
+		t.Errorf("Expected the item to be refreshed, got %d loads", value)
+	}
+
+	store.Unregister("key")
+	time.Sleep(250 * time.Millisecond)
+	if err := store.Get("key", &value); err != ErrCacheMiss {
+		t.Errorf("Expected the unregistered item to expire, got: %v", err)
+	}
+}
+
+func TestRefreshAheadStore_RegisterErrors(t *testing.T) {
+	store := NewRefreshAheadStore(NewInMemoryStore(time.Hour), time.Second)
+	defer store.Close(context.Background())
+
+	loader := func() (interface{}, error) { return "value", nil }
+	if err := store.Register("key", DEFAULT, loader); err != errRefreshExpiration {
+		t.Errorf("Expected errRefreshExpiration, got: %v", err)
+	}
+
+	failure := errors.New("load failed")
+	err := store.Register("key", time.Minute, func() (interface{}, error) {
+		return nil, failure
+	})
+	if err != failure {
+		t.Errorf("Expected the loader error, got: %v", err)
+	}
+	store.refresher.mu.Lock()
+	registered := len(store.refresher.entries)
+	store.refresher.mu.Unlock()
+	if registered != 0 {
+		t.Errorf("Expected the failing key not to be registered, got %d entries", registered)
+	}
+}
+
+func TestRefreshAheadStore_Failure(t *testing.T) {
+	failure := errors.New("load failed")
+	failed := make(chan string, 1)
+	store := NewRefreshAheadStore(NewInMemoryStore(time.Hour), 190*time.Millisecond,
+		WithRefreshInterval(10*time.Millisecond),
+		WithRefreshErrorHandler(func(key string, err error) {
+			if err != failure {
+				t.Errorf("Expected the loader error, got: %v", err)
+			}
+			select {
+			case failed <- key:
+			default:
+			}
+		}))
+	defer store.Close(context.Background())
+
+	var loads int32
+	err := store.Register("key", 200*time.Millisecond, func() (interface{}, error) {
+		if atomic.AddInt32(&loads, 1) > 1 {
+			return nil, failure
+		}
+		return "value", nil
+	})
+	if err != nil {
+		t.Fatalf("Error registering: %s", err)
+	}
+
+	select {
+	case key := <-failed:
+		if key != "key" {
+			t.Errorf("Expected key to fail, got %q", key)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the refresh to fail")
+	}
+	var value string
+	if err := store.Get("key", &value); err != nil || value != "value" {
+		t.Errorf("Expected the item to keep its value, got %q (%v)", value, err)
+	}
+}
+
+func TestRefreshAheadStore_Concurrency(t *testing.T) {
+	store := NewRefreshAheadStore(NewInMemoryStore(time.Hour), time.Minute,
+		WithRefreshInterval(10*time.Millisecond), WithRefreshConcurrency(2))
+
+	var mu sync.Mutex
+	registered := false
+	running, maxRunning, refreshes := 0, 0, 0
+	loader := func() (interface{}, error) {
+		mu.Lock()
+		if !registered {
+			// Initial loads run in Register, outside of the scheduler
+			mu.Unlock()
+			return "value", nil
+		}
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		refreshes++
+		mu.Unlock()
+		return "value", nil
+	}
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		// Refreshed halfway through their lifetime, every 25ms
+		if err := store.Register(key, 50*time.Millisecond, loader); err != nil {
+			t.Fatalf("Error registering: %s", err)
+		}
+	}
+	mu.Lock()
+	registered = true
+	mu.Unlock()
+
+	time.Sleep(200 * time.Millisecond)
+	store.Close(context.Background())
+	mu.Lock()
+	defer mu.Unlock()
+	if refreshes <= 6 {
+		t.Errorf("Expected items to be refreshed, got %d loads", refreshes)
+	}
+	if maxRunning > 2 {
+		t.Errorf("Expected at most 2 loaders at once, got %d", maxRunning)
+	}
+}
@@ -0,0 +1,315 @@
+package persistence
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// replicaCooldown is how long a replica that failed is only read from if
+// every other one failed too
+const replicaCooldown = time.Second
+
+// ReplicatedStore is a CacheStore replicating its items to several stores,
+// e.g. Redis clusters in different availability zones, so that caching
+// survives the loss of any of them. Writes go to every replica, and succeed
+// once a quorum of them answered. Reads go to the replica answering the
+// fastest, among those which did not fail in the last second, and to the
+// next ones if it fails.
+//
+// Replicas are not reconciled: one that missed writes, while unreachable or
+// behind the quorum, serves stale values or misses until they are written
+// again or expire.
+type ReplicatedStore struct {
+	replicas []replica
+	quorum   int
+	async    bool
+}
+
+type replica struct {
+	store  CacheStore
+	health *replicaHealth
+}
+
+// replicaHealth tracks the latency and the failures of a replica
+type replicaHealth struct {
+	mu       sync.Mutex
+	latency  time.Duration
+	failedAt time.Time
+}
+
+// ReplicatedOption configures optional behaviour of a ReplicatedStore
+type ReplicatedOption func(*ReplicatedStore)
+
+// WithWriteQuorum sets the number of replicas which must answer a write for
+// it to succeed. The default is all of them.
+func WithWriteQuorum(quorum int) ReplicatedOption {
+	return func(c *ReplicatedStore) {
+		c.quorum = quorum
+	}
+}
+
+// WithAsyncReplication makes writes return as soon as the quorum answered,
+// instead of once every replica did: the other replicas are written in the
+// background, so that a slow zone does not slow every write down.
+func WithAsyncReplication() ReplicatedOption {
+	return func(c *ReplicatedStore) {
+		c.async = true
+	}
+}
+
+// NewReplicatedStore returns a ReplicatedStore replicating items to stores
+func NewReplicatedStore(stores []CacheStore, options ...ReplicatedOption) *ReplicatedStore {
+	c := &ReplicatedStore{quorum: len(stores)}
+	for _, store := range stores {
+		c.replicas = append(c.replicas, replica{store: store, health: &replicaHealth{}})
+	}
+	for _, option := range options {
+		option(c)
+	}
+	if c.quorum < 1 {
+		c.quorum = 1
+	}
+	if c.quorum > len(stores) {
+		c.quorum = len(stores)
+	}
+	return c
+}
+
+// WithContext (see ContextBinder interface)
+//
+// The bound store shares the health of the replicas of c.
+func (c *ReplicatedStore) WithContext(ctx context.Context) CacheStore {
+	bound := *c
+	bound.replicas = make([]replica, len(c.replicas))
+	for i, r := range c.replicas {
+		bound.replicas[i] = replica{store: BindContext(ctx, r.store), health: r.health}
+	}
+	return &bound
+}
+
+// observe records that an operation on the replica took latency and
+// returned err
+func (h *replicaHealth) observe(latency time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if isBackendError(err) {
+		h.failedAt = time.Now()
+		return
+	}
+	if h.latency == 0 {
+		h.latency = latency
+	} else {
+		h.latency = (4*h.latency + latency) / 5
+	}
+}
+
+// rank returns whether the replica failed recently, and its latency
+func (h *replicaHealth) rank(now time.Time) (failed bool, latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return now.Sub(h.failedAt) < replicaCooldown, h.latency
+}
+
+// readOrder returns the replicas ordered by preference for reads: healthy
+// ones first, fastest first
+func (c *ReplicatedStore) readOrder() []replica {
+	type ranked struct {
+		replica
+		failed  bool
+		latency time.Duration
+	}
+	now := time.Now()
+	rankings := make([]ranked, len(c.replicas))
+	for i, r := range c.replicas {
+		failed, latency := r.health.rank(now)
+		rankings[i] = ranked{r, failed, latency}
+	}
+	sort.SliceStable(rankings, func(i, j int) bool {
+		if rankings[i].failed != rankings[j].failed {
+			return !rankings[i].failed
+		}
+		return rankings[i].latency < rankings[j].latency
+	})
+	order := make([]replica, len(rankings))
+	for i, r := range rankings {
+		order[i] = r.replica
+	}
+	return order
+}
+
+// read runs op on the preferred replica, and on the next ones while it fails
+func (c *ReplicatedStore) read(op func(store CacheStore) error) error {
+	var err error
+	for _, r := range c.readOrder() {
+		started := time.Now()
+		err = op(r.store)
+		r.health.observe(time.Since(started), err)
+		if !isBackendError(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// write runs op on every replica, and returns once the quorum answered, or
+// every replica did unless writes are asynchronous. The error is that of the
+// first replica which answered, in the order of the replicas, such as
+// ErrNotStored, or if too few did, the first failure.
+func (c *ReplicatedStore) write(op func(i int, store CacheStore) error) error {
+	type result struct {
+		i   int
+		err error
+	}
+	results := make(chan result, len(c.replicas))
+	for i, r := range c.replicas {
+		go func(i int, r replica) {
+			started := time.Now()
+			err := op(i, r.store)
+			r.health.observe(time.Since(started), err)
+			results <- result{i, err}
+		}(i, r)
+	}
+
+	errs := make([]error, len(c.replicas))
+	received := make([]bool, len(c.replicas))
+	answered := 0
+	for n := 0; n < len(c.replicas); n++ {
+		res := <-results
+		errs[res.i], received[res.i] = res.err, true
+		if !isBackendError(res.err) {
+			answered++
+		}
+		if c.async && answered >= c.quorum {
+			break
+		}
+	}
+
+	var failure error
+	for i, err := range errs {
+		if !received[i] {
+			continue
+		}
+		if answered >= c.quorum && !isBackendError(err) {
+			return err
+		}
+		if failure == nil && isBackendError(err) {
+			failure = err
+		}
+	}
+	return failure
+}
+
+// Get (see CacheStore interface)
+func (c *ReplicatedStore) Get(key string, value interface{}) error {
+	return c.read(func(store CacheStore) error {
+		return store.Get(key, value)
+	})
+}
+
+// Set (see CacheStore interface)
+func (c *ReplicatedStore) Set(key string, value interface{}, expires time.Duration) error {
+	return c.write(func(_ int, store CacheStore) error {
+		return store.Set(key, value, expires)
+	})
+}
+
+// Add (see CacheStore interface)
+func (c *ReplicatedStore) Add(key string, value interface{}, expires time.Duration) error {
+	return c.write(func(_ int, store CacheStore) error {
+		return store.Add(key, value, expires)
+	})
+}
+
+// Replace (see CacheStore interface)
+func (c *ReplicatedStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return c.write(func(_ int, store CacheStore) error {
+		return store.Replace(key, value, expires)
+	})
+}
+
+// Delete (see CacheStore interface)
+func (c *ReplicatedStore) Delete(key string) error {
+	return c.write(func(_ int, store CacheStore) error {
+		return store.Delete(key)
+	})
+}
+
+// Increment (see CacheStore interface)
+//
+// Each replica updates its own counter: the value returned is that of the
+// first replica which answered, in the order of the replicas.
+func (c *ReplicatedStore) Increment(key string, delta uint64) (uint64, error) {
+	return c.count(func(store CacheStore) (uint64, error) {
+		return store.Increment(key, delta)
+	})
+}
+
+// Decrement (see CacheStore interface)
+//
+// Each replica updates its own counter: the value returned is that of the
+// first replica which answered, in the order of the replicas.
+func (c *ReplicatedStore) Decrement(key string, delta uint64) (uint64, error) {
+	return c.count(func(store CacheStore) (uint64, error) {
+		return store.Decrement(key, delta)
+	})
+}
+
+func (c *ReplicatedStore) count(op func(store CacheStore) (uint64, error)) (uint64, error) {
+	var mu sync.Mutex
+	values := make([]uint64, len(c.replicas))
+	counted := make([]bool, len(c.replicas))
+	err := c.write(func(i int, store CacheStore) error {
+		n, err := op(store)
+		mu.Lock()
+		values[i], counted[i] = n, err == nil
+		mu.Unlock()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i, n := range values {
+		if counted[i] {
+			return n, nil
+		}
+	}
+	return 0, nil
+}
+
+// Flush (see CacheStore interface)
+func (c *ReplicatedStore) Flush() error {
+	return c.write(func(_ int, store CacheStore) error {
+		return store.Flush()
+	})
+}
+
+// Close (see CacheStore interface)
+//
+// It closes every replica.
+func (c *ReplicatedStore) Close(ctx context.Context) error {
+	stores := make([]CacheStore, len(c.replicas))
+	for i, r := range c.replicas {
+		stores[i] = r.store
+	}
+	return closeAll(ctx, stores...)
+}
+
+// GetMulti (see CacheStore interface)
+func (c *ReplicatedStore) GetMulti(keys []string, values []interface{}) (found []bool, err error) {
+	err = c.read(func(store CacheStore) error {
+		found, err = store.GetMulti(keys, values)
+		return err
+	})
+	return found, err
+}
+
+// SetMulti (see CacheStore interface)
+func (c *ReplicatedStore) SetMulti(items map[string]Item) error {
+	return c.write(func(_ int, store CacheStore) error {
+		return store.SetMulti(items)
+	})
+}
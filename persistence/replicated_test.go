@@ -0,0 +1,92 @@
+package persistence
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var newReplicatedStore = func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
+	return NewReplicatedStore([]CacheStore{NewInMemoryStore(defaultExpiration), NewInMemoryStore(defaultExpiration)})
+}
+
+// Test typical cache interactions
+func TestReplicatedCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newReplicatedStore)
+}
+
+func TestReplicatedCache_IncrDecr(t *testing.T) {
+	incrDecr(t, newReplicatedStore)
+}
+
+func TestReplicatedCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newReplicatedStore)
+}
+
+func TestReplicatedCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newReplicatedStore)
+}
+
+func TestReplicatedCache_Replace(t *testing.T) {
+	testReplace(t, newReplicatedStore)
+}
+
+func TestReplicatedCache_Add(t *testing.T) {
+	testAdd(t, newReplicatedStore)
+}
+
+func TestReplicatedCache_Quorum(t *testing.T) {
+	healthy := NewInMemoryStore(time.Hour)
+	down := &flakyStore{CacheStore: NewInMemoryStore(time.Hour), down: 1}
+
+	store := NewReplicatedStore([]CacheStore{down, healthy})
+	if err := store.Set("key", "value", DEFAULT); err != errBackendDown {
+		t.Errorf("Expected the write to fail without a quorum, got: %v", err)
+	}
+
+	store = NewReplicatedStore([]CacheStore{down, healthy}, WithWriteQuorum(1))
+	if err := store.Set("key", "value", DEFAULT); err != nil {
+		t.Errorf("Expected the write to succeed with a quorum, got: %s", err)
+	}
+	var s string
+	if err := store.Get("key", &s); err != nil || s != "value" {
+		t.Errorf("Expected to read from the healthy replica, got: %q, %v", s, err)
+	}
+	calls := atomic.LoadInt32(&down.calls)
+	if err := store.Get("key", &s); err != nil || s != "value" {
+		t.Errorf("Expected to read from the healthy replica, got: %q, %v", s, err)
+	}
+	if n := atomic.LoadInt32(&down.calls); n != calls {
+		t.Errorf("Expected the failed replica not to be read from, got %d reads", n-calls)
+	}
+}
+
+// slowSetStore delays writes until released
+type slowSetStore struct {
+	CacheStore
+	release chan struct{}
+}
+
+func (s slowSetStore) Set(key string, value interface{}, expires time.Duration) error {
+	<-s.release
+	return s.CacheStore.Set(key, value, expires)
+}
+
+func TestReplicatedCache_AsyncReplication(t *testing.T) {
+	fast := NewInMemoryStore(time.Hour)
+	slow := slowSetStore{NewInMemoryStore(time.Hour), make(chan struct{})}
+	store := NewReplicatedStore([]CacheStore{slow, fast}, WithWriteQuorum(1), WithAsyncReplication())
+
+	if err := store.Set("key", "value", DEFAULT); err != nil {
+		t.Errorf("Expected the write to return once the quorum answered, got: %s", err)
+	}
+	var s string
+	if err := slow.Get("key", &s); err != ErrCacheMiss {
+		t.Errorf("Expected the slow replica to be written later, got: %q, %v", s, err)
+	}
+	close(slow.release)
+	time.Sleep(10 * time.Millisecond)
+	if err := slow.Get("key", &s); err != nil || s != "value" {
+		t.Errorf("Expected the slow replica to be written in the background, got: %q, %v", s, err)
+	}
+}
@@ -0,0 +1,191 @@
+package persistence
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/mlsen/cache/utils"
+)
+
+// RistrettoStore represents the cache with in-process persistence bounded by
+// a memory budget. Values are stored serialized, and their size counts against
+// the budget; once it is exhausted, ristretto evicts the entries least likely
+// to be used again.
+type RistrettoStore struct {
+	cache             *ristretto.Cache
+	defaultExpiration time.Duration
+
+	// mu serializes writes, so that Add, Replace and counters can check and
+	// update an entry atomically
+	mu sync.Mutex
+}
+
+// NewRistrettoStore returns a RistrettoStore holding at most maxBytes of
+// serialized values. Ristretto tracks the access frequency of about ten keys
+// per expected entry, assuming entries of around a kilobyte.
+func NewRistrettoStore(maxBytes int64, defaultExpiration time.Duration) (*RistrettoStore, error) {
+	counters := maxBytes / 100
+	if counters < 1000 {
+		counters = 1000
+	}
+	cache, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: counters,
+		MaxCost:     maxBytes,
+		BufferItems: 64,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &RistrettoStore{cache: cache, defaultExpiration: defaultExpiration}, nil
+}
+
+// Close (see CacheStore interface)
+//
+// It stops the goroutines of the underlying ristretto cache.
+func (c *RistrettoStore) Close(ctx context.Context) error {
+	c.cache.Close()
+	return nil
+}
+
+// Get (see CacheStore interface)
+func (c *RistrettoStore) Get(key string, value interface{}) error {
+	b, found := c.get(key)
+	if !found {
+		return ErrCacheMiss
+	}
+	return utils.Deserialize(b, value)
+}
+
+// Set (see CacheStore interface)
+func (c *RistrettoStore) Set(key string, value interface{}, expires time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.set(key, value, c.expval(expires))
+}
+
+// Add (see CacheStore interface)
+func (c *RistrettoStore) Add(key string, value interface{}, expires time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, found := c.get(key); found {
+		return ErrNotStored
+	}
+	return c.set(key, value, c.expval(expires))
+}
+
+// Replace (see CacheStore interface)
+func (c *RistrettoStore) Replace(key string, value interface{}, expires time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, found := c.get(key); !found {
+		return ErrNotStored
+	}
+	return c.set(key, value, c.expval(expires))
+}
+
+// Delete (see CacheStore interface)
+func (c *RistrettoStore) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, found := c.get(key); !found {
+		return ErrCacheMiss
+	}
+	c.cache.Del(key)
+	return nil
+}
+
+// Increment (see CacheStore interface)
+//
+// Like InMemoryStore, the counter wraps around on overflow.
+func (c *RistrettoStore) Increment(key string, n uint64) (uint64, error) {
+	return c.incrDecr(key, func(value uint64) uint64 {
+		return value + n
+	})
+}
+
+// Decrement (see CacheStore interface)
+//
+// Like InMemoryStore, the counter stops at 0.
+func (c *RistrettoStore) Decrement(key string, n uint64) (uint64, error) {
+	return c.incrDecr(key, func(value uint64) uint64 {
+		if n > value {
+			return 0
+		}
+		return value - n
+	})
+}
+
+// incrDecr updates the counter at key with fn, keeping its time to live
+func (c *RistrettoStore) incrDecr(key string, fn func(uint64) uint64) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, found := c.get(key)
+	if !found {
+		return 0, ErrCacheMiss
+	}
+	var value uint64
+	if err := utils.Deserialize(b, &value); err != nil {
+		return 0, err
+	}
+	ttl, _ := c.cache.GetTTL(key)
+	value = fn(value)
+	return value, c.set(key, value, ttl)
+}
+
+// Flush (see CacheStore interface)
+func (c *RistrettoStore) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache.Clear()
+	return nil
+}
+
+// GetMulti (see CacheStore interface)
+func (c *RistrettoStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	return getMulti(c, keys, values)
+}
+
+// SetMulti (see CacheStore interface)
+func (c *RistrettoStore) SetMulti(items map[string]Item) error {
+	return setMulti(c, items)
+}
+
+func (c *RistrettoStore) get(key string) ([]byte, bool) {
+	v, found := c.cache.Get(key)
+	if !found {
+		return nil, false
+	}
+	return v.([]byte), true
+}
+
+// set stores value for ttl (0 means forever). Ristretto applies writes
+// asynchronously, so set waits for the write to be applied, making it visible
+// to the next Get. It returns ErrNotStored if ristretto dropped the write
+// because of contention, or did not admit a new key: once the budget is
+// spent, new keys are only admitted in place of keys used less often.
+func (c *RistrettoStore) set(key string, value interface{}, ttl time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	if !c.cache.SetWithTTL(key, b, int64(len(b)), ttl) {
+		return ErrNotStored
+	}
+	c.cache.Wait()
+	if _, found := c.cache.Get(key); !found {
+		return ErrNotStored
+	}
+	return nil
+}
+
+func (c *RistrettoStore) expval(expires time.Duration) time.Duration {
+	switch expires {
+	case DEFAULT:
+		return c.defaultExpiration
+	case FOREVER:
+		return 0
+	}
+	return expires
+}
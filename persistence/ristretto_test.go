@@ -0,0 +1,92 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var newRistrettoStore = func(t *testing.T, defaultExpiration time.Duration) CacheStore {
+	store, err := NewRistrettoStore(1<<20, defaultExpiration)
+	if err != nil {
+		t.Fatalf("Error creating store: %s", err)
+	}
+	return store
+}
+
+// Test typical cache interactions
+func TestRistrettoCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newRistrettoStore)
+}
+
+func TestRistrettoCache_IncrDecr(t *testing.T) {
+	incrDecr(t, newRistrettoStore)
+}
+
+func TestRistrettoCache_CounterPresence(t *testing.T) {
+	counterPresence(t, newRistrettoStore)
+}
+
+func TestRistrettoCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newRistrettoStore)
+}
+
+func TestRistrettoCache_Expiration(t *testing.T) {
+	expiration(t, newRistrettoStore)
+}
+
+func TestRistrettoCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newRistrettoStore)
+}
+
+func TestRistrettoCache_Replace(t *testing.T) {
+	testReplace(t, newRistrettoStore)
+}
+
+func TestRistrettoCache_Add(t *testing.T) {
+	testAdd(t, newRistrettoStore)
+}
+
+func TestRistrettoCache_MemoryBound(t *testing.T) {
+	const maxBytes = 1 << 20
+	store, err := NewRistrettoStore(maxBytes, time.Hour)
+	if err != nil {
+		t.Fatalf("Error creating store: %s", err)
+	}
+	defer store.Close(context.Background())
+
+	value := bytes.Repeat([]byte("x"), 10<<10)
+	const items = 1000 // ~10MB in total
+	for i := 0; i < items; i++ {
+		// Set reports whether the value was admitted
+		var b []byte
+		switch err := store.Set(strconv.Itoa(i), value, DEFAULT); err {
+		case nil:
+			if err := store.Get(strconv.Itoa(i), &b); err != nil {
+				t.Fatalf("Expected a value set to be found, got: %s", err)
+			}
+		case ErrNotStored:
+			if err := store.Get(strconv.Itoa(i), &b); err != ErrCacheMiss {
+				t.Fatalf("Expected a value not stored to be missed, got: %v", err)
+			}
+		default:
+			t.Fatalf("Unexpected error setting a value: %s", err)
+		}
+	}
+
+	kept := 0
+	for i := 0; i < items; i++ {
+		var b []byte
+		if store.Get(strconv.Itoa(i), &b) == nil {
+			kept++
+		}
+	}
+	if kept == 0 {
+		t.Errorf("Expected some values to be kept")
+	}
+	if size := kept * len(value); size > maxBytes {
+		t.Errorf("Expected at most %d bytes to be kept, got %d", maxBytes, size)
+	}
+}
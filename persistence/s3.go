@@ -0,0 +1,317 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/mlsen/cache/utils"
+)
+
+// s3ExpiresMetadata is the user metadata holding the expiration of an object,
+// as Unix nanoseconds, in its canonical form as returned by the SDK
+const s3ExpiresMetadata = "Expires-At"
+
+// s3DeleteBatchSize is the maximum number of objects deleted by DeleteObjects
+const s3DeleteBatchSize = 1000
+
+// S3Store represents the cache with S3 persistence, for very large items such
+// as rendered documents or exports that do not belong in memory. Each item is
+// an object holding the serialized value, its expiration in the object's
+// metadata. S3 does not delete objects on their own expiration: Get checks it
+// and deletes expired objects lazily, and a lifecycle rule on the bucket
+// should delete the objects nobody reads again.
+//
+// S3 has no conditional writes: Add, Replace and the counters read the object
+// first and write it next, which is not atomic when several clients write the
+// same key. To keep hot items close, use the store as the remote store of a
+// TieredStore.
+type S3Store struct {
+	client            s3iface.S3API
+	ctx               context.Context
+	bucket            string
+	prefix            string
+	defaultExpiration time.Duration
+}
+
+// S3Option configures an S3Store
+type S3Option func(*S3Store)
+
+// WithS3Prefix stores objects under prefix, so that the bucket can hold
+// other objects. Flush only deletes the objects under prefix.
+func WithS3Prefix(prefix string) S3Option {
+	return func(c *S3Store) {
+		c.prefix = prefix
+	}
+}
+
+// NewS3Store returns an S3Store keeping its items in bucket
+func NewS3Store(client s3iface.S3API, bucket string, defaultExpiration time.Duration, opts ...S3Option) *S3Store {
+	store := &S3Store{
+		client:            client,
+		ctx:               context.Background(),
+		bucket:            bucket,
+		defaultExpiration: defaultExpiration,
+	}
+	for _, opt := range opts {
+		opt(store)
+	}
+	return store
+}
+
+// WithContext (see ContextBinder interface)
+func (c *S3Store) WithContext(ctx context.Context) CacheStore {
+	bound := *c
+	bound.ctx = ctx
+	return &bound
+}
+
+// Get (see CacheStore interface)
+func (c *S3Store) Get(key string, value interface{}) error {
+	data, _, err := c.get(key)
+	if err != nil {
+		return err
+	}
+	return utils.Deserialize(data, value)
+}
+
+// Set (see CacheStore interface)
+func (c *S3Store) Set(key string, value interface{}, expires time.Duration) error {
+	return c.put(key, value, c.expiresAt(expires))
+}
+
+// Add (see CacheStore interface)
+func (c *S3Store) Add(key string, value interface{}, expires time.Duration) error {
+	exists, err := c.exists(key)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrNotStored
+	}
+	return c.put(key, value, c.expiresAt(expires))
+}
+
+// Replace (see CacheStore interface)
+func (c *S3Store) Replace(key string, value interface{}, expires time.Duration) error {
+	exists, err := c.exists(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotStored
+	}
+	return c.put(key, value, c.expiresAt(expires))
+}
+
+// Delete (see CacheStore interface)
+func (c *S3Store) Delete(key string) error {
+	exists, err := c.exists(key)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrCacheMiss
+	}
+	return c.delete(key)
+}
+
+// Increment (see CacheStore interface)
+//
+// Like InMemoryStore, the counter wraps around on overflow.
+func (c *S3Store) Increment(key string, n uint64) (uint64, error) {
+	return c.incrDecr(key, func(value uint64) uint64 {
+		return value + n
+	})
+}
+
+// Decrement (see CacheStore interface)
+//
+// Like InMemoryStore, the counter stops at 0.
+func (c *S3Store) Decrement(key string, n uint64) (uint64, error) {
+	return c.incrDecr(key, func(value uint64) uint64 {
+		if n > value {
+			return 0
+		}
+		return value - n
+	})
+}
+
+// incrDecr updates the counter at key with fn, keeping its expiration
+func (c *S3Store) incrDecr(key string, fn func(uint64) uint64) (uint64, error) {
+	data, expiresAt, err := c.get(key)
+	if err != nil {
+		return 0, err
+	}
+	var value uint64
+	if err := utils.Deserialize(data, &value); err != nil {
+		return 0, err
+	}
+	value = fn(value)
+	return value, c.put(key, value, expiresAt)
+}
+
+// Flush (see CacheStore interface)
+//
+// Flush lists the objects under the prefix set with WithS3Prefix, or the whole
+// bucket, and deletes them in batches.
+func (c *S3Store) Flush() error {
+	var objects []*s3.ObjectIdentifier
+	err := c.client.ListObjectsV2PagesWithContext(c.ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(c.bucket),
+		Prefix: aws.String(c.prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, object := range page.Contents {
+			objects = append(objects, &s3.ObjectIdentifier{Key: object.Key})
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(objects); start += s3DeleteBatchSize {
+		end := start + s3DeleteBatchSize
+		if end > len(objects) {
+			end = len(objects)
+		}
+		_, err := c.client.DeleteObjectsWithContext(c.ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(c.bucket),
+			Delete: &s3.Delete{Objects: objects[start:end], Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close (see CacheStore interface)
+//
+// It does nothing, as the client may be shared.
+func (c *S3Store) Close(ctx context.Context) error {
+	return nil
+}
+
+// GetMulti (see CacheStore interface)
+func (c *S3Store) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	return getMulti(c, keys, values)
+}
+
+// SetMulti (see CacheStore interface)
+func (c *S3Store) SetMulti(items map[string]Item) error {
+	return setMulti(c, items)
+}
+
+// get returns the serialized value at key and its expiration, or
+// ErrCacheMiss if it is missing or expired. Expired objects are deleted.
+func (c *S3Store) get(key string) ([]byte, int64, error) {
+	out, err := c.client.GetObjectWithContext(c.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(c.objectKey(key)),
+	})
+	if isS3NotFound(err) {
+		return nil, 0, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer out.Body.Close()
+
+	expiresAt := s3ExpiresAt(out.Metadata)
+	if c.expired(expiresAt) {
+		c.delete(key)
+		return nil, 0, ErrCacheMiss
+	}
+	data, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, expiresAt, nil
+}
+
+// exists reports whether an object that has not expired is stored at key
+func (c *S3Store) exists(key string) (bool, error) {
+	out, err := c.client.HeadObjectWithContext(c.ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(c.objectKey(key)),
+	})
+	if isS3NotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !c.expired(s3ExpiresAt(out.Metadata)), nil
+}
+
+// put writes the object at key; it has no expiration if expiresAt is 0
+func (c *S3Store) put(key string, value interface{}, expiresAt int64) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(c.objectKey(key)),
+		Body:   bytes.NewReader(b),
+	}
+	if expiresAt != 0 {
+		input.Metadata = map[string]*string{s3ExpiresMetadata: aws.String(strconv.FormatInt(expiresAt, 10))}
+	}
+	_, err = c.client.PutObjectWithContext(c.ctx, input)
+	return err
+}
+
+func (c *S3Store) delete(key string) error {
+	_, err := c.client.DeleteObjectWithContext(c.ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(c.objectKey(key)),
+	})
+	return err
+}
+
+func (c *S3Store) objectKey(key string) string {
+	return c.prefix + key
+}
+
+// expiresAt returns the expiration time, in Unix nanoseconds, of an item set
+// now for expires, or 0 if it never expires
+func (c *S3Store) expiresAt(expires time.Duration) int64 {
+	switch expires {
+	case DEFAULT:
+		expires = c.defaultExpiration
+	case FOREVER:
+		return 0
+	}
+	if expires <= 0 {
+		return 0
+	}
+	return time.Now().Add(expires).UnixNano()
+}
+
+func (c *S3Store) expired(expiresAt int64) bool {
+	return expiresAt != 0 && expiresAt <= time.Now().UnixNano()
+}
+
+// s3ExpiresAt returns the expiration recorded in metadata, 0 if none
+func s3ExpiresAt(metadata map[string]*string) int64 {
+	expiresAt, err := strconv.ParseInt(aws.StringValue(metadata[s3ExpiresMetadata]), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return expiresAt
+}
+
+// isS3NotFound reports whether err reports a missing object. HeadObject has no
+// body to return the error code in, and reports a plain NotFound.
+func isS3NotFound(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound")
+}
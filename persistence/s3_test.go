@@ -0,0 +1,182 @@
+package persistence
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// fakeS3 is an in-memory S3 bucket. Like S3, it keeps expired objects.
+type fakeS3 struct {
+	s3iface.S3API
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data     []byte
+	metadata map[string]*string
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string]fakeObject)}
+}
+
+func (f *fakeS3) GetObjectWithContext(_ aws.Context, in *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	object, ok := f.objects[*in.Key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "no such key", nil)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(object.data)), Metadata: object.metadata}, nil
+}
+
+func (f *fakeS3) HeadObjectWithContext(_ aws.Context, in *s3.HeadObjectInput, _ ...request.Option) (*s3.HeadObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	object, ok := f.objects[*in.Key]
+	if !ok {
+		return nil, awserr.New("NotFound", "not found", nil)
+	}
+	return &s3.HeadObjectOutput{Metadata: object.metadata}, nil
+}
+
+func (f *fakeS3) PutObjectWithContext(_ aws.Context, in *s3.PutObjectInput, _ ...request.Option) (*s3.PutObjectOutput, error) {
+	data, _ := ioutil.ReadAll(in.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[*in.Key] = fakeObject{data: data, metadata: in.Metadata}
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObjectWithContext(_ aws.Context, in *s3.DeleteObjectInput, _ ...request.Option) (*s3.DeleteObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.objects, *in.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObjectsWithContext(_ aws.Context, in *s3.DeleteObjectsInput, _ ...request.Option) (*s3.DeleteObjectsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(in.Delete.Objects) > s3DeleteBatchSize {
+		return nil, awserr.New("MalformedXML", "too many objects", nil)
+	}
+	for _, object := range in.Delete.Objects {
+		delete(f.objects, *object.Key)
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+func (f *fakeS3) ListObjectsV2PagesWithContext(_ aws.Context, in *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, _ ...request.Option) error {
+	f.mu.Lock()
+	page := &s3.ListObjectsV2Output{}
+	for key := range f.objects {
+		if strings.HasPrefix(key, aws.StringValue(in.Prefix)) {
+			page.Contents = append(page.Contents, &s3.Object{Key: aws.String(key)})
+		}
+	}
+	f.mu.Unlock()
+	fn(page, true)
+	return nil
+}
+
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var newS3Store = func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
+	return NewS3Store(newFakeS3(), "cache", defaultExpiration)
+}
+
+// Test typical cache interactions
+func TestS3Cache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newS3Store)
+}
+
+func TestS3Cache_IncrDecr(t *testing.T) {
+	incrDecr(t, newS3Store)
+}
+
+func TestS3Cache_CounterPresence(t *testing.T) {
+	counterPresence(t, newS3Store)
+}
+
+func TestS3Cache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newS3Store)
+}
+
+func TestS3Cache_Expiration(t *testing.T) {
+	expiration(t, newS3Store)
+}
+
+func TestS3Cache_EmptyCache(t *testing.T) {
+	emptyCache(t, newS3Store)
+}
+
+func TestS3Cache_Replace(t *testing.T) {
+	testReplace(t, newS3Store)
+}
+
+func TestS3Cache_Add(t *testing.T) {
+	testAdd(t, newS3Store)
+}
+
+func TestS3Cache_LazyExpiry(t *testing.T) {
+	client := newFakeS3()
+	store := NewS3Store(client, "cache", time.Hour)
+	store.Set("a", "value", DEFAULT)
+	client.objects["a"].metadata[s3ExpiresMetadata] = aws.String(strconv.FormatInt(time.Now().UnixNano()-1, 10))
+
+	// The expired object is still in the bucket, but Add can overwrite it
+	if err := store.Add("a", "new", DEFAULT); err != nil {
+		t.Errorf("Expected Add to overwrite an expired object, got %s", err)
+	}
+	store.Set("b", "value", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	var s string
+	if err := store.Get("b", &s); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss, got: %v", err)
+	}
+	if keys := client.keys(); len(keys) != 1 || keys[0] != "a" {
+		t.Errorf("Expected the expired object to be deleted on Get, got %v", keys)
+	}
+}
+
+func TestS3Cache_Prefix(t *testing.T) {
+	client := newFakeS3()
+	client.objects["other"] = fakeObject{data: []byte("kept")}
+	store := NewS3Store(client, "cache", time.Hour, WithS3Prefix("cache/"))
+	for i := 0; i < s3DeleteBatchSize+1; i++ {
+		store.Set(strconv.Itoa(i), i, DEFAULT)
+	}
+	if _, ok := client.objects["cache/1"]; !ok {
+		t.Errorf("Expected objects under the prefix, got %d objects", len(client.objects))
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Error flushing: %s", err)
+	}
+	if keys := client.keys(); len(keys) != 1 || keys[0] != "other" {
+		t.Errorf("Expected Flush to only delete objects under the prefix, got %v", keys)
+	}
+}
@@ -0,0 +1,322 @@
+package persistence
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ShardedStore is a CacheStore distributing its keys across several stores,
+// e.g. memcached or Redis servers, by consistent hashing, to scale the cache
+// horizontally without a proxy. Each shard owns the keys hashed closest to
+// its points on a ring, so that adding or removing a shard only moves the
+// keys it gains or loses. Shards are named: a shard keeps its keys as long
+// as its name does, whatever the others.
+type ShardedStore struct {
+	shards  *shardSet
+	ctx     context.Context
+	replace ShardReplacer
+}
+
+// shardSet holds the shards of a ShardedStore and their ring
+type shardSet struct {
+	mu     sync.RWMutex
+	stores map[string]*shard
+	ring   *peerRing
+}
+
+// shard is a store of a ShardedStore. Replacing a shard creates another
+// one, so that concurrent failures of the same store replace it once.
+type shard struct {
+	name  string
+	store CacheStore
+}
+
+// ShardReplacer is called with the name of a shard whose store failed with
+// err. It returns the store replacing it, or nil to remove the shard: its
+// keys then move to the other shards.
+type ShardReplacer func(name string, err error) CacheStore
+
+// ShardedOption configures optional behaviour of a ShardedStore
+type ShardedOption func(*ShardedStore)
+
+// WithVirtualNodes sets the number of points of each shard on the ring. More
+// points spread the keys more evenly across the shards, at the cost of a
+// larger ring. The default is 64.
+func WithVirtualNodes(n int) ShardedOption {
+	return func(c *ShardedStore) {
+		c.shards.ring.replicas = n
+	}
+}
+
+// WithShardReplacer sets the function replacing shards whose store fails, the
+// operation being retried once on the replacement, or on the new owner of
+// the key. Without it, operations return the errors of the shards, and
+// shards are only changed with SetShard and RemoveShard.
+func WithShardReplacer(replace ShardReplacer) ShardedOption {
+	return func(c *ShardedStore) {
+		c.replace = replace
+	}
+}
+
+// NewShardedStore returns a ShardedStore distributing keys across shards,
+// keyed by name
+func NewShardedStore(shards map[string]CacheStore, options ...ShardedOption) *ShardedStore {
+	c := &ShardedStore{shards: &shardSet{stores: make(map[string]*shard), ring: &peerRing{}}}
+	for _, option := range options {
+		option(c)
+	}
+	for name, store := range shards {
+		c.shards.stores[name] = &shard{name: name, store: store}
+	}
+	c.shards.updateRing()
+	return c
+}
+
+// WithContext (see ContextBinder interface)
+//
+// The bound store shares the shards of c.
+func (c *ShardedStore) WithContext(ctx context.Context) CacheStore {
+	return &ShardedStore{shards: c.shards, ctx: ctx, replace: c.replace}
+}
+
+// SetShard adds a shard named name, or replaces the store of the shard of
+// that name, which keeps its keys
+func (c *ShardedStore) SetShard(name string, store CacheStore) {
+	c.shards.mu.Lock()
+	defer c.shards.mu.Unlock()
+	c.shards.stores[name] = &shard{name: name, store: store}
+	c.shards.updateRing()
+}
+
+// RemoveShard removes the shard named name: its keys move to the other shards
+func (c *ShardedStore) RemoveShard(name string) {
+	c.shards.mu.Lock()
+	defer c.shards.mu.Unlock()
+	delete(c.shards.stores, name)
+	c.shards.updateRing()
+}
+
+// updateRing places the current shards on the ring, with the lock held
+func (s *shardSet) updateRing() {
+	names := make([]string, 0, len(s.stores))
+	for name := range s.stores {
+		names = append(names, name)
+	}
+	s.ring.set(names)
+}
+
+// owner returns the shard owning key, or nil if there is none
+func (s *shardSet) owner(key string) *shard {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.stores[s.ring.get(key)]
+}
+
+// failed handles the failure of sh with err: it asks replace for a
+// replacement, unless sh was already replaced, and returns false if
+// replace is nil
+func (c *ShardedStore) failed(sh *shard, err error) bool {
+	if c.replace == nil {
+		return false
+	}
+	c.shards.mu.Lock()
+	defer c.shards.mu.Unlock()
+	if c.shards.stores[sh.name] != sh {
+		return true
+	}
+	if store := c.replace(sh.name, err); store != nil {
+		c.shards.stores[sh.name] = &shard{name: sh.name, store: store}
+	} else {
+		delete(c.shards.stores, sh.name)
+	}
+	c.shards.updateRing()
+	return true
+}
+
+// bind returns the store of sh bound to the context of c
+func (c *ShardedStore) bind(sh *shard) CacheStore {
+	if c.ctx == nil {
+		return sh.store
+	}
+	return BindContext(c.ctx, sh.store)
+}
+
+// do runs op on the shard owning key, and once more on its replacement or
+// new owner if it fails and a ShardReplacer is set
+func (c *ShardedStore) do(key string, op func(store CacheStore) error) error {
+	sh := c.shards.owner(key)
+	if sh == nil {
+		return ErrCacheUnavailable
+	}
+	err := op(c.bind(sh))
+	if !isBackendError(err) || !c.failed(sh, err) {
+		return err
+	}
+	if sh = c.shards.owner(key); sh == nil {
+		return err
+	}
+	return op(c.bind(sh))
+}
+
+// Get (see CacheStore interface)
+func (c *ShardedStore) Get(key string, value interface{}) error {
+	return c.do(key, func(store CacheStore) error {
+		return store.Get(key, value)
+	})
+}
+
+// Set (see CacheStore interface)
+func (c *ShardedStore) Set(key string, value interface{}, expires time.Duration) error {
+	return c.do(key, func(store CacheStore) error {
+		return store.Set(key, value, expires)
+	})
+}
+
+// Add (see CacheStore interface)
+func (c *ShardedStore) Add(key string, value interface{}, expires time.Duration) error {
+	return c.do(key, func(store CacheStore) error {
+		return store.Add(key, value, expires)
+	})
+}
+
+// Replace (see CacheStore interface)
+func (c *ShardedStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return c.do(key, func(store CacheStore) error {
+		return store.Replace(key, value, expires)
+	})
+}
+
+// Delete (see CacheStore interface)
+func (c *ShardedStore) Delete(key string) error {
+	return c.do(key, func(store CacheStore) error {
+		return store.Delete(key)
+	})
+}
+
+// Increment (see CacheStore interface)
+func (c *ShardedStore) Increment(key string, delta uint64) (n uint64, err error) {
+	err = c.do(key, func(store CacheStore) error {
+		n, err = store.Increment(key, delta)
+		return err
+	})
+	return n, err
+}
+
+// Decrement (see CacheStore interface)
+func (c *ShardedStore) Decrement(key string, delta uint64) (n uint64, err error) {
+	err = c.do(key, func(store CacheStore) error {
+		n, err = store.Decrement(key, delta)
+		return err
+	})
+	return n, err
+}
+
+// Flush (see CacheStore interface)
+//
+// It flushes every shard, and returns the first error.
+func (c *ShardedStore) Flush() error {
+	c.shards.mu.RLock()
+	shards := make([]*shard, 0, len(c.shards.stores))
+	for _, sh := range c.shards.stores {
+		shards = append(shards, sh)
+	}
+	c.shards.mu.RUnlock()
+
+	var firstErr error
+	for _, sh := range shards {
+		if err := c.bind(sh).Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Close (see CacheStore interface)
+//
+// It closes every shard.
+func (c *ShardedStore) Close(ctx context.Context) error {
+	c.shards.mu.RLock()
+	stores := make([]CacheStore, 0, len(c.shards.stores))
+	for _, sh := range c.shards.stores {
+		stores = append(stores, sh.store)
+	}
+	c.shards.mu.RUnlock()
+	return closeAll(ctx, stores...)
+}
+
+// GetMulti (see CacheStore interface)
+//
+// Keys are read with a GetMulti per shard.
+func (c *ShardedStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	if len(keys) != len(values) {
+		return nil, errGetMultiLength
+	}
+	return c.getMulti(keys, values, false)
+}
+
+// getMulti reads keys into values with a GetMulti per shard, and reads the
+// keys of failed shards again unless retried is set
+func (c *ShardedStore) getMulti(keys []string, values []interface{}, retried bool) ([]bool, error) {
+	byShard := make(map[*shard][]int)
+	for i, key := range keys {
+		sh := c.shards.owner(key)
+		if sh == nil {
+			return nil, ErrCacheUnavailable
+		}
+		byShard[sh] = append(byShard[sh], i)
+	}
+
+	found := make([]bool, len(keys))
+	for sh, indexes := range byShard {
+		shardKeys := make([]string, len(indexes))
+		shardValues := make([]interface{}, len(indexes))
+		for n, i := range indexes {
+			shardKeys[n], shardValues[n] = keys[i], values[i]
+		}
+		shardFound, err := c.bind(sh).GetMulti(shardKeys, shardValues)
+		if isBackendError(err) && !retried && c.failed(sh, err) {
+			shardFound, err = c.getMulti(shardKeys, shardValues, true)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for n, i := range indexes {
+			found[i] = shardFound[n]
+		}
+	}
+	return found, nil
+}
+
+// SetMulti (see CacheStore interface)
+//
+// Items are written with a SetMulti per shard.
+func (c *ShardedStore) SetMulti(items map[string]Item) error {
+	return c.setMulti(items, false)
+}
+
+// setMulti writes items with a SetMulti per shard, and writes the items of
+// failed shards again unless retried is set
+func (c *ShardedStore) setMulti(items map[string]Item, retried bool) error {
+	byShard := make(map[*shard]map[string]Item)
+	for key, item := range items {
+		sh := c.shards.owner(key)
+		if sh == nil {
+			return ErrCacheUnavailable
+		}
+		if byShard[sh] == nil {
+			byShard[sh] = make(map[string]Item)
+		}
+		byShard[sh][key] = item
+	}
+	for sh, shardItems := range byShard {
+		err := c.bind(sh).SetMulti(shardItems)
+		if isBackendError(err) && !retried && c.failed(sh, err) {
+			err = c.setMulti(shardItems, true)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
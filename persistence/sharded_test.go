@@ -0,0 +1,117 @@
+package persistence
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+var newShardedStore = func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
+	return NewShardedStore(map[string]CacheStore{
+		"a": NewInMemoryStore(defaultExpiration),
+		"b": NewInMemoryStore(defaultExpiration),
+		"c": NewInMemoryStore(defaultExpiration),
+	})
+}
+
+// Test typical cache interactions
+func TestShardedCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newShardedStore)
+}
+
+func TestShardedCache_IncrDecr(t *testing.T) {
+	incrDecr(t, newShardedStore)
+}
+
+func TestShardedCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newShardedStore)
+}
+
+func TestShardedCache_Expiration(t *testing.T) {
+	expiration(t, newShardedStore)
+}
+
+func TestShardedCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newShardedStore)
+}
+
+func TestShardedCache_Replace(t *testing.T) {
+	testReplace(t, newShardedStore)
+}
+
+func TestShardedCache_Add(t *testing.T) {
+	testAdd(t, newShardedStore)
+}
+
+func TestShardedCache_Distribution(t *testing.T) {
+	shards := map[string]*InMemoryStore{
+		"a": NewInMemoryStore(time.Hour),
+		"b": NewInMemoryStore(time.Hour),
+		"c": NewInMemoryStore(time.Hour),
+	}
+	store := NewShardedStore(map[string]CacheStore{"a": shards["a"], "b": shards["b"], "c": shards["c"]})
+
+	owners := make(map[string]string)
+	for i := 0; i < 300; i++ {
+		key := "key" + strconv.Itoa(i)
+		store.Set(key, i, DEFAULT)
+		for name, shard := range shards {
+			if exists, _ := shard.Exists(key); exists {
+				owners[key] = name
+			}
+		}
+	}
+	for name, shard := range shards {
+		if keys, _ := shard.Keys(""); len(keys) < 50 {
+			t.Errorf("Expected keys to be spread evenly, got %d on %s", len(keys), name)
+		}
+	}
+
+	store.RemoveShard("c")
+	moved := 0
+	for key, owner := range owners {
+		var n int
+		err := store.Get(key, &n)
+		switch {
+		case owner != "c" && err != nil:
+			t.Errorf("Expected %s to stay on %s, got: %v", key, owner, err)
+		case owner == "c" && err != ErrCacheMiss:
+			t.Errorf("Expected %s to move to another shard, got: %v", key, err)
+		case owner == "c":
+			moved++
+		}
+	}
+	if moved == 0 {
+		t.Errorf("Expected the keys of the removed shard to move")
+	}
+}
+
+func TestShardedCache_Replacer(t *testing.T) {
+	down := &flakyStore{CacheStore: NewInMemoryStore(time.Hour), down: 1}
+	replacement := NewInMemoryStore(time.Hour)
+	var replaced []string
+	store := NewShardedStore(map[string]CacheStore{"down": down}, WithShardReplacer(func(name string, err error) CacheStore {
+		replaced = append(replaced, name)
+		return replacement
+	}))
+
+	if err := store.Set("key", "value", DEFAULT); err != nil {
+		t.Errorf("Expected the write to be retried on the replacement, got: %s", err)
+	}
+	var s string
+	if err := replacement.Get("key", &s); err != nil || s != "value" {
+		t.Errorf("Expected the replacement to hold the value, got: %q, %v", s, err)
+	}
+	if len(replaced) != 1 || replaced[0] != "down" {
+		t.Errorf("Expected the failed shard to be replaced once, got: %v", replaced)
+	}
+
+	store = NewShardedStore(map[string]CacheStore{"down": down, "up": replacement}, WithShardReplacer(func(string, error) CacheStore {
+		return nil
+	}))
+	for i := 0; i < 20; i++ {
+		if err := store.Set("key"+strconv.Itoa(i), i, DEFAULT); err != nil {
+			t.Errorf("Expected the write to go to the remaining shard, got: %s", err)
+		}
+	}
+}
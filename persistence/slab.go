@@ -0,0 +1,358 @@
+package persistence
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mlsen/cache/utils"
+)
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// slabHeaderSize is the size of the header of a slab entry: its total size
+// (uint32), its expiration in Unix nanoseconds, 0 if none (int64), and the
+// length of its key (uint16), followed by the key and the serialized value
+const slabHeaderSize = 4 + 8 + 2
+
+// maxSlabSize is the size of the largest slab entries can be addressed in
+var maxSlabSize uint64 = math.MaxUint32
+
+// SlabStore represents the cache with memory persistence, keeping items
+// serialized in byte slabs allocated once, instead of as Go values. The
+// garbage collector does not scan the slabs, nor the index of each partition,
+// whose keys and values hold no pointers: with millions of items, GC pauses
+// stay as short as with an empty store, unlike with InMemoryStore.
+//
+// Each of the partitions holds up to maxBytes/partitions bytes of entries,
+// allocated when the store is created. Items are appended to the slab of
+// their partition; once it is full, the space of deleted, replaced and
+// expired items is reclaimed, and if that is not enough the oldest items are
+// evicted, first in first out. Items larger than a partition are not stored.
+//
+// Keys are indexed by a 64-bit hash: on the rare collision, the item written
+// last evicts the other.
+type SlabStore struct {
+	shards            []*slabShard
+	defaultExpiration time.Duration
+	stats             *statsCounters
+}
+
+type slabShard struct {
+	sync.Mutex
+	// index maps the hashes of keys to the offsets of their entries
+	index map[uint64]uint32
+	data  []byte
+	// garbage counts the bytes of the entries no longer indexed
+	garbage int
+}
+
+// NewSlabStore returns a SlabStore holding up to maxBytes of entries split in
+// the given number of partitions, which is at most 4GiB per partition
+func NewSlabStore(defaultExpiration time.Duration, maxBytes, partitions int) *SlabStore {
+	if partitions < 1 {
+		partitions = 1
+	}
+	limit := maxBytes / partitions
+	if uint64(limit) > maxSlabSize {
+		limit = int(maxSlabSize)
+	}
+	c := &SlabStore{
+		shards:            make([]*slabShard, partitions),
+		defaultExpiration: defaultExpiration,
+		stats:             &statsCounters{},
+	}
+	for i := range c.shards {
+		c.shards[i] = &slabShard{index: make(map[uint64]uint32), data: make([]byte, 0, limit)}
+	}
+	return c
+}
+
+// Get (see CacheStore interface)
+func (c *SlabStore) Get(key string, value interface{}) error {
+	hash := fnv64a(key)
+	s := c.shard(hash)
+	s.Lock()
+	data, _, found := s.get(hash, key, time.Now())
+	// The entry may move once the lock is released
+	data = append([]byte(nil), data...)
+	s.Unlock()
+
+	if !found {
+		c.stats.countLookup(ErrCacheMiss)
+		return ErrCacheMiss
+	}
+	err := utils.Deserialize(data, value)
+	c.stats.countLookup(err)
+	return err
+}
+
+// Set (see CacheStore interface)
+func (c *SlabStore) Set(key string, value interface{}, expires time.Duration) error {
+	return c.write(key, value, expires, func(found bool) bool { return true })
+}
+
+// Add (see CacheStore interface)
+func (c *SlabStore) Add(key string, value interface{}, expires time.Duration) error {
+	return c.write(key, value, expires, func(found bool) bool { return !found })
+}
+
+// Replace (see CacheStore interface)
+func (c *SlabStore) Replace(key string, value interface{}, expires time.Duration) error {
+	return c.write(key, value, expires, func(found bool) bool { return found })
+}
+
+// Delete (see CacheStore interface)
+func (c *SlabStore) Delete(key string) error {
+	hash := fnv64a(key)
+	s := c.shard(hash)
+	s.Lock()
+	defer s.Unlock()
+	if _, _, found := s.get(hash, key, time.Now()); !found {
+		return ErrCacheMiss
+	}
+	s.remove(hash)
+	return nil
+}
+
+// Increment (see CacheStore interface)
+//
+// Like InMemoryStore, the counter wraps around on overflow.
+func (c *SlabStore) Increment(key string, n uint64) (uint64, error) {
+	return c.incrDecr(key, func(value uint64) uint64 {
+		return value + n
+	})
+}
+
+// Decrement (see CacheStore interface)
+//
+// Like InMemoryStore, the counter stops at 0.
+func (c *SlabStore) Decrement(key string, n uint64) (uint64, error) {
+	return c.incrDecr(key, func(value uint64) uint64 {
+		if n > value {
+			return 0
+		}
+		return value - n
+	})
+}
+
+// incrDecr updates the counter at key with fn, keeping its expiration
+func (c *SlabStore) incrDecr(key string, fn func(uint64) uint64) (uint64, error) {
+	hash := fnv64a(key)
+	s := c.shard(hash)
+	s.Lock()
+	defer s.Unlock()
+	data, expiresAt, found := s.get(hash, key, time.Now())
+	if !found {
+		return 0, ErrCacheMiss
+	}
+	var value uint64
+	if err := utils.Deserialize(data, &value); err != nil {
+		return 0, err
+	}
+	value = fn(value)
+	b, _ := utils.Serialize(value)
+	atomic.AddUint64(&c.stats.evictions, uint64(s.set(hash, key, b, expiresAt)))
+	return value, nil
+}
+
+// Flush (see CacheStore interface)
+func (c *SlabStore) Flush() error {
+	for _, s := range c.shards {
+		s.Lock()
+		s.index = make(map[uint64]uint32)
+		s.data = s.data[:0]
+		s.garbage = 0
+		s.Unlock()
+	}
+	return nil
+}
+
+// Close (see CacheStore interface)
+func (c *SlabStore) Close(ctx context.Context) error {
+	return nil
+}
+
+// GetMulti (see CacheStore interface)
+func (c *SlabStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	return getMulti(c, keys, values)
+}
+
+// SetMulti (see CacheStore interface)
+func (c *SlabStore) SetMulti(items map[string]Item) error {
+	return setMulti(c, items)
+}
+
+// Stats (see StatsStore interface)
+//
+// Entries includes the expired items not reclaimed yet, and Bytes is the size
+// of their entries.
+func (c *SlabStore) Stats() Stats {
+	stats := c.stats.snapshot()
+	stats.Entries, stats.Bytes = 0, 0
+	for _, s := range c.shards {
+		s.Lock()
+		stats.Entries += len(s.index)
+		stats.Bytes += int64(len(s.data) - s.garbage)
+		s.Unlock()
+	}
+	return stats
+}
+
+// write serializes value and stores it at key for expires if store, given
+// whether an item is stored at key, reports it should
+func (c *SlabStore) write(key string, value interface{}, expires time.Duration, store func(found bool) bool) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	if len(key) > math.MaxUint16 {
+		return ErrNotStored
+	}
+	if expires == DEFAULT {
+		expires = c.defaultExpiration
+	}
+	var expiresAt int64
+	if expires > 0 {
+		expiresAt = time.Now().Add(expires).UnixNano()
+	}
+
+	hash := fnv64a(key)
+	s := c.shard(hash)
+	s.Lock()
+	defer s.Unlock()
+	_, _, found := s.get(hash, key, time.Now())
+	if !store(found) {
+		return ErrNotStored
+	}
+	if slabHeaderSize+len(key)+len(b) > cap(s.data) {
+		return ErrNotStored
+	}
+	atomic.AddUint64(&c.stats.evictions, uint64(s.set(hash, key, b, expiresAt)))
+	c.stats.countSet(1, nil)
+	return nil
+}
+
+func (c *SlabStore) shard(hash uint64) *slabShard {
+	return c.shards[hash%uint64(len(c.shards))]
+}
+
+// fnv64a hashes key with 64-bit FNV-1a
+func fnv64a(key string) uint64 {
+	h := uint64(fnvOffset64)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// get returns the serialized value of the item at key and its expiration, if
+// it is stored and not expired at now. The value points into the slab.
+func (s *slabShard) get(hash uint64, key string, now time.Time) ([]byte, int64, bool) {
+	off, ok := s.index[hash]
+	if !ok {
+		return nil, 0, false
+	}
+	entry := s.entry(off)
+	expiresAt := int64(binary.LittleEndian.Uint64(entry[4:]))
+	keyLen := int(binary.LittleEndian.Uint16(entry[12:]))
+	if string(entry[slabHeaderSize:slabHeaderSize+keyLen]) != key {
+		return nil, 0, false
+	}
+	if expiresAt != 0 && expiresAt <= now.UnixNano() {
+		s.remove(hash)
+		return nil, 0, false
+	}
+	return entry[slabHeaderSize+keyLen:], expiresAt, true
+}
+
+// set appends an entry for key, which must fit in the slab, and returns the
+// number of items evicted to make room for it
+func (s *slabShard) set(hash uint64, key string, value []byte, expiresAt int64) (evicted int) {
+	s.remove(hash)
+	size := slabHeaderSize + len(key) + len(value)
+	if len(s.data)+size > cap(s.data) {
+		evicted = s.compact(size, time.Now())
+	}
+	off := len(s.data)
+	var header [slabHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:], uint32(size))
+	binary.LittleEndian.PutUint64(header[4:], uint64(expiresAt))
+	binary.LittleEndian.PutUint16(header[12:], uint16(len(key)))
+	s.data = append(s.data, header[:]...)
+	s.data = append(s.data, key...)
+	s.data = append(s.data, value...)
+	s.index[hash] = uint32(off)
+	return evicted
+}
+
+// remove drops the item indexed at hash, if any, leaving its entry as garbage
+func (s *slabShard) remove(hash uint64) {
+	if off, ok := s.index[hash]; ok {
+		s.garbage += len(s.entry(off))
+		delete(s.index, hash)
+	}
+}
+
+func (s *slabShard) entry(off uint32) []byte {
+	size := binary.LittleEndian.Uint32(s.data[off:])
+	return s.data[off : off+size]
+}
+
+// compact moves the live entries to the start of the slab, dropping the
+// garbage and the items expired at now, then evicting the oldest items until
+// need bytes are free. It returns the number of items evicted.
+func (s *slabShard) compact(need int, now time.Time) (evicted int) {
+	live := func(off int) (uint64, bool) {
+		entry := s.entry(uint32(off))
+		keyLen := int(binary.LittleEndian.Uint16(entry[12:]))
+		hash := fnv64a(string(entry[slabHeaderSize : slabHeaderSize+keyLen]))
+		if indexed, ok := s.index[hash]; !ok || int(indexed) != off {
+			return hash, false
+		}
+		expiresAt := int64(binary.LittleEndian.Uint64(entry[4:]))
+		if expiresAt != 0 && expiresAt <= now.UnixNano() {
+			delete(s.index, hash)
+			return hash, false
+		}
+		return hash, true
+	}
+
+	liveBytes := 0
+	for off := 0; off < len(s.data); off += len(s.entry(uint32(off))) {
+		if _, ok := live(off); ok {
+			liveBytes += len(s.entry(uint32(off)))
+		}
+	}
+	excess := liveBytes + need - cap(s.data)
+
+	// Entries only move towards the start: none is overwritten before it is
+	// read, and no entry lands on the offset of an entry not read yet
+	w := 0
+	for off := 0; off < len(s.data); {
+		size := len(s.entry(uint32(off)))
+		hash, ok := live(off)
+		switch {
+		case !ok:
+		case excess > 0:
+			delete(s.index, hash)
+			excess -= size
+			evicted++
+		default:
+			copy(s.data[w:], s.data[off:off+size])
+			s.index[hash] = uint32(w)
+			w += size
+		}
+		off += size
+	}
+	s.data = s.data[:w]
+	s.garbage = 0
+	return evicted
+}
@@ -0,0 +1,84 @@
+package persistence
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var newSlabStore = func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
+	return NewSlabStore(defaultExpiration, 1<<20, 4)
+}
+
+// Test typical cache interactions
+func TestSlabCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newSlabStore)
+}
+
+func TestSlabCache_IncrDecr(t *testing.T) {
+	incrDecr(t, newSlabStore)
+}
+
+func TestSlabCache_CounterPresence(t *testing.T) {
+	counterPresence(t, newSlabStore)
+}
+
+func TestSlabCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newSlabStore)
+}
+
+func TestSlabCache_Expiration(t *testing.T) {
+	expiration(t, newSlabStore)
+}
+
+func TestSlabCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newSlabStore)
+}
+
+func TestSlabCache_Replace(t *testing.T) {
+	testReplace(t, newSlabStore)
+}
+
+func TestSlabCache_Add(t *testing.T) {
+	testAdd(t, newSlabStore)
+}
+
+func TestSlabCache_NegativeCaching(t *testing.T) {
+	negativeCaching(t, newSlabStore)
+}
+
+func TestSlabCache_Compaction(t *testing.T) {
+	// A single partition of 1000 bytes, holding 20 entries of 50 bytes
+	store := NewSlabStore(time.Hour, 1000, 1)
+	value := make([]byte, 50-slabHeaderSize-2)
+	for i := 0; i < 20; i++ {
+		store.Set(fmt.Sprintf("%02d", i), value, DEFAULT)
+	}
+	// Rewriting keys leaves their previous entries as garbage, which is
+	// reclaimed rather than evicting items
+	for i := 0; i < 5; i++ {
+		store.Set("00", value, DEFAULT)
+	}
+	store.Delete("01")
+	store.Set("20", value, DEFAULT)
+	if stats := store.Stats(); stats.Entries != 20 || stats.Evictions != 0 || stats.Bytes != 1000 {
+		t.Errorf("Expected 20 entries in 1000 bytes without evictions, got %+v", stats)
+	}
+
+	// Without garbage, the oldest items are evicted
+	store.Set("21", value, DEFAULT)
+	store.Set("22", value, DEFAULT)
+	var b []byte
+	for key, found := range map[string]bool{"02": false, "03": false, "04": true, "00": true, "22": true} {
+		if err := store.Get(key, &b); (err == nil) != found {
+			t.Errorf("Expected %s found: %v, got: %v", key, found, err)
+		}
+	}
+	if stats := store.Stats(); stats.Evictions != 2 {
+		t.Errorf("Expected 2 evictions, got %d", stats.Evictions)
+	}
+
+	if err := store.Set("large", make([]byte, 1000), DEFAULT); err != ErrNotStored {
+		t.Errorf("Expected ErrNotStored for an item larger than the partition, got: %v", err)
+	}
+}
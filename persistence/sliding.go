@@ -0,0 +1,71 @@
+package persistence
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"time"
+)
+
+// SlidingStore is a CacheStore extending the expiration of the items it reads
+// to a fixed duration, so that items such as sessions live as long as they
+// are used: an item expires once it has not been read for that long. Writes
+// keep the expiration they are given.
+//
+// The expiration is extended with Touch when the wrapped store is a
+// TouchStore. Other stores get the item written again, value included.
+type SlidingStore struct {
+	CacheStore
+
+	expires time.Duration
+}
+
+// NewSlidingStore returns a SlidingStore wrapping store, which extends the
+// expiration of the items read to expires from the time they are read
+func NewSlidingStore(store CacheStore, expires time.Duration) *SlidingStore {
+	return &SlidingStore{CacheStore: store, expires: expires}
+}
+
+// WithContext (see ContextBinder interface)
+func (s *SlidingStore) WithContext(ctx context.Context) CacheStore {
+	return &SlidingStore{CacheStore: BindContext(ctx, s.CacheStore), expires: s.expires}
+}
+
+// Get (see CacheStore interface)
+func (s *SlidingStore) Get(key string, value interface{}) error {
+	if err := s.CacheStore.Get(key, value); err != nil {
+		return err
+	}
+	s.slide(key, value)
+	return nil
+}
+
+// GetMulti (see CacheStore interface)
+func (s *SlidingStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	found, err := s.CacheStore.GetMulti(keys, values)
+	for i, ok := range found {
+		if ok {
+			s.slide(keys[i], values[i])
+		}
+	}
+	return found, err
+}
+
+// Touch (see TouchStore interface)
+//
+// It returns ErrNotSupport if the wrapped store is not a TouchStore.
+func (s *SlidingStore) Touch(key string, expires time.Duration) error {
+	return Touch(s.CacheStore, key, expires)
+}
+
+// slide extends the expiration of the item at key, read into value. Failures
+// are logged: the item was read all the same.
+func (s *SlidingStore) slide(key string, value interface{}) {
+	err := Touch(s.CacheStore, key, s.expires)
+	if err == ErrNotSupport {
+		err = s.CacheStore.Set(key, reflect.ValueOf(value).Elem().Interface(), s.expires)
+	}
+	if err != nil && err != ErrCacheMiss {
+		log.Printf("cache: extending the expiration of %s: %s", key, err)
+	}
+}
@@ -0,0 +1,43 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+var newSlidingStore = func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
+	return NewSlidingStore(NewInMemoryStore(defaultExpiration), defaultExpiration)
+}
+
+// Test typical cache interactions
+func TestSlidingCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newSlidingStore)
+}
+
+func TestSlidingCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newSlidingStore)
+}
+
+func TestSlidingStore_Slide(t *testing.T) {
+	for name, backend := range map[string]CacheStore{
+		"touch":   NewInMemoryStore(time.Hour),
+		"rewrite": NewSlabStore(time.Hour, 1<<20, 1),
+	} {
+		store := NewSlidingStore(backend, 400*time.Millisecond)
+		if err := store.Set("session", "value", 400*time.Millisecond); err != nil {
+			t.Fatalf("%s: error setting a value: %s", name, err)
+		}
+
+		var s string
+		for i := 0; i < 3; i++ {
+			time.Sleep(250 * time.Millisecond)
+			if err := store.Get("session", &s); err != nil || s != "value" {
+				t.Errorf("%s: expected the item to live while read, got: %q, %v", name, s, err)
+			}
+		}
+		time.Sleep(600 * time.Millisecond)
+		if err := store.Get("session", &s); err != ErrCacheMiss {
+			t.Errorf("%s: expected the item to expire once no longer read, got: %v", name, err)
+		}
+	}
+}
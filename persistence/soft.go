@@ -0,0 +1,56 @@
+package persistence
+
+import (
+	"time"
+
+	"github.com/mlsen/cache/utils"
+)
+
+// softEntry is the envelope SetSoft stores: the serialized value, the time
+// after which it is stale, and the time after which it is gone, zero if the
+// store's expiration alone applies
+type softEntry struct {
+	Value      []byte
+	StaleAfter time.Time
+	ExpiresAt  time.Time
+}
+
+// SetSoft stores value at key with two expirations: after softTTL, GetSoft
+// still returns the value but reports it stale, so that the caller can serve
+// it while refreshing it; after hardTTL, it is a miss. hardTTL accepts
+// DEFAULT and FOREVER like the expiration of Set, and should be longer than
+// softTTL.
+//
+// The value is stored in an envelope holding both expirations: it must be
+// read with GetSoft, and is only as serializable as utils.Serialize allows.
+func SetSoft(store CacheStore, key string, value interface{}, softTTL, hardTTL time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	entry := softEntry{Value: b, StaleAfter: now.Add(softTTL)}
+	if hardTTL > 0 {
+		entry.ExpiresAt = now.Add(hardTTL)
+	}
+	return store.Set(key, entry, hardTTL)
+}
+
+// GetSoft reads the value stored by SetSoft at key into value, and reports
+// whether it is past its soft TTL. It returns ErrCacheMiss once the value is
+// past its hard TTL, even if the store, such as memcached with its one second
+// resolution, still holds it.
+func GetSoft(store CacheStore, key string, value interface{}) (stale bool, err error) {
+	var entry softEntry
+	if err := store.Get(key, &entry); err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if !entry.ExpiresAt.IsZero() && !now.Before(entry.ExpiresAt) {
+		return false, ErrCacheMiss
+	}
+	if err := utils.Deserialize(entry.Value, value); err != nil {
+		return false, err
+	}
+	return !now.Before(entry.StaleAfter), nil
+}
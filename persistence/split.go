@@ -0,0 +1,190 @@
+package persistence
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SplitStore sends reads and writes to different stores, typically reads to
+// the replicas of a Redis primary receiving the writes, so that the replicas
+// share the load of the reads. Replicas lag behind the primary: a value read
+// right after being written may be stale, or missing.
+type SplitStore struct {
+	read  CacheStore
+	write CacheStore
+
+	maxLag time.Duration
+	recent *recentWrites
+}
+
+// NewSplitStore returns a SplitStore reading from read and writing to write.
+// If maxLag is positive, keys written through the store are read from write
+// until maxLag has elapsed, as are all keys after a Flush, so that an
+// instance reads its own writes despite the replication lag; maxLag should
+// then bound the lag of read. Otherwise reads are tolerated to be stale and
+// always go to read.
+func NewSplitStore(read, write CacheStore, maxLag time.Duration) *SplitStore {
+	return &SplitStore{read: read, write: write, maxLag: maxLag, recent: &recentWrites{keys: make(map[string]time.Time)}}
+}
+
+// WithContext (see ContextBinder interface)
+func (c *SplitStore) WithContext(ctx context.Context) CacheStore {
+	return &SplitStore{
+		read:   BindContext(ctx, c.read),
+		write:  BindContext(ctx, c.write),
+		maxLag: c.maxLag,
+		recent: c.recent,
+	}
+}
+
+// reader returns the store to read keys from
+func (c *SplitStore) reader(keys ...string) CacheStore {
+	if c.maxLag > 0 && c.recent.any(keys, time.Now()) {
+		return c.write
+	}
+	return c.read
+}
+
+// written records that keys were written, if reads of them must go to the
+// write store for a while
+func (c *SplitStore) written(keys ...string) {
+	if c.maxLag > 0 {
+		c.recent.add(keys, time.Now().Add(c.maxLag))
+	}
+}
+
+// Get (see CacheStore interface)
+func (c *SplitStore) Get(key string, value interface{}) error {
+	return c.reader(key).Get(key, value)
+}
+
+// Set (see CacheStore interface)
+func (c *SplitStore) Set(key string, value interface{}, expires time.Duration) error {
+	c.written(key)
+	return c.write.Set(key, value, expires)
+}
+
+// Add (see CacheStore interface)
+func (c *SplitStore) Add(key string, value interface{}, expires time.Duration) error {
+	c.written(key)
+	return c.write.Add(key, value, expires)
+}
+
+// Replace (see CacheStore interface)
+func (c *SplitStore) Replace(key string, value interface{}, expires time.Duration) error {
+	c.written(key)
+	return c.write.Replace(key, value, expires)
+}
+
+// Delete (see CacheStore interface)
+func (c *SplitStore) Delete(key string) error {
+	c.written(key)
+	return c.write.Delete(key)
+}
+
+// Increment (see CacheStore interface)
+func (c *SplitStore) Increment(key string, delta uint64) (uint64, error) {
+	c.written(key)
+	return c.write.Increment(key, delta)
+}
+
+// Decrement (see CacheStore interface)
+func (c *SplitStore) Decrement(key string, delta uint64) (uint64, error) {
+	c.written(key)
+	return c.write.Decrement(key, delta)
+}
+
+// Flush (see CacheStore interface)
+func (c *SplitStore) Flush() error {
+	if c.maxLag > 0 {
+		c.recent.flush(time.Now().Add(c.maxLag))
+	}
+	return c.write.Flush()
+}
+
+// Close (see CacheStore interface)
+//
+// It closes the stores read from and written to.
+func (c *SplitStore) Close(ctx context.Context) error {
+	if c.read == c.write {
+		return c.write.Close(ctx)
+	}
+	return closeAll(ctx, c.read, c.write)
+}
+
+// GetMulti (see CacheStore interface)
+//
+// The keys are read from the write store if any of them has to be.
+func (c *SplitStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	return c.reader(keys...).GetMulti(keys, values)
+}
+
+// SetMulti (see CacheStore interface)
+func (c *SplitStore) SetMulti(items map[string]Item) error {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	c.written(keys...)
+	return c.write.SetMulti(items)
+}
+
+// recentWrites tracks the keys recently written through a SplitStore, with
+// the time until which they must be read from the write store
+type recentWrites struct {
+	mu        sync.Mutex
+	keys      map[string]time.Time
+	flushed   time.Time
+	nextSweep time.Time
+}
+
+// add records that keys must be read from the write store until until
+func (r *recentWrites) add(keys []string, until time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range keys {
+		r.keys[key] = until
+	}
+	r.sweep(until)
+}
+
+// flush records that every key must be read from the write store until
+// until
+func (r *recentWrites) flush(until time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flushed = until
+	r.keys = make(map[string]time.Time)
+}
+
+// any reports whether any of keys must be read from the write store at now
+func (r *recentWrites) any(keys []string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.Before(r.flushed) {
+		return true
+	}
+	for _, key := range keys {
+		if until, ok := r.keys[key]; ok && now.Before(until) {
+			return true
+		}
+	}
+	return false
+}
+
+// sweep forgets the keys which can be read from the read store again, at
+// most once per lag so that writes do not scan every tracked key: until is
+// when the keys just written can be.
+func (r *recentWrites) sweep(until time.Time) {
+	now := time.Now()
+	if now.Before(r.nextSweep) {
+		return
+	}
+	for key, t := range r.keys {
+		if !now.Before(t) {
+			delete(r.keys, key)
+		}
+	}
+	r.nextSweep = until
+}
@@ -0,0 +1,93 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+var newSplitStore = func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
+	store := NewInMemoryStore(defaultExpiration)
+	return NewSplitStore(store, store, 0)
+}
+
+// Test typical cache interactions
+func TestSplitCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newSplitStore)
+}
+
+func TestSplitCache_IncrDecr(t *testing.T) {
+	incrDecr(t, newSplitStore)
+}
+
+func TestSplitCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newSplitStore)
+}
+
+func TestSplitCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newSplitStore)
+}
+
+func TestSplitCache_Replace(t *testing.T) {
+	testReplace(t, newSplitStore)
+}
+
+func TestSplitCache_Add(t *testing.T) {
+	testAdd(t, newSplitStore)
+}
+
+func TestSplitCache_ReadWriteSplit(t *testing.T) {
+	replica, primary := NewInMemoryStore(time.Hour), NewInMemoryStore(time.Hour)
+	store := NewSplitStore(replica, primary, 0)
+
+	if err := store.Set("key", "primary", DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	var s string
+	if err := primary.Get("key", &s); err != nil || s != "primary" {
+		t.Errorf("Expected the value to be written to the write store, got %s, %v", s, err)
+	}
+	if err := store.Get("key", &s); err != ErrCacheMiss {
+		t.Errorf("Expected a stale read from the read store, got %s, %v", s, err)
+	}
+
+	replica.Set("key", "replica", DEFAULT)
+	if err := store.Get("key", &s); err != nil || s != "replica" {
+		t.Errorf("Expected to read from the read store, got %s, %v", s, err)
+	}
+}
+
+func TestSplitCache_MaxLag(t *testing.T) {
+	replica, primary := NewInMemoryStore(time.Hour), NewInMemoryStore(time.Hour)
+	store := NewSplitStore(replica, primary, 100*time.Millisecond)
+
+	replica.Set("key", "replica", DEFAULT)
+	replica.Set("other", "replica", DEFAULT)
+	store.Set("key", "primary", DEFAULT)
+	var s string
+	if err := store.Get("key", &s); err != nil || s != "primary" {
+		t.Errorf("Expected a recent write to be read from the write store, got %s, %v", s, err)
+	}
+	if err := store.Get("other", &s); err != nil || s != "replica" {
+		t.Errorf("Expected other keys to be read from the read store, got %s, %v", s, err)
+	}
+	found, err := store.GetMulti([]string{"key", "other"}, []interface{}{new(string), new(string)})
+	if err != nil || !found[0] || found[1] {
+		t.Errorf("Expected the keys to be read from the write store, got %v, %v", found, err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if err := store.Get("key", &s); err != nil || s != "replica" {
+		t.Errorf("Expected to read from the read store after the lag, got %s, %v", s, err)
+	}
+
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Error flushing the store: %s", err)
+	}
+	if err := store.Get("other", &s); err != ErrCacheMiss {
+		t.Errorf("Expected keys to be read from the write store after a flush, got %s, %v", s, err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if err := store.Get("other", &s); err != nil || s != "replica" {
+		t.Errorf("Expected to read from the read store after the lag, got %s, %v", s, err)
+	}
+}
@@ -0,0 +1,402 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mlsen/cache/utils"
+)
+
+// SQLDialect selects the SQL flavour spoken by a SQLStore
+type SQLDialect int
+
+const (
+	// Postgres is the dialect of PostgreSQL
+	Postgres SQLDialect = iota
+	// MySQL is the dialect of MySQL and MariaDB
+	MySQL
+	// SQLite is the dialect of SQLite, from version 3.24
+	SQLite
+)
+
+// liveCondition selects the rows that did not expire; it takes the current
+// time as a parameter
+const liveCondition = "(expires_at IS NULL OR expires_at > ?)"
+
+// SQLStore represents the cache with persistence in a SQL database, through
+// database/sql. Items are kept in a table of their own, with a key, a
+// serialized value and an expiration in Unix nanoseconds (NULL for items that
+// never expire). Expired rows are treated as missing when read, and deleted by
+// a background purge.
+type SQLStore struct {
+	db                *sql.DB
+	ctx               context.Context
+	dialect           SQLDialect
+	table             string
+	defaultExpiration time.Duration
+
+	stop     chan struct{}
+	stopOnce *sync.Once
+	done     chan struct{}
+}
+
+// NewSQLStore returns a SQLStore keeping its items in table of db, which is
+// created if needed. db is not closed by the store, so that it can share the
+// connection pool of the application. If purgeInterval is positive, expired
+// rows are deleted at that interval.
+func NewSQLStore(db *sql.DB, dialect SQLDialect, table string, defaultExpiration, purgeInterval time.Duration) (*SQLStore, error) {
+	store := &SQLStore{
+		db:                db,
+		ctx:               context.Background(),
+		dialect:           dialect,
+		table:             table,
+		defaultExpiration: defaultExpiration,
+		stop:              make(chan struct{}),
+		stopOnce:          &sync.Once{},
+		done:              make(chan struct{}),
+	}
+
+	valueType := "BLOB"
+	switch dialect {
+	case Postgres:
+		valueType = "BYTEA"
+	case MySQL:
+		valueType = "LONGBLOB"
+	}
+	_, err := db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (cache_key VARCHAR(250) NOT NULL PRIMARY KEY, cache_value %s NOT NULL, expires_at BIGINT)", table, valueType))
+	if err != nil {
+		return nil, err
+	}
+
+	if purgeInterval > 0 {
+		go store.purge(purgeInterval)
+	} else {
+		close(store.done)
+	}
+	return store, nil
+}
+
+// Close (see CacheStore interface)
+//
+// It stops the purge. The database is left open.
+func (c *SQLStore) Close(ctx context.Context) error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	return awaitDone(ctx, c.done)
+}
+
+func (c *SQLStore) purge(interval time.Duration) {
+	defer close(c.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.DeleteExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// DeleteExpired deletes the expired rows from the table
+func (c *SQLStore) DeleteExpired() error {
+	_, err := c.exec("DELETE FROM %s WHERE expires_at IS NOT NULL AND expires_at <= ?", time.Now().UnixNano())
+	return err
+}
+
+// WithContext (see ContextBinder interface)
+func (c *SQLStore) WithContext(ctx context.Context) CacheStore {
+	bound := *c
+	bound.ctx = ctx
+	return &bound
+}
+
+// Get (see CacheStore interface)
+func (c *SQLStore) Get(key string, value interface{}) error {
+	b, err := c.get(key)
+	if err != nil {
+		return err
+	}
+	return utils.Deserialize(b, value)
+}
+
+// Set (see CacheStore interface)
+func (c *SQLStore) Set(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	_, err = c.exec(c.upsert(), key, b, c.expiresAt(expires))
+	return err
+}
+
+// Add (see CacheStore interface)
+//
+// Add inserts the row, or overwrites it if it expired.
+func (c *SQLStore) Add(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	var query string
+	switch c.dialect {
+	case MySQL:
+		// Assignments are applied in order: expires_at is updated last, so
+		// that the first condition sees the old expiration
+		query = "INSERT INTO %s (cache_key, cache_value, expires_at) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE " +
+			"cache_value = IF(expires_at IS NOT NULL AND expires_at <= ?, VALUES(cache_value), cache_value), " +
+			"expires_at = IF(expires_at IS NOT NULL AND expires_at <= ?, VALUES(expires_at), expires_at)"
+	default:
+		query = "INSERT INTO %s (cache_key, cache_value, expires_at) VALUES (?, ?, ?) ON CONFLICT (cache_key) DO UPDATE " +
+			"SET cache_value = excluded.cache_value, expires_at = excluded.expires_at " +
+			"WHERE %[1]s.expires_at IS NOT NULL AND %[1]s.expires_at <= ?"
+	}
+	now := time.Now().UnixNano()
+	args := []interface{}{key, b, c.expiresAt(expires), now}
+	if c.dialect == MySQL {
+		args = append(args, now)
+	}
+	return c.execAffecting(ErrNotStored, query, args...)
+}
+
+// Replace (see CacheStore interface)
+func (c *SQLStore) Replace(key string, value interface{}, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	return c.execAffecting(ErrNotStored, "UPDATE %s SET cache_value = ?, expires_at = ? WHERE cache_key = ? AND "+liveCondition,
+		b, c.expiresAt(expires), key, time.Now().UnixNano())
+}
+
+// Delete (see CacheStore interface)
+func (c *SQLStore) Delete(key string) error {
+	return c.execAffecting(ErrCacheMiss, "DELETE FROM %s WHERE cache_key = ? AND "+liveCondition, key, time.Now().UnixNano())
+}
+
+// Increment (see CacheStore interface)
+//
+// Like InMemoryStore, the counter wraps around on overflow.
+func (c *SQLStore) Increment(key string, n uint64) (uint64, error) {
+	return c.incrDecr(key, func(value uint64) uint64 {
+		return value + n
+	})
+}
+
+// Decrement (see CacheStore interface)
+//
+// Like InMemoryStore, the counter stops at 0.
+func (c *SQLStore) Decrement(key string, n uint64) (uint64, error) {
+	return c.incrDecr(key, func(value uint64) uint64 {
+		if n > value {
+			return 0
+		}
+		return value - n
+	})
+}
+
+// incrDecr updates the counter at key with fn, keeping its expiration. The
+// update is conditioned on the counter being unchanged since it was read, and
+// retried otherwise.
+func (c *SQLStore) incrDecr(key string, fn func(uint64) uint64) (uint64, error) {
+	for {
+		old, err := c.get(key)
+		if err != nil {
+			return 0, err
+		}
+		var value uint64
+		if err := utils.Deserialize(old, &value); err != nil {
+			return 0, err
+		}
+		value = fn(value)
+		b, err := utils.Serialize(value)
+		if err != nil {
+			return 0, err
+		}
+		if bytes.Equal(b, old) {
+			return value, nil
+		}
+		err = c.execAffecting(ErrNotStored, "UPDATE %s SET cache_value = ? WHERE cache_key = ? AND cache_value = ?", b, key, old)
+		if err == ErrNotStored {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		return value, nil
+	}
+}
+
+// Flush (see CacheStore interface)
+func (c *SQLStore) Flush() error {
+	_, err := c.exec("DELETE FROM %s")
+	return err
+}
+
+// GetMulti (see CacheStore interface)
+//
+// All keys are read with a single query.
+func (c *SQLStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	if len(keys) != len(values) {
+		return nil, errGetMultiLength
+	}
+	found := make([]bool, len(keys))
+	if len(keys) == 0 {
+		return found, nil
+	}
+	index := make(map[string]int, len(keys))
+	args := make([]interface{}, 0, len(keys)+1)
+	for i, key := range keys {
+		index[key] = i
+		args = append(args, key)
+	}
+	args = append(args, time.Now().UnixNano())
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", ")
+	rows, err := c.query("SELECT cache_key, cache_value FROM %s WHERE cache_key IN ("+placeholders+") AND "+liveCondition, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			key string
+			b   []byte
+		)
+		if err := rows.Scan(&key, &b); err != nil {
+			return nil, err
+		}
+		i := index[key]
+		err := utils.Deserialize(b, values[i])
+		if err == ErrNegativeHit {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found[i] = true
+	}
+	return found, rows.Err()
+}
+
+// SetMulti (see CacheStore interface)
+//
+// All items are written in a single transaction.
+func (c *SQLStore) SetMulti(items map[string]Item) error {
+	tx, err := c.db.BeginTx(c.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(c.ctx, c.rebind(fmt.Sprintf(c.upsert(), c.table)))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for key, item := range items {
+		b, err := utils.Serialize(item.Value)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.ExecContext(c.ctx, key, b, c.expiresAt(item.Expire)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// get returns the value of the row at key, or ErrCacheMiss if it is missing
+// or expired
+func (c *SQLStore) get(key string) ([]byte, error) {
+	rows, err := c.query("SELECT cache_value FROM %s WHERE cache_key = ? AND "+liveCondition, key, time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrCacheMiss
+	}
+	var b []byte
+	if err := rows.Scan(&b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// upsert returns the statement inserting a row or overwriting it
+func (c *SQLStore) upsert() string {
+	if c.dialect == MySQL {
+		return "INSERT INTO %s (cache_key, cache_value, expires_at) VALUES (?, ?, ?) " +
+			"ON DUPLICATE KEY UPDATE cache_value = VALUES(cache_value), expires_at = VALUES(expires_at)"
+	}
+	return "INSERT INTO %s (cache_key, cache_value, expires_at) VALUES (?, ?, ?) " +
+		"ON CONFLICT (cache_key) DO UPDATE SET cache_value = excluded.cache_value, expires_at = excluded.expires_at"
+}
+
+// exec runs query, in which %s stands for the table and ? for the parameters
+func (c *SQLStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.db.ExecContext(c.ctx, c.rebind(fmt.Sprintf(query, c.table)), args...)
+}
+
+// execAffecting runs query like exec, and returns errNone if it affected no
+// row
+func (c *SQLStore) execAffecting(errNone error, query string, args ...interface{}) error {
+	res, err := c.exec(query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errNone
+	}
+	return nil
+}
+
+func (c *SQLStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.db.QueryContext(c.ctx, c.rebind(fmt.Sprintf(query, c.table)), args...)
+}
+
+// rebind replaces the ? placeholders of query with $1, $2... for Postgres
+func (c *SQLStore) rebind(query string) string {
+	if c.dialect != Postgres {
+		return query
+	}
+	var buf strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			buf.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// expiresAt returns the expiration time, in Unix nanoseconds, of an item set
+// now for expires, or nil if it never expires
+func (c *SQLStore) expiresAt(expires time.Duration) interface{} {
+	switch expires {
+	case DEFAULT:
+		expires = c.defaultExpiration
+	case FOREVER:
+		return nil
+	}
+	if expires <= 0 {
+		return nil
+	}
+	return time.Now().Add(expires).UnixNano()
+}
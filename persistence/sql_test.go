@@ -0,0 +1,109 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openSQLite(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatalf("Error opening database: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+var newSQLStore = func(t *testing.T, defaultExpiration time.Duration) CacheStore {
+	store, err := NewSQLStore(openSQLite(t), SQLite, "cache", defaultExpiration, time.Minute)
+	if err != nil {
+		t.Fatalf("Error creating store: %s", err)
+	}
+	t.Cleanup(func() { store.Close(context.Background()) })
+	return store
+}
+
+// Test typical cache interactions
+func TestSQLCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newSQLStore)
+}
+
+func TestSQLCache_IncrDecr(t *testing.T) {
+	incrDecr(t, newSQLStore)
+}
+
+func TestSQLCache_CounterPresence(t *testing.T) {
+	counterPresence(t, newSQLStore)
+}
+
+func TestSQLCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newSQLStore)
+}
+
+func TestSQLCache_Expiration(t *testing.T) {
+	expiration(t, newSQLStore)
+}
+
+func TestSQLCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newSQLStore)
+}
+
+func TestSQLCache_Replace(t *testing.T) {
+	testReplace(t, newSQLStore)
+}
+
+func TestSQLCache_Add(t *testing.T) {
+	testAdd(t, newSQLStore)
+}
+
+func TestSQLCache_NegativeCaching(t *testing.T) {
+	negativeCaching(t, newSQLStore)
+}
+
+func TestSQLCache_AddExpired(t *testing.T) {
+	store := newSQLStore(t, time.Hour)
+	store.Set("a", "old", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	if err := store.Add("a", "new", DEFAULT); err != nil {
+		t.Fatalf("Expected Add to overwrite an expired row, got %s", err)
+	}
+	var value string
+	if err := store.Get("a", &value); err != nil || value != "new" {
+		t.Errorf("Expected new, got %q (%v)", value, err)
+	}
+}
+
+func TestSQLCache_DeleteExpired(t *testing.T) {
+	db := openSQLite(t)
+	store, err := NewSQLStore(db, SQLite, "cache", time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Error creating store: %s", err)
+	}
+	store.Set("a", 1, 10*time.Millisecond)
+	store.Set("b", 1, DEFAULT)
+	store.Set("c", 1, FOREVER)
+	time.Sleep(20 * time.Millisecond)
+
+	if err := store.DeleteExpired(); err != nil {
+		t.Fatalf("Error deleting expired rows: %s", err)
+	}
+	var n int
+	db.QueryRow("SELECT COUNT(*) FROM cache").Scan(&n)
+	if n != 2 {
+		t.Errorf("Expected 2 rows left, got %d", n)
+	}
+}
+
+func TestSQLStore_Rebind(t *testing.T) {
+	store := &SQLStore{dialect: Postgres}
+	query := store.rebind("UPDATE t SET a = ? WHERE b = ? AND c = ?")
+	if query != "UPDATE t SET a = $1 WHERE b = $2 AND c = $3" {
+		t.Errorf("Unexpected query %q", query)
+	}
+}
@@ -0,0 +1,244 @@
+package persistence
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// Stats reports the activity of a store since it was created
+type Stats struct {
+	// Hits and Misses count the keys looked up with Get and GetMulti
+	Hits   uint64
+	Misses uint64
+	// Sets counts the items written with Set, Add, Replace and SetMulti
+	Sets uint64
+	// Evictions counts the items the store evicted to make room for others
+	Evictions uint64
+	// Entries is the number of items held, and Bytes an estimate of the
+	// memory they use. Both are -1 when the store does not know them.
+	Entries int
+	Bytes   int64
+}
+
+// HitRatio returns the fraction of the keys looked up that were found, or 0
+// if none was looked up
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// StatsStore is implemented by stores reporting Stats, to expose cache
+// efficiency on health or debug endpoints
+type StatsStore interface {
+	CacheStore
+
+	// Stats returns the current statistics of the store.
+	Stats() Stats
+}
+
+// statsCounters holds the counters of Stats. It is allocated on its own so
+// that its fields are 64-bit aligned for atomic operations.
+type statsCounters struct {
+	hits      uint64
+	misses    uint64
+	sets      uint64
+	evictions uint64
+}
+
+// countLookup counts a single key lookup that returned err as a hit or a miss
+func (s *statsCounters) countLookup(err error) {
+	switch err {
+	case nil:
+		atomic.AddUint64(&s.hits, 1)
+	case ErrCacheMiss, ErrNegativeHit:
+		atomic.AddUint64(&s.misses, 1)
+	}
+}
+
+func (s *statsCounters) countLookups(found []bool) {
+	for _, ok := range found {
+		if ok {
+			atomic.AddUint64(&s.hits, 1)
+		} else {
+			atomic.AddUint64(&s.misses, 1)
+		}
+	}
+}
+
+// countSet counts n items written by an operation that returned err
+func (s *statsCounters) countSet(n int, err error) {
+	if err == nil {
+		atomic.AddUint64(&s.sets, uint64(n))
+	}
+}
+
+func (s *statsCounters) snapshot() Stats {
+	return Stats{
+		Hits:      atomic.LoadUint64(&s.hits),
+		Misses:    atomic.LoadUint64(&s.misses),
+		Sets:      atomic.LoadUint64(&s.sets),
+		Evictions: atomic.LoadUint64(&s.evictions),
+		Entries:   -1,
+		Bytes:     -1,
+	}
+}
+
+// CountingStore is a CacheStore counting the operations of the store it
+// wraps, typically a remote one, to report them as Stats. Entries and Bytes
+// are reported as unknown, and Evictions as 0.
+type CountingStore struct {
+	store    CacheStore
+	counters *statsCounters
+}
+
+// NewCountingStore returns a CountingStore wrapping store
+func NewCountingStore(store CacheStore) *CountingStore {
+	return &CountingStore{store: store, counters: &statsCounters{}}
+}
+
+// Stats (see StatsStore interface)
+func (s *CountingStore) Stats() Stats {
+	return s.counters.snapshot()
+}
+
+// WithContext (see ContextBinder interface)
+func (s *CountingStore) WithContext(ctx context.Context) CacheStore {
+	return &CountingStore{store: BindContext(ctx, s.store), counters: s.counters}
+}
+
+// Get (see CacheStore interface)
+func (s *CountingStore) Get(key string, value interface{}) error {
+	err := s.store.Get(key, value)
+	s.counters.countLookup(err)
+	return err
+}
+
+// Set (see CacheStore interface)
+func (s *CountingStore) Set(key string, value interface{}, expires time.Duration) error {
+	err := s.store.Set(key, value, expires)
+	s.counters.countSet(1, err)
+	return err
+}
+
+// Add (see CacheStore interface)
+func (s *CountingStore) Add(key string, value interface{}, expires time.Duration) error {
+	err := s.store.Add(key, value, expires)
+	s.counters.countSet(1, err)
+	return err
+}
+
+// Replace (see CacheStore interface)
+func (s *CountingStore) Replace(key string, value interface{}, expires time.Duration) error {
+	err := s.store.Replace(key, value, expires)
+	s.counters.countSet(1, err)
+	return err
+}
+
+// Delete (see CacheStore interface)
+func (s *CountingStore) Delete(key string) error {
+	return s.store.Delete(key)
+}
+
+// Increment (see CacheStore interface)
+func (s *CountingStore) Increment(key string, delta uint64) (uint64, error) {
+	return s.store.Increment(key, delta)
+}
+
+// Decrement (see CacheStore interface)
+func (s *CountingStore) Decrement(key string, delta uint64) (uint64, error) {
+	return s.store.Decrement(key, delta)
+}
+
+// Flush (see CacheStore interface)
+func (s *CountingStore) Flush() error {
+	return s.store.Flush()
+}
+
+// Close (see CacheStore interface)
+func (s *CountingStore) Close(ctx context.Context) error {
+	return s.store.Close(ctx)
+}
+
+// GetMulti (see CacheStore interface)
+func (s *CountingStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	found, err := s.store.GetMulti(keys, values)
+	s.counters.countLookups(found)
+	return found, err
+}
+
+// SetMulti (see CacheStore interface)
+func (s *CountingStore) SetMulti(items map[string]Item) error {
+	err := s.store.SetMulti(items)
+	s.counters.countSet(len(items), err)
+	return err
+}
+
+// approximateSize estimates the memory used by v, following pointers,
+// slices, maps and interfaces up to a small depth. Shared and cyclic
+// references are counted every time they are reached.
+func approximateSize(v interface{}) int64 {
+	if v == nil {
+		return 0
+	}
+	return sizeOf(reflect.ValueOf(v), 8)
+}
+
+func sizeOf(v reflect.Value, depth int) int64 {
+	size := int64(v.Type().Size())
+	if depth == 0 {
+		return size
+	}
+	switch v.Kind() {
+	case reflect.String:
+		size += int64(v.Len())
+	case reflect.Slice:
+		if v.Len() == 0 {
+			break
+		}
+		elem := v.Type().Elem()
+		if isFlat(elem.Kind()) {
+			size += int64(v.Len()) * int64(elem.Size())
+			break
+		}
+		for i := 0; i < v.Len(); i++ {
+			size += sizeOf(v.Index(i), depth-1)
+		}
+	case reflect.Array:
+		if isFlat(v.Type().Elem().Kind()) {
+			break
+		}
+		size = 0
+		for i := 0; i < v.Len(); i++ {
+			size += sizeOf(v.Index(i), depth-1)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			size += sizeOf(iter.Key(), depth-1) + sizeOf(iter.Value(), depth-1)
+		}
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			size += sizeOf(v.Elem(), depth-1)
+		}
+	case reflect.Struct:
+		size = 0
+		for i := 0; i < v.NumField(); i++ {
+			size += sizeOf(v.Field(i), depth-1)
+		}
+	}
+	return size
+}
+
+// isFlat reports whether values of kind hold no references
+func isFlat(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map, reflect.Ptr,
+		reflect.Interface, reflect.Struct, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return false
+	}
+	return true
+}
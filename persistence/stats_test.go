@@ -0,0 +1,133 @@
+package persistence
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+var newCountingStore = func(t *testing.T, defaultExpiration time.Duration) CacheStore {
+	return NewCountingStore(NewInMemoryStore(defaultExpiration))
+}
+
+func TestCountingCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newCountingStore)
+}
+
+func TestCountingCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newCountingStore)
+}
+
+func TestCountingCache_Add(t *testing.T) {
+	testAdd(t, newCountingStore)
+}
+
+func TestCountingCache_Stats(t *testing.T) {
+	store := NewCountingStore(newRedisStore(t, time.Hour))
+	var s string
+	store.Set("a", "a", DEFAULT)
+	store.Add("a", "a", DEFAULT)
+	store.Get("a", &s)
+	store.Get("missing", &s)
+	store.GetMulti([]string{"a", "missing"}, []interface{}{&s, &s})
+	store.SetMulti(map[string]Item{"b": {Value: "b"}, "c": {Value: "c"}})
+
+	stats := store.Stats()
+	expected := Stats{Hits: 2, Misses: 2, Sets: 3, Entries: -1, Bytes: -1}
+	if stats != expected {
+		t.Errorf("Expected %+v, got %+v", expected, stats)
+	}
+	if ratio := stats.HitRatio(); ratio != 0.5 {
+		t.Errorf("Expected a hit ratio of 0.5, got %v", ratio)
+	}
+}
+
+func TestInMemoryCache_Stats(t *testing.T) {
+	store := NewInMemoryStore(time.Hour, WithMaxEntries(2, EvictLRU), WithItemInfo())
+	var b []byte
+	store.Set("a", []byte("value"), DEFAULT)
+	store.Set("b", []byte("value"), DEFAULT)
+	store.Set("c", []byte("value"), DEFAULT)
+	store.Get("c", &b)
+	store.Get("a", &b)
+
+	stats := store.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Sets != 3 || stats.Evictions != 1 {
+		t.Errorf("Unexpected counters: %+v", stats)
+	}
+	if stats.Entries != 2 {
+		t.Errorf("Expected 2 entries, got %d", stats.Entries)
+	}
+	if stats.Bytes < 12 || stats.Bytes > 128 {
+		t.Errorf("Expected the size of 2 small items, got %d bytes", stats.Bytes)
+	}
+
+	store.Flush()
+	if stats := store.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("Expected no entries after a flush, got %+v", stats)
+	}
+}
+
+func TestInMemoryCache_ForgetExpiredKeys(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	for i := 0; i < 1000; i++ {
+		store.Set("expired"+strconv.Itoa(i), i, time.Millisecond)
+		store.Set("kept"+strconv.Itoa(i), i, DEFAULT)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := len(store.keys()); n != 1000 {
+		t.Errorf("Expected expired keys to be forgotten, got %d keys", n)
+	}
+	if stats := store.Stats(); stats.Entries != 1000 {
+		t.Errorf("Expected 1000 entries, got %d", stats.Entries)
+	}
+}
+
+func TestInMemoryCache_TrackBytes(t *testing.T) {
+	untracked := NewInMemoryStore(time.Hour)
+	untracked.Set("blob", make([]byte, 1000), DEFAULT)
+	if stats := untracked.Stats(); stats.Entries != 1 || stats.Bytes != -1 {
+		t.Errorf("Expected sizes not to be tracked without WithItemInfo, got %+v", stats)
+	}
+
+	store := NewInMemoryStore(time.Hour, WithItemInfo())
+	store.Set("blob", make([]byte, 1000), DEFAULT)
+	store.Set("counter", 1, DEFAULT)
+	before := store.Stats().Bytes
+	if before < 1000 || before > 1200 {
+		t.Errorf("Expected the size of a 1000 byte blob and a counter, got %d bytes", before)
+	}
+
+	store.Append("blob", make([]byte, 1000))
+	if grown := store.Stats().Bytes; grown-before != 1000 {
+		t.Errorf("Expected 1000 more bytes after an append, got %d", grown-before)
+	}
+	store.Iterate("blob", func(key string, meta Meta) bool {
+		if meta.Size < 2000 {
+			t.Errorf("Expected the size of blob in its metadata, got %d", meta.Size)
+		}
+		return true
+	})
+
+	store.Set("blob", "small", DEFAULT)
+	store.Delete("counter")
+	if stats := store.Stats(); stats.Entries != 1 || stats.Bytes > 64 {
+		t.Errorf("Expected the size of a single small item, got %+v", stats)
+	}
+}
+
+func TestApproximateSize(t *testing.T) {
+	type page struct {
+		Status int
+		Header map[string][]string
+		Data   []byte
+	}
+	small := approximateSize(page{Status: 200, Data: []byte("x")})
+	large := approximateSize(page{Status: 200, Header: map[string][]string{"Content-Type": {"text/plain"}}, Data: make([]byte, 4096)})
+	if large-small < 4096 {
+		t.Errorf("Expected the size to grow with the data, got %d and %d", small, large)
+	}
+	if size := approximateSize(nil); size != 0 {
+		t.Errorf("Expected nil to have no size, got %d", size)
+	}
+}
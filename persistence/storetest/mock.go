@@ -0,0 +1,190 @@
+package storetest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mlsen/cache/persistence"
+)
+
+// Call is an operation made on a MockStore
+type Call struct {
+	// Op is the name of the method called, e.g. "Get" or "SetMulti"
+	Op string
+	// Key is the key of single key operations, and Keys the keys of
+	// GetMulti and SetMulti, sorted for SetMulti
+	Key  string
+	Keys []string
+	// Value is the value written by Set, Add and Replace
+	Value interface{}
+	// Expires is the expiration passed to Set, Add and Replace
+	Expires time.Duration
+	// Delta is the delta passed to Increment and Decrement
+	Delta uint64
+}
+
+// MockStore is a persistence.CacheStore holding its items in memory and
+// recording the calls made to it, so that handlers can be unit tested
+// without a cache server. Operations can be made to fail with FailWith. It
+// is safe for concurrent use.
+type MockStore struct {
+	store *persistence.InMemoryStore
+
+	mu       sync.Mutex
+	calls    []Call
+	failures map[string]error
+}
+
+// NewMockStore returns an empty MockStore whose items set with
+// persistence.DEFAULT expire after defaultExpiration
+func NewMockStore(defaultExpiration time.Duration) *MockStore {
+	return &MockStore{
+		store:    persistence.NewInMemoryStore(defaultExpiration),
+		failures: make(map[string]error),
+	}
+}
+
+// Calls returns the calls made to the store, in order
+func (m *MockStore) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Call(nil), m.calls...)
+}
+
+// CallsTo returns the calls made to the method op, in order
+func (m *MockStore) CallsTo(op string) []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var calls []Call
+	for _, call := range m.calls {
+		if call.Op == op {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Reset forgets the calls recorded and the failures set with FailWith. The
+// items of the store are kept.
+func (m *MockStore) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = nil
+	m.failures = make(map[string]error)
+}
+
+// FailWith makes the calls to the method op fail with err, without
+// touching the items of the store. A nil err makes them succeed again.
+func (m *MockStore) FailWith(op string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
+		delete(m.failures, op)
+		return
+	}
+	m.failures[op] = err
+}
+
+// record records call, and returns the error it must fail with, if any
+func (m *MockStore) record(call Call) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, call)
+	return m.failures[call.Op]
+}
+
+// Get (see CacheStore interface)
+func (m *MockStore) Get(key string, value interface{}) error {
+	if err := m.record(Call{Op: "Get", Key: key}); err != nil {
+		return err
+	}
+	return m.store.Get(key, value)
+}
+
+// Set (see CacheStore interface)
+func (m *MockStore) Set(key string, value interface{}, expires time.Duration) error {
+	if err := m.record(Call{Op: "Set", Key: key, Value: value, Expires: expires}); err != nil {
+		return err
+	}
+	return m.store.Set(key, value, expires)
+}
+
+// Add (see CacheStore interface)
+func (m *MockStore) Add(key string, value interface{}, expires time.Duration) error {
+	if err := m.record(Call{Op: "Add", Key: key, Value: value, Expires: expires}); err != nil {
+		return err
+	}
+	return m.store.Add(key, value, expires)
+}
+
+// Replace (see CacheStore interface)
+func (m *MockStore) Replace(key string, value interface{}, expires time.Duration) error {
+	if err := m.record(Call{Op: "Replace", Key: key, Value: value, Expires: expires}); err != nil {
+		return err
+	}
+	return m.store.Replace(key, value, expires)
+}
+
+// Delete (see CacheStore interface)
+func (m *MockStore) Delete(key string) error {
+	if err := m.record(Call{Op: "Delete", Key: key}); err != nil {
+		return err
+	}
+	return m.store.Delete(key)
+}
+
+// Increment (see CacheStore interface)
+func (m *MockStore) Increment(key string, delta uint64) (uint64, error) {
+	if err := m.record(Call{Op: "Increment", Key: key, Delta: delta}); err != nil {
+		return 0, err
+	}
+	return m.store.Increment(key, delta)
+}
+
+// Decrement (see CacheStore interface)
+func (m *MockStore) Decrement(key string, delta uint64) (uint64, error) {
+	if err := m.record(Call{Op: "Decrement", Key: key, Delta: delta}); err != nil {
+		return 0, err
+	}
+	return m.store.Decrement(key, delta)
+}
+
+// Flush (see CacheStore interface)
+func (m *MockStore) Flush() error {
+	if err := m.record(Call{Op: "Flush"}); err != nil {
+		return err
+	}
+	return m.store.Flush()
+}
+
+// Close (see CacheStore interface)
+func (m *MockStore) Close(ctx context.Context) error {
+	if err := m.record(Call{Op: "Close"}); err != nil {
+		return err
+	}
+	return m.store.Close(ctx)
+}
+
+// GetMulti (see CacheStore interface)
+func (m *MockStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	call := Call{Op: "GetMulti", Keys: append([]string(nil), keys...)}
+	if err := m.record(call); err != nil {
+		return nil, err
+	}
+	return m.store.GetMulti(keys, values)
+}
+
+// SetMulti (see CacheStore interface)
+func (m *MockStore) SetMulti(items map[string]persistence.Item) error {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if err := m.record(Call{Op: "SetMulti", Keys: keys}); err != nil {
+		return err
+	}
+	return m.store.SetMulti(items)
+}
@@ -0,0 +1,301 @@
+// Package storetest provides utilities for testing cache stores: a
+// conformance suite checking that a persistence.CacheStore behaves like the
+// built-in stores, and a MockStore recording the calls made to it, so that
+// handlers can be unit tested without a cache server.
+package storetest
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/mlsen/cache/persistence"
+)
+
+// Factory returns an empty store whose items set with persistence.DEFAULT
+// expire after defaultExpiration. Stores backed by a server should be
+// flushed, and closed with t.Cleanup if needed.
+type Factory func(t *testing.T, defaultExpiration time.Duration) persistence.CacheStore
+
+// Run runs the conformance suite against the stores returned by newStore,
+// each check in its own subtest. Expiration is checked with times of a
+// second, the lowest memcached supports, so the suite takes a few seconds.
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		test func(*testing.T, Factory)
+	}{
+		{"GetSet", testGetSet},
+		{"EmptyStore", testEmptyStore},
+		{"Add", testAdd},
+		{"Replace", testReplace},
+		{"Delete", testDelete},
+		{"IncrementDecrement", testIncrementDecrement},
+		{"CounterPresence", testCounterPresence},
+		{"Expiration", testExpiration},
+		{"GetSetMulti", testGetSetMulti},
+		{"Flush", testFlush},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, newStore)
+		})
+	}
+}
+
+func testGetSet(t *testing.T, newStore Factory) {
+	store := newStore(t, time.Hour)
+
+	if err := store.Set("value", "foo", persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	var s string
+	if err := store.Get("value", &s); err != nil || s != "foo" {
+		t.Errorf("Expected to get foo back, got %q, %v", s, err)
+	}
+
+	if err := store.Set("value", "bar", persistence.DEFAULT); err != nil {
+		t.Fatalf("Error overwriting a value: %s", err)
+	}
+	if err := store.Get("value", &s); err != nil || s != "bar" {
+		t.Errorf("Expected to get bar back, got %q, %v", s, err)
+	}
+
+	type point struct{ X, Y int }
+	if err := store.Set("struct", point{1, 2}, persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting a struct: %s", err)
+	}
+	var p point
+	if err := store.Get("struct", &p); err != nil || p != (point{1, 2}) {
+		t.Errorf("Expected to get {1 2} back, got %v, %v", p, err)
+	}
+}
+
+func testEmptyStore(t *testing.T, newStore Factory) {
+	store := newStore(t, time.Hour)
+
+	var s string
+	if err := store.Get("notexist", &s); err != persistence.ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss getting a non-existent key, got: %v", err)
+	}
+	if err := store.Delete("notexist"); err != persistence.ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss deleting a non-existent key, got: %v", err)
+	}
+	if _, err := store.Increment("notexist", 1); err != persistence.ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss incrementing a non-existent key, got: %v", err)
+	}
+	if _, err := store.Decrement("notexist", 1); err != persistence.ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss decrementing a non-existent key, got: %v", err)
+	}
+}
+
+func testAdd(t *testing.T, newStore Factory) {
+	store := newStore(t, time.Hour)
+
+	if err := store.Add("int", 1, time.Second); err != nil {
+		t.Errorf("Unexpected error adding to an empty store: %s", err)
+	}
+	if err := store.Add("int", 2, time.Second); err != persistence.ErrNotStored {
+		t.Errorf("Expected ErrNotStored adding an existing key, got: %v", err)
+	}
+	var i int
+	if err := store.Get("int", &i); err != nil || i != 1 {
+		t.Errorf("Expected a failed Add to keep 1, got %d, %v", i, err)
+	}
+
+	// Expired items can be added again
+	time.Sleep(2 * time.Second)
+	if err := store.Add("int", 3, time.Second); err != nil {
+		t.Errorf("Unexpected error adding an expired key: %s", err)
+	}
+	if err := store.Get("int", &i); err != nil || i != 3 {
+		t.Errorf("Expected 3, got %d, %v", i, err)
+	}
+}
+
+func testReplace(t *testing.T, newStore Factory) {
+	store := newStore(t, time.Hour)
+
+	if err := store.Replace("notexist", 1, persistence.FOREVER); err != persistence.ErrNotStored && err != persistence.ErrCacheMiss {
+		t.Errorf("Expected ErrNotStored or ErrCacheMiss replacing a non-existent key, got: %v", err)
+	}
+	var i int
+	if err := store.Get("notexist", &i); err != persistence.ErrCacheMiss {
+		t.Errorf("Expected a failed Replace not to store the key, got: %v", err)
+	}
+
+	if err := store.Set("int", 1, time.Second); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	if err := store.Replace("int", 2, time.Second); err != nil {
+		t.Errorf("Unexpected error replacing a value: %s", err)
+	}
+	if err := store.Get("int", &i); err != nil || i != 2 {
+		t.Errorf("Expected 2, got %d, %v", i, err)
+	}
+
+	// Expired items cannot be replaced
+	time.Sleep(2 * time.Second)
+	if err := store.Replace("int", 3, time.Second); err != persistence.ErrNotStored && err != persistence.ErrCacheMiss {
+		t.Errorf("Expected ErrNotStored or ErrCacheMiss replacing an expired key, got: %v", err)
+	}
+	if err := store.Get("int", &i); err != persistence.ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss, got: %v", err)
+	}
+}
+
+func testDelete(t *testing.T, newStore Factory) {
+	store := newStore(t, time.Hour)
+
+	if err := store.Set("value", "foo", persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	if err := store.Delete("value"); err != nil {
+		t.Errorf("Error deleting a value: %s", err)
+	}
+	var s string
+	if err := store.Get("value", &s); err != persistence.ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss getting a deleted key, got: %v", err)
+	}
+	if err := store.Delete("value"); err != persistence.ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss deleting a deleted key, got: %v", err)
+	}
+}
+
+func testIncrementDecrement(t *testing.T, newStore Factory) {
+	store := newStore(t, time.Hour)
+
+	if err := store.Set("int", 10, persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting int: %s", err)
+	}
+	if n, err := store.Increment("int", 50); err != nil || n != 60 {
+		t.Errorf("Expected 60, got %d, %v", n, err)
+	}
+	if n, err := store.Decrement("int", 50); err != nil || n != 10 {
+		t.Errorf("Expected 10, got %d, %v", n, err)
+	}
+	var i int
+	if err := store.Get("int", &i); err != nil || i != 10 {
+		t.Errorf("Expected to read the counter back as 10, got %d, %v", i, err)
+	}
+
+	// Increments wrap around
+	if n, err := store.Increment("int", math.MaxUint64-5); err != nil || n != 4 {
+		t.Errorf("Expected wraparound to 4, got %d, %v", n, err)
+	}
+	// Decrements are capped at 0
+	if n, err := store.Decrement("int", 25); err != nil || n != 0 {
+		t.Errorf("Expected to be capped at 0, got %d, %v", n, err)
+	}
+}
+
+func testCounterPresence(t *testing.T, newStore Factory) {
+	store := newStore(t, time.Hour)
+
+	if err := store.Set("zero", 0, persistence.DEFAULT); err != nil {
+		t.Fatalf("Error setting int: %s", err)
+	}
+	if n, err := store.Increment("zero", 0); err != nil || n != 0 {
+		t.Errorf("Expected to increment a counter set to 0 to 0, got %d, %v", n, err)
+	}
+	if n, err := store.Decrement("zero", 1); err != nil || n != 0 {
+		t.Errorf("Expected to decrement a counter set to 0 to 0, got %d, %v", n, err)
+	}
+	// Counters are not created implicitly
+	if _, err := store.Increment("untouched", 0); err != persistence.ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss incrementing a counter that was never set, got: %v", err)
+	}
+	var i int
+	if err := store.Get("untouched", &i); err != persistence.ErrCacheMiss {
+		t.Errorf("Expected a failed Increment not to create the key, got: %v", err)
+	}
+}
+
+func testExpiration(t *testing.T, newStore Factory) {
+	store := newStore(t, time.Second)
+	items := map[string]time.Duration{
+		"default": persistence.DEFAULT,
+		"short":   time.Second,
+		"long":    time.Hour,
+		"forever": persistence.FOREVER,
+	}
+	for key, expires := range items {
+		if err := store.Set(key, 10, expires); err != nil {
+			t.Fatalf("Error setting %s: %s", key, err)
+		}
+	}
+
+	time.Sleep(2 * time.Second)
+	for key := range items {
+		var i int
+		err := store.Get(key, &i)
+		switch key {
+		case "default", "short":
+			if err != persistence.ErrCacheMiss {
+				t.Errorf("Expected %s to expire, got: %v", key, err)
+			}
+		default:
+			if err != nil || i != 10 {
+				t.Errorf("Expected %s to be kept, got %d, %v", key, i, err)
+			}
+		}
+	}
+}
+
+func testGetSetMulti(t *testing.T, newStore Factory) {
+	store := newStore(t, time.Hour)
+
+	err := store.SetMulti(map[string]persistence.Item{
+		"multi:string": {Value: "foo", Expire: persistence.DEFAULT},
+		"multi:int":    {Value: 42, Expire: persistence.DEFAULT},
+		"multi:empty":  {Value: []byte{}, Expire: persistence.DEFAULT},
+	})
+	if err != nil {
+		t.Fatalf("Error setting values: %s", err)
+	}
+
+	var (
+		s     string
+		i     int
+		empty []byte
+		miss  string
+	)
+	keys := []string{"multi:string", "multi:missing", "multi:int", "multi:empty"}
+	found, err := store.GetMulti(keys, []interface{}{&s, &miss, &i, &empty})
+	if err != nil {
+		t.Fatalf("Error getting values: %s", err)
+	}
+	expected := []bool{true, false, true, true}
+	for n := range keys {
+		if len(found) != len(keys) || found[n] != expected[n] {
+			t.Fatalf("Expected found to be %v, got %v", expected, found)
+		}
+	}
+	if s != "foo" || i != 42 || len(empty) != 0 {
+		t.Errorf("Expected foo, 42 and an empty value, got %q, %d, %q", s, i, empty)
+	}
+
+	if _, err = store.GetMulti(keys, nil); err == nil {
+		t.Errorf("Expected an error for mismatched keys and values")
+	}
+}
+
+func testFlush(t *testing.T, newStore Factory) {
+	store := newStore(t, time.Hour)
+
+	for _, key := range []string{"a", "b"} {
+		if err := store.Set(key, key, persistence.DEFAULT); err != nil {
+			t.Fatalf("Error setting a value: %s", err)
+		}
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Error flushing the store: %s", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		var s string
+		if err := store.Get(key, &s); err != persistence.ErrCacheMiss {
+			t.Errorf("Expected %s to be flushed, got: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package storetest
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mlsen/cache/persistence"
+)
+
+func TestInMemoryStoreConformance(t *testing.T) {
+	Run(t, func(_ *testing.T, defaultExpiration time.Duration) persistence.CacheStore {
+		return persistence.NewInMemoryStore(defaultExpiration)
+	})
+}
+
+func TestMockStoreConformance(t *testing.T) {
+	Run(t, func(_ *testing.T, defaultExpiration time.Duration) persistence.CacheStore {
+		return NewMockStore(defaultExpiration)
+	})
+}
+
+func TestMockStore_RecordsCalls(t *testing.T) {
+	store := NewMockStore(time.Hour)
+	store.Set("a", "value", time.Minute)
+	var s string
+	store.Get("a", &s)
+	store.Increment("counter", 2)
+	store.SetMulti(map[string]persistence.Item{"c": {Value: 1}, "b": {Value: 2}})
+
+	expected := []Call{
+		{Op: "Set", Key: "a", Value: "value", Expires: time.Minute},
+		{Op: "Get", Key: "a"},
+		{Op: "Increment", Key: "counter", Delta: 2},
+		{Op: "SetMulti", Keys: []string{"b", "c"}},
+	}
+	if calls := store.Calls(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected calls %+v, got %+v", expected, calls)
+	}
+	if calls := store.CallsTo("Get"); len(calls) != 1 || calls[0].Key != "a" {
+		t.Errorf("Expected a single Get of a, got %+v", calls)
+	}
+
+	store.Reset()
+	if calls := store.Calls(); len(calls) != 0 {
+		t.Errorf("Expected no calls after a reset, got %+v", calls)
+	}
+	if err := store.Get("a", &s); err != nil || s != "value" {
+		t.Errorf("Expected a reset to keep the items, got %q, %v", s, err)
+	}
+}
+
+func TestMockStore_FailWith(t *testing.T) {
+	store := NewMockStore(time.Hour)
+	errDown := errors.New("down")
+	store.FailWith("Set", errDown)
+
+	if err := store.Set("a", "value", persistence.DEFAULT); err != errDown {
+		t.Errorf("Expected the injected error, got: %v", err)
+	}
+	var s string
+	if err := store.Get("a", &s); err != persistence.ErrCacheMiss {
+		t.Errorf("Expected a failed Set not to store the item, got: %v", err)
+	}
+	if calls := store.CallsTo("Set"); len(calls) != 1 {
+		t.Errorf("Expected failed calls to be recorded, got %+v", calls)
+	}
+
+	store.FailWith("Set", nil)
+	if err := store.Set("a", "value", persistence.DEFAULT); err != nil {
+		t.Errorf("Expected Set to succeed again, got: %v", err)
+	}
+}
+
+func TestMockStore_Concurrent(t *testing.T) {
+	store := NewMockStore(time.Hour)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				store.Set("key", j, persistence.DEFAULT)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls := store.CallsTo("Set"); len(calls) != 1000 {
+		t.Errorf("Expected 1000 recorded calls, got %d", len(calls))
+	}
+}
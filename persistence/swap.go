@@ -0,0 +1,114 @@
+package persistence
+
+import (
+	"time"
+
+	"github.com/mlsen/cache/utils"
+)
+
+// getSetSource sets KEYS[1] to ARGV[1], expiring in ARGV[2] milliseconds if
+// positive, and returns the value it held, if any
+const getSetSource = `
+local old = redis.call("GET", KEYS[1])
+if tonumber(ARGV[2]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+else
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+return old
+`
+
+// getDelSource deletes KEYS[1] and returns the value it held, if any, like
+// GETDEL, which needs Redis 6.2
+const getDelSource = `
+local old = redis.call("GET", KEYS[1])
+if old then
+	redis.call("DEL", KEYS[1])
+end
+return old
+`
+
+// consumedExpiration is how long the emulation of GetDel keeps a consumed
+// item as a negative entry
+const consumedExpiration = time.Second
+
+// SwapStore is implemented by stores able to read an item while replacing or
+// deleting it, atomically, for rotating tokens or consuming one-time values
+// without racing other clients between a Get and a Set or a Delete
+type SwapStore interface {
+	CacheStore
+
+	// GetSet sets key to value, and reads the item it held into old. It
+	// returns ErrCacheMiss if key held no item, value being set all the
+	// same.
+	GetSet(key string, value interface{}, expires time.Duration, old interface{}) error
+
+	// GetDel reads the item at key into value and deletes it. Of concurrent
+	// calls, only one reads the item; the others return ErrCacheMiss.
+	GetDel(key string, value interface{}) error
+}
+
+// GetSet sets key to value in store, and reads the item it held into old.
+// Stores that are not a SwapStore but a CASStore, such as MemcachedStore,
+// emulate it with GetWithVersion and CompareAndSwap, retrying on conflicts.
+// It returns ErrNotSupport for other stores.
+func GetSet(store CacheStore, key string, value interface{}, expires time.Duration, old interface{}) error {
+	if swapStore, ok := store.(SwapStore); ok {
+		return swapStore.GetSet(key, value, expires, old)
+	}
+	casStore, ok := store.(CASStore)
+	if !ok {
+		return ErrNotSupport
+	}
+	for {
+		version, err := casStore.GetWithVersion(key, old)
+		if err != nil && err != ErrCacheMiss && err != ErrNegativeHit {
+			return err
+		}
+		switch swapErr := casStore.CompareAndSwap(key, value, version, expires); swapErr {
+		case nil:
+			if err != nil {
+				return ErrCacheMiss
+			}
+			return nil
+		case ErrCASConflict, ErrCacheMiss:
+			// Written or deleted in between: read it again
+		default:
+			return swapErr
+		}
+	}
+}
+
+// GetDel reads the item at key of store into value and deletes it. Stores
+// that are not a SwapStore but a CASStore emulate it with GetWithVersion and
+// CompareAndSwap, replacing the item with a negative entry (see
+// utils.NegativeValue) that expires after a second, so that a single client
+// reads it and the writes of others are not lost. It returns ErrNotSupport
+// for other stores.
+func GetDel(store CacheStore, key string, value interface{}) error {
+	if swapStore, ok := store.(SwapStore); ok {
+		return swapStore.GetDel(key, value)
+	}
+	casStore, ok := store.(CASStore)
+	if !ok {
+		return ErrNotSupport
+	}
+	for {
+		version, err := casStore.GetWithVersion(key, value)
+		switch err {
+		case nil:
+		case ErrNegativeHit:
+			return ErrCacheMiss
+		default:
+			return err
+		}
+		switch err := casStore.CompareAndSwap(key, utils.NegativeValue(), version, consumedExpiration); err {
+		case nil:
+			return nil
+		case ErrCASConflict:
+			// Written in between: read it again
+		default:
+			return err
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+// casOnlyStore hides the SwapStore methods of the store it wraps
+type casOnlyStore struct {
+	CASStore
+}
+
+func TestSwapEmulation(t *testing.T) {
+	swapValues(t, casOnlyStore{NewInMemoryStore(time.Hour)})
+
+	var s string
+	if err := GetSet(NewSlabStore(time.Hour, 1<<20, 1), "key", "value", time.Hour, &s); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport swapping an item of a store without CAS, got: %v", err)
+	}
+	if err := GetDel(NewSlabStore(time.Hour, 1<<20, 1), "key", &s); err != ErrNotSupport {
+		t.Errorf("Expected ErrNotSupport consuming an item of a store without CAS, got: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package persistence
+
+import "time"
+
+// TagStore is implemented by stores supporting tag based invalidation: keys
+// are associated with tags such as "user:42", and invalidating a tag deletes
+// every key associated with it.
+type TagStore interface {
+	CacheStore
+
+	// Tag associates key with tags. Tagging a key that is not in the cache is
+	// allowed. Stores may forget the tags of a key once it is written again,
+	// deleted or expires, so tag keys after storing them.
+	Tag(key string, tags ...string) error
+
+	// InvalidateTag deletes every key associated with tag.
+	InvalidateTag(tag string) error
+}
+
+// tagSetTTL returns the time to live to give a tag set, which had setTTL left
+// before a member was added whose key has keyTTL left, as reported by PTTL,
+// so that the set outlives its members: 0 to leave it as is, or -1 to make it
+// persistent. Keys tagged before they are stored are given
+// defaultExpiration.
+func tagSetTTL(keyTTL, setTTL, defaultExpiration time.Duration) time.Duration {
+	if keyTTL == -2 {
+		keyTTL = defaultExpiration
+		if keyTTL <= 0 {
+			keyTTL = -1
+		}
+	}
+	switch {
+	case setTTL == -1:
+		// Already persistent
+		return 0
+	case keyTTL == -1:
+		return -1
+	case setTTL == -2 || setTTL < keyTTL:
+		return keyTTL
+	}
+	return 0
+}
+
+// SetWithTags sets an item to the cache like Set, and associates it with tags
+func SetWithTags(store TagStore, key string, value interface{}, expires time.Duration, tags ...string) error {
+	if err := store.Set(key, value, expires); err != nil {
+		return err
+	}
+	return store.Tag(key, tags...)
+}
@@ -0,0 +1,257 @@
+package persistence
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+// TieredStore composes a fast local store (L1), typically an InMemoryStore,
+// with a shared remote store (L2) such as a RedisStore. Reads try L1 first and
+// fall back to L2, copying what they find to L1; writes go to both. L2 is the
+// source of truth: counters are updated there, and dropped from L1.
+type TieredStore struct {
+	local  CacheStore
+	remote CacheStore
+
+	localExpiration time.Duration
+
+	invalidator       *PubSubInvalidator
+	stopInvalidations func()
+}
+
+// NewTieredStore returns a TieredStore reading through local to remote. If
+// localExpiration is positive, items are kept in local for at most that long,
+// which bounds how stale local copies get when another instance updates
+// remote; otherwise local uses the expirations given to remote. Items read
+// from remote are copied to local for at most the time they have left in
+// remote if it is a TTLStore. When that time is unknown, as with other
+// stores or GetMulti, they are only copied if localExpiration is positive.
+func NewTieredStore(local, remote CacheStore, localExpiration time.Duration) *TieredStore {
+	return &TieredStore{local: local, remote: remote, localExpiration: localExpiration}
+}
+
+// BroadcastInvalidations keeps the local stores of the instances sharing
+// remote consistent: keys written through this store are invalidated on the
+// other instances with inv, and the keys they invalidate are evicted from
+// local. Call it before using the store.
+//
+// Calling stop ends the subscription; writes are still broadcast. Close
+// calls stop.
+func (c *TieredStore) BroadcastInvalidations(inv *PubSubInvalidator) (stop func(), err error) {
+	stop, err = inv.Subscribe(func(key string) {
+		c.local.Delete(key)
+	}, func() {
+		c.local.Flush()
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.invalidator, c.stopInvalidations = inv, stop
+	return stop, nil
+}
+
+// invalidate evicts keys from the local stores of the other instances, if
+// BroadcastInvalidations was called
+func (c *TieredStore) invalidate(keys ...string) error {
+	if c.invalidator == nil {
+		return nil
+	}
+	for _, key := range keys {
+		if err := c.invalidator.Invalidate(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get (see CacheStore interface)
+func (c *TieredStore) Get(key string, value interface{}) error {
+	if err := c.local.Get(key, value); err == nil {
+		return nil
+	}
+	ttl, err := c.getRemote(key, value)
+	if err != nil {
+		return err
+	}
+	c.backfill(key, value, ttl)
+	return nil
+}
+
+// getRemote reads key from remote, along with the time it has left to live if
+// remote is a TTLStore, and 0 otherwise
+func (c *TieredStore) getRemote(key string, value interface{}) (time.Duration, error) {
+	if ttlStore, ok := c.remote.(TTLStore); ok {
+		return ttlStore.GetWithTTL(key, value)
+	}
+	return 0, c.remote.Get(key, value)
+}
+
+// Set (see CacheStore interface)
+func (c *TieredStore) Set(key string, value interface{}, expires time.Duration) error {
+	if err := c.remote.Set(key, value, expires); err != nil {
+		c.local.Delete(key)
+		return err
+	}
+	if err := c.invalidate(key); err != nil {
+		return err
+	}
+	return c.local.Set(key, value, c.localExpires(expires))
+}
+
+// Add (see CacheStore interface)
+func (c *TieredStore) Add(key string, value interface{}, expires time.Duration) error {
+	if err := c.remote.Add(key, value, expires); err != nil {
+		return err
+	}
+	if err := c.invalidate(key); err != nil {
+		return err
+	}
+	return c.local.Set(key, value, c.localExpires(expires))
+}
+
+// Replace (see CacheStore interface)
+func (c *TieredStore) Replace(key string, value interface{}, expires time.Duration) error {
+	if err := c.remote.Replace(key, value, expires); err != nil {
+		return err
+	}
+	if err := c.invalidate(key); err != nil {
+		return err
+	}
+	return c.local.Set(key, value, c.localExpires(expires))
+}
+
+// Delete (see CacheStore interface)
+func (c *TieredStore) Delete(key string) error {
+	c.local.Delete(key)
+	err := c.remote.Delete(key)
+	if err != nil && err != ErrCacheMiss {
+		return err
+	}
+	if invErr := c.invalidate(key); invErr != nil {
+		return invErr
+	}
+	return err
+}
+
+// Increment (see CacheStore interface)
+func (c *TieredStore) Increment(key string, delta uint64) (uint64, error) {
+	c.local.Delete(key)
+	n, err := c.remote.Increment(key, delta)
+	if err != nil {
+		return n, err
+	}
+	return n, c.invalidate(key)
+}
+
+// Decrement (see CacheStore interface)
+func (c *TieredStore) Decrement(key string, delta uint64) (uint64, error) {
+	c.local.Delete(key)
+	n, err := c.remote.Decrement(key, delta)
+	if err != nil {
+		return n, err
+	}
+	return n, c.invalidate(key)
+}
+
+// Flush (see CacheStore interface)
+func (c *TieredStore) Flush() error {
+	if err := c.remote.Flush(); err != nil {
+		return err
+	}
+	if c.invalidator != nil {
+		if err := c.invalidator.InvalidateAll(); err != nil {
+			return err
+		}
+	}
+	return c.local.Flush()
+}
+
+// Close (see CacheStore interface)
+//
+// It ends the subscription of BroadcastInvalidations, and closes both
+// stores.
+func (c *TieredStore) Close(ctx context.Context) error {
+	if c.stopInvalidations != nil {
+		c.stopInvalidations()
+	}
+	return closeAll(ctx, c.local, c.remote)
+}
+
+// GetMulti (see CacheStore interface)
+//
+// Keys missing from local are fetched from remote in a single GetMulti.
+func (c *TieredStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	found, err := c.local.GetMulti(keys, values)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []int
+	for i := range keys {
+		if !found[i] {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return found, nil
+	}
+	remoteKeys := make([]string, len(missing))
+	remoteValues := make([]interface{}, len(missing))
+	for n, i := range missing {
+		remoteKeys[n] = keys[i]
+		remoteValues[n] = values[i]
+	}
+	remoteFound, err := c.remote.GetMulti(remoteKeys, remoteValues)
+	if err != nil {
+		return nil, err
+	}
+	for n, i := range missing {
+		if remoteFound[n] {
+			found[i] = true
+			c.backfill(keys[i], values[i], 0)
+		}
+	}
+	return found, nil
+}
+
+// SetMulti (see CacheStore interface)
+func (c *TieredStore) SetMulti(items map[string]Item) error {
+	if err := c.remote.SetMulti(items); err != nil {
+		for key := range items {
+			c.local.Delete(key)
+		}
+		return err
+	}
+	keys := make([]string, 0, len(items))
+	local := make(map[string]Item, len(items))
+	for key, item := range items {
+		keys = append(keys, key)
+		local[key] = Item{item.Value, c.localExpires(item.Expire)}
+	}
+	if err := c.invalidate(keys...); err != nil {
+		return err
+	}
+	return c.local.SetMulti(local)
+}
+
+// backfill copies a value read from remote, with ttl left to live there (0 if
+// unknown), into local. Without localExpiration, values with an unknown ttl
+// are not copied, as the default expiration of local may outlive them.
+func (c *TieredStore) backfill(key string, ptrValue interface{}, ttl time.Duration) {
+	if ttl == 0 && c.localExpiration <= 0 {
+		return
+	}
+	c.local.Set(key, reflect.Indirect(reflect.ValueOf(ptrValue)).Interface(), c.localExpires(ttl))
+}
+
+// localExpires caps expires to localExpiration, if set
+func (c *TieredStore) localExpires(expires time.Duration) time.Duration {
+	if c.localExpiration <= 0 {
+		return expires
+	}
+	if expires == DEFAULT || expires == FOREVER || expires > c.localExpiration {
+		return c.localExpiration
+	}
+	return expires
+}
@@ -0,0 +1,120 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+var newTieredStore = func(_ *testing.T, defaultExpiration time.Duration) CacheStore {
+	return NewTieredStore(NewInMemoryStore(defaultExpiration), NewInMemoryStore(defaultExpiration), 0)
+}
+
+// Test typical cache interactions
+func TestTieredCache_TypicalGetSet(t *testing.T) {
+	typicalGetSet(t, newTieredStore)
+}
+
+func TestTieredCache_IncrDecr(t *testing.T) {
+	incrDecr(t, newTieredStore)
+}
+
+func TestTieredCache_CounterPresence(t *testing.T) {
+	counterPresence(t, newTieredStore)
+}
+
+func TestTieredCache_GetSetMulti(t *testing.T) {
+	getSetMulti(t, newTieredStore)
+}
+
+func TestTieredCache_Expiration(t *testing.T) {
+	expiration(t, newTieredStore)
+}
+
+func TestTieredCache_EmptyCache(t *testing.T) {
+	emptyCache(t, newTieredStore)
+}
+
+func TestTieredCache_Replace(t *testing.T) {
+	testReplace(t, newTieredStore)
+}
+
+func TestTieredCache_Add(t *testing.T) {
+	testAdd(t, newTieredStore)
+}
+
+func TestTieredCache_Backfill(t *testing.T) {
+	local, remote := NewInMemoryStore(time.Hour), NewInMemoryStore(time.Hour)
+	store := NewTieredStore(local, remote, time.Hour)
+
+	remote.Set("shared", "from remote", DEFAULT)
+	var s string
+	if err := store.Get("shared", &s); err != nil || s != "from remote" {
+		t.Errorf("Expected to read from remote, got %s, %v", s, err)
+	}
+	if err := local.Get("shared", &s); err != nil || s != "from remote" {
+		t.Errorf("Expected the value to be copied to local, got %s, %v", s, err)
+	}
+
+	remote.Set("multi", "from remote", DEFAULT)
+	found, err := store.GetMulti([]string{"shared", "multi", "missing"}, []interface{}{new(string), new(string), new(string)})
+	if err != nil || !found[0] || !found[1] || found[2] {
+		t.Errorf("Expected shared and multi to be found, got %v, %v", found, err)
+	}
+	if err := local.Get("multi", &s); err != nil {
+		t.Errorf("Expected the value to be copied to local, got: %v", err)
+	}
+
+	if err := store.Delete("shared"); err != nil {
+		t.Errorf("Error deleting: %s", err)
+	}
+	if err := local.Get("shared", &s); err != ErrCacheMiss {
+		t.Errorf("Expected the value to be deleted from local, got: %v", err)
+	}
+	if err := remote.Get("shared", &s); err != ErrCacheMiss {
+		t.Errorf("Expected the value to be deleted from remote, got: %v", err)
+	}
+}
+
+func TestTieredCache_BackfillRemoteTTL(t *testing.T) {
+	local, remote := NewInMemoryStore(time.Hour), NewInMemoryStore(time.Hour)
+	store := NewTieredStore(local, remote, 0)
+
+	remote.Set("short", "from remote", time.Minute)
+	var s string
+	if err := store.Get("short", &s); err != nil || s != "from remote" {
+		t.Errorf("Expected to read from remote, got %s, %v", s, err)
+	}
+	if ttl, err := local.GetWithTTL("short", &s); err != nil || ttl > time.Minute {
+		t.Errorf("Expected the local copy to expire with the remote item, got %s, %v", ttl, err)
+	}
+
+	// Without a TTLStore, the time left in remote is unknown
+	store = NewTieredStore(local, struct{ CacheStore }{remote}, 0)
+	remote.Set("unknown", "from remote", time.Minute)
+	if err := store.Get("unknown", &s); err != nil || s != "from remote" {
+		t.Errorf("Expected to read from remote, got %s, %v", s, err)
+	}
+	if err := local.Get("unknown", &s); err != ErrCacheMiss {
+		t.Errorf("Expected an item with an unknown TTL not to be copied to local, got: %v", err)
+	}
+}
+
+func TestTieredCache_LocalExpiration(t *testing.T) {
+	local, remote := NewInMemoryStore(time.Hour), NewInMemoryStore(time.Hour)
+	store := NewTieredStore(local, remote, time.Second)
+
+	if err := store.Set("key", "v1", time.Hour); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	// Another instance updates remote
+	remote.Set("key", "v2", time.Hour)
+
+	var s string
+	if err := store.Get("key", &s); err != nil || s != "v1" {
+		t.Errorf("Expected the local copy, got %s, %v", s, err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if err := store.Get("key", &s); err != nil || s != "v2" {
+		t.Errorf("Expected the local copy to expire, got %s, %v", s, err)
+	}
+}
@@ -0,0 +1,25 @@
+package persistence
+
+import "time"
+
+// TouchStore is implemented by stores able to change the expiration of an
+// item without writing it again, so that extending the life of large values
+// on every access, as sliding expiration does, costs a single small command
+type TouchStore interface {
+	CacheStore
+
+	// Touch sets the expiration of the item at key to expires from now,
+	// DEFAULT and FOREVER included. It returns ErrCacheMiss if key does not
+	// hold an item.
+	Touch(key string, expires time.Duration) error
+}
+
+// Touch sets the expiration of the item of store at key to expires from now.
+// It returns ErrNotSupport if the store is not a TouchStore.
+func Touch(store CacheStore, key string, expires time.Duration) error {
+	touchStore, ok := store.(TouchStore)
+	if !ok {
+		return ErrNotSupport
+	}
+	return touchStore.Touch(key, expires)
+}
@@ -0,0 +1,175 @@
+package persistence
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/mlsen/cache/persistence"
+
+// TracedStore is a CacheStore emitting an OpenTelemetry span for every
+// operation of the store it wraps. Spans are children of the span found in
+// the context the store is bound to (see ContextBinder), which the gin
+// middleware sets to the request context, and carry the backend type, a hash
+// of the key and, for reads, whether the key was found.
+type TracedStore struct {
+	store   CacheStore
+	ctx     context.Context
+	tracer  trace.Tracer
+	backend string
+}
+
+// NewTracedStore returns a TracedStore wrapping store, creating its spans
+// with a tracer from provider
+func NewTracedStore(store CacheStore, provider trace.TracerProvider) *TracedStore {
+	return &TracedStore{
+		store:   store,
+		ctx:     context.Background(),
+		tracer:  provider.Tracer(tracerName),
+		backend: strings.TrimPrefix(fmt.Sprintf("%T", store), "*"),
+	}
+}
+
+// WithContext (see ContextBinder interface)
+func (s *TracedStore) WithContext(ctx context.Context) CacheStore {
+	bound := *s
+	bound.ctx = ctx
+	return &bound
+}
+
+// Get (see CacheStore interface)
+func (s *TracedStore) Get(key string, value interface{}) error {
+	store, span := s.start("get", key)
+	err := store.Get(key, value)
+	if err == nil || err == ErrCacheMiss {
+		span.SetAttributes(attribute.Bool("cache.hit", err == nil))
+	}
+	endSpan(span, err)
+	return err
+}
+
+// Set (see CacheStore interface)
+func (s *TracedStore) Set(key string, value interface{}, expires time.Duration) error {
+	store, span := s.start("set", key)
+	err := store.Set(key, value, expires)
+	endSpan(span, err)
+	return err
+}
+
+// Add (see CacheStore interface)
+func (s *TracedStore) Add(key string, value interface{}, expires time.Duration) error {
+	store, span := s.start("add", key)
+	err := store.Add(key, value, expires)
+	endSpan(span, err)
+	return err
+}
+
+// Replace (see CacheStore interface)
+func (s *TracedStore) Replace(key string, value interface{}, expires time.Duration) error {
+	store, span := s.start("replace", key)
+	err := store.Replace(key, value, expires)
+	endSpan(span, err)
+	return err
+}
+
+// Delete (see CacheStore interface)
+func (s *TracedStore) Delete(key string) error {
+	store, span := s.start("delete", key)
+	err := store.Delete(key)
+	endSpan(span, err)
+	return err
+}
+
+// Increment (see CacheStore interface)
+func (s *TracedStore) Increment(key string, delta uint64) (uint64, error) {
+	store, span := s.start("increment", key)
+	n, err := store.Increment(key, delta)
+	endSpan(span, err)
+	return n, err
+}
+
+// Decrement (see CacheStore interface)
+func (s *TracedStore) Decrement(key string, delta uint64) (uint64, error) {
+	store, span := s.start("decrement", key)
+	n, err := store.Decrement(key, delta)
+	endSpan(span, err)
+	return n, err
+}
+
+// Flush (see CacheStore interface)
+func (s *TracedStore) Flush() error {
+	store, span := s.start("flush", "")
+	err := store.Flush()
+	endSpan(span, err)
+	return err
+}
+
+// Close (see CacheStore interface)
+func (s *TracedStore) Close(ctx context.Context) error {
+	return s.store.Close(ctx)
+}
+
+// GetMulti (see CacheStore interface)
+//
+// The span records the number of keys requested and found instead of a key
+// hash.
+func (s *TracedStore) GetMulti(keys []string, values []interface{}) ([]bool, error) {
+	store, span := s.start("get_multi", "")
+	found, err := store.GetMulti(keys, values)
+	hits := 0
+	for _, ok := range found {
+		if ok {
+			hits++
+		}
+	}
+	span.SetAttributes(attribute.Int("cache.keys", len(keys)), attribute.Int("cache.hits", hits))
+	endSpan(span, err)
+	return found, err
+}
+
+// SetMulti (see CacheStore interface)
+func (s *TracedStore) SetMulti(items map[string]Item) error {
+	store, span := s.start("set_multi", "")
+	err := store.SetMulti(items)
+	span.SetAttributes(attribute.Int("cache.keys", len(items)))
+	endSpan(span, err)
+	return err
+}
+
+// start starts the span of operation on key (none if empty), and returns the
+// wrapped store bound to the context of the span
+func (s *TracedStore) start(operation, key string) (CacheStore, trace.Span) {
+	attrs := []attribute.KeyValue{attribute.String("cache.backend", s.backend)}
+	if key != "" {
+		attrs = append(attrs, attribute.String("cache.key_hash", hashKey(key)))
+	}
+	ctx, span := s.tracer.Start(s.ctx, "cache."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+	return BindContext(ctx, s.store), span
+}
+
+// endSpan ends span, recording err unless it only reports a miss or an unmet
+// Add or Replace condition
+func endSpan(span trace.Span, err error) {
+	if err != nil && err != ErrCacheMiss && err != ErrNotStored {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// hashKey returns a short hash of key, so spans do not expose the keys, which
+// may contain user data
+func hashKey(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
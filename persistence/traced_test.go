@@ -0,0 +1,70 @@
+package persistence
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracedStore(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	store := BindContext(ctx, NewTracedStore(NewInMemoryStore(time.Hour), provider))
+
+	var value int
+	store.Set("a", 1, DEFAULT)
+	store.Get("a", &value)
+	store.Get("b", &value)
+	store.Set("s", "string", DEFAULT)
+	store.Increment("s", 1)
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 6 {
+		t.Fatalf("Expected 6 spans, got %d", len(spans))
+	}
+	for i, name := range []string{"cache.set", "cache.get", "cache.get", "cache.set", "cache.increment"} {
+		span := spans[i]
+		if span.Name() != name {
+			t.Errorf("Expected span %d to be %s, got %s", i, name, span.Name())
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("Expected span %s to be a child of the request span", span.Name())
+		}
+		if backend, _ := spanAttribute(span, "cache.backend"); backend.AsString() != "persistence.InMemoryStore" {
+			t.Errorf("Unexpected backend %q", backend.AsString())
+		}
+		if hash, ok := spanAttribute(span, "cache.key_hash"); !ok || hash.AsString() == "" {
+			t.Errorf("Expected span %s to have a key hash", span.Name())
+		}
+	}
+
+	if hit, _ := spanAttribute(spans[1], "cache.hit"); !hit.AsBool() {
+		t.Errorf("Expected a hit")
+	}
+	if hit, ok := spanAttribute(spans[2], "cache.hit"); !ok || hit.AsBool() {
+		t.Errorf("Expected a miss")
+	}
+	if spans[2].Status().Code == codes.Error {
+		t.Errorf("Expected a miss not to be an error")
+	}
+	if spans[4].Status().Code != codes.Error {
+		t.Errorf("Expected incrementing a string to be an error")
+	}
+}
@@ -0,0 +1,28 @@
+package persistence
+
+import "time"
+
+// TTLStore is implemented by stores able to tell how long items have left to
+// live, e.g. to emit Age and Expires headers
+type TTLStore interface {
+	CacheStore
+
+	// GetWithTTL works like Get, and additionally returns the time left
+	// before the item expires, or FOREVER if it does not expire.
+	GetWithTTL(key string, value interface{}) (time.Duration, error)
+
+	// Exists reports whether key holds an item, without reading it.
+	Exists(key string) (bool, error)
+}
+
+// remainingTTL returns the time left until expiresAt, FOREVER if it is zero
+func remainingTTL(expiresAt time.Time) time.Duration {
+	if expiresAt.IsZero() {
+		return FOREVER
+	}
+	if ttl := time.Until(expiresAt); ttl > 0 {
+		return ttl
+	}
+	// The item expires right now
+	return time.Nanosecond
+}
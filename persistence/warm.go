@@ -0,0 +1,99 @@
+package persistence
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// warmConcurrency bounds the number of loaders WarmAndWait runs at once
+const warmConcurrency = 8
+
+// StoreWarmer populates a store before serving traffic
+type StoreWarmer struct {
+	store CacheStore
+}
+
+// NewStoreWarmer returns a StoreWarmer populating store
+func NewStoreWarmer(store CacheStore) *StoreWarmer {
+	return &StoreWarmer{store: store}
+}
+
+// WarmAndWait populates keys in the store with the values and expirations
+// returned by loader, running up to 8 loaders in parallel. It returns once
+// every key has been stored, with the first error returned by loader or by
+// the store, or ctx.Err() as soon as ctx is done. Keys not started by then
+// are skipped, and the values of the loaders still running are discarded.
+//
+//	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+//	defer cancel()
+//	err := persistence.NewStoreWarmer(store).WarmAndWait(ctx, keys, loadProduct)
+//
+// Calling it before flipping a readiness probe avoids a thundering herd on a
+// cold cache right after a deploy.
+func (w *StoreWarmer) WarmAndWait(ctx context.Context, keys []string, loader func(key string) (interface{}, time.Duration, error)) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- WarmKeys(ctx, keys, warmConcurrency, func(ctx context.Context, key string) error {
+			value, expires, err := loader(key)
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return BindContext(ctx, w.store).Set(key, value, expires)
+		})
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WarmKeys calls warm with each of keys, running up to concurrency calls at
+// once, and returns once every call returned. Unlike WarmAndWait, it leaves
+// storing values to warm, which is given ctx and should return when it is
+// done. The first error returned by warm is reported after the remaining
+// keys have been processed. Once ctx is done, the keys not started yet are
+// skipped, and ctx.Err() is returned after the running calls returned.
+// cache.Warmer builds on it to also request the pages of the application.
+func WarmKeys(ctx context.Context, keys []string, concurrency int, warm func(ctx context.Context, key string) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	sem := make(chan struct{}, concurrency)
+	for _, key := range keys {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := warm(ctx, key); err != nil {
+				once.Do(func() { firstErr = err })
+			}
+		}(key)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return firstErr
+}
@@ -0,0 +1,169 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWarmAndWait(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	keys := make([]string, 50)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key:%d", i)
+	}
+
+	var running, maxRunning int32
+	err := NewStoreWarmer(store).WarmAndWait(context.Background(), keys, func(key string) (interface{}, time.Duration, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return "value of " + key, DEFAULT, nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error warming: %s", err)
+	}
+
+	for _, key := range keys {
+		var value string
+		if err := store.Get(key, &value); err != nil || value != "value of "+key {
+			t.Errorf("Expected %s to be warm, got %q, %v", key, value, err)
+		}
+	}
+	if maxRunning > warmConcurrency {
+		t.Errorf("Expected at most %d concurrent loaders, got %d", warmConcurrency, maxRunning)
+	}
+}
+
+func TestWarmAndWait_Expiration(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+
+	err := NewStoreWarmer(store).WarmAndWait(context.Background(), []string{"short"}, func(key string) (interface{}, time.Duration, error) {
+		return 1, 50 * time.Millisecond, nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error warming: %s", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	var i int
+	if err := store.Get("short", &i); err != ErrCacheMiss {
+		t.Errorf("Expected the value to expire with the TTL of the loader, got: %v", err)
+	}
+}
+
+func TestWarmAndWait_LoaderError(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	errLoad := errors.New("load failed")
+
+	err := NewStoreWarmer(store).WarmAndWait(context.Background(), []string{"ok", "bad"}, func(key string) (interface{}, time.Duration, error) {
+		if key == "bad" {
+			return nil, 0, errLoad
+		}
+		return 1, DEFAULT, nil
+	})
+	if err != errLoad {
+		t.Errorf("Expected the loader error, got: %v", err)
+	}
+	var i int
+	if err := store.Get("ok", &i); err != nil {
+		t.Errorf("Expected the other key to be warm, got: %v", err)
+	}
+}
+
+func TestWarmAndWait_Timeout(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	loaded := make(chan struct{})
+	start := time.Now()
+	err := NewStoreWarmer(store).WarmAndWait(ctx, []string{"slow"}, func(key string) (interface{}, time.Duration, error) {
+		defer close(loaded)
+		time.Sleep(200 * time.Millisecond)
+		return 1, DEFAULT, nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("Expected WarmAndWait to return at the deadline, took %s", elapsed)
+	}
+
+	<-loaded
+	time.Sleep(10 * time.Millisecond)
+	var i int
+	if err := store.Get("slow", &i); err != ErrCacheMiss {
+		t.Errorf("Expected the value loaded after the deadline to be discarded, got: %v", err)
+	}
+}
+
+func TestWarmKeys(t *testing.T) {
+	keys := make([]string, 50)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key:%d", i)
+	}
+
+	var warmed, running, maxRunning int32
+	err := WarmKeys(context.Background(), keys, 3, func(_ context.Context, key string) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&warmed, 1)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error warming: %s", err)
+	}
+	if warmed != int32(len(keys)) {
+		t.Errorf("Expected %d keys to be warmed, got %d", len(keys), warmed)
+	}
+	if maxRunning > 3 {
+		t.Errorf("Expected at most 3 concurrent calls, got %d", maxRunning)
+	}
+}
+
+func TestWarmKeys_Timeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	var started, running int32
+	err := WarmKeys(ctx, []string{"slow", "skipped"}, 1, func(ctx context.Context, key string) error {
+		atomic.AddInt32(&started, 1)
+		atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		select {
+		case <-time.After(time.Second):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the calls to be stopped at the deadline, took %s", elapsed)
+	}
+	if started != 1 {
+		t.Errorf("Expected the keys not started by the deadline to be skipped, %d were started", started)
+	}
+	if running != 0 {
+		t.Errorf("Expected no call to be running after WarmKeys returned, %d are", running)
+	}
+}
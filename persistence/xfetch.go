@@ -0,0 +1,67 @@
+package persistence
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/mlsen/cache/utils"
+)
+
+// xfetchEntry is the envelope SetXFetch stores: the serialized value, the
+// time it took to compute, and the time it expires, zero if it does not
+type xfetchEntry struct {
+	Value     []byte
+	Delta     time.Duration
+	ExpiresAt time.Time
+}
+
+// ShouldRecompute implements the XFetch algorithm of "Optimal Probabilistic
+// Cache Stampede Prevention" (Vattani et al.): it reports whether a value
+// expiring at expiresAt, and taking delta to compute, should be recomputed
+// now, with a probability growing as the expiration nears. The larger delta,
+// the earlier recomputations start, so that they end before the value
+// expires. beta scales how early: 1 is the optimal default, larger values
+// favor earlier recomputations. A zero expiresAt never expires.
+func ShouldRecompute(delta time.Duration, expiresAt time.Time, beta float64) bool {
+	if expiresAt.IsZero() {
+		return false
+	}
+	// -log(rand) is exponentially distributed, with a mean of 1
+	early := time.Duration(float64(delta) * beta * -math.Log(1-rand.Float64()))
+	return !time.Now().Add(early).Before(expiresAt)
+}
+
+// SetXFetch stores value at key for expires, recording delta, the time it
+// took to compute, for GetXFetch. expires accepts DEFAULT and FOREVER like
+// the expiration of Set, but values stored for DEFAULT are never recomputed
+// early, their expiration being unknown.
+//
+// The value is stored in an envelope: it must be read with GetXFetch, and is
+// only as serializable as utils.Serialize allows.
+func SetXFetch(store CacheStore, key string, value interface{}, delta, expires time.Duration) error {
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+	entry := xfetchEntry{Value: b, Delta: delta}
+	if expires > 0 {
+		entry.ExpiresAt = time.Now().Add(expires)
+	}
+	return store.Set(key, entry, expires)
+}
+
+// GetXFetch reads the value stored by SetXFetch at key into value, and
+// reports whether the caller should recompute and store it again before it
+// expires, see ShouldRecompute. Only a few of the concurrent readers of a
+// value about to expire are told to, so that they do not all recompute it.
+func GetXFetch(store CacheStore, key string, value interface{}, beta float64) (shouldRecompute bool, err error) {
+	var entry xfetchEntry
+	if err := store.Get(key, &entry); err != nil {
+		return false, err
+	}
+	if err := utils.Deserialize(entry.Value, value); err != nil {
+		return false, err
+	}
+	return ShouldRecompute(entry.Delta, entry.ExpiresAt, beta), nil
+}
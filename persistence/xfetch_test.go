@@ -0,0 +1,53 @@
+package persistence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShouldRecompute(t *testing.T) {
+	now := time.Now()
+	if ShouldRecompute(time.Hour, time.Time{}, 1) {
+		t.Errorf("Expected values without expiration never to be recomputed")
+	}
+	if !ShouldRecompute(0, now.Add(-time.Second), 1) {
+		t.Errorf("Expected expired values to be recomputed")
+	}
+
+	early, late := 0, 0
+	for i := 0; i < 1000; i++ {
+		if ShouldRecompute(10*time.Millisecond, now.Add(time.Hour), 1) {
+			early++
+		}
+		if ShouldRecompute(time.Second, now.Add(10*time.Millisecond), 1) {
+			late++
+		}
+	}
+	if early > 0 {
+		t.Errorf("Expected values far from expiring not to be recomputed, got %d/1000", early)
+	}
+	if late < 900 {
+		t.Errorf("Expected slow values about to expire to be recomputed, got %d/1000", late)
+	}
+}
+
+func TestXFetch(t *testing.T) {
+	store := NewInMemoryStore(time.Hour)
+	if err := SetXFetch(store, "fresh", "value", time.Millisecond, time.Hour); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+	if err := SetXFetch(store, "expiring", "value", time.Hour, time.Minute); err != nil {
+		t.Fatalf("Error setting a value: %s", err)
+	}
+
+	var s string
+	if recompute, err := GetXFetch(store, "fresh", &s, 1); err != nil || recompute || s != "value" {
+		t.Errorf("Expected a fresh value, got: %q, %v, %v", s, recompute, err)
+	}
+	if recompute, err := GetXFetch(store, "expiring", &s, 1); err != nil || !recompute || s != "value" {
+		t.Errorf("Expected to be told to recompute the value, got: %q, %v, %v", s, recompute, err)
+	}
+	if _, err := GetXFetch(store, "notexist", &s, 1); err != ErrCacheMiss {
+		t.Errorf("Expected ErrCacheMiss, got: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
+)
+
+// staleWarning is the Warning header added to the responses served by
+// WithSecondChance
+const staleWarning = `110 - "Response is Stale"`
+
+// WithSecondChance keeps the last cached copy of up to size pages in a local
+// LRU, and serves it when regenerating the page fails: the handler responds
+// with a 5xx status code or panics. Read-mostly content stays available
+// during incidents, even after it expires from the store. If warning is set,
+// the copies served are marked with a Warning header.
+//
+// Once a page has a copy, the handler's response is buffered until it is
+// known to have succeeded: it is not streamed to the client.
+func WithSecondChance(size int, warning bool) PageOption {
+	return func(o *pageOptions) {
+		o.secondChance = persistence.NewInMemoryStore(persistence.FOREVER, persistence.WithMaxEntries(size, persistence.EvictLRU))
+		o.staleWarning = warning
+	}
+}
+
+// remember keeps cache, the page at key, as its last known copy
+func (o pageOptions) remember(key string, cache responseCache) {
+	if o.secondChance != nil {
+		o.secondChance.Set(key, cache, persistence.FOREVER)
+	}
+}
+
+// withSecondChance returns generate, running it with the response buffered
+// if the page at key has a last known copy, which is served instead of the
+// response if the handler fails
+func (o pageOptions) withSecondChance(c *gin.Context, key string, generate func()) func() {
+	if o.secondChance == nil {
+		return generate
+	}
+	return func() {
+		var last responseCache
+		if err := o.secondChance.Get(key, &last); err != nil {
+			generate()
+			return
+		}
+
+		w := c.Writer
+		buffer := &recordingWriter{ResponseWriter: newDiscardWriter()}
+		c.Writer = buffer
+		failed := func() (failed bool) {
+			defer func() {
+				if r := recover(); r != nil {
+					o.logError(key, fmt.Errorf("cache: handler panicked: %v", r))
+					failed = true
+				}
+			}()
+			generate()
+			return buffer.Status() >= http.StatusInternalServerError
+		}()
+		c.Writer = w
+
+		if failed {
+			for k, vals := range last.Header {
+				w.Header()[k] = vals
+			}
+			if o.staleWarning {
+				w.Header().Add("Warning", staleWarning)
+			}
+			w.WriteHeader(last.Status)
+			w.Write(last.Data)
+			return
+		}
+		for k, vals := range buffer.Header() {
+			w.Header()[k] = vals
+		}
+		w.WriteHeader(buffer.Status())
+		w.Write(buffer.body.Bytes())
+	}
+}
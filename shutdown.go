@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"context"
+	"sync"
+)
+
+// RefreshGroup runs the background refreshes of the middlewares it is given
+// to with WithRefreshGroup, so that a service can stop them when it shuts
+// down, and tests do not leak their goroutines:
+//
+//	refreshes := NewRefreshGroup()
+//	router.GET("/", CachePageStale(store, time.Minute, time.Hour, 4, handler, WithRefreshGroup(refreshes)))
+//	...
+//	server.Shutdown(ctx)
+//	refreshes.Shutdown(ctx)
+//	store.Close(ctx)
+type RefreshGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	stopped bool
+	running sync.WaitGroup
+}
+
+// NewRefreshGroup returns a RefreshGroup running refreshes until Shutdown
+func NewRefreshGroup() *RefreshGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RefreshGroup{ctx: ctx, cancel: cancel}
+}
+
+// WithRefreshGroup runs the background refreshes of CachePageStale in group.
+// Their requests are bound to the context of group, canceled when it is
+// shut down, instead of context.Background().
+func WithRefreshGroup(group *RefreshGroup) PageOption {
+	return func(o *pageOptions) {
+		o.refreshGroup = group
+	}
+}
+
+// goRefresh runs refresh in a new goroutine with the context of the group,
+// and reports whether it did, which it does not once the group is shut
+// down. A nil group runs refresh with context.Background().
+func (g *RefreshGroup) goRefresh(refresh func(ctx context.Context)) bool {
+	if g == nil {
+		go refresh(context.Background())
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		return false
+	}
+	g.running.Add(1)
+	go func() {
+		defer g.running.Done()
+		refresh(g.ctx)
+	}()
+	return true
+}
+
+// Shutdown stops starting refreshes, and waits for the running ones to
+// return. If ctx is done first, the context of the running refreshes is
+// canceled and Shutdown returns ctx.Err() without waiting further.
+func (g *RefreshGroup) Shutdown(ctx context.Context) error {
+	g.mu.Lock()
+	g.stopped = true
+	g.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		g.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		g.cancel()
+		return nil
+	case <-ctx.Done():
+		g.cancel()
+		return ctx.Err()
+	}
+}
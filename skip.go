@@ -0,0 +1,40 @@
+package cache
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// SkipKey is the gin context key which, set to true by a handler wrapped by
+// CachePage or one of its variants, keeps the response to the current
+// request from being cached
+const SkipKey = "gincontrib.cache.skip"
+
+// Skip keeps the response to the current request from being cached, e.g.
+// when the handler serves degraded or partial data because a backend failed.
+// It is the same as c.Set(SkipKey, true).
+//
+// Whether Skip is called or not, the responses of aborted contexts, and of
+// handlers that panic, are never cached.
+func Skip(c *gin.Context) {
+	c.Set(SkipKey, true)
+}
+
+// WithSkipOnError does not cache the responses of requests the handler
+// attached an error to with c.Error, as if it called Skip
+func WithSkipOnError() PageOption {
+	return func(o *pageOptions) {
+		o.skipOnError = true
+	}
+}
+
+// skipped reports whether the response to c must not be cached, because the
+// context was aborted or the handler called Skip
+func skipped(c *gin.Context) bool {
+	return c.IsAborted() || c.GetBool(SkipKey)
+}
+
+// skips reports whether the response to c must not be cached, like skipped,
+// or because the handler attached an error with WithSkipOnError
+func (o pageOptions) skips(c *gin.Context) bool {
+	return skipped(c) || (o.skipOnError && handlerErrors(c) > 0)
+}
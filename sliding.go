@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/mlsen/cache/persistence"
+)
+
+// WithSlidingExpiration extends the expiration of a cached page each time it
+// is served, to the expiration given to CachePage, so that pages live as
+// long as they are requested. The page is touched when the store is a
+// persistence.TouchStore, and written again otherwise. Expirations set with
+// WithTTLFunc or by the Cache-Control header of responses are replaced by
+// the first hit.
+func WithSlidingExpiration() PageOption {
+	return func(o *pageOptions) {
+		o.sliding = true
+	}
+}
+
+// slide extends the expiration of the page cached at key to expire, and
+// returns its time to live: expire, or ttl if it could not be extended
+func (o pageOptions) slide(store persistence.CacheStore, key string, cache responseCache, ttl, expire time.Duration) time.Duration {
+	err := persistence.Touch(store, key, expire)
+	if err == persistence.ErrNotSupport {
+		err = store.Set(key, cache, expire)
+	}
+	if err != nil {
+		if err != persistence.ErrCacheMiss {
+			o.logError(key, err)
+		}
+		return ttl
+	}
+	return expire
+}
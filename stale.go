@@ -0,0 +1,258 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
+)
+
+// CachePageStale Decorator
+//
+// Like CachePage, except that a response is kept for staleWindow after it
+// expires: it is stored with persistence.SetSoft, expire being its soft TTL
+// and expire plus staleWindow its hard TTL. A request for a stale page is answered with it right away, while
+// the handler runs in the background to refresh the cache, with at most
+// maxRefreshes (at least 1) refreshes running at once. Refreshes that would
+// exceed the limit are skipped, leaving the page to a later request.
+//
+// Background refreshes run the handler on a copy of the gin context, with a
+// request context that is not canceled when the original request ends. The
+// copy always reports IsAborted, so responses with a status code < 300 are
+// cached whether the handler aborted or not, unless it called Skip.
+//
+// Of the PageOption, only WithRefreshGroup applies.
+func CachePageStale(store persistence.CacheStore, expire, staleWindow time.Duration, maxRefreshes int, handle gin.HandlerFunc, opts ...PageOption) gin.HandlerFunc {
+	group := newPageOptions(opts).refreshGroup
+	if maxRefreshes < 1 {
+		maxRefreshes = 1
+	}
+	refreshes := make(chan struct{}, maxRefreshes)
+	var (
+		mu         sync.Mutex
+		refreshing = make(map[string]bool)
+	)
+
+	refresh := func(c *gin.Context, key string) {
+		mu.Lock()
+		if refreshing[key] {
+			mu.Unlock()
+			return
+		}
+		select {
+		case refreshes <- struct{}{}:
+		default:
+			mu.Unlock()
+			return
+		}
+		refreshing[key] = true
+		mu.Unlock()
+
+		done := func() {
+			mu.Lock()
+			delete(refreshing, key)
+			mu.Unlock()
+			<-refreshes
+		}
+		cp := c.Copy()
+		writer := &recordingWriter{ResponseWriter: newDiscardWriter()}
+		cp.Writer = writer
+		started := group.goRefresh(func(ctx context.Context) {
+			defer done()
+			cp.Request = cp.Request.WithContext(ctx)
+			handle(cp)
+			if cp.GetBool(SkipKey) {
+				return
+			}
+			storeStale(store, key, writer, expire, staleWindow)
+		})
+		if !started {
+			done()
+		}
+	}
+
+	return func(c *gin.Context) {
+		store := persistence.BindContext(c.Request.Context(), store)
+		var cache responseCache
+		url := c.Request.URL
+		key := CreateKey(url.RequestURI())
+		stale, err := persistence.GetSoft(store, key, &cache)
+		if err != nil {
+			if err != persistence.ErrCacheMiss {
+				log.Println(err.Error())
+			}
+			writer := &recordingWriter{ResponseWriter: c.Writer}
+			c.Writer = writer
+			handle(c)
+
+			if !skipped(c) {
+				storeStale(store, key, writer, expire, staleWindow)
+			}
+			return
+		}
+
+		if stale {
+			refresh(c, key)
+		}
+		c.Writer.WriteHeader(cache.Status)
+		for k, vals := range cache.Header {
+			for _, v := range vals {
+				c.Writer.Header().Set(k, v)
+			}
+		}
+		c.Writer.Write(cache.Data)
+	}
+}
+
+// storeStale caches the response recorded by writer if its status code is
+// < 300, for expire plus staleWindow
+func storeStale(store persistence.CacheStore, key string, writer *recordingWriter, expire, staleWindow time.Duration) {
+	if writer.Status() >= 300 {
+		return
+	}
+	val := responseCache{
+		Status: writer.Status(),
+		Header: headerFilter{}.filter(writer.Header()),
+		Data:   writer.body.Bytes(),
+		Stored: time.Now(),
+	}
+	if err := persistence.SetSoft(store, key, val, expire, expire+staleWindow); err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// recordingWriter records the body written through it. Once the body exceeds
+// limit bytes, if positive, recording stops and overflow is set. Once the
+// response is detected as streamed, limit is replaced by streamLimit if not
+// zero, and recording stops right away if streamLimit is negative.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body        bytes.Buffer
+	limit       int
+	overflow    bool
+	streamLimit int
+	streamed    bool
+}
+
+func (w *recordingWriter) record(data []byte) {
+	if !w.streamed && isEventStream(w.Header()) {
+		w.stream()
+	}
+	if w.overflow {
+		return
+	}
+	if w.limit > 0 && w.body.Len()+len(data) > w.limit {
+		w.overflow = true
+		w.body = bytes.Buffer{}
+		return
+	}
+	w.body.Write(data)
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	w.record(data[:n])
+	return n, err
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	n, err := w.ResponseWriter.WriteString(s)
+	w.record([]byte(s[:n]))
+	return n, err
+}
+
+func (w *recordingWriter) Flush() {
+	w.stream()
+	w.ResponseWriter.Flush()
+}
+
+// stream applies streamLimit to the response
+func (w *recordingWriter) stream() {
+	if w.streamed {
+		return
+	}
+	w.streamed = true
+	if w.streamLimit != 0 {
+		w.limit = w.streamLimit
+	}
+	if w.limit < 0 || (w.limit > 0 && w.body.Len() > w.limit) {
+		w.overflow = true
+		w.body = bytes.Buffer{}
+	}
+}
+
+// discardWriter is a gin.ResponseWriter without a client, used to run
+// handlers in the background
+type discardWriter struct {
+	header http.Header
+	status int
+	size   int
+}
+
+var _ gin.ResponseWriter = &discardWriter{}
+
+var errNoConnection = errors.New("cache: no client connection to hijack.")
+
+func newDiscardWriter() *discardWriter {
+	return &discardWriter{header: make(http.Header), status: http.StatusOK, size: -1}
+}
+
+func (w *discardWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardWriter) WriteHeader(code int) {
+	if !w.Written() {
+		w.status = code
+	}
+}
+
+func (w *discardWriter) WriteHeaderNow() {
+	if !w.Written() {
+		w.size = 0
+	}
+}
+
+func (w *discardWriter) Write(data []byte) (int, error) {
+	w.WriteHeaderNow()
+	w.size += len(data)
+	return len(data), nil
+}
+
+func (w *discardWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *discardWriter) Status() int {
+	return w.status
+}
+
+func (w *discardWriter) Size() int {
+	return w.size
+}
+
+func (w *discardWriter) Written() bool {
+	return w.size != -1
+}
+
+func (w *discardWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errNoConnection
+}
+
+func (w *discardWriter) Flush() {}
+
+func (w *discardWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *discardWriter) Pusher() http.Pusher {
+	return nil
+}
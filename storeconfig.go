@@ -0,0 +1,336 @@
+package cache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/memcachier/mc"
+	"github.com/mlsen/cache/persistence"
+	"github.com/mlsen/cache/utils"
+)
+
+// StoreConfig declares a store built by FromConfig, so that services share
+// the wiring of their stores instead of writing it by hand. It can be
+// decoded from JSON or YAML, using the names of the json and yaml tags, or
+// loaded from environment variables with StoreConfigFromEnv:
+//
+//	type: tiered
+//	namespace: products
+//	local:
+//	  type: inmemory
+//	  max_entries: 10000
+//	remote:
+//	  type: redis
+//	  addrs: [redis-1:6379, redis-2:6379]
+//	  pool_size: 50
+//	  codec: msgpack
+//	  tls:
+//	    enabled: true
+//	    ca_file: /etc/ssl/redis-ca.pem
+type StoreConfig struct {
+	// Type is the kind of store: "inmemory", "redis", "memcached" or
+	// "tiered"
+	Type string `json:"type" yaml:"type"`
+	// DefaultExpiration is the expiration of the items set with DEFAULT
+	DefaultExpiration Duration `json:"default_expiration" yaml:"default_expiration"`
+	// Namespace prefixes the keys of the store, see
+	// persistence.NewNamespacedStore
+	Namespace string `json:"namespace" yaml:"namespace"`
+	// Codec encodes the values of redis and memcached stores: "gob" (the
+	// default), "json", "msgpack" or "raw"
+	Codec string `json:"codec" yaml:"codec"`
+
+	// Addrs lists the servers of redis and memcached stores. Several
+	// addresses connect a redis store to a cluster.
+	Addrs []string `json:"addrs" yaml:"addrs"`
+	// Password authenticates with redis. Username and Password
+	// authenticate with memcached over SASL, in which case the binary
+	// protocol is used.
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+	// DB selects the database of a redis store
+	DB int `json:"db" yaml:"db"`
+	// PoolSize bounds the connections to each server of a redis store, and
+	// the idle connections kept to each server of a memcached store
+	PoolSize int `json:"pool_size" yaml:"pool_size"`
+	// MinIdleConns is the number of idle connections a redis store keeps
+	MinIdleConns int `json:"min_idle_conns" yaml:"min_idle_conns"`
+	// TLS configures the connections of redis and memcached stores
+	TLS *TLSConfig `json:"tls" yaml:"tls"`
+
+	// MaxEntries and MaxTotalBytes bound an inmemory store, see
+	// persistence.WithMaxEntries and persistence.WithMaxTotalBytes
+	MaxEntries    int   `json:"max_entries" yaml:"max_entries"`
+	MaxTotalBytes int64 `json:"max_total_bytes" yaml:"max_total_bytes"`
+
+	// Local and Remote are the stores of a tiered store, see
+	// persistence.NewTieredStore
+	Local           *StoreConfig `json:"local" yaml:"local"`
+	Remote          *StoreConfig `json:"remote" yaml:"remote"`
+	LocalExpiration Duration     `json:"local_expiration" yaml:"local_expiration"`
+}
+
+// TLSConfig configures the TLS connections of a store
+type TLSConfig struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// CAFile holds the PEM certificates of the authorities trusted to sign
+	// the certificates of the servers, instead of those of the system
+	CAFile string `json:"ca_file" yaml:"ca_file"`
+	// CertFile and KeyFile hold the PEM certificate and key of the client,
+	// for servers requiring one
+	CertFile string `json:"cert_file" yaml:"cert_file"`
+	KeyFile  string `json:"key_file" yaml:"key_file"`
+	// ServerName is verified against the certificates of the servers
+	// instead of their host
+	ServerName         string `json:"server_name" yaml:"server_name"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+}
+
+// Duration is a time.Duration decoded from strings such as "1m30s"
+type Duration time.Duration
+
+// UnmarshalText (see encoding.TextUnmarshaler interface)
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalText (see encoding.TextMarshaler interface)
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// FromConfig returns the store declared by cfg
+func FromConfig(cfg StoreConfig) (persistence.CacheStore, error) {
+	store, err := newConfiguredStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Namespace != "" {
+		return persistence.NewNamespacedStore(store, cfg.Namespace), nil
+	}
+	return store, nil
+}
+
+func newConfiguredStore(cfg StoreConfig) (persistence.CacheStore, error) {
+	expiration := time.Duration(cfg.DefaultExpiration)
+	codec, err := configuredCodec(cfg.Codec)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := cfg.TLS.build()
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Type {
+	case "inmemory":
+		var options []persistence.InMemoryOption
+		if cfg.MaxEntries > 0 {
+			options = append(options, persistence.WithMaxEntries(cfg.MaxEntries, persistence.EvictLRU))
+		}
+		if cfg.MaxTotalBytes > 0 {
+			options = append(options, persistence.WithMaxTotalBytes(cfg.MaxTotalBytes))
+		}
+		return persistence.NewInMemoryStore(expiration, options...), nil
+	case "redis":
+		if len(cfg.Addrs) == 0 {
+			return nil, errors.New("cache: no address given for the redis store.")
+		}
+		return persistence.NewRedisCache(&persistence.ClientOptions{
+			Addrs:        cfg.Addrs,
+			Password:     cfg.Password,
+			DB:           cfg.DB,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			TLSConfig:    tlsConfig,
+		}, expiration, persistence.WithCodec(codec))
+	case "memcached":
+		if len(cfg.Addrs) == 0 {
+			return nil, errors.New("cache: no address given for the memcached store.")
+		}
+		if cfg.Username != "" {
+			config := mc.DefaultConfig()
+			if cfg.PoolSize > 0 {
+				config.PoolSize = cfg.PoolSize
+			}
+			hosts := strings.Join(cfg.Addrs, ",")
+			var store *persistence.MemcachedBinaryStore
+			if tlsConfig != nil {
+				if store, err = persistence.NewMemcachedBinaryStoreTLS(hosts, cfg.Username, cfg.Password, expiration, tlsConfig, config, persistence.WithMemcachedBinaryCodec(codec)); err != nil {
+					return nil, err
+				}
+			} else {
+				store = persistence.NewMemcachedBinaryStoreWithConfig(hosts, cfg.Username, cfg.Password, expiration, config, persistence.WithMemcachedBinaryCodec(codec))
+			}
+			return store, nil
+		}
+		var store *persistence.MemcachedStore
+		if tlsConfig != nil {
+			if store, err = persistence.NewMemcachedStoreTLS(cfg.Addrs, expiration, tlsConfig, persistence.WithMemcachedCodec(codec)); err != nil {
+				return nil, err
+			}
+		} else {
+			store = persistence.NewMemcachedStore(cfg.Addrs, expiration, persistence.WithMemcachedCodec(codec))
+		}
+		if cfg.PoolSize > 0 {
+			store.Client.MaxIdleConns = cfg.PoolSize
+		}
+		return store, nil
+	case "tiered":
+		if cfg.Local == nil || cfg.Remote == nil {
+			return nil, errors.New("cache: a tiered store needs a local and a remote store.")
+		}
+		local, err := FromConfig(*cfg.Local)
+		if err != nil {
+			return nil, err
+		}
+		remote, err := FromConfig(*cfg.Remote)
+		if err != nil {
+			return nil, err
+		}
+		return persistence.NewTieredStore(local, remote, time.Duration(cfg.LocalExpiration)), nil
+	}
+	return nil, fmt.Errorf("cache: unknown store type %q.", cfg.Type)
+}
+
+// configuredCodec returns the codec named name
+func configuredCodec(name string) (utils.Codec, error) {
+	switch name {
+	case "", "gob":
+		return utils.GobCodec, nil
+	case "json":
+		return utils.JSONCodec, nil
+	case "msgpack":
+		return utils.MsgpackCodec, nil
+	case "raw":
+		return utils.RawCodec, nil
+	}
+	return nil, fmt.Errorf("cache: unknown codec %q.", name)
+}
+
+// build returns the tls.Config declared by cfg, nil if TLS is disabled
+func (cfg *TLSConfig) build() (*tls.Config, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+	config := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("cache: no certificate found in %s.", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// StoreConfigFromEnv loads a StoreConfig from the environment variables named
+// after the json tags of its fields, upper-cased and prefixed with prefix and
+// an underscore: with the prefix "CACHE", CACHE_TYPE sets Type,
+// CACHE_DEFAULT_EXPIRATION DefaultExpiration, and CACHE_TLS_CA_FILE the
+// CAFile of TLS. Addrs is a comma-separated list, and the stores of a tiered
+// store are prefixed with CACHE_LOCAL and CACHE_REMOTE.
+func StoreConfigFromEnv(prefix string) (StoreConfig, error) {
+	var cfg StoreConfig
+	_, err := loadEnv(prefix, reflect.ValueOf(&cfg).Elem())
+	return cfg, err
+}
+
+// loadEnv sets the fields of the struct v from the environment variables
+// prefixed with prefix, and reports whether any was set
+func loadEnv(prefix string, v reflect.Value) (found bool, err error) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := prefix + "_" + strings.ToUpper(field.Tag.Get("json"))
+		value := v.Field(i)
+		if field.Type.Kind() == reflect.Ptr {
+			// Nested configurations are only allocated if set, which
+			// also ends the recursion of tiered stores
+			if !hasEnvPrefix(name + "_") {
+				continue
+			}
+			nested := reflect.New(field.Type.Elem())
+			if _, err := loadEnv(name, nested.Elem()); err != nil {
+				return false, err
+			}
+			value.Set(nested)
+			found = true
+			continue
+		}
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setEnvValue(value, s); err != nil {
+			return false, fmt.Errorf("cache: invalid %s: %v", name, err)
+		}
+		found = true
+	}
+	return found, nil
+}
+
+func hasEnvPrefix(prefix string) bool {
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func setEnvValue(v reflect.Value, s string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	}
+	return nil
+}
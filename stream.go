@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"mime"
+	"net/http"
+)
+
+// StreamingPolicy decides how CachePage caches streamed responses: those
+// the handler flushes before returning, as gin's Context.Stream does, and
+// server-sent events, identified by their text/event-stream content type.
+type StreamingPolicy int
+
+const (
+	// StreamBypass does not cache streamed responses. It is the default.
+	StreamBypass StreamingPolicy = iota
+	// StreamCache caches streamed responses up to a size cap. Once a response
+	// exceeds it, it is no longer recorded, but keeps streaming to the
+	// client.
+	StreamCache
+)
+
+// WithStreaming sets how streamed responses are cached. With StreamCache,
+// they are cached as long as their body does not exceed maxSize bytes. If
+// maxSize <= 0, the limit set with WithMaxSize, if any, applies to them.
+func WithStreaming(policy StreamingPolicy, maxSize int) PageOption {
+	return func(o *pageOptions) {
+		o.streaming = policy
+		o.streamMaxSize = maxSize
+	}
+}
+
+// streamLimit returns the recordingWriter streamLimit implementing the
+// streaming policy
+func (o pageOptions) streamLimit() int {
+	if o.streaming == StreamBypass {
+		return -1
+	}
+	if o.streamMaxSize < 0 {
+		return 0
+	}
+	return o.streamMaxSize
+}
+
+// isEventStream reports whether header describes server-sent events
+func isEventStream(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
+)
+
+// pageTagsKey is the gin context key holding the tags of the page being cached
+const pageTagsKey = "gincontrib.cache.tags"
+
+// TagPage associates the page cached for the current request with tags, so
+// that persistence.TagStore's InvalidateTag evicts it. Call it from a handler
+// wrapped by CachePage or one of its variants; tags are ignored if the store
+// is not a persistence.TagStore.
+func TagPage(c *gin.Context, tags ...string) {
+	c.Set(pageTagsKey, append(c.GetStringSlice(pageTagsKey), tags...))
+}
+
+// Tagged returns a handler tagging the page it generates with tags before
+// calling handle, to tag all the pages of a route:
+//
+//	router.GET("/products/:id", CachePage(store, time.Hour, Tagged(handler, "products")))
+func Tagged(handle gin.HandlerFunc, tags ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		TagPage(c, tags...)
+		handle(c)
+	}
+}
+
+// tagPage applies the tags set with TagPage to the page cached at key
+func tagPage(store persistence.CacheStore, c *gin.Context, key string) {
+	tags := c.GetStringSlice(pageTagsKey)
+	tagStore, ok := store.(persistence.TagStore)
+	if len(tags) == 0 || !ok {
+		return
+	}
+	if err := tagStore.Tag(key, tags...); err != nil && err != persistence.ErrNotSupport {
+		log.Println(err.Error())
+	}
+}
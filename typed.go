@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"log"
+	"time"
+
+	"github.com/mlsen/cache/persistence"
+)
+
+// Typed wraps a CacheStore holding values of type T, sparing callers the
+// interface{} values and pointers of the CacheStore methods
+type Typed[T any] struct {
+	store persistence.CacheStore
+}
+
+// NewTyped returns a Typed reading and writing values of type T in store
+func NewTyped[T any](store persistence.CacheStore) *Typed[T] {
+	return &Typed[T]{store: store}
+}
+
+// Get returns the value at key, or persistence.ErrCacheMiss if there is none
+func (t *Typed[T]) Get(key string) (T, error) {
+	var value T
+	if err := t.store.Get(key, &value); err != nil {
+		var zero T
+		return zero, err
+	}
+	return value, nil
+}
+
+// Set stores value at key for expire
+func (t *Typed[T]) Set(key string, value T, expire time.Duration) error {
+	return t.store.Set(key, value, expire)
+}
+
+// GetOrCompute returns the value at key. If the key cannot be read from the
+// store, the value is computed with compute and stored for expire. An error
+// returned by compute is returned as is and nothing is stored; failing to
+// store the computed value is only logged.
+func (t *Typed[T]) GetOrCompute(key string, compute func() (T, error), expire time.Duration) (T, error) {
+	value, err := t.Get(key)
+	if err == nil {
+		return value, nil
+	}
+	if err != persistence.ErrCacheMiss {
+		log.Println(err.Error())
+	}
+	value, err = compute()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	if err := t.store.Set(key, value, expire); err != nil {
+		log.Println(err.Error())
+	}
+	return value, nil
+}
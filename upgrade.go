@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mlsen/cache/persistence"
+)
+
+// CachedResponse is a cached page, as returned by a ResponseDecoder
+type CachedResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// Stored is the time the response was cached, zero if unknown
+	Stored time.Time
+}
+
+// ResponseDecoder decodes a cached response from an envelope of a version
+// this version of the package does not write, data starting with the magic
+// byte and the version
+type ResponseDecoder func(data []byte) (CachedResponse, error)
+
+var responseDecoders = struct {
+	sync.RWMutex
+	byVersion map[byte]ResponseDecoder
+}{byVersion: make(map[byte]ResponseDecoder)}
+
+// RegisterResponseDecoder registers decoder for the envelopes of cached
+// responses of the given version, so that during a rolling upgrade the
+// replicas running a new version read the pages cached by the old one, and
+// the other way around, instead of all regenerating them. Call it at
+// startup, before serving requests. The decoders of the versions this package
+// reads, 1 and 2, cannot be replaced.
+//
+// Pages cached before responses were stored in an envelope, with gob, are
+// read without a decoder.
+func RegisterResponseDecoder(version byte, decoder ResponseDecoder) {
+	responseDecoders.Lock()
+	defer responseDecoders.Unlock()
+	responseDecoders.byVersion[version] = decoder
+}
+
+func lookupResponseDecoder(version byte) ResponseDecoder {
+	responseDecoders.RLock()
+	defer responseDecoders.RUnlock()
+	return responseDecoders.byVersion[version]
+}
+
+// WithRewriteUpgraded writes the pages read from an envelope of another
+// version, or cached before envelopes, back in the current envelope, keeping
+// their time to live when the store is a persistence.TTLStore. Once the
+// upgrade is over, the registered decoders are no longer needed.
+func WithRewriteUpgraded() PageOption {
+	return func(o *pageOptions) {
+		o.upgrade = true
+	}
+}
+
+// legacyResponseCache has the fields of responseCache, which gob encoded as
+// a struct before responses were stored in an envelope
+type legacyResponseCache struct {
+	Status int
+	Header http.Header
+	Data   []byte
+	Stored time.Time
+}
+
+// getLegacy reads the page cached at key before envelopes into cache, after
+// reading it failed with err. Only gob errors are retried, so that failures
+// of the store are not.
+func getLegacy(store persistence.CacheStore, key string, cache *responseCache, err error) error {
+	if !strings.HasPrefix(err.Error(), "gob: ") {
+		return err
+	}
+	var legacy legacyResponseCache
+	if err := store.Get(key, &legacy); err != nil {
+		return err
+	}
+	*cache = responseCache{Status: legacy.Status, Header: legacy.Header, Data: legacy.Data, Stored: legacy.Stored, upgraded: true}
+	return nil
+}
+
+// rewrite writes cache, read from another format, back at key in the current
+// envelope, for the time it had left to live or for expire
+func (o pageOptions) rewrite(store persistence.CacheStore, key string, cache responseCache, expire time.Duration) {
+	if ttlStore, ok := store.(persistence.TTLStore); ok {
+		// Only stores serializing values hold other formats: read the bytes
+		// as they are
+		var raw []byte
+		ttl, err := ttlStore.GetWithTTL(key, &raw)
+		if err != nil {
+			return
+		}
+		expire = ttl
+	}
+	cache.upgraded = false
+	if err := store.Set(key, cache, expire); err != nil {
+		o.logError(key, err)
+	}
+}
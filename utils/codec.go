@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+
+	ugorji "github.com/ugorji/go/codec"
+)
+
+// ErrNegativeHit is returned when reading a key cached as not found, see
+// NegativeValue
+var ErrNegativeHit = errors.New("cache: key cached as not found.")
+
+// negativeValue marks the keys cached as not found
+var negativeValue = []byte("\x00gincontrib.cache.negative\x00")
+
+// NegativeValue returns the value stored for keys cached as not found. Being
+// a byte slice, it is stored as is by every store, and reading it back
+// yields ErrNegativeHit.
+func NegativeValue() []byte {
+	return append([]byte(nil), negativeValue...)
+}
+
+// IsNegative reports whether value is the one returned by NegativeValue
+func IsNegative(value interface{}) bool {
+	b, ok := value.([]byte)
+	return ok && bytes.Equal(b, negativeValue)
+}
+
+// ErrRawCodecType is returned by RawCodec for values that are neither a
+// []byte nor a string
+var ErrRawCodecType = errors.New("cache: raw codec only supports []byte and string.")
+
+// Codec converts cached values to and from the bytes stored in the backend
+type Codec interface {
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte, ptr interface{}) error
+}
+
+var (
+	// GobCodec encodes values with encoding/gob. It is the default.
+	GobCodec Codec = gobCodec{}
+	// JSONCodec encodes values with encoding/json, which lets services
+	// written in other languages read the cache
+	JSONCodec Codec = jsonCodec{}
+	// MsgpackCodec encodes values with MessagePack
+	MsgpackCodec Codec = msgpackCodec{}
+	// RawCodec stores strings and byte slices as is, and rejects anything
+	// else with ErrRawCodecType
+	RawCodec Codec = rawCodec{}
+)
+
+// SerializeWith returns a []byte representing the passed value. Byte slices
+// and integers are stored as is, whatever the codec, so that counters work;
+// other values are encoded with codec.
+func SerializeWith(codec Codec, value interface{}) ([]byte, error) {
+	if bytes, ok := value.([]byte); ok {
+		return bytes, nil
+	}
+
+	switch v := reflect.ValueOf(value); v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return []byte(strconv.FormatInt(v.Int(), 10)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return []byte(strconv.FormatUint(v.Uint(), 10)), nil
+	}
+
+	return codec.Marshal(value)
+}
+
+// DeserializeWith deserializes the passed []byte into the passed ptr
+// interface{}, the inverse of SerializeWith. It returns ErrNegativeHit for
+// NegativeValue.
+func DeserializeWith(codec Codec, byt []byte, ptr interface{}) (err error) {
+	if bytes.Equal(byt, negativeValue) {
+		return ErrNegativeHit
+	}
+
+	if bytes, ok := ptr.(*[]byte); ok {
+		*bytes = byt
+		return nil
+	}
+
+	if v := reflect.ValueOf(ptr); v.Kind() == reflect.Ptr {
+		switch p := v.Elem(); p.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			var i int64
+			i, err = strconv.ParseInt(string(byt), 10, 64)
+			if err != nil {
+				return err
+			}
+
+			p.SetInt(i)
+			return nil
+
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			var i uint64
+			i, err = strconv.ParseUint(string(byt), 10, 64)
+			if err != nil {
+				return err
+			}
+
+			p.SetUint(i)
+			return nil
+		}
+	}
+
+	return codec.Unmarshal(byt, ptr)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(value interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	encoder := gob.NewEncoder(&b)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, ptr interface{}) error {
+	return gob.NewDecoder(bytes.NewBuffer(data)).Decode(ptr)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec) Unmarshal(data []byte, ptr interface{}) error {
+	return json.Unmarshal(data, ptr)
+}
+
+var msgpackHandle ugorji.MsgpackHandle
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(value interface{}) ([]byte, error) {
+	var b []byte
+	err := ugorji.NewEncoderBytes(&b, &msgpackHandle).Encode(value)
+	return b, err
+}
+
+func (msgpackCodec) Unmarshal(data []byte, ptr interface{}) error {
+	return ugorji.NewDecoderBytes(data, &msgpackHandle).Decode(ptr)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Marshal(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, ErrRawCodecType
+}
+
+func (rawCodec) Unmarshal(data []byte, ptr interface{}) error {
+	switch p := ptr.(type) {
+	case *[]byte:
+		*p = data
+	case *string:
+		*p = string(data)
+	default:
+		return ErrRawCodecType
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+type codecItem struct {
+	Name  string
+	Count int
+	Tags  []string
+}
+
+func TestCodecs(t *testing.T) {
+	item := codecItem{Name: "item", Count: 3, Tags: []string{"a", "b"}}
+	tests := []struct {
+		name  string
+		codec Codec
+		value interface{}
+		ptr   interface{}
+		// data is the expected encoding, if deterministic
+		data string
+	}{
+		{"gob struct", GobCodec, item, &codecItem{}, ""},
+		{"gob string", GobCodec, "value", new(string), ""},
+		{"json struct", JSONCodec, item, &codecItem{}, `{"Name":"item","Count":3,"Tags":["a","b"]}`},
+		{"json string", JSONCodec, "value", new(string), `"value"`},
+		{"msgpack struct", MsgpackCodec, item, &codecItem{}, ""},
+		{"msgpack string", MsgpackCodec, "value", new(string), "\xa5value"},
+		{"raw string", RawCodec, "value", new(string), "value"},
+		{"raw bytes", RawCodec, []byte("value"), new([]byte), "value"},
+	}
+	for _, test := range tests {
+		data, err := test.codec.Marshal(test.value)
+		if err != nil {
+			t.Errorf("%s: error encoding: %s", test.name, err)
+			continue
+		}
+		if test.data != "" && string(data) != test.data {
+			t.Errorf("%s: expected %q, got %q", test.name, test.data, data)
+		}
+		if err := test.codec.Unmarshal(data, test.ptr); err != nil {
+			t.Errorf("%s: error decoding: %s", test.name, err)
+			continue
+		}
+		if got := reflect.ValueOf(test.ptr).Elem().Interface(); !reflect.DeepEqual(got, test.value) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.value, got)
+		}
+	}
+}
+
+func TestCodecs_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		codec Codec
+		data  string
+	}{
+		{"gob", GobCodec, "not gob"},
+		{"json", JSONCodec, "{"},
+		{"msgpack", MsgpackCodec, "\xa5val"},
+	}
+	for _, test := range tests {
+		var item codecItem
+		if err := test.codec.Unmarshal([]byte(test.data), &item); err == nil {
+			t.Errorf("%s: expected an error decoding %q", test.name, test.data)
+		}
+	}
+
+	if _, err := RawCodec.Marshal(1.5); err != ErrRawCodecType {
+		t.Errorf("Expected ErrRawCodecType encoding a float, got: %v", err)
+	}
+	var item codecItem
+	if err := RawCodec.Unmarshal([]byte("value"), &item); err != ErrRawCodecType {
+		t.Errorf("Expected ErrRawCodecType decoding into a struct, got: %v", err)
+	}
+}
+
+func TestSerializeWith(t *testing.T) {
+	for _, codec := range []Codec{GobCodec, JSONCodec, MsgpackCodec, RawCodec} {
+		// Byte slices and integers are stored as is, whatever the codec
+		tests := []struct {
+			value interface{}
+			ptr   interface{}
+			data  string
+		}{
+			{[]byte("bytes"), new([]byte), "bytes"},
+			{-42, new(int), "-42"},
+			{int64(1) << 40, new(int64), "1099511627776"},
+			{uint8(7), new(uint8), "7"},
+		}
+		for _, test := range tests {
+			data, err := SerializeWith(codec, test.value)
+			if err != nil || string(data) != test.data {
+				t.Errorf("%T: expected %v to be stored as %q, got %q, %v", codec, test.value, test.data, data, err)
+			}
+			if err := DeserializeWith(codec, data, test.ptr); err != nil {
+				t.Errorf("%T: error reading %q: %s", codec, data, err)
+			}
+			if got := reflect.ValueOf(test.ptr).Elem().Interface(); !reflect.DeepEqual(got, test.value) {
+				t.Errorf("%T: expected %v, got %v", codec, test.value, got)
+			}
+		}
+
+		var s string
+		if err := DeserializeWith(codec, NegativeValue(), &s); err != ErrNegativeHit {
+			t.Errorf("%T: expected ErrNegativeHit, got: %v", codec, err)
+		}
+	}
+
+	var i int
+	if err := DeserializeWith(GobCodec, []byte("not a number"), &i); err == nil {
+		t.Errorf("Expected an error reading a counter that is not a number")
+	}
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// ErrUnknownCodec is returned when decoding a value compressed with an
+// algorithm this version does not know
+var ErrUnknownCodec = errors.New("cache: value compressed with an unknown codec.")
+
+// Compression is an algorithm used by a compressed codec
+type Compression byte
+
+// The values of Compression are stored in the header of compressed values,
+// and must not change
+const (
+	Gzip Compression = iota + 1
+	Snappy
+	Zstd
+)
+
+// compressedMagic starts the header of compressed values, followed by the
+// Compression used. It is never produced by gob, JSON or MessagePack, so
+// values stored uncompressed, before compression was enabled or because they
+// were below the threshold, are told apart.
+const compressedMagic = 0xc1
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+// NewCompressedCodec returns a Codec compressing the values encoded by codec
+// with compression, when they are at least threshold bytes long. Values are
+// decoded whatever the algorithm they were compressed with, so that the
+// compression can be changed without flushing the cache.
+func NewCompressedCodec(codec Codec, compression Compression, threshold int) Codec {
+	return compressedCodec{codec: codec, compression: compression, threshold: threshold}
+}
+
+type compressedCodec struct {
+	codec       Codec
+	compression Compression
+	threshold   int
+}
+
+func (c compressedCodec) Marshal(value interface{}) ([]byte, error) {
+	b, err := c.codec.Marshal(value)
+	if err != nil || len(b) < c.threshold {
+		return b, err
+	}
+	header := []byte{compressedMagic, byte(c.compression)}
+	switch c.compression {
+	case Gzip:
+		buf := bytes.NewBuffer(header)
+		w := gzip.NewWriter(buf)
+		if _, err := w.Write(b); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Snappy:
+		return append(header, snappy.Encode(nil, b)...), nil
+	case Zstd:
+		initZstd()
+		return zstdEncoder.EncodeAll(b, header), nil
+	}
+	return nil, ErrUnknownCodec
+}
+
+func (c compressedCodec) Unmarshal(data []byte, ptr interface{}) error {
+	if len(data) < 2 || data[0] != compressedMagic {
+		return c.codec.Unmarshal(data, ptr)
+	}
+	var (
+		b   []byte
+		err error
+	)
+	switch Compression(data[1]) {
+	case Gzip:
+		var r *gzip.Reader
+		if r, err = gzip.NewReader(bytes.NewReader(data[2:])); err == nil {
+			b, err = ioutil.ReadAll(r)
+		}
+	case Snappy:
+		b, err = snappy.Decode(nil, data[2:])
+	case Zstd:
+		initZstd()
+		b, err = zstdDecoder.DecodeAll(data[2:], nil)
+	default:
+		return ErrUnknownCodec
+	}
+	if err != nil {
+		return err
+	}
+	return c.codec.Unmarshal(b, ptr)
+}
+
+// initZstd creates the zstd encoder and decoder, which are safe for
+// concurrent use
+func initZstd() {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil)
+		zstdDecoder, _ = zstd.NewReader(nil)
+	})
+}
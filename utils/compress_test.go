@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+var compressions = map[string]Compression{
+	"gzip":   Gzip,
+	"snappy": Snappy,
+	"zstd":   Zstd,
+}
+
+func TestCompressedCodec_RoundTrip(t *testing.T) {
+	body := strings.Repeat("<p>cached page</p>", 1000)
+	for name, compression := range compressions {
+		codec := NewCompressedCodec(GobCodec, compression, 1024)
+
+		data, err := codec.Marshal(body)
+		if err != nil {
+			t.Fatalf("Error compressing with %s: %s", name, err)
+		}
+		if data[0] != compressedMagic || Compression(data[1]) != compression {
+			t.Errorf("Expected %s to prefix the value with its header, got %x", name, data[:2])
+		}
+		if len(data) >= len(body) {
+			t.Errorf("Expected %s to compress the value, got %d bytes", name, len(data))
+		}
+		var s string
+		if err := codec.Unmarshal(data, &s); err != nil || s != body {
+			t.Errorf("Expected to read back the %s value, got %v", name, err)
+		}
+
+		// Below the threshold, values are stored as encoded by the codec
+		small, _ := codec.Marshal("small")
+		plain, _ := GobCodec.Marshal("small")
+		if string(small) != string(plain) {
+			t.Errorf("Expected %s to leave small values uncompressed, got %x", name, small)
+		}
+		if err := codec.Unmarshal(small, &s); err != nil || s != "small" {
+			t.Errorf("Expected to read back the small %s value, got %q, %v", name, s, err)
+		}
+	}
+}
+
+func TestCompressedCodec_AnyCompression(t *testing.T) {
+	body := strings.Repeat("<p>cached page</p>", 1000)
+	for name, compression := range compressions {
+		data, _ := NewCompressedCodec(JSONCodec, compression, 0).Marshal(body)
+		// Values are decoded whatever the compression they were written with
+		for _, other := range compressions {
+			var s string
+			if err := NewCompressedCodec(JSONCodec, other, 0).Unmarshal(data, &s); err != nil || s != body {
+				t.Errorf("Expected to read back the %s value, got %v", name, err)
+			}
+		}
+	}
+}
+
+func TestCompressedCodec_Plain(t *testing.T) {
+	codec := NewCompressedCodec(JSONCodec, Zstd, 0)
+	// Written before compression was enabled
+	for _, plain := range []string{`"stored before compression"`, `{}`, `1`} {
+		var v interface{}
+		if err := codec.Unmarshal([]byte(plain), &v); err != nil {
+			t.Errorf("Expected to read the plain value %s, got: %s", plain, err)
+		}
+	}
+}
+
+func TestCompressedCodec_Corrupt(t *testing.T) {
+	codec := NewCompressedCodec(GobCodec, Gzip, 0)
+	var s string
+	if err := codec.Unmarshal([]byte{compressedMagic, 0xff, 0}, &s); err != ErrUnknownCodec {
+		t.Errorf("Expected ErrUnknownCodec, got: %v", err)
+	}
+	for name, compression := range compressions {
+		data, _ := NewCompressedCodec(GobCodec, compression, 0).Marshal(strings.Repeat("page", 100))
+		corrupt := append([]byte{compressedMagic, byte(compression)}, []byte("not compressed")...)
+		if err := codec.Unmarshal(corrupt, &s); err == nil {
+			t.Errorf("Expected an error for corrupt %s data", name)
+		}
+		if err := codec.Unmarshal(data[:len(data)/2], &s); err == nil {
+			t.Errorf("Expected an error for truncated %s data", name)
+		}
+	}
+	if _, err := NewCompressedCodec(GobCodec, Compression(0xff), 0).Marshal("page"); err != ErrUnknownCodec {
+		t.Errorf("Expected ErrUnknownCodec compressing with an unknown algorithm, got: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrUnknownKey is returned when decoding a value encrypted with a key
+	// the codec was not given, or when the current key is missing
+	ErrUnknownKey = errors.New("cache: value encrypted with an unknown key.")
+	// ErrNotEncrypted is returned when decoding a value that was not written
+	// by an encrypted codec
+	ErrNotEncrypted = errors.New("cache: value is not encrypted.")
+)
+
+// encryptedMagic starts the envelope of encrypted values, followed by the key
+// ID, the nonce and the sealed value
+const encryptedMagic = 0xc2
+
+// NewEncryptedCodec returns a Codec encrypting the values encoded by codec
+// with AES-GCM, so that they cannot be read nor altered by whoever has access
+// to the backend. keys maps key IDs to AES keys of 16, 24 or 32 bytes; values
+// are encrypted with the key current, and decrypted with the key they were
+// encrypted with. To rotate keys, add the new key and make it current, then
+// remove the old one once the values it encrypted have expired.
+//
+// Values that are not encrypted are rejected with ErrNotEncrypted, so the
+// cache must be flushed when enabling encryption. Byte slices and integers
+// are stored as is by the stores, whatever the codec (see SerializeWith).
+// To compress values as well, wrap a compressed codec, as encrypted values
+// do not compress:
+//
+//	codec, err := utils.NewEncryptedCodec(utils.NewCompressedCodec(utils.GobCodec, utils.Zstd, 1024), 2, keys)
+func NewEncryptedCodec(codec Codec, current byte, keys map[byte][]byte) (Codec, error) {
+	aeads := make(map[byte]cipher.AEAD, len(keys))
+	for id, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if aeads[id], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := aeads[current]; !ok {
+		return nil, ErrUnknownKey
+	}
+	return encryptedCodec{codec: codec, current: current, aeads: aeads}, nil
+}
+
+type encryptedCodec struct {
+	codec   Codec
+	current byte
+	aeads   map[byte]cipher.AEAD
+}
+
+func (c encryptedCodec) Marshal(value interface{}) ([]byte, error) {
+	b, err := c.codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	aead := c.aeads[c.current]
+	header := []byte{encryptedMagic, c.current}
+	data := make([]byte, len(header)+aead.NonceSize(), len(header)+aead.NonceSize()+len(b)+aead.Overhead())
+	copy(data, header)
+	nonce := data[len(header):]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	// The header is authenticated, so that the key ID cannot be altered
+	return aead.Seal(data, nonce, b, header), nil
+}
+
+func (c encryptedCodec) Unmarshal(data []byte, ptr interface{}) error {
+	if len(data) < 2 || data[0] != encryptedMagic {
+		return ErrNotEncrypted
+	}
+	aead, ok := c.aeads[data[1]]
+	if !ok {
+		return ErrUnknownKey
+	}
+	if len(data) < 2+aead.NonceSize() {
+		return ErrNotEncrypted
+	}
+	header, nonce, sealed := data[:2], data[2:2+aead.NonceSize()], data[2+aead.NonceSize():]
+	b, err := aead.Open(nil, nonce, sealed, header)
+	if err != nil {
+		return err
+	}
+	return c.codec.Unmarshal(b, ptr)
+}
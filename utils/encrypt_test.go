@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+)
+
+var encryptionKeys = map[byte][]byte{
+	1: bytes.Repeat([]byte{1}, 32),
+	2: bytes.Repeat([]byte{2}, 32),
+}
+
+func TestNewEncryptedCodec(t *testing.T) {
+	if _, err := NewEncryptedCodec(GobCodec, 3, encryptionKeys); err != ErrUnknownKey {
+		t.Errorf("Expected ErrUnknownKey for a missing current key, got: %v", err)
+	}
+	if _, err := NewEncryptedCodec(GobCodec, 1, map[byte][]byte{1: []byte("short")}); err == nil {
+		t.Errorf("Expected an error for an invalid key")
+	}
+}
+
+func TestEncryptedCodec_RoundTrip(t *testing.T) {
+	codec, err := NewEncryptedCodec(JSONCodec, 1, encryptionKeys)
+	if err != nil {
+		t.Fatalf("Error creating the codec: %s", err)
+	}
+	data, err := codec.Marshal("jane@example.com")
+	if err != nil {
+		t.Fatalf("Error encrypting: %s", err)
+	}
+	if data[0] != encryptedMagic || data[1] != 1 {
+		t.Errorf("Expected the envelope to start with the magic and the key ID, got %x", data[:2])
+	}
+	if bytes.Contains(data, []byte("jane")) {
+		t.Errorf("Expected the value to be encrypted, got %q", data)
+	}
+	var s string
+	if err := codec.Unmarshal(data, &s); err != nil || s != "jane@example.com" {
+		t.Errorf("Expected to decrypt the value, got %q, %v", s, err)
+	}
+
+	// Each value is encrypted with its own nonce
+	again, _ := codec.Marshal("jane@example.com")
+	if bytes.Equal(data, again) {
+		t.Errorf("Expected the same value to encrypt differently")
+	}
+}
+
+func TestEncryptedCodec_RotatedKey(t *testing.T) {
+	previous, _ := NewEncryptedCodec(JSONCodec, 1, encryptionKeys)
+	data, _ := previous.Marshal("jane@example.com")
+
+	// After a rotation, values encrypted with the previous key are still read
+	rotated, _ := NewEncryptedCodec(JSONCodec, 2, encryptionKeys)
+	var s string
+	if err := rotated.Unmarshal(data, &s); err != nil || s != "jane@example.com" {
+		t.Errorf("Expected to decrypt with the previous key, got %q, %v", s, err)
+	}
+	if data, _ := rotated.Marshal("x"); data[1] != 2 {
+		t.Errorf("Expected values to be encrypted with the current key, got key %d", data[1])
+	}
+
+	withoutKey, _ := NewEncryptedCodec(JSONCodec, 2, map[byte][]byte{2: encryptionKeys[2]})
+	if err := withoutKey.Unmarshal(data, &s); err != ErrUnknownKey {
+		t.Errorf("Expected ErrUnknownKey once the previous key is removed, got: %v", err)
+	}
+}
+
+func TestEncryptedCodec_WrongKey(t *testing.T) {
+	codec, _ := NewEncryptedCodec(JSONCodec, 1, encryptionKeys)
+	data, _ := codec.Marshal("jane@example.com")
+
+	// Another key under the same ID
+	other, _ := NewEncryptedCodec(JSONCodec, 1, map[byte][]byte{1: bytes.Repeat([]byte{9}, 32)})
+	var s string
+	if err := other.Unmarshal(data, &s); err == nil {
+		t.Errorf("Expected an error decrypting with the wrong key, got %q", s)
+	}
+}
+
+func TestEncryptedCodec_Tampered(t *testing.T) {
+	codec, _ := NewEncryptedCodec(JSONCodec, 1, encryptionKeys)
+	data, _ := codec.Marshal("jane@example.com")
+
+	tests := map[string][]byte{
+		"altered ciphertext": append(append([]byte(nil), data[:len(data)-1]...), data[len(data)-1]^1),
+		"altered key ID":     append([]byte{encryptedMagic, 2}, data[2:]...),
+		"truncated tag":      data[:len(data)-4],
+		"truncated nonce":    data[:5],
+	}
+	for name, tampered := range tests {
+		var s string
+		if err := codec.Unmarshal(tampered, &s); err == nil {
+			t.Errorf("Expected an error for %s, got %q", name, s)
+		}
+	}
+
+	var s string
+	if err := codec.Unmarshal([]byte(`"jane@example.com"`), &s); err != ErrNotEncrypted {
+		t.Errorf("Expected ErrNotEncrypted for a plain value, got: %v", err)
+	}
+	if err := codec.Unmarshal(nil, &s); err != ErrNotEncrypted {
+		t.Errorf("Expected ErrNotEncrypted for an empty value, got: %v", err)
+	}
+}
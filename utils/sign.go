@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+)
+
+var (
+	// ErrInvalidSignature is returned when decoding a signed value whose
+	// signature does not match, because it was altered or written by someone
+	// without the key
+	ErrInvalidSignature = errors.New("cache: value signature is invalid.")
+	// ErrNotSigned is returned when decoding a value that was not written by
+	// a signed codec
+	ErrNotSigned = errors.New("cache: value is not signed.")
+)
+
+// signedMagic starts the envelope of signed values, followed by the key ID,
+// the HMAC-SHA256 of the key ID and the value, and the value
+const signedMagic = 0xc3
+
+// NewSignedCodec returns a Codec signing the values encoded by codec with
+// HMAC-SHA256, so that values altered in the backend, or written there by
+// another application sharing it, are rejected instead of being served.
+// Unlike NewEncryptedCodec, values remain readable by whoever has access to
+// the backend. keys maps key IDs to secret keys, which should be at least 32
+// bytes long; values are signed with the key current, and verified with the
+// key they were signed with, so that keys can be rotated like those of
+// NewEncryptedCodec.
+//
+// Values that are not signed are rejected with ErrNotSigned, so the cache
+// must be flushed when enabling signatures. Byte slices and integers are
+// stored as is by the stores, whatever the codec (see SerializeWith), and
+// are not signed: the pages cached by the middleware are structs, and are.
+func NewSignedCodec(codec Codec, current byte, keys map[byte][]byte) (Codec, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, ErrUnknownKey
+	}
+	copied := make(map[byte][]byte, len(keys))
+	for id, key := range keys {
+		copied[id] = append([]byte(nil), key...)
+	}
+	return signedCodec{codec: codec, current: current, keys: copied}, nil
+}
+
+type signedCodec struct {
+	codec   Codec
+	current byte
+	keys    map[byte][]byte
+}
+
+func (c signedCodec) Marshal(value interface{}) ([]byte, error) {
+	b, err := c.codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 2, 2+sha256.Size+len(b))
+	data[0], data[1] = signedMagic, c.current
+	data = append(data, c.sign(c.current, b)...)
+	return append(data, b...), nil
+}
+
+func (c signedCodec) Unmarshal(data []byte, ptr interface{}) error {
+	if len(data) < 2+sha256.Size || data[0] != signedMagic {
+		return ErrNotSigned
+	}
+	if _, ok := c.keys[data[1]]; !ok {
+		return ErrUnknownKey
+	}
+	mac, b := data[2:2+sha256.Size], data[2+sha256.Size:]
+	if !hmac.Equal(mac, c.sign(data[1], b)) {
+		return ErrInvalidSignature
+	}
+	return c.codec.Unmarshal(b, ptr)
+}
+
+// sign returns the HMAC of the key ID and b with the key id, so that the key
+// ID cannot be altered
+func (c signedCodec) sign(id byte, b []byte) []byte {
+	h := hmac.New(sha256.New, c.keys[id])
+	h.Write([]byte{id})
+	h.Write(b)
+	return h.Sum(nil)
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+)
+
+var signingKeys = map[byte][]byte{
+	1: bytes.Repeat([]byte{1}, 32),
+	2: bytes.Repeat([]byte{2}, 32),
+}
+
+func TestNewSignedCodec(t *testing.T) {
+	if _, err := NewSignedCodec(GobCodec, 3, signingKeys); err != ErrUnknownKey {
+		t.Errorf("Expected ErrUnknownKey for a missing current key, got: %v", err)
+	}
+}
+
+func TestSignedCodec_Valid(t *testing.T) {
+	codec, err := NewSignedCodec(JSONCodec, 1, signingKeys)
+	if err != nil {
+		t.Fatalf("Error creating the codec: %s", err)
+	}
+	data, err := codec.Marshal("<html>")
+	if err != nil {
+		t.Fatalf("Error signing: %s", err)
+	}
+	if data[0] != signedMagic || data[1] != 1 {
+		t.Errorf("Expected the envelope to start with the magic and the key ID, got %x", data[:2])
+	}
+	var s string
+	if err := codec.Unmarshal(data, &s); err != nil || s != "<html>" {
+		t.Errorf("Expected to read back the value, got %q, %v", s, err)
+	}
+
+	// After a rotation, values signed with the previous key are still read
+	rotated, _ := NewSignedCodec(JSONCodec, 2, signingKeys)
+	if err := rotated.Unmarshal(data, &s); err != nil || s != "<html>" {
+		t.Errorf("Expected to verify with the previous key, got %q, %v", s, err)
+	}
+}
+
+func TestSignedCodec_FlippedByte(t *testing.T) {
+	codec, _ := NewSignedCodec(JSONCodec, 1, signingKeys)
+	data, _ := codec.Marshal("<html>")
+
+	// Whether in the key ID, the MAC or the value, a change is detected
+	for _, i := range []int{1, 2, 2 + sha256.Size, len(data) - 2} {
+		flipped := append([]byte(nil), data...)
+		flipped[i] ^= 1
+		var s string
+		if err := codec.Unmarshal(flipped, &s); err != ErrInvalidSignature && err != ErrUnknownKey {
+			t.Errorf("Expected the value with byte %d flipped to be rejected, got %q, %v", i, s, err)
+		}
+	}
+}
+
+func TestSignedCodec_WrongKey(t *testing.T) {
+	codec, _ := NewSignedCodec(JSONCodec, 1, signingKeys)
+	data, _ := codec.Marshal("<html>")
+
+	// Another key under the same ID, e.g. another application
+	other, _ := NewSignedCodec(JSONCodec, 1, map[byte][]byte{1: bytes.Repeat([]byte{9}, 32)})
+	var s string
+	if err := other.Unmarshal(data, &s); err != ErrInvalidSignature {
+		t.Errorf("Expected ErrInvalidSignature, got: %v", err)
+	}
+
+	withoutKey, _ := NewSignedCodec(JSONCodec, 2, map[byte][]byte{2: signingKeys[2]})
+	if err := withoutKey.Unmarshal(data, &s); err != ErrUnknownKey {
+		t.Errorf("Expected ErrUnknownKey, got: %v", err)
+	}
+}
+
+func TestSignedCodec_Short(t *testing.T) {
+	codec, _ := NewSignedCodec(JSONCodec, 1, signingKeys)
+	data, _ := codec.Marshal("")
+
+	var s string
+	for _, short := range [][]byte{nil, {signedMagic}, data[:2+sha256.Size-1]} {
+		if err := codec.Unmarshal(short, &s); err != ErrNotSigned {
+			t.Errorf("Expected ErrNotSigned for %d bytes, got: %v", len(short), err)
+		}
+	}
+	if err := codec.Unmarshal([]byte(`"<script>"`), &s); err != ErrNotSigned {
+		t.Errorf("Expected ErrNotSigned for a plain value, got: %v", err)
+	}
+}
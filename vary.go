@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/mlsen/cache/persistence"
+)
+
+// varySuffix is appended to the key of a page to store the request headers
+// its responses vary on, as announced by the handler
+const varySuffix = ":vary"
+
+// maxKeyLength keeps keys within the limits of memcached
+const maxKeyLength = 250
+
+// WithVary caches a response per value of the given request headers, such as
+// Accept-Encoding or Accept-Language, instead of per URL only
+func WithVary(headers ...string) PageOption {
+	return func(o *pageOptions) {
+		o.vary = append(o.vary, headers...)
+	}
+}
+
+// WithResponseVary caches a response per value of the request headers listed
+// in the Vary header set by the handler. The headers are remembered per URL
+// once a response was generated; responses with "Vary: *" are not cached.
+func WithResponseVary() PageOption {
+	return func(o *pageOptions) {
+		o.responseVary = true
+	}
+}
+
+// varyHeaders returns the request headers the page at base varies on
+func (o pageOptions) varyHeaders(store persistence.CacheStore, base string) []string {
+	headers := o.vary
+	if o.responseVary {
+		var announced []string
+		if err := store.Get(base+varySuffix, &announced); err == nil {
+			headers = append(append([]string(nil), headers...), announced...)
+		}
+	}
+	return normalizeHeaders(headers)
+}
+
+// revary moves the page cached at key to the key matching the Vary header of
+// the response, if it names headers the key does not account for, and
+// remembers them for later requests. It returns the key of the page, or "" if
+// the page must not be cached.
+func (o pageOptions) revary(store persistence.CacheStore, c *gin.Context, base, key string, headers []string, expire time.Duration) string {
+	if !o.responseVary {
+		return key
+	}
+	var announced []string
+	for _, value := range c.Writer.Header()[http.CanonicalHeaderKey("Vary")] {
+		for _, h := range strings.Split(value, ",") {
+			if h = strings.TrimSpace(h); h == "*" {
+				store.Delete(key)
+				return ""
+			} else if h != "" {
+				announced = append(announced, h)
+			}
+		}
+	}
+	all := normalizeHeaders(append(append([]string(nil), headers...), announced...))
+	if len(all) == len(headers) {
+		return key
+	}
+
+	store.Set(base+varySuffix, normalizeHeaders(announced), expire)
+	newKey := varyKey(base, c.Request, all)
+	var cache responseCache
+	if err := store.Get(key, &cache); err == nil {
+		store.Set(newKey, cache, expire)
+		store.Delete(key)
+	}
+	return newKey
+}
+
+// normalizeHeaders returns headers canonicalized, sorted and without
+// duplicates
+func normalizeHeaders(headers []string) []string {
+	if len(headers) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool, len(headers))
+	normalized := make([]string, 0, len(headers))
+	for _, h := range headers {
+		h = http.CanonicalHeaderKey(h)
+		if !seen[h] {
+			seen[h] = true
+			normalized = append(normalized, h)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// varyKey returns the key of the variant of the page at base matching the
+// values of headers in r
+func varyKey(base string, r *http.Request, headers []string) string {
+	if len(headers) == 0 {
+		return base
+	}
+	var buffer bytes.Buffer
+	buffer.WriteString(base)
+	for _, h := range headers {
+		buffer.WriteString("|")
+		buffer.WriteString(h)
+		buffer.WriteString("=")
+		buffer.WriteString(url.QueryEscape(strings.Join(r.Header[h], ",")))
+	}
+	if buffer.Len() <= maxKeyLength {
+		return buffer.String()
+	}
+	sum := sha1.Sum(buffer.Bytes())
+	return base + "|" + hex.EncodeToString(sum[:])
+}
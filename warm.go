@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mlsen/cache/persistence"
+)
+
+// defaultWarmConcurrency is the number of targets a Warmer warms at once,
+// unless set with WithWarmConcurrency
+const defaultWarmConcurrency = 4
+
+// WarmProgress reports the progress of Warmer.Run, once per target warmed
+type WarmProgress struct {
+	// Target is the URL or key just warmed
+	Target string
+	// Err is the error warming Target, if any
+	Err error
+	// Done counts the targets warmed so far, including failed ones, out of
+	// Total
+	Done, Total int
+}
+
+// WarmError lists the targets a Warmer failed to warm, by URL or key
+type WarmError map[string]error
+
+func (e WarmError) Error() string {
+	targets := make([]string, 0, len(e))
+	for target := range e {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	failures := make([]string, len(targets))
+	for i, target := range targets {
+		failures[i] = fmt.Sprintf("%s: %s", target, e[target])
+	}
+	return "cache: warming failed for " + strings.Join(failures, "; ")
+}
+
+// WarmOption configures optional behaviour of a Warmer
+type WarmOption func(*Warmer)
+
+// WithWarmConcurrency sets the number of targets warmed at once. The default
+// is 4.
+func WithWarmConcurrency(n int) WarmOption {
+	return func(w *Warmer) {
+		w.concurrency = n
+	}
+}
+
+// WithWarmProgress sets a function called each time a target is warmed. It
+// is called from one goroutine at a time.
+func WithWarmProgress(fn func(WarmProgress)) WarmOption {
+	return func(w *Warmer) {
+		w.progress = fn
+	}
+}
+
+// Warmer populates the cache before traffic arrives, typically at startup:
+// it requests URLs from the application, so that pages cached by CachePage
+// and its variants are generated, and stores the values of loaders.
+//
+//	err := cache.NewWarmer(router).
+//		AddURL("/", "/products").
+//		AddLoader(store, "config", time.Hour, loadConfig).
+//		Run(ctx)
+type Warmer struct {
+	handler     http.Handler
+	concurrency int
+	progress    func(WarmProgress)
+	targets     []warmTarget
+}
+
+type warmTarget struct {
+	name string
+	warm func(ctx context.Context) error
+}
+
+// NewWarmer returns a Warmer requesting URLs from handler, usually the gin
+// engine. handler may be nil if only loaders are added.
+func NewWarmer(handler http.Handler, options ...WarmOption) *Warmer {
+	w := &Warmer{handler: handler, concurrency: defaultWarmConcurrency}
+	for _, option := range options {
+		option(w)
+	}
+	if w.concurrency < 1 {
+		w.concurrency = 1
+	}
+	return w
+}
+
+// AddURL adds URLs to GET from the handler. A URL fails to warm if the
+// response has a status code >= 400.
+func (w *Warmer) AddURL(urls ...string) *Warmer {
+	for _, url := range urls {
+		url := url
+		w.targets = append(w.targets, warmTarget{name: url, warm: func(ctx context.Context) error {
+			return w.request(ctx, url)
+		}})
+	}
+	return w
+}
+
+// AddLoader adds a value to store at key for expire, as returned by loader
+func (w *Warmer) AddLoader(store persistence.CacheStore, key string, expire time.Duration, loader func(ctx context.Context) (interface{}, error)) *Warmer {
+	w.targets = append(w.targets, warmTarget{name: key, warm: func(ctx context.Context) error {
+		value, err := loader(ctx)
+		if err != nil {
+			return err
+		}
+		return persistence.BindContext(ctx, store).Set(key, value, expire)
+	}})
+	return w
+}
+
+func (w *Warmer) request(ctx context.Context, url string) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	rec := httptest.NewRecorder()
+	w.handler.ServeHTTP(rec, req.WithContext(ctx))
+	if rec.Code >= 400 {
+		return fmt.Errorf("status %d", rec.Code)
+	}
+	return nil
+}
+
+// Run warms the targets added with persistence.WarmKeys, and returns once
+// all are warmed. A URL or key added several times is warmed once, with the
+// target added last. It returns a WarmError if some failed, or the error of
+// ctx if it is done first, in which case the remaining targets are skipped.
+func (w *Warmer) Run(ctx context.Context) error {
+	names := make([]string, 0, len(w.targets))
+	targets := make(map[string]warmTarget, len(w.targets))
+	for _, target := range w.targets {
+		if _, ok := targets[target.name]; !ok {
+			names = append(names, target.name)
+		}
+		targets[target.name] = target
+	}
+
+	var (
+		mu       sync.Mutex
+		done     int
+		failures = WarmError{}
+	)
+	err := persistence.WarmKeys(ctx, names, w.concurrency, func(ctx context.Context, name string) error {
+		err := targets[name].warm(ctx)
+		mu.Lock()
+		defer mu.Unlock()
+		done++
+		if err != nil {
+			failures[name] = err
+		}
+		if w.progress != nil {
+			w.progress(WarmProgress{Target: name, Err: err, Done: done, Total: len(names)})
+		}
+		// Failures are reported together in a WarmError
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(failures) > 0 {
+		return failures
+	}
+	return nil
+}
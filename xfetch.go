@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/mlsen/cache/persistence"
+)
+
+// WithEarlyRecompute regenerates pages before they expire, so that popular
+// pages do not all miss the cache at once when they do: a request for a
+// cached page runs the handler instead, with a probability growing as the
+// expiration nears and the longer the handler took to generate the page, see
+// persistence.ShouldRecompute. beta scales how early, 1 being the optimal
+// default.
+//
+// Pages only know the expiration they were stored with: a TTL shortened
+// later, by WithCacheControl for instance, is not accounted for.
+func WithEarlyRecompute(beta float64) PageOption {
+	return func(o *pageOptions) {
+		o.earlyBeta = beta
+	}
+}
+
+// recomputeEarly reports whether the page cached in cache should be
+// generated again before it expires
+func (o pageOptions) recomputeEarly(cache responseCache) bool {
+	return o.earlyBeta > 0 && persistence.ShouldRecompute(cache.Delta, cache.Expires, o.earlyBeta)
+}
+
+// expiresAt returns the time a page stored at now for expire expires, zero
+// if the store's default expiration applies or if it does not expire
+func expiresAt(now time.Time, expire time.Duration) time.Time {
+	if expire <= 0 {
+		return time.Time{}
+	}
+	return now.Add(expire)
+}